@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/wire"
@@ -26,6 +28,7 @@ import (
 	"github.com/lightningnetwork/lnd/channeldb/migration29"
 	"github.com/lightningnetwork/lnd/channeldb/migration30"
 	"github.com/lightningnetwork/lnd/channeldb/migration31"
+	"github.com/lightningnetwork/lnd/channeldb/migration32"
 	"github.com/lightningnetwork/lnd/channeldb/migration_01_to_11"
 	"github.com/lightningnetwork/lnd/clock"
 	"github.com/lightningnetwork/lnd/invoices"
@@ -286,6 +289,12 @@ var (
 			number:    31,
 			migration: migration31.DeleteLastPublishedTxTLB,
 		},
+		{
+			// Prefix the creation info of all payments with the
+			// version byte of its layout.
+			number:    32,
+			migration: migration32.MigratePaymentCreationInfoVersion,
+		},
 	}
 
 	// optionalVersions stores all optional migrations that are applied
@@ -329,7 +338,29 @@ type DB struct {
 	clock                     clock.Clock
 	dryRun                    bool
 	keepFailedPaymentAttempts bool
+	maxInFlightAttempts       uint32
+	archiveDeletedPayments    bool
+	strictPaymentDecoding     bool
+	reuseSequenceOnRetry      bool
+	storeAttemptRoutes        bool
+	skipPaymentRequests       bool
 	storeFinalHtlcResolutions bool
+	paymentsDeleteBatchSize   int
+	maxQueryPayments          uint64
+	paymentsQueryTimeout      time.Duration
+
+	// paymentCache caches the payments that are still being sent. It's
+	// nil if the cache is disabled.
+	paymentCache *paymentCache
+
+	// loggedQuarantines holds the quarantined htlc attempts that were
+	// already logged, so each corrupt attempt is only logged once rather
+	// than on every read of its payment.
+	loggedQuarantines *sync.Map
+
+	// paymentsStoreConfig holds the resolved options of the payments
+	// store, collected when the database was created.
+	paymentsStoreConfig *PaymentsStoreConfig
 
 	// noRevLogAmtData if true, means that commitment transaction amount
 	// data should not be stored in the revocation log.
@@ -391,10 +422,24 @@ func CreateWithBackend(backend kvdb.Backend,
 		clock:                     opts.clock,
 		dryRun:                    opts.dryRun,
 		keepFailedPaymentAttempts: opts.keepFailedPaymentAttempts,
+		maxInFlightAttempts:       opts.maxInFlightAttempts,
+		archiveDeletedPayments:    opts.archiveDeletedPayments,
+		strictPaymentDecoding:     opts.strictPaymentDecoding,
+		loggedQuarantines:         &sync.Map{},
+		reuseSequenceOnRetry:      opts.reuseSequenceOnRetry,
+		storeAttemptRoutes:        opts.storeAttemptRoutes,
+		skipPaymentRequests:       opts.skipPaymentRequests,
 		storeFinalHtlcResolutions: opts.storeFinalHtlcResolutions,
+		paymentsDeleteBatchSize:   opts.paymentsDeleteBatchSize,
+		maxQueryPayments:          opts.maxQueryPayments,
+		paymentsQueryTimeout:      opts.paymentsQueryTimeout,
 		noRevLogAmtData:           opts.NoRevLogAmtData,
 	}
 
+	if opts.paymentCacheSize > 0 {
+		chanDB.paymentCache = newPaymentCache(opts.paymentCacheSize)
+	}
+
 	// Set the parent pointer (only used in tests).
 	chanDB.channelStateDB.parent = chanDB
 
@@ -423,6 +468,31 @@ func CreateWithBackend(backend kvdb.Backend,
 		}
 	}
 
+	// Make sure payments are written to the backend that holds their
+	// history.
+	err = chanDB.checkPaymentsBackend(
+		opts.paymentsBackend, opts.forcePaymentsBackend,
+	)
+	if err != nil {
+		backend.Close()
+		return nil, err
+	}
+
+	// Now that any migrations were applied, we can collect the config of
+	// the payments store. A database that wasn't initialized yet has no
+	// schema version.
+	var dbVersion uint32
+	meta, err := chanDB.FetchMeta()
+	switch {
+	case err == nil:
+		dbVersion = meta.DbVersionNumber
+
+	case !errors.Is(err, ErrMetaNotFound):
+		backend.Close()
+		return nil, err
+	}
+	chanDB.paymentsStoreConfig = newPaymentsStoreConfig(&opts, dbVersion)
+
 	return chanDB, nil
 }
 
@@ -440,6 +510,10 @@ var dbTopLevelBuckets = [][]byte{
 	payAddrIndexBucket,
 	setIDIndexBucket,
 	paymentsIndexBucket,
+	paymentsInFlightIndexBucket,
+	paymentsReferenceIndexBucket,
+	paymentsCountBucket,
+	paymentsArchiveBucket,
 	peersBucket,
 	nodeInfoBucket,
 	metaBucket,
@@ -1836,7 +1910,7 @@ func MakeTestInvoiceDB(t *testing.T, modifiers ...OptionModifier) (
 // MakeTestDB creates a new instance of the ChannelDB for testing purposes.
 // A callback which cleans up the created temporary directories is also
 // returned and intended to be executed after the test completes.
-func MakeTestDB(t *testing.T, modifiers ...OptionModifier) (*DB, error) {
+func MakeTestDB(t testing.TB, modifiers ...OptionModifier) (*DB, error) {
 	// First, create a temporary directory to be used for the duration of
 	// this test.
 	tempDirName := t.TempDir()
@@ -0,0 +1,85 @@
+package migration32
+
+import (
+	"fmt"
+
+	"github.com/lightningnetwork/lnd/kvdb"
+)
+
+// MigratePaymentCreationInfoVersion prefixes the creation info of every stored
+// payment, including the archived ones, with the version byte of the first
+// versioned layout. The unversioned layout starts with the payment hash, so it
+// can't be told apart from a versioned one when it's read. After this
+// migration, every creation info starts with its version.
+func MigratePaymentCreationInfoVersion(tx kvdb.RwTx) error {
+	log.Infof("Migrating payment creation info to the versioned layout...")
+
+	var total int
+	for _, rootKey := range [][]byte{
+		paymentsRootBucket, paymentsArchiveBucket,
+	} {
+		n, err := migrateCreationInfo(tx, rootKey)
+		if err != nil {
+			return err
+		}
+
+		total += n
+	}
+
+	log.Infof("Migrated creation info of %d payments", total)
+
+	return nil
+}
+
+// migrateCreationInfo adds the version byte to the creation info of every
+// payment in the given top-level bucket, returning the number of migrated
+// payments.
+func migrateCreationInfo(tx kvdb.RwTx, rootKey []byte) (int, error) {
+	payments := tx.ReadWriteBucket(rootKey)
+	if payments == nil {
+		return 0, nil
+	}
+
+	// Collect all payment keys first so we don't modify the buckets while
+	// iterating over them.
+	var keys [][]byte
+	err := payments.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return fmt.Errorf("non bucket element %x in %s", k,
+				rootKey)
+		}
+
+		keys = append(keys, append([]byte(nil), k...))
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var migrated int
+	for _, k := range keys {
+		payment := payments.NestedReadWriteBucket(k)
+
+		info := payment.Get(paymentCreationInfoKey)
+		if info == nil {
+			log.Warnf("Payment %x in %s has no creation info", k,
+				rootKey)
+
+			continue
+		}
+
+		versioned := make([]byte, 0, len(info)+1)
+		versioned = append(versioned, paymentCreationInfoV1)
+		versioned = append(versioned, info...)
+
+		err := payment.Put(paymentCreationInfoKey, versioned)
+		if err != nil {
+			return 0, err
+		}
+
+		migrated++
+	}
+
+	return migrated, nil
+}
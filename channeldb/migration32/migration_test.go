@@ -0,0 +1,114 @@
+package migration32
+
+import (
+	"testing"
+
+	"github.com/lightningnetwork/lnd/channeldb/migtest"
+	"github.com/lightningnetwork/lnd/kvdb"
+)
+
+var (
+	hexStr = migtest.Hex
+
+	hash1 = hexStr("0102030405060708090a0b0c0d0e0f10111213141516171819" +
+		"1a1b1c1d1e1f20")
+	hash2 = hexStr("2122232425262728292a2b2c2d2e2f30313233343536373839" +
+		"3a3b3c3d3e3f40")
+
+	// creationInfo1 is creation info in the unversioned layout, with a
+	// payment request and a fee limit.
+	creationInfo1 = "0102030405060708090a0b0c0d0e0f101112131415161718" +
+		"191a1b1c1d1e1f2000000000000003e817979cfe362a0000000000046c6e" +
+		"626300000000000001f4"
+
+	// creationInfo2 is creation info in the unversioned layout without a
+	// payment request and without a fee limit, as written before the fee
+	// limit was added.
+	creationInfo2 = "2122232425262728292a2b2c2d2e2f303132333435363738" +
+		"393a3b3c3d3e3f4000000000000007d017979cfe362a000000000000"
+
+	paymentsBefore = map[string]interface{}{
+		hash1: map[string]interface{}{
+			"payment-creation-info": hexStr(creationInfo1),
+			"payment-sequence-key":  hexStr("0000000000000001"),
+			"payment-htlcs-bucket": map[string]interface{}{
+				"ai0000000000000000": hexStr("aaaa"),
+			},
+		},
+		hash2: map[string]interface{}{
+			"payment-creation-info": hexStr(creationInfo2),
+			"payment-sequence-key":  hexStr("0000000000000002"),
+		},
+	}
+
+	paymentsAfter = map[string]interface{}{
+		hash1: map[string]interface{}{
+			"payment-creation-info": hexStr("01" + creationInfo1),
+			"payment-sequence-key":  hexStr("0000000000000001"),
+			"payment-htlcs-bucket": map[string]interface{}{
+				"ai0000000000000000": hexStr("aaaa"),
+			},
+		},
+		hash2: map[string]interface{}{
+			"payment-creation-info": hexStr("01" + creationInfo2),
+			"payment-sequence-key":  hexStr("0000000000000002"),
+		},
+	}
+
+	archiveBefore = map[string]interface{}{
+		hexStr("0000000000000003"): map[string]interface{}{
+			"payment-creation-info": hexStr(creationInfo2),
+			"payment-deleted-at":    hexStr("17979cfe362a0000"),
+		},
+	}
+
+	archiveAfter = map[string]interface{}{
+		hexStr("0000000000000003"): map[string]interface{}{
+			"payment-creation-info": hexStr("01" + creationInfo2),
+			"payment-deleted-at":    hexStr("17979cfe362a0000"),
+		},
+	}
+)
+
+// TestMigratePaymentCreationInfoVersion asserts that the creation info of
+// stored and archived payments is prefixed with the version byte, while the
+// rest of the payments is left untouched.
+func TestMigratePaymentCreationInfoVersion(t *testing.T) {
+	t.Parallel()
+
+	before := func(tx kvdb.RwTx) error {
+		err := migtest.RestoreDB(tx, paymentsRootBucket, paymentsBefore)
+		if err != nil {
+			return err
+		}
+
+		return migtest.RestoreDB(
+			tx, paymentsArchiveBucket, archiveBefore,
+		)
+	}
+
+	after := func(tx kvdb.RwTx) error {
+		err := migtest.VerifyDB(tx, paymentsRootBucket, paymentsAfter)
+		if err != nil {
+			return err
+		}
+
+		return migtest.VerifyDB(tx, paymentsArchiveBucket, archiveAfter)
+	}
+
+	migtest.ApplyMigration(
+		t, before, after, MigratePaymentCreationInfoVersion, false,
+	)
+}
+
+// TestMigratePaymentCreationInfoVersionNoPayments asserts that the migration
+// succeeds on a database without any payments.
+func TestMigratePaymentCreationInfoVersionNoPayments(t *testing.T) {
+	t.Parallel()
+
+	migtest.ApplyMigration(
+		t, func(kvdb.RwTx) error { return nil },
+		func(kvdb.RwTx) error { return nil },
+		MigratePaymentCreationInfoVersion, false,
+	)
+}
@@ -0,0 +1,21 @@
+package migration32
+
+var (
+	// paymentsRootBucket is the name of the top-level bucket within the
+	// database that stores all data related to payments.
+	paymentsRootBucket = []byte("payments-root-bucket")
+
+	// paymentsArchiveBucket is the name of the top-level bucket that holds
+	// the payments that were moved to the archive when they were deleted.
+	// The archived payments have the same layout as the ones in the
+	// payments root bucket.
+	paymentsArchiveBucket = []byte("payments-archive-bucket")
+
+	// paymentCreationInfoKey is the key used in a payment's sub-bucket to
+	// store the creation info of the payment.
+	paymentCreationInfoKey = []byte("payment-creation-info")
+)
+
+// paymentCreationInfoV1 is the version of the creation info layout that
+// prefixes the original, unversioned layout with a version byte.
+const paymentCreationInfoV1 = 1
@@ -2,7 +2,6 @@ package channeldb
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
 	"io"
 	"math"
@@ -11,6 +10,7 @@ import (
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
 	"github.com/lightningnetwork/lnd/lnwire"
 	"github.com/lightningnetwork/lnd/routing/route"
 )
@@ -45,6 +45,13 @@ type HTLCAttemptInfo struct {
 	// in which the payment's PaymentHash in the PaymentCreationInfo should
 	// be used.
 	Hash *lntypes.Hash
+
+	// ChainFeeRate is the node's on-chain fee estimate at the time this
+	// HTLC was attempted. It is zero if no estimate was available.
+	//
+	// NOTE: The fee rate is only persisted for attempts that have their
+	// Hash set.
+	ChainFeeRate chainfee.SatPerKWeight
 }
 
 // NewHtlcAttempt creates a htlc attempt.
@@ -96,6 +103,65 @@ type HTLCAttempt struct {
 	//
 	// NOTE: Can be nil if payment is not failed.
 	Failure *HTLCFailInfo
+
+	// Corrupt is set if some of the attempt's stored data couldn't be
+	// parsed. Such an attempt is quarantined: the parts that couldn't be
+	// parsed are left empty, while the attempt still counts as settled or
+	// failed if its settle or fail info is stored.
+	//
+	// NOTE: Is nil for attempts that were parsed successfully.
+	Corrupt *HTLCCorruption
+}
+
+// FailureSourceNode returns the node that generated the failure of the
+// attempt by resolving its failure source index against the attempt's route.
+// Index zero is the sender of the payment.
+func (h *HTLCAttempt) FailureSourceNode() (route.Vertex, error) {
+	if h.Failure == nil {
+		return route.Vertex{}, ErrAttemptNotFailed
+	}
+
+	return failureSourceNode(&h.Route, h.Failure.FailureSourceIndex)
+}
+
+// failureSourceNode returns the node at the given failure source index of the
+// route, where index zero is the sender and index i is the i-th hop.
+func failureSourceNode(rt *route.Route, idx uint32) (route.Vertex, error) {
+	if idx == 0 {
+		return rt.SourcePubKey, nil
+	}
+
+	if int(idx) > len(rt.Hops) {
+		return route.Vertex{}, fmt.Errorf("%w: index %d for route "+
+			"with %d hops", ErrInvalidFailureSourceIndex, idx,
+			len(rt.Hops))
+	}
+
+	return rt.Hops[idx-1].PubKeyBytes, nil
+}
+
+// HTLCCorruption describes the stored data of an htlc attempt that couldn't be
+// parsed.
+type HTLCCorruption struct {
+	// Err is the first error encountered while parsing the attempt.
+	Err error
+
+	// Records maps the bucket keys of the attempt's records that couldn't
+	// be parsed to their raw values, allowing them to be repaired
+	// manually.
+	Records map[string][]byte
+}
+
+// addRecord adds the raw record that failed to parse with the given error.
+func (c *HTLCCorruption) addRecord(key, value []byte, err error) {
+	if c.Err == nil {
+		c.Err = err
+	}
+
+	if c.Records == nil {
+		c.Records = make(map[string][]byte)
+	}
+	c.Records[string(key)] = copyBytes(value)
 }
 
 // HTLCSettleInfo encapsulates the information that augments an HTLCAttempt in
@@ -128,8 +194,37 @@ const (
 	// HTLCFailMessage is recorded for htlcs that failed with a network
 	// failure message.
 	HTLCFailMessage HTLCFailReason = 3
+
+	// HTLCFailAbandoned is recorded for htlcs whose result we gave up
+	// waiting for, for example because the circuit of the htlc was lost.
+	// Such htlcs count as failed, but didn't necessarily fail in the
+	// network.
+	HTLCFailAbandoned HTLCFailReason = 4
 )
 
+// String returns a human-readable name of the failure reason.
+func (r HTLCFailReason) String() string {
+	switch r {
+	case HTLCFailUnknown:
+		return "Unknown"
+
+	case HTLCFailUnreadable:
+		return "Unreadable"
+
+	case HTLCFailInternal:
+		return "Internal"
+
+	case HTLCFailMessage:
+		return "Message"
+
+	case HTLCFailAbandoned:
+		return "Abandoned"
+
+	default:
+		return fmt.Sprintf("HTLCFailReason(%d)", byte(r))
+	}
+}
+
 // HTLCFailInfo encapsulates the information that augments an HTLCAttempt in the
 // event that the HTLC fails.
 type HTLCFailInfo struct {
@@ -150,6 +245,41 @@ type HTLCFailInfo struct {
 	FailureSourceIndex uint32
 }
 
+// HTLCFailureSummary is a compact summary of the failure of an htlc attempt
+// that doesn't depend on the wire types of the failure message, which makes
+// it suitable for consumers that only need to classify failures.
+type HTLCFailureSummary struct {
+	// AttemptID is the ID of the failed attempt.
+	AttemptID uint64
+
+	// Reason is the failure reason of the attempt.
+	Reason HTLCFailReason
+
+	// Code is the name of the code of the failure message, or the name of
+	// the failure reason if the attempt didn't fail with a message.
+	Code string
+
+	// SourceIndex is the position in the route of the node that generated
+	// the failure, where position zero is the sender. It's only set if the
+	// source of the failure is known.
+	SourceIndex uint32
+
+	// FinalNode is true if the failure was generated by the final node of
+	// the route.
+	FinalNode bool
+}
+
+// String returns the summary in the compact form
+// "<code>@<source index>[/final]".
+func (s HTLCFailureSummary) String() string {
+	summary := fmt.Sprintf("%v@%d", s.Code, s.SourceIndex)
+	if s.FinalNode {
+		summary += "/final"
+	}
+
+	return summary
+}
+
 // MPPaymentState wraps a series of info needed for a given payment, which is
 // used by both MPP and AMP. This is a memory representation of the payment's
 // current state and is updated whenever the payment is read from disk.
@@ -200,6 +330,19 @@ type MPPayment struct {
 	// altogether.
 	FailureReason *FailureReason
 
+	// RawFailureReason is the failure reason byte as it is stored in the
+	// database. It is only set if the stored reason isn't known, in which
+	// case FailureReason is FailureReasonLegacyUnknown.
+	RawFailureReason *byte
+
+	// FailureDetail holds optional diagnostics about the failure of the
+	// payment. It is only set if the payment was failed with them.
+	FailureDetail *PaymentFailureDetail
+
+	// Timings holds the timings of the payment's first attempt. It is only
+	// set for payments that were sent to completion by the router.
+	Timings *PaymentTimings
+
 	// Status is the current PaymentStatus of this payment.
 	Status PaymentStatus
 
@@ -247,6 +390,57 @@ func (m *MPPayment) SentAmt() (lnwire.MilliSatoshi, lnwire.MilliSatoshi) {
 	return sent, fees
 }
 
+// SettledAmt returns the sum of sent amount and fees for HTLCs that are
+// settled. Unlike SentAmt, HTLCs that are still in flight are not included.
+func (m *MPPayment) SettledAmt() (lnwire.MilliSatoshi, lnwire.MilliSatoshi) {
+	var settled, fees lnwire.MilliSatoshi
+	for _, h := range m.HTLCs {
+		if h.Settle == nil {
+			continue
+		}
+
+		settled += h.Route.ReceiverAmt()
+		fees += h.Route.TotalFees()
+	}
+
+	return settled, fees
+}
+
+// Progress returns the fraction of the payment amount that was delivered to
+// the receiver or is still on its way, as a value between 0 and 1. It is based
+// on the settled and in-flight HTLCs, so it doesn't drop while a shard is
+// still pending. Fees are not taken into account.
+func (m *MPPayment) Progress() float64 {
+	if m.Info == nil || m.Info.Value == 0 {
+		return 0
+	}
+
+	sent, _ := m.SentAmt()
+	if sent >= m.Info.Value {
+		return 1
+	}
+
+	return float64(sent) / float64(m.Info.Value)
+}
+
+// IsBlinded returns true if any of the htlc attempts of the payment was sent to
+// a blinded path, which is the case if the final hop of its route carries
+// encrypted data.
+func (m *MPPayment) IsBlinded() bool {
+	for _, h := range m.HTLCs {
+		hops := h.Route.Hops
+		if len(hops) == 0 {
+			continue
+		}
+
+		if len(hops[len(hops)-1].EncryptedData) != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
 // InFlightHTLCs returns the HTLCs that are still in-flight, meaning they have
 // not been settled or failed.
 func (m *MPPayment) InFlightHTLCs() []HTLCAttempt {
@@ -262,6 +456,82 @@ func (m *MPPayment) InFlightHTLCs() []HTLCAttempt {
 	return inflights
 }
 
+// FailureSummary returns a summary of the failure of every failed htlc
+// attempt of the payment, in the order of the attempts.
+func (m *MPPayment) FailureSummary() []HTLCFailureSummary {
+	var summaries []HTLCFailureSummary
+	for _, h := range m.HTLCs {
+		if h.Failure == nil {
+			continue
+		}
+
+		summary := HTLCFailureSummary{
+			AttemptID: h.AttemptID,
+			Reason:    h.Failure.Reason,
+			Code:      h.Failure.Reason.String(),
+		}
+
+		// The source of the failure is only known for failures that
+		// were reported by a node on the route.
+		switch h.Failure.Reason {
+		case HTLCFailMessage, HTLCFailUnknown:
+			idx := h.Failure.FailureSourceIndex
+			summary.SourceIndex = idx
+			summary.FinalNode = idx > 0 &&
+				int(idx) == len(h.Route.Hops)
+		}
+
+		if h.Failure.Message != nil {
+			summary.Code = h.Failure.Message.Code().String()
+		}
+
+		summaries = append(summaries, summary)
+	}
+
+	return summaries
+}
+
+// AMPSubPaymentStatuses returns the status of every AMP sub-payment of the
+// payment, keyed by the set ID the attempts of the sub-payment share. The
+// status of each sub-payment is decided from its attempts the same way the
+// status of the whole payment is, so a sub-payment whose attempts all failed
+// is only failed once the payment itself failed.
+//
+// The set ID is read from the AMP record of an attempt's final hop. Attempts
+// without an AMP record, such as the shards of a regular MPP payment, don't
+// belong to any sub-payment and are excluded. As the hops aren't available
+// for payments that were read with only a summary of their routes, no
+// sub-payments are returned for them.
+func (m *MPPayment) AMPSubPaymentStatuses() map[[32]byte]PaymentStatus {
+	sets := make(map[[32]byte][]HTLCAttempt)
+	for _, h := range m.HTLCs {
+		finalHop := h.Route.FinalHop()
+		if finalHop == nil || finalHop.AMP == nil {
+			continue
+		}
+
+		setID := finalHop.AMP.SetID()
+		sets[setID] = append(sets[setID], h)
+	}
+
+	statuses := make(map[[32]byte]PaymentStatus, len(sets))
+	for setID, htlcs := range sets {
+		// Every set has at least one attempt, so its status can
+		// always be decided.
+		status, err := decidePaymentStatus(htlcs, m.FailureReason)
+		if err != nil {
+			log.Errorf("Unable to decide status of AMP set %x: %v",
+				setID, err)
+
+			continue
+		}
+
+		statuses[setID] = status
+	}
+
+	return statuses
+}
+
 // GetAttempt returns the specified htlc attempt on the payment.
 func (m *MPPayment) GetAttempt(id uint64) (*HTLCAttempt, error) {
 	// TODO(yy): iteration can be slow, make it into a tree or use BS.
@@ -272,7 +542,31 @@ func (m *MPPayment) GetAttempt(id uint64) (*HTLCAttempt, error) {
 		}
 	}
 
-	return nil, errors.New("htlc attempt not found on payment")
+	return nil, ErrAttemptNotFound
+}
+
+// GetAttemptByHash returns the htlc attempt on the payment that uses the given
+// hash. For AMP payments each attempt has its own hash, while attempts of other
+// payments share the same hash, in which case the first matching attempt is
+// returned. Older attempts without a hash are matched against the payment
+// identifier.
+func (m *MPPayment) GetAttemptByHash(hash lntypes.Hash) (*HTLCAttempt,
+	error) {
+
+	for _, htlc := range m.HTLCs {
+		htlc := htlc
+
+		attemptHash := m.Info.PaymentIdentifier
+		if htlc.Hash != nil {
+			attemptHash = *htlc.Hash
+		}
+
+		if attemptHash == hash {
+			return &htlc, nil
+		}
+	}
+
+	return nil, ErrAttemptNotFound
 }
 
 // Registrable returns an error to specify whether adding more HTLCs to the
@@ -331,10 +625,22 @@ func (m *MPPayment) setState() error {
 		return err
 	}
 
+	// The amount of a quarantined attempt that wasn't failed is unknown,
+	// so it's assumed to use up the rest of the payment amount. This
+	// prevents sending more than the payment amount if its route couldn't
+	// be read.
+	remainingAmt := totalAmt - sentAmt
+	for _, h := range m.HTLCs {
+		if h.Corrupt != nil && h.Failure == nil {
+			remainingAmt = 0
+			break
+		}
+	}
+
 	// Update the payment state and status.
 	m.State = &MPPaymentState{
 		NumAttemptsInFlight: len(m.InFlightHTLCs()),
-		RemainingAmt:        totalAmt - sentAmt,
+		RemainingAmt:        remainingAmt,
 		FeesPaid:            fees,
 		HasSettledHTLC:      settle != nil,
 		PaymentFailed:       failure != nil,
@@ -434,12 +740,21 @@ func (m *MPPayment) NeedWaitAttempts() (bool, error) {
 	case StatusSucceeded:
 		return false, nil
 
-	// If the payment is already failed, yet the remaining amount is zero,
-	// return an error as this indicates an error state. We will only each
-	// this status when there are no inflight HTLCs and the payment is
-	// marked as failed with a reason, which means the remainingAmt must
-	// not be zero because our sentAmt is zero.
+	// We will only reach this status when there are no inflight HTLCs and
+	// the payment is marked as failed with a reason, which means our
+	// sentAmt is zero and the remainingAmt equals the payment's value.
 	case StatusFailed:
+		// A payment that failed before any attempt was made, such as
+		// when path finding fails right away, has nothing to wait for.
+		// Its remainingAmt can only be zero here if the payment's value
+		// is zero.
+		if len(m.HTLCs) == 0 {
+			return false, nil
+		}
+
+		// Otherwise, the payment is already failed, yet the remaining
+		// amount is zero, return an error as this indicates an error
+		// state.
 		return false, fmt.Errorf("%w: %v", ErrPaymentInternal, m.Status)
 
 	// Unknown payment status.
@@ -554,6 +869,14 @@ func serializeHTLCFailInfo(w io.Writer, f *HTLCFailInfo) error {
 			return err
 		}
 	}
+
+	// Reject failure messages that couldn't be read back, matching the
+	// size limit of the wire.
+	if messageBytes.Len() > math.MaxUint16 {
+		return fmt.Errorf("%w: %d bytes", ErrFailureMessageTooLarge,
+			messageBytes.Len())
+	}
+
 	if err := wire.WriteVarBytes(w, 0, messageBytes.Bytes()); err != nil {
 		return err
 	}
@@ -562,7 +885,9 @@ func serializeHTLCFailInfo(w io.Writer, f *HTLCFailInfo) error {
 }
 
 // deserializeHTLCFailInfo deserializes the details of a failed htlc including
-// the wire failure.
+// the wire failure. If only the failure message can't be decoded, the rest of
+// the failure details is returned with the HTLCFailUnreadable reason, along
+// with an error wrapping errUnreadableFailureMessage.
 func deserializeHTLCFailInfo(r io.Reader) (*HTLCFailInfo, error) {
 	f := &HTLCFailInfo{}
 	var err error
@@ -578,13 +903,11 @@ func deserializeHTLCFailInfo(r io.Reader) (*HTLCFailInfo, error) {
 	if err != nil {
 		return nil, err
 	}
+	var msgErr error
 	if len(failureBytes) > 0 {
-		f.Message, err = lnwire.DecodeFailureMessage(
+		f.Message, msgErr = lnwire.DecodeFailureMessage(
 			bytes.NewReader(failureBytes), 0,
 		)
-		if err != nil {
-			return nil, err
-		}
 	}
 
 	var reason byte
@@ -594,6 +917,14 @@ func deserializeHTLCFailInfo(r io.Reader) (*HTLCFailInfo, error) {
 	}
 	f.Reason = HTLCFailReason(reason)
 
+	if msgErr != nil {
+		f.Message = nil
+		f.Reason = HTLCFailUnreadable
+
+		return f, fmt.Errorf("%w: %v", errUnreadableFailureMessage,
+			msgErr)
+	}
+
 	return f, nil
 }
 
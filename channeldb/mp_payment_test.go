@@ -239,6 +239,7 @@ func TestNeedWaitAttempts(t *testing.T) {
 		remainingAmt     lnwire.MilliSatoshi
 		hasSettledHTLC   bool
 		hasFailureReason bool
+		hasHTLCs         bool
 		needWait         bool
 		expectedErr      error
 	}{
@@ -316,12 +317,22 @@ func TestNeedWaitAttempts(t *testing.T) {
 			expectedErr:  nil,
 		},
 		{
-			// Payment is terminated, no need to wait for results.
+			// A failed payment with zero remainingAmt but with
+			// attempts made indicates an error.
 			status:       StatusFailed,
 			remainingAmt: 0,
+			hasHTLCs:     true,
 			needWait:     false,
 			expectedErr:  ErrPaymentInternal,
 		},
+		{
+			// A payment that failed before any attempt was made
+			// doesn't need to wait for results.
+			status:       StatusFailed,
+			remainingAmt: 0,
+			needWait:     false,
+			expectedErr:  nil,
+		},
 		{
 			// Payment is in an unknown status, return an error.
 			status:       0,
@@ -352,10 +363,14 @@ func TestNeedWaitAttempts(t *testing.T) {
 				PaymentFailed:  tc.hasFailureReason,
 			},
 		}
+		if tc.hasHTLCs {
+			p.HTLCs = []HTLCAttempt{{}}
+		}
 
 		name := fmt.Sprintf("status=%s|remainingAmt=%v|"+
-			"settledHTLC=%v|failureReason=%v", tc.status,
-			tc.remainingAmt, tc.hasSettledHTLC, tc.hasFailureReason)
+			"settledHTLC=%v|failureReason=%v|htlcs=%v", tc.status,
+			tc.remainingAmt, tc.hasSettledHTLC, tc.hasFailureReason,
+			tc.hasHTLCs)
 
 		t.Run(name, func(t *testing.T) {
 			t.Parallel()
@@ -545,6 +560,145 @@ func TestAllowMoreAttempts(t *testing.T) {
 	}
 }
 
+// TestGetAttemptByHash checks that htlc attempts can be looked up by their
+// hash, both for AMP payments with a hash per attempt and for payments whose
+// attempts don't store a hash.
+func TestGetAttemptByHash(t *testing.T) {
+	t.Parallel()
+
+	var (
+		paymentID = lntypes.Hash{1}
+		shard1    = lntypes.Hash{2}
+		shard2    = lntypes.Hash{3}
+	)
+
+	makeAttempt := func(id uint64, hash *lntypes.Hash) HTLCAttempt {
+		a := makeActiveAttempt(100, 0)
+		a.AttemptID = id
+		a.Hash = hash
+
+		return a
+	}
+
+	// An AMP payment has a distinct hash for each of its attempts, none of
+	// which equals the payment identifier.
+	amp := &MPPayment{
+		Info: &PaymentCreationInfo{PaymentIdentifier: paymentID},
+		HTLCs: []HTLCAttempt{
+			makeAttempt(1, &shard1),
+			makeAttempt(2, &shard2),
+		},
+	}
+
+	htlc, err := amp.GetAttemptByHash(shard2)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, htlc.AttemptID)
+
+	htlc, err = amp.GetAttemptByHash(shard1)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, htlc.AttemptID)
+
+	_, err = amp.GetAttemptByHash(paymentID)
+	require.ErrorIs(t, err, ErrAttemptNotFound)
+
+	// Legacy attempts don't store a hash, so they are matched against the
+	// payment identifier.
+	legacy := &MPPayment{
+		Info: &PaymentCreationInfo{PaymentIdentifier: paymentID},
+		HTLCs: []HTLCAttempt{
+			makeAttempt(3, nil),
+		},
+	}
+
+	htlc, err = legacy.GetAttemptByHash(paymentID)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, htlc.AttemptID)
+
+	_, err = legacy.GetAttemptByHash(shard1)
+	require.ErrorIs(t, err, ErrAttemptNotFound)
+}
+
+// TestPaymentProgress checks the settled amount and the progress of payments
+// with settled, in-flight and failed htlcs.
+func TestPaymentProgress(t *testing.T) {
+	t.Parallel()
+
+	preimage := lntypes.Preimage{1}
+
+	testCases := []struct {
+		name            string
+		value           lnwire.MilliSatoshi
+		htlcs           []HTLCAttempt
+		expectedSent    lnwire.MilliSatoshi
+		expectedSettled lnwire.MilliSatoshi
+		expectedFees    lnwire.MilliSatoshi
+		expectedProg    float64
+	}{
+		{
+			name:  "no htlcs",
+			value: 1000,
+		},
+		{
+			name:  "single shard in flight",
+			value: 1000,
+			htlcs: []HTLCAttempt{
+				makeActiveAttempt(1010, 10),
+			},
+			expectedSent: 1000,
+			expectedProg: 1,
+		},
+		{
+			// A failed shard doesn't count, while a pending one
+			// keeps the progress from dropping.
+			name:  "half delivered mpp",
+			value: 1000,
+			htlcs: []HTLCAttempt{
+				makeSettledAttempt(255, 5, preimage),
+				makeActiveAttempt(252, 2),
+				makeFailedAttempt(500, 0),
+			},
+			expectedSent:    500,
+			expectedSettled: 250,
+			expectedFees:    5,
+			expectedProg:    0.5,
+		},
+		{
+			name:  "fully settled mpp",
+			value: 1000,
+			htlcs: []HTLCAttempt{
+				makeSettledAttempt(605, 5, preimage),
+				makeFailedAttempt(400, 0),
+				makeSettledAttempt(403, 3, preimage),
+			},
+			expectedSent:    1000,
+			expectedSettled: 1000,
+			expectedFees:    8,
+			expectedProg:    1,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := &MPPayment{
+				Info:  &PaymentCreationInfo{Value: tc.value},
+				HTLCs: tc.htlcs,
+			}
+
+			sent, _ := p.SentAmt()
+			require.Equal(t, tc.expectedSent, sent)
+
+			settled, fees := p.SettledAmt()
+			require.Equal(t, tc.expectedSettled, settled)
+			require.Equal(t, tc.expectedFees, fees)
+
+			require.InDelta(t, tc.expectedProg, p.Progress(), 1e-9)
+		})
+	}
+}
+
 func makeActiveAttempt(total, fee int) HTLCAttempt {
 	return HTLCAttempt{
 		HTLCAttemptInfo: makeAttemptInfo(total, total-fee),
@@ -578,3 +732,234 @@ func makeAttemptInfo(total, amtForwarded int) HTLCAttemptInfo {
 		},
 	}
 }
+
+// TestFailureSourceNode checks that the failure source index of an attempt is
+// resolved against its route, with index zero being the sender.
+func TestFailureSourceNode(t *testing.T) {
+	t.Parallel()
+
+	var (
+		self  = route.Vertex{1}
+		hop1  = route.Vertex{2}
+		final = route.Vertex{3}
+	)
+	rt := route.Route{
+		SourcePubKey: self,
+		Hops: []*route.Hop{
+			{PubKeyBytes: hop1},
+			{PubKeyBytes: final},
+		},
+	}
+
+	testCases := []struct {
+		name           string
+		failure        *HTLCFailInfo
+		expectedSource route.Vertex
+		expectedErr    error
+	}{
+		{
+			name:        "not failed",
+			expectedErr: ErrAttemptNotFailed,
+		},
+		{
+			name:           "sender",
+			failure:        &HTLCFailInfo{FailureSourceIndex: 0},
+			expectedSource: self,
+		},
+		{
+			name:           "intermediate hop",
+			failure:        &HTLCFailInfo{FailureSourceIndex: 1},
+			expectedSource: hop1,
+		},
+		{
+			name:           "final hop",
+			failure:        &HTLCFailInfo{FailureSourceIndex: 2},
+			expectedSource: final,
+		},
+		{
+			name:        "out of range",
+			failure:     &HTLCFailInfo{FailureSourceIndex: 3},
+			expectedErr: ErrInvalidFailureSourceIndex,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			a := &HTLCAttempt{
+				HTLCAttemptInfo: HTLCAttemptInfo{Route: rt},
+				Failure:         tc.failure,
+			}
+
+			source, err := a.FailureSourceNode()
+			require.ErrorIs(t, err, tc.expectedErr)
+			require.Equal(t, tc.expectedSource, source)
+		})
+	}
+}
+
+// TestPaymentIsBlinded tests that a payment is reported as blinded if the
+// final hop of any of its attempts carries encrypted data.
+func TestPaymentIsBlinded(t *testing.T) {
+	t.Parallel()
+
+	makeAttempt := func(encryptedData []byte) HTLCAttempt {
+		finalHop := &route.Hop{
+			PubKeyBytes:   route.Vertex{2},
+			EncryptedData: encryptedData,
+		}
+		rt := route.Route{
+			Hops: []*route.Hop{
+				{PubKeyBytes: route.Vertex{1}},
+				finalHop,
+			},
+		}
+
+		return HTLCAttempt{
+			HTLCAttemptInfo: HTLCAttemptInfo{Route: rt},
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		htlcs    []HTLCAttempt
+		expected bool
+	}{
+		{
+			name: "no attempts",
+		},
+		{
+			name:  "no hops",
+			htlcs: []HTLCAttempt{{}},
+		},
+		{
+			name:  "not blinded",
+			htlcs: []HTLCAttempt{makeAttempt(nil)},
+		},
+		{
+			name: "blinded attempt",
+			htlcs: []HTLCAttempt{
+				makeAttempt(nil), makeAttempt([]byte{1}),
+			},
+			expected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			payment := &MPPayment{HTLCs: tc.htlcs}
+			require.Equal(t, tc.expected, payment.IsBlinded())
+		})
+	}
+}
+
+// TestFailureSummary tests that the failures of the htlc attempts of a payment
+// are summarized by their failure code, source index and whether the final
+// node failed them.
+func TestFailureSummary(t *testing.T) {
+	t.Parallel()
+
+	rt := route.Route{
+		Hops: []*route.Hop{
+			{PubKeyBytes: route.Vertex{1}},
+			{PubKeyBytes: route.Vertex{2}},
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		failure  *HTLCFailInfo
+		expected string
+	}{
+		{
+			name:    "not failed",
+			failure: nil,
+		},
+		{
+			name: "final node failure",
+			failure: &HTLCFailInfo{
+				Reason: HTLCFailMessage,
+				Message: lnwire.NewFailIncorrectDetails(
+					100, 10,
+				),
+				FailureSourceIndex: 2,
+			},
+			expected: "IncorrectOrUnknownPaymentDetails@2/final",
+		},
+		{
+			name: "intermediate node failure",
+			failure: &HTLCFailInfo{
+				Reason: HTLCFailMessage,
+				Message: lnwire.NewTemporaryChannelFailure(
+					nil,
+				),
+				FailureSourceIndex: 1,
+			},
+			expected: "TemporaryChannelFailure@1",
+		},
+		{
+			name: "sender failure",
+			failure: &HTLCFailInfo{
+				Reason:  HTLCFailMessage,
+				Message: &lnwire.FailPermanentNodeFailure{},
+			},
+			expected: "PermanentNodeFailure@0",
+		},
+		{
+			name: "unknown failure",
+			failure: &HTLCFailInfo{
+				Reason:             HTLCFailUnknown,
+				FailureSourceIndex: 2,
+			},
+			expected: "Unknown@2/final",
+		},
+		{
+			name: "unreadable failure",
+			failure: &HTLCFailInfo{
+				Reason: HTLCFailUnreadable,
+			},
+			expected: "Unreadable@0",
+		},
+		{
+			name: "abandoned",
+			failure: &HTLCFailInfo{
+				Reason:             HTLCFailAbandoned,
+				FailureSourceIndex: 2,
+			},
+			expected: "Abandoned@0",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			payment := &MPPayment{
+				HTLCs: []HTLCAttempt{{
+					HTLCAttemptInfo: HTLCAttemptInfo{
+						AttemptID: 5,
+						Route:     rt,
+					},
+					Failure: tc.failure,
+				}},
+			}
+
+			summaries := payment.FailureSummary()
+			if tc.expected == "" {
+				require.Empty(t, summaries)
+				return
+			}
+
+			require.Len(t, summaries, 1)
+			require.EqualValues(t, 5, summaries[0].AttemptID)
+			require.Equal(t, tc.failure.Reason, summaries[0].Reason)
+			require.Equal(t, tc.expected, summaries[0].String())
+		})
+	}
+}
@@ -23,6 +23,14 @@ const (
 	// September 2021, there currently are 14k nodes in a strictly pruned
 	// graph, so we choose a number that is slightly higher.
 	DefaultPreAllocCacheNumNodes = 15000
+
+	// DefaultPaymentsDeleteBatchSize is the default maximum number of
+	// payments that are deleted in a single database transaction.
+	DefaultPaymentsDeleteBatchSize = 1000
+
+	// DefaultMaxQueryPayments is the default maximum number of payments
+	// returned by a single payments query.
+	DefaultMaxQueryPayments = 5000
 )
 
 // OptionalMiragtionConfig defines the flags used to signal whether a
@@ -79,9 +87,60 @@ type Options struct {
 	// are kept on disk or removed to save space.
 	keepFailedPaymentAttempts bool
 
+	// maxInFlightAttempts is the maximum number of in-flight htlc attempts
+	// a single payment may have. A value of zero means no limit.
+	maxInFlightAttempts uint32
+
+	// archiveDeletedPayments determines whether deleted payments are moved
+	// to the payments archive instead of being removed entirely.
+	archiveDeletedPayments bool
+
+	// strictPaymentDecoding determines whether reading a payment with an
+	// htlc attempt that couldn't be parsed fails instead of quarantining
+	// the attempt.
+	strictPaymentDecoding bool
+
+	// reuseSequenceOnRetry determines whether a payment that is initiated
+	// again after it failed keeps its sequence number instead of getting
+	// a new one.
+	reuseSequenceOnRetry bool
+
+	// storeAttemptRoutes determines whether the serialized route of every
+	// htlc attempt is stored separately, so it can be fetched for
+	// debugging.
+	storeAttemptRoutes bool
+
+	// skipPaymentRequests determines whether the payment request of new
+	// payments is left out when the payment is stored.
+	skipPaymentRequests bool
+
 	// storeFinalHtlcResolutions determines whether to persistently store
 	// the final resolution of incoming htlcs.
 	storeFinalHtlcResolutions bool
+
+	// paymentCacheSize is the maximum number of in-flight payments kept
+	// in the in-memory payment cache. A value of zero disables the cache.
+	paymentCacheSize int
+
+	// paymentsDeleteBatchSize is the maximum number of payments that are
+	// deleted in a single database transaction.
+	paymentsDeleteBatchSize int
+
+	// maxQueryPayments is the maximum number of payments returned by a
+	// single payments query, regardless of the number requested.
+	maxQueryPayments uint64
+
+	// paymentsQueryTimeout bounds the read transactions of payments
+	// queries whose context has no deadline. A value of zero disables the
+	// timeout.
+	paymentsQueryTimeout time.Duration
+
+	// paymentsBackend is the backend payments are written to.
+	paymentsBackend PaymentsBackend
+
+	// forcePaymentsBackend determines whether the database is opened even
+	// if payments were recorded to be written to another backend.
+	forcePaymentsBackend bool
 }
 
 // DefaultOptions returns an Options populated with default values.
@@ -100,6 +159,9 @@ func DefaultOptions() Options {
 		UseGraphCache:           true,
 		NoMigration:             false,
 		clock:                   clock.NewDefaultClock(),
+		paymentsDeleteBatchSize: DefaultPaymentsDeleteBatchSize,
+		maxQueryPayments:        DefaultMaxQueryPayments,
+		paymentsBackend:         PaymentsBackendKV,
 	}
 }
 
@@ -203,6 +265,107 @@ func OptionKeepFailedPaymentAttempts(keepFailedPaymentAttempts bool) OptionModif
 	}
 }
 
+// OptionMaxInFlightAttempts sets the maximum number of in-flight htlc attempts
+// a single payment may have. A value of zero means no limit.
+func OptionMaxInFlightAttempts(maxInFlightAttempts uint32) OptionModifier {
+	return func(o *Options) {
+		o.maxInFlightAttempts = maxInFlightAttempts
+	}
+}
+
+// OptionArchiveDeletedPayments controls whether deleted payments are moved to
+// the payments archive instead of being removed entirely.
+func OptionArchiveDeletedPayments(archive bool) OptionModifier {
+	return func(o *Options) {
+		o.archiveDeletedPayments = archive
+	}
+}
+
+// OptionStrictPaymentDecoding controls whether reading a payment with an htlc
+// attempt that couldn't be parsed fails instead of quarantining the attempt.
+func OptionStrictPaymentDecoding(strict bool) OptionModifier {
+	return func(o *Options) {
+		o.strictPaymentDecoding = strict
+	}
+}
+
+// OptionReuseSequenceOnRetry controls whether a payment that is initiated
+// again after it failed keeps its sequence number instead of getting a new
+// one.
+func OptionReuseSequenceOnRetry(reuse bool) OptionModifier {
+	return func(o *Options) {
+		o.reuseSequenceOnRetry = reuse
+	}
+}
+
+// OptionStoreAttemptRoutes controls whether the serialized route of every htlc
+// attempt is stored separately, so it can be fetched for debugging.
+func OptionStoreAttemptRoutes(store bool) OptionModifier {
+	return func(o *Options) {
+		o.storeAttemptRoutes = store
+	}
+}
+
+// OptionSkipPaymentRequests controls whether the payment request of new
+// payments is left out when the payment is stored. The payments are still
+// stored with their hash and amount, but are fetched without a payment
+// request.
+func OptionSkipPaymentRequests(skip bool) OptionModifier {
+	return func(o *Options) {
+		o.skipPaymentRequests = skip
+	}
+}
+
+// OptionPaymentCacheSize sets the maximum number of in-flight payments that
+// are kept in the in-memory payment cache. A size of zero disables the cache.
+func OptionPaymentCacheSize(size int) OptionModifier {
+	return func(o *Options) {
+		o.paymentCacheSize = size
+	}
+}
+
+// OptionPaymentsDeleteBatchSize sets the maximum number of payments that are
+// deleted in a single database transaction when deleting payments in bulk.
+func OptionPaymentsDeleteBatchSize(size int) OptionModifier {
+	return func(o *Options) {
+		o.paymentsDeleteBatchSize = size
+	}
+}
+
+// OptionMaxQueryPayments sets the maximum number of payments returned by a
+// single payments query. Queries asking for more payments are clamped to it.
+func OptionMaxQueryPayments(maxPayments uint64) OptionModifier {
+	return func(o *Options) {
+		o.maxQueryPayments = maxPayments
+	}
+}
+
+// OptionPaymentsQueryTimeout sets the timeout of the read transactions of
+// payments queries whose context has no deadline. A timeout of zero disables
+// it.
+func OptionPaymentsQueryTimeout(timeout time.Duration) OptionModifier {
+	return func(o *Options) {
+		o.paymentsQueryTimeout = timeout
+	}
+}
+
+// OptionPaymentsBackend sets the backend payments are written to. Opening the
+// database fails if payments were recorded to be written to another backend.
+func OptionPaymentsBackend(backend PaymentsBackend) OptionModifier {
+	return func(o *Options) {
+		o.paymentsBackend = backend
+	}
+}
+
+// OptionForcePaymentsBackend controls whether the database is opened even if
+// payments were recorded to be written to another backend than the configured
+// one.
+func OptionForcePaymentsBackend(force bool) OptionModifier {
+	return func(o *Options) {
+		o.forcePaymentsBackend = force
+	}
+}
+
 // OptionStoreFinalHtlcResolutions controls whether to persistently store the
 // final resolution of incoming htlcs.
 func OptionStoreFinalHtlcResolutions(
@@ -0,0 +1,104 @@
+package channeldb
+
+import (
+	"testing"
+
+	"github.com/lightningnetwork/lnd/kvdb"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPaginatorStopsAtTotalItems tests that the paginator only fetches as
+// many items as are allowed in the response, so a small query doesn't read
+// more items than it returns.
+func TestPaginatorStopsAtTotalItems(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	testBucket := []byte("paginator-test-bucket")
+
+	// Fill the bucket with the indexes 1 to 10.
+	err = kvdb.Update(db, func(tx kvdb.RwTx) error {
+		bucket, err := tx.CreateTopLevelBucket(testBucket)
+		if err != nil {
+			return err
+		}
+
+		for i := uint64(1); i <= 10; i++ {
+			var key [8]byte
+			byteOrder.PutUint64(key[:], i)
+
+			if err := bucket.Put(key[:], []byte{1}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, func() {})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name        string
+		reversed    bool
+		indexOffset uint64
+		totalItems  uint64
+		expected    []uint64
+	}{
+		{
+			name:       "first item",
+			totalItems: 1,
+			expected:   []uint64{1},
+		},
+		{
+			name:       "latest item",
+			reversed:   true,
+			totalItems: 1,
+			expected:   []uint64{10},
+		},
+		{
+			name:        "forward from offset",
+			indexOffset: 4,
+			totalItems:  2,
+			expected:    []uint64{5, 6},
+		},
+		{
+			name:        "reversed from offset",
+			reversed:    true,
+			indexOffset: 4,
+			totalItems:  2,
+			expected:    []uint64{3, 2},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Record every item the paginator fetches.
+			var fetched []uint64
+			err := kvdb.View(db, func(tx kvdb.RTx) error {
+				bucket := tx.ReadBucket(testBucket)
+
+				p := newPaginator(
+					bucket.ReadCursor(), tc.reversed,
+					tc.indexOffset, tc.totalItems,
+				)
+
+				return p.query(func(k, _ []byte) (bool, error) {
+					fetched = append(
+						fetched, byteOrder.Uint64(k),
+					)
+
+					return true, nil
+				})
+			}, func() {
+				fetched = nil
+			})
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, fetched)
+		})
+	}
+}
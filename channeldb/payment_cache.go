@@ -0,0 +1,128 @@
+package channeldb
+
+import (
+	"github.com/lightninglabs/neutrino/cache/lru"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/lightningnetwork/lnd/multimutex"
+)
+
+// cachedPayment is the value stored in the payment cache.
+type cachedPayment struct {
+	*MPPayment
+}
+
+// Size returns the "size" of an entry. We return 1 as we just want to limit
+// the number of cached payments.
+func (c *cachedPayment) Size() (uint64, error) {
+	return 1, nil
+}
+
+// paymentCache is a bounded LRU cache of the payments that are still being
+// sent, so the payment lifecycle doesn't need to read the full payment from
+// the database after every update. A cache entry is only ever replaced with
+// the state of the payment after an update was committed. To make sure an
+// entry can't be replaced by an older state, the updates of a payment and the
+// reads that fill the cache hold the lock of the payment until the cache is
+// updated. Updates that don't hold the lock invalidate the entry once they
+// were committed.
+//
+// The methods of a nil paymentCache are no-ops, which is how the cache is
+// disabled.
+type paymentCache struct {
+	payments *lru.Cache[lntypes.Hash, *cachedPayment]
+
+	// paymentsMtx serializes the updates of a payment with the updates of
+	// its cache entry.
+	paymentsMtx *multimutex.Mutex[lntypes.Hash]
+}
+
+// newPaymentCache returns a cache that holds at most size payments.
+func newPaymentCache(size int) *paymentCache {
+	return &paymentCache{
+		payments: lru.NewCache[lntypes.Hash, *cachedPayment](
+			uint64(size),
+		),
+		paymentsMtx: multimutex.NewMutex[lntypes.Hash](),
+	}
+}
+
+// lock locks the payment with the given hash.
+func (c *paymentCache) lock(hash lntypes.Hash) {
+	if c == nil {
+		return
+	}
+
+	c.paymentsMtx.Lock(hash)
+}
+
+// unlock unlocks the payment with the given hash.
+func (c *paymentCache) unlock(hash lntypes.Hash) {
+	if c == nil {
+		return
+	}
+
+	c.paymentsMtx.Unlock(hash)
+}
+
+// get returns the cached payment with the given hash, if any. The returned
+// payment is shared with other callers and must not be modified.
+func (c *paymentCache) get(hash lntypes.Hash) (*MPPayment, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	cached, err := c.payments.Get(hash)
+	if err != nil {
+		return nil, false
+	}
+
+	return cached.MPPayment, true
+}
+
+// update replaces the cache entry of the payment with its given committed
+// state. Only payments that are still being sent are cached, the entry of a
+// payment that reached a final state is removed.
+//
+// NOTE: The caller must hold the lock of the payment.
+func (c *paymentCache) update(payment *MPPayment) {
+	if c == nil {
+		return
+	}
+
+	hash := payment.Info.PaymentIdentifier
+	switch payment.Status {
+	case StatusInitiated, StatusInFlight:
+		// The error is ignored, as it can only be returned by the size
+		// of the entry, which is constant.
+		_, _ = c.payments.Put(hash, &cachedPayment{payment})
+
+	default:
+		c.payments.Delete(hash)
+	}
+}
+
+// remove removes the cache entry of the payment with the given hash.
+//
+// NOTE: The caller must hold the lock of the payment.
+func (c *paymentCache) remove(hash lntypes.Hash) {
+	if c == nil {
+		return
+	}
+
+	c.payments.Delete(hash)
+}
+
+// invalidate removes the cache entry of the payment with the given hash after
+// an update of the payment was committed without holding its lock. Taking the
+// lock makes sure that a concurrent read that still saw the old state of the
+// payment has updated the cache before the entry is removed.
+func (c *paymentCache) invalidate(hash lntypes.Hash) {
+	if c == nil {
+		return
+	}
+
+	c.lock(hash)
+	defer c.unlock(hash)
+
+	c.payments.Delete(hash)
+}
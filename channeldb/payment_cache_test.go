@@ -0,0 +1,218 @@
+package channeldb
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/kvdb"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/lightningnetwork/lnd/record"
+	"github.com/stretchr/testify/require"
+)
+
+// fetchPaymentFromDB reads the payment with the given hash from the database,
+// bypassing the payment cache.
+func fetchPaymentFromDB(t *testing.T, db *DB, hash lntypes.Hash) *MPPayment {
+	t.Helper()
+
+	var payment *MPPayment
+	err := kvdb.View(db, func(tx kvdb.RTx) error {
+		bucket, err := fetchPaymentBucket(tx, hash)
+		if err != nil {
+			return err
+		}
+
+		payment, err = fetchPayment(bucket)
+
+		return err
+	}, func() {
+		payment = nil
+	})
+	require.NoError(t, err)
+
+	return payment
+}
+
+// TestPaymentCacheConcurrentShards checks that the cached view of a payment
+// always matches a fresh read from the database, while its shards are settled
+// and failed in parallel and the payment is read concurrently.
+func TestPaymentCacheConcurrentShards(t *testing.T) {
+	t.Parallel()
+
+	const numShards = 10
+
+	db, err := MakeTestDB(t, OptionPaymentCacheSize(10))
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+
+	info, attempt, preimg, err := genInfo()
+	require.NoError(t, err)
+
+	hash := info.PaymentIdentifier
+	require.NoError(t, pControl.InitPayment(hash, info))
+
+	// Split the payment into MPP shards and register all of them.
+	attempt.Route.FinalHop().AmtToForward = info.Value / numShards
+	attempt.Route.FinalHop().MPP = record.NewMPP(
+		info.Value, [32]byte{1},
+	)
+	for i := uint64(0); i < numShards; i++ {
+		a := *attempt
+		a.AttemptID = i
+
+		_, err := pControl.RegisterAttempt(hash, &a)
+		require.NoError(t, err)
+	}
+
+	// assertCacheConsistent checks that the payment read through the
+	// payment control matches a fresh read from the database.
+	assertCacheConsistent := func() {
+		t.Helper()
+
+		payment, err := pControl.FetchPayment(hash)
+		require.NoError(t, err)
+		require.Equal(t, fetchPaymentFromDB(t, db, hash), payment)
+	}
+
+	// The in-flight payment is cached.
+	_, ok := db.paymentCache.get(hash)
+	require.True(t, ok)
+	assertCacheConsistent()
+
+	// resolveShard settles or fails the shard with the given ID.
+	resolveShard := func(id uint64, settle bool) error {
+		if settle {
+			_, _, err := pControl.SettleAttempt(
+				hash, id, &HTLCSettleInfo{Preimage: preimg},
+			)
+
+			return err
+		}
+
+		_, _, err := pControl.FailAttempt(
+			hash, id, &HTLCFailInfo{Reason: HTLCFailUnreadable},
+		)
+
+		return err
+	}
+
+	// resolveShards resolves the given shards in parallel, while the
+	// payment is read concurrently.
+	resolveShards := func(ids []uint64, settle bool) {
+		var wg sync.WaitGroup
+		for _, id := range ids {
+			wg.Add(2)
+			go func(id uint64) {
+				defer wg.Done()
+
+				require.NoError(t, resolveShard(id, settle))
+			}(id)
+
+			go func() {
+				defer wg.Done()
+
+				_, err := pControl.FetchPayment(hash)
+				require.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+	}
+
+	// Fail half of the shards. The payment is still in flight.
+	resolveShards([]uint64{0, 1, 2, 3, 4}, false)
+	assertCacheConsistent()
+
+	// Settle and fail some more shards, leaving one in flight.
+	resolveShards([]uint64{5, 6}, true)
+	assertCacheConsistent()
+
+	resolveShards([]uint64{7, 8}, false)
+	assertCacheConsistent()
+
+	payment, err := pControl.FetchPayment(hash)
+	require.NoError(t, err)
+	require.Equal(t, StatusInFlight, payment.Status)
+	require.Len(t, payment.InFlightHTLCs(), 1)
+
+	// Settling the last shard completes the payment, which removes it
+	// from the cache.
+	resolveShards([]uint64{9}, true)
+	assertCacheConsistent()
+
+	_, ok = db.paymentCache.get(hash)
+	require.False(t, ok)
+}
+
+// TestPaymentCacheInvalidation checks that updates of a payment that don't
+// go through the attempt updates invalidate its cache entry.
+func TestPaymentCacheInvalidation(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t, OptionPaymentCacheSize(10))
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+
+	info, attempt, _, err := genInfo()
+	require.NoError(t, err)
+
+	hash := info.PaymentIdentifier
+	require.NoError(t, pControl.InitPayment(hash, info))
+
+	// Reading the payment puts it into the cache.
+	_, err = pControl.FetchPayment(hash)
+	require.NoError(t, err)
+
+	_, ok := db.paymentCache.get(hash)
+	require.True(t, ok)
+
+	// Storing the timings invalidates the entry.
+	timings := &PaymentTimings{
+		InitCommitted: time.Unix(1, 0),
+	}
+	require.NoError(t, pControl.StoreTimings(hash, timings))
+
+	payment, err := pControl.FetchPayment(hash)
+	require.NoError(t, err)
+	require.NotNil(t, payment.Timings)
+
+	// Failing the payment removes it from the cache.
+	_, err = pControl.RegisterAttempt(hash, attempt)
+	require.NoError(t, err)
+
+	_, _, err = pControl.FailAttempt(
+		hash, attempt.AttemptID, &HTLCFailInfo{
+			Reason: HTLCFailUnreadable,
+		},
+	)
+	require.NoError(t, err)
+
+	_, ok = db.paymentCache.get(hash)
+	require.True(t, ok)
+
+	_, err = pControl.Fail(hash, FailureReasonNoRoute)
+	require.NoError(t, err)
+
+	_, ok = db.paymentCache.get(hash)
+	require.False(t, ok)
+
+	// Initiating the payment again resets it, and deleting the initiated
+	// payment removes it.
+	require.NoError(t, pControl.InitPayment(hash, info))
+
+	payment, err = pControl.FetchPayment(hash)
+	require.NoError(t, err)
+	require.Equal(t, StatusInitiated, payment.Status)
+	require.Empty(t, payment.HTLCs)
+
+	_, err = pControl.DeletePayment(hash, false)
+	require.NoError(t, err)
+
+	_, ok = db.paymentCache.get(hash)
+	require.False(t, ok)
+
+	_, err = pControl.FetchPayment(hash)
+	require.ErrorIs(t, err, ErrPaymentNotInitiated)
+}
@@ -2,20 +2,37 @@ package channeldb
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/lightningnetwork/lnd/kvdb"
 	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/lightningnetwork/lnd/record"
+	"github.com/lightningnetwork/lnd/routing/route"
 )
 
 const (
 	// paymentSeqBlockSize is the block size used when we batch allocate
 	// payment sequences for future payments.
 	paymentSeqBlockSize = 1000
+
+	// attemptIDBlockSize is the block size used when we batch allocate
+	// IDs for future htlc attempts.
+	attemptIDBlockSize = 1000
+)
+
+var (
+	// nextAttemptIDBucket is the top-level bucket whose sequence holds the
+	// next htlc attempt ID that hasn't been reserved yet. The bucket was
+	// created by the switch's payment ID sequencer, which reserved IDs
+	// from the same counter, so IDs keep increasing across upgrades.
+	nextAttemptIDBucket = []byte("next-payment-id-key")
 )
 
 var (
@@ -65,6 +82,28 @@ var (
 	// failed HTLC attempt.
 	ErrAttemptAlreadyFailed = errors.New("attempt already failed")
 
+	// ErrAttemptAlreadyRegistered is returned if we try to register an
+	// HTLC attempt with an ID the payment already has an attempt for.
+	ErrAttemptAlreadyRegistered = errors.New("attempt already registered")
+
+	// ErrFailureMessageTooLarge is returned if we try to fail an htlc
+	// attempt with a failure message whose encoding exceeds the maximum
+	// size that can be stored.
+	ErrFailureMessageTooLarge = errors.New("failure message too large")
+
+	// ErrInvalidFailureSourceIndex is returned if the failure source
+	// index of an htlc attempt doesn't point into the attempt's route.
+	ErrInvalidFailureSourceIndex = errors.New("invalid failure source " +
+		"index")
+
+	// ErrAttemptNotFailed is returned if we try to look up the failure
+	// source of an htlc attempt that isn't failed.
+	ErrAttemptNotFailed = errors.New("attempt not failed")
+
+	// ErrAttemptNotFound is returned if we try to look up an htlc attempt
+	// that doesn't exist on the payment.
+	ErrAttemptNotFound = errors.New("htlc attempt not found on payment")
+
 	// ErrValueMismatch is returned if we try to register a non-MPP attempt
 	// with an amount that doesn't match the payment amount.
 	ErrValueMismatch = errors.New("attempted value doesn't match payment" +
@@ -92,6 +131,17 @@ var (
 	ErrMPPTotalAmountMismatch = errors.New("mp payment total amount " +
 		"mismatch")
 
+	// ErrMPPRecordInBlindedPayment is returned if we try to register an
+	// attempt with an MPP record for a payment to a blinded path.
+	ErrMPPRecordInBlindedPayment = errors.New("blinded payment cannot " +
+		"contain MPP records")
+
+	// ErrBlindedPaymentTotalAmountMismatch is returned if we try to
+	// register a blinded shard where the total amount doesn't match
+	// existing shards.
+	ErrBlindedPaymentTotalAmountMismatch = errors.New("blinded path " +
+		"total amount mismatch")
+
 	// ErrPaymentPendingSettled is returned when we try to add a new
 	// attempt to a payment that has at least one of its HTLCs settled.
 	ErrPaymentPendingSettled = errors.New("payment has settled htlcs")
@@ -104,22 +154,126 @@ var (
 	// amount exceed the total amount.
 	ErrSentExceedsTotal = errors.New("total sent exceeds total amount")
 
+	// errSequenceNumRequired is used internally when a payment turns out
+	// to need a new sequence number while it's being initiated.
+	errSequenceNumRequired = errors.New("payment sequence number required")
+
+	// ErrMaxInFlightAttempts is returned when we try to register a new
+	// attempt for a payment that already has the maximum number of
+	// in-flight attempts.
+	ErrMaxInFlightAttempts = errors.New("payment has reached the " +
+		"maximum number of in-flight attempts")
+
+	// ErrAttemptRouteNotStored is returned when the route of an htlc
+	// attempt is fetched, but it wasn't stored, either because storing
+	// attempt routes is disabled or because the attempt isn't known.
+	ErrAttemptRouteNotStored = errors.New("attempt route not stored")
+
+	// ErrPaymentLabelTooLong is returned when we try to initiate a payment
+	// with a label longer than MaxPaymentLabelLength.
+	ErrPaymentLabelTooLong = fmt.Errorf("payment label exceeds %d bytes",
+		MaxPaymentLabelLength)
+
+	// ErrClientReferenceTooLong is returned when we try to initiate a
+	// payment with a client reference longer than
+	// MaxClientReferenceLength.
+	ErrClientReferenceTooLong = fmt.Errorf("client reference exceeds %d "+
+		"bytes", MaxClientReferenceLength)
+
 	// errNoAttemptInfo is returned when no attempt info is stored yet.
 	errNoAttemptInfo = errors.New("unable to find attempt info for " +
 		"inflight payment")
 
+	// errUnreadableFailureMessage is returned when the failure message of
+	// a failed htlc can't be decoded.
+	errUnreadableFailureMessage = errors.New("unreadable failure message")
+
 	// errNoSequenceNrIndex is returned when an attempt to lookup a payment
 	// index is made for a sequence number that is not indexed.
 	errNoSequenceNrIndex = errors.New("payment sequence number index " +
 		"does not exist")
 )
 
+// AttemptEvent is the transition of an htlc attempt that caused an update of
+// its payment.
+type AttemptEvent uint8
+
+const (
+	// AttemptEventNone means that the update wasn't caused by an htlc
+	// attempt, as when the payment itself was failed.
+	AttemptEventNone AttemptEvent = iota
+
+	// AttemptEventRegistered means that the attempt was registered.
+	AttemptEventRegistered
+
+	// AttemptEventSettled means that the attempt was settled.
+	AttemptEventSettled
+
+	// AttemptEventFailed means that the attempt was failed or abandoned.
+	AttemptEventFailed
+)
+
+// String returns a human-readable name of the attempt event.
+func (e AttemptEvent) String() string {
+	switch e {
+	case AttemptEventNone:
+		return "none"
+
+	case AttemptEventRegistered:
+		return "registered"
+
+	case AttemptEventSettled:
+		return "settled"
+
+	case AttemptEventFailed:
+		return "failed"
+
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(e))
+	}
+}
+
+// PaymentUpdate describes a committed update of a payment.
+type PaymentUpdate struct {
+	// PaymentIdentifier is the identifier of the updated payment.
+	PaymentIdentifier lntypes.Hash
+
+	// AttemptID is the ID of the htlc attempt that was registered, settled
+	// or failed. It is nil if the payment itself was failed.
+	AttemptID *uint64
+
+	// AttemptEvent is the transition of the attempt with AttemptID. It is
+	// AttemptEventNone if the payment itself was failed.
+	AttemptEvent AttemptEvent
+
+	// Status is the status of the payment after the update.
+	Status PaymentStatus
+
+	// Payment is the payment as of the update.
+	Payment *MPPayment
+}
+
+// PaymentNotifier is called with every update of a payment once it has been
+// committed to the database.
+type PaymentNotifier func(update *PaymentUpdate)
+
 // PaymentControl implements persistence for payments and payment attempts.
 type PaymentControl struct {
 	paymentSeqMx     sync.Mutex
 	currPaymentSeq   uint64
 	storedPaymentSeq uint64
 	db               *DB
+
+	// attemptIDMx guards nextAttemptID and attemptIDHorizon, which
+	// delimit the block of attempt IDs reserved in the database that
+	// haven't been handed out yet.
+	attemptIDMx      sync.Mutex
+	nextAttemptID    uint64
+	attemptIDHorizon uint64
+
+	// notifier, if set, is called after an attempt was registered,
+	// settled or failed, or after a payment was failed.
+	notifier PaymentNotifier
 }
 
 // NewPaymentControl creates a new instance of the PaymentControl.
@@ -129,32 +283,113 @@ func NewPaymentControl(db *DB) *PaymentControl {
 	}
 }
 
+// SetNotifier sets the notifier that is called after each committed call of
+// RegisterAttempt, SettleAttempt, FailAttempt and Fail, and for each attempt
+// resolved by ResolveAttempts. The notifier is called exactly once per
+// committed update and never for updates that were rolled back. It runs
+// synchronously, before the updating method returns.
+//
+// NOTE: This must be called before the PaymentControl is used.
+func (p *PaymentControl) SetNotifier(notifier PaymentNotifier) {
+	p.notifier = notifier
+}
+
+// notify calls the notifier, if set, with the given committed update of a
+// payment.
+func (p *PaymentControl) notify(payment *MPPayment, attemptID *uint64,
+	event AttemptEvent) {
+
+	if p.notifier == nil {
+		return
+	}
+
+	p.notifier(&PaymentUpdate{
+		PaymentIdentifier: payment.Info.PaymentIdentifier,
+		AttemptID:         attemptID,
+		AttemptEvent:      event,
+		Status:            payment.Status,
+		Payment:           payment,
+	})
+}
+
 // InitPayment checks or records the given PaymentCreationInfo with the DB,
 // making sure it does not already exist as an in-flight payment. When this
 // method returns successfully, the payment is guaranteed to be in the InFlight
-// state.
+// state, unless it's an existing payment that is sent again with its client
+// reference. Such a payment is left as is if it can't be retried, so the
+// caller can pick up its outcome.
 func (p *PaymentControl) InitPayment(paymentHash lntypes.Hash,
 	info *PaymentCreationInfo) error {
 
-	// Obtain a new sequence number for this payment. This is used
-	// to sort the payments in order of creation, and also acts as
-	// a unique identifier for each payment.
-	sequenceNum, err := p.nextPaymentSequence()
-	if err != nil {
-		return err
+	if len(info.Label) > MaxPaymentLabelLength {
+		return ErrPaymentLabelTooLong
+	}
+
+	if len(info.ClientReference) > MaxClientReferenceLength {
+		return ErrClientReferenceTooLong
+	}
+
+	// The payment request is left out if the caller or the database is
+	// configured to not store it. We store a copy of the info, so the
+	// caller's info is kept intact.
+	storedInfo := info
+	if info.SkipPaymentRequest || p.db.skipPaymentRequests {
+		infoCopy := *info
+		infoCopy.PaymentRequest = nil
+		storedInfo = &infoCopy
 	}
 
 	var b bytes.Buffer
-	if err := serializePaymentCreationInfo(&b, info); err != nil {
+	if err := serializePaymentCreationInfo(&b, storedInfo); err != nil {
 		return err
 	}
 	infoBytes := b.Bytes()
 
-	var updateErr error
-	err = kvdb.Batch(p.db.Backend, func(tx kvdb.RwTx) error {
+	p.db.paymentCache.lock(paymentHash)
+	defer p.db.paymentCache.unlock(paymentHash)
+
+	// Obtain a new sequence number for this payment. This is used to sort
+	// the payments in order of creation, and also acts as a unique
+	// identifier for each payment. A payment that is sent again keeps its
+	// sequence number if configured, so we only obtain one if it will be
+	// used.
+	var sequenceNum []byte
+	reuseSeq, err := p.canReuseSequence(paymentHash)
+	if err != nil {
+		return err
+	}
+	if !reuseSeq {
+		sequenceNum, err = p.nextPaymentSequence()
+		if err != nil {
+			return err
+		}
+	}
+
+	var (
+		updateErr error
+		existing  bool
+	)
+	initPayment := func(tx kvdb.RwTx) error {
 		// Reset the update error, to avoid carrying over an error
 		// from a previous execution of the batched db transaction.
 		updateErr = nil
+		existing = false
+
+		// A client reference can only be used by a single payment, so
+		// we refuse to initiate another payment with it.
+		if len(info.ClientReference) != 0 {
+			owner, ok := lookupReferenceIndex(
+				tx, info.ClientReference,
+			)
+			if ok && owner != paymentHash {
+				updateErr = fmt.Errorf("%w: client "+
+					"reference %x is used by payment %v",
+					ErrPaymentExists, info.ClientReference,
+					owner)
+
+				return nil
+			}
+		}
 
 		prefetchPayment(tx, paymentHash)
 		bucket, err := createPaymentBucket(tx, paymentHash)
@@ -170,7 +405,21 @@ func (p *PaymentControl) InitPayment(paymentHash lntypes.Hash,
 		// payment. We'll check the status to decide whether we allow
 		// retrying the payment or return a specific error.
 		case err == nil:
-			if err := paymentStatus.initializable(); err != nil {
+			err := paymentStatus.initializable()
+
+			// A payment that is sent again with its client
+			// reference while it can't be retried is an
+			// idempotent retry of the client, so the existing
+			// payment is kept as is.
+			ref := bucket.Get(paymentClientReferenceKey)
+			if err != nil && len(info.ClientReference) != 0 &&
+				bytes.Equal(ref, info.ClientReference) {
+
+				existing = true
+				return nil
+			}
+
+			if err != nil {
 				updateErr = err
 				return nil
 			}
@@ -182,14 +431,26 @@ func (p *PaymentControl) InitPayment(paymentHash lntypes.Hash,
 		}
 
 		// Before we set our new sequence number, we check whether this
-		// payment has a previously set sequence number and remove its
-		// index entry if it exists. This happens in the case where we
-		// have a previously attempted payment which was left in a state
-		// where we can retry.
+		// payment has a previously set sequence number. This happens in
+		// the case where we have a previously attempted payment which
+		// was left in a state where we can retry. If configured, we
+		// keep the existing sequence number and its index entry.
+		// Otherwise, we remove the index entry of the old sequence
+		// number.
 		seqBytes := bucket.Get(paymentSequenceKey)
-		if seqBytes != nil {
-			indexBucket := tx.ReadWriteBucket(paymentsIndexBucket)
-			if err := indexBucket.Delete(seqBytes); err != nil {
+		reuseSeq := seqBytes != nil && p.db.reuseSequenceOnRetry
+
+		// We didn't obtain a sequence number, because the payment had
+		// one it could keep. If the payment was deleted since, it
+		// needs a new one after all.
+		if !reuseSeq && sequenceNum == nil {
+			updateErr = errSequenceNumRequired
+			return nil
+		}
+
+		if seqBytes != nil && !reuseSeq {
+			err := deletePaymentIndexEntry(tx, seqBytes)
+			if err != nil {
 				return err
 			}
 		}
@@ -197,21 +458,69 @@ func (p *PaymentControl) InitPayment(paymentHash lntypes.Hash,
 		// Once we have obtained a sequence number, we add an entry
 		// to our index bucket which will map the sequence number to
 		// our payment identifier.
-		err = createPaymentIndexEntry(
-			tx, sequenceNum, info.PaymentIdentifier,
-		)
+		if !reuseSeq {
+			err = createPaymentIndexEntry(
+				tx, sequenceNum, info.PaymentIdentifier,
+			)
+			if err != nil {
+				return err
+			}
+
+			err = bucket.Put(paymentSequenceKey, sequenceNum)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Add the payment info to the bucket, which contains the
+		// static information for this payment
+		err = bucket.Put(paymentCreationInfoKey, infoBytes)
 		if err != nil {
 			return err
 		}
 
-		err = bucket.Put(paymentSequenceKey, sequenceNum)
+		// Store the payment's label, if any, removing the label of a
+		// previous attempt of the payment otherwise.
+		if info.Label != "" {
+			err = bucket.Put(paymentLabelKey, []byte(info.Label))
+		} else {
+			err = bucket.Delete(paymentLabelKey)
+		}
 		if err != nil {
 			return err
 		}
 
-		// Add the payment info to the bucket, which contains the
-		// static information for this payment
-		err = bucket.Put(paymentCreationInfoKey, infoBytes)
+		// Likewise for the payment address.
+		if info.PaymentAddr != nil {
+			err = bucket.Put(paymentAddrKey, info.PaymentAddr[:])
+		} else {
+			err = bucket.Delete(paymentAddrKey)
+		}
+		if err != nil {
+			return err
+		}
+
+		// And for the maximum number of recorded attempts.
+		if info.MaxRecordedAttempts != 0 {
+			var maxAttempts [4]byte
+			byteOrder.PutUint32(
+				maxAttempts[:], info.MaxRecordedAttempts,
+			)
+			err = bucket.Put(
+				paymentMaxRecordedAttemptsKey, maxAttempts[:],
+			)
+		} else {
+			err = bucket.Delete(paymentMaxRecordedAttemptsKey)
+		}
+		if err != nil {
+			return err
+		}
+
+		// And for the client reference, which is also indexed. The
+		// reference of a previous attempt is released if it changed.
+		err = updateClientReference(
+			tx, bucket, paymentHash, info.ClientReference,
+		)
 		if err != nil {
 			return err
 		}
@@ -224,23 +533,99 @@ func (p *PaymentControl) InitPayment(paymentHash lntypes.Hash,
 			return err
 		}
 
-		// Also delete any lingering failure info now that we are
-		// re-attempting.
-		return bucket.Delete(paymentFailInfoKey)
-	})
+		err = bucket.DeleteNestedBucket(paymentAttemptRoutesBucket)
+		if err != nil && err != kvdb.ErrBucketNotFound {
+			return err
+		}
+
+		// Also delete any lingering failure info and timings now that
+		// we are re-attempting.
+		if err := bucket.Delete(paymentTimingsKey); err != nil {
+			return err
+		}
+
+		if err := bucket.Delete(paymentFailInfoKey); err != nil {
+			return err
+		}
+
+		if err := bucket.Delete(paymentFailDetailKey); err != nil {
+			return err
+		}
+
+		// The stored state was computed for the previous attempts and
+		// amount, so it's recomputed once the first attempt is
+		// registered.
+		if err := bucket.Delete(paymentStateKey); err != nil {
+			return err
+		}
+
+		// The payment is being sent (again), so it's in flight.
+		return updateInFlightIndex(tx, paymentHash, true)
+	}
+
+	err = kvdb.Batch(p.db.Backend, initPayment)
+	if err == nil && errors.Is(updateErr, errSequenceNumRequired) {
+		sequenceNum, err = p.nextPaymentSequence()
+		if err == nil {
+			err = kvdb.Batch(p.db.Backend, initPayment)
+		}
+	}
 	if err != nil {
 		return fmt.Errorf("unable to init payment: %w", err)
 	}
 
+	// The existing payment was left untouched.
+	if existing {
+		log.Debugf("Payment %v with client reference %x already "+
+			"exists, keeping it", paymentHash, info.ClientReference)
+
+		return nil
+	}
+
+	// The payment was reset, so any cached state of an earlier attempt of
+	// it is stale.
+	if updateErr == nil {
+		p.db.paymentCache.remove(paymentHash)
+	}
+
 	return updateErr
 }
 
+// canReuseSequence returns true if the payment already has a sequence number
+// that it keeps when it is sent again. The caller must hold the payment's lock.
+func (p *PaymentControl) canReuseSequence(paymentHash lntypes.Hash) (bool,
+	error) {
+
+	if !p.db.reuseSequenceOnRetry {
+		return false, nil
+	}
+
+	var hasSeq bool
+	err := kvdb.View(p.db.Backend, func(tx kvdb.RTx) error {
+		bucket, err := fetchPaymentBucket(tx, paymentHash)
+		if errors.Is(err, ErrPaymentNotInitiated) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		hasSeq = bucket.Get(paymentSequenceKey) != nil
+
+		return nil
+	}, func() {
+		hasSeq = false
+	})
+
+	return hasSeq, err
+}
+
 // DeleteFailedAttempts deletes all failed htlcs for a payment if configured
 // by the PaymentControl db.
 func (p *PaymentControl) DeleteFailedAttempts(hash lntypes.Hash) error {
 	if !p.db.keepFailedPaymentAttempts {
 		const failedHtlcsOnly = true
-		err := p.db.DeletePayment(hash, failedHtlcsOnly)
+		_, err := p.db.DeletePayment(hash, failedHtlcsOnly)
 		if err != nil {
 			return err
 		}
@@ -248,6 +633,36 @@ func (p *PaymentControl) DeleteFailedAttempts(hash lntypes.Hash) error {
 	return nil
 }
 
+// DeletePayment deletes the payment with the given hash, or only its failed
+// htlcs if failedHtlcsOnly is set, and returns what was removed. Payments with
+// in-flight htlcs can't be deleted.
+func (p *PaymentControl) DeletePayment(hash lntypes.Hash,
+	failedHtlcsOnly bool) (*DeletedPayment, error) {
+
+	return p.db.DeletePayment(hash, failedHtlcsOnly)
+}
+
+// DeletePayments deletes all completed and failed payments, or only the
+// failed ones if failedOnly is set, and returns the hashes of the altered
+// payments along with the number of removed htlc attempts. If failedHtlcsOnly
+// is set, only the failed htlc attempts of the payments are deleted. See
+// DB.DeletePayments for how the payments are deleted in batches.
+func (p *PaymentControl) DeletePayments(failedOnly,
+	failedHtlcsOnly bool) ([]lntypes.Hash, int, error) {
+
+	return p.db.deletePayments(failedOnly, failedHtlcsOnly)
+}
+
+// DeletePaymentsBefore deletes at most maxDeletes removable payments that
+// were created before the given cutoff time, or only the failed ones if
+// failedOnly is set, and returns the hashes of the deleted payments.
+func (p *PaymentControl) DeletePaymentsBefore(ctx context.Context,
+	cutoff time.Time, failedOnly bool, maxDeletes int) ([]lntypes.Hash,
+	error) {
+
+	return p.db.deletePaymentsBefore(ctx, cutoff, failedOnly, maxDeletes)
+}
+
 // paymentIndexTypeHash is a payment index type which indicates that we have
 // created an index of payment sequence number to payment hash.
 type paymentIndexType uint8
@@ -258,7 +673,8 @@ const paymentIndexTypeHash paymentIndexType = 0
 
 // createPaymentIndexEntry creates a payment hash typed index for a payment. The
 // index produced contains a payment index type (which can be used in future to
-// signal different payment index types) and the payment identifier.
+// signal different payment index types) and the payment identifier. The
+// payments counter is incremented if the entry is new.
 func createPaymentIndexEntry(tx kvdb.RwTx, sequenceNumber []byte,
 	id lntypes.Hash) error {
 
@@ -268,9 +684,97 @@ func createPaymentIndexEntry(tx kvdb.RwTx, sequenceNumber []byte,
 	}
 
 	indexes := tx.ReadWriteBucket(paymentsIndexBucket)
+	if indexes.Get(sequenceNumber) == nil {
+		if err := adjustPaymentsCount(tx, 1); err != nil {
+			return err
+		}
+	}
+
 	return indexes.Put(sequenceNumber, b.Bytes())
 }
 
+// deletePaymentIndexEntry deletes the payments index entry with the given
+// sequence number, decrementing the payments counter if it existed.
+func deletePaymentIndexEntry(tx kvdb.RwTx, sequenceNumber []byte) error {
+	indexes := tx.ReadWriteBucket(paymentsIndexBucket)
+	if indexes.Get(sequenceNumber) == nil {
+		return nil
+	}
+
+	if err := adjustPaymentsCount(tx, -1); err != nil {
+		return err
+	}
+
+	return indexes.Delete(sequenceNumber)
+}
+
+// lookupReferenceIndex returns the hash of the payment that uses the given
+// client reference, if any.
+func lookupReferenceIndex(tx kvdb.RTx, ref []byte) (lntypes.Hash, bool) {
+	index := tx.ReadBucket(paymentsReferenceIndexBucket)
+	if index == nil {
+		return lntypes.Hash{}, false
+	}
+
+	hashBytes := index.Get(ref)
+	if len(hashBytes) != lntypes.HashSize {
+		return lntypes.Hash{}, false
+	}
+
+	var hash lntypes.Hash
+	copy(hash[:], hashBytes)
+
+	return hash, true
+}
+
+// updateClientReference stores the given client reference in the payment's
+// bucket and indexes it, releasing the reference the payment carried before,
+// if it differs. An empty reference removes the payment's reference.
+func updateClientReference(tx kvdb.RwTx, bucket kvdb.RwBucket,
+	paymentHash lntypes.Hash, ref []byte) error {
+
+	if oldRef := bucket.Get(paymentClientReferenceKey); oldRef != nil &&
+		!bytes.Equal(oldRef, ref) {
+
+		err := deleteReferenceIndexEntry(
+			tx, copyBytes(oldRef), paymentHash,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(ref) == 0 {
+		return bucket.Delete(paymentClientReferenceKey)
+	}
+
+	if err := bucket.Put(paymentClientReferenceKey, ref); err != nil {
+		return err
+	}
+
+	index, err := tx.CreateTopLevelBucket(paymentsReferenceIndexBucket)
+	if err != nil {
+		return err
+	}
+
+	return index.Put(ref, paymentHash[:])
+}
+
+// deleteReferenceIndexEntry removes the given client reference from the
+// reference index, as long as it points to the payment with the given hash.
+func deleteReferenceIndexEntry(tx kvdb.RwTx, ref []byte,
+	paymentHash lntypes.Hash) error {
+
+	owner, ok := lookupReferenceIndex(tx, ref)
+	if !ok || owner != paymentHash {
+		return nil
+	}
+
+	index := tx.ReadWriteBucket(paymentsReferenceIndexBucket)
+
+	return index.Delete(ref)
+}
+
 // deserializePaymentIndex deserializes a payment index entry. This function
 // currently only supports deserialization of payment hash indexes, and will
 // fail for other types.
@@ -300,11 +804,63 @@ func deserializePaymentIndex(r io.Reader) (lntypes.Hash, error) {
 	return hash, nil
 }
 
+// CustomRecordRangeError is returned when an attempt is registered whose route
+// carries a hop-level custom record with a type below the custom TLV range.
+type CustomRecordRangeError struct {
+	// HopIndex is the index of the offending hop in the attempt's route.
+	HopIndex int
+
+	// Type is the offending custom record type.
+	Type uint64
+}
+
+// Error returns a human-readable description of the error.
+func (e *CustomRecordRangeError) Error() string {
+	return fmt.Sprintf("custom record type %d of hop %d is below the "+
+		"custom type range starting at %d", e.Type, e.HopIndex,
+		record.CustomTypeStart)
+}
+
+// validateHopCustomRecords checks that all hop-level custom records of the
+// route are in the custom type range.
+func validateHopCustomRecords(rt *route.Route) error {
+	for i, h := range rt.Hops {
+		if err := h.CustomRecords.Validate(); err == nil {
+			continue
+		}
+
+		// Report the lowest offending type, so the error doesn't
+		// depend on the map iteration order.
+		rangeErr := &CustomRecordRangeError{
+			HopIndex: i,
+			Type:     record.CustomTypeStart,
+		}
+		for t := range h.CustomRecords {
+			if t < rangeErr.Type {
+				rangeErr.Type = t
+			}
+		}
+
+		return rangeErr
+	}
+
+	return nil
+}
+
 // RegisterAttempt atomically records the provided HTLCAttemptInfo to the
-// DB.
+// DB. Nil hop custom record values of the attempt's route are replaced by
+// empty values, which is how they are read back from the DB.
 func (p *PaymentControl) RegisterAttempt(paymentHash lntypes.Hash,
 	attempt *HTLCAttemptInfo) (*MPPayment, error) {
 
+	// Reject custom records outside of the custom range with an error
+	// naming the offending hop and record type.
+	if err := validateHopCustomRecords(&attempt.Route); err != nil {
+		return nil, err
+	}
+
+	normalizeHopCustomRecords(&attempt.Route)
+
 	// Serialize the information before opening the db transaction.
 	var a bytes.Buffer
 	err := serializeHTLCAttemptInfo(&a, attempt)
@@ -316,6 +872,19 @@ func (p *PaymentControl) RegisterAttempt(paymentHash lntypes.Hash,
 	htlcIDBytes := make([]byte, 8)
 	binary.BigEndian.PutUint64(htlcIDBytes, attempt.AttemptID)
 
+	// Keep the serialized route of the attempt, if configured.
+	var routeBytes []byte
+	if p.db.storeAttemptRoutes {
+		var r bytes.Buffer
+		if err := SerializeRoute(&r, attempt.Route); err != nil {
+			return nil, err
+		}
+		routeBytes = r.Bytes()
+	}
+
+	p.db.paymentCache.lock(paymentHash)
+	defer p.db.paymentCache.unlock(paymentHash)
+
 	var payment *MPPayment
 	err = kvdb.Batch(p.db.Backend, func(tx kvdb.RwTx) error {
 		prefetchPayment(tx, paymentHash)
@@ -334,49 +903,36 @@ func (p *PaymentControl) RegisterAttempt(paymentHash lntypes.Hash,
 			return err
 		}
 
-		// Make sure any existing shards match the new one with regards
-		// to MPP options.
-		mpp := attempt.Route.FinalHop().MPP
-		for _, h := range payment.InFlightHTLCs() {
-			hMpp := h.Route.FinalHop().MPP
-
-			switch {
-			// We tried to register a non-MPP attempt for a MPP
-			// payment.
-			case mpp == nil && hMpp != nil:
-				return ErrMPPayment
-
-			// We tried to register a MPP shard for a non-MPP
-			// payment.
-			case mpp != nil && hMpp == nil:
-				return ErrNonMPPayment
-
-			// Non-MPP payment, nothing more to validate.
-			case mpp == nil:
-				continue
-			}
+		// Make sure we don't overwrite an existing attempt with the
+		// same ID.
+		infoKey := htlcBucketKey(htlcAttemptInfoKey, htlcIDBytes)
+		htlcs := bucket.NestedReadBucket(paymentHtlcsBucket)
+		if htlcs != nil && htlcs.Get(infoKey) != nil {
+			return fmt.Errorf("%w: attempt %d of payment %v",
+				ErrAttemptAlreadyRegistered, attempt.AttemptID,
+				paymentHash)
+		}
 
-			// Check that MPP options match.
-			if mpp.PaymentAddr() != hMpp.PaymentAddr() {
-				return ErrMPPPaymentAddrMismatch
-			}
+		// Make sure the payment doesn't exceed the maximum number of
+		// in-flight attempts, if configured. Settled and failed
+		// attempts don't count towards this limit.
+		maxInFlight := p.db.maxInFlightAttempts
+		if maxInFlight != 0 &&
+			payment.State.NumAttemptsInFlight >= int(maxInFlight) {
 
-			if mpp.TotalMsat() != hMpp.TotalMsat() {
-				return ErrMPPTotalAmountMismatch
-			}
+			return ErrMaxInFlightAttempts
 		}
 
-		// If this is a non-MPP attempt, it must match the total amount
-		// exactly.
-		amt := attempt.Route.ReceiverAmt()
-		if mpp == nil && amt != payment.Info.Value {
-			return ErrValueMismatch
+		// Make sure the attempt is consistent with the payment and its
+		// existing attempts.
+		if err := VerifyAttempt(payment, attempt); err != nil {
+			return err
 		}
 
-		// Ensure we aren't sending more than the total payment amount.
-		sentAmt, _ := payment.SentAmt()
-		if sentAmt+amt > payment.Info.Value {
-			return ErrValueExceedsAmt
+		// Make room for the new attempt by dropping the oldest failed
+		// attempts, if the number of recorded attempts is limited.
+		if err := pruneFailedAttempts(bucket, payment); err != nil {
+			return err
 		}
 
 		htlcsBucket, err := bucket.CreateBucketIfNotExists(
@@ -386,23 +942,179 @@ func (p *PaymentControl) RegisterAttempt(paymentHash lntypes.Hash,
 			return err
 		}
 
-		err = htlcsBucket.Put(
-			htlcBucketKey(htlcAttemptInfoKey, htlcIDBytes),
-			htlcInfoBytes,
-		)
+		err = htlcsBucket.Put(infoKey, htlcInfoBytes)
 		if err != nil {
 			return err
 		}
 
-		// Retrieve attempt info for the notification.
-		payment, err = fetchPayment(bucket)
-		return err
-	})
-	if err != nil {
+		if routeBytes != nil {
+			routes, err := bucket.CreateBucketIfNotExists(
+				paymentAttemptRoutesBucket,
+			)
+			if err != nil {
+				return err
+			}
+
+			err = routes.Put(htlcIDBytes, routeBytes)
+			if err != nil {
+				return err
+			}
+		}
+
+		// If the payment address isn't known yet, take it from the
+		// MPP record of the attempt.
+		mpp := attempt.Route.FinalHop().MPP
+		if payment.Info.PaymentAddr == nil && mpp != nil {
+			addr := mpp.PaymentAddr()
+			err := bucket.Put(paymentAddrKey, addr[:])
+			if err != nil {
+				return err
+			}
+		}
+
+		// Retrieve attempt info for the notification.
+		payment, err = fetchPayment(bucket)
+		if err != nil {
+			return err
+		}
+
+		return putPaymentState(bucket, payment.State)
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	return payment, err
+	p.db.paymentCache.update(payment)
+
+	attemptID := attempt.AttemptID
+	p.notify(payment, &attemptID, AttemptEventRegistered)
+
+	return payment, nil
+}
+
+// pruneFailedAttempts removes the oldest failed htlc attempts of the payment,
+// so that one more attempt can be recorded without exceeding the payment's
+// maximum number of recorded attempts. Settled and in-flight attempts are
+// never removed, so they may still exceed the limit. Failed attempts don't
+// contribute to the amounts of the payment, which therefore stay intact.
+func pruneFailedAttempts(bucket kvdb.RwBucket, payment *MPPayment) error {
+	maxAttempts := int(payment.Info.MaxRecordedAttempts)
+	if maxAttempts == 0 {
+		return nil
+	}
+
+	// The attempts are sorted by their ID, so the oldest ones come first.
+	excess := len(payment.HTLCs) + 1 - maxAttempts
+
+	var toDelete [][]byte
+	for _, h := range payment.HTLCs {
+		if excess <= 0 {
+			break
+		}
+
+		if h.Failure == nil {
+			continue
+		}
+
+		htlcIDBytes := make([]byte, 8)
+		binary.BigEndian.PutUint64(htlcIDBytes, h.AttemptID)
+		toDelete = append(toDelete, htlcIDBytes)
+
+		excess--
+	}
+
+	if len(toDelete) == 0 {
+		return nil
+	}
+
+	log.Debugf("Pruning %d failed attempts of payment %v", len(toDelete),
+		payment.Info.PaymentIdentifier)
+
+	return deleteHtlcAttempts(bucket, toDelete)
+}
+
+// VerifyAttempt checks that the given attempt can be added to the payment
+// without violating its MPP or blinded path options or exceeding the payment
+// amount. The
+// payment's status isn't checked.
+func VerifyAttempt(payment *MPPayment, attempt *HTLCAttemptInfo) error {
+	// Make sure any existing shards match the new one with regards to MPP
+	// options.
+	finalHop := attempt.Route.FinalHop()
+	mpp := finalHop.MPP
+
+	// Attempts to a blinded path are split without MPP records, so they
+	// must not carry one.
+	isBlinded := len(finalHop.EncryptedData) != 0
+	if isBlinded && mpp != nil {
+		return ErrMPPRecordInBlindedPayment
+	}
+
+	for _, h := range payment.InFlightHTLCs() {
+		// The route of a quarantined attempt may be unknown, so no
+		// attempt can be checked against it.
+		if h.Corrupt != nil {
+			return fmt.Errorf("%w %v of payment %v: %v",
+				ErrCorruptHTLCAttempt, h.AttemptID,
+				payment.Info.PaymentIdentifier, h.Corrupt.Err)
+		}
+
+		hMpp := h.Route.FinalHop().MPP
+
+		// For a blinded payment, the shards must agree on the total
+		// amount of the blinded path.
+		if isBlinded {
+			if hMpp != nil {
+				return ErrMPPRecordInBlindedPayment
+			}
+
+			hTotal := h.Route.FinalHop().TotalAmtMsat
+			if finalHop.TotalAmtMsat != hTotal {
+				return ErrBlindedPaymentTotalAmountMismatch
+			}
+
+			continue
+		}
+
+		switch {
+		// We tried to register a non-MPP attempt for a MPP payment.
+		case mpp == nil && hMpp != nil:
+			return ErrMPPayment
+
+		// We tried to register a MPP shard for a non-MPP payment.
+		case mpp != nil && hMpp == nil:
+			return ErrNonMPPayment
+
+		// Non-MPP payment, nothing more to validate.
+		case mpp == nil:
+			continue
+		}
+
+		// Check that MPP options match.
+		if mpp.PaymentAddr() != hMpp.PaymentAddr() {
+			return ErrMPPPaymentAddrMismatch
+		}
+
+		if mpp.TotalMsat() != hMpp.TotalMsat() {
+			return ErrMPPTotalAmountMismatch
+		}
+	}
+
+	// If this is a non-MPP attempt, it must match the total amount exactly.
+	// A blinded attempt is treated like an MPP shard.
+	amt := attempt.Route.ReceiverAmt()
+	if mpp == nil && !isBlinded && amt != payment.Info.Value {
+		return ErrValueMismatch
+	}
+
+	// Ensure we aren't sending more than the total payment amount.
+	sentAmt, _ := payment.SentAmt()
+	if sentAmt+amt > payment.Info.Value {
+		return fmt.Errorf("%w: attempted=%v, sent=%v, total=%v",
+			ErrValueExceedsAmt, amt, sentAmt, payment.Info.Value)
+	}
+
+	return nil
 }
 
 // SettleAttempt marks the given attempt settled with the preimage. If this is
@@ -412,41 +1124,91 @@ func (p *PaymentControl) RegisterAttempt(paymentHash lntypes.Hash,
 // After invoking this method, InitPayment should always return an error to
 // prevent us from making duplicate payments to the same payment hash. The
 // provided preimage is atomically saved to the DB for record keeping.
+//
+// The updated payment is returned together with the settled attempt.
 func (p *PaymentControl) SettleAttempt(hash lntypes.Hash,
-	attemptID uint64, settleInfo *HTLCSettleInfo) (*MPPayment, error) {
+	attemptID uint64, settleInfo *HTLCSettleInfo) (*MPPayment,
+	*HTLCAttempt, error) {
 
 	var b bytes.Buffer
 	if err := serializeHTLCSettleInfo(&b, settleInfo); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	settleBytes := b.Bytes()
 
-	return p.updateHtlcKey(hash, attemptID, htlcSettleInfoKey, settleBytes)
+	return p.updateHtlcKey(
+		hash, attemptID, htlcSettleInfoKey, settleBytes, nil,
+		AttemptEventSettled,
+	)
 }
 
-// FailAttempt marks the given payment attempt failed.
+// FailAttempt marks the given payment attempt failed. The updated payment is
+// returned together with the failed attempt.
 func (p *PaymentControl) FailAttempt(hash lntypes.Hash,
-	attemptID uint64, failInfo *HTLCFailInfo) (*MPPayment, error) {
+	attemptID uint64, failInfo *HTLCFailInfo) (*MPPayment, *HTLCAttempt,
+	error) {
 
 	var b bytes.Buffer
 	if err := serializeHTLCFailInfo(&b, failInfo); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	failBytes := b.Bytes()
 
-	return p.updateHtlcKey(hash, attemptID, htlcFailInfoKey, failBytes)
+	return p.updateHtlcKey(
+		hash, attemptID, htlcFailInfoKey, failBytes,
+		checkFailureSource(failInfo), AttemptEventFailed,
+	)
 }
 
-// updateHtlcKey updates a database key for the specified htlc.
+// checkFailureSource returns a check that the failure source of the given
+// fail info is a node on the route of the failed attempt. The route of a
+// quarantined attempt can't be trusted, so the index can't be checked against
+// it.
+func checkFailureSource(failInfo *HTLCFailInfo) func(*HTLCAttempt) error {
+	return func(a *HTLCAttempt) error {
+		if a.Corrupt != nil {
+			return nil
+		}
+
+		_, err := failureSourceNode(
+			&a.Route, failInfo.FailureSourceIndex,
+		)
+
+		return err
+	}
+}
+
+// AbandonAttempt marks the given payment attempt as abandoned. Like a failed
+// attempt, an abandoned attempt no longer counts towards the amount in
+// flight, allowing the payment to retry the amount. The updated payment is
+// returned together with the abandoned attempt.
+func (p *PaymentControl) AbandonAttempt(hash lntypes.Hash,
+	attemptID uint64) (*MPPayment, *HTLCAttempt, error) {
+
+	return p.FailAttempt(hash, attemptID, &HTLCFailInfo{
+		FailTime: p.db.clock.Now(),
+		Reason:   HTLCFailAbandoned,
+	})
+}
+
+// updateHtlcKey updates a database key for the specified htlc and returns the
+// updated payment along with the updated htlc. If check is non-nil, it is
+// called with the attempt before the update, aborting it on error. The update
+// is notified as the given event of the attempt.
 func (p *PaymentControl) updateHtlcKey(paymentHash lntypes.Hash,
-	attemptID uint64, key, value []byte) (*MPPayment, error) {
+	attemptID uint64, key, value []byte, check func(*HTLCAttempt) error,
+	event AttemptEvent) (*MPPayment, *HTLCAttempt, error) {
 
-	aid := make([]byte, 8)
-	binary.BigEndian.PutUint64(aid, attemptID)
+	p.db.paymentCache.lock(paymentHash)
+	defer p.db.paymentCache.unlock(paymentHash)
 
-	var payment *MPPayment
+	var (
+		payment *MPPayment
+		attempt *HTLCAttempt
+	)
 	err := kvdb.Batch(p.db.Backend, func(tx kvdb.RwTx) error {
 		payment = nil
+		attempt = nil
 
 		prefetchPayment(tx, paymentHash)
 		bucket, err := fetchPaymentBucketUpdate(tx, paymentHash)
@@ -471,53 +1233,278 @@ func (p *PaymentControl) updateHtlcKey(paymentHash lntypes.Hash,
 			return fmt.Errorf("htlcs bucket not found")
 		}
 
-		if htlcsBucket.Get(htlcBucketKey(htlcAttemptInfoKey, aid)) == nil {
-			return fmt.Errorf("HTLC with ID %v not registered",
-				attemptID)
+		err = putHtlcResolution(
+			htlcsBucket, p, attemptID, key, value, check,
+		)
+		if err != nil {
+			return err
 		}
 
-		// Make sure the shard is not already failed or settled.
-		if htlcsBucket.Get(htlcBucketKey(htlcFailInfoKey, aid)) != nil {
-			return ErrAttemptAlreadyFailed
+		// Retrieve attempt info for the notification.
+		payment, err = fetchPayment(bucket)
+		if err != nil {
+			return err
 		}
 
-		if htlcsBucket.Get(htlcBucketKey(htlcSettleInfoKey, aid)) != nil {
-			return ErrAttemptAlreadySettled
+		err = putPaymentState(bucket, payment.State)
+		if err != nil {
+			return err
 		}
 
-		// Add or update the key for this htlc.
-		err = htlcsBucket.Put(htlcBucketKey(key, aid), value)
+		// The payment is terminated once its last htlc is resolved.
+		err = updateInFlightIndex(
+			tx, paymentHash, !payment.Terminated(),
+		)
 		if err != nil {
 			return err
 		}
 
-		// Retrieve attempt info for the notification.
-		payment, err = fetchPayment(bucket)
+		attempt, err = payment.GetAttempt(attemptID)
 		return err
 	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p.db.paymentCache.update(payment)
+
+	p.notify(payment, &attemptID, event)
+
+	return payment, attempt, nil
+}
+
+// putHtlcResolution stores the given settle or fail info of the attempt with
+// the given ID under the given key. The attempt must be registered with the
+// payment and must not be resolved yet. If check is non-nil, it is called with
+// the attempt as read with the payment, aborting the update on error.
+func putHtlcResolution(htlcsBucket kvdb.RwBucket, payment *MPPayment,
+	attemptID uint64, key, value []byte,
+	check func(*HTLCAttempt) error) error {
+
+	aid := make([]byte, 8)
+	binary.BigEndian.PutUint64(aid, attemptID)
+
+	if htlcsBucket.Get(htlcBucketKey(htlcAttemptInfoKey, aid)) == nil {
+		return fmt.Errorf("HTLC with ID %v not registered", attemptID)
+	}
+
+	// Make sure the shard is not already failed or settled.
+	if htlcsBucket.Get(htlcBucketKey(htlcFailInfoKey, aid)) != nil {
+		return ErrAttemptAlreadyFailed
+	}
+
+	if htlcsBucket.Get(htlcBucketKey(htlcSettleInfoKey, aid)) != nil {
+		return ErrAttemptAlreadySettled
+	}
+
+	if check != nil {
+		a, err := payment.GetAttempt(attemptID)
+		if err != nil {
+			return err
+		}
+
+		if err := check(a); err != nil {
+			return err
+		}
+	}
+
+	// Add or update the key for this htlc.
+	return htlcsBucket.Put(htlcBucketKey(key, aid), value)
+}
+
+// AttemptResolution is the outcome of a single htlc attempt, to be recorded
+// by ResolveAttempts. Exactly one of Settle and Failure must be set.
+type AttemptResolution struct {
+	// AttemptID is the ID of the resolved attempt.
+	AttemptID uint64
+
+	// Settle is the settle info of the attempt, if it was settled.
+	Settle *HTLCSettleInfo
+
+	// Failure is the fail info of the attempt, if it failed.
+	Failure *HTLCFailInfo
+}
+
+// ResolveAttempts settles or fails multiple attempts of a payment in a single
+// database transaction and returns the resulting payment. Each resolution is
+// validated like the ones of SettleAttempt and FailAttempt. If any of them is
+// invalid, for example because its attempt is already resolved, none of the
+// resolutions is recorded and the error is returned.
+//
+// The notifier is called once per resolved attempt after the transaction is
+// committed, each time with the payment as of the whole batch.
+func (p *PaymentControl) ResolveAttempts(paymentHash lntypes.Hash,
+	resolutions []AttemptResolution) (*MPPayment, error) {
+
+	if len(resolutions) == 0 {
+		return nil, fmt.Errorf("no attempt resolutions given")
+	}
+
+	// Serialize the resolutions up front, so the transaction only has to
+	// validate and store them.
+	type htlcUpdate struct {
+		key, value []byte
+		check      func(*HTLCAttempt) error
+		event      AttemptEvent
+	}
+	updates := make([]htlcUpdate, len(resolutions))
+	for i, r := range resolutions {
+		var b bytes.Buffer
+		switch {
+		case r.Settle != nil && r.Failure != nil:
+			return nil, fmt.Errorf("attempt %d is both settled "+
+				"and failed", r.AttemptID)
+
+		case r.Settle != nil:
+			err := serializeHTLCSettleInfo(&b, r.Settle)
+			if err != nil {
+				return nil, err
+			}
+
+			updates[i] = htlcUpdate{
+				key:   htlcSettleInfoKey,
+				event: AttemptEventSettled,
+			}
+
+		case r.Failure != nil:
+			err := serializeHTLCFailInfo(&b, r.Failure)
+			if err != nil {
+				return nil, err
+			}
+
+			updates[i] = htlcUpdate{
+				key:   htlcFailInfoKey,
+				check: checkFailureSource(r.Failure),
+				event: AttemptEventFailed,
+			}
+
+		default:
+			return nil, fmt.Errorf("attempt %d is neither "+
+				"settled nor failed", r.AttemptID)
+		}
+		updates[i].value = b.Bytes()
+	}
+
+	p.db.paymentCache.lock(paymentHash)
+	defer p.db.paymentCache.unlock(paymentHash)
+
+	var payment *MPPayment
+	err := kvdb.Batch(p.db.Backend, func(tx kvdb.RwTx) error {
+		payment = nil
+
+		prefetchPayment(tx, paymentHash)
+		bucket, err := fetchPaymentBucketUpdate(tx, paymentHash)
+		if err != nil {
+			return err
+		}
+
+		current, err := fetchPayment(bucket)
+		if err != nil {
+			return err
+		}
+
+		// Like single attempts, the attempts of terminated payments
+		// can still be resolved.
+		if err := current.Status.updatable(); err != nil {
+			return err
+		}
+
+		htlcsBucket := bucket.NestedReadWriteBucket(paymentHtlcsBucket)
+		if htlcsBucket == nil {
+			return fmt.Errorf("htlcs bucket not found")
+		}
+
+		// An attempt that is resolved twice within the batch is
+		// rejected by the second resolution, as the first one was
+		// already stored.
+		for i, u := range updates {
+			err := putHtlcResolution(
+				htlcsBucket, current, resolutions[i].AttemptID,
+				u.key, u.value, u.check,
+			)
+			if err != nil {
+				return fmt.Errorf("attempt %d: %w",
+					resolutions[i].AttemptID, err)
+			}
+		}
+
+		payment, err = fetchPayment(bucket)
+		if err != nil {
+			return err
+		}
+
+		err = putPaymentState(bucket, payment.State)
+		if err != nil {
+			return err
+		}
+
+		// The payment is terminated once its last htlc is resolved.
+		return updateInFlightIndex(
+			tx, paymentHash, !payment.Terminated(),
+		)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return payment, err
+	p.db.paymentCache.update(payment)
+
+	for i := range resolutions {
+		attemptID := resolutions[i].AttemptID
+		p.notify(payment, &attemptID, updates[i].event)
+	}
+
+	return payment, nil
 }
 
 // Fail transitions a payment into the Failed state, and records the reason the
 // payment failed. After invoking this method, InitPayment should return nil on
 // its next call for this payment hash, allowing the switch to make a
 // subsequent payment.
+//
+// Failing a payment is idempotent, so it's safe to retry: if the payment
+// already failed with the same reason, nothing is written and the payment is
+// returned as is. If it already failed with a different reason,
+// ErrPaymentAlreadyFailed is returned and the first reason is kept.
 func (p *PaymentControl) Fail(paymentHash lntypes.Hash,
 	reason FailureReason) (*MPPayment, error) {
 
+	return p.FailWithDetail(paymentHash, reason, nil)
+}
+
+// FailWithDetail fails the payment like Fail, additionally storing the given
+// diagnostics of the failure along with its reason, if not nil. The detail
+// must not exceed MaxPaymentFailureDetailSize once serialized. If the payment
+// already failed, the detail of its first failure is kept.
+func (p *PaymentControl) FailWithDetail(paymentHash lntypes.Hash,
+	reason FailureReason, detail *PaymentFailureDetail) (*MPPayment,
+	error) {
+
+	// Serialize the detail before opening the db transaction, rejecting it
+	// if it's too large.
+	var detailBytes []byte
+	if detail != nil {
+		var err error
+		detailBytes, err = serializePaymentFailureDetail(detail)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	p.db.paymentCache.lock(paymentHash)
+	defer p.db.paymentCache.unlock(paymentHash)
+
 	var (
 		updateErr error
 		payment   *MPPayment
+		unchanged bool
 	)
 	err := kvdb.Batch(p.db.Backend, func(tx kvdb.RwTx) error {
 		// Reset the update error, to avoid carrying over an error
 		// from a previous execution of the batched db transaction.
 		updateErr = nil
 		payment = nil
+		unchanged = false
 
 		prefetchPayment(tx, paymentHash)
 		bucket, err := fetchPaymentBucketUpdate(tx, paymentHash)
@@ -540,6 +1527,19 @@ func (p *PaymentControl) Fail(paymentHash lntypes.Hash,
 			return err
 		}
 
+		// A payment that already failed keeps its failure reason.
+		if b := bucket.Get(paymentFailInfoKey); b != nil {
+			if FailureReason(b[0]) != reason {
+				updateErr = ErrPaymentAlreadyFailed
+				return nil
+			}
+
+			unchanged = true
+			payment, err = fetchPayment(bucket)
+
+			return err
+		}
+
 		// Put the failure reason in the bucket for record keeping.
 		v := []byte{byte(reason)}
 		err = bucket.Put(paymentFailInfoKey, v)
@@ -547,25 +1547,181 @@ func (p *PaymentControl) Fail(paymentHash lntypes.Hash,
 			return err
 		}
 
+		// Store the diagnostics of the failure alongside, if any.
+		if detailBytes != nil {
+			err = bucket.Put(paymentFailDetailKey, detailBytes)
+			if err != nil {
+				return err
+			}
+		}
+
 		// Retrieve attempt info for the notification, if available.
 		payment, err = fetchPayment(bucket)
 		if err != nil {
 			return err
 		}
 
-		return nil
+		// The payment stays in flight until its in-flight htlcs are
+		// resolved.
+		return updateInFlightIndex(
+			tx, paymentHash, !payment.Terminated(),
+		)
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	return payment, updateErr
+	// Nothing was written if the payment isn't known or already failed
+	// with another reason.
+	if updateErr != nil {
+		return nil, updateErr
+	}
+
+	// Subscribers were already notified of the failure.
+	if unchanged {
+		return payment, nil
+	}
+
+	p.db.paymentCache.update(payment)
+
+	p.notify(payment, nil, AttemptEventNone)
+
+	return payment, nil
+}
+
+// AbandonPayment forces a payment that can't make progress anymore into the
+// Failed state. All of its in-flight HTLC attempts are marked as abandoned,
+// and the payment is failed with FailureReasonCanceled, all within a single
+// transaction. A payment that already failed keeps its first failure reason. A
+// payment that has a settled HTLC can't be abandoned, in which case
+// ErrPaymentPendingSettled is returned.
+//
+// NOTE: The caller must make sure the abandoned attempts are truly gone, as
+// their eventual results can no longer be recorded.
+func (p *PaymentControl) AbandonPayment(paymentHash lntypes.Hash) error {
+	p.db.paymentCache.lock(paymentHash)
+	defer p.db.paymentCache.unlock(paymentHash)
+
+	var (
+		payment   *MPPayment
+		abandoned []uint64
+	)
+	err := kvdb.Update(p.db, func(tx kvdb.RwTx) error {
+		bucket, err := fetchPaymentBucketUpdate(tx, paymentHash)
+		if err != nil {
+			return err
+		}
+
+		payment, err = fetchPayment(bucket)
+		if err != nil {
+			return err
+		}
+
+		// We can't abandon a payment whose preimage we already have.
+		if payment.State.HasSettledHTLC {
+			return ErrPaymentPendingSettled
+		}
+
+		if err := payment.Status.updatable(); err != nil {
+			return err
+		}
+
+		inFlight := payment.InFlightHTLCs()
+		if len(inFlight) > 0 {
+			var b bytes.Buffer
+			err := serializeHTLCFailInfo(&b, &HTLCFailInfo{
+				FailTime: p.db.clock.Now(),
+				Reason:   HTLCFailAbandoned,
+			})
+			if err != nil {
+				return err
+			}
+			failBytes := b.Bytes()
+
+			htlcsBucket := bucket.NestedReadWriteBucket(
+				paymentHtlcsBucket,
+			)
+			for _, a := range inFlight {
+				aid := make([]byte, 8)
+				binary.BigEndian.PutUint64(aid, a.AttemptID)
+
+				err := htlcsBucket.Put(
+					htlcBucketKey(htlcFailInfoKey, aid),
+					failBytes,
+				)
+				if err != nil {
+					return err
+				}
+
+				abandoned = append(abandoned, a.AttemptID)
+			}
+		}
+
+		// A payment that already failed, for example because it timed
+		// out while its attempts were stuck, keeps its first reason.
+		if bucket.Get(paymentFailInfoKey) == nil {
+			err = bucket.Put(
+				paymentFailInfoKey,
+				[]byte{byte(FailureReasonCanceled)},
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		// The abandoned attempts no longer count as sent.
+		payment, err = fetchPayment(bucket)
+		if err != nil {
+			return err
+		}
+
+		err = putPaymentState(bucket, payment.State)
+		if err != nil {
+			return err
+		}
+
+		return updateInFlightIndex(tx, paymentHash, false)
+	}, func() {
+		payment = nil
+		abandoned = nil
+	})
+	if err != nil {
+		return err
+	}
+
+	p.db.paymentCache.update(payment)
+
+	// Notify the abandoned attempts, or the failure of the payment itself
+	// if it had no attempts in flight.
+	if len(abandoned) == 0 {
+		p.notify(payment, nil, AttemptEventNone)
+	}
+	for i := range abandoned {
+		p.notify(payment, &abandoned[i], AttemptEventFailed)
+	}
+
+	return nil
 }
 
 // FetchPayment returns information about a payment from the database.
 func (p *PaymentControl) FetchPayment(paymentHash lntypes.Hash) (
 	*MPPayment, error) {
 
+	// Payments that are still being sent are served from the cache, if
+	// it's enabled.
+	if payment, ok := p.db.paymentCache.get(paymentHash); ok {
+		if err := p.db.checkQuarantine(payment); err != nil {
+			return nil, err
+		}
+
+		return payment, nil
+	}
+
+	// Hold the lock of the payment while reading it, so it can't be
+	// updated before we've put what we read into the cache.
+	p.db.paymentCache.lock(paymentHash)
+	defer p.db.paymentCache.unlock(paymentHash)
+
 	var payment *MPPayment
 	err := kvdb.View(p.db, func(tx kvdb.RTx) error {
 		prefetchPayment(tx, paymentHash)
@@ -574,16 +1730,151 @@ func (p *PaymentControl) FetchPayment(paymentHash lntypes.Hash) (
 			return err
 		}
 
-		payment, err = fetchPayment(bucket)
+		payment, err = fetchPayment(bucket)
+		if err != nil {
+			return err
+		}
+
+		return p.db.checkQuarantine(payment)
+	}, func() {
+		payment = nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	p.db.paymentCache.update(payment)
+
+	return payment, nil
+}
+
+// FetchPaymentStatus returns the status of the payment with the given hash.
+// Unlike FetchPayment, it doesn't deserialize the payment's attempts, which
+// makes it cheap enough for hot paths that only need the status.
+// ErrPaymentNotInitiated is returned if the payment doesn't exist.
+func (p *PaymentControl) FetchPaymentStatus(ctx context.Context,
+	paymentHash lntypes.Hash) (PaymentStatus, error) {
+
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var status PaymentStatus
+	err := kvdb.View(p.db, func(tx kvdb.RTx) error {
+		bucket, err := fetchPaymentBucket(tx, paymentHash)
+		if err != nil {
+			return err
+		}
+
+		status, err = fetchPaymentStatus(bucket)
+
+		return err
+	}, func() {
+		status = 0
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return status, nil
+}
+
+// ReconcilePayment re-derives the state of the payment with the given hash
+// strictly from its stored attempts and repairs the state that is stored
+// separately from the payment: the entries of the payments index pointing to
+// it and its entry in the in-flight index. The cached state of the payment, if
+// any, is replaced as well. The corrections are logged, and nothing is written
+// for a healthy payment. The reconciled payment is returned.
+//
+// NOTE: This is a repair tool for operators, it isn't needed in normal
+// operation.
+func (p *PaymentControl) ReconcilePayment(ctx context.Context,
+	paymentHash lntypes.Hash) (*MPPayment, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	p.db.paymentCache.lock(paymentHash)
+	defer p.db.paymentCache.unlock(paymentHash)
+
+	var (
+		payment     *MPPayment
+		corrections []string
+	)
+	err := kvdb.Update(p.db, func(tx kvdb.RwTx) error {
+		payment = nil
+		corrections = nil
+
+		bucket, err := fetchPaymentBucketUpdate(tx, paymentHash)
+		if err != nil {
+			return err
+		}
+
+		// The state of the payment is always computed from its
+		// attempts when it's read.
+		payment, err = fetchPayment(bucket)
+		if err != nil {
+			return err
+		}
+
+		// Restore the missing payments index entries of the payment
+		// and its duplicates.
+		seqNrs, err := fetchSequenceNumbers(bucket)
+		if err != nil {
+			return err
+		}
+
+		indexes := tx.ReadWriteBucket(paymentsIndexBucket)
+		for _, seqNr := range seqNrs {
+			if indexes.Get(seqNr) != nil {
+				continue
+			}
+
+			err := createPaymentIndexEntry(tx, seqNr, paymentHash)
+			if err != nil {
+				return err
+			}
+
+			corrections = append(corrections, fmt.Sprintf(
+				"restored payments index entry %d",
+				byteOrder.Uint64(seqNr),
+			))
+		}
+
+		// Make sure the payment is in the in-flight index exactly if
+		// it isn't terminated, if the index was built already.
+		inFlight := tx.ReadBucket(paymentsInFlightIndexBucket)
+		if inFlight == nil {
+			return nil
+		}
+
+		indexed := inFlight.Get(paymentHash[:]) != nil
+		if indexed == !payment.Terminated() {
+			return nil
+		}
+
+		corrections = append(corrections, fmt.Sprintf(
+			"set in-flight index entry to %v for status %v",
+			!indexed, payment.Status,
+		))
 
-		return err
+		return updateInFlightIndex(tx, paymentHash, !indexed)
 	}, func() {
 		payment = nil
+		corrections = nil
 	})
 	if err != nil {
 		return nil, err
 	}
 
+	// Replace any stale cached state of the payment.
+	p.db.paymentCache.update(payment)
+
+	for _, c := range corrections {
+		log.Infof("Reconciled payment %v: %v", paymentHash, c)
+	}
+
 	return payment, nil
 }
 
@@ -607,6 +1898,55 @@ func prefetchPayment(tx kvdb.RTx, paymentHash lntypes.Hash) {
 	)
 }
 
+// paymentPrefetchChunkSize is the number of payments that are prefetched at
+// once by the operations that read many payments.
+const paymentPrefetchChunkSize = 100
+
+// prefetchPayments prefetches the buckets of all the given payments in a
+// single request, which reduces the DB roundtrips of reading many payments
+// compared to prefetching them one by one.
+func prefetchPayments(tx kvdb.RTx, paymentHashes []lntypes.Hash) {
+	paths := make([][]string, 0, 2*len(paymentHashes))
+	for _, hash := range paymentHashes {
+		paths = append(paths,
+			[]string{
+				string(paymentsRootBucket),
+				string(hash[:]),
+			},
+			[]string{
+				string(paymentsRootBucket),
+				string(hash[:]),
+				string(paymentHtlcsBucket),
+			},
+		)
+	}
+
+	kvdb.Prefetch(kvdb.RootBucket(tx), paths...)
+}
+
+// forEachPrefetched calls the given callback for each of the given payment
+// hashes in order. The payments are prefetched in chunks of
+// paymentPrefetchChunkSize payments before the callback is called for them.
+func forEachPrefetched(tx kvdb.RTx, paymentHashes []lntypes.Hash,
+	cb func(lntypes.Hash) error) error {
+
+	for start := 0; start < len(paymentHashes); {
+		end := min(start+paymentPrefetchChunkSize, len(paymentHashes))
+		chunk := paymentHashes[start:end]
+
+		prefetchPayments(tx, chunk)
+		for _, hash := range chunk {
+			if err := cb(hash); err != nil {
+				return err
+			}
+		}
+
+		start = end
+	}
+
+	return nil
+}
+
 // createPaymentBucket creates or fetches the sub-bucket assigned to this
 // payment hash.
 func createPaymentBucket(tx kvdb.RwTx, paymentHash lntypes.Hash) (
@@ -701,6 +2041,141 @@ func (p *PaymentControl) nextPaymentSequence() ([]byte, error) {
 	return b, nil
 }
 
+// Close releases the payment sequence numbers that were reserved in the
+// database but not handed out yet, so the sequence numbers of payments sent
+// after a restart continue without a gap. The reservation is only released if
+// no other block was reserved after it. If Close isn't called, for example
+// because lnd crashed, the unused sequence numbers are skipped instead. The
+// PaymentControl can still be used after it was closed.
+func (p *PaymentControl) Close() error {
+	p.paymentSeqMx.Lock()
+	defer p.paymentSeqMx.Unlock()
+
+	// There's nothing to release if no sequence numbers were reserved or
+	// all of them were handed out.
+	if p.currPaymentSeq == p.storedPaymentSeq {
+		return nil
+	}
+
+	var released bool
+	err := kvdb.Update(p.db.Backend, func(tx kvdb.RwTx) error {
+		paymentsBucket := tx.ReadWriteBucket(paymentsRootBucket)
+		if paymentsBucket == nil {
+			return nil
+		}
+
+		// Another instance might have reserved a block after ours, in
+		// which case lowering the sequence would hand out its numbers
+		// twice.
+		if paymentsBucket.Sequence() != p.storedPaymentSeq {
+			return nil
+		}
+
+		released = true
+
+		return paymentsBucket.SetSequence(p.currPaymentSeq)
+	}, func() {
+		released = false
+	})
+	if err != nil {
+		return err
+	}
+
+	if released {
+		log.Debugf("Released payment sequence numbers %d to %d",
+			p.currPaymentSeq+1, p.storedPaymentSeq)
+	}
+
+	// The next payment reserves a new block, starting after the last
+	// sequence number we handed out.
+	p.storedPaymentSeq = p.currPaymentSeq
+
+	return nil
+}
+
+// NextAttemptID returns a new unique ID for an htlc attempt. IDs are reserved
+// in the database in blocks, to avoid conflicts on the counter when using a
+// remote database. IDs of a block that weren't handed out before a restart are
+// skipped, so an ID is never issued twice.
+func (p *PaymentControl) NextAttemptID(ctx context.Context) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	p.attemptIDMx.Lock()
+	defer p.attemptIDMx.Unlock()
+
+	// Hand out the next ID of the current block, if any are left.
+	if p.nextAttemptID < p.attemptIDHorizon {
+		id := p.nextAttemptID
+		p.nextAttemptID++
+
+		return id, nil
+	}
+
+	// Otherwise reserve a new block.
+	var next, horizon uint64
+	err := kvdb.Update(p.db.Backend, func(tx kvdb.RwTx) error {
+		bucket, err := tx.CreateTopLevelBucket(nextAttemptIDBucket)
+		if err != nil {
+			return err
+		}
+
+		next = bucket.Sequence()
+		horizon = next + attemptIDBlockSize
+
+		return bucket.SetSequence(horizon)
+	}, func() {
+		next, horizon = 0, 0
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Attempt ID zero is never issued.
+	if next == 0 {
+		next++
+	}
+
+	p.nextAttemptID = next + 1
+	p.attemptIDHorizon = horizon
+
+	return next, nil
+}
+
+// LatestAttemptID returns the highest attempt ID that has been reserved in the
+// database. Since IDs are reserved in blocks, this is an upper bound on the
+// IDs issued so far, rather than the ID that was issued last. Zero is
+// returned if no ID was ever reserved.
+func (p *PaymentControl) LatestAttemptID(ctx context.Context) (uint64,
+	error) {
+
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	// The sequence of a bucket can only be read through a read-write
+	// bucket, so we use an update transaction that doesn't write anything.
+	var latest uint64
+	err := kvdb.Update(p.db.Backend, func(tx kvdb.RwTx) error {
+		bucket := tx.ReadWriteBucket(nextAttemptIDBucket)
+		if bucket == nil || bucket.Sequence() == 0 {
+			return nil
+		}
+
+		latest = bucket.Sequence() - 1
+
+		return nil
+	}, func() {
+		latest = 0
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return latest, nil
+}
+
 // fetchPaymentStatus fetches the payment status of the payment. If the payment
 // isn't found, it will return error `ErrPaymentNotInitiated`.
 func fetchPaymentStatus(bucket kvdb.RBucket) (PaymentStatus, error) {
@@ -710,42 +2185,206 @@ func fetchPaymentStatus(bucket kvdb.RBucket) (PaymentStatus, error) {
 		return 0, ErrPaymentNotInitiated
 	}
 
-	payment, err := fetchPayment(bucket)
+	// Only the resolution of the attempts and the failure reason of the
+	// payment are needed to decide its status, so we don't deserialize the
+	// attempts themselves.
+	var (
+		htlcs []HTLCAttempt
+		err   error
+	)
+	htlcsBucket := bucket.NestedReadBucket(paymentHtlcsBucket)
+	if htlcsBucket != nil {
+		htlcs, err = fetchHtlcResolutions(htlcsBucket)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var failureReason *FailureReason
+	if b := bucket.Get(paymentFailInfoKey); b != nil {
+		reason := FailureReason(b[0])
+		failureReason = &reason
+	}
+
+	return decidePaymentStatus(htlcs, failureReason)
+}
+
+// fetchHtlcResolutions returns the htlc attempts found in the given bucket,
+// with only their settle or failure info set to a non-nil, empty value if the
+// attempt was resolved. Only the keys of the bucket are inspected.
+func fetchHtlcResolutions(bucket kvdb.RBucket) ([]HTLCAttempt, error) {
+	htlcsMap := make(map[uint64]*HTLCAttempt)
+	err := bucket.ForEach(func(k, _ []byte) error {
+		aid := byteOrder.Uint64(k[len(k)-8:])
+
+		htlc, ok := htlcsMap[aid]
+		if !ok {
+			htlc = &HTLCAttempt{}
+			htlc.AttemptID = aid
+			htlcsMap[aid] = htlc
+		}
+
+		switch {
+		// The attempt info doesn't affect the status of the payment.
+		case bytes.HasPrefix(k, htlcAttemptInfoKey):
+		case bytes.HasPrefix(k, htlcSettleInfoKey):
+			htlc.Settle = &HTLCSettleInfo{}
+
+		case bytes.HasPrefix(k, htlcFailInfoKey):
+			htlc.Failure = &HTLCFailInfo{}
+
+		default:
+			return fmt.Errorf("unknown htlc attempt key")
+		}
+
+		return nil
+	})
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+
+	htlcs := make([]HTLCAttempt, 0, len(htlcsMap))
+	for _, htlc := range htlcsMap {
+		htlcs = append(htlcs, *htlc)
 	}
 
-	return payment.Status, nil
+	return htlcs, nil
 }
 
-// FetchInFlightPayments returns all payments with status InFlight.
-func (p *PaymentControl) FetchInFlightPayments() ([]*MPPayment, error) {
-	var inFlights []*MPPayment
+// updateInFlightIndex adds the payment with the given hash to the in-flight
+// index if inFlight is set, and removes it from the index otherwise. Nothing
+// is done if the index hasn't been built yet.
+func updateInFlightIndex(tx kvdb.RwTx, paymentHash lntypes.Hash,
+	inFlight bool) error {
+
+	index := tx.ReadWriteBucket(paymentsInFlightIndexBucket)
+	if index == nil {
+		return nil
+	}
+
+	if inFlight {
+		return index.Put(paymentHash[:], []byte{})
+	}
+
+	return index.Delete(paymentHash[:])
+}
+
+// buildInFlightIndex builds the in-flight index from all stored payments if
+// the database was created before the index existed. This is done once, as
+// the index is kept up to date by the payment updates from then on.
+func (p *PaymentControl) buildInFlightIndex(ctx context.Context) error {
+	var built bool
 	err := kvdb.View(p.db, func(tx kvdb.RTx) error {
+		built = tx.ReadBucket(paymentsInFlightIndexBucket) != nil
+
+		return nil
+	}, func() {
+		built = false
+	})
+	if err != nil || built {
+		return err
+	}
+
+	log.Infof("Building index of in-flight payments")
+
+	var numInFlight int
+	err = kvdb.Update(p.db, func(tx kvdb.RwTx) error {
+		// Another caller might have built the index in the meantime.
+		if tx.ReadWriteBucket(paymentsInFlightIndexBucket) != nil {
+			return nil
+		}
+
+		index, err := tx.CreateTopLevelBucket(
+			paymentsInFlightIndexBucket,
+		)
+		if err != nil {
+			return err
+		}
+
 		payments := tx.ReadBucket(paymentsRootBucket)
 		if payments == nil {
 			return nil
 		}
 
+		checkCancel := newScanCancelCheck(ctx)
 		return payments.ForEach(func(k, _ []byte) error {
+			if err := checkCancel(); err != nil {
+				return err
+			}
+
 			bucket := payments.NestedReadBucket(k)
 			if bucket == nil {
 				return fmt.Errorf("non bucket element")
 			}
 
-			p, err := fetchPayment(bucket)
+			status, err := fetchPaymentStatus(bucket)
 			if err != nil {
 				return err
 			}
 
 			// Skip the payment if it's terminated.
-			if p.Terminated() {
+			if status.updatable() != nil {
 				return nil
 			}
 
-			inFlights = append(inFlights, p)
+			numInFlight++
+
+			return index.Put(k, []byte{})
+		})
+	}, func() {
+		numInFlight = 0
+	})
+	if err != nil {
+		return fmt.Errorf("unable to build in-flight payments "+
+			"index: %w", err)
+	}
+
+	log.Infof("Indexed %d in-flight payments", numInFlight)
+
+	return nil
+}
+
+// FetchInFlightPayments returns all payments with status InFlight. Only the
+// payments of the in-flight index are read, which is built first if needed.
+// The scan is aborted with an error wrapping the context's error if the
+// context is canceled.
+func (p *PaymentControl) FetchInFlightPayments(
+	ctx context.Context) ([]*MPPayment, error) {
+
+	if err := p.buildInFlightIndex(ctx); err != nil {
+		return nil, err
+	}
+
+	var inFlights []*MPPayment
+	err := kvdb.View(p.db, func(tx kvdb.RTx) error {
+		if tx.ReadBucket(paymentsRootBucket) == nil {
+			return nil
+		}
+
+		index := tx.ReadBucket(paymentsInFlightIndexBucket)
+		if index == nil {
+			return fmt.Errorf("in-flight payments index not found")
+		}
+
+		// Collect the indexed payments first, so they can be
+		// prefetched in chunks.
+		var hashes []lntypes.Hash
+		err := index.ForEach(func(k, _ []byte) error {
+			hash, err := lntypes.MakeHash(k)
+			if err != nil {
+				return err
+			}
+			hashes = append(hashes, hash)
+
 			return nil
 		})
+		if err != nil {
+			return err
+		}
+
+		inFlights, err = p.fetchInFlightPayments(ctx, tx, hashes)
+
+		return err
 	}, func() {
 		inFlights = nil
 	})
@@ -755,3 +2394,187 @@ func (p *PaymentControl) FetchInFlightPayments() ([]*MPPayment, error) {
 
 	return inFlights, nil
 }
+
+// ForEachInFlightPayment calls the given callback for every payment with
+// status InFlight. Unlike FetchInFlightPayments, the payments are read in
+// pages of the given size, each within its own transaction, and handed to the
+// callback as soon as their page was read. A payment that is added to or
+// removed from the in-flight index during the iteration may or may not be
+// visited. The iteration is aborted with the callback's error, or with an
+// error wrapping the context's error if the context is canceled.
+//
+// NOTE: The callback is called outside of any transaction, so it may access
+// the database.
+func (p *PaymentControl) ForEachInFlightPayment(ctx context.Context,
+	pageSize int, cb func(*MPPayment) error) error {
+
+	if pageSize <= 0 {
+		return fmt.Errorf("invalid page size %d", pageSize)
+	}
+
+	if err := p.buildInFlightIndex(ctx); err != nil {
+		return err
+	}
+
+	// The pages are delimited by the hash of the last payment of the
+	// previous page, as the in-flight index is ordered by payment hash.
+	var (
+		lastHash []byte
+		more     = true
+	)
+	for more {
+		var (
+			page     []*MPPayment
+			pageLast []byte
+			pageFull bool
+		)
+		err := kvdb.View(p.db, func(tx kvdb.RTx) error {
+			if tx.ReadBucket(paymentsRootBucket) == nil {
+				return nil
+			}
+
+			index := tx.ReadBucket(paymentsInFlightIndexBucket)
+			if index == nil {
+				return fmt.Errorf("in-flight payments index " +
+					"not found")
+			}
+
+			var (
+				hashes []lntypes.Hash
+				c      = index.ReadCursor()
+				k, _   = c.First()
+			)
+			if lastHash != nil {
+				k, _ = c.Seek(lastHash)
+				if bytes.Equal(k, lastHash) {
+					k, _ = c.Next()
+				}
+			}
+			for k != nil && len(hashes) < pageSize {
+				hash, err := lntypes.MakeHash(k)
+				if err != nil {
+					return err
+				}
+				hashes = append(hashes, hash)
+
+				k, _ = c.Next()
+			}
+
+			// A full page may be followed by another one.
+			pageFull = len(hashes) == pageSize
+			if len(hashes) > 0 {
+				last := hashes[len(hashes)-1]
+				pageLast = last[:]
+			}
+
+			var err error
+			page, err = p.fetchInFlightPayments(ctx, tx, hashes)
+
+			return err
+		}, func() {
+			page = nil
+			pageLast = nil
+			pageFull = false
+		})
+		if err != nil {
+			return err
+		}
+
+		lastHash, more = pageLast, pageFull
+		for _, payment := range page {
+			if err := cb(payment); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// fetchInFlightPayments fetches the payments with the given hashes from the
+// in-flight index. Payments that are terminated are skipped.
+func (p *PaymentControl) fetchInFlightPayments(ctx context.Context,
+	tx kvdb.RTx, hashes []lntypes.Hash) ([]*MPPayment, error) {
+
+	payments := tx.ReadBucket(paymentsRootBucket)
+	if payments == nil {
+		return nil, nil
+	}
+
+	var inFlights []*MPPayment
+	checkCancel := newScanCancelCheck(ctx)
+	err := forEachPrefetched(tx, hashes, func(h lntypes.Hash) error {
+		if err := checkCancel(); err != nil {
+			return err
+		}
+
+		bucket := payments.NestedReadBucket(h[:])
+		if bucket == nil {
+			return fmt.Errorf("in-flight payment %v not found", h)
+		}
+
+		payment, err := fetchPayment(bucket)
+		if err != nil {
+			return err
+		}
+
+		// Skip the payment if it's terminated.
+		if payment.Terminated() {
+			return nil
+		}
+
+		if err := p.db.checkQuarantine(payment); err != nil {
+			return err
+		}
+
+		inFlights = append(inFlights, payment)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return inFlights, nil
+}
+
+// FetchStalePayments returns the in-flight payments that haven't seen a new
+// htlc attempt since the given cutoff, which are likely stuck. The age of a
+// payment is given by the attempt time of its newest attempt, or by its
+// creation time if no attempt was made yet. The payments are ordered by their
+// age, starting with the stalest one.
+func (p *PaymentControl) FetchStalePayments(ctx context.Context,
+	olderThan time.Time) ([]*MPPayment, error) {
+
+	inFlights, err := p.FetchInFlightPayments(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var stale []*MPPayment
+	for _, payment := range inFlights {
+		if lastAttemptTime(payment).Before(olderThan) {
+			stale = append(stale, payment)
+		}
+	}
+
+	sort.SliceStable(stale, func(i, j int) bool {
+		return lastAttemptTime(stale[i]).Before(
+			lastAttemptTime(stale[j]),
+		)
+	})
+
+	return stale, nil
+}
+
+// lastAttemptTime returns the attempt time of the newest htlc attempt of the
+// payment, or its creation time if it has no attempts.
+func lastAttemptTime(payment *MPPayment) time.Time {
+	last := payment.Info.CreationTime
+	for i, h := range payment.HTLCs {
+		if i == 0 || h.AttemptTime.After(last) {
+			last = h.AttemptTime
+		}
+	}
+
+	return last
+}
@@ -2,20 +2,26 @@ package channeldb
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/btcsuite/btcwallet/walletdb"
 	"github.com/davecgh/go-spew/spew"
+	"github.com/lightningnetwork/lnd/clock"
 	"github.com/lightningnetwork/lnd/kvdb"
 	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/lightningnetwork/lnd/lnwire"
 	"github.com/lightningnetwork/lnd/record"
+	"github.com/lightningnetwork/lnd/routing/route"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -41,11 +47,18 @@ func genInfo() (*PaymentCreationInfo, *HTLCAttemptInfo,
 	attempt := NewHtlcAttempt(
 		0, priv, *testRoute.Copy(), time.Time{}, nil,
 	)
+
+	// The payment is sent to the payment address of the route's MPP
+	// record, just like the payment address of an invoice.
+	paymentAddr := testRoute.FinalHop().MPP.PaymentAddr()
+
 	return &PaymentCreationInfo{
 		PaymentIdentifier: rhash,
 		Value:             testRoute.ReceiverAmt(),
 		CreationTime:      time.Unix(time.Now().Unix(), 0),
 		PaymentRequest:    []byte("hola"),
+		FeeLimit:          1000,
+		PaymentAddr:       &paymentAddr,
 	}, &attempt.HTLCAttemptInfo, preimage, nil
 }
 
@@ -115,7 +128,7 @@ func TestPaymentControlSwitchFail(t *testing.T) {
 	require.NoError(t, err, "unable to register attempt")
 
 	htlcReason := HTLCFailUnreadable
-	_, err = pControl.FailAttempt(
+	_, _, err = pControl.FailAttempt(
 		info.PaymentIdentifier, attempt.AttemptID,
 		&HTLCFailInfo{
 			Reason: htlcReason,
@@ -151,7 +164,7 @@ func TestPaymentControlSwitchFail(t *testing.T) {
 
 	// Settle the attempt and verify that status was changed to
 	// StatusSucceeded.
-	payment, err = pControl.SettleAttempt(
+	payment, _, err = pControl.SettleAttempt(
 		info.PaymentIdentifier, attempt.AttemptID,
 		&HTLCSettleInfo{
 			Preimage: preimg,
@@ -238,7 +251,7 @@ func TestPaymentControlSwitchDoubleSend(t *testing.T) {
 	}
 
 	// After settling, the error should be ErrAlreadyPaid.
-	_, err = pControl.SettleAttempt(
+	_, _, err = pControl.SettleAttempt(
 		info.PaymentIdentifier, attempt.AttemptID,
 		&HTLCSettleInfo{
 			Preimage: preimg,
@@ -270,7 +283,7 @@ func TestPaymentControlSuccessesWithoutInFlight(t *testing.T) {
 	require.NoError(t, err, "unable to generate htlc message")
 
 	// Attempt to complete the payment should fail.
-	_, err = pControl.SettleAttempt(
+	_, _, err = pControl.SettleAttempt(
 		info.PaymentIdentifier, 0,
 		&HTLCSettleInfo{
 			Preimage: preimg,
@@ -301,216 +314,114 @@ func TestPaymentControlFailsWithoutInFlight(t *testing.T) {
 	}
 }
 
-// TestPaymentControlDeleteNonInFlight checks that calling DeletePayments only
-// deletes payments from the database that are not in-flight.
-func TestPaymentControlDeleteNonInFlight(t *testing.T) {
+// TestPaymentControlReuseSequenceOnRetry checks the sequence number a failed
+// payment gets when it is initiated again, with and without reusing its
+// previous sequence number.
+func TestPaymentControlReuseSequenceOnRetry(t *testing.T) {
 	t.Parallel()
 
-	db, err := MakeTestDB(t)
-	require.NoError(t, err, "unable to init db")
-
-	// Create a sequence number for duplicate payments that will not collide
-	// with the sequence numbers for the payments we create. These values
-	// start at 1, so 9999 is a safe bet for this test.
-	var duplicateSeqNr = 9999
-
-	pControl := NewPaymentControl(db)
-
-	payments := []struct {
-		failed       bool
-		success      bool
-		hasDuplicate bool
-	}{
-		{
-			failed:       true,
-			success:      false,
-			hasDuplicate: false,
-		},
-		{
-			failed:       false,
-			success:      true,
-			hasDuplicate: false,
-		},
-		{
-			failed:       false,
-			success:      false,
-			hasDuplicate: false,
-		},
-		{
-			failed:       false,
-			success:      true,
-			hasDuplicate: true,
-		},
-	}
-
-	var numSuccess, numInflight int
-
-	for _, p := range payments {
-		info, attempt, preimg, err := genInfo()
-		if err != nil {
-			t.Fatalf("unable to generate htlc message: %v", err)
-		}
-
-		// Sends base htlc message which initiate StatusInFlight.
-		err = pControl.InitPayment(info.PaymentIdentifier, info)
-		if err != nil {
-			t.Fatalf("unable to send htlc message: %v", err)
-		}
-		_, err = pControl.RegisterAttempt(info.PaymentIdentifier, attempt)
-		if err != nil {
-			t.Fatalf("unable to send htlc message: %v", err)
-		}
+	t.Run("reuse", func(t *testing.T) {
+		t.Parallel()
+		testPaymentControlReuseSequenceOnRetry(t, true)
+	})
+	t.Run("no reuse", func(t *testing.T) {
+		t.Parallel()
+		testPaymentControlReuseSequenceOnRetry(t, false)
+	})
+}
 
-		htlc := &htlcStatus{
-			HTLCAttemptInfo: attempt,
-		}
+func testPaymentControlReuseSequenceOnRetry(t *testing.T, reuse bool) {
+	ctx := context.Background()
 
-		if p.failed {
-			// Fail the payment attempt.
-			htlcFailure := HTLCFailUnreadable
-			_, err := pControl.FailAttempt(
-				info.PaymentIdentifier, attempt.AttemptID,
-				&HTLCFailInfo{
-					Reason: htlcFailure,
-				},
-			)
-			if err != nil {
-				t.Fatalf("unable to fail htlc: %v", err)
-			}
+	db, err := MakeTestDB(t, OptionReuseSequenceOnRetry(reuse))
+	require.NoError(t, err, "unable to init db")
 
-			// Fail the payment, which should moved it to Failed.
-			failReason := FailureReasonNoRoute
-			_, err = pControl.Fail(info.PaymentIdentifier, failReason)
-			if err != nil {
-				t.Fatalf("unable to fail payment hash: %v", err)
-			}
+	pControl := NewPaymentControl(db)
 
-			// Verify the status is indeed Failed.
-			assertPaymentStatus(t, pControl, info.PaymentIdentifier, StatusFailed)
+	info, _, _, err := genInfo()
+	require.NoError(t, err, "unable to generate htlc message")
 
-			htlc.failure = &htlcFailure
-			assertPaymentInfo(
-				t, pControl, info.PaymentIdentifier, info,
-				&failReason, htlc,
-			)
-		} else if p.success {
-			// Verifies that status was changed to StatusSucceeded.
-			_, err := pControl.SettleAttempt(
-				info.PaymentIdentifier, attempt.AttemptID,
-				&HTLCSettleInfo{
-					Preimage: preimg,
-				},
-			)
-			if err != nil {
-				t.Fatalf("error shouldn't have been received, got: %v", err)
-			}
+	// Initiate and fail the payment.
+	err = pControl.InitPayment(info.PaymentIdentifier, info)
+	require.NoError(t, err)
 
-			assertPaymentStatus(t, pControl, info.PaymentIdentifier, StatusSucceeded)
+	_, err = pControl.Fail(info.PaymentIdentifier, FailureReasonNoRoute)
+	require.NoError(t, err)
 
-			htlc.settle = &preimg
-			assertPaymentInfo(
-				t, pControl, info.PaymentIdentifier, info, nil, htlc,
-			)
+	payment, err := pControl.FetchPayment(info.PaymentIdentifier)
+	require.NoError(t, err)
+	firstSeq := payment.SequenceNum
 
-			numSuccess++
-		} else {
-			assertPaymentStatus(t, pControl, info.PaymentIdentifier, StatusInFlight)
-			assertPaymentInfo(
-				t, pControl, info.PaymentIdentifier, info, nil, htlc,
-			)
+	// Initiate another payment in between, so a new sequence number of
+	// the retried payment would differ from its first one.
+	other, _, _, err := genInfo()
+	require.NoError(t, err, "unable to generate htlc message")
 
-			numInflight++
-		}
+	err = pControl.InitPayment(other.PaymentIdentifier, other)
+	require.NoError(t, err)
 
-		// If the payment is intended to have a duplicate payment, we
-		// add one.
-		if p.hasDuplicate {
-			appendDuplicatePayment(
-				t, pControl.db, info.PaymentIdentifier,
-				uint64(duplicateSeqNr), preimg,
-			)
-			duplicateSeqNr++
-			numSuccess++
-		}
-	}
+	// Now retry the failed payment.
+	err = pControl.InitPayment(info.PaymentIdentifier, info)
+	require.NoError(t, err)
 
-	// Delete all failed payments.
-	if err := db.DeletePayments(true, false); err != nil {
-		t.Fatal(err)
-	}
+	payment, err = pControl.FetchPayment(info.PaymentIdentifier)
+	require.NoError(t, err)
+	require.Equal(t, StatusInitiated, payment.Status)
 
-	// This should leave the succeeded and in-flight payments.
-	dbPayments, err := db.FetchPayments()
-	if err != nil {
-		t.Fatal(err)
+	if reuse {
+		require.Equal(t, firstSeq, payment.SequenceNum)
+	} else {
+		require.Greater(t, payment.SequenceNum, firstSeq)
 	}
 
-	if len(dbPayments) != numSuccess+numInflight {
-		t.Fatalf("expected %d payments, got %d",
-			numSuccess+numInflight, len(dbPayments))
-	}
+	// Either way, the payment is indexed exactly once under its current
+	// sequence number.
+	resp, err := db.QueryPayments(ctx, PaymentsQuery{
+		MaxPayments:       math.MaxUint64,
+		IncludeIncomplete: true,
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Payments, 2)
 
-	var s, i int
-	for _, p := range dbPayments {
-		t.Log("fetch payment has status", p.Status)
-		switch p.Status {
-		case StatusSucceeded:
-			s++
-		case StatusInFlight:
-			i++
+	var found int
+	for _, p := range resp.Payments {
+		if p.Info.PaymentIdentifier != info.PaymentIdentifier {
+			continue
 		}
-	}
-
-	if s != numSuccess {
-		t.Fatalf("expected %d succeeded payments , got %d",
-			numSuccess, s)
-	}
-	if i != numInflight {
-		t.Fatalf("expected %d in-flight payments, got %d",
-			numInflight, i)
-	}
-
-	// Now delete all payments except in-flight.
-	if err := db.DeletePayments(false, false); err != nil {
-		t.Fatal(err)
-	}
 
-	// This should leave the in-flight payment.
-	dbPayments, err = db.FetchPayments()
-	if err != nil {
-		t.Fatal(err)
+		found++
+		require.Equal(t, payment.SequenceNum, p.SequenceNum)
 	}
+	require.Equal(t, 1, found)
 
-	if len(dbPayments) != numInflight {
-		t.Fatalf("expected %d payments, got %d", numInflight,
-			len(dbPayments))
-	}
+	// A retry that keeps its sequence number doesn't use up a new one, so
+	// the next payment follows the one initiated in between.
+	otherPayment, err := pControl.FetchPayment(other.PaymentIdentifier)
+	require.NoError(t, err)
 
-	for _, p := range dbPayments {
-		if p.Status != StatusInFlight {
-			t.Fatalf("expected in-fligth status, got %v", p.Status)
-		}
-	}
+	next, _, _, err := genInfo()
+	require.NoError(t, err, "unable to generate htlc message")
 
-	// Finally, check that we only have a single index left in the payment
-	// index bucket.
-	var indexCount int
-	err = kvdb.View(db, func(tx walletdb.ReadTx) error {
-		index := tx.ReadBucket(paymentsIndexBucket)
+	err = pControl.InitPayment(next.PaymentIdentifier, next)
+	require.NoError(t, err)
 
-		return index.ForEach(func(k, v []byte) error {
-			indexCount++
-			return nil
-		})
-	}, func() { indexCount = 0 })
+	nextPayment, err := pControl.FetchPayment(next.PaymentIdentifier)
 	require.NoError(t, err)
 
-	require.Equal(t, 1, indexCount)
+	if reuse {
+		require.Equal(
+			t, otherPayment.SequenceNum+1, nextPayment.SequenceNum,
+		)
+	} else {
+		require.Equal(
+			t, payment.SequenceNum+1, nextPayment.SequenceNum,
+		)
+	}
 }
 
-// TestPaymentControlDeletePayments tests that DeletePayments correctly deletes
-// information about completed payments from the database.
-func TestPaymentControlDeletePayments(t *testing.T) {
+// TestPaymentControlLabel checks that a payment's label is stored and
+// returned, and that payments without a label are read cleanly.
+func TestPaymentControlLabel(t *testing.T) {
 	t.Parallel()
 
 	db, err := MakeTestDB(t)
@@ -518,501 +429,3485 @@ func TestPaymentControlDeletePayments(t *testing.T) {
 
 	pControl := NewPaymentControl(db)
 
-	// Register three payments:
-	// 1. A payment with two failed attempts.
-	// 2. A payment with one failed and one settled attempt.
-	// 3. A payment with one failed and one in-flight attempt.
-	payments := []*payment{
-		{status: StatusFailed},
-		{status: StatusSucceeded},
-		{status: StatusInFlight},
-	}
+	// A payment without a label, just like the ones stored before labels
+	// were added, has no label key in its bucket.
+	unlabeled, _, _, err := genInfo()
+	require.NoError(t, err, "unable to generate htlc message")
 
-	// Use helper function to register the test payments in the data and
-	// populate the data to the payments slice.
-	createTestPayments(t, pControl, payments)
+	err = pControl.InitPayment(unlabeled.PaymentIdentifier, unlabeled)
+	require.NoError(t, err)
 
-	// Check that all payments are there as we added them.
-	assertPayments(t, db, payments)
+	payment, err := pControl.FetchPayment(unlabeled.PaymentIdentifier)
+	require.NoError(t, err)
+	require.Empty(t, payment.Info.Label)
+	require.Equal(t, unlabeled, payment.Info)
 
-	// Delete HTLC attempts for failed payments only.
-	require.NoError(t, db.DeletePayments(true, true))
+	// A labeled payment returns its label.
+	info, _, _, err := genInfo()
+	require.NoError(t, err, "unable to generate htlc message")
+	info.Label = "invoice #42"
 
-	// The failed payment is the only altered one.
-	payments[0].htlcs = 0
-	assertPayments(t, db, payments)
+	err = pControl.InitPayment(info.PaymentIdentifier, info)
+	require.NoError(t, err)
 
-	// Delete failed attempts for all payments.
-	require.NoError(t, db.DeletePayments(false, true))
+	payment, err = pControl.FetchPayment(info.PaymentIdentifier)
+	require.NoError(t, err)
+	require.Equal(t, info, payment.Info)
 
-	// The failed attempts should be deleted, except for the in-flight
-	// payment, that shouldn't be altered until it has completed.
-	payments[1].htlcs = 1
-	assertPayments(t, db, payments)
+	// When the failed payment is retried without a label, its previous
+	// label is removed.
+	_, err = pControl.Fail(info.PaymentIdentifier, FailureReasonNoRoute)
+	require.NoError(t, err)
 
-	// Now delete all failed payments.
-	require.NoError(t, db.DeletePayments(true, false))
+	info.Label = ""
+	err = pControl.InitPayment(info.PaymentIdentifier, info)
+	require.NoError(t, err)
 
-	assertPayments(t, db, payments[1:])
+	payment, err = pControl.FetchPayment(info.PaymentIdentifier)
+	require.NoError(t, err)
+	require.Empty(t, payment.Info.Label)
+
+	// Labels longer than the maximum length are rejected.
+	tooLong, _, _, err := genInfo()
+	require.NoError(t, err, "unable to generate htlc message")
+	tooLong.Label = string(make([]byte, MaxPaymentLabelLength+1))
 
-	// Finally delete all completed payments.
-	require.NoError(t, db.DeletePayments(false, false))
+	err = pControl.InitPayment(tooLong.PaymentIdentifier, tooLong)
+	require.ErrorIs(t, err, ErrPaymentLabelTooLong)
 
-	assertPayments(t, db, payments[2:])
+	_, err = pControl.FetchPayment(tooLong.PaymentIdentifier)
+	require.ErrorIs(t, err, ErrPaymentNotInitiated)
 }
 
-// TestPaymentControlDeleteSinglePayment tests that DeletePayment correctly
-// deletes information about a completed payment from the database.
-func TestPaymentControlDeleteSinglePayment(t *testing.T) {
+// TestPaymentControlPaymentAddr checks that the payment address of a payment
+// is taken from its creation info or, if unknown, from the MPP record of its
+// first attempt.
+func TestPaymentControlPaymentAddr(t *testing.T) {
 	t.Parallel()
 
-	db, err := MakeTestDB(t)
-	require.NoError(t, err, "unable to init db")
+	invoiceAddr := [32]byte{1}
 
-	pControl := NewPaymentControl(db)
+	// withFinalHop returns a copy of the attempt whose final hop is
+	// modified by the given function.
+	withFinalHop := func(a *HTLCAttemptInfo,
+		modify func(*route.Hop)) *HTLCAttemptInfo {
 
-	// Register four payments:
-	// All payments will have one failed HTLC attempt and one HTLC attempt
-	// according to its final status.
-	// 1. A payment with two failed attempts.
-	// 2. Another payment with two failed attempts.
-	// 3. A payment with one failed and one settled attempt.
-	// 4. A payment with one failed and one in-flight attempt.
+		c := *a
+		c.Route = *a.Route.Copy()
+		modify(c.Route.FinalHop())
 
-	// Initiate payments, which is a slice of payment that is used as
-	// template to create the corresponding test payments in the database.
-	//
-	// Note: The payment id and number of htlc attempts of each payment will
-	// be added to this slice when creating the payments below.
-	// This allows the slice to be used directly for testing purposes.
+		return &c
+	}
+
+	testCases := []struct {
+		name string
+
+		// invoiceAddr is the payment address of the creation info.
+		invoiceAddr *[32]byte
+
+		// modifyHop modifies the final hop of the payment's attempt.
+		modifyHop func(*route.Hop)
+
+		// expectedAddr is the payment address expected after the
+		// attempt is registered.
+		expectedAddr *[32]byte
+	}{
+		{
+			name: "mpp",
+			modifyHop: func(h *route.Hop) {
+				h.MPP = record.NewMPP(
+					h.AmtToForward, [32]byte{2},
+				)
+			},
+			expectedAddr: &[32]byte{2},
+		},
+		{
+			name:        "mpp with invoice address",
+			invoiceAddr: &invoiceAddr,
+			modifyHop: func(h *route.Hop) {
+				h.MPP = record.NewMPP(
+					h.AmtToForward, invoiceAddr,
+				)
+			},
+			expectedAddr: &invoiceAddr,
+		},
+		{
+			name:        "blinded",
+			invoiceAddr: &invoiceAddr,
+			modifyHop: func(h *route.Hop) {
+				h.MPP = nil
+				h.EncryptedData = []byte{1, 2, 3}
+				h.TotalAmtMsat = h.AmtToForward
+			},
+			expectedAddr: &invoiceAddr,
+		},
+		{
+			name: "keysend",
+			modifyHop: func(h *route.Hop) {
+				h.MPP = nil
+				h.CustomRecords = record.CustomSet{
+					record.KeySendType: {1, 2, 3},
+				}
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, err := MakeTestDB(t)
+			require.NoError(t, err)
+
+			pControl := NewPaymentControl(db)
+
+			info, attempt, _, err := genInfo()
+			require.NoError(t, err)
+			info.PaymentAddr = tc.invoiceAddr
+
+			err = pControl.InitPayment(info.PaymentIdentifier, info)
+			require.NoError(t, err)
+
+			payment, err := pControl.FetchPayment(
+				info.PaymentIdentifier,
+			)
+			require.NoError(t, err)
+			require.Equal(
+				t, tc.invoiceAddr, payment.Info.PaymentAddr,
+			)
+
+			attempt = withFinalHop(attempt, tc.modifyHop)
+			payment, err = pControl.RegisterAttempt(
+				info.PaymentIdentifier, attempt,
+			)
+			require.NoError(t, err)
+			require.Equal(
+				t, tc.expectedAddr, payment.Info.PaymentAddr,
+			)
+
+			// When the failed payment is retried without a payment
+			// address, the previous one is removed.
+			_, _, err = pControl.FailAttempt(
+				info.PaymentIdentifier, attempt.AttemptID,
+				&HTLCFailInfo{Reason: HTLCFailUnreadable},
+			)
+			require.NoError(t, err)
+			_, err = pControl.Fail(
+				info.PaymentIdentifier, FailureReasonNoRoute,
+			)
+			require.NoError(t, err)
+
+			info.PaymentAddr = nil
+			err = pControl.InitPayment(info.PaymentIdentifier, info)
+			require.NoError(t, err)
+
+			payment, err = pControl.FetchPayment(
+				info.PaymentIdentifier,
+			)
+			require.NoError(t, err)
+			require.Nil(t, payment.Info.PaymentAddr)
+		})
+	}
+}
+
+// TestPaymentControlAbandonPayment checks that an in-flight payment can be
+// abandoned, failing all its in-flight attempts, while payments with a settled
+// htlc can't be abandoned.
+func TestPaymentControlAbandonPayment(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1_700_000_000, 0)
+	db, err := MakeTestDB(t, OptionClock(clock.NewTestClock(now)))
+	require.NoError(t, err, "unable to init db")
+
+	pControl := NewPaymentControl(db)
+
+	// Each payment has a failed attempt and a second attempt that is
+	// settled, failed or still in flight.
 	payments := []*payment{
+		{status: StatusInFlight},
+		{status: StatusSucceeded},
 		{status: StatusFailed},
+	}
+	createTestPayments(t, pControl, payments)
+
+	// A payment with a settled htlc can't be abandoned.
+	err = pControl.AbandonPayment(payments[1].id)
+	require.ErrorIs(t, err, ErrPaymentPendingSettled)
+
+	// A payment that already failed can't be abandoned either.
+	err = pControl.AbandonPayment(payments[2].id)
+	require.ErrorIs(t, err, ErrPaymentAlreadyFailed)
+
+	// Neither can an unknown payment.
+	err = pControl.AbandonPayment(lntypes.Hash{1})
+	require.ErrorIs(t, err, ErrPaymentNotInitiated)
+
+	// The payments are unchanged.
+	assertPayments(t, db, payments)
+
+	// Abandon the in-flight payment.
+	err = pControl.AbandonPayment(payments[0].id)
+	require.NoError(t, err)
+
+	payment, err := pControl.FetchPayment(payments[0].id)
+	require.NoError(t, err)
+	require.Equal(t, StatusFailed, payment.Status)
+	require.Equal(t, FailureReasonCanceled, *payment.FailureReason)
+	require.Empty(t, payment.InFlightHTLCs())
+
+	// The in-flight attempt is abandoned.
+	require.Len(t, payment.HTLCs, 2)
+	abandoned := payment.HTLCs[1].Failure
+	require.NotNil(t, abandoned)
+	require.Equal(t, HTLCFailAbandoned, abandoned.Reason)
+	require.True(t, now.Equal(abandoned.FailTime))
+
+	// The abandoned payment can be retried.
+	info, _, _, err := genInfo()
+	require.NoError(t, err, "unable to generate htlc message")
+	info.PaymentIdentifier = payments[0].id
+
+	err = pControl.InitPayment(payments[0].id, info)
+	require.NoError(t, err)
+}
+
+// TestPaymentControlAbandonFailedPayment checks that abandoning a payment that
+// already failed while its attempt was in flight keeps the first failure
+// reason, and that the abandoned attempt is notified.
+func TestPaymentControlAbandonFailedPayment(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err, "unable to init db")
+
+	pControl := NewPaymentControl(db)
+
+	info, attempt, _, err := genInfo()
+	require.NoError(t, err)
+
+	hash := info.PaymentIdentifier
+	require.NoError(t, pControl.InitPayment(hash, info))
+
+	_, err = pControl.RegisterAttempt(hash, attempt)
+	require.NoError(t, err)
+
+	// The payment times out while its attempt is stuck.
+	_, err = pControl.Fail(hash, FailureReasonTimeout)
+	require.NoError(t, err)
+
+	var updates []*PaymentUpdate
+	pControl.SetNotifier(func(update *PaymentUpdate) {
+		updates = append(updates, update)
+	})
+
+	require.NoError(t, pControl.AbandonPayment(hash))
+
+	payment, err := pControl.FetchPayment(hash)
+	require.NoError(t, err)
+	require.Equal(t, StatusFailed, payment.Status)
+	require.Equal(t, FailureReasonTimeout, *payment.FailureReason)
+
+	// The subscribers are notified of the abandoned attempt once the
+	// update is committed.
+	require.Len(t, updates, 1)
+	require.Equal(t, attempt.AttemptID, *updates[0].AttemptID)
+	require.Equal(t, AttemptEventFailed, updates[0].AttemptEvent)
+	require.Equal(t, StatusFailed, updates[0].Status)
+}
+
+// TestPaymentControlFailWithoutAttempts checks that a payment that is failed
+// before any attempt was registered is cleanly reported as failed.
+func TestPaymentControlFailWithoutAttempts(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err, "unable to init db")
+
+	pControl := NewPaymentControl(db)
+
+	info, _, _, err := genInfo()
+	require.NoError(t, err, "unable to generate htlc message")
+
+	err = pControl.InitPayment(info.PaymentIdentifier, info)
+	require.NoError(t, err)
+
+	// Fail the payment right away, as if path finding failed before the
+	// first attempt was made.
+	payment, err := pControl.Fail(
+		info.PaymentIdentifier, FailureReasonNoRoute,
+	)
+	require.NoError(t, err)
+	require.Empty(t, payment.HTLCs)
+
+	payment, err = pControl.FetchPayment(info.PaymentIdentifier)
+	require.NoError(t, err)
+
+	// The payment is failed with nothing sent.
+	require.Equal(t, StatusFailed, payment.Status)
+	require.True(t, payment.State.PaymentFailed)
+	require.Equal(t, info.Value, payment.State.RemainingAmt)
+	require.Zero(t, payment.State.NumAttemptsInFlight)
+
+	// There's nothing to wait for and no more attempts can be made.
+	wait, err := payment.NeedWaitAttempts()
+	require.NoError(t, err)
+	require.False(t, wait)
+
+	allow, err := payment.AllowMoreAttempts()
+	require.NoError(t, err)
+	require.False(t, allow)
+}
+
+// TestPaymentControlFailIdempotent checks that failing an already failed
+// payment with the same reason is a no-op, while failing it with another
+// reason is refused.
+func TestPaymentControlFailIdempotent(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+
+	info, attempt, _, err := genInfo()
+	require.NoError(t, err)
+
+	hash := info.PaymentIdentifier
+	require.NoError(t, pControl.InitPayment(hash, info))
+
+	_, err = pControl.RegisterAttempt(hash, attempt)
+	require.NoError(t, err)
+
+	var updates []*PaymentUpdate
+	pControl.SetNotifier(func(update *PaymentUpdate) {
+		updates = append(updates, update)
+	})
+
+	// The payment stays in flight after it was failed, until its attempt
+	// is resolved.
+	payment, err := pControl.Fail(hash, FailureReasonTimeout)
+	require.NoError(t, err)
+	require.Equal(t, StatusInFlight, payment.Status)
+	require.Len(t, updates, 1)
+
+	// Failing the payment again with the same reason returns it as is,
+	// without notifying the subscribers.
+	payment, err = pControl.Fail(hash, FailureReasonTimeout)
+	require.NoError(t, err)
+	require.Equal(t, StatusInFlight, payment.Status)
+	require.Equal(t, FailureReasonTimeout, *payment.FailureReason)
+	require.Len(t, updates, 1)
+
+	// Another reason is refused, and the first one is kept.
+	_, err = pControl.Fail(hash, FailureReasonNoRoute)
+	require.ErrorIs(t, err, ErrPaymentAlreadyFailed)
+
+	_, _, err = pControl.FailAttempt(hash, attempt.AttemptID, &HTLCFailInfo{
+		Reason: HTLCFailUnreadable,
+	})
+	require.NoError(t, err)
+
+	payment, err = pControl.FetchPayment(hash)
+	require.NoError(t, err)
+	require.Equal(t, StatusFailed, payment.Status)
+	require.Equal(t, FailureReasonTimeout, *payment.FailureReason)
+
+	// The same holds once the payment reached the failed status.
+	_, err = pControl.Fail(hash, FailureReasonTimeout)
+	require.NoError(t, err)
+
+	_, err = pControl.Fail(hash, FailureReasonNoRoute)
+	require.ErrorIs(t, err, ErrPaymentAlreadyFailed)
+
+	// A retried payment can be failed with any reason again.
+	require.NoError(t, pControl.InitPayment(hash, info))
+
+	payment, err = pControl.Fail(hash, FailureReasonNoRoute)
+	require.NoError(t, err)
+	require.Equal(t, FailureReasonNoRoute, *payment.FailureReason)
+}
+
+// TestPaymentControlFetchInFlightPaymentsCanceled checks that fetching the
+// in-flight payments is aborted if the context is canceled.
+func TestPaymentControlFetchInFlightPaymentsCanceled(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err, "unable to init db")
+
+	pControl := NewPaymentControl(db)
+
+	const numPayments = 2 * paymentScanCheckInterval
+	for i := 0; i < numPayments; i++ {
+		info, _, _, err := genInfo()
+		require.NoError(t, err)
+
+		err = pControl.InitPayment(info.PaymentIdentifier, info)
+		require.NoError(t, err)
+	}
+
+	inFlight, err := pControl.FetchInFlightPayments(context.Background())
+	require.NoError(t, err)
+	require.Len(t, inFlight, numPayments)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	inFlight, err = pControl.FetchInFlightPayments(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Nil(t, inFlight)
+}
+
+// cancelAfterCtx is a context that reports itself as canceled once its Err
+// method has been called a given number of times. It allows canceling a scan
+// deterministically after a number of checks.
+type cancelAfterCtx struct {
+	context.Context
+
+	numChecks  int
+	cancelFrom int
+}
+
+// Err returns context.Canceled once the configured number of checks was
+// reached.
+func (c *cancelAfterCtx) Err() error {
+	c.numChecks++
+	if c.numChecks >= c.cancelFrom {
+		return context.Canceled
+	}
+
+	return nil
+}
+
+// TestPaymentControlFetchInFlightPaymentsCanceledMidScan checks that a scan of
+// the in-flight payments that is canceled while it is running is aborted at
+// the next check with the context's error, without returning the partial
+// result.
+func TestPaymentControlFetchInFlightPaymentsCanceledMidScan(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err, "unable to init db")
+
+	pControl := NewPaymentControl(db)
+
+	const numPayments = 3 * paymentScanCheckInterval
+	for i := 0; i < numPayments; i++ {
+		info, _, _, err := genInfo()
+		require.NoError(t, err)
+
+		err = pControl.InitPayment(info.PaymentIdentifier, info)
+		require.NoError(t, err)
+	}
+
+	// The context is canceled at its second check, which happens after
+	// two thirds of the payments were scanned.
+	ctx := &cancelAfterCtx{
+		Context:    context.Background(),
+		cancelFrom: 2,
+	}
+
+	inFlight, err := pControl.FetchInFlightPayments(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Nil(t, inFlight)
+	require.Equal(t, 2, ctx.numChecks)
+}
+
+// fetchInFlightIndex returns the hashes of the payments in the in-flight
+// index.
+func fetchInFlightIndex(t *testing.T, db *DB) []lntypes.Hash {
+	t.Helper()
+
+	var hashes []lntypes.Hash
+	err := kvdb.View(db, func(tx kvdb.RTx) error {
+		index := tx.ReadBucket(paymentsInFlightIndexBucket)
+		require.NotNil(t, index)
+
+		return index.ForEach(func(k, _ []byte) error {
+			hash, err := lntypes.MakeHash(k)
+			if err != nil {
+				return err
+			}
+
+			hashes = append(hashes, hash)
+
+			return nil
+		})
+	}, func() {
+		hashes = nil
+	})
+	require.NoError(t, err)
+
+	return hashes
+}
+
+// TestPaymentControlInFlightIndex checks that the in-flight index tracks the
+// payments that aren't terminated, and that it's built from the stored
+// payments on first use if it's missing.
+func TestPaymentControlInFlightIndex(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+
+	payments := []*payment{
 		{status: StatusFailed},
+		{status: StatusInFlight},
 		{status: StatusSucceeded},
 		{status: StatusInFlight},
 	}
-
-	// Use helper function to register the test payments in the data and
-	// populate the data to the payments slice.
 	createTestPayments(t, pControl, payments)
 
-	// Check that all payments are there as we added them.
-	assertPayments(t, db, payments)
+	// An initiated payment without attempts is in flight as well.
+	info, attempt, preimg, err := genInfo()
+	require.NoError(t, err)
 
-	// Delete HTLC attempts for first payment only.
-	require.NoError(t, db.DeletePayment(payments[0].id, true))
+	hash := info.PaymentIdentifier
+	require.NoError(t, pControl.InitPayment(hash, info))
 
-	// The first payment is the only altered one as its failed HTLC should
-	// have been removed but is still present as payment.
-	payments[0].htlcs = 0
-	assertPayments(t, db, payments)
+	// assertInFlight checks that exactly the given payments are indexed
+	// and fetched as in flight.
+	assertInFlight := func(expected ...lntypes.Hash) {
+		t.Helper()
+
+		inFlight, err := pControl.FetchInFlightPayments(ctx)
+		require.NoError(t, err)
+
+		fetched := make([]lntypes.Hash, 0, len(inFlight))
+		for _, p := range inFlight {
+			fetched = append(fetched, p.Info.PaymentIdentifier)
+		}
+		require.ElementsMatch(t, expected, fetched)
+
+		require.ElementsMatch(t, expected, fetchInFlightIndex(t, db))
+	}
+
+	assertInFlight(payments[1].id, payments[3].id, hash)
+
+	// Drop the index, as for a database created before it existed. It is
+	// built again from the stored payments.
+	err = kvdb.Update(db, func(tx kvdb.RwTx) error {
+		return tx.DeleteTopLevelBucket(paymentsInFlightIndexBucket)
+	}, func() {})
+	require.NoError(t, err)
+
+	assertInFlight(payments[1].id, payments[3].id, hash)
+
+	// Failing the payment while its attempt is in flight keeps it in the
+	// index until the attempt is resolved.
+	_, err = pControl.RegisterAttempt(hash, attempt)
+	require.NoError(t, err)
+
+	_, err = pControl.Fail(hash, FailureReasonNoRoute)
+	require.NoError(t, err)
+	assertInFlight(payments[1].id, payments[3].id, hash)
+
+	_, _, err = pControl.FailAttempt(
+		hash, attempt.AttemptID, &HTLCFailInfo{
+			Reason: HTLCFailUnreadable,
+		},
+	)
+	require.NoError(t, err)
+	assertInFlight(payments[1].id, payments[3].id)
+
+	// Retrying the failed payment puts it back into the index, and
+	// settling it removes it again.
+	require.NoError(t, pControl.InitPayment(hash, info))
+	assertInFlight(payments[1].id, payments[3].id, hash)
+
+	_, err = pControl.RegisterAttempt(hash, attempt)
+	require.NoError(t, err)
+
+	_, _, err = pControl.SettleAttempt(
+		hash, attempt.AttemptID, &HTLCSettleInfo{Preimage: preimg},
+	)
+	require.NoError(t, err)
+	assertInFlight(payments[1].id, payments[3].id)
+
+	// Abandoning a payment removes it from the index.
+	require.NoError(t, pControl.AbandonPayment(payments[1].id))
+	assertInFlight(payments[3].id)
+}
+
+// TestPaymentControlForEachInFlightPayment checks that iterating the in-flight
+// payments page by page visits each of them exactly once.
+func TestPaymentControlForEachInFlightPayment(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+
+	payments := []*payment{
+		{status: StatusInFlight},
+		{status: StatusSucceeded},
+		{status: StatusInFlight},
+		{status: StatusInFlight},
+		{status: StatusFailed},
+		{status: StatusInFlight},
+		{status: StatusInFlight},
+	}
+	createTestPayments(t, pControl, payments)
+
+	var expected []lntypes.Hash
+	for _, p := range payments {
+		if p.status == StatusInFlight {
+			expected = append(expected, p.id)
+		}
+	}
+
+	// A page size that doesn't divide the number of payments makes the
+	// last page a partial one.
+	for _, pageSize := range []int{1, 2, 10} {
+		var visited []lntypes.Hash
+		err := pControl.ForEachInFlightPayment(ctx, pageSize,
+			func(p *MPPayment) error {
+				visited = append(
+					visited, p.Info.PaymentIdentifier,
+				)
+
+				return nil
+			},
+		)
+		require.NoError(t, err)
+		require.ElementsMatch(t, expected, visited, pageSize)
+	}
+
+	// An error returned by the callback ends the iteration.
+	errStop := errors.New("stop")
+	var numVisited int
+	err = pControl.ForEachInFlightPayment(ctx, 1, func(*MPPayment) error {
+		numVisited++
+		return errStop
+	})
+	require.ErrorIs(t, err, errStop)
+	require.Equal(t, 1, numVisited)
+}
+
+// TestPaymentControlDeleteNonInFlight checks that calling DeletePayments only
+// deletes payments from the database that are not in-flight.
+func TestPaymentControlDeleteNonInFlight(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err, "unable to init db")
+
+	// Create a sequence number for duplicate payments that will not collide
+	// with the sequence numbers for the payments we create. These values
+	// start at 1, so 9999 is a safe bet for this test.
+	var duplicateSeqNr = 9999
+
+	pControl := NewPaymentControl(db)
+
+	payments := []struct {
+		failed       bool
+		success      bool
+		hasDuplicate bool
+	}{
+		{
+			failed:       true,
+			success:      false,
+			hasDuplicate: false,
+		},
+		{
+			failed:       false,
+			success:      true,
+			hasDuplicate: false,
+		},
+		{
+			failed:       false,
+			success:      false,
+			hasDuplicate: false,
+		},
+		{
+			failed:       false,
+			success:      true,
+			hasDuplicate: true,
+		},
+	}
+
+	var numSuccess, numInflight int
+
+	for _, p := range payments {
+		info, attempt, preimg, err := genInfo()
+		if err != nil {
+			t.Fatalf("unable to generate htlc message: %v", err)
+		}
+
+		// Sends base htlc message which initiate StatusInFlight.
+		err = pControl.InitPayment(info.PaymentIdentifier, info)
+		if err != nil {
+			t.Fatalf("unable to send htlc message: %v", err)
+		}
+		_, err = pControl.RegisterAttempt(info.PaymentIdentifier, attempt)
+		if err != nil {
+			t.Fatalf("unable to send htlc message: %v", err)
+		}
+
+		htlc := &htlcStatus{
+			HTLCAttemptInfo: attempt,
+		}
+
+		if p.failed {
+			// Fail the payment attempt.
+			htlcFailure := HTLCFailUnreadable
+			_, _, err := pControl.FailAttempt(
+				info.PaymentIdentifier, attempt.AttemptID,
+				&HTLCFailInfo{
+					Reason: htlcFailure,
+				},
+			)
+			if err != nil {
+				t.Fatalf("unable to fail htlc: %v", err)
+			}
+
+			// Fail the payment, which should moved it to Failed.
+			failReason := FailureReasonNoRoute
+			_, err = pControl.Fail(info.PaymentIdentifier, failReason)
+			if err != nil {
+				t.Fatalf("unable to fail payment hash: %v", err)
+			}
+
+			// Verify the status is indeed Failed.
+			assertPaymentStatus(t, pControl, info.PaymentIdentifier, StatusFailed)
+
+			htlc.failure = &htlcFailure
+			assertPaymentInfo(
+				t, pControl, info.PaymentIdentifier, info,
+				&failReason, htlc,
+			)
+		} else if p.success {
+			// Verifies that status was changed to StatusSucceeded.
+			_, _, err := pControl.SettleAttempt(
+				info.PaymentIdentifier, attempt.AttemptID,
+				&HTLCSettleInfo{
+					Preimage: preimg,
+				},
+			)
+			if err != nil {
+				t.Fatalf("error shouldn't have been received, got: %v", err)
+			}
+
+			assertPaymentStatus(t, pControl, info.PaymentIdentifier, StatusSucceeded)
+
+			htlc.settle = &preimg
+			assertPaymentInfo(
+				t, pControl, info.PaymentIdentifier, info, nil, htlc,
+			)
+
+			numSuccess++
+		} else {
+			assertPaymentStatus(t, pControl, info.PaymentIdentifier, StatusInFlight)
+			assertPaymentInfo(
+				t, pControl, info.PaymentIdentifier, info, nil, htlc,
+			)
+
+			numInflight++
+		}
+
+		// If the payment is intended to have a duplicate payment, we
+		// add one.
+		if p.hasDuplicate {
+			appendDuplicatePayment(
+				t, pControl.db, info.PaymentIdentifier,
+				uint64(duplicateSeqNr), preimg,
+			)
+			duplicateSeqNr++
+			numSuccess++
+		}
+	}
+
+	// Delete all failed payments.
+	if _, _, err := db.DeletePayments(true, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// This should leave the succeeded and in-flight payments.
+	dbPayments, err := db.FetchPayments()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dbPayments) != numSuccess+numInflight {
+		t.Fatalf("expected %d payments, got %d",
+			numSuccess+numInflight, len(dbPayments))
+	}
+
+	var s, i int
+	for _, p := range dbPayments {
+		t.Log("fetch payment has status", p.Status)
+		switch p.Status {
+		case StatusSucceeded:
+			s++
+		case StatusInFlight:
+			i++
+		}
+	}
+
+	if s != numSuccess {
+		t.Fatalf("expected %d succeeded payments , got %d",
+			numSuccess, s)
+	}
+	if i != numInflight {
+		t.Fatalf("expected %d in-flight payments, got %d",
+			numInflight, i)
+	}
+
+	// Now delete all payments except in-flight.
+	if _, _, err := db.DeletePayments(false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// This should leave the in-flight payment.
+	dbPayments, err = db.FetchPayments()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dbPayments) != numInflight {
+		t.Fatalf("expected %d payments, got %d", numInflight,
+			len(dbPayments))
+	}
+
+	for _, p := range dbPayments {
+		if p.Status != StatusInFlight {
+			t.Fatalf("expected in-fligth status, got %v", p.Status)
+		}
+	}
+
+	// Finally, check that we only have a single index left in the payment
+	// index bucket.
+	var indexCount int
+	err = kvdb.View(db, func(tx walletdb.ReadTx) error {
+		index := tx.ReadBucket(paymentsIndexBucket)
+
+		return index.ForEach(func(k, v []byte) error {
+			indexCount++
+			return nil
+		})
+	}, func() { indexCount = 0 })
+	require.NoError(t, err)
+
+	require.Equal(t, 1, indexCount)
+}
+
+// TestPaymentControlDeletePayments tests that DeletePayments correctly deletes
+// information about completed payments from the database.
+func TestPaymentControlDeletePayments(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err, "unable to init db")
+
+	pControl := NewPaymentControl(db)
+
+	// Register three payments:
+	// 1. A payment with two failed attempts.
+	// 2. A payment with one failed and one settled attempt.
+	// 3. A payment with one failed and one in-flight attempt.
+	payments := []*payment{
+		{status: StatusFailed},
+		{status: StatusSucceeded},
+		{status: StatusInFlight},
+	}
+
+	// Use helper function to register the test payments in the data and
+	// populate the data to the payments slice.
+	createTestPayments(t, pControl, payments)
+
+	// Check that all payments are there as we added them.
+	assertPayments(t, db, payments)
+
+	// Delete HTLC attempts for failed payments only.
+	numPayments, numAttempts, err := db.DeletePayments(true, true)
+	require.NoError(t, err)
+	require.Equal(t, 1, numPayments)
+	require.Equal(t, 2, numAttempts)
+
+	// The failed payment is the only altered one.
+	payments[0].htlcs = 0
+	assertPayments(t, db, payments)
+
+	// Delete failed attempts for all payments. The failed payment has no
+	// failed attempts left, but is still counted as altered.
+	numPayments, numAttempts, err = db.DeletePayments(false, true)
+	require.NoError(t, err)
+	require.Equal(t, 2, numPayments)
+	require.Equal(t, 1, numAttempts)
+
+	// The failed attempts should be deleted, except for the in-flight
+	// payment, that shouldn't be altered until it has completed.
+	payments[1].htlcs = 1
+	assertPayments(t, db, payments)
+
+	// Now delete all failed payments.
+	numPayments, numAttempts, err = db.DeletePayments(true, false)
+	require.NoError(t, err)
+	require.Equal(t, 1, numPayments)
+	require.Zero(t, numAttempts)
+
+	assertPayments(t, db, payments[1:])
+
+	// Finally delete all completed payments, along with the settled
+	// attempt of the succeeded one.
+	numPayments, numAttempts, err = db.DeletePayments(false, false)
+	require.NoError(t, err)
+	require.Equal(t, 1, numPayments)
+	require.Equal(t, 1, numAttempts)
+
+	assertPayments(t, db, payments[2:])
+}
+
+// TestPaymentControlDeleteSinglePayment tests that DeletePayment correctly
+// deletes information about a completed payment from the database.
+func TestPaymentControlDeleteSinglePayment(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err, "unable to init db")
+
+	pControl := NewPaymentControl(db)
+
+	// Register four payments:
+	// All payments will have one failed HTLC attempt and one HTLC attempt
+	// according to its final status.
+	// 1. A payment with two failed attempts.
+	// 2. Another payment with two failed attempts.
+	// 3. A payment with one failed and one settled attempt.
+	// 4. A payment with one failed and one in-flight attempt.
+
+	// Initiate payments, which is a slice of payment that is used as
+	// template to create the corresponding test payments in the database.
+	//
+	// Note: The payment id and number of htlc attempts of each payment will
+	// be added to this slice when creating the payments below.
+	// This allows the slice to be used directly for testing purposes.
+	payments := []*payment{
+		{status: StatusFailed},
+		{status: StatusFailed},
+		{status: StatusSucceeded},
+		{status: StatusInFlight},
+	}
+
+	// Use helper function to register the test payments in the data and
+	// populate the data to the payments slice.
+	createTestPayments(t, pControl, payments)
+
+	// Check that all payments are there as we added them.
+	assertPayments(t, db, payments)
+
+	// assertDeleted deletes the given payment, or only its failed HTLC
+	// attempts, and asserts what the deletion reported.
+	assertDeleted := func(p *payment, failedHtlcsOnly bool,
+		numAttempts int) {
+
+		t.Helper()
+
+		deleted, err := db.DeletePayment(p.id, failedHtlcsOnly)
+		require.NoError(t, err)
+		require.Equal(t, &DeletedPayment{
+			Status:      p.status,
+			NumAttempts: numAttempts,
+		}, deleted)
+	}
+
+	// Delete HTLC attempts for first payment only, which removes both of
+	// its failed attempts.
+	assertDeleted(payments[0], true, 2)
+
+	// The first payment is the only altered one as its failed HTLC should
+	// have been removed but is still present as payment.
+	payments[0].htlcs = 0
+	assertPayments(t, db, payments)
+
+	// Delete the first payment completely. It has no attempts left.
+	assertDeleted(payments[0], false, 0)
+
+	// The first payment should have been deleted.
+	assertPayments(t, db, payments[1:])
+
+	// Now delete the second payment completely, along with its attempts.
+	assertDeleted(payments[1], false, 2)
+
+	// The Second payment should have been deleted.
+	assertPayments(t, db, payments[2:])
+
+	// Delete failed HTLC attempts for the third payment.
+	assertDeleted(payments[2], true, 1)
+
+	// Only the successful HTLC attempt should be left for the third payment.
+	payments[2].htlcs = 1
+	assertPayments(t, db, payments[2:])
+
+	// Now delete the third payment completely.
+	assertDeleted(payments[2], false, 1)
+
+	// Only the last payment should be left.
+	assertPayments(t, db, payments[3:])
+
+	// Deleting HTLC attempts from InFlight payments should not work and an
+	// error returned.
+	_, err = db.DeletePayment(payments[3].id, true)
+	require.Error(t, err)
+
+	// The payment is InFlight and therefore should not have been altered.
+	assertPayments(t, db, payments[3:])
+
+	// Finally deleting the InFlight payment should also not work and an
+	// error returned.
+	_, err = db.DeletePayment(payments[3].id, false)
+	require.Error(t, err)
+
+	// The payment is InFlight and therefore should not have been altered.
+	assertPayments(t, db, payments[3:])
+}
+
+// TestPaymentControlDeletePaymentBySeqNum checks that payments can be deleted
+// by their sequence number, following the same rules as deleting them by
+// their hash.
+func TestPaymentControlDeletePaymentBySeqNum(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err, "unable to init db")
+
+	ctx := context.Background()
+	pControl := NewPaymentControl(db)
+
+	payments := []*payment{
+		{status: StatusFailed},
+		{status: StatusSucceeded},
+		{status: StatusInFlight},
+		{status: StatusFailed},
+	}
+	createTestPayments(t, pControl, payments)
+
+	seqNum := func(p *payment) uint64 {
+		pmt, err := pControl.FetchPayment(p.id)
+		require.NoError(t, err)
+
+		return pmt.SequenceNum
+	}
+	seqNums := make([]uint64, len(payments))
+	for i, p := range payments {
+		seqNums[i] = seqNum(p)
+	}
+
+	// Delete the failed HTLC attempts of the first payment, and then the
+	// payment itself.
+	err = db.DeletePaymentBySeqNum(ctx, seqNums[0], true)
+	require.NoError(t, err)
+
+	payments[0].htlcs = 0
+	assertPayments(t, db, payments)
+
+	require.NoError(t, db.DeletePaymentBySeqNum(ctx, seqNums[0], false))
+	assertPayments(t, db, payments[1:])
+	assertNoIndex(t, pControl, seqNums[0])
+
+	// The sequence number of the deleted payment isn't known anymore.
+	err = db.DeletePaymentBySeqNum(ctx, seqNums[0], false)
+	require.ErrorIs(t, err, errNoSequenceNrIndex)
+
+	// Likewise after a payment was deleted by its hash.
+	_, err = db.DeletePayment(payments[1].id, false)
+	require.NoError(t, err)
+	assertPayments(t, db, payments[2:])
+
+	err = db.DeletePaymentBySeqNum(ctx, seqNums[1], false)
+	require.ErrorIs(t, err, errNoSequenceNrIndex)
+
+	// An in-flight payment can't be deleted.
+	err = db.DeletePaymentBySeqNum(ctx, seqNums[2], false)
+	require.ErrorIs(t, err, ErrPaymentInFlight)
+	assertPayments(t, db, payments[2:])
+
+	// If the payment bucket is gone but its index entry was left behind,
+	// the payment isn't found.
+	err = kvdb.Update(db, func(tx kvdb.RwTx) error {
+		bucket := tx.ReadWriteBucket(paymentsRootBucket)
+		return bucket.DeleteNestedBucket(payments[3].id[:])
+	}, func() {})
+	require.NoError(t, err)
+
+	err = db.DeletePaymentBySeqNum(ctx, seqNums[3], false)
+	require.ErrorIs(t, err, ErrPaymentNotInitiated)
+
+	// An unknown sequence number is rejected.
+	err = db.DeletePaymentBySeqNum(ctx, 1000, false)
+	require.ErrorIs(t, err, errNoSequenceNrIndex)
+}
+
+// TestPaymentControlFetchPaymentBySeqNum checks that payments can be looked up
+// by their sequence number, and that deleted payments aren't found.
+func TestPaymentControlFetchPaymentBySeqNum(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err, "unable to init db")
+
+	ctx := context.Background()
+	pControl := NewPaymentControl(db)
+
+	payments := []*payment{
+		{status: StatusFailed},
+		{status: StatusSucceeded},
+		{status: StatusInFlight},
+	}
+	createTestPayments(t, pControl, payments)
+
+	// Every payment resolves to itself by its sequence number.
+	for _, p := range payments {
+		expected, err := pControl.FetchPayment(p.id)
+		require.NoError(t, err)
+
+		pmt, err := db.FetchPaymentBySeqNum(ctx, expected.SequenceNum)
+		require.NoError(t, err)
+		require.Equal(t, p.id, pmt.Info.PaymentIdentifier)
+		require.Equal(t, expected.SequenceNum, pmt.SequenceNum)
+		require.Equal(t, p.status, pmt.Status)
+	}
+
+	first, err := pControl.FetchPayment(payments[0].id)
+	require.NoError(t, err)
+
+	// Once deleted, the payment isn't found by its sequence number.
+	_, err = db.DeletePayment(payments[0].id, false)
+	require.NoError(t, err)
+
+	_, err = db.FetchPaymentBySeqNum(ctx, first.SequenceNum)
+	require.ErrorIs(t, err, ErrPaymentNotInitiated)
+
+	// Likewise if only the payment bucket is gone.
+	second, err := pControl.FetchPayment(payments[1].id)
+	require.NoError(t, err)
+
+	err = kvdb.Update(db, func(tx kvdb.RwTx) error {
+		bucket := tx.ReadWriteBucket(paymentsRootBucket)
+		return bucket.DeleteNestedBucket(payments[1].id[:])
+	}, func() {})
+	require.NoError(t, err)
+
+	_, err = db.FetchPaymentBySeqNum(ctx, second.SequenceNum)
+	require.ErrorIs(t, err, ErrPaymentNotInitiated)
+
+	// An unknown sequence number isn't found either.
+	_, err = db.FetchPaymentBySeqNum(ctx, 1000)
+	require.ErrorIs(t, err, ErrPaymentNotInitiated)
+}
+
+// TestPaymentControlClientReference checks that a client reference can only
+// be used by a single payment, and that the payment can be looked up by it.
+func TestPaymentControlClientReference(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err, "unable to init db")
+
+	ctx := context.Background()
+	pControl := NewPaymentControl(db)
+
+	ref := bytes.Repeat([]byte{1}, MaxClientReferenceLength)
+
+	info, _, _, err := genInfo()
+	require.NoError(t, err)
+	info.ClientReference = ref
+
+	otherInfo, _, _, err := genInfo()
+	require.NoError(t, err)
+	otherInfo.ClientReference = ref
+
+	hash := info.PaymentIdentifier
+	otherHash := otherInfo.PaymentIdentifier
+
+	// A reference exceeding the maximum length is rejected.
+	tooLong := *info
+	tooLong.ClientReference = append(ref, 1)
+	err = pControl.InitPayment(hash, &tooLong)
+	require.ErrorIs(t, err, ErrClientReferenceTooLong)
+
+	// An unused reference isn't found.
+	_, err = db.LookupPaymentByReference(ctx, ref)
+	require.ErrorIs(t, err, ErrPaymentNotInitiated)
+
+	// Once the payment is initiated, it's found by its reference.
+	require.NoError(t, pControl.InitPayment(hash, info))
+
+	payment, err := db.LookupPaymentByReference(ctx, ref)
+	require.NoError(t, err)
+	require.Equal(t, hash, payment.Info.PaymentIdentifier)
+	require.Equal(t, ref, payment.Info.ClientReference)
+
+	// Initiating the same payment with the same reference again succeeds,
+	// leaving the existing payment untouched.
+	retry := *info
+	retry.Value *= 2
+	require.NoError(t, pControl.InitPayment(hash, &retry))
+
+	payment, err = db.LookupPaymentByReference(ctx, ref)
+	require.NoError(t, err)
+	require.Equal(t, hash, payment.Info.PaymentIdentifier)
+	require.Equal(t, info.Value, payment.Info.Value)
+
+	// Without the reference, the usual rules for the payment's status
+	// apply.
+	noRef := *info
+	noRef.ClientReference = nil
+	err = pControl.InitPayment(hash, &noRef)
+	require.ErrorIs(t, err, ErrPaymentExists)
+
+	// Another payment can't use the same reference, and isn't created.
+	err = pControl.InitPayment(otherHash, otherInfo)
+	require.ErrorIs(t, err, ErrPaymentExists)
+
+	_, err = pControl.FetchPayment(otherHash)
+	require.ErrorIs(t, err, ErrPaymentNotInitiated)
+
+	// Once failed, the payment can be retried with the same reference.
+	_, err = pControl.Fail(hash, FailureReasonNoRoute)
+	require.NoError(t, err)
+	require.NoError(t, pControl.InitPayment(hash, info))
+
+	// Retrying it without a reference releases the reference.
+	_, err = pControl.Fail(hash, FailureReasonNoRoute)
+	require.NoError(t, err)
+
+	require.NoError(t, pControl.InitPayment(hash, &noRef))
+
+	_, err = db.LookupPaymentByReference(ctx, ref)
+	require.ErrorIs(t, err, ErrPaymentNotInitiated)
+
+	// So the other payment can use it now. Deleting that payment releases
+	// the reference again.
+	require.NoError(t, pControl.InitPayment(otherHash, otherInfo))
+
+	payment, err = db.LookupPaymentByReference(ctx, ref)
+	require.NoError(t, err)
+	require.Equal(t, otherHash, payment.Info.PaymentIdentifier)
+
+	_, err = pControl.Fail(otherHash, FailureReasonNoRoute)
+	require.NoError(t, err)
+	_, err = db.DeletePayment(otherHash, false)
+	require.NoError(t, err)
+
+	_, err = db.LookupPaymentByReference(ctx, ref)
+	require.ErrorIs(t, err, ErrPaymentNotInitiated)
+}
+
+// TestPaymentControlFetchFailedAttempts checks that only the failed attempts
+// of a payment are returned, along with their failure info.
+func TestPaymentControlFetchFailedAttempts(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err, "unable to init db")
+
+	ctx := context.Background()
+	pControl := NewPaymentControl(db)
+
+	info, attempt, preimg, err := genInfo()
+	require.NoError(t, err)
+
+	// Failed attempts can't be fetched for an unknown payment.
+	hash := info.PaymentIdentifier
+	_, err = db.FetchFailedAttempts(ctx, hash)
+	require.ErrorIs(t, err, ErrPaymentNotInitiated)
+
+	require.NoError(t, pControl.InitPayment(hash, info))
+
+	// A payment without attempts has no failed ones.
+	failed, err := db.FetchFailedAttempts(ctx, hash)
+	require.NoError(t, err)
+	require.Empty(t, failed)
+
+	// Every attempt carries half of the payment amount.
+	registerShard := func(id uint64) {
+		t.Helper()
+
+		a := *attempt
+		a.AttemptID = id
+		a.Route = *attempt.Route.Copy()
+		a.Route.FinalHop().AmtToForward = info.Value / 2
+		a.Route.FinalHop().MPP = record.NewMPP(
+			info.Value, *info.PaymentAddr,
+		)
+
+		_, err := pControl.RegisterAttempt(hash, &a)
+		require.NoError(t, err)
+	}
+
+	// Fail two of the attempts, one of them with a failure message.
+	failures := map[uint64]*HTLCFailInfo{
+		0: {
+			Reason:             HTLCFailMessage,
+			Message:            &lnwire.FailTemporaryNodeFailure{},
+			FailureSourceIndex: 1,
+		},
+		2: {Reason: HTLCFailUnreadable},
+	}
+	for id := uint64(0); id < 3; id++ {
+		registerShard(id)
+
+		failure, ok := failures[id]
+		if !ok {
+			continue
+		}
+
+		_, _, err := pControl.FailAttempt(hash, id, failure)
+		require.NoError(t, err)
+	}
+
+	// Settle one attempt and leave another one in flight.
+	registerShard(3)
+	_, _, err = pControl.SettleAttempt(
+		hash, 1, &HTLCSettleInfo{Preimage: preimg},
+	)
+	require.NoError(t, err)
+
+	// Only the failed attempts are returned, in order.
+	failed, err = db.FetchFailedAttempts(ctx, hash)
+	require.NoError(t, err)
+	require.Len(t, failed, 2)
+
+	for i, id := range []uint64{0, 2} {
+		require.Equal(t, id, failed[i].AttemptID)
+		require.Nil(t, failed[i].Settle)
+		require.Nil(t, failed[i].Corrupt)
+		require.Equal(t, attempt.Hash, failed[i].Hash)
+
+		expected := failures[id]
+		require.Equal(t, expected.Reason, failed[i].Failure.Reason)
+		require.Equal(t, expected.Message, failed[i].Failure.Message)
+		require.Equal(
+			t, expected.FailureSourceIndex,
+			failed[i].Failure.FailureSourceIndex,
+		)
+	}
+}
+
+// TestPaymentControlFetchPaymentByPreimage checks that payments can be looked
+// up by the preimage they were settled with, including the preimages of the
+// shards of AMP payments.
+func TestPaymentControlFetchPaymentByPreimage(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err, "unable to init db")
+
+	ctx := context.Background()
+	pControl := NewPaymentControl(db)
+
+	info, attempt, preimg, err := genInfo()
+	require.NoError(t, err)
+
+	// Nothing can be found before the payment is settled.
+	_, err = db.FetchPaymentByPreimage(ctx, preimg)
+	require.ErrorIs(t, err, ErrPaymentNotInitiated)
+
+	hash := info.PaymentIdentifier
+	require.NoError(t, pControl.InitPayment(hash, info))
+	_, err = pControl.RegisterAttempt(hash, attempt)
+	require.NoError(t, err)
+
+	_, err = db.FetchPaymentByPreimage(ctx, preimg)
+	require.ErrorIs(t, err, ErrPaymentNotInitiated)
+
+	_, _, err = pControl.SettleAttempt(
+		hash, attempt.AttemptID, &HTLCSettleInfo{Preimage: preimg},
+	)
+	require.NoError(t, err)
+
+	payment, err := db.FetchPaymentByPreimage(ctx, preimg)
+	require.NoError(t, err)
+	require.Equal(t, hash, payment.Info.PaymentIdentifier)
+
+	// Register an AMP payment with two shards, each of which settles with
+	// a preimage of its own.
+	ampInfo, ampAttempt, _, err := genInfo()
+	require.NoError(t, err)
+
+	ampHash := ampInfo.PaymentIdentifier
+	require.NoError(t, pControl.InitPayment(ampHash, ampInfo))
+
+	shardPreimages := []lntypes.Preimage{{1}, {2}}
+	for i := range shardPreimages {
+		a := *ampAttempt
+		a.AttemptID = uint64(i)
+		a.Route = *ampAttempt.Route.Copy()
+
+		finalHop := a.Route.FinalHop()
+		finalHop.AmtToForward = ampInfo.Value / 2
+		finalHop.MPP = record.NewMPP(ampInfo.Value, [32]byte{1})
+		finalHop.AMP = record.NewAMP([32]byte{3}, [32]byte{4}, uint32(i))
+
+		_, err = pControl.RegisterAttempt(ampHash, &a)
+		require.NoError(t, err)
+	}
+
+	// Only the settled shard can be found by its preimage.
+	_, _, err = pControl.SettleAttempt(
+		ampHash, 1, &HTLCSettleInfo{Preimage: shardPreimages[1]},
+	)
+	require.NoError(t, err)
+
+	payment, err = db.FetchPaymentByPreimage(ctx, shardPreimages[1])
+	require.NoError(t, err)
+	require.Equal(t, ampHash, payment.Info.PaymentIdentifier)
+
+	_, err = db.FetchPaymentByPreimage(ctx, shardPreimages[0])
+	require.ErrorIs(t, err, ErrPaymentNotInitiated)
+
+	// The non-AMP payment is still found by its preimage.
+	payment, err = db.FetchPaymentByPreimage(ctx, preimg)
+	require.NoError(t, err)
+	require.Equal(t, hash, payment.Info.PaymentIdentifier)
+}
+
+// TestPaymentControlSkipPaymentRequest checks that the payment request is left
+// out when storing a payment if requested by the payment or by the database
+// options, and that such payments can still be fetched and queried.
+func TestPaymentControlSkipPaymentRequest(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		skipOption bool
+		skipFlag   bool
+		stored     bool
+	}{{
+		name:   "stored",
+		stored: true,
+	}, {
+		name:     "skipped by payment",
+		skipFlag: true,
+	}, {
+		name:       "skipped by option",
+		skipOption: true,
+	}}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, err := MakeTestDB(
+				t, OptionSkipPaymentRequests(test.skipOption),
+			)
+			require.NoError(t, err, "unable to init db")
+
+			pControl := NewPaymentControl(db)
+
+			info, _, _, err := genInfo()
+			require.NoError(t, err)
+			info.SkipPaymentRequest = test.skipFlag
+
+			hash := info.PaymentIdentifier
+			require.NoError(t, pControl.InitPayment(hash, info))
+
+			// The caller's info is left untouched.
+			require.Equal(t, []byte("hola"), info.PaymentRequest)
+
+			payment, err := pControl.FetchPayment(hash)
+			require.NoError(t, err)
+			require.Equal(t, info.Value, payment.Info.Value)
+
+			if test.stored {
+				require.Equal(
+					t, info.PaymentRequest,
+					payment.Info.PaymentRequest,
+				)
+			} else {
+				require.Empty(t, payment.Info.PaymentRequest)
+			}
+
+			resp, err := db.QueryPayments(
+				context.Background(), PaymentsQuery{
+					MaxPayments:       10,
+					IncludeIncomplete: true,
+				},
+			)
+			require.NoError(t, err)
+			require.Len(t, resp.Payments, 1)
+			require.Equal(
+				t, payment.Info.PaymentRequest,
+				resp.Payments[0].Info.PaymentRequest,
+			)
+		})
+	}
+}
+
+// TestPaymentControlMaxRecordedAttempts checks that the oldest failed attempts
+// of a payment are dropped once its maximum number of recorded attempts is
+// reached, while settled and in-flight attempts are kept.
+func TestPaymentControlMaxRecordedAttempts(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err, "unable to init db")
+
+	pControl := NewPaymentControl(db)
+
+	const (
+		maxAttempts = 10
+		numFailed   = 50
+	)
+
+	info, attempt, preimg, err := genInfo()
+	require.NoError(t, err)
+	info.FeeLimit = NoFeeLimit
+	info.MaxRecordedAttempts = maxAttempts
+
+	hash := info.PaymentIdentifier
+	require.NoError(t, pControl.InitPayment(hash, info))
+
+	// Every attempt carries half of the payment amount.
+	registerShard := func(id uint64) {
+		t.Helper()
+
+		a := *attempt
+		a.AttemptID = id
+		a.Route = *attempt.Route.Copy()
+		a.Route.FinalHop().AmtToForward = info.Value / 2
+		a.Route.FinalHop().MPP = record.NewMPP(
+			info.Value, *info.PaymentAddr,
+		)
+
+		_, err := pControl.RegisterAttempt(hash, &a)
+		require.NoError(t, err)
+	}
+
+	// assertAttempts asserts that exactly the attempts with the given IDs
+	// are recorded for the payment.
+	assertAttempts := func(expected []uint64) *MPPayment {
+		t.Helper()
+
+		payment, err := pControl.FetchPayment(hash)
+		require.NoError(t, err)
+
+		ids := make([]uint64, 0, len(payment.HTLCs))
+		for _, h := range payment.HTLCs {
+			ids = append(ids, h.AttemptID)
+		}
+		require.Equal(t, expected, ids)
+
+		return payment
+	}
+
+	for id := uint64(0); id < numFailed; id++ {
+		registerShard(id)
+
+		_, _, err := pControl.FailAttempt(
+			hash, id, &HTLCFailInfo{Reason: HTLCFailUnreadable},
+		)
+		require.NoError(t, err)
+	}
+
+	// Only the most recent failures are kept.
+	expected := make([]uint64, 0, maxAttempts)
+	for id := uint64(numFailed - maxAttempts); id < numFailed; id++ {
+		expected = append(expected, id)
+	}
+	assertAttempts(expected)
+
+	// Registering two more attempts drops the two oldest failures, and the
+	// in-flight attempts are kept.
+	registerShard(numFailed)
+	registerShard(numFailed + 1)
+
+	expected = append(expected[2:], numFailed, numFailed+1)
+	payment := assertAttempts(expected)
+	require.Equal(t, 2, payment.State.NumAttemptsInFlight)
+
+	// Settling the attempts leaves them in place, and the amount sent is
+	// still accounted for.
+	for _, id := range []uint64{numFailed, numFailed + 1} {
+		_, _, err := pControl.SettleAttempt(
+			hash, id, &HTLCSettleInfo{Preimage: preimg},
+		)
+		require.NoError(t, err)
+	}
+
+	payment = assertAttempts(expected)
+	require.Equal(t, StatusSucceeded, payment.Status)
+
+	sent, _ := payment.SentAmt()
+	require.Equal(t, 2*(info.Value/2), sent)
+}
+
+// TestPaymentControlMultiShard checks the ability of payment control to
+// have multiple in-flight HTLCs for a single payment.
+func TestPaymentControlMultiShard(t *testing.T) {
+	t.Parallel()
+
+	// We will register three HTLC attempts, and always fail the second
+	// one. We'll generate all combinations of settling/failing the first
+	// and third HTLC, and assert that the payment status end up as we
+	// expect.
+	type testCase struct {
+		settleFirst bool
+		settleLast  bool
+	}
+
+	var tests []testCase
+	for _, f := range []bool{true, false} {
+		for _, l := range []bool{true, false} {
+			tests = append(tests, testCase{f, l})
+		}
+	}
+
+	runSubTest := func(t *testing.T, test testCase) {
+		db, err := MakeTestDB(t)
+		if err != nil {
+			t.Fatalf("unable to init db: %v", err)
+		}
+
+		pControl := NewPaymentControl(db)
+
+		info, attempt, preimg, err := genInfo()
+		if err != nil {
+			t.Fatalf("unable to generate htlc message: %v", err)
+		}
+
+		// Init the payment, moving it to the StatusInFlight state.
+		err = pControl.InitPayment(info.PaymentIdentifier, info)
+		if err != nil {
+			t.Fatalf("unable to send htlc message: %v", err)
+		}
+
+		assertPaymentIndex(t, pControl, info.PaymentIdentifier)
+		assertPaymentStatus(
+			t, pControl, info.PaymentIdentifier, StatusInitiated,
+		)
+		assertPaymentInfo(
+			t, pControl, info.PaymentIdentifier, info, nil, nil,
+		)
+
+		// Create three unique attempts we'll use for the test, and
+		// register them with the payment control. We set each
+		// attempts's value to one third of the payment amount, and
+		// populate the MPP options.
+		shardAmt := info.Value / 3
+		attempt.Route.FinalHop().AmtToForward = shardAmt
+		attempt.Route.FinalHop().MPP = record.NewMPP(
+			info.Value, [32]byte{1},
+		)
+
+		var attempts []*HTLCAttemptInfo
+		for i := uint64(0); i < 3; i++ {
+			a := *attempt
+			a.AttemptID = i
+			attempts = append(attempts, &a)
+
+			_, err = pControl.RegisterAttempt(info.PaymentIdentifier, &a)
+			if err != nil {
+				t.Fatalf("unable to send htlc message: %v", err)
+			}
+			assertPaymentStatus(
+				t, pControl, info.PaymentIdentifier,
+				StatusInFlight,
+			)
+
+			htlc := &htlcStatus{
+				HTLCAttemptInfo: &a,
+			}
+			assertPaymentInfo(
+				t, pControl, info.PaymentIdentifier, info, nil, htlc,
+			)
+		}
+
+		// For a fourth attempt, check that attempting to
+		// register it will fail since the total sent amount
+		// will be too large.
+		b := *attempt
+		b.AttemptID = 3
+		_, err = pControl.RegisterAttempt(info.PaymentIdentifier, &b)
+		require.ErrorIs(t, err, ErrValueExceedsAmt)
+
+		// Fail the second attempt.
+		a := attempts[1]
+		htlcFail := HTLCFailUnreadable
+		_, _, err = pControl.FailAttempt(
+			info.PaymentIdentifier, a.AttemptID,
+			&HTLCFailInfo{
+				Reason: htlcFail,
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		htlc := &htlcStatus{
+			HTLCAttemptInfo: a,
+			failure:         &htlcFail,
+		}
+		assertPaymentInfo(
+			t, pControl, info.PaymentIdentifier, info, nil, htlc,
+		)
+
+		// Payment should still be in-flight.
+		assertPaymentStatus(t, pControl, info.PaymentIdentifier, StatusInFlight)
+
+		// Depending on the test case, settle or fail the first attempt.
+		a = attempts[0]
+		htlc = &htlcStatus{
+			HTLCAttemptInfo: a,
+		}
+
+		var firstFailReason *FailureReason
+		if test.settleFirst {
+			_, _, err := pControl.SettleAttempt(
+				info.PaymentIdentifier, a.AttemptID,
+				&HTLCSettleInfo{
+					Preimage: preimg,
+				},
+			)
+			if err != nil {
+				t.Fatalf("error shouldn't have been "+
+					"received, got: %v", err)
+			}
+
+			// Assert that the HTLC has had the preimage recorded.
+			htlc.settle = &preimg
+			assertPaymentInfo(
+				t, pControl, info.PaymentIdentifier, info, nil, htlc,
+			)
+		} else {
+			_, _, err := pControl.FailAttempt(
+				info.PaymentIdentifier, a.AttemptID,
+				&HTLCFailInfo{
+					Reason: htlcFail,
+				},
+			)
+			if err != nil {
+				t.Fatalf("error shouldn't have been "+
+					"received, got: %v", err)
+			}
+
+			// Assert the failure was recorded.
+			htlc.failure = &htlcFail
+			assertPaymentInfo(
+				t, pControl, info.PaymentIdentifier, info, nil, htlc,
+			)
+
+			// We also record a payment level fail, to move it into
+			// a terminal state.
+			failReason := FailureReasonNoRoute
+			_, err = pControl.Fail(info.PaymentIdentifier, failReason)
+			if err != nil {
+				t.Fatalf("unable to fail payment hash: %v", err)
+			}
+
+			// Record the reason we failed the payment, such that
+			// we can assert this later in the test.
+			firstFailReason = &failReason
+
+			// The payment is now considered pending fail, since
+			// there is still an active HTLC.
+			assertPaymentStatus(
+				t, pControl, info.PaymentIdentifier,
+				StatusInFlight,
+			)
+		}
+
+		// Try to register yet another attempt. This should fail now
+		// that the payment has reached a terminal condition.
+		b = *attempt
+		b.AttemptID = 3
+		_, err = pControl.RegisterAttempt(info.PaymentIdentifier, &b)
+		if test.settleFirst {
+			require.ErrorIs(t, err, ErrPaymentPendingSettled)
+		} else {
+			require.ErrorIs(t, err, ErrPaymentPendingFailed)
+		}
+
+		assertPaymentStatus(t, pControl, info.PaymentIdentifier, StatusInFlight)
+
+		// Settle or fail the remaining attempt based on the testcase.
+		a = attempts[2]
+		htlc = &htlcStatus{
+			HTLCAttemptInfo: a,
+		}
+		if test.settleLast {
+			// Settle the last outstanding attempt.
+			_, _, err = pControl.SettleAttempt(
+				info.PaymentIdentifier, a.AttemptID,
+				&HTLCSettleInfo{
+					Preimage: preimg,
+				},
+			)
+			require.NoError(t, err, "unable to settle")
+
+			htlc.settle = &preimg
+			assertPaymentInfo(
+				t, pControl, info.PaymentIdentifier,
+				info, firstFailReason, htlc,
+			)
+		} else {
+			// Fail the attempt.
+			_, _, err := pControl.FailAttempt(
+				info.PaymentIdentifier, a.AttemptID,
+				&HTLCFailInfo{
+					Reason: htlcFail,
+				},
+			)
+			if err != nil {
+				t.Fatalf("error shouldn't have been "+
+					"received, got: %v", err)
+			}
+
+			// Assert the failure was recorded.
+			htlc.failure = &htlcFail
+			assertPaymentInfo(
+				t, pControl, info.PaymentIdentifier, info,
+				firstFailReason, htlc,
+			)
+
+			// Check that a previous terminal failure can't be
+			// overridden with another reason, while failing the
+			// payment again with the same reason is a no-op. This
+			// allows multiple concurrent shards to write a
+			// terminal failure to the database without syncing.
+			failReason := FailureReasonPaymentDetails
+			_, err = pControl.Fail(info.PaymentIdentifier, failReason)
+			if firstFailReason != nil {
+				require.ErrorIs(t, err, ErrPaymentAlreadyFailed)
+
+				failReason = *firstFailReason
+				_, err = pControl.Fail(
+					info.PaymentIdentifier, failReason,
+				)
+			}
+			require.NoError(t, err, "unable to fail")
+		}
+
+		var (
+			finalStatus PaymentStatus
+			registerErr error
+		)
+
+		switch {
+		// If one of the attempts settled but the other failed with
+		// terminal error, we would still consider the payment is
+		// settled.
+		case test.settleFirst && !test.settleLast:
+			finalStatus = StatusSucceeded
+			registerErr = ErrPaymentAlreadySucceeded
+
+		case !test.settleFirst && test.settleLast:
+			finalStatus = StatusSucceeded
+			registerErr = ErrPaymentAlreadySucceeded
+
+		// If both failed, we end up in a failed status.
+		case !test.settleFirst && !test.settleLast:
+			finalStatus = StatusFailed
+			registerErr = ErrPaymentAlreadyFailed
+
+		// Otherwise, the payment has a succeed status.
+		case test.settleFirst && test.settleLast:
+			finalStatus = StatusSucceeded
+			registerErr = ErrPaymentAlreadySucceeded
+		}
+
+		assertPaymentStatus(
+			t, pControl, info.PaymentIdentifier, finalStatus,
+		)
+
+		// Finally assert we cannot register more attempts.
+		_, err = pControl.RegisterAttempt(info.PaymentIdentifier, &b)
+		require.Equal(t, registerErr, err)
+	}
+
+	for _, test := range tests {
+		test := test
+		subTest := fmt.Sprintf("first=%v, second=%v",
+			test.settleFirst, test.settleLast)
+
+		t.Run(subTest, func(t *testing.T) {
+			runSubTest(t, test)
+		})
+	}
+}
+
+func TestPaymentControlMPPRecordValidation(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err, "unable to init db")
+
+	pControl := NewPaymentControl(db)
+
+	info, attempt, _, err := genInfo()
+	require.NoError(t, err, "unable to generate htlc message")
+
+	// Init the payment.
+	err = pControl.InitPayment(info.PaymentIdentifier, info)
+	require.NoError(t, err, "unable to send htlc message")
+
+	// Create three unique attempts we'll use for the test, and
+	// register them with the payment control. We set each
+	// attempts's value to one third of the payment amount, and
+	// populate the MPP options.
+	shardAmt := info.Value / 3
+	attempt.Route.FinalHop().AmtToForward = shardAmt
+	attempt.Route.FinalHop().MPP = record.NewMPP(
+		info.Value, [32]byte{1},
+	)
+
+	_, err = pControl.RegisterAttempt(info.PaymentIdentifier, attempt)
+	require.NoError(t, err, "unable to send htlc message")
+
+	// Now try to register a non-MPP attempt, which should fail.
+	b := *attempt
+	b.AttemptID = 1
+	b.Route.FinalHop().MPP = nil
+	_, err = pControl.RegisterAttempt(info.PaymentIdentifier, &b)
+	if err != ErrMPPayment {
+		t.Fatalf("expected ErrMPPayment, got: %v", err)
+	}
+
+	// Try to register attempt one with a different payment address.
+	b.Route.FinalHop().MPP = record.NewMPP(
+		info.Value, [32]byte{2},
+	)
+	_, err = pControl.RegisterAttempt(info.PaymentIdentifier, &b)
+	if err != ErrMPPPaymentAddrMismatch {
+		t.Fatalf("expected ErrMPPPaymentAddrMismatch, got: %v", err)
+	}
+
+	// Try registering one with a different total amount.
+	b.Route.FinalHop().MPP = record.NewMPP(
+		info.Value/2, [32]byte{1},
+	)
+	_, err = pControl.RegisterAttempt(info.PaymentIdentifier, &b)
+	if err != ErrMPPTotalAmountMismatch {
+		t.Fatalf("expected ErrMPPTotalAmountMismatch, got: %v", err)
+	}
+
+	// Create and init a new payment. This time we'll check that we cannot
+	// register an MPP attempt if we already registered a non-MPP one.
+	info, attempt, _, err = genInfo()
+	require.NoError(t, err, "unable to generate htlc message")
+
+	err = pControl.InitPayment(info.PaymentIdentifier, info)
+	require.NoError(t, err, "unable to send htlc message")
+
+	attempt.Route.FinalHop().MPP = nil
+	_, err = pControl.RegisterAttempt(info.PaymentIdentifier, attempt)
+	require.NoError(t, err, "unable to send htlc message")
+
+	// Attempt to register an MPP attempt, which should fail.
+	b = *attempt
+	b.AttemptID = 1
+	b.Route.FinalHop().MPP = record.NewMPP(
+		info.Value, [32]byte{1},
+	)
+
+	_, err = pControl.RegisterAttempt(info.PaymentIdentifier, &b)
+	if err != ErrNonMPPayment {
+		t.Fatalf("expected ErrNonMPPayment, got: %v", err)
+	}
+}
+
+// TestVerifyAttempt checks that VerifyAttempt rejects attempts that don't
+// match the MPP options of the payment's in-flight attempts or that would
+// exceed the payment amount.
+func TestVerifyAttempt(t *testing.T) {
+	t.Parallel()
+
+	const total = lnwire.MilliSatoshi(3000)
+
+	var (
+		mpp        = record.NewMPP(total, [32]byte{1})
+		otherAddr  = record.NewMPP(total, [32]byte{2})
+		otherTotal = record.NewMPP(total/2, [32]byte{1})
+		failure    = &HTLCFailInfo{Reason: HTLCFailUnreadable}
+		settle     = &HTLCSettleInfo{}
+	)
+
+	// makeInfo creates an attempt that pays the given amount to the
+	// receiver with the given MPP record.
+	makeInfo := func(amt lnwire.MilliSatoshi,
+		mpp *record.MPP) HTLCAttemptInfo {
+
+		return HTLCAttemptInfo{
+			Route: route.Route{
+				Hops: []*route.Hop{{
+					AmtToForward: amt,
+					MPP:          mpp,
+				}},
+			},
+		}
+	}
+
+	// makeBlinded creates an attempt that pays the given amount to a
+	// blinded path with the given total amount.
+	makeBlinded := func(amt,
+		pathTotal lnwire.MilliSatoshi) HTLCAttemptInfo {
+
+		info := makeInfo(amt, nil)
+		info.Route.FinalHop().EncryptedData = []byte{1}
+		info.Route.FinalHop().TotalAmtMsat = pathTotal
+
+		return info
+	}
+
+	testCases := []struct {
+		name    string
+		htlcs   []HTLCAttempt
+		attempt HTLCAttemptInfo
+		err     error
+	}{
+		{
+			name:    "first non-mpp attempt",
+			attempt: makeInfo(total, nil),
+		},
+		{
+			name:    "first mpp attempt",
+			attempt: makeInfo(total/3, mpp),
+		},
+		{
+			name: "mpp attempt after failed non-mpp attempt",
+			htlcs: []HTLCAttempt{{
+				HTLCAttemptInfo: makeInfo(total, nil),
+				Failure:         failure,
+			}},
+			attempt: makeInfo(total/3, mpp),
+		},
+		{
+			name: "non-mpp attempt for mpp payment",
+			htlcs: []HTLCAttempt{{
+				HTLCAttemptInfo: makeInfo(total/3, mpp),
+			}},
+			attempt: makeInfo(total, nil),
+			err:     ErrMPPayment,
+		},
+		{
+			name: "mpp attempt for non-mpp payment",
+			htlcs: []HTLCAttempt{{
+				HTLCAttemptInfo: makeInfo(total, nil),
+			}},
+			attempt: makeInfo(total/3, mpp),
+			err:     ErrNonMPPayment,
+		},
+		{
+			name: "payment address mismatch",
+			htlcs: []HTLCAttempt{{
+				HTLCAttemptInfo: makeInfo(total/3, mpp),
+			}},
+			attempt: makeInfo(total/3, otherAddr),
+			err:     ErrMPPPaymentAddrMismatch,
+		},
+		{
+			name: "total amount mismatch",
+			htlcs: []HTLCAttempt{{
+				HTLCAttemptInfo: makeInfo(total/3, mpp),
+			}},
+			attempt: makeInfo(total/3, otherTotal),
+			err:     ErrMPPTotalAmountMismatch,
+		},
+		{
+			name:    "non-mpp value mismatch",
+			attempt: makeInfo(total-1, nil),
+			err:     ErrValueMismatch,
+		},
+		{
+			name: "settled attempts count towards sent amount",
+			htlcs: []HTLCAttempt{{
+				HTLCAttemptInfo: makeInfo(total/2, mpp),
+				Settle:          settle,
+			}},
+			attempt: makeInfo(total/2+1, mpp),
+			err:     ErrValueExceedsAmt,
+		},
+		{
+			name: "in-flight attempts count towards sent amount",
+			htlcs: []HTLCAttempt{{
+				HTLCAttemptInfo: makeInfo(total/2, mpp),
+			}},
+			attempt: makeInfo(total/2+1, mpp),
+			err:     ErrValueExceedsAmt,
+		},
+		{
+			name: "failed attempts don't count towards sent amount",
+			htlcs: []HTLCAttempt{{
+				HTLCAttemptInfo: makeInfo(total/2, mpp),
+				Failure:         failure,
+			}},
+			attempt: makeInfo(total, mpp),
+		},
+		{
+			name: "blinded shards",
+			htlcs: []HTLCAttempt{{
+				HTLCAttemptInfo: makeBlinded(total/3, total),
+			}},
+			attempt: makeBlinded(total/3, total),
+		},
+		{
+			name: "blinded total amount mismatch",
+			htlcs: []HTLCAttempt{{
+				HTLCAttemptInfo: makeBlinded(total/3, total),
+			}},
+			attempt: makeBlinded(total/3, total/2),
+			err:     ErrBlindedPaymentTotalAmountMismatch,
+		},
+		{
+			name: "failed blinded shards aren't compared",
+			htlcs: []HTLCAttempt{{
+				HTLCAttemptInfo: makeBlinded(total/3, total/2),
+				Failure:         failure,
+			}},
+			attempt: makeBlinded(total/3, total),
+		},
+		{
+			name: "mpp record in blinded attempt",
+			attempt: func() HTLCAttemptInfo {
+				info := makeBlinded(total/3, total)
+				info.Route.FinalHop().MPP = mpp
+
+				return info
+			}(),
+			err: ErrMPPRecordInBlindedPayment,
+		},
+		{
+			name: "blinded attempt for mpp payment",
+			htlcs: []HTLCAttempt{{
+				HTLCAttemptInfo: makeInfo(total/3, mpp),
+			}},
+			attempt: makeBlinded(total/3, total),
+			err:     ErrMPPRecordInBlindedPayment,
+		},
+		{
+			name: "blinded attempt exceeds payment amount",
+			htlcs: []HTLCAttempt{{
+				HTLCAttemptInfo: makeBlinded(total/2, total),
+			}},
+			attempt: makeBlinded(total/2+1, total),
+			err:     ErrValueExceedsAmt,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			payment := &MPPayment{
+				Info:  &PaymentCreationInfo{Value: total},
+				HTLCs: tc.htlcs,
+			}
+
+			err := VerifyAttempt(payment, &tc.attempt)
+			require.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+// TestPaymentControlCustomRecordRange checks that attempts with hop-level
+// custom records below the custom type range are rejected.
+func TestPaymentControlCustomRecordRange(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+
+	info, attempt, _, err := genInfo()
+	require.NoError(t, err)
+
+	err = pControl.InitPayment(info.PaymentIdentifier, info)
+	require.NoError(t, err)
+
+	// Add an out of range record to the second hop.
+	attempt.Route.Hops[1].CustomRecords = record.CustomSet{
+		100:                    []byte{1},
+		record.CustomTypeStart: []byte{2},
+	}
+
+	_, err = pControl.RegisterAttempt(info.PaymentIdentifier, attempt)
+
+	var rangeErr *CustomRecordRangeError
+	require.ErrorAs(t, err, &rangeErr)
+	require.Equal(t, 1, rangeErr.HopIndex)
+	require.EqualValues(t, 100, rangeErr.Type)
+
+	// The attempt wasn't stored.
+	payment, err := pControl.FetchPayment(info.PaymentIdentifier)
+	require.NoError(t, err)
+	require.Empty(t, payment.HTLCs)
+}
+
+// TestPaymentControlBlindedRecordValidation checks that the shards of a
+// payment to a blinded path are validated against the already registered
+// shards.
+func TestPaymentControlBlindedRecordValidation(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+
+	info, attempt, _, err := genInfo()
+	require.NoError(t, err)
+
+	err = pControl.InitPayment(info.PaymentIdentifier, info)
+	require.NoError(t, err)
+
+	// Send half of the payment amount to a blinded path. The final hop
+	// of the route is replaced, so the shared test hop isn't modified.
+	shardAmt := info.Value / 2
+	finalHop := *attempt.Route.FinalHop()
+	finalHop.MPP = nil
+	finalHop.AMP = nil
+	finalHop.AmtToForward = shardAmt
+	finalHop.EncryptedData = []byte{1, 2, 3}
+	finalHop.TotalAmtMsat = info.Value
+	attempt.Route.Hops[len(attempt.Route.Hops)-1] = &finalHop
+
+	_, err = pControl.RegisterAttempt(info.PaymentIdentifier, attempt)
+	require.NoError(t, err)
+
+	// A shard with a different blinded path total amount is rejected.
+	b := *attempt
+	b.AttemptID = 1
+	b.Route = *attempt.Route.Copy()
+	b.Route.FinalHop().TotalAmtMsat = info.Value - 1
+	_, err = pControl.RegisterAttempt(info.PaymentIdentifier, &b)
+	require.ErrorIs(t, err, ErrBlindedPaymentTotalAmountMismatch)
+
+	// So is a shard with an MPP record.
+	b.Route.FinalHop().TotalAmtMsat = info.Value
+	b.Route.FinalHop().MPP = record.NewMPP(info.Value, [32]byte{1})
+	_, err = pControl.RegisterAttempt(info.PaymentIdentifier, &b)
+	require.ErrorIs(t, err, ErrMPPRecordInBlindedPayment)
+
+	// A matching shard completes the payment amount.
+	b.Route.FinalHop().MPP = nil
+	_, err = pControl.RegisterAttempt(info.PaymentIdentifier, &b)
+	require.NoError(t, err)
+}
+
+// TestPaymentControlHopCustomRecords checks that the hop-level custom records
+// of an attempt compare equal after a round trip through the database, for
+// nil, empty and non-empty record values and for a hop without custom
+// records.
+func TestPaymentControlHopCustomRecords(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+
+	info, attempt, _, err := genInfo()
+	require.NoError(t, err)
+
+	err = pControl.InitPayment(info.PaymentIdentifier, info)
+	require.NoError(t, err)
+
+	// The first hop carries custom records with nil, empty and non-empty
+	// values. The final hop carries an MPP record, but no custom records.
+	attempt.Route.Hops[0].CustomRecords = record.CustomSet{
+		65536: nil,
+		65537: []byte{},
+		65538: []byte{1, 2, 3},
+	}
+	finalHop := attempt.Route.FinalHop()
+	attempt.Route.Hops[len(attempt.Route.Hops)-1] = &route.Hop{
+		PubKeyBytes:      finalHop.PubKeyBytes,
+		ChannelID:        finalHop.ChannelID,
+		OutgoingTimeLock: finalHop.OutgoingTimeLock,
+		AmtToForward:     finalHop.AmtToForward,
+		MPP:              record.NewMPP(info.Value, [32]byte{1}),
+	}
+
+	_, err = pControl.RegisterAttempt(info.PaymentIdentifier, attempt)
+	require.NoError(t, err)
+
+	// The nil value is normalized to an empty value when the attempt is
+	// registered.
+	require.Equal(
+		t, []byte{}, attempt.Route.Hops[0].CustomRecords[65536],
+	)
+
+	payment, err := pControl.FetchPayment(info.PaymentIdentifier)
+	require.NoError(t, err)
+	require.Len(t, payment.HTLCs, 1)
+	require.Equal(t, attempt.Route, payment.HTLCs[0].Route)
+}
+
+// TestPaymentControlFailAttemptWireMessage checks that the wire failure
+// message of a failed HTLC attempt is persisted and decoded again when the
+// payment is fetched, together with the failure reason and source index.
+func TestPaymentControlFailAttemptWireMessage(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err, "unable to init db")
+
+	pControl := NewPaymentControl(db)
+
+	info, attempt, _, err := genInfo()
+	require.NoError(t, err, "unable to generate htlc message")
+
+	err = pControl.InitPayment(info.PaymentIdentifier, info)
+	require.NoError(t, err, "unable to send htlc message")
+
+	_, err = pControl.RegisterAttempt(info.PaymentIdentifier, attempt)
+	require.NoError(t, err, "unable to register attempt")
+
+	// Fail the attempt with a temporary channel failure that carries a
+	// channel update, as reported by the second hop of the route.
+	update := &lnwire.ChannelUpdate{
+		ShortChannelID:  lnwire.NewShortChanIDFromInt(1234),
+		Timestamp:       uint32(time.Now().Unix()),
+		MessageFlags:    lnwire.ChanUpdateRequiredMaxHtlc,
+		TimeLockDelta:   40,
+		HtlcMinimumMsat: 1000,
+		HtlcMaximumMsat: 100_000_000,
+		BaseFee:         1000,
+		FeeRate:         1,
+		ExtraOpaqueData: make([]byte, 0),
+	}
+	failInfo := &HTLCFailInfo{
+		FailTime:           time.Unix(time.Now().Unix(), 0),
+		Message:            lnwire.NewTemporaryChannelFailure(update),
+		Reason:             HTLCFailMessage,
+		FailureSourceIndex: 2,
+	}
+	_, _, err = pControl.FailAttempt(
+		info.PaymentIdentifier, attempt.AttemptID, failInfo,
+	)
+	require.NoError(t, err, "unable to fail htlc")
+
+	// Reload the payment and make sure the attempt exposes the same typed
+	// failure we stored.
+	payment, err := pControl.FetchPayment(info.PaymentIdentifier)
+	require.NoError(t, err)
+	require.Len(t, payment.HTLCs, 1)
+
+	failure := payment.HTLCs[0].Failure
+	require.NotNil(t, failure)
+	require.Equal(t, HTLCFailMessage, failure.Reason)
+	require.EqualValues(t, 2, failure.FailureSourceIndex)
+	require.True(t, failInfo.FailTime.Equal(failure.FailTime))
+
+	msg, ok := failure.Message.(*lnwire.FailTemporaryChannelFailure)
+	require.True(t, ok, "unexpected failure message type %T",
+		failure.Message)
+	require.Equal(t, update, msg.Update)
+}
+
+// TestPaymentControlResolvedAttempt checks that settling or failing an attempt
+// returns the resolved attempt, carrying the settle or failure info that was
+// just written.
+func TestPaymentControlResolvedAttempt(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err, "unable to init db")
+
+	pControl := NewPaymentControl(db)
+
+	info, attempt, preimg, err := genInfo()
+	require.NoError(t, err, "unable to generate htlc message")
+
+	err = pControl.InitPayment(info.PaymentIdentifier, info)
+	require.NoError(t, err, "unable to send htlc message")
+
+	_, err = pControl.RegisterAttempt(info.PaymentIdentifier, attempt)
+	require.NoError(t, err, "unable to register attempt")
+
+	failInfo := &HTLCFailInfo{
+		FailTime:           time.Unix(time.Now().Unix(), 0),
+		Message:            &lnwire.FailTemporaryNodeFailure{},
+		Reason:             HTLCFailMessage,
+		FailureSourceIndex: 1,
+	}
+	payment, failed, err := pControl.FailAttempt(
+		info.PaymentIdentifier, attempt.AttemptID, failInfo,
+	)
+	require.NoError(t, err, "unable to fail htlc")
+	require.Equal(t, StatusInFlight, payment.Status)
+
+	require.Equal(t, attempt.AttemptID, failed.AttemptID)
+	require.Nil(t, failed.Settle)
+	require.NotNil(t, failed.Failure)
+	require.Equal(t, HTLCFailMessage, failed.Failure.Reason)
+	require.EqualValues(t, 1, failed.Failure.FailureSourceIndex)
+	require.Equal(
+		t, &lnwire.FailTemporaryNodeFailure{}, failed.Failure.Message,
+	)
+
+	// Retry with a second attempt and settle it.
+	attempt.AttemptID = 1
+	_, err = pControl.RegisterAttempt(info.PaymentIdentifier, attempt)
+	require.NoError(t, err, "unable to register attempt")
+
+	payment, settled, err := pControl.SettleAttempt(
+		info.PaymentIdentifier, attempt.AttemptID,
+		&HTLCSettleInfo{
+			Preimage:   preimg,
+			SettleTime: time.Unix(time.Now().Unix(), 0),
+		},
+	)
+	require.NoError(t, err, "unable to settle htlc")
+	require.Equal(t, StatusSucceeded, payment.Status)
+
+	require.Equal(t, attempt.AttemptID, settled.AttemptID)
+	require.Nil(t, settled.Failure)
+	require.NotNil(t, settled.Settle)
+	require.Equal(t, preimg, settled.Settle.Preimage)
+
+	// The returned attempt matches the attempt of the returned payment.
+	fromPayment, err := payment.GetAttempt(attempt.AttemptID)
+	require.NoError(t, err)
+	require.Equal(t, fromPayment, settled)
+
+	// Resolving an unknown attempt fails without returning an attempt.
+	_, unknown, err := pControl.FailAttempt(
+		info.PaymentIdentifier, 99, failInfo,
+	)
+	require.Error(t, err)
+	require.Nil(t, unknown)
+}
+
+// TestPaymentControlAbandonAttempt checks that abandoning an attempt records
+// it with the abandoned failure reason and frees up its amount for a retry.
+func TestPaymentControlAbandonAttempt(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err, "unable to init db")
+
+	pControl := NewPaymentControl(db)
+
+	info, attempt, _, err := genInfo()
+	require.NoError(t, err, "unable to generate htlc message")
+
+	err = pControl.InitPayment(info.PaymentIdentifier, info)
+	require.NoError(t, err, "unable to send htlc message")
+
+	_, err = pControl.RegisterAttempt(info.PaymentIdentifier, attempt)
+	require.NoError(t, err, "unable to register attempt")
+
+	// The attempt carries the full amount, so no other attempt can be
+	// registered while it is in flight.
+	retry := *attempt
+	retry.AttemptID = 1
+	_, err = pControl.RegisterAttempt(info.PaymentIdentifier, &retry)
+	require.ErrorIs(t, err, ErrValueExceedsAmt)
+
+	payment, abandoned, err := pControl.AbandonAttempt(
+		info.PaymentIdentifier, attempt.AttemptID,
+	)
+	require.NoError(t, err)
+	require.Equal(t, HTLCFailAbandoned, abandoned.Failure.Reason)
+
+	// The abandoned attempt is treated like a failed one, leaving the full
+	// amount to be sent while the payment stays in flight.
+	require.Equal(t, StatusInFlight, payment.Status)
+	require.Zero(t, payment.State.NumAttemptsInFlight)
+	require.Equal(t, info.Value, payment.State.RemainingAmt)
+
+	// An abandoned attempt can't be resolved again.
+	_, _, err = pControl.AbandonAttempt(
+		info.PaymentIdentifier, attempt.AttemptID,
+	)
+	require.ErrorIs(t, err, ErrAttemptAlreadyFailed)
+
+	// The amount of the abandoned attempt can now be retried.
+	_, err = pControl.RegisterAttempt(info.PaymentIdentifier, &retry)
+	require.NoError(t, err)
+}
+
+// TestPaymentControlResolveAttempts checks that a batch of settled and failed
+// attempts is recorded in a single update, and that an invalid resolution
+// rolls back the whole batch.
+func TestPaymentControlResolveAttempts(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err, "unable to init db")
+
+	pControl := NewPaymentControl(db)
+
+	var updates []*PaymentUpdate
+	pControl.SetNotifier(func(update *PaymentUpdate) {
+		updates = append(updates, update)
+	})
+
+	hash, preimg, ids := registerShards(t, pControl, 4)
+	updates = nil
+
+	settle := &HTLCSettleInfo{Preimage: preimg}
+	failure := &HTLCFailInfo{Reason: HTLCFailUnreadable}
+
+	// Settle one attempt and fail two others in a single batch.
+	payment, err := pControl.ResolveAttempts(hash, []AttemptResolution{
+		{AttemptID: ids[0], Settle: settle},
+		{AttemptID: ids[1], Failure: failure},
+		{AttemptID: ids[2], Failure: failure},
+	})
+	require.NoError(t, err)
+	require.Equal(t, StatusInFlight, payment.Status)
+	require.Equal(t, 1, payment.State.NumAttemptsInFlight)
+
+	require.NotNil(t, payment.HTLCs[ids[0]].Settle)
+	require.NotNil(t, payment.HTLCs[ids[1]].Failure)
+	require.NotNil(t, payment.HTLCs[ids[2]].Failure)
+
+	// Every resolved attempt is notified with the resulting payment.
+	require.Len(t, updates, 3)
+	for i, event := range []AttemptEvent{
+		AttemptEventSettled, AttemptEventFailed, AttemptEventFailed,
+	} {
+		require.Equal(t, ids[i], *updates[i].AttemptID)
+		require.Equal(t, event, updates[i].AttemptEvent)
+		require.Equal(t, payment, updates[i].Payment)
+	}
+	updates = nil
+
+	// assertUnchanged asserts that the last attempt is still in flight,
+	// and that no update was notified.
+	assertUnchanged := func() {
+		t.Helper()
+
+		fetched, err := pControl.FetchPayment(hash)
+		require.NoError(t, err)
+		require.Equal(t, payment.State, fetched.State)
+		require.Nil(t, fetched.HTLCs[ids[3]].Settle)
+		require.Nil(t, fetched.HTLCs[ids[3]].Failure)
+		require.Empty(t, updates)
+	}
+
+	// A batch with an already resolved attempt is rolled back entirely,
+	// leaving the valid resolution unrecorded.
+	_, err = pControl.ResolveAttempts(hash, []AttemptResolution{
+		{AttemptID: ids[3], Settle: settle},
+		{AttemptID: ids[1], Failure: failure},
+	})
+	require.ErrorIs(t, err, ErrAttemptAlreadyFailed)
+	assertUnchanged()
+
+	// The same goes for an attempt that is resolved twice in a batch.
+	_, err = pControl.ResolveAttempts(hash, []AttemptResolution{
+		{AttemptID: ids[3], Settle: settle},
+		{AttemptID: ids[3], Settle: settle},
+	})
+	require.ErrorIs(t, err, ErrAttemptAlreadySettled)
+	assertUnchanged()
+
+	// A failure source outside of the attempt's route is rejected.
+	_, err = pControl.ResolveAttempts(hash, []AttemptResolution{{
+		AttemptID: ids[3],
+		Failure: &HTLCFailInfo{
+			Reason:             HTLCFailUnreadable,
+			FailureSourceIndex: 100,
+		},
+	}})
+	require.ErrorIs(t, err, ErrInvalidFailureSourceIndex)
+	assertUnchanged()
+
+	// Resolutions must be given, and each of them must either settle or
+	// fail its attempt.
+	_, err = pControl.ResolveAttempts(hash, nil)
+	require.Error(t, err)
+
+	_, err = pControl.ResolveAttempts(hash, []AttemptResolution{
+		{AttemptID: ids[3]},
+	})
+	require.Error(t, err)
+
+	_, err = pControl.ResolveAttempts(hash, []AttemptResolution{
+		{AttemptID: ids[3], Settle: settle, Failure: failure},
+	})
+	require.Error(t, err)
+	assertUnchanged()
+
+	// Settling the last attempt completes the payment.
+	payment, err = pControl.ResolveAttempts(hash, []AttemptResolution{
+		{AttemptID: ids[3], Settle: settle},
+	})
+	require.NoError(t, err)
+	require.Equal(t, StatusSucceeded, payment.Status)
+	require.Zero(t, payment.State.NumAttemptsInFlight)
+}
+
+// TestPaymentControlNotifier checks that the notifier is called exactly once
+// for every committed update and never for updates that were rolled back.
+func TestPaymentControlNotifier(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err, "unable to init db")
+
+	pControl := NewPaymentControl(db)
+
+	var updates []*PaymentUpdate
+	pControl.SetNotifier(func(update *PaymentUpdate) {
+		updates = append(updates, update)
+	})
+
+	// popUpdate asserts that exactly one update was received and returns
+	// it.
+	popUpdate := func() *PaymentUpdate {
+		t.Helper()
+
+		require.Len(t, updates, 1)
+		update := updates[0]
+		updates = nil
+
+		return update
+	}
+
+	info, attempt, preimg, err := genInfo()
+	require.NoError(t, err, "unable to generate htlc message")
+	hash := info.PaymentIdentifier
+
+	// Initializing a payment isn't notified.
+	err = pControl.InitPayment(hash, info)
+	require.NoError(t, err, "unable to send htlc message")
+	require.Empty(t, updates)
+
+	_, err = pControl.RegisterAttempt(hash, attempt)
+	require.NoError(t, err, "unable to register attempt")
+
+	update := popUpdate()
+	require.Equal(t, hash, update.PaymentIdentifier)
+	require.Equal(t, &attempt.AttemptID, update.AttemptID)
+	require.Equal(t, AttemptEventRegistered, update.AttemptEvent)
+	require.Equal(t, StatusInFlight, update.Status)
+	require.Len(t, update.Payment.HTLCs, 1)
+
+	// An attempt exceeding the payment amount is rejected within the
+	// transaction, so nothing is notified.
+	attempt.AttemptID = 1
+	_, err = pControl.RegisterAttempt(hash, attempt)
+	require.Error(t, err)
+	require.Empty(t, updates)
+
+	// Resolving an unknown attempt fails, so nothing is notified either.
+	failInfo := &HTLCFailInfo{Reason: HTLCFailUnreadable}
+	_, _, err = pControl.FailAttempt(hash, 99, failInfo)
+	require.Error(t, err)
+	require.Empty(t, updates)
+
+	_, _, err = pControl.FailAttempt(hash, 0, failInfo)
+	require.NoError(t, err, "unable to fail htlc")
+
+	update = popUpdate()
+	require.EqualValues(t, 0, *update.AttemptID)
+	require.Equal(t, AttemptEventFailed, update.AttemptEvent)
+	require.Equal(t, StatusInFlight, update.Status)
+	require.NotNil(t, update.Payment.HTLCs[0].Failure)
+
+	// An attempt can't be resolved twice.
+	_, _, err = pControl.FailAttempt(hash, 0, failInfo)
+	require.ErrorIs(t, err, ErrAttemptAlreadyFailed)
+	require.Empty(t, updates)
+
+	_, err = pControl.RegisterAttempt(hash, attempt)
+	require.NoError(t, err, "unable to register attempt")
+	require.EqualValues(t, 1, *popUpdate().AttemptID)
+
+	_, _, err = pControl.SettleAttempt(
+		hash, attempt.AttemptID, &HTLCSettleInfo{Preimage: preimg},
+	)
+	require.NoError(t, err, "unable to settle htlc")
+
+	update = popUpdate()
+	require.EqualValues(t, 1, *update.AttemptID)
+	require.Equal(t, AttemptEventSettled, update.AttemptEvent)
+	require.Equal(t, StatusSucceeded, update.Status)
+
+	// Failing the payment is notified without an attempt ID.
+	_, err = pControl.Fail(hash, FailureReasonError)
+	require.NoError(t, err)
+
+	update = popUpdate()
+	require.Equal(t, hash, update.PaymentIdentifier)
+	require.Nil(t, update.AttemptID)
+	require.Equal(t, AttemptEventNone, update.AttemptEvent)
+
+	// Failing an unknown payment writes nothing and isn't notified.
+	_, err = pControl.Fail(lntypes.Hash{1}, FailureReasonError)
+	require.ErrorIs(t, err, ErrPaymentNotInitiated)
+	require.Empty(t, updates)
+}
+
+// failureWithSize returns a failure message whose encoding has exactly the
+// given size.
+func failureWithSize(t *testing.T, size int) lnwire.FailureMessage {
+	t.Helper()
+
+	update := &lnwire.ChannelUpdate{
+		ShortChannelID:  lnwire.NewShortChanIDFromInt(1234),
+		MessageFlags:    lnwire.ChanUpdateRequiredMaxHtlc,
+		HtlcMaximumMsat: 100_000_000,
+		ExtraOpaqueData: make([]byte, 0),
+	}
+	failure := lnwire.NewTemporaryChannelFailure(update)
+
+	// Pad the channel update with extra opaque data to reach the
+	// requested size.
+	var b bytes.Buffer
+	require.NoError(t, lnwire.EncodeFailureMessage(&b, failure, 0))
+	require.LessOrEqual(t, b.Len(), size)
+	update.ExtraOpaqueData = make([]byte, size-b.Len())
+
+	b.Reset()
+	require.NoError(t, lnwire.EncodeFailureMessage(&b, failure, 0))
+	require.Equal(t, size, b.Len())
+
+	return failure
+}
+
+// TestPaymentControlFailureMessageSize checks that failure messages of up to
+// the maximum size can be stored, while larger ones are rejected.
+func TestPaymentControlFailureMessageSize(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err, "unable to init db")
+
+	pControl := NewPaymentControl(db)
+
+	info, attempt, _, err := genInfo()
+	require.NoError(t, err, "unable to generate htlc message")
+
+	err = pControl.InitPayment(info.PaymentIdentifier, info)
+	require.NoError(t, err, "unable to send htlc message")
+
+	_, err = pControl.RegisterAttempt(info.PaymentIdentifier, attempt)
+	require.NoError(t, err, "unable to register attempt")
+
+	// A failure message exceeding the maximum size is rejected, leaving
+	// the attempt in flight.
+	_, _, err = pControl.FailAttempt(
+		info.PaymentIdentifier, attempt.AttemptID, &HTLCFailInfo{
+			Message: failureWithSize(t, math.MaxUint16+1),
+			Reason:  HTLCFailMessage,
+		},
+	)
+	require.ErrorIs(t, err, ErrFailureMessageTooLarge)
+
+	payment, err := pControl.FetchPayment(info.PaymentIdentifier)
+	require.NoError(t, err)
+	require.Len(t, payment.InFlightHTLCs(), 1)
+
+	// A failure message of the maximum size is stored and read back.
+	message := failureWithSize(t, math.MaxUint16)
+	_, _, err = pControl.FailAttempt(
+		info.PaymentIdentifier, attempt.AttemptID, &HTLCFailInfo{
+			Message: message,
+			Reason:  HTLCFailMessage,
+		},
+	)
+	require.NoError(t, err)
+
+	payment, err = pControl.FetchPayment(info.PaymentIdentifier)
+	require.NoError(t, err)
+	require.Nil(t, payment.HTLCs[0].Corrupt)
+	require.Equal(t, HTLCFailMessage, payment.HTLCs[0].Failure.Reason)
+	require.Equal(t, message, payment.HTLCs[0].Failure.Message)
+}
+
+// TestPaymentControlFailureSourceIndex checks that an attempt can only be
+// failed with a failure source index that points into its route.
+func TestPaymentControlFailureSourceIndex(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err, "unable to init db")
+
+	pControl := NewPaymentControl(db)
+
+	info, attempt, _, err := genInfo()
+	require.NoError(t, err, "unable to generate htlc message")
+
+	err = pControl.InitPayment(info.PaymentIdentifier, info)
+	require.NoError(t, err, "unable to send htlc message")
+
+	_, err = pControl.RegisterAttempt(info.PaymentIdentifier, attempt)
+	require.NoError(t, err, "unable to register attempt")
+
+	// An index beyond the final hop is rejected, leaving the attempt in
+	// flight.
+	numHops := uint32(len(attempt.Route.Hops))
+	_, _, err = pControl.FailAttempt(
+		info.PaymentIdentifier, attempt.AttemptID, &HTLCFailInfo{
+			Reason:             HTLCFailUnknown,
+			FailureSourceIndex: numHops + 1,
+		},
+	)
+	require.ErrorIs(t, err, ErrInvalidFailureSourceIndex)
+
+	payment, err := pControl.FetchPayment(info.PaymentIdentifier)
+	require.NoError(t, err)
+	require.Len(t, payment.InFlightHTLCs(), 1)
+
+	// The final hop is a valid failure source.
+	_, failed, err := pControl.FailAttempt(
+		info.PaymentIdentifier, attempt.AttemptID, &HTLCFailInfo{
+			Reason:             HTLCFailUnknown,
+			FailureSourceIndex: numHops,
+		},
+	)
+	require.NoError(t, err)
+
+	source, err := failed.FailureSourceNode()
+	require.NoError(t, err)
+	require.Equal(t, attempt.Route.Hops[numHops-1].PubKeyBytes, source)
+}
+
+// TestPaymentControlStoreTimings checks that the timings of a payment are
+// stored and read back, and that they are removed when the payment is
+// re-initialized.
+func TestPaymentControlStoreTimings(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err, "unable to init db")
+
+	pControl := NewPaymentControl(db)
+
+	info, _, _, err := genInfo()
+	require.NoError(t, err, "unable to generate htlc message")
+
+	// Timings can't be stored for an unknown payment.
+	timings := &PaymentTimings{
+		InitCommitted:          time.Unix(0, 100),
+		FirstRouteFound:        time.Unix(0, 200),
+		FirstAttemptRegistered: time.Unix(0, 300),
+	}
+	err = pControl.StoreTimings(info.PaymentIdentifier, timings)
+	require.ErrorIs(t, err, ErrPaymentNotInitiated)
+
+	err = pControl.InitPayment(info.PaymentIdentifier, info)
+	require.NoError(t, err, "unable to send htlc message")
+
+	// A payment without stored timings has none.
+	payment, err := pControl.FetchPayment(info.PaymentIdentifier)
+	require.NoError(t, err)
+	require.Nil(t, payment.Timings)
+
+	// The stored timings are read back, including the unobserved
+	// dispatch time.
+	err = pControl.StoreTimings(info.PaymentIdentifier, timings)
+	require.NoError(t, err)
+
+	payment, err = pControl.FetchPayment(info.PaymentIdentifier)
+	require.NoError(t, err)
+	require.Equal(t, timings, payment.Timings)
+	require.True(t, payment.Timings.FirstAttemptDispatched.IsZero())
+
+	// Once the payment is failed and re-initialized, the timings of the
+	// earlier attempt are gone.
+	_, err = pControl.Fail(info.PaymentIdentifier, FailureReasonNoRoute)
+	require.NoError(t, err)
+
+	err = pControl.InitPayment(info.PaymentIdentifier, info)
+	require.NoError(t, err)
+
+	payment, err = pControl.FetchPayment(info.PaymentIdentifier)
+	require.NoError(t, err)
+	require.Nil(t, payment.Timings)
+}
+
+// TestPaymentControlFailureDetail checks that the failure detail of a payment
+// is stored and read back, that oversized details are rejected and that
+// payments failed without a detail, like the ones failed before the detail
+// existed, are read back without one.
+func TestPaymentControlFailureDetail(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err, "unable to init db")
+
+	pControl := NewPaymentControl(db)
+
+	info, _, _, err := genInfo()
+	require.NoError(t, err, "unable to generate htlc message")
+
+	hash := info.PaymentIdentifier
+	require.NoError(t, pControl.InitPayment(hash, info))
+
+	// A payment failed without a detail has none.
+	_, err = pControl.Fail(hash, FailureReasonNoRoute)
+	require.NoError(t, err)
+
+	payment, err := pControl.FetchPayment(hash)
+	require.NoError(t, err)
+	require.Equal(t, StatusFailed, payment.Status)
+	require.Nil(t, payment.FailureDetail)
+
+	// Retry the payment. A detail exceeding the maximum size is rejected,
+	// leaving the payment in flight.
+	require.NoError(t, pControl.InitPayment(hash, info))
+
+	_, err = pControl.FailWithDetail(
+		hash, FailureReasonNoRoute, &PaymentFailureDetail{
+			Error: strings.Repeat("x", MaxPaymentFailureDetailSize),
+		},
+	)
+	require.ErrorIs(t, err, ErrPaymentFailureDetailTooLarge)
+
+	payment, err = pControl.FetchPayment(hash)
+	require.NoError(t, err)
+	require.Equal(t, StatusInitiated, payment.Status)
+
+	// A valid detail is stored along with the failure reason. An error of
+	// the maximum length fits even with the largest number of attempts.
+	detail := &PaymentFailureDetail{
+		Error:       strings.Repeat("x", MaxPaymentFailureDetailErrorLen),
+		NumAttempts: math.MaxUint32,
+	}
+	failed, err := pControl.FailWithDetail(
+		hash, FailureReasonNoRoute, detail,
+	)
+	require.NoError(t, err)
+	require.Equal(t, detail, failed.FailureDetail)
+
+	payment, err = pControl.FetchPayment(hash)
+	require.NoError(t, err)
+	require.Equal(t, FailureReasonNoRoute, *payment.FailureReason)
+	require.Equal(t, detail, payment.FailureDetail)
+
+	// Failing the payment again keeps the first detail.
+	_, err = pControl.FailWithDetail(
+		hash, FailureReasonNoRoute, &PaymentFailureDetail{},
+	)
+	require.NoError(t, err)
+
+	payment, err = pControl.FetchPayment(hash)
+	require.NoError(t, err)
+	require.Equal(t, detail, payment.FailureDetail)
+
+	// Once the payment is re-initialized, the detail is gone.
+	require.NoError(t, pControl.InitPayment(hash, info))
+
+	payment, err = pControl.FetchPayment(hash)
+	require.NoError(t, err)
+	require.Nil(t, payment.FailureDetail)
+}
+
+// TestPaymentControlMaxInFlightAttempts checks that no new attempts can be
+// registered once a payment has reached the configured maximum number of
+// in-flight attempts, and that failed attempts don't count towards it.
+func TestPaymentControlMaxInFlightAttempts(t *testing.T) {
+	t.Parallel()
+
+	const maxInFlight = 2
+
+	db, err := MakeTestDB(t, OptionMaxInFlightAttempts(maxInFlight))
+	require.NoError(t, err, "unable to init db")
+
+	pControl := NewPaymentControl(db)
+
+	info, attempt, _, err := genInfo()
+	require.NoError(t, err, "unable to generate htlc message")
+
+	err = pControl.InitPayment(info.PaymentIdentifier, info)
+	require.NoError(t, err, "unable to send htlc message")
+
+	// Split the payment into four MPP shards, so the amount alone would
+	// allow us to register more attempts than the cap.
+	shardAmt := info.Value / 4
+	attempt.Route.FinalHop().AmtToForward = shardAmt
+	attempt.Route.FinalHop().MPP = record.NewMPP(
+		info.Value, [32]byte{1},
+	)
+
+	newAttempt := func(id uint64) *HTLCAttemptInfo {
+		a := *attempt
+		a.AttemptID = id
+
+		return &a
+	}
+
+	// Register attempts up to the cap.
+	for i := uint64(0); i < maxInFlight; i++ {
+		_, err = pControl.RegisterAttempt(
+			info.PaymentIdentifier, newAttempt(i),
+		)
+		require.NoError(t, err, "unable to register attempt")
+	}
+
+	// The next attempt should be rejected.
+	_, err = pControl.RegisterAttempt(
+		info.PaymentIdentifier, newAttempt(maxInFlight),
+	)
+	require.ErrorIs(t, err, ErrMaxInFlightAttempts)
+
+	// Once one of the in-flight attempts fails, there's room for a new
+	// one again.
+	_, _, err = pControl.FailAttempt(
+		info.PaymentIdentifier, 0, &HTLCFailInfo{
+			Reason: HTLCFailUnreadable,
+		},
+	)
+	require.NoError(t, err, "unable to fail htlc")
+
+	_, err = pControl.RegisterAttempt(
+		info.PaymentIdentifier, newAttempt(maxInFlight),
+	)
+	require.NoError(t, err, "unable to register attempt")
+
+	// We're at the cap again, so another attempt is rejected.
+	_, err = pControl.RegisterAttempt(
+		info.PaymentIdentifier, newAttempt(maxInFlight+1),
+	)
+	require.ErrorIs(t, err, ErrMaxInFlightAttempts)
+
+	payment, err := pControl.FetchPayment(info.PaymentIdentifier)
+	require.NoError(t, err)
+	require.Len(t, payment.HTLCs, maxInFlight+1)
+	require.Len(t, payment.InFlightHTLCs(), maxInFlight)
+}
 
-	// Delete the first payment completely.
-	require.NoError(t, db.DeletePayment(payments[0].id, false))
+// TestPaymentControlNextAttemptID checks that attempt IDs are unique and
+// increasing, also across restarts, and that LatestAttemptID is an upper bound
+// on the IDs that were issued.
+func TestPaymentControlNextAttemptID(t *testing.T) {
+	t.Parallel()
 
-	// The first payment should have been deleted.
-	assertPayments(t, db, payments[1:])
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
 
-	// Now delete the second payment completely.
-	require.NoError(t, db.DeletePayment(payments[1].id, false))
+	ctx := context.Background()
+	pControl := NewPaymentControl(db)
 
-	// The Second payment should have been deleted.
-	assertPayments(t, db, payments[2:])
+	// No ID has been reserved yet.
+	latest, err := pControl.LatestAttemptID(ctx)
+	require.NoError(t, err)
+	require.Zero(t, latest)
+
+	// Issue more IDs than fit in a single block.
+	issued := make(map[uint64]struct{})
+	var last uint64
+	for i := 0; i < attemptIDBlockSize+10; i++ {
+		id, err := pControl.NextAttemptID(ctx)
+		require.NoError(t, err)
+		require.Greater(t, id, last)
+
+		issued[id] = struct{}{}
+		last = id
+	}
 
-	// Delete failed HTLC attempts for the third payment.
-	require.NoError(t, db.DeletePayment(payments[2].id, true))
+	latest, err = pControl.LatestAttemptID(ctx)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, latest, last)
+
+	// Simulate a restart by creating a new payment control on the same
+	// database. The IDs it issues must not have been issued before.
+	pControl = NewPaymentControl(db)
+	for i := 0; i < 10; i++ {
+		id, err := pControl.NextAttemptID(ctx)
+		require.NoError(t, err)
+		require.Greater(t, id, latest)
+		require.NotContains(t, issued, id)
+
+		issued[id] = struct{}{}
+	}
 
-	// Only the successful HTLC attempt should be left for the third payment.
-	payments[2].htlcs = 1
-	assertPayments(t, db, payments[2:])
+	// A canceled context is respected.
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
 
-	// Now delete the third payment completely.
-	require.NoError(t, db.DeletePayment(payments[2].id, false))
+	_, err = pControl.NextAttemptID(ctx)
+	require.ErrorIs(t, err, context.Canceled)
 
-	// Only the last payment should be left.
-	assertPayments(t, db, payments[3:])
+	_, err = pControl.LatestAttemptID(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+}
 
-	// Deleting HTLC attempts from InFlight payments should not work and an
-	// error returned.
-	require.Error(t, db.DeletePayment(payments[3].id, true))
+// TestPaymentControlCloseReleasesSequence checks that closing the payment
+// control releases the unused payment sequence numbers, so the next payment
+// after a clean restart continues without a gap, while a crash skips to the
+// end of the reserved block.
+func TestPaymentControlCloseReleasesSequence(t *testing.T) {
+	t.Parallel()
 
-	// The payment is InFlight and therefore should not have been altered.
-	assertPayments(t, db, payments[3:])
+	// initPayment initiates a new payment and returns its sequence
+	// number.
+	initPayment := func(t *testing.T, p *PaymentControl) uint64 {
+		t.Helper()
 
-	// Finally deleting the InFlight payment should also not work and an
-	// error returned.
-	require.Error(t, db.DeletePayment(payments[3].id, false))
+		info, _, _, err := genInfo()
+		require.NoError(t, err)
 
-	// The payment is InFlight and therefore should not have been altered.
-	assertPayments(t, db, payments[3:])
-}
+		err = p.InitPayment(info.PaymentIdentifier, info)
+		require.NoError(t, err)
 
-// TestPaymentControlMultiShard checks the ability of payment control to
-// have multiple in-flight HTLCs for a single payment.
-func TestPaymentControlMultiShard(t *testing.T) {
-	t.Parallel()
+		payment, err := p.FetchPayment(info.PaymentIdentifier)
+		require.NoError(t, err)
 
-	// We will register three HTLC attempts, and always fail the second
-	// one. We'll generate all combinations of settling/failing the first
-	// and third HTLC, and assert that the payment status end up as we
-	// expect.
-	type testCase struct {
-		settleFirst bool
-		settleLast  bool
+		return payment.SequenceNum
 	}
 
-	var tests []testCase
-	for _, f := range []bool{true, false} {
-		for _, l := range []bool{true, false} {
-			tests = append(tests, testCase{f, l})
-		}
-	}
+	t.Run("clean shutdown", func(t *testing.T) {
+		t.Parallel()
 
-	runSubTest := func(t *testing.T, test testCase) {
 		db, err := MakeTestDB(t)
-		if err != nil {
-			t.Fatalf("unable to init db: %v", err)
-		}
+		require.NoError(t, err)
 
+		// Closing before any payment was sent is a no-op.
 		pControl := NewPaymentControl(db)
+		require.NoError(t, pControl.Close())
 
-		info, attempt, preimg, err := genInfo()
-		if err != nil {
-			t.Fatalf("unable to generate htlc message: %v", err)
+		for i := uint64(1); i <= 3; i++ {
+			require.Equal(t, i, initPayment(t, pControl))
 		}
+		require.NoError(t, pControl.Close())
 
-		// Init the payment, moving it to the StatusInFlight state.
-		err = pControl.InitPayment(info.PaymentIdentifier, info)
-		if err != nil {
-			t.Fatalf("unable to send htlc message: %v", err)
+		// The payment control can still be used after it was closed.
+		require.EqualValues(t, 4, initPayment(t, pControl))
+		require.NoError(t, pControl.Close())
+
+		// After a restart, the sequence continues without a gap.
+		pControl = NewPaymentControl(db)
+		require.EqualValues(t, 5, initPayment(t, pControl))
+	})
+
+	t.Run("crash", func(t *testing.T) {
+		t.Parallel()
+
+		db, err := MakeTestDB(t)
+		require.NoError(t, err)
+
+		pControl := NewPaymentControl(db)
+		for i := uint64(1); i <= 3; i++ {
+			require.Equal(t, i, initPayment(t, pControl))
 		}
 
-		assertPaymentIndex(t, pControl, info.PaymentIdentifier)
-		assertPaymentStatus(
-			t, pControl, info.PaymentIdentifier, StatusInitiated,
+		// Without closing, the rest of the block is skipped.
+		pControl = NewPaymentControl(db)
+		require.EqualValues(
+			t, paymentSeqBlockSize+1, initPayment(t, pControl),
 		)
-		assertPaymentInfo(
-			t, pControl, info.PaymentIdentifier, info, nil, nil,
+	})
+
+	t.Run("later reservation", func(t *testing.T) {
+		t.Parallel()
+
+		db, err := MakeTestDB(t)
+		require.NoError(t, err)
+
+		// A second instance reserves a block after the first one, so
+		// the first one can't release its block on close.
+		first := NewPaymentControl(db)
+		require.EqualValues(t, 1, initPayment(t, first))
+
+		second := NewPaymentControl(db)
+		require.EqualValues(
+			t, paymentSeqBlockSize+1, initPayment(t, second),
 		)
 
-		// Create three unique attempts we'll use for the test, and
-		// register them with the payment control. We set each
-		// attempts's value to one third of the payment amount, and
-		// populate the MPP options.
-		shardAmt := info.Value / 3
-		attempt.Route.FinalHop().AmtToForward = shardAmt
-		attempt.Route.FinalHop().MPP = record.NewMPP(
-			info.Value, [32]byte{1},
+		require.NoError(t, first.Close())
+		require.NoError(t, second.Close())
+
+		pControl := NewPaymentControl(db)
+		require.EqualValues(
+			t, paymentSeqBlockSize+2, initPayment(t, pControl),
 		)
+	})
+}
 
-		var attempts []*HTLCAttemptInfo
-		for i := uint64(0); i < 3; i++ {
-			a := *attempt
-			a.AttemptID = i
-			attempts = append(attempts, &a)
+// TestPaymentControlAMPSubPaymentStatuses checks that the statuses of the AMP
+// sub-payments of a payment are decided per set ID from the persisted routes of
+// its attempts, and that attempts without an AMP record are excluded.
+func TestPaymentControlAMPSubPaymentStatuses(t *testing.T) {
+	t.Parallel()
 
-			_, err = pControl.RegisterAttempt(info.PaymentIdentifier, &a)
-			if err != nil {
-				t.Fatalf("unable to send htlc message: %v", err)
-			}
-			assertPaymentStatus(
-				t, pControl, info.PaymentIdentifier,
-				StatusInFlight,
-			)
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
 
-			htlc := &htlcStatus{
-				HTLCAttemptInfo: &a,
-			}
-			assertPaymentInfo(
-				t, pControl, info.PaymentIdentifier, info, nil, htlc,
-			)
-		}
+	pControl := NewPaymentControl(db)
 
-		// For a fourth attempt, check that attempting to
-		// register it will fail since the total sent amount
-		// will be too large.
-		b := *attempt
-		b.AttemptID = 3
-		_, err = pControl.RegisterAttempt(info.PaymentIdentifier, &b)
-		if err != ErrValueExceedsAmt {
-			t.Fatalf("expected ErrValueExceedsAmt, got: %v",
-				err)
-		}
+	info, attempt, preimg, err := genInfo()
+	require.NoError(t, err)
 
-		// Fail the second attempt.
-		a := attempts[1]
-		htlcFail := HTLCFailUnreadable
-		_, err = pControl.FailAttempt(
-			info.PaymentIdentifier, a.AttemptID,
-			&HTLCFailInfo{
-				Reason: htlcFail,
-			},
-		)
-		if err != nil {
-			t.Fatal(err)
-		}
+	err = pControl.InitPayment(info.PaymentIdentifier, info)
+	require.NoError(t, err)
 
-		htlc := &htlcStatus{
-			HTLCAttemptInfo: a,
-			failure:         &htlcFail,
+	var (
+		setA = [32]byte{1}
+		setB = [32]byte{2}
+	)
+
+	// Register two attempts for each of the two sets, and a final attempt
+	// without an AMP record.
+	const numAttempts = 5
+	shardAmt := info.Value / numAttempts
+	setIDs := []*[32]byte{&setA, &setA, &setB, &setB, nil}
+	for i, setID := range setIDs {
+		a := *attempt
+		a.AttemptID = uint64(i)
+		a.Route = *attempt.Route.Copy()
+
+		finalHop := a.Route.FinalHop()
+		finalHop.AmtToForward = shardAmt
+		finalHop.MPP = record.NewMPP(info.Value, [32]byte{1})
+		if setID != nil {
+			finalHop.AMP = record.NewAMP(
+				[32]byte{3}, *setID, uint32(i),
+			)
 		}
-		assertPaymentInfo(
-			t, pControl, info.PaymentIdentifier, info, nil, htlc,
-		)
 
-		// Payment should still be in-flight.
-		assertPaymentStatus(t, pControl, info.PaymentIdentifier, StatusInFlight)
+		_, err = pControl.RegisterAttempt(info.PaymentIdentifier, &a)
+		require.NoError(t, err)
+	}
 
-		// Depending on the test case, settle or fail the first attempt.
-		a = attempts[0]
-		htlc = &htlcStatus{
-			HTLCAttemptInfo: a,
-		}
+	settle := func(id uint64) {
+		_, _, err := pControl.SettleAttempt(
+			info.PaymentIdentifier, id,
+			&HTLCSettleInfo{Preimage: preimg},
+		)
+		require.NoError(t, err)
+	}
+	fail := func(id uint64) {
+		_, _, err := pControl.FailAttempt(
+			info.PaymentIdentifier, id,
+			&HTLCFailInfo{Reason: HTLCFailUnreadable},
+		)
+		require.NoError(t, err)
+	}
+	assertStatuses := func(expected map[[32]byte]PaymentStatus) {
+		t.Helper()
 
-		var firstFailReason *FailureReason
-		if test.settleFirst {
-			_, err := pControl.SettleAttempt(
-				info.PaymentIdentifier, a.AttemptID,
-				&HTLCSettleInfo{
-					Preimage: preimg,
-				},
-			)
-			if err != nil {
-				t.Fatalf("error shouldn't have been "+
-					"received, got: %v", err)
-			}
+		payment, err := pControl.FetchPayment(info.PaymentIdentifier)
+		require.NoError(t, err)
+		require.Equal(t, expected, payment.AMPSubPaymentStatuses())
+	}
 
-			// Assert that the HTLC has had the preimage recorded.
-			htlc.settle = &preimg
-			assertPaymentInfo(
-				t, pControl, info.PaymentIdentifier, info, nil, htlc,
-			)
-		} else {
-			_, err := pControl.FailAttempt(
-				info.PaymentIdentifier, a.AttemptID,
-				&HTLCFailInfo{
-					Reason: htlcFail,
-				},
-			)
-			if err != nil {
-				t.Fatalf("error shouldn't have been "+
-					"received, got: %v", err)
-			}
+	// Initially both sets are in flight.
+	assertStatuses(map[[32]byte]PaymentStatus{
+		setA: StatusInFlight,
+		setB: StatusInFlight,
+	})
 
-			// Assert the failure was recorded.
-			htlc.failure = &htlcFail
-			assertPaymentInfo(
-				t, pControl, info.PaymentIdentifier, info, nil, htlc,
-			)
+	// Set A succeeds with one failed attempt, while set B is still in
+	// flight with one failed attempt.
+	fail(1)
+	settle(0)
+	fail(3)
+	assertStatuses(map[[32]byte]PaymentStatus{
+		setA: StatusSucceeded,
+		setB: StatusInFlight,
+	})
 
-			// We also record a payment level fail, to move it into
-			// a terminal state.
-			failReason := FailureReasonNoRoute
-			_, err = pControl.Fail(info.PaymentIdentifier, failReason)
-			if err != nil {
-				t.Fatalf("unable to fail payment hash: %v", err)
-			}
+	// With all of its attempts failed, set B could still be retried.
+	fail(2)
+	assertStatuses(map[[32]byte]PaymentStatus{
+		setA: StatusSucceeded,
+		setB: StatusInFlight,
+	})
 
-			// Record the reason we failed the payment, such that
-			// we can assert this later in the test.
-			firstFailReason = &failReason
+	// Once the payment failed, set B is failed as well.
+	fail(4)
+	_, err = pControl.Fail(info.PaymentIdentifier, FailureReasonNoRoute)
+	require.NoError(t, err)
 
-			// The payment is now considered pending fail, since
-			// there is still an active HTLC.
-			assertPaymentStatus(
-				t, pControl, info.PaymentIdentifier,
-				StatusInFlight,
-			)
-		}
+	assertStatuses(map[[32]byte]PaymentStatus{
+		setA: StatusSucceeded,
+		setB: StatusFailed,
+	})
+}
 
-		// Try to register yet another attempt. This should fail now
-		// that the payment has reached a terminal condition.
-		b = *attempt
-		b.AttemptID = 3
-		_, err = pControl.RegisterAttempt(info.PaymentIdentifier, &b)
-		if test.settleFirst {
-			require.ErrorIs(t, err, ErrPaymentPendingSettled)
-		} else {
-			require.ErrorIs(t, err, ErrPaymentPendingFailed)
-		}
+// TestPaymentControlFetchStalePayments checks that only in-flight payments
+// without a recent attempt are returned as stale, ordered by the time of their
+// newest attempt.
+func TestPaymentControlFetchStalePayments(t *testing.T) {
+	t.Parallel()
 
-		assertPaymentStatus(t, pControl, info.PaymentIdentifier, StatusInFlight)
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
 
-		// Settle or fail the remaining attempt based on the testcase.
-		a = attempts[2]
-		htlc = &htlcStatus{
-			HTLCAttemptInfo: a,
-		}
-		if test.settleLast {
-			// Settle the last outstanding attempt.
-			_, err = pControl.SettleAttempt(
-				info.PaymentIdentifier, a.AttemptID,
-				&HTLCSettleInfo{
-					Preimage: preimg,
-				},
-			)
-			require.NoError(t, err, "unable to settle")
+	pControl := NewPaymentControl(db)
 
-			htlc.settle = &preimg
-			assertPaymentInfo(
-				t, pControl, info.PaymentIdentifier,
-				info, firstFailReason, htlc,
-			)
-		} else {
-			// Fail the attempt.
-			_, err := pControl.FailAttempt(
-				info.PaymentIdentifier, a.AttemptID,
-				&HTLCFailInfo{
-					Reason: htlcFail,
-				},
-			)
-			if err != nil {
-				t.Fatalf("error shouldn't have been "+
-					"received, got: %v", err)
-			}
+	now := time.Unix(1_700_000_000, 0)
+	ago := func(d time.Duration) time.Time {
+		return now.Add(-d)
+	}
 
-			// Assert the failure was recorded.
-			htlc.failure = &htlcFail
-			assertPaymentInfo(
-				t, pControl, info.PaymentIdentifier, info,
-				firstFailReason, htlc,
-			)
+	// initPayment initiates a payment created at the given time and
+	// registers an attempt for each of the attempt times, each sending a
+	// third of the payment amount.
+	var attemptID uint64
+	initPayment := func(created time.Time,
+		attemptTimes ...time.Time) lntypes.Hash {
 
-			// Check that we can override any perevious terminal
-			// failure. This is to allow multiple concurrent shard
-			// write a terminal failure to the database without
-			// syncing.
-			failReason := FailureReasonPaymentDetails
-			_, err = pControl.Fail(info.PaymentIdentifier, failReason)
-			require.NoError(t, err, "unable to fail")
+		info, attempt, _, err := genInfo()
+		require.NoError(t, err)
+
+		info.CreationTime = created
+		err = pControl.InitPayment(info.PaymentIdentifier, info)
+		require.NoError(t, err)
+
+		for _, attemptTime := range attemptTimes {
+			a := *attempt
+			a.AttemptID = attemptID
+			a.AttemptTime = attemptTime
+			a.Route = *attempt.Route.Copy()
+			a.Route.FinalHop().AmtToForward = info.Value / 3
+			attemptID++
+
+			_, err = pControl.RegisterAttempt(
+				info.PaymentIdentifier, &a,
+			)
+			require.NoError(t, err)
 		}
 
-		var (
-			finalStatus PaymentStatus
-			registerErr error
-		)
+		return info.PaymentIdentifier
+	}
 
-		switch {
-		// If one of the attempts settled but the other failed with
-		// terminal error, we would still consider the payment is
-		// settled.
-		case test.settleFirst && !test.settleLast:
-			finalStatus = StatusSucceeded
-			registerErr = ErrPaymentAlreadySucceeded
+	cutoff := ago(2 * time.Hour)
 
-		case !test.settleFirst && test.settleLast:
-			finalStatus = StatusSucceeded
-			registerErr = ErrPaymentAlreadySucceeded
+	// A payment with an attempt before the cutoff is stale.
+	stale := initPayment(ago(4*time.Hour), ago(3*time.Hour))
 
-		// If both failed, we end up in a failed status.
-		case !test.settleFirst && !test.settleLast:
-			finalStatus = StatusFailed
-			registerErr = ErrPaymentAlreadyFailed
+	// A payment is only stale if its newest attempt is before the cutoff,
+	// so a recent retry keeps it from being stale.
+	initPayment(ago(6*time.Hour), ago(5*time.Hour), ago(time.Hour))
 
-		// Otherwise, the payment has a succeed status.
-		case test.settleFirst && test.settleLast:
-			finalStatus = StatusSucceeded
-			registerErr = ErrPaymentAlreadySucceeded
-		}
+	// The oldest attempt of a payment doesn't matter.
+	staler := initPayment(
+		ago(6*time.Hour), ago(5*time.Hour), ago(4*time.Hour),
+	)
 
-		assertPaymentStatus(
-			t, pControl, info.PaymentIdentifier, finalStatus,
-		)
+	// Without attempts, the creation time of the payment is used.
+	stalest := initPayment(ago(6 * time.Hour))
+	initPayment(ago(time.Hour))
 
-		// Finally assert we cannot register more attempts.
-		_, err = pControl.RegisterAttempt(info.PaymentIdentifier, &b)
-		require.Equal(t, registerErr, err)
-	}
+	// Terminated payments are never stale.
+	settled := initPayment(ago(10*time.Hour), ago(10*time.Hour))
+	_, _, err = pControl.SettleAttempt(
+		settled, attemptID-1, &HTLCSettleInfo{},
+	)
+	require.NoError(t, err)
 
-	for _, test := range tests {
-		test := test
-		subTest := fmt.Sprintf("first=%v, second=%v",
-			test.settleFirst, test.settleLast)
+	payments, err := pControl.FetchStalePayments(
+		context.Background(), cutoff,
+	)
+	require.NoError(t, err)
 
-		t.Run(subTest, func(t *testing.T) {
-			runSubTest(t, test)
-		})
+	var hashes []lntypes.Hash
+	for _, payment := range payments {
+		hashes = append(hashes, payment.Info.PaymentIdentifier)
 	}
+	require.Equal(t, []lntypes.Hash{stalest, staler, stale}, hashes)
+
+	// With a cutoff before all attempts, no payment is stale.
+	payments, err = pControl.FetchStalePayments(
+		context.Background(), ago(24*time.Hour),
+	)
+	require.NoError(t, err)
+	require.Empty(t, payments)
 }
 
-func TestPaymentControlMPPRecordValidation(t *testing.T) {
+// TestPaymentControlFetchPaymentStatus checks that the status returned by
+// FetchPaymentStatus matches the status of the fully fetched payment as it
+// moves through all of its states.
+func TestPaymentControlFetchPaymentStatus(t *testing.T) {
 	t.Parallel()
 
 	db, err := MakeTestDB(t)
-	require.NoError(t, err, "unable to init db")
+	require.NoError(t, err)
 
+	ctx := context.Background()
 	pControl := NewPaymentControl(db)
 
-	info, attempt, _, err := genInfo()
-	require.NoError(t, err, "unable to generate htlc message")
+	info, attempt, preimg, err := genInfo()
+	require.NoError(t, err)
 
-	// Init the payment.
-	err = pControl.InitPayment(info.PaymentIdentifier, info)
-	require.NoError(t, err, "unable to send htlc message")
+	hash := info.PaymentIdentifier
 
-	// Create three unique attempts we'll use for the test, and
-	// register them with the payment control. We set each
-	// attempts's value to one third of the payment amount, and
-	// populate the MPP options.
-	shardAmt := info.Value / 3
-	attempt.Route.FinalHop().AmtToForward = shardAmt
-	attempt.Route.FinalHop().MPP = record.NewMPP(
-		info.Value, [32]byte{1},
-	)
+	// assertStatus checks that both ways of fetching the payment agree on
+	// the expected status.
+	assertStatus := func(expected PaymentStatus) {
+		t.Helper()
 
-	_, err = pControl.RegisterAttempt(info.PaymentIdentifier, attempt)
-	require.NoError(t, err, "unable to send htlc message")
+		status, err := pControl.FetchPaymentStatus(ctx, hash)
+		require.NoError(t, err)
+		require.Equal(t, expected, status)
 
-	// Now try to register a non-MPP attempt, which should fail.
-	b := *attempt
-	b.AttemptID = 1
-	b.Route.FinalHop().MPP = nil
-	_, err = pControl.RegisterAttempt(info.PaymentIdentifier, &b)
-	if err != ErrMPPayment {
-		t.Fatalf("expected ErrMPPayment, got: %v", err)
+		payment, err := pControl.FetchPayment(hash)
+		require.NoError(t, err)
+		require.Equal(t, payment.Status, status)
 	}
 
-	// Try to register attempt one with a different payment address.
-	b.Route.FinalHop().MPP = record.NewMPP(
-		info.Value, [32]byte{2},
-	)
-	_, err = pControl.RegisterAttempt(info.PaymentIdentifier, &b)
-	if err != ErrMPPPaymentAddrMismatch {
-		t.Fatalf("expected ErrMPPPaymentAddrMismatch, got: %v", err)
-	}
+	// An unknown payment isn't found.
+	_, err = pControl.FetchPaymentStatus(ctx, hash)
+	require.ErrorIs(t, err, ErrPaymentNotInitiated)
 
-	// Try registering one with a different total amount.
-	b.Route.FinalHop().MPP = record.NewMPP(
-		info.Value/2, [32]byte{1},
-	)
-	_, err = pControl.RegisterAttempt(info.PaymentIdentifier, &b)
-	if err != ErrMPPTotalAmountMismatch {
-		t.Fatalf("expected ErrMPPTotalAmountMismatch, got: %v", err)
-	}
+	require.NoError(t, pControl.InitPayment(hash, info))
+	assertStatus(StatusInitiated)
 
-	// Create and init a new payment. This time we'll check that we cannot
-	// register an MPP attempt if we already registered a non-MPP one.
-	info, attempt, _, err = genInfo()
-	require.NoError(t, err, "unable to generate htlc message")
+	// Register an attempt and fail the payment while the attempt is still
+	// in flight, which leaves the payment in flight until the attempt is
+	// resolved.
+	_, err = pControl.RegisterAttempt(hash, attempt)
+	require.NoError(t, err)
+	assertStatus(StatusInFlight)
 
-	err = pControl.InitPayment(info.PaymentIdentifier, info)
-	require.NoError(t, err, "unable to send htlc message")
+	_, err = pControl.Fail(hash, FailureReasonNoRoute)
+	require.NoError(t, err)
+	assertStatus(StatusInFlight)
 
-	attempt.Route.FinalHop().MPP = nil
-	_, err = pControl.RegisterAttempt(info.PaymentIdentifier, attempt)
-	require.NoError(t, err, "unable to send htlc message")
+	_, _, err = pControl.FailAttempt(
+		hash, attempt.AttemptID, &HTLCFailInfo{
+			Reason: HTLCFailUnreadable,
+		},
+	)
+	require.NoError(t, err)
+	assertStatus(StatusFailed)
+
+	// Retry the payment with two shards and settle them one by one. With
+	// one shard settled and the other one in flight, the payment is still
+	// in flight.
+	require.NoError(t, pControl.InitPayment(hash, info))
+	assertStatus(StatusInitiated)
+
+	attempt.Route.FinalHop().AmtToForward = info.Value / 2
+	shardIDs := []uint64{attempt.AttemptID + 1, attempt.AttemptID + 2}
+	for _, id := range shardIDs {
+		attempt.AttemptID = id
+		_, err = pControl.RegisterAttempt(hash, attempt)
+		require.NoError(t, err)
+	}
 
-	// Attempt to register an MPP attempt, which should fail.
-	b = *attempt
-	b.AttemptID = 1
-	b.Route.FinalHop().MPP = record.NewMPP(
-		info.Value, [32]byte{1},
+	_, _, err = pControl.SettleAttempt(
+		hash, shardIDs[0], &HTLCSettleInfo{Preimage: preimg},
 	)
+	require.NoError(t, err)
+	assertStatus(StatusInFlight)
 
-	_, err = pControl.RegisterAttempt(info.PaymentIdentifier, &b)
-	if err != ErrNonMPPayment {
-		t.Fatalf("expected ErrNonMPPayment, got: %v", err)
-	}
+	_, _, err = pControl.SettleAttempt(
+		hash, shardIDs[1], &HTLCSettleInfo{Preimage: preimg},
+	)
+	require.NoError(t, err)
+	assertStatus(StatusSucceeded)
+
+	// A canceled context is respected.
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	_, err = pControl.FetchPaymentStatus(ctx, hash)
+	require.ErrorIs(t, err, context.Canceled)
 }
 
 // TestDeleteFailedAttempts checks that DeleteFailedAttempts properly removes
@@ -1257,6 +4152,9 @@ type payment struct {
 	id     lntypes.Hash
 	status PaymentStatus
 	htlcs  int
+
+	// creationTime overwrites the creation time of the payment if set.
+	creationTime time.Time
 }
 
 // createTestPayments registers payments depending on the provided statuses in
@@ -1272,6 +4170,10 @@ func createTestPayments(t *testing.T, p *PaymentControl, payments []*payment) {
 		// Set the payment id accordingly in the payments slice.
 		payments[i].id = info.PaymentIdentifier
 
+		if !payments[i].creationTime.IsZero() {
+			info.CreationTime = payments[i].creationTime
+		}
+
 		attempt.AttemptID = attemptID
 		attemptID++
 
@@ -1284,7 +4186,7 @@ func createTestPayments(t *testing.T, p *PaymentControl, payments []*payment) {
 		require.NoError(t, err, "unable to send htlc message")
 
 		htlcFailure := HTLCFailUnreadable
-		_, err = p.FailAttempt(
+		_, _, err = p.FailAttempt(
 			info.PaymentIdentifier, attempt.AttemptID,
 			&HTLCFailInfo{
 				Reason: htlcFailure,
@@ -1308,7 +4210,7 @@ func createTestPayments(t *testing.T, p *PaymentControl, payments []*payment) {
 		// Fail the attempt and the payment overall.
 		case StatusFailed:
 			htlcFailure := HTLCFailUnreadable
-			_, err = p.FailAttempt(
+			_, _, err = p.FailAttempt(
 				info.PaymentIdentifier, attempt.AttemptID,
 				&HTLCFailInfo{
 					Reason: htlcFailure,
@@ -1323,7 +4225,7 @@ func createTestPayments(t *testing.T, p *PaymentControl, payments []*payment) {
 
 		// Settle the attempt
 		case StatusSucceeded:
-			_, err := p.SettleAttempt(
+			_, _, err := p.SettleAttempt(
 				info.PaymentIdentifier, attempt.AttemptID,
 				&HTLCSettleInfo{
 					Preimage: preimg,
@@ -1366,6 +4268,298 @@ func assertPayments(t *testing.T, db *DB, payments []*payment) {
 		}
 	}
 
+	// The creation time is only an input for creating the payments, so we
+	// don't compare it.
+	expected := make([]*payment, len(payments))
+	for i, pmt := range payments {
+		expected[i] = &payment{
+			id:     pmt.id,
+			status: pmt.status,
+			htlcs:  pmt.htlcs,
+		}
+	}
+
 	// Check that each payment we want to assert exists in the database.
-	require.Equal(t, payments, p)
+	require.Equal(t, expected, p)
+}
+
+// TestPaymentControlFetchAttemptRoute checks that the routes of htlc attempts
+// are stored and can be fetched if enabled, that they are removed together
+// with their failed attempts, and that a clear error is returned if they
+// aren't stored.
+func TestPaymentControlFetchAttemptRoute(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("enabled", func(t *testing.T) {
+		t.Parallel()
+
+		db, err := MakeTestDB(t, OptionStoreAttemptRoutes(true))
+		require.NoError(t, err)
+
+		pControl := NewPaymentControl(db)
+
+		info, attempt, preimg, err := genInfo()
+		require.NoError(t, err)
+
+		hash := info.PaymentIdentifier
+		require.NoError(t, pControl.InitPayment(hash, info))
+
+		// Register a first attempt and fail it, then settle a
+		// second one.
+		_, err = pControl.RegisterAttempt(hash, attempt)
+		require.NoError(t, err)
+
+		routeBytes, err := db.FetchAttemptRoute(
+			ctx, hash, attempt.AttemptID,
+		)
+		require.NoError(t, err)
+
+		rt, err := DeserializeRoute(bytes.NewReader(routeBytes))
+		require.NoError(t, err)
+		require.Equal(t, attempt.Route, rt)
+
+		_, _, err = pControl.FailAttempt(
+			hash, attempt.AttemptID, &HTLCFailInfo{
+				Reason: HTLCFailUnreadable,
+			},
+		)
+		require.NoError(t, err)
+
+		failedID := attempt.AttemptID
+		attempt.AttemptID++
+		_, err = pControl.RegisterAttempt(hash, attempt)
+		require.NoError(t, err)
+
+		_, _, err = pControl.SettleAttempt(
+			hash, attempt.AttemptID, &HTLCSettleInfo{
+				Preimage: preimg,
+			},
+		)
+		require.NoError(t, err)
+
+		// Deleting the failed attempts removes their routes only.
+		require.NoError(t, pControl.DeleteFailedAttempts(hash))
+
+		_, err = db.FetchAttemptRoute(ctx, hash, failedID)
+		require.ErrorIs(t, err, ErrAttemptRouteNotStored)
+
+		_, err = db.FetchAttemptRoute(ctx, hash, attempt.AttemptID)
+		require.NoError(t, err)
+
+		// Unknown payments are reported as such.
+		_, err = db.FetchAttemptRoute(ctx, lntypes.Hash{1}, 0)
+		require.ErrorIs(t, err, ErrPaymentNotInitiated)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Parallel()
+
+		db, err := MakeTestDB(t)
+		require.NoError(t, err)
+
+		pControl := NewPaymentControl(db)
+
+		info, attempt, _, err := genInfo()
+		require.NoError(t, err)
+
+		hash := info.PaymentIdentifier
+		require.NoError(t, pControl.InitPayment(hash, info))
+
+		_, err = pControl.RegisterAttempt(hash, attempt)
+		require.NoError(t, err)
+
+		_, err = db.FetchAttemptRoute(ctx, hash, attempt.AttemptID)
+		require.ErrorIs(t, err, ErrAttemptRouteNotStored)
+	})
+}
+
+// TestPaymentControlRegisterAttemptDuplicateID checks that registering an
+// attempt with the ID of an existing attempt fails with a typed error and
+// leaves the existing attempt untouched.
+func TestPaymentControlRegisterAttemptDuplicateID(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+
+	info, attempt, _, err := genInfo()
+	require.NoError(t, err)
+
+	hash := info.PaymentIdentifier
+	require.NoError(t, pControl.InitPayment(hash, info))
+
+	// Send half of the amount, so the amount of a second shard is valid.
+	attempt.Route.FinalHop().AmtToForward = info.Value / 2
+	_, err = pControl.RegisterAttempt(hash, attempt)
+	require.NoError(t, err)
+
+	// A second shard reusing the attempt ID is rejected.
+	duplicate := *attempt
+	_, err = pControl.RegisterAttempt(hash, &duplicate)
+	require.ErrorIs(t, err, ErrAttemptAlreadyRegistered)
+
+	payment, err := pControl.FetchPayment(hash)
+	require.NoError(t, err)
+	require.Len(t, payment.HTLCs, 1)
+	require.Equal(t, info.Value/2, payment.HTLCs[0].Route.ReceiverAmt())
+}
+
+// TestPaymentControlReconcilePayment checks that reconciling a payment repairs
+// its index entries after they were corrupted, and that healthy payments are
+// left untouched.
+func TestPaymentControlReconcilePayment(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	db, err := MakeTestDB(t, OptionPaymentCacheSize(10))
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+
+	payments := []*payment{
+		{status: StatusInFlight},
+		{status: StatusSucceeded},
+	}
+	createTestPayments(t, pControl, payments)
+	inFlightHash, settledHash := payments[0].id, payments[1].id
+
+	// fetchIndexes returns the entries of the payments index and the
+	// in-flight index.
+	fetchIndexes := func() (map[uint64]lntypes.Hash, []lntypes.Hash) {
+		t.Helper()
+
+		seqIndex := make(map[uint64]lntypes.Hash)
+		err := kvdb.View(db, func(tx kvdb.RTx) error {
+			indexes := tx.ReadBucket(paymentsIndexBucket)
+
+			return indexes.ForEach(func(k, v []byte) error {
+				hash, err := deserializePaymentIndex(
+					bytes.NewReader(v),
+				)
+				seqIndex[byteOrder.Uint64(k)] = hash
+
+				return err
+			})
+		}, func() {
+			seqIndex = make(map[uint64]lntypes.Hash)
+		})
+		require.NoError(t, err)
+
+		return seqIndex, fetchInFlightIndex(t, db)
+	}
+
+	healthySeqIndex, healthyInFlight := fetchIndexes()
+	require.Len(t, healthySeqIndex, 2)
+	require.Equal(t, []lntypes.Hash{inFlightHash}, healthyInFlight)
+
+	// Reconciling the healthy payments changes nothing.
+	for _, hash := range []lntypes.Hash{inFlightHash, settledHash} {
+		payment, err := pControl.ReconcilePayment(ctx, hash)
+		require.NoError(t, err)
+		require.Equal(t, fetchPaymentFromDB(t, db, hash), payment)
+	}
+
+	seqIndex, inFlight := fetchIndexes()
+	require.Equal(t, healthySeqIndex, seqIndex)
+	require.Equal(t, healthyInFlight, inFlight)
+
+	// Corrupt the indexes: the in-flight payment is dropped from the
+	// in-flight index while the settled one is added, and the payments
+	// index entry of the settled payment is removed.
+	settled := fetchPaymentFromDB(t, db, settledHash)
+	err = kvdb.Update(db, func(tx kvdb.RwTx) error {
+		err := updateInFlightIndex(tx, inFlightHash, false)
+		if err != nil {
+			return err
+		}
+
+		err = updateInFlightIndex(tx, settledHash, true)
+		if err != nil {
+			return err
+		}
+
+		var seqNr [8]byte
+		byteOrder.PutUint64(seqNr[:], settled.SequenceNum)
+
+		return tx.ReadWriteBucket(paymentsIndexBucket).Delete(
+			seqNr[:],
+		)
+	}, func() {})
+	require.NoError(t, err)
+
+	// Reconciling the payments repairs the indexes.
+	payment, err := pControl.ReconcilePayment(ctx, settledHash)
+	require.NoError(t, err)
+	require.Equal(t, StatusSucceeded, payment.Status)
+
+	payment, err = pControl.ReconcilePayment(ctx, inFlightHash)
+	require.NoError(t, err)
+	require.Equal(t, StatusInFlight, payment.Status)
+
+	seqIndex, inFlight = fetchIndexes()
+	require.Equal(t, healthySeqIndex, seqIndex)
+	require.Equal(t, healthyInFlight, inFlight)
+
+	// Unknown payments can't be reconciled.
+	_, err = pControl.ReconcilePayment(ctx, lntypes.Hash{1})
+	require.ErrorIs(t, err, ErrPaymentNotInitiated)
+}
+
+// TestPaymentsPrefetchChunks checks that the operations that prefetch many
+// payments in chunks visit every payment when their number isn't a multiple
+// of the chunk size.
+func TestPaymentsPrefetchChunks(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	const numPayments = 2*paymentPrefetchChunkSize + 5
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+
+	// The payments are written directly, so the in-flight index is
+	// dropped to have it built from them.
+	populatePayments(t, db, numPayments, 1, false)
+	err = kvdb.Update(db, func(tx kvdb.RwTx) error {
+		return tx.DeleteTopLevelBucket(paymentsInFlightIndexBucket)
+	}, func() {})
+	require.NoError(t, err)
+
+	inFlight, err := pControl.FetchInFlightPayments(ctx)
+	require.NoError(t, err)
+	require.Len(t, inFlight, numPayments)
+
+	// Abandon the payments, so they can be deleted.
+	for _, p := range inFlight {
+		hash := p.Info.PaymentIdentifier
+		require.NoError(t, pControl.AbandonPayment(hash))
+	}
+
+	inFlight, err = pControl.FetchInFlightPayments(ctx)
+	require.NoError(t, err)
+	require.Empty(t, inFlight)
+
+	// Delete the older half of the payments by their creation time, and
+	// all the others at once.
+	cutoff := time.Unix(numPayments/2+1, 0)
+	numDeleted, err := db.DeletePaymentsBefore(
+		ctx, cutoff, false, numPayments,
+	)
+	require.NoError(t, err)
+	require.Equal(t, numPayments/2, numDeleted)
+
+	_, _, err = db.DeletePayments(false, false)
+	require.NoError(t, err)
+
+	payments, err := db.FetchPayments()
+	require.NoError(t, err)
+	require.Empty(t, payments)
 }
@@ -0,0 +1,108 @@
+package channeldb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/lightningnetwork/lnd/tlv"
+)
+
+const (
+	// MaxPaymentFailureDetailSize is the maximum size in bytes of the
+	// serialized failure detail of a payment.
+	MaxPaymentFailureDetailSize = 1024
+
+	// MaxPaymentFailureDetailErrorLen is the maximum length in bytes of the
+	// error message of a failure detail. It leaves room for the tlv
+	// overhead and the number of attempts within
+	// MaxPaymentFailureDetailSize.
+	MaxPaymentFailureDetailErrorLen = MaxPaymentFailureDetailSize - 16
+
+	// failureDetailErrorType is the tlv type of the error message of a
+	// payment's failure detail.
+	failureDetailErrorType tlv.Type = 1
+
+	// failureDetailNumAttemptsType is the tlv type of the number of
+	// attempts of a payment's failure detail.
+	failureDetailNumAttemptsType tlv.Type = 3
+)
+
+// ErrPaymentFailureDetailTooLarge is returned when a payment is failed with a
+// failure detail that exceeds MaxPaymentFailureDetailSize once serialized.
+var ErrPaymentFailureDetailTooLarge = errors.New("payment failure detail " +
+	"too large")
+
+// PaymentFailureDetail holds diagnostics about why a payment failed, beyond
+// its failure reason. It is stored along with the failure reason, so it's
+// still available after a restart.
+type PaymentFailureDetail struct {
+	// Error is the error that terminated the payment, such as the last
+	// error returned by pathfinding.
+	Error string
+
+	// NumAttempts is the number of htlc attempts that were made for the
+	// payment before it failed.
+	NumAttempts uint32
+}
+
+// serializePaymentFailureDetail serializes the failure detail as a tlv stream,
+// making sure it doesn't exceed MaxPaymentFailureDetailSize.
+func serializePaymentFailureDetail(d *PaymentFailureDetail) ([]byte, error) {
+	errBytes := []byte(d.Error)
+	numAttempts := d.NumAttempts
+
+	tlvStream, err := tlv.NewStream(
+		tlv.MakePrimitiveRecord(failureDetailErrorType, &errBytes),
+		tlv.MakePrimitiveRecord(
+			failureDetailNumAttemptsType, &numAttempts,
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	if err := tlvStream.Encode(&b); err != nil {
+		return nil, err
+	}
+
+	if b.Len() > MaxPaymentFailureDetailSize {
+		return nil, fmt.Errorf("%w: %d bytes exceed the maximum of %d "+
+			"bytes", ErrPaymentFailureDetailTooLarge, b.Len(),
+			MaxPaymentFailureDetailSize)
+	}
+
+	return b.Bytes(), nil
+}
+
+// deserializePaymentFailureDetail reads a failure detail serialized with
+// serializePaymentFailureDetail. Unknown records are ignored.
+func deserializePaymentFailureDetail(r io.Reader) (*PaymentFailureDetail,
+	error) {
+
+	var (
+		errBytes    []byte
+		numAttempts uint32
+	)
+
+	tlvStream, err := tlv.NewStream(
+		tlv.MakePrimitiveRecord(failureDetailErrorType, &errBytes),
+		tlv.MakePrimitiveRecord(
+			failureDetailNumAttemptsType, &numAttempts,
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tlvStream.Decode(r); err != nil {
+		return nil, err
+	}
+
+	return &PaymentFailureDetail{
+		Error:       string(errBytes),
+		NumAttempts: numAttempts,
+	}, nil
+}
@@ -0,0 +1,655 @@
+package channeldb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+// ErrShadowStopped is returned when waiting for the shadow store while the
+// shadow payment control is stopped.
+var ErrShadowStopped = errors.New("shadow payment control stopped")
+
+// DefaultShadowQueueSize is the default number of writes that can wait to be
+// replicated to the shadow store before further writes are dropped.
+const DefaultShadowQueueSize = 1000
+
+// ShadowStats holds the counters of a ShadowPaymentControl.
+type ShadowStats struct {
+	// Mirrored is the number of writes that were replicated to the shadow
+	// store.
+	Mirrored uint64
+
+	// Failed is the number of writes that failed on the shadow store.
+	Failed uint64
+
+	// Dropped is the number of writes that weren't replicated because
+	// the queue was full.
+	Dropped uint64
+
+	// Diverged is the number of payments that were found to differ
+	// between the two stores when comparing them.
+	Diverged uint64
+}
+
+// shadowWrite is a write that is replicated to the shadow store.
+type shadowWrite struct {
+	// name is the name of the write, used for logging.
+	name string
+
+	// hash is the hash of the payment that is written.
+	hash lntypes.Hash
+
+	// apply applies the write to the shadow store.
+	apply func(shadow *PaymentControl) error
+
+	// done, if set, is closed once the write was processed. It's only used
+	// to wait for all earlier writes.
+	done chan struct{}
+}
+
+// ShadowPaymentControl is a PaymentStore that replicates every successful
+// write to a primary PaymentControl to a second, shadow store. Reads are
+// always served by the primary store. The writes are replicated asynchronously
+// and in order through a bounded queue, so the shadow store never slows down
+// or fails a write to the primary store. Writes that fail on the shadow store
+// or don't fit into the queue are logged and counted instead.
+//
+// This allows a new payments store, for example a database on a different
+// backend, to be run alongside the existing one until it's trusted.
+type ShadowPaymentControl struct {
+	primary *PaymentControl
+	shadow  *PaymentControl
+
+	queue chan *shadowWrite
+
+	numMirrored atomic.Uint64
+	numFailed   atomic.Uint64
+	numDropped  atomic.Uint64
+	numDiverged atomic.Uint64
+
+	started sync.Once
+	stopped sync.Once
+
+	// quitMtx makes sure no write is queued once quit is closed, so that
+	// all queued writes are applied when stopping.
+	quitMtx sync.RWMutex
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewShadowPaymentControl creates a ShadowPaymentControl that serves the given
+// primary store and replicates its writes to the shadow store, queueing at
+// most queueSize writes.
+func NewShadowPaymentControl(primary, shadow *PaymentControl,
+	queueSize int) *ShadowPaymentControl {
+
+	return &ShadowPaymentControl{
+		primary: primary,
+		shadow:  shadow,
+		queue:   make(chan *shadowWrite, queueSize),
+		quit:    make(chan struct{}),
+	}
+}
+
+// Compile-time constraint to ensure that ShadowPaymentControl implements the
+// PaymentStore interface. It wraps the primary store instead of embedding it,
+// so every write of the interface has to be replicated explicitly.
+var _ PaymentStore = (*ShadowPaymentControl)(nil)
+
+// Start starts replicating the queued writes to the shadow store.
+func (s *ShadowPaymentControl) Start() error {
+	s.started.Do(func() {
+		log.Info("Starting shadow payment control")
+
+		s.wg.Add(1)
+		go s.mirrorWrites()
+	})
+
+	return nil
+}
+
+// Stop stops replicating writes to the shadow store. Writes that are still
+// queued are applied before it returns, writes that arrive afterwards are
+// dropped.
+func (s *ShadowPaymentControl) Stop() error {
+	s.stopped.Do(func() {
+		log.Info("Stopping shadow payment control")
+
+		s.quitMtx.Lock()
+		close(s.quit)
+		s.quitMtx.Unlock()
+
+		s.wg.Wait()
+
+		// No writes are queued anymore, so apply the remaining ones.
+		for len(s.queue) > 0 {
+			s.mirror(<-s.queue)
+		}
+
+		stats := s.Stats()
+		log.Infof("Shadow payment control stopped: mirrored=%d, "+
+			"failed=%d, dropped=%d, diverged=%d", stats.Mirrored,
+			stats.Failed, stats.Dropped, stats.Diverged)
+	})
+
+	return nil
+}
+
+// Stats returns the current counters of the shadow payment control.
+func (s *ShadowPaymentControl) Stats() ShadowStats {
+	return ShadowStats{
+		Mirrored: s.numMirrored.Load(),
+		Failed:   s.numFailed.Load(),
+		Dropped:  s.numDropped.Load(),
+		Diverged: s.numDiverged.Load(),
+	}
+}
+
+// mirrorWrites applies the queued writes to the shadow store in order.
+//
+// NOTE: This MUST be run as a goroutine.
+func (s *ShadowPaymentControl) mirrorWrites() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case w := <-s.queue:
+			s.mirror(w)
+
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// mirror applies a single queued write to the shadow store.
+func (s *ShadowPaymentControl) mirror(w *shadowWrite) {
+	if w.done != nil {
+		close(w.done)
+		return
+	}
+
+	if err := w.apply(s.shadow); err != nil {
+		log.Warnf("Unable to mirror %v of payment %v to shadow "+
+			"store: %v", w.name, w.hash, err)
+
+		s.numFailed.Add(1)
+
+		return
+	}
+
+	s.numMirrored.Add(1)
+}
+
+// enqueue queues the write for the shadow store, dropping it if the queue is
+// full or the shadow payment control is stopped.
+func (s *ShadowPaymentControl) enqueue(name string, hash lntypes.Hash,
+	apply func(shadow *PaymentControl) error) {
+
+	s.quitMtx.RLock()
+	defer s.quitMtx.RUnlock()
+
+	select {
+	case <-s.quit:
+		log.Warnf("Shadow payment control stopped, dropping %v of "+
+			"payment %v", name, hash)
+
+		s.numDropped.Add(1)
+
+		return
+
+	default:
+	}
+
+	select {
+	case s.queue <- &shadowWrite{name: name, hash: hash, apply: apply}:
+	default:
+		log.Warnf("Shadow queue full, dropping %v of payment %v", name,
+			hash)
+
+		s.numDropped.Add(1)
+	}
+}
+
+// flush waits until all writes that were queued before were processed.
+func (s *ShadowPaymentControl) flush() error {
+	done := make(chan struct{})
+	select {
+	case s.queue <- &shadowWrite{done: done}:
+	case <-s.quit:
+		return ErrShadowStopped
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-s.quit:
+		return ErrShadowStopped
+	}
+}
+
+// InitPayment initiates the payment in the primary store and replicates it
+// to the shadow store.
+func (s *ShadowPaymentControl) InitPayment(paymentHash lntypes.Hash,
+	info *PaymentCreationInfo) error {
+
+	err := s.primary.InitPayment(paymentHash, info)
+	if err != nil {
+		return err
+	}
+
+	s.enqueue("init", paymentHash, func(shadow *PaymentControl) error {
+		return shadow.InitPayment(paymentHash, info)
+	})
+
+	return nil
+}
+
+// RegisterAttempt registers the attempt in the primary store and replicates
+// it to the shadow store.
+func (s *ShadowPaymentControl) RegisterAttempt(paymentHash lntypes.Hash,
+	attempt *HTLCAttemptInfo) (*MPPayment, error) {
+
+	payment, err := s.primary.RegisterAttempt(paymentHash, attempt)
+	if err != nil {
+		return nil, err
+	}
+
+	s.enqueue("attempt", paymentHash, func(shadow *PaymentControl) error {
+		_, err := shadow.RegisterAttempt(paymentHash, attempt)
+		return err
+	})
+
+	return payment, nil
+}
+
+// SettleAttempt settles the attempt in the primary store and replicates the
+// settlement to the shadow store.
+func (s *ShadowPaymentControl) SettleAttempt(hash lntypes.Hash,
+	attemptID uint64, settleInfo *HTLCSettleInfo) (*MPPayment,
+	*HTLCAttempt, error) {
+
+	payment, attempt, err := s.primary.SettleAttempt(
+		hash, attemptID, settleInfo,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.enqueue("settle", hash, func(shadow *PaymentControl) error {
+		_, _, err := shadow.SettleAttempt(hash, attemptID, settleInfo)
+		return err
+	})
+
+	return payment, attempt, nil
+}
+
+// FailAttempt fails the attempt in the primary store and replicates the
+// failure to the shadow store.
+func (s *ShadowPaymentControl) FailAttempt(hash lntypes.Hash,
+	attemptID uint64, failInfo *HTLCFailInfo) (*MPPayment, *HTLCAttempt,
+	error) {
+
+	payment, attempt, err := s.primary.FailAttempt(
+		hash, attemptID, failInfo,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.enqueue("attempt failure", hash, func(shadow *PaymentControl) error {
+		_, _, err := shadow.FailAttempt(hash, attemptID, failInfo)
+		return err
+	})
+
+	return payment, attempt, nil
+}
+
+// Fail fails the payment in the primary store and replicates the failure to
+// the shadow store.
+func (s *ShadowPaymentControl) Fail(paymentHash lntypes.Hash,
+	reason FailureReason) (*MPPayment, error) {
+
+	return s.FailWithDetail(paymentHash, reason, nil)
+}
+
+// FailWithDetail fails the payment with the given failure detail in the
+// primary store and replicates the failure to the shadow store.
+func (s *ShadowPaymentControl) FailWithDetail(paymentHash lntypes.Hash,
+	reason FailureReason, detail *PaymentFailureDetail) (*MPPayment,
+	error) {
+
+	payment, err := s.primary.FailWithDetail(
+		paymentHash, reason, detail,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	s.enqueue("failure", paymentHash, func(shadow *PaymentControl) error {
+		_, err := shadow.FailWithDetail(paymentHash, reason, detail)
+		return err
+	})
+
+	return payment, nil
+}
+
+// ResolveAttempts resolves the attempts in the primary store and replicates
+// the resolutions to the shadow store.
+func (s *ShadowPaymentControl) ResolveAttempts(hash lntypes.Hash,
+	resolutions []AttemptResolution) (*MPPayment, error) {
+
+	payment, err := s.primary.ResolveAttempts(hash, resolutions)
+	if err != nil {
+		return nil, err
+	}
+
+	s.enqueue("attempt resolutions", hash,
+		func(shadow *PaymentControl) error {
+			_, err := shadow.ResolveAttempts(hash, resolutions)
+			return err
+		},
+	)
+
+	return payment, nil
+}
+
+// DeleteFailedAttempts deletes the failed attempts of the payment in the
+// primary store and replicates the deletion to the shadow store.
+func (s *ShadowPaymentControl) DeleteFailedAttempts(hash lntypes.Hash) error {
+	if err := s.primary.DeleteFailedAttempts(hash); err != nil {
+		return err
+	}
+
+	s.enqueue("failed attempts deletion", hash,
+		func(shadow *PaymentControl) error {
+			return shadow.DeleteFailedAttempts(hash)
+		},
+	)
+
+	return nil
+}
+
+// DeletePayment deletes the payment, or only its failed attempts, in the
+// primary store and replicates the deletion to the shadow store.
+func (s *ShadowPaymentControl) DeletePayment(hash lntypes.Hash,
+	failedHtlcsOnly bool) (*DeletedPayment, error) {
+
+	deleted, err := s.primary.DeletePayment(hash, failedHtlcsOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	s.enqueue("deletion", hash, func(shadow *PaymentControl) error {
+		_, err := shadow.DeletePayment(hash, failedHtlcsOnly)
+		return err
+	})
+
+	return deleted, nil
+}
+
+// SetNotifier sets the notifier of the primary store. Updates replicated to
+// the shadow store aren't notified.
+func (s *ShadowPaymentControl) SetNotifier(notifier PaymentNotifier) {
+	s.primary.SetNotifier(notifier)
+}
+
+// AbandonAttempt abandons the attempt in the primary store and replicates the
+// abandonment to the shadow store.
+func (s *ShadowPaymentControl) AbandonAttempt(hash lntypes.Hash,
+	attemptID uint64) (*MPPayment, *HTLCAttempt, error) {
+
+	payment, attempt, err := s.primary.AbandonAttempt(hash, attemptID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.enqueue("attempt abandonment", hash,
+		func(shadow *PaymentControl) error {
+			_, _, err := shadow.AbandonAttempt(hash, attemptID)
+			return err
+		},
+	)
+
+	return payment, attempt, nil
+}
+
+// AbandonPayment abandons the payment in the primary store and replicates the
+// abandonment to the shadow store.
+func (s *ShadowPaymentControl) AbandonPayment(hash lntypes.Hash) error {
+	if err := s.primary.AbandonPayment(hash); err != nil {
+		return err
+	}
+
+	s.enqueue("abandonment", hash, func(shadow *PaymentControl) error {
+		return shadow.AbandonPayment(hash)
+	})
+
+	return nil
+}
+
+// ReconcilePayment reconciles the payment in the primary store and replicates
+// the reconciliation to the shadow store.
+func (s *ShadowPaymentControl) ReconcilePayment(ctx context.Context,
+	hash lntypes.Hash) (*MPPayment, error) {
+
+	payment, err := s.primary.ReconcilePayment(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	s.enqueue("reconciliation", hash, func(shadow *PaymentControl) error {
+		_, err := shadow.ReconcilePayment(context.Background(), hash)
+		return err
+	})
+
+	return payment, nil
+}
+
+// StoreTimings stores the timings in the primary store and replicates them to
+// the shadow store.
+func (s *ShadowPaymentControl) StoreTimings(hash lntypes.Hash,
+	timings *PaymentTimings) error {
+
+	if err := s.primary.StoreTimings(hash, timings); err != nil {
+		return err
+	}
+
+	s.enqueue("timings", hash, func(shadow *PaymentControl) error {
+		return shadow.StoreTimings(hash, timings)
+	})
+
+	return nil
+}
+
+// DeletePayments deletes the payments in the primary store and replicates the
+// deletion of each altered payment to the shadow store. The payments altered
+// before an error are replicated as well.
+func (s *ShadowPaymentControl) DeletePayments(failedOnly,
+	failedHtlcsOnly bool) ([]lntypes.Hash, int, error) {
+
+	deleted, numAttempts, err := s.primary.DeletePayments(
+		failedOnly, failedHtlcsOnly,
+	)
+	s.enqueueDeletions(deleted, failedHtlcsOnly)
+
+	return deleted, numAttempts, err
+}
+
+// DeletePaymentsBefore deletes the payments in the primary store and
+// replicates the deletion of each deleted payment to the shadow store.
+func (s *ShadowPaymentControl) DeletePaymentsBefore(ctx context.Context,
+	cutoff time.Time, failedOnly bool, maxDeletes int) ([]lntypes.Hash,
+	error) {
+
+	deleted, err := s.primary.DeletePaymentsBefore(
+		ctx, cutoff, failedOnly, maxDeletes,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	const failedHtlcsOnly = false
+	s.enqueueDeletions(deleted, failedHtlcsOnly)
+
+	return deleted, nil
+}
+
+// enqueueDeletions queues the deletion of each of the given payments, or only
+// of their failed attempts, for the shadow store.
+func (s *ShadowPaymentControl) enqueueDeletions(hashes []lntypes.Hash,
+	failedHtlcsOnly bool) {
+
+	for _, hash := range hashes {
+		hash := hash
+		s.enqueue("deletion", hash, func(shadow *PaymentControl) error {
+			_, err := shadow.DeletePayment(hash, failedHtlcsOnly)
+			return err
+		})
+	}
+}
+
+// FetchPayment returns the payment from the primary store.
+func (s *ShadowPaymentControl) FetchPayment(hash lntypes.Hash) (*MPPayment,
+	error) {
+
+	return s.primary.FetchPayment(hash)
+}
+
+// FetchPaymentState returns the state of the payment from the primary store.
+func (s *ShadowPaymentControl) FetchPaymentState(hash lntypes.Hash) (
+	*MPPaymentState, PaymentStatus, error) {
+
+	return s.primary.FetchPaymentState(hash)
+}
+
+// FetchInFlightPayments returns the in-flight payments of the primary store.
+func (s *ShadowPaymentControl) FetchInFlightPayments(
+	ctx context.Context) ([]*MPPayment, error) {
+
+	return s.primary.FetchInFlightPayments(ctx)
+}
+
+// ForEachInFlightPayment calls the callback for every in-flight payment of the
+// primary store.
+func (s *ShadowPaymentControl) ForEachInFlightPayment(ctx context.Context,
+	pageSize int, cb func(*MPPayment) error) error {
+
+	return s.primary.ForEachInFlightPayment(ctx, pageSize, cb)
+}
+
+// CompareRandomSample compares up to n randomly chosen payments of the primary
+// store with their copies in the shadow store, after all queued writes were
+// replicated. It returns the number of payments that differ, which are also
+// added to the divergence counter.
+func (s *ShadowPaymentControl) CompareRandomSample(n int) (int, error) {
+	if err := s.flush(); err != nil {
+		return 0, err
+	}
+
+	payments, err := s.primary.db.FetchPayments()
+	if err != nil {
+		return 0, err
+	}
+
+	// Legacy duplicate payments share the hash of their payment and
+	// aren't replicated, so every hash is only sampled once.
+	var (
+		hashes []lntypes.Hash
+		seen   = make(map[lntypes.Hash]struct{})
+	)
+	for _, payment := range payments {
+		hash := payment.Info.PaymentIdentifier
+		if _, ok := seen[hash]; ok {
+			continue
+		}
+
+		seen[hash] = struct{}{}
+		hashes = append(hashes, hash)
+	}
+
+	var diverged int
+	for _, i := range rand.Perm(len(hashes))[:min(n, len(hashes))] {
+		hash := hashes[i]
+
+		payment, err := s.primary.FetchPayment(hash)
+		if err != nil {
+			return diverged, err
+		}
+
+		shadowPayment, err := s.shadow.FetchPayment(hash)
+		if err == nil {
+			err = comparePayments(payment, shadowPayment)
+		}
+		if err != nil {
+			log.Warnf("Payment %v diverged in shadow store: %v",
+				hash, err)
+
+			diverged++
+		}
+	}
+
+	s.numDiverged.Add(uint64(diverged))
+
+	return diverged, nil
+}
+
+// comparePayments returns an error describing the first difference found
+// between the two payments. As each store assigns its own sequence numbers,
+// they aren't compared.
+func comparePayments(a, b *MPPayment) error {
+	switch {
+	case a.Info.PaymentIdentifier != b.Info.PaymentIdentifier:
+		return fmt.Errorf("identifier %v != %v",
+			a.Info.PaymentIdentifier, b.Info.PaymentIdentifier)
+
+	case a.Info.Value != b.Info.Value:
+		return fmt.Errorf("value %v != %v", a.Info.Value, b.Info.Value)
+
+	case a.Status != b.Status:
+		return fmt.Errorf("status %v != %v", a.Status, b.Status)
+
+	case (a.FailureReason == nil) != (b.FailureReason == nil),
+		a.FailureReason != nil && *a.FailureReason != *b.FailureReason:
+
+		return fmt.Errorf("failure reason %v != %v", a.FailureReason,
+			b.FailureReason)
+
+	case len(a.HTLCs) != len(b.HTLCs):
+		return fmt.Errorf("%d attempts != %d attempts", len(a.HTLCs),
+			len(b.HTLCs))
+	}
+
+	for i := range a.HTLCs {
+		ha, hb := a.HTLCs[i], b.HTLCs[i]
+
+		switch {
+		case ha.AttemptID != hb.AttemptID:
+			return fmt.Errorf("attempt %d != attempt %d",
+				ha.AttemptID, hb.AttemptID)
+
+		case ha.Route.TotalAmount != hb.Route.TotalAmount:
+			return fmt.Errorf("amount of attempt %d: %v != %v",
+				ha.AttemptID, ha.Route.TotalAmount,
+				hb.Route.TotalAmount)
+
+		case (ha.Settle == nil) != (hb.Settle == nil):
+			return fmt.Errorf("settlement of attempt %d differs",
+				ha.AttemptID)
+
+		case (ha.Failure == nil) != (hb.Failure == nil):
+			return fmt.Errorf("failure of attempt %d differs",
+				ha.AttemptID)
+		}
+	}
+
+	return nil
+}
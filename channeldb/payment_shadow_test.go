@@ -0,0 +1,312 @@
+package channeldb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/lightningnetwork/lnd/record"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestShadowPaymentControl creates a shadow payment control on top of two
+// fresh databases and returns it together with the shadow store.
+func newTestShadowPaymentControl(t *testing.T,
+	queueSize int) (*ShadowPaymentControl, *PaymentControl) {
+
+	primaryDB, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	shadowDB, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	shadow := NewPaymentControl(shadowDB)
+	s := NewShadowPaymentControl(
+		NewPaymentControl(primaryDB), shadow, queueSize,
+	)
+
+	return s, shadow
+}
+
+// TestShadowPaymentControlConverges tests that both stores of a shadow payment
+// control converge after a payment flow with MPP shards, and that a payment
+// that was only written to one of them is detected.
+func TestShadowPaymentControlConverges(t *testing.T) {
+	t.Parallel()
+
+	s, _ := newTestShadowPaymentControl(t, DefaultShadowQueueSize)
+	require.NoError(t, s.Start())
+	t.Cleanup(func() {
+		require.NoError(t, s.Stop())
+	})
+
+	// sendPayment sends a payment in a shard for each of the given
+	// outcomes, and settles or fails them accordingly. The payment is
+	// failed if no shard was settled.
+	var attemptID uint64
+	sendPayment := func(settle ...bool) lntypes.Hash {
+		info, attempt, preimg, err := genInfo()
+		require.NoError(t, err)
+
+		hash := info.PaymentIdentifier
+		require.NoError(t, s.InitPayment(hash, info))
+
+		var ids []uint64
+		for range settle {
+			a := *attempt
+			a.AttemptID = attemptID
+			a.Route = *attempt.Route.Copy()
+			a.Route.FinalHop().AmtToForward = info.Value / 3
+			a.Route.FinalHop().MPP = record.NewMPP(
+				info.Value, [32]byte{1},
+			)
+			attemptID++
+
+			_, err := s.RegisterAttempt(hash, &a)
+			require.NoError(t, err)
+
+			ids = append(ids, a.AttemptID)
+		}
+
+		var settled bool
+		for i, settle := range settle {
+			if settle {
+				settled = true
+				_, _, err = s.SettleAttempt(
+					hash, ids[i],
+					&HTLCSettleInfo{Preimage: preimg},
+				)
+			} else {
+				_, _, err = s.FailAttempt(
+					hash, ids[i], &HTLCFailInfo{
+						Reason: HTLCFailUnreadable,
+					},
+				)
+			}
+			require.NoError(t, err)
+		}
+
+		if !settled {
+			_, err = s.Fail(hash, FailureReasonNoRoute)
+			require.NoError(t, err)
+		}
+
+		return hash
+	}
+
+	sendPayment(true, true, true)
+	partial := sendPayment(false, true, true)
+	failed := sendPayment(false, false)
+
+	// Delete the failed attempts of the partially failed payment and the
+	// failed payment entirely.
+	require.NoError(t, s.DeleteFailedAttempts(partial))
+	_, err := s.DeletePayment(failed, false)
+	require.NoError(t, err)
+
+	diverged, err := s.CompareRandomSample(10)
+	require.NoError(t, err)
+	require.Zero(t, diverged)
+
+	// Every write was mirrored: three inits, eight attempts with their
+	// resolutions, one payment failure and two deletions.
+	stats := s.Stats()
+	require.EqualValues(t, 3+8*2+1+2, stats.Mirrored)
+	require.Zero(t, stats.Failed)
+	require.Zero(t, stats.Dropped)
+	require.Zero(t, stats.Diverged)
+
+	// A payment that is only written to the primary store is detected.
+	info, _, _, err := genInfo()
+	require.NoError(t, err)
+
+	err = s.primary.InitPayment(info.PaymentIdentifier, info)
+	require.NoError(t, err)
+
+	diverged, err = s.CompareRandomSample(10)
+	require.NoError(t, err)
+	require.Equal(t, 1, diverged)
+	require.EqualValues(t, 1, s.Stats().Diverged)
+}
+
+// TestShadowPaymentControlMirrorsAllWrites tests that the writes beyond the
+// basic payment flow, such as abandoning payments, storing timings and bulk
+// deletions, are replicated to the shadow store as well.
+func TestShadowPaymentControlMirrorsAllWrites(t *testing.T) {
+	t.Parallel()
+
+	s, shadow := newTestShadowPaymentControl(t, DefaultShadowQueueSize)
+	require.NoError(t, s.Start())
+	t.Cleanup(func() {
+		require.NoError(t, s.Stop())
+	})
+
+	// initAttempt initiates a payment with a single attempt.
+	initAttempt := func() (lntypes.Hash, uint64) {
+		info, attempt, _, err := genInfo()
+		require.NoError(t, err)
+
+		hash := info.PaymentIdentifier
+		require.NoError(t, s.InitPayment(hash, info))
+
+		_, err = s.RegisterAttempt(hash, attempt)
+		require.NoError(t, err)
+
+		return hash, attempt.AttemptID
+	}
+
+	// The attempt of the first payment is abandoned and the payment
+	// failed, while the second payment is abandoned as a whole.
+	abandonedAttempt, attemptID := initAttempt()
+	_, _, err := s.AbandonAttempt(abandonedAttempt, attemptID)
+	require.NoError(t, err)
+
+	_, err = s.Fail(abandonedAttempt, FailureReasonNoRoute)
+	require.NoError(t, err)
+
+	timings := &PaymentTimings{
+		InitCommitted: time.Unix(1, 0),
+	}
+	require.NoError(t, s.StoreTimings(abandonedAttempt, timings))
+
+	abandoned, _ := initAttempt()
+	require.NoError(t, s.AbandonPayment(abandoned))
+
+	diverged, err := s.CompareRandomSample(10)
+	require.NoError(t, err)
+	require.Zero(t, diverged)
+
+	payment, err := shadow.FetchPayment(abandonedAttempt)
+	require.NoError(t, err)
+	require.Equal(t, timings.InitCommitted, payment.Timings.InitCommitted)
+
+	// Both failed payments are deleted in bulk.
+	deleted, _, err := s.DeletePayments(true, false)
+	require.NoError(t, err)
+	require.ElementsMatch(
+		t, []lntypes.Hash{abandonedAttempt, abandoned}, deleted,
+	)
+
+	// A payment created afterwards is deleted by its creation time.
+	old, _ := initAttempt()
+	require.NoError(t, s.AbandonPayment(old))
+
+	deleted, err = s.DeletePaymentsBefore(
+		context.Background(), time.Now().Add(time.Hour), false, 10,
+	)
+	require.NoError(t, err)
+	require.Equal(t, []lntypes.Hash{old}, deleted)
+
+	require.NoError(t, s.flush())
+	for _, hash := range []lntypes.Hash{abandonedAttempt, abandoned, old} {
+		_, err := shadow.FetchPayment(hash)
+		require.ErrorIs(t, err, ErrPaymentNotInitiated)
+	}
+
+	require.Zero(t, s.Stats().Failed)
+	require.Zero(t, s.Stats().Dropped)
+}
+
+// TestShadowPaymentControlFailures tests that writes that fail on the shadow
+// store or don't fit into the queue never fail the primary store, but are
+// counted.
+func TestShadowPaymentControlFailures(t *testing.T) {
+	t.Parallel()
+
+	t.Run("shadow failure", func(t *testing.T) {
+		t.Parallel()
+
+		s, shadow := newTestShadowPaymentControl(
+			t, DefaultShadowQueueSize,
+		)
+		require.NoError(t, s.Start())
+		t.Cleanup(func() {
+			require.NoError(t, s.Stop())
+		})
+
+		// Initiating a payment that is already in flight in the
+		// shadow store fails there only.
+		info, _, _, err := genInfo()
+		require.NoError(t, err)
+
+		hash := info.PaymentIdentifier
+		require.NoError(t, shadow.InitPayment(hash, info))
+		require.NoError(t, s.InitPayment(hash, info))
+
+		_, err = s.CompareRandomSample(1)
+		require.NoError(t, err)
+
+		stats := s.Stats()
+		require.Zero(t, stats.Mirrored)
+		require.EqualValues(t, 1, stats.Failed)
+	})
+
+	t.Run("full queue", func(t *testing.T) {
+		t.Parallel()
+
+		// Without being started, the writes aren't taken off the
+		// queue, so only the first one fits into it.
+		s, _ := newTestShadowPaymentControl(t, 1)
+
+		for i := 0; i < 2; i++ {
+			info, _, _, err := genInfo()
+			require.NoError(t, err)
+
+			err = s.InitPayment(info.PaymentIdentifier, info)
+			require.NoError(t, err)
+		}
+
+		require.EqualValues(t, 1, s.Stats().Dropped)
+	})
+}
+
+// TestShadowPaymentControlStop tests that the writes that are still queued when
+// the shadow payment control is stopped are applied, and that writes arriving
+// afterwards are counted as dropped.
+func TestShadowPaymentControlStop(t *testing.T) {
+	t.Parallel()
+
+	// Without being started, the writes stay in the queue until the
+	// shadow payment control is stopped.
+	s, shadow := newTestShadowPaymentControl(t, DefaultShadowQueueSize)
+
+	var hashes []lntypes.Hash
+	for i := 0; i < 3; i++ {
+		info, _, _, err := genInfo()
+		require.NoError(t, err)
+
+		hash := info.PaymentIdentifier
+		require.NoError(t, s.InitPayment(hash, info))
+
+		hashes = append(hashes, hash)
+	}
+
+	require.NoError(t, s.Stop())
+
+	stats := s.Stats()
+	require.EqualValues(t, len(hashes), stats.Mirrored)
+	require.Zero(t, stats.Dropped)
+	require.Empty(t, s.queue)
+
+	for _, hash := range hashes {
+		_, err := shadow.FetchPayment(hash)
+		require.NoError(t, err)
+	}
+
+	// A write after stopping still succeeds on the primary store, but
+	// isn't replicated.
+	info, _, _, err := genInfo()
+	require.NoError(t, err)
+
+	hash := info.PaymentIdentifier
+	require.NoError(t, s.InitPayment(hash, info))
+
+	_, err = s.FetchPayment(hash)
+	require.NoError(t, err)
+
+	stats = s.Stats()
+	require.EqualValues(t, len(hashes), stats.Mirrored)
+	require.EqualValues(t, 1, stats.Dropped)
+	require.Empty(t, s.queue)
+}
@@ -0,0 +1,160 @@
+package channeldb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/lightningnetwork/lnd/kvdb"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/lightningnetwork/lnd/lnwire"
+)
+
+// errPaymentStateMismatch is returned in debug builds if the stored state of a
+// payment differs from the state computed from its attempts.
+var errPaymentStateMismatch = errors.New("stored payment state doesn't " +
+	"match its attempts")
+
+// putPaymentState stores the amounts of the given state in the payment
+// bucket, so they can be read without decoding the payment's attempts. It
+// must be called whenever an attempt of the payment is added or resolved,
+// with the state computed by setState.
+func putPaymentState(bucket kvdb.RwBucket, state *MPPaymentState) error {
+	var b bytes.Buffer
+	if err := serializePaymentState(&b, state); err != nil {
+		return err
+	}
+
+	return bucket.Put(paymentStateKey, b.Bytes())
+}
+
+// fetchPaymentState returns the state and status of the payment in the given
+// bucket. If the amounts of the state were stored along with the attempts,
+// only the keys of the attempts are inspected. Otherwise, as for payments
+// that were last updated by an older version, the whole payment is fetched
+// to compute its state.
+func fetchPaymentState(bucket kvdb.RBucket) (*MPPaymentState, PaymentStatus,
+	error) {
+
+	b := bucket.Get(paymentStateKey)
+	if b == nil {
+		payment, err := fetchPayment(bucket)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		return payment.State, payment.Status, nil
+	}
+
+	state, err := deserializePaymentState(bytes.NewReader(b))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var htlcs []HTLCAttempt
+	htlcsBucket := bucket.NestedReadBucket(paymentHtlcsBucket)
+	if htlcsBucket != nil {
+		htlcs, err = fetchHtlcResolutions(htlcsBucket)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	var failureReason *FailureReason
+	if b := bucket.Get(paymentFailInfoKey); b != nil {
+		reason := FailureReason(b[0])
+		failureReason = &reason
+	}
+
+	status, err := decidePaymentStatus(htlcs, failureReason)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, h := range htlcs {
+		if h.Settle != nil {
+			state.HasSettledHTLC = true
+			break
+		}
+	}
+	state.PaymentFailed = failureReason != nil
+
+	// The state computed by setState remains the source of truth, so make
+	// sure the stored amounts match it in debug builds.
+	if paymentStateDebug {
+		payment, err := fetchPayment(bucket)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if *payment.State != *state || payment.Status != status {
+			return nil, 0, fmt.Errorf("%w: payment %v, stored "+
+				"%+v (%v), computed %+v (%v)",
+				errPaymentStateMismatch,
+				payment.Info.PaymentIdentifier, *state, status,
+				*payment.State, payment.Status)
+		}
+	}
+
+	return state, status, nil
+}
+
+// FetchPaymentState returns the state and status of the payment with the
+// given hash. Unlike FetchPayment, the attempts of the payment aren't decoded,
+// which makes this cheaper for payments with many attempts.
+func (p *PaymentControl) FetchPaymentState(paymentHash lntypes.Hash) (
+	*MPPaymentState, PaymentStatus, error) {
+
+	if payment, ok := p.db.paymentCache.get(paymentHash); ok {
+		state := *payment.State
+		return &state, payment.Status, nil
+	}
+
+	var (
+		state  *MPPaymentState
+		status PaymentStatus
+	)
+	err := kvdb.View(p.db, func(tx kvdb.RTx) error {
+		bucket, err := fetchPaymentBucket(tx, paymentHash)
+		if err != nil {
+			return err
+		}
+
+		state, status, err = fetchPaymentState(bucket)
+
+		return err
+	}, func() {
+		state = nil
+		status = 0
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return state, status, nil
+}
+
+func serializePaymentState(w io.Writer, state *MPPaymentState) error {
+	return WriteElements(
+		w, uint64(state.FeesPaid), uint64(state.RemainingAmt),
+		uint32(state.NumAttemptsInFlight),
+	)
+}
+
+func deserializePaymentState(r io.Reader) (*MPPaymentState, error) {
+	var (
+		fees, remaining uint64
+		numInFlight     uint32
+	)
+	err := ReadElements(r, &fees, &remaining, &numInFlight)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MPPaymentState{
+		NumAttemptsInFlight: int(numInFlight),
+		RemainingAmt:        lnwire.MilliSatoshi(remaining),
+		FeesPaid:            lnwire.MilliSatoshi(fees),
+	}, nil
+}
@@ -0,0 +1,10 @@
+//go:build dev
+// +build dev
+
+package channeldb
+
+const (
+	// Compare the stored state of payments with the state computed from
+	// their attempts.
+	paymentStateDebug = true
+)
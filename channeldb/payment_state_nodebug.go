@@ -0,0 +1,9 @@
+//go:build !dev
+// +build !dev
+
+package channeldb
+
+const (
+	// Trust the stored state of payments.
+	paymentStateDebug = false
+)
@@ -0,0 +1,164 @@
+package channeldb
+
+import (
+	"testing"
+
+	"github.com/lightningnetwork/lnd/kvdb"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/record"
+	"github.com/stretchr/testify/require"
+)
+
+// registerShards initiates a payment and registers the given number of MPP
+// shards for it, returning the payment's hash and preimage along with the IDs
+// of the shards.
+func registerShards(t testing.TB, pControl *PaymentControl,
+	numShards int) (lntypes.Hash, lntypes.Preimage, []uint64) {
+
+	info, attempt, preimg, err := genInfo()
+	require.NoError(t, err)
+	info.FeeLimit = NoFeeLimit
+
+	hash := info.PaymentIdentifier
+	require.NoError(t, pControl.InitPayment(hash, info))
+
+	ids := make([]uint64, numShards)
+	for i := range ids {
+		a := *attempt
+		a.AttemptID = uint64(i)
+		a.Route = *attempt.Route.Copy()
+		a.Route.FinalHop().AmtToForward = info.Value /
+			lnwire.MilliSatoshi(numShards)
+		a.Route.FinalHop().MPP = record.NewMPP(
+			info.Value, *info.PaymentAddr,
+		)
+
+		_, err := pControl.RegisterAttempt(hash, &a)
+		require.NoError(t, err)
+
+		ids[i] = a.AttemptID
+	}
+
+	return hash, preimg, ids
+}
+
+// TestFetchPaymentState tests that the state of a payment that is read without
+// decoding its attempts always matches the state computed from them.
+func TestFetchPaymentState(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+
+	// assertState asserts that the state of the payment with the given
+	// hash matches the one computed by FetchPayment.
+	assertState := func(hash lntypes.Hash) {
+		t.Helper()
+
+		payment, err := pControl.FetchPayment(hash)
+		require.NoError(t, err)
+
+		state, status, err := pControl.FetchPaymentState(hash)
+		require.NoError(t, err)
+		require.Equal(t, payment.State, state)
+		require.Equal(t, payment.Status, status)
+	}
+
+	hash, preimg, ids := registerShards(t, pControl, 3)
+	assertState(hash)
+
+	// The state was stored along with the attempts.
+	err = kvdb.View(db, func(tx kvdb.RTx) error {
+		bucket, err := fetchPaymentBucket(tx, hash)
+		require.NoError(t, err)
+		require.NotNil(t, bucket.Get(paymentStateKey))
+
+		return nil
+	}, func() {})
+	require.NoError(t, err)
+
+	_, _, err = pControl.FailAttempt(hash, ids[0], &HTLCFailInfo{
+		Reason: HTLCFailUnreadable,
+	})
+	require.NoError(t, err)
+	assertState(hash)
+
+	settle := &HTLCSettleInfo{Preimage: preimg}
+	_, _, err = pControl.SettleAttempt(hash, ids[1], settle)
+	require.NoError(t, err)
+	assertState(hash)
+
+	_, _, err = pControl.SettleAttempt(hash, ids[2], settle)
+	require.NoError(t, err)
+	assertState(hash)
+
+	// Deleting the failed attempt keeps the state valid.
+	require.NoError(t, pControl.DeleteFailedAttempts(hash))
+	assertState(hash)
+
+	// Abandoning a payment releases the amounts of its attempts.
+	hash, _, _ = registerShards(t, pControl, 2)
+	require.NoError(t, pControl.AbandonPayment(hash))
+	assertState(hash)
+
+	// Retrying the abandoned payment with a different amount drops the
+	// state of its previous attempts.
+	payment, err := pControl.FetchPayment(hash)
+	require.NoError(t, err)
+
+	info := *payment.Info
+	info.Value *= 2
+	require.NoError(t, pControl.InitPayment(hash, &info))
+	assertState(hash)
+
+	state, _, err := pControl.FetchPaymentState(hash)
+	require.NoError(t, err)
+	require.Equal(t, info.Value, state.RemainingAmt)
+
+	// A payment without a stored state, such as one that was last updated
+	// by an older version, has its state computed from its attempts.
+	hash, _, _ = registerShards(t, pControl, 2)
+	err = kvdb.Update(db, func(tx kvdb.RwTx) error {
+		bucket, err := fetchPaymentBucketUpdate(tx, hash)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Delete(paymentStateKey)
+	}, func() {})
+	require.NoError(t, err)
+	assertState(hash)
+
+	_, _, err = pControl.FetchPaymentState(lntypes.Hash{1})
+	require.ErrorIs(t, err, ErrPaymentNotInitiated)
+}
+
+// BenchmarkFetchPaymentState compares reading the state of a payment with 50
+// in-flight shards from its stored state with computing it from the decoded
+// payment.
+func BenchmarkFetchPaymentState(b *testing.B) {
+	db, err := MakeTestDB(b)
+	require.NoError(b, err)
+
+	pControl := NewPaymentControl(db)
+	hash, _, _ := registerShards(b, pControl, 50)
+
+	b.Run("computed", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, err := pControl.FetchPayment(hash)
+			require.NoError(b, err)
+		}
+	})
+
+	b.Run("stored", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, _, err := pControl.FetchPaymentState(hash)
+			require.NoError(b, err)
+		}
+	})
+}
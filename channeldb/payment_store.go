@@ -0,0 +1,99 @@
+package channeldb
+
+import (
+	"context"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+// PaymentStore is the store that tracks the lifecycle of outgoing payments. It
+// is implemented by PaymentControl and by ShadowPaymentControl, which wraps a
+// PaymentControl to replicate its writes.
+type PaymentStore interface {
+	// SetNotifier sets the notifier that is called after each committed
+	// update of a payment.
+	SetNotifier(notifier PaymentNotifier)
+
+	// InitPayment checks or records the given creation info, making sure
+	// the payment doesn't already exist as an in-flight payment.
+	InitPayment(lntypes.Hash, *PaymentCreationInfo) error
+
+	// RegisterAttempt atomically records the given attempt.
+	RegisterAttempt(lntypes.Hash, *HTLCAttemptInfo) (*MPPayment, error)
+
+	// SettleAttempt marks the given attempt settled with the preimage.
+	SettleAttempt(lntypes.Hash, uint64, *HTLCSettleInfo) (*MPPayment,
+		*HTLCAttempt, error)
+
+	// FailAttempt marks the given attempt failed.
+	FailAttempt(lntypes.Hash, uint64, *HTLCFailInfo) (*MPPayment,
+		*HTLCAttempt, error)
+
+	// AbandonAttempt marks the given attempt as abandoned, which counts as
+	// failed but records that its actual result is unknown.
+	AbandonAttempt(lntypes.Hash, uint64) (*MPPayment, *HTLCAttempt, error)
+
+	// ResolveAttempts settles or fails several attempts of a payment at
+	// once.
+	ResolveAttempts(lntypes.Hash, []AttemptResolution) (*MPPayment, error)
+
+	// Fail transitions the payment into the Failed state.
+	Fail(lntypes.Hash, FailureReason) (*MPPayment, error)
+
+	// FailWithDetail transitions the payment into the Failed state,
+	// storing the given failure detail along with the reason.
+	FailWithDetail(lntypes.Hash, FailureReason,
+		*PaymentFailureDetail) (*MPPayment, error)
+
+	// AbandonPayment abandons all in-flight attempts of the payment and
+	// fails it.
+	AbandonPayment(lntypes.Hash) error
+
+	// ReconcilePayment brings the stored state of the payment in line
+	// with its attempts.
+	ReconcilePayment(context.Context, lntypes.Hash) (*MPPayment, error)
+
+	// StoreTimings persists the timings of the payment's first attempt.
+	StoreTimings(lntypes.Hash, *PaymentTimings) error
+
+	// DeleteFailedAttempts deletes the failed attempts of the payment,
+	// unless failed attempts are kept.
+	DeleteFailedAttempts(lntypes.Hash) error
+
+	// DeletePayment deletes the payment, or only its failed attempts if
+	// failedHtlcsOnly is set.
+	DeletePayment(hash lntypes.Hash, failedHtlcsOnly bool) (*DeletedPayment,
+		error)
+
+	// DeletePayments deletes all completed and failed payments, or only
+	// their failed attempts, returning the hashes of the altered payments
+	// and the number of removed attempts.
+	DeletePayments(failedOnly, failedHtlcsOnly bool) ([]lntypes.Hash, int,
+		error)
+
+	// DeletePaymentsBefore deletes at most maxDeletes removable payments
+	// created before the cutoff, returning their hashes.
+	DeletePaymentsBefore(ctx context.Context, cutoff time.Time,
+		failedOnly bool, maxDeletes int) ([]lntypes.Hash, error)
+
+	// FetchPayment returns the payment with the given hash.
+	FetchPayment(lntypes.Hash) (*MPPayment, error)
+
+	// FetchPaymentState returns the state and status of the payment with
+	// the given hash without decoding its attempts.
+	FetchPaymentState(lntypes.Hash) (*MPPaymentState, PaymentStatus,
+		error)
+
+	// FetchInFlightPayments returns all payments with status InFlight.
+	FetchInFlightPayments(context.Context) ([]*MPPayment, error)
+
+	// ForEachInFlightPayment calls the callback for every payment with
+	// status InFlight, reading them page by page.
+	ForEachInFlightPayment(ctx context.Context, pageSize int,
+		cb func(*MPPayment) error) error
+}
+
+// Compile-time constraint to ensure that PaymentControl implements the
+// PaymentStore interface.
+var _ PaymentStore = (*PaymentControl)(nil)
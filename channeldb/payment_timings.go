@@ -0,0 +1,102 @@
+package channeldb
+
+import (
+	"bytes"
+	"io"
+	"time"
+
+	"github.com/lightningnetwork/lnd/kvdb"
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+// PaymentTimings records when a payment passed the milestones leading up to
+// its first HTLC attempt being handed to the switch. All times are taken from
+// the same clock, so the differences between them can be compared across
+// payments. A zero time means the milestone wasn't observed, for instance
+// because the payment was resumed after a restart.
+type PaymentTimings struct {
+	// InitCommitted is the time the payment's initialization was
+	// committed to the database.
+	InitCommitted time.Time
+
+	// FirstRouteFound is the time the first route for the payment was
+	// returned by pathfinding.
+	FirstRouteFound time.Time
+
+	// FirstAttemptRegistered is the time the first HTLC attempt of the
+	// payment was persisted.
+	FirstAttemptRegistered time.Time
+
+	// FirstAttemptDispatched is the time the first HTLC attempt of the
+	// payment was handed to the switch.
+	FirstAttemptDispatched time.Time
+}
+
+// IsEmpty returns true if none of the milestones were observed.
+func (t *PaymentTimings) IsEmpty() bool {
+	return t.InitCommitted.IsZero() && t.FirstRouteFound.IsZero() &&
+		t.FirstAttemptRegistered.IsZero() &&
+		t.FirstAttemptDispatched.IsZero()
+}
+
+// StoreTimings persists the timings of the payment with the given hash,
+// replacing any timings stored earlier.
+func (p *PaymentControl) StoreTimings(paymentHash lntypes.Hash,
+	timings *PaymentTimings) error {
+
+	var b bytes.Buffer
+	if err := serializePaymentTimings(&b, timings); err != nil {
+		return err
+	}
+
+	p.db.paymentCache.lock(paymentHash)
+	defer p.db.paymentCache.unlock(paymentHash)
+
+	err := kvdb.Update(p.db, func(tx kvdb.RwTx) error {
+		bucket, err := fetchPaymentBucketUpdate(tx, paymentHash)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put(paymentTimingsKey, b.Bytes())
+	}, func() {})
+	if err != nil {
+		return err
+	}
+
+	// The cached payment doesn't have the timings yet.
+	p.db.paymentCache.remove(paymentHash)
+
+	return nil
+}
+
+func serializePaymentTimings(w io.Writer, t *PaymentTimings) error {
+	times := []time.Time{
+		t.InitCommitted, t.FirstRouteFound, t.FirstAttemptRegistered,
+		t.FirstAttemptDispatched,
+	}
+	for _, ts := range times {
+		if err := serializeTime(w, ts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func deserializePaymentTimings(r io.Reader) (*PaymentTimings, error) {
+	t := &PaymentTimings{}
+	times := []*time.Time{
+		&t.InitCommitted, &t.FirstRouteFound,
+		&t.FirstAttemptRegistered, &t.FirstAttemptDispatched,
+	}
+	for _, ts := range times {
+		var err error
+		*ts, err = deserializeTime(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
@@ -2,17 +2,21 @@ package channeldb
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/lightningnetwork/lnd/kvdb"
 	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
 	"github.com/lightningnetwork/lnd/lnwire"
 	"github.com/lightningnetwork/lnd/record"
 	"github.com/lightningnetwork/lnd/routing/route"
@@ -33,6 +37,11 @@ var (
 	//      |        |--sequence-key: <sequence number>
 	//      |        |--creation-info-key: <creation info>
 	//      |        |--fail-info-key: <(optional) fail info>
+	//      |        |--fail-detail-key: <(optional) fail detail>
+	//      |        |--label-key: <(optional) payment label>
+	//      |        |--payment-addr-key: <(optional) payment address>
+	//      |        |--max-recorded-attempts-key: <(optional) attempts cap>
+	//      |        |--client-reference-key: <(optional) client reference>
 	//      |        |
 	//      |        |--payment-htlcs-bucket (shard-bucket)
 	//      |        |        |
@@ -75,6 +84,11 @@ var (
 	// about the HTLCs that were attempted for a payment.
 	paymentHtlcsBucket = []byte("payment-htlcs-bucket")
 
+	// paymentAttemptRoutesBucket is a bucket where we'll store the
+	// serialized routes of the HTLC attempts of a payment, keyed by
+	// attempt ID, if storing them is enabled.
+	paymentAttemptRoutesBucket = []byte("payment-attempt-routes-bucket")
+
 	// htlcAttemptInfoKey is the key used as the prefix of an HTLC attempt
 	// to store the info about the attempt that was done for the HTLC in
 	// question. The HTLC attempt ID is concatenated at the end.
@@ -93,6 +107,37 @@ var (
 	// store information about the reason a payment failed.
 	paymentFailInfoKey = []byte("payment-fail-info")
 
+	// paymentFailDetailKey is a key used in the payment's sub-bucket to
+	// store the optional diagnostics of the payment's failure.
+	paymentFailDetailKey = []byte("payment-fail-detail")
+
+	// paymentLabelKey is a key used in the payment's sub-bucket to store
+	// the user-supplied label of the payment, if any.
+	paymentLabelKey = []byte("payment-label")
+
+	// paymentAddrKey is a key used in the payment's sub-bucket to store
+	// the payment address the payment was sent to, if any.
+	paymentAddrKey = []byte("payment-addr")
+
+	// paymentMaxRecordedAttemptsKey is a key used in the payment's
+	// sub-bucket to store the maximum number of htlc attempts recorded for
+	// the payment, if it's limited.
+	paymentMaxRecordedAttemptsKey = []byte("payment-max-recorded-attempts")
+
+	// paymentClientReferenceKey is a key used in the payment's sub-bucket
+	// to store the client-supplied reference of the payment, if any.
+	paymentClientReferenceKey = []byte("payment-client-reference")
+
+	// paymentTimingsKey is a key used in the payment's sub-bucket to
+	// store the timings of the payment's first attempt, once the payment
+	// has reached a terminal state.
+	paymentTimingsKey = []byte("payment-timings")
+
+	// paymentStateKey is a key used in the payment's sub-bucket to store
+	// the fees paid, the remaining amount and the number of in-flight
+	// attempts of the payment, as of the last update of its attempts.
+	paymentStateKey = []byte("payment-state")
+
 	// paymentsIndexBucket is the name of the top-level bucket within the
 	// database that stores an index of payment sequence numbers to its
 	// payment hash.
@@ -101,6 +146,39 @@ var (
 	// 	|--...
 	// 	|--<sequence-number>: <payment hash>
 	paymentsIndexBucket = []byte("payments-index-bucket")
+
+	// paymentsInFlightIndexBucket is the name of the top-level bucket
+	// within the database that indexes the payments that aren't
+	// terminated yet, so they can be found without reading all payments.
+	// Databases created before the index existed lack the bucket until it
+	// is built on the first fetch of the in-flight payments.
+	// payments-inflight-index-bucket
+	// 	|--<payment hash>: <empty>
+	// 	|--...
+	// 	|--<payment hash>: <empty>
+	paymentsInFlightIndexBucket = []byte("payments-inflight-index-bucket")
+
+	// paymentsReferenceIndexBucket is the name of the top-level bucket
+	// within the database that maps the client references of payments to
+	// their payment hash, ensuring a reference is only used by a single
+	// payment. Databases created before the index existed lack the bucket
+	// until the first payment with a reference is initiated.
+	// payments-reference-index-bucket
+	// 	|--<client reference>: <payment hash>
+	// 	|--...
+	// 	|--<client reference>: <payment hash>
+	paymentsReferenceIndexBucket = []byte("payments-reference-index-bucket")
+
+	// paymentsCountBucket is the name of the top-level bucket within the
+	// database that stores the number of entries of the payments index,
+	// so the payments can be counted without walking the index. The count
+	// is missing until it's first needed for databases created before it
+	// existed.
+	paymentsCountBucket = []byte("payments-count-bucket")
+
+	// paymentsCountKey is the key of the payments count within the
+	// payments count bucket.
+	paymentsCountKey = []byte("payments-count")
 )
 
 var (
@@ -121,8 +199,33 @@ var (
 	// payments in their own sub-bucket.
 	ErrNoDuplicateNestedBucket = errors.New("nested duplicate bucket not " +
 		"found")
+
+	// ErrStopIteration can be returned by the callback passed to
+	// ForEachPayment to stop the iteration early without an error.
+	ErrStopIteration = errors.New("stop iteration")
+
+	// ErrInvalidDateRange is returned when payments are queried with a
+	// negative creation date or a start date after the end date.
+	ErrInvalidDateRange = errors.New("invalid creation date range")
+
+	// ErrQueryTimeout is returned when a payments query exceeds the
+	// configured default query timeout.
+	ErrQueryTimeout = errors.New("payments query timed out")
 )
 
+// defaultPaymentsPageSize is the number of payments ForEachPayment reads per
+// database transaction if the query doesn't specify a page size.
+const defaultPaymentsPageSize = 1000
+
+// paymentIndexGCBatchSize is the number of payment index entries
+// GCPaymentIndexes checks per database transaction.
+const paymentIndexGCBatchSize = 1000
+
+// paymentScanCheckInterval is the number of payments after which a scan of
+// the payments checks whether its context was canceled, so a canceled call
+// releases its read transaction promptly.
+const paymentScanCheckInterval = 100
+
 // FailureReason encodes the reason a payment ultimately failed.
 type FailureReason byte
 
@@ -147,7 +250,15 @@ const (
 	// balance to complete the payment.
 	FailureReasonInsufficientBalance FailureReason = 4
 
-	// TODO(halseth): cancel state.
+	// FailureReasonCanceled indicates that the payment was abandoned by
+	// the user while it was still in flight.
+	FailureReasonCanceled FailureReason = 5
+
+	// FailureReasonLegacyUnknown indicates that the payment was failed
+	// with a reason that isn't known. Some very old payments were stored
+	// with such reasons. It is never written to the database, instead the
+	// unknown reasons are mapped to it when payments are read.
+	FailureReasonLegacyUnknown FailureReason = 0xff
 
 	// TODO(joostjager): Add failure reasons for:
 	// LocalLiquidityInsufficient, RemoteCapacityInsufficient.
@@ -171,11 +282,21 @@ func (r FailureReason) String() string {
 		return "incorrect_payment_details"
 	case FailureReasonInsufficientBalance:
 		return "insufficient_balance"
+	case FailureReasonCanceled:
+		return "canceled"
+	case FailureReasonLegacyUnknown:
+		return "legacy_unknown"
 	}
 
 	return "unknown"
 }
 
+// known returns true if the failure reason is one of the reasons a payment
+// can be failed with.
+func (r FailureReason) known() bool {
+	return r <= FailureReasonCanceled
+}
+
 // PaymentCreationInfo is the information necessary to have ready when
 // initiating a payment, moving it into state InFlight.
 type PaymentCreationInfo struct {
@@ -189,10 +310,56 @@ type PaymentCreationInfo struct {
 	// CreationTime is the time when this payment was initiated.
 	CreationTime time.Time
 
-	// PaymentRequest is the full payment request, if any.
+	// PaymentRequest is the full payment request, if any. It is empty for
+	// payments that were stored without their payment request.
 	PaymentRequest []byte
+
+	// SkipPaymentRequest indicates that the payment request shouldn't be
+	// stored with the payment, for callers that don't need it back. The
+	// flag itself isn't stored. Payment requests are also skipped for all
+	// payments if the database was opened with OptionSkipPaymentRequests.
+	SkipPaymentRequest bool
+
+	// FeeLimit is the maximum total fee the payment is allowed to pay. It
+	// is set to NoFeeLimit for payments that weren't sent with a fee limit
+	// or that were created before the fee limit was persisted.
+	FeeLimit lnwire.MilliSatoshi
+
+	// Label is an optional user-supplied label of the payment. It is
+	// empty for payments that were created without a label. The label may
+	// be at most MaxPaymentLabelLength bytes long.
+	Label string
+
+	// PaymentAddr is the payment address the payment is sent to. It is
+	// taken from the invoice or, if the invoice didn't provide one, from
+	// the MPP record of the payment's first attempt. It is nil for
+	// payments without a payment address, such as keysend payments.
+	PaymentAddr *[32]byte
+
+	// MaxRecordedAttempts is the maximum number of htlc attempts that are
+	// kept for the payment. Once it's reached, the oldest failed attempts
+	// are dropped when registering a new attempt. Settled and in-flight
+	// attempts are always kept. Zero means that the number of recorded
+	// attempts isn't limited.
+	MaxRecordedAttempts uint32
+
+	// ClientReference is an optional reference supplied by the client
+	// sending the payment, of at most MaxClientReferenceLength bytes. No
+	// two payments can carry the same reference, which lets clients
+	// retry sending a payment without risking to pay twice.
+	ClientReference []byte
 }
 
+// NoFeeLimit is the fee limit of payments whose total fee isn't restricted.
+const NoFeeLimit = lnwire.MilliSatoshi(math.MaxUint64)
+
+// MaxPaymentLabelLength is the maximum length of a payment's label in bytes.
+const MaxPaymentLabelLength = 500
+
+// MaxClientReferenceLength is the maximum length of a payment's client
+// reference in bytes.
+const MaxClientReferenceLength = 32
+
 // htlcBucketKey creates a composite key from prefix and id where the result is
 // simply the two concatenated.
 func htlcBucketKey(prefix, id []byte) []byte {
@@ -228,6 +395,10 @@ func (d *DB) FetchPayments() ([]*MPPayment, error) {
 				return err
 			}
 
+			if err := d.checkQuarantine(p); err != nil {
+				return err
+			}
+
 			payments = append(payments, p)
 
 			// For older versions of lnd, duplicate payments to a
@@ -264,10 +435,52 @@ func fetchCreationInfo(bucket kvdb.RBucket) (*PaymentCreationInfo, error) {
 	}
 
 	r := bytes.NewReader(b)
-	return deserializePaymentCreationInfo(r)
+	info, err := deserializePaymentCreationInfo(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// The label is stored separately and is absent for payments without
+	// one.
+	if label := bucket.Get(paymentLabelKey); label != nil {
+		info.Label = string(label)
+	}
+
+	// The payment address is stored separately as well, since it may
+	// only become known with the payment's first attempt.
+	if addr := bucket.Get(paymentAddrKey); len(addr) == 32 {
+		info.PaymentAddr = &[32]byte{}
+		copy(info.PaymentAddr[:], addr)
+	}
+
+	// Likewise for the maximum number of recorded attempts, which is
+	// absent if the number isn't limited.
+	maxAttempts := bucket.Get(paymentMaxRecordedAttemptsKey)
+	if len(maxAttempts) == 4 {
+		info.MaxRecordedAttempts = byteOrder.Uint32(maxAttempts)
+	}
+
+	// And for the client reference, which is absent for payments without
+	// one.
+	if ref := bucket.Get(paymentClientReferenceKey); ref != nil {
+		info.ClientReference = copyBytes(ref)
+	}
+
+	return info, nil
 }
 
+// fetchPayment fetches the payment stored in the given payment bucket,
+// including the full routes of all its HTLC attempts.
 func fetchPayment(bucket kvdb.RBucket) (*MPPayment, error) {
+	return fetchPaymentWithHops(bucket, false)
+}
+
+// fetchPaymentWithHops fetches the payment stored in the given payment bucket.
+// If skipHops is true, the routes of the payment's HTLC attempts are only
+// partially deserialized, see deserializeRouteSummary.
+func fetchPaymentWithHops(bucket kvdb.RBucket, skipHops bool) (*MPPayment,
+	error) {
+
 	seqBytes := bucket.Get(paymentSequenceKey)
 	if seqBytes == nil {
 		return nil, fmt.Errorf("sequence number not found")
@@ -285,26 +498,58 @@ func fetchPayment(bucket kvdb.RBucket) (*MPPayment, error) {
 	htlcsBucket := bucket.NestedReadBucket(paymentHtlcsBucket)
 	if htlcsBucket != nil {
 		// Get the payment attempts. This can be empty.
-		htlcs, err = fetchHtlcAttempts(htlcsBucket)
+		htlcs, err = fetchHtlcAttempts(htlcsBucket, skipHops)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	// Get failure reason if available.
-	var failureReason *FailureReason
+	// Get failure reason if available. Reasons that aren't known are
+	// mapped to FailureReasonLegacyUnknown, keeping the stored byte.
+	var (
+		failureReason    *FailureReason
+		rawFailureReason *byte
+	)
 	b := bucket.Get(paymentFailInfoKey)
 	if b != nil {
 		reason := FailureReason(b[0])
+		if !reason.known() {
+			raw := b[0]
+			rawFailureReason = &raw
+			reason = FailureReasonLegacyUnknown
+		}
 		failureReason = &reason
 	}
 
 	// Create a new payment.
 	payment := &MPPayment{
-		SequenceNum:   sequenceNum,
-		Info:          creationInfo,
-		HTLCs:         htlcs,
-		FailureReason: failureReason,
+		SequenceNum:      sequenceNum,
+		Info:             creationInfo,
+		HTLCs:            htlcs,
+		FailureReason:    failureReason,
+		RawFailureReason: rawFailureReason,
+	}
+
+	// Payments that failed before the failure detail existed, or that
+	// failed without one, don't have it stored.
+	if b := bucket.Get(paymentFailDetailKey); b != nil {
+		payment.FailureDetail, err = deserializePaymentFailureDetail(
+			bytes.NewReader(b),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The timings are only stored once the payment has been sent to
+	// completion.
+	if b := bucket.Get(paymentTimingsKey); b != nil {
+		payment.Timings, err = deserializePaymentTimings(
+			bytes.NewReader(b),
+		)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// Set its state and status.
@@ -316,41 +561,74 @@ func fetchPayment(bucket kvdb.RBucket) (*MPPayment, error) {
 }
 
 // fetchHtlcAttempts retrieves all htlc attempts made for the payment found in
-// the given bucket.
-func fetchHtlcAttempts(bucket kvdb.RBucket) ([]HTLCAttempt, error) {
+// the given bucket. If skipHops is true, only a summary of each attempt's route
+// is read. Attempts whose stored data can't be parsed are quarantined instead
+// of failing the read, see HTLCAttempt.Corrupt.
+func fetchHtlcAttempts(bucket kvdb.RBucket, skipHops bool) ([]HTLCAttempt,
+	error) {
+
 	htlcsMap := make(map[uint64]*HTLCAttempt)
+	hasAttemptInfo := make(map[uint64]bool)
+
+	// quarantine marks the attempt as corrupt, recording the raw record
+	// that failed to parse.
+	quarantine := func(htlc *HTLCAttempt, k, v []byte, err error) {
+		if htlc.Corrupt == nil {
+			htlc.Corrupt = &HTLCCorruption{}
+		}
+		htlc.Corrupt.addRecord(k, v, err)
+	}
 
-	attemptInfoCount := 0
 	err := bucket.ForEach(func(k, v []byte) error {
 		aid := byteOrder.Uint64(k[len(k)-8:])
 
 		if _, ok := htlcsMap[aid]; !ok {
 			htlcsMap[aid] = &HTLCAttempt{}
 		}
+		htlc := htlcsMap[aid]
 
-		var err error
 		switch {
 		case bytes.HasPrefix(k, htlcAttemptInfoKey):
-			attemptInfo, err := readHtlcAttemptInfo(v)
+			hasAttemptInfo[aid] = true
+
+			attemptInfo, err := readHtlcAttemptInfo(v, skipHops)
 			if err != nil {
-				return err
+				quarantine(htlc, k, v, err)
+
+				// Keep the ID, so the attempt can still be
+				// identified.
+				attemptInfo = &HTLCAttemptInfo{}
 			}
 
 			attemptInfo.AttemptID = aid
-			htlcsMap[aid].HTLCAttemptInfo = *attemptInfo
-			attemptInfoCount++
+			htlc.HTLCAttemptInfo = *attemptInfo
 
 		case bytes.HasPrefix(k, htlcSettleInfoKey):
-			htlcsMap[aid].Settle, err = readHtlcSettleInfo(v)
+			settle, err := readHtlcSettleInfo(v)
 			if err != nil {
-				return err
+				quarantine(htlc, k, v, err)
+
+				// The attempt is still settled, even though we
+				// don't know its preimage.
+				settle = &HTLCSettleInfo{}
 			}
+			htlc.Settle = settle
 
 		case bytes.HasPrefix(k, htlcFailInfoKey):
-			htlcsMap[aid].Failure, err = readHtlcFailInfo(v)
-			if err != nil {
-				return err
+			failure, err := readHtlcFailInfo(v)
+			switch {
+			// Only the failure message couldn't be decoded, so we
+			// keep the rest of the failure details.
+			case errors.Is(err, errUnreadableFailureMessage):
+				quarantine(htlc, k, v, err)
+
+			// The attempt is still failed, even though we don't
+			// know why.
+			case err != nil:
+				quarantine(htlc, k, v, err)
+				failure = &HTLCFailInfo{Reason: HTLCFailUnknown}
 			}
+			htlc.Failure = failure
 
 		default:
 			return fmt.Errorf("unknown htlc attempt key")
@@ -362,9 +640,20 @@ func fetchHtlcAttempts(bucket kvdb.RBucket) ([]HTLCAttempt, error) {
 		return nil, err
 	}
 
-	// Sanity check that all htlcs have an attempt info.
-	if attemptInfoCount != len(htlcsMap) {
-		return nil, errNoAttemptInfo
+	// Sanity check that all htlcs have an attempt info, quarantining the
+	// ones that don't.
+	for aid, htlc := range htlcsMap {
+		if hasAttemptInfo[aid] {
+			continue
+		}
+
+		htlc.AttemptID = aid
+		if htlc.Corrupt == nil {
+			htlc.Corrupt = &HTLCCorruption{}
+		}
+		if htlc.Corrupt.Err == nil {
+			htlc.Corrupt.Err = errNoAttemptInfo
+		}
 	}
 
 	keys := make([]uint64, len(htlcsMap))
@@ -389,10 +678,76 @@ func fetchHtlcAttempts(bucket kvdb.RBucket) ([]HTLCAttempt, error) {
 	return htlcs, nil
 }
 
-// readHtlcAttemptInfo reads the payment attempt info for this htlc.
-func readHtlcAttemptInfo(b []byte) (*HTLCAttemptInfo, error) {
-	r := bytes.NewReader(b)
-	return deserializeHTLCAttemptInfo(r)
+// fetchFailedHtlcAttempts retrieves the failed htlc attempts found in the
+// given htlcs bucket, ordered by attempt ID. Records that can't be parsed are
+// quarantined the same way fetchHtlcAttempts does.
+func fetchFailedHtlcAttempts(bucket kvdb.RBucket) ([]HTLCAttempt, error) {
+	var htlcs []HTLCAttempt
+
+	// quarantine marks the attempt as corrupt, recording the raw record
+	// that failed to parse.
+	quarantine := func(htlc *HTLCAttempt, k, v []byte, err error) {
+		if htlc.Corrupt == nil {
+			htlc.Corrupt = &HTLCCorruption{}
+		}
+		htlc.Corrupt.addRecord(k, v, err)
+	}
+
+	// The fail info keys share a prefix followed by the big endian attempt
+	// ID, so seeking to the prefix visits the failed attempts in order.
+	c := bucket.ReadCursor()
+	k, v := c.Seek(htlcFailInfoKey)
+	for ; bytes.HasPrefix(k, htlcFailInfoKey); k, v = c.Next() {
+		aid := byteOrder.Uint64(k[len(k)-8:])
+		htlc := HTLCAttempt{}
+
+		failure, err := readHtlcFailInfo(v)
+		switch {
+		// Only the failure message couldn't be decoded, so we keep the
+		// rest of the failure details.
+		case errors.Is(err, errUnreadableFailureMessage):
+			quarantine(&htlc, k, v, err)
+
+		// The attempt is still failed, even though we don't know why.
+		case err != nil:
+			quarantine(&htlc, k, v, err)
+			failure = &HTLCFailInfo{Reason: HTLCFailUnknown}
+		}
+		htlc.Failure = failure
+
+		infoKey := make([]byte, len(htlcAttemptInfoKey)+8)
+		copy(infoKey, htlcAttemptInfoKey)
+		byteOrder.PutUint64(infoKey[len(htlcAttemptInfoKey):], aid)
+
+		infoBytes := bucket.Get(infoKey)
+		if infoBytes != nil {
+			attemptInfo, err := readHtlcAttemptInfo(infoBytes, true)
+			if err != nil {
+				quarantine(&htlc, infoKey, infoBytes, err)
+			} else {
+				htlc.HTLCAttemptInfo = *attemptInfo
+			}
+		} else {
+			if htlc.Corrupt == nil {
+				htlc.Corrupt = &HTLCCorruption{}
+			}
+			if htlc.Corrupt.Err == nil {
+				htlc.Corrupt.Err = errNoAttemptInfo
+			}
+		}
+
+		// Keep the ID, so the attempt can still be identified.
+		htlc.AttemptID = aid
+		htlcs = append(htlcs, htlc)
+	}
+
+	return htlcs, nil
+}
+
+// readHtlcAttemptInfo reads the payment attempt info for this htlc. If
+// skipHops is true, only a summary of the attempt's route is read.
+func readHtlcAttemptInfo(b []byte, skipHops bool) (*HTLCAttemptInfo, error) {
+	return deserializeHTLCAttemptInfo(bytes.NewReader(b), skipHops)
 }
 
 // readHtlcSettleInfo reads the settle info for the htlc. If the htlc isn't
@@ -417,7 +772,7 @@ func fetchFailedHtlcKeys(bucket kvdb.RBucket) ([][]byte, error) {
 	var htlcs []HTLCAttempt
 	var err error
 	if htlcsBucket != nil {
-		htlcs, err = fetchHtlcAttempts(htlcsBucket)
+		htlcs, err = fetchHtlcAttempts(htlcsBucket, false)
 		if err != nil {
 			return nil, err
 		}
@@ -453,7 +808,8 @@ type PaymentsQuery struct {
 	IndexOffset uint64
 
 	// MaxPayments is the maximal number of payments returned in the
-	// payments query.
+	// payments query. If it is zero and CountTotal is set, the query only
+	// counts the payments without reading any of them.
 	MaxPayments uint64
 
 	// Reversed gives a meaning to the IndexOffset. If reversed is set to
@@ -468,7 +824,9 @@ type PaymentsQuery struct {
 	IncludeIncomplete bool
 
 	// CountTotal indicates that all payments currently present in the
-	// payment index (complete and incomplete) should be counted.
+	// payment index (complete and incomplete) should be counted. If a
+	// creation date filter is set, only the payments created within the
+	// queried time range are counted.
 	CountTotal bool
 
 	// CreationDateStart, expressed in Unix seconds, if set, filters out
@@ -478,6 +836,80 @@ type PaymentsQuery struct {
 	// CreationDateEnd, expressed in Unix seconds, if set, filters out all
 	// payments with a creation date less than or equal to it.
 	CreationDateEnd int64
+
+	// LabelContains, if set, restricts the query to payments whose label
+	// contains the given string.
+	LabelContains string
+
+	// PaymentAddr, if set, restricts the query to payments sent to the
+	// given payment address.
+	PaymentAddr *[32]byte
+
+	// BlindedOnly, if set, restricts the query to payments that targeted
+	// a blinded path.
+	BlindedOnly bool
+
+	// SkipHops indicates that the routes of the returned HTLC attempts
+	// don't need to be fully hydrated. If set, each attempt's route only
+	// carries its totals and a stub of the final hop holding the amount
+	// delivered to the receiver, which is sufficient to compute the sent
+	// amount and fees of the payment. The stub also keeps the encrypted
+	// data of the final hop, so IsBlinded still works on the payment.
+	// Custom records, other blinded path data and all intermediate hops
+	// are omitted.
+	SkipHops bool
+
+	// ExcludeHTLCs indicates that the HTLC attempts of the returned
+	// payments aren't needed. The payments then only carry their creation
+	// info, failure reason, status and state, which are still computed
+	// from the attempts. This implies SkipHops.
+	ExcludeHTLCs bool
+}
+
+// hasCreationDateFilter returns true if the query restricts the returned
+// payments to a creation date range.
+func (q *PaymentsQuery) hasCreationDateFilter() bool {
+	return q.CreationDateStart != 0 || q.CreationDateEnd != 0
+}
+
+// validateCreationDateRange returns ErrInvalidDateRange if a creation date of
+// the query is negative, or if its start date is after its end date, as no
+// payment could match the query. An end date of zero leaves the range open,
+// while an equal start and end date restrict it to a single second.
+func (q *PaymentsQuery) validateCreationDateRange() error {
+	switch {
+	case q.CreationDateStart < 0 || q.CreationDateEnd < 0:
+		return fmt.Errorf("%w: negative creation date, start=%d, "+
+			"end=%d", ErrInvalidDateRange, q.CreationDateStart,
+			q.CreationDateEnd)
+
+	case q.CreationDateEnd != 0 && q.CreationDateStart > q.CreationDateEnd:
+		return fmt.Errorf("%w: start date %d is after end date %d",
+			ErrInvalidDateRange, q.CreationDateStart,
+			q.CreationDateEnd)
+	}
+
+	return nil
+}
+
+// inCreationDateRange returns true if the given payment was created within the
+// creation date range of the query.
+func (q *PaymentsQuery) inCreationDateRange(payment *MPPayment) bool {
+	// Get the creation time in Unix seconds, this always rounds down the
+	// nanoseconds to full seconds.
+	createTime := payment.Info.CreationTime.Unix()
+
+	// Skip any payments that were created before the specified time.
+	if createTime < q.CreationDateStart {
+		return false
+	}
+
+	// Skip any payments that were created after the specified time.
+	if q.CreationDateEnd != 0 && createTime > q.CreationDateEnd {
+		return false
+	}
+
+	return true
 }
 
 // PaymentsResponse contains the result of a query to the payments database.
@@ -504,209 +936,1484 @@ type PaymentsResponse struct {
 
 	// TotalCount represents the total number of payments that are currently
 	// stored in the payment database. This will only be set if the
-	// CountTotal field in the query was set to true.
+	// CountTotal field in the query was set to true. If the query had a
+	// creation date filter, only the payments within the queried time
+	// range are counted.
 	TotalCount uint64
+
+	// HasMore is true if the page was filled to the maximum number of
+	// payments of the query and at least one more payment matching the
+	// query exists in the queried direction.
+	HasMore bool
 }
 
 // QueryPayments is a query to the payments database which is restricted
 // to a subset of payments by the payments query, containing an offset
 // index and a maximum number of returned payments.
-func (d *DB) QueryPayments(query PaymentsQuery) (PaymentsResponse, error) {
-	var resp PaymentsResponse
+//
+// The maximum number of returned payments is clamped to the limit the
+// database was configured with, so a single query can't read an unbounded
+// number of payments into memory. If the page of a clamped query is full and
+// more payments match the query, HasMore is set, so the remaining payments
+// can be fetched with further queries.
+//
+// The query is aborted with an error wrapping the context's error if the
+// context is canceled while the payments are scanned.
+func (d *DB) QueryPayments(ctx context.Context,
+	query PaymentsQuery) (PaymentsResponse, error) {
 
-	if err := kvdb.View(d, func(tx kvdb.RTx) error {
-		// Get the root payments bucket.
-		paymentsBucket := tx.ReadBucket(paymentsRootBucket)
-		if paymentsBucket == nil {
-			return nil
-		}
+	// Without the htlcs, the summaries of their routes suffice to compute
+	// the payment state.
+	if query.ExcludeHTLCs {
+		query.SkipHops = true
+	}
 
-		// Get the index bucket which maps sequence number -> payment
-		// hash and duplicate bool. If we have a payments bucket, we
-		// should have an indexes bucket as well.
-		indexes := tx.ReadBucket(paymentsIndexBucket)
-		if indexes == nil {
-			return fmt.Errorf("index bucket does not exist")
-		}
+	return d.queryPayments(ctx, query, nil)
+}
 
-		// accumulatePayments gets payments with the sequence number
-		// and hash provided and adds them to our list of payments if
-		// they meet the criteria of our query. It returns the number
-		// of payments that were added.
-		accumulatePayments := func(sequenceKey, hash []byte) (bool,
-			error) {
+// FetchPaymentsByHopPubKey returns the payments that have at least one htlc
+// attempt whose route traverses the node with the given public key, either as
+// an intermediate hop or as the destination. The results are restricted and
+// paginated by the payments query just like QueryPayments. Since there is no
+// index on the hops of a route, this requires a scan of all payments.
+//
+// NOTE: The hops of the payments are always hydrated, so the SkipHops field
+// of the query is ignored.
+func (d *DB) FetchPaymentsByHopPubKey(ctx context.Context,
+	pubKey route.Vertex, query PaymentsQuery) (PaymentsResponse, error) {
 
-			r := bytes.NewReader(hash)
-			paymentHash, err := deserializePaymentIndex(r)
-			if err != nil {
-				return false, err
-			}
+	// We need the full routes of the htlc attempts to match their hops.
+	query.SkipHops = false
 
-			payment, err := fetchPaymentWithSequenceNumber(
-				tx, paymentHash, sequenceKey,
-			)
-			if err != nil {
-				return false, err
-			}
+	return d.queryPayments(ctx, query, func(payment *MPPayment) bool {
+		return paymentTraversesNode(payment, pubKey)
+	})
+}
 
-			// To keep compatibility with the old API, we only
-			// return non-succeeded payments if requested.
-			if payment.Status != StatusSucceeded &&
-				!query.IncludeIncomplete {
+// FetchAttemptsBySetID returns the htlc attempts whose final hop carries an AMP
+// record with the given set ID. AMP payments are stored under their set ID as
+// payment identifier, so the payments bucket doubles as the index and no scan
+// of the payments is needed. If no payment is known for the set ID, no
+// attempts are returned.
+func (d *DB) FetchAttemptsBySetID(ctx context.Context,
+	setID [32]byte) ([]HTLCAttempt, error) {
 
-				return false, err
-			}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
-			// Get the creation time in Unix seconds, this always
-			// rounds down the nanoseconds to full seconds.
-			createTime := payment.Info.CreationTime.Unix()
+	var attempts []HTLCAttempt
+	err := kvdb.View(d, func(tx kvdb.RTx) error {
+		bucket, err := fetchPaymentBucket(tx, setID)
+		if errors.Is(err, ErrPaymentNotInitiated) {
+			return nil
+		} else if err != nil {
+			return err
+		}
 
-			// Skip any payments that were created before the
-			// specified time.
-			if createTime < query.CreationDateStart {
-				return false, nil
-			}
+		payment, err := fetchPayment(bucket)
+		if err != nil {
+			return err
+		}
 
-			// Skip any payments that were created after the
-			// specified time.
-			if query.CreationDateEnd != 0 &&
-				createTime > query.CreationDateEnd {
+		if err := d.checkQuarantine(payment); err != nil {
+			return err
+		}
 
-				return false, nil
+		for _, htlc := range payment.HTLCs {
+			amp := htlc.Route.FinalHop().AMP
+			if amp == nil || amp.SetID() != setID {
+				continue
 			}
 
-			// At this point, we've exhausted the offset, so we'll
-			// begin collecting invoices found within the range.
-			resp.Payments = append(resp.Payments, payment)
-			return true, nil
+			attempts = append(attempts, htlc)
 		}
 
-		// Create a paginator which reads from our sequence index bucket
-		// with the parameters provided by the payments query.
-		paginator := newPaginator(
-			indexes.ReadCursor(), query.Reversed, query.IndexOffset,
-			query.MaxPayments,
-		)
+		return nil
+	}, func() {
+		attempts = nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return attempts, nil
+}
+
+// ForEachPayment calls the given callback for every payment matching the
+// query, starting with the oldest payment or, if the query is reversed, with
+// the most recent one. Unlike QueryPayments, the payments aren't
+// collected into a single response. Instead, they are read in pages of
+// MaxPayments payments (or defaultPaymentsPageSize if unset), each within its
+// own read transaction, so no transaction is held open while the callback
+// runs. The callback can return ErrStopIteration to end the iteration early.
+//
+// NOTE: The IndexOffset of the query is the starting point of the iteration
+// and the CountTotal field is ignored.
+func (d *DB) ForEachPayment(ctx context.Context, query PaymentsQuery,
+	cb func(*MPPayment) error) error {
 
-		// Run a paginated query, adding payments to our response.
-		if err := paginator.query(accumulatePayments); err != nil {
+	if query.MaxPayments == 0 {
+		query.MaxPayments = defaultPaymentsPageSize
+	}
+	query.CountTotal = false
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		resp, err := d.QueryPayments(ctx, query)
+		if err != nil {
 			return err
 		}
 
-		// Counting the total number of payments is expensive, since we
-		// literally have to traverse the cursor linearly, which can
-		// take quite a while. So it's an optional query parameter.
-		if query.CountTotal {
-			var (
-				totalPayments uint64
-				err           error
-			)
-			countFn := func(_, _ []byte) error {
-				totalPayments++
+		// The payments of a page are always sorted by ascending
+		// sequence number, so we walk reversed pages backwards.
+		for i := range resp.Payments {
+			payment := resp.Payments[i]
+			if query.Reversed {
+				payment = resp.Payments[len(resp.Payments)-1-i]
+			}
 
+			err := cb(payment)
+			switch {
+			case errors.Is(err, ErrStopIteration):
 				return nil
-			}
 
-			// In non-boltdb database backends, there's a faster
-			// ForAll query that allows for batch fetching items.
-			if fastBucket, ok := indexes.(kvdb.ExtendedRBucket); ok {
-				err = fastBucket.ForAll(countFn)
-			} else {
-				err = indexes.ForEach(countFn)
-			}
-			if err != nil {
-				return fmt.Errorf("error counting payments: %w",
-					err)
+			case err != nil:
+				return err
 			}
-
-			resp.TotalCount = totalPayments
 		}
 
-		return nil
-	}, func() {
-		resp = PaymentsResponse{}
-	}); err != nil {
-		return resp, err
-	}
+		// Without another matching payment after this page, we've
+		// reached the end of the payments index. This saves querying
+		// an empty page if the last page is full.
+		if !resp.HasMore {
+			return nil
+		}
 
-	// Need to swap the payments slice order if reversed order.
-	if query.Reversed {
-		for l, r := 0, len(resp.Payments)-1; l < r; l, r = l+1, r-1 {
-			resp.Payments[l], resp.Payments[r] =
-				resp.Payments[r], resp.Payments[l]
+		// Continue after the last payment of this page.
+		query.IndexOffset = resp.LastIndexOffset
+		if query.Reversed {
+			query.IndexOffset = resp.FirstIndexOffset
 		}
 	}
+}
 
-	// Set the first and last index of the returned payments so that the
-	// caller can resume from this point later on.
-	if len(resp.Payments) > 0 {
-		resp.FirstIndexOffset = resp.Payments[0].SequenceNum
-		resp.LastIndexOffset =
-			resp.Payments[len(resp.Payments)-1].SequenceNum
+// paymentTraversesNode returns true if any of the htlc attempts of the given
+// payment was routed through the node with the given public key.
+func paymentTraversesNode(payment *MPPayment, pubKey route.Vertex) bool {
+	for _, htlc := range payment.HTLCs {
+		for _, hop := range htlc.Route.Hops {
+			if hop.PubKeyBytes == pubKey {
+				return true
+			}
+		}
 	}
 
-	return resp, nil
+	return false
 }
 
-// fetchPaymentWithSequenceNumber get the payment which matches the payment hash
-// *and* sequence number provided from the database. This is required because
-// we previously had more than one payment per hash, so we have multiple indexes
-// pointing to a single payment; we want to retrieve the correct one.
-func fetchPaymentWithSequenceNumber(tx kvdb.RTx, paymentHash lntypes.Hash,
-	sequenceNumber []byte) (*MPPayment, error) {
+// paymentFilter is an additional predicate a payment has to satisfy to be
+// returned by a payments query.
+type paymentFilter func(payment *MPPayment) bool
 
-	// We can now lookup the payment keyed by its hash in
-	// the payments root bucket.
-	bucket, err := fetchPaymentBucket(tx, paymentHash)
-	if err != nil {
-		return nil, err
+// withLabelFilter extends the given filter to only match payments whose label
+// contains the given string.
+func withLabelFilter(filter paymentFilter, label string) paymentFilter {
+	return func(payment *MPPayment) bool {
+		if !strings.Contains(payment.Info.Label, label) {
+			return false
+		}
+
+		return filter == nil || filter(payment)
 	}
+}
 
-	// A single payment hash can have multiple payments associated with it.
-	// We lookup our sequence number first, to determine whether this is
-	// the payment we are actually looking for.
-	seqBytes := bucket.Get(paymentSequenceKey)
-	if seqBytes == nil {
-		return nil, ErrNoSequenceNumber
+// withPaymentAddrFilter extends the given filter to only match payments sent
+// to the given payment address.
+func withPaymentAddrFilter(filter paymentFilter,
+	addr [32]byte) paymentFilter {
+
+	return func(payment *MPPayment) bool {
+		paymentAddr := payment.Info.PaymentAddr
+		if paymentAddr == nil || *paymentAddr != addr {
+			return false
+		}
+
+		return filter == nil || filter(payment)
+	}
+}
+
+// withBlindedFilter extends the given filter to only match payments that
+// targeted a blinded path.
+func withBlindedFilter(filter paymentFilter) paymentFilter {
+	return func(payment *MPPayment) bool {
+		if !payment.IsBlinded() {
+			return false
+		}
+
+		return filter == nil || filter(payment)
+	}
+}
+
+// newScanCancelCheck returns a function that is to be called for every payment
+// visited by a scan of the payments. It returns an error wrapping the error of
+// the given context once the context is canceled. To keep the overhead low, the
+// context is only checked every paymentScanCheckInterval calls.
+func newScanCancelCheck(ctx context.Context) func() error {
+	var numScanned int
+
+	return func() error {
+		numScanned++
+		if numScanned%paymentScanCheckInterval != 0 {
+			return nil
+		}
+
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("payment scan aborted after %d "+
+				"payments: %w", numScanned, err)
+		}
+
+		return nil
+	}
+}
+
+// adjustPaymentsCount adds delta to the stored number of payments index
+// entries. Nothing is done if the count hasn't been stored yet.
+func adjustPaymentsCount(tx kvdb.RwTx, delta int64) error {
+	bucket := tx.ReadWriteBucket(paymentsCountBucket)
+	if bucket == nil {
+		return nil
+	}
+
+	countBytes := bucket.Get(paymentsCountKey)
+	if countBytes == nil {
+		return nil
+	}
+
+	count := int64(byteOrder.Uint64(countBytes)) + delta
+	if count < 0 {
+		return fmt.Errorf("payments count dropped below zero")
+	}
+
+	var b [8]byte
+	byteOrder.PutUint64(b[:], uint64(count))
+
+	return bucket.Put(paymentsCountKey, b[:])
+}
+
+// fetchPaymentsCount returns the stored number of payments index entries. The
+// second return value is false if the count hasn't been stored yet.
+func fetchPaymentsCount(tx kvdb.RTx) (uint64, bool) {
+	bucket := tx.ReadBucket(paymentsCountBucket)
+	if bucket == nil {
+		return 0, false
+	}
+
+	countBytes := bucket.Get(paymentsCountKey)
+	if countBytes == nil {
+		return 0, false
+	}
+
+	return byteOrder.Uint64(countBytes), true
+}
+
+// buildPaymentsCount counts the entries of the payments index and stores the
+// count if it hasn't been stored yet. This is done once, as the count is kept
+// up to date with the index from then on.
+func (d *DB) buildPaymentsCount(ctx context.Context) error {
+	var stored bool
+	err := kvdb.View(d, func(tx kvdb.RTx) error {
+		_, stored = fetchPaymentsCount(tx)
+
+		return nil
+	}, func() {
+		stored = false
+	})
+	if err != nil || stored {
+		return err
+	}
+
+	return kvdb.Update(d, func(tx kvdb.RwTx) error {
+		// Another caller might have stored the count in the meantime.
+		if _, ok := fetchPaymentsCount(tx); ok {
+			return nil
+		}
+
+		var count uint64
+		indexes := tx.ReadBucket(paymentsIndexBucket)
+		if indexes != nil {
+			checkCancel := newScanCancelCheck(ctx)
+			err := indexes.ForEach(func(_, _ []byte) error {
+				count++
+
+				return checkCancel()
+			})
+			if err != nil {
+				return fmt.Errorf("error counting payments: %w",
+					err)
+			}
+		}
+
+		bucket, err := tx.CreateTopLevelBucket(paymentsCountBucket)
+		if err != nil {
+			return err
+		}
+
+		var b [8]byte
+		byteOrder.PutUint64(b[:], count)
+
+		return bucket.Put(paymentsCountKey, b[:])
+	}, func() {})
+}
+
+// queryPayments executes the given payments query. If a filter is provided,
+// only the payments matching it are returned and counted.
+func (d *DB) queryPayments(ctx context.Context, query PaymentsQuery,
+	filter paymentFilter) (PaymentsResponse, error) {
+
+	var resp PaymentsResponse
+	err := d.withQueryTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = d.runPaymentsQuery(ctx, query, filter)
+
+		return err
+	})
+
+	return resp, err
+}
+
+// withQueryTimeout runs the given payments query, bounding it by the default
+// query timeout if one is configured and the context has no deadline yet. If
+// the query exceeds the default timeout, ErrQueryTimeout is returned.
+func (d *DB) withQueryTimeout(ctx context.Context,
+	query func(context.Context) error) error {
+
+	// The caller's deadline takes precedence over the default timeout.
+	if _, ok := ctx.Deadline(); ok || d.paymentsQueryTimeout <= 0 {
+		return query(ctx)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d.paymentsQueryTimeout)
+	defer cancel()
+
+	// Since the context had no deadline before, an exceeded deadline can
+	// only stem from the default timeout.
+	err := query(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w after %v: %w", ErrQueryTimeout,
+			d.paymentsQueryTimeout, err)
+	}
+
+	return err
+}
+
+// runPaymentsQuery runs the payments query of queryPayments, without applying
+// the default query timeout.
+func (d *DB) runPaymentsQuery(ctx context.Context, query PaymentsQuery,
+	filter paymentFilter) (PaymentsResponse, error) {
+
+	var resp PaymentsResponse
+	if err := query.validateCreationDateRange(); err != nil {
+		return resp, err
+	}
+
+	// Never read more payments than the configured limit into memory.
+	maxQueryPayments := d.maxQueryPayments
+	if maxQueryPayments == 0 {
+		maxQueryPayments = DefaultMaxQueryPayments
+	}
+	if query.MaxPayments > maxQueryPayments {
+		log.Debugf("Clamping payments query from %d to %d payments",
+			query.MaxPayments, maxQueryPayments)
+
+		query.MaxPayments = maxQueryPayments
+	}
+
+	// Restrict the query to payments with a matching label, if requested.
+	if query.LabelContains != "" {
+		filter = withLabelFilter(filter, query.LabelContains)
+	}
+
+	// Restrict the query to payments sent to the payment address, if
+	// requested.
+	if query.PaymentAddr != nil {
+		filter = withPaymentAddrFilter(filter, *query.PaymentAddr)
+	}
+
+	// Restrict the query to blinded payments, if requested.
+	if query.BlindedOnly {
+		filter = withBlindedFilter(filter)
+	}
+
+	// Without a date filter or additional filter, every payment in the
+	// index is counted, so the stored payments count can be used. Make
+	// sure it's available.
+	countAll := query.CountTotal && !query.hasCreationDateFilter() &&
+		filter == nil
+	if countAll {
+		if err := d.buildPaymentsCount(ctx); err != nil {
+			return resp, err
+		}
+	}
+
+	if err := kvdb.View(d, func(tx kvdb.RTx) error {
+		// Get the root payments bucket.
+		paymentsBucket := tx.ReadBucket(paymentsRootBucket)
+		if paymentsBucket == nil {
+			return nil
+		}
+
+		// Get the index bucket which maps sequence number -> payment
+		// hash and duplicate bool. If we have a payments bucket, we
+		// should have an indexes bucket as well.
+		indexes := tx.ReadBucket(paymentsIndexBucket)
+		if indexes == nil {
+			return fmt.Errorf("index bucket does not exist")
+		}
+
+		// accumulatePayments gets payments with the sequence number
+		// and hash provided and adds them to our list of payments if
+		// they meet the criteria of our query. It returns the number
+		// of payments that were added.
+		checkCancel := newScanCancelCheck(ctx)
+		accumulatePayments := func(sequenceKey, hash []byte) (bool,
+			error) {
+
+			if err := checkCancel(); err != nil {
+				return false, err
+			}
+
+			r := bytes.NewReader(hash)
+			paymentHash, err := deserializePaymentIndex(r)
+			if err != nil {
+				return false, err
+			}
+
+			// Skip payments that don't match the status or the
+			// creation date range of the query before we decode
+			// their attempts.
+			ok, err := prefilterPayment(
+				tx, paymentHash, sequenceKey, &query,
+			)
+			if err != nil || !ok {
+				return false, err
+			}
+
+			payment, err := fetchPaymentWithSequenceNumber(
+				tx, paymentHash, sequenceKey, query.SkipHops,
+			)
+			if err != nil {
+				return false, err
+			}
+
+			if err := d.checkQuarantine(payment); err != nil {
+				return false, err
+			}
+
+			// To keep compatibility with the old API, we only
+			// return non-succeeded payments if requested.
+			if payment.Status != StatusSucceeded &&
+				!query.IncludeIncomplete {
+
+				return false, err
+			}
+
+			// Skip any payments that were created outside of the
+			// specified time range.
+			if !query.inCreationDateRange(payment) {
+				return false, nil
+			}
+
+			// Skip any payments that don't match the filter.
+			if filter != nil && !filter(payment) {
+				return false, nil
+			}
+
+			// The state of the payment has been computed, so we can
+			// drop its htlcs if they aren't needed.
+			if query.ExcludeHTLCs {
+				payment.HTLCs = nil
+			}
+
+			// At this point, we've exhausted the offset, so we'll
+			// begin collecting invoices found within the range.
+			resp.Payments = append(resp.Payments, payment)
+			return true, nil
+		}
+
+		// A count-only query doesn't return any payments, so we don't
+		// need to read any of them.
+		countOnly := query.CountTotal && query.MaxPayments == 0
+		if !countOnly {
+			// We request one more payment than the page holds,
+			// so we know whether another page exists.
+			maxPayments := query.MaxPayments
+			if maxPayments < math.MaxUint64 {
+				maxPayments++
+			}
+
+			// Create a paginator which reads from our sequence
+			// index bucket with the parameters provided by the
+			// payments query.
+			paginator := newPaginator(
+				indexes.ReadCursor(), query.Reversed,
+				query.IndexOffset, maxPayments,
+			)
+
+			// Run a paginated query, adding payments to our
+			// response.
+			err := paginator.query(accumulatePayments)
+			if err != nil {
+				return err
+			}
+
+			// The extra payment is always the last one that was
+			// added, so we drop it from the page.
+			numPayments := uint64(len(resp.Payments))
+			if numPayments > query.MaxPayments {
+				resp.Payments = resp.Payments[:numPayments-1]
+				resp.HasMore = true
+			}
+		}
+
+		// If all payments are counted, the stored count is used.
+		if count, ok := fetchPaymentsCount(tx); countAll && ok {
+			resp.TotalCount = count
+
+			return nil
+		}
+
+		// Otherwise counting the total number of payments is
+		// expensive, since we literally have to traverse the cursor
+		// linearly, which can take quite a while. So it's an optional
+		// query parameter.
+		if query.CountTotal {
+			var (
+				totalPayments uint64
+				err           error
+			)
+			countFn := func(sequenceKey, hash []byte) error {
+				if err := checkCancel(); err != nil {
+					return err
+				}
+
+				// Without a date filter or additional filter,
+				// every payment in the index is counted.
+				if !query.hasCreationDateFilter() &&
+					filter == nil {
+
+					totalPayments++
+
+					return nil
+				}
+
+				// Otherwise we need to look up the payment to
+				// find out whether it matches the query. The
+				// hops are only needed by the filter.
+				r := bytes.NewReader(hash)
+				paymentHash, err := deserializePaymentIndex(r)
+				if err != nil {
+					return err
+				}
+
+				payment, err := fetchPaymentWithSequenceNumber(
+					tx, paymentHash, sequenceKey,
+					filter == nil,
+				)
+				if err != nil {
+					return err
+				}
+
+				if !query.inCreationDateRange(payment) {
+					return nil
+				}
+
+				if filter != nil && !filter(payment) {
+					return nil
+				}
+
+				totalPayments++
+
+				return nil
+			}
+
+			// In non-boltdb database backends, there's a faster
+			// ForAll query that allows for batch fetching items.
+			if fastBucket, ok := indexes.(kvdb.ExtendedRBucket); ok {
+				err = fastBucket.ForAll(countFn)
+			} else {
+				err = indexes.ForEach(countFn)
+			}
+			if err != nil {
+				return fmt.Errorf("error counting payments: %w",
+					err)
+			}
+
+			resp.TotalCount = totalPayments
+		}
+
+		return nil
+	}, func() {
+		resp = PaymentsResponse{}
+	}); err != nil {
+		return resp, err
+	}
+
+	// Need to swap the payments slice order if reversed order.
+	if query.Reversed {
+		for l, r := 0, len(resp.Payments)-1; l < r; l, r = l+1, r-1 {
+			resp.Payments[l], resp.Payments[r] =
+				resp.Payments[r], resp.Payments[l]
+		}
+	}
+
+	// Set the first and last index of the returned payments so that the
+	// caller can resume from this point later on.
+	if len(resp.Payments) > 0 {
+		resp.FirstIndexOffset = resp.Payments[0].SequenceNum
+		resp.LastIndexOffset =
+			resp.Payments[len(resp.Payments)-1].SequenceNum
+	}
+
+	return resp, nil
+}
+
+// prefilterPayment returns false if the payment with the given hash and
+// sequence number can be skipped by the query based on its status and creation
+// time, which are read without decoding the payment's attempts. Duplicate
+// payments are never skipped here, they are checked once they're fetched.
+func prefilterPayment(tx kvdb.RTx, paymentHash lntypes.Hash,
+	sequenceNumber []byte, query *PaymentsQuery) (bool, error) {
+
+	if query.IncludeIncomplete && !query.hasCreationDateFilter() {
+		return true, nil
+	}
+
+	bucket, err := fetchPaymentBucket(tx, paymentHash)
+	if err != nil {
+		return false, err
+	}
+
+	if !bytes.Equal(bucket.Get(paymentSequenceKey), sequenceNumber) {
+		return true, nil
+	}
+
+	// To keep compatibility with the old API, we only return
+	// non-succeeded payments if requested.
+	if !query.IncludeIncomplete {
+		status, err := fetchPaymentStatus(bucket)
+		if err != nil {
+			return false, err
+		}
+
+		if status != StatusSucceeded {
+			return false, nil
+		}
+	}
+
+	if query.hasCreationDateFilter() {
+		info, err := fetchCreationInfo(bucket)
+		if err != nil {
+			return false, err
+		}
+
+		return query.inCreationDateRange(&MPPayment{Info: info}), nil
+	}
+
+	return true, nil
+}
+
+// fetchPaymentWithSequenceNumber get the payment which matches the payment hash
+// *and* sequence number provided from the database. This is required because
+// we previously had more than one payment per hash, so we have multiple indexes
+// pointing to a single payment; we want to retrieve the correct one. If
+// skipHops is set, only a summary of the routes of the payment's HTLC attempts
+// is read. Note that legacy duplicate payments are always fully hydrated.
+func fetchPaymentWithSequenceNumber(tx kvdb.RTx, paymentHash lntypes.Hash,
+	sequenceNumber []byte, skipHops bool) (*MPPayment, error) {
+
+	// We can now lookup the payment keyed by its hash in
+	// the payments root bucket.
+	bucket, err := fetchPaymentBucket(tx, paymentHash)
+	if err != nil {
+		return nil, err
+	}
+
+	// A single payment hash can have multiple payments associated with it.
+	// We lookup our sequence number first, to determine whether this is
+	// the payment we are actually looking for.
+	seqBytes := bucket.Get(paymentSequenceKey)
+	if seqBytes == nil {
+		return nil, ErrNoSequenceNumber
 	}
 
 	// If this top level payment has the sequence number we are looking for,
 	// return it.
 	if bytes.Equal(seqBytes, sequenceNumber) {
+		return fetchPaymentWithHops(bucket, skipHops)
+	}
+
+	// If we were not looking for the top level payment, we are looking for
+	// one of our duplicate payments. We need to iterate through the seq
+	// numbers in this bucket to find the correct payments. If we do not
+	// find a duplicate payments bucket here, something is wrong.
+	dup := bucket.NestedReadBucket(duplicatePaymentsBucket)
+	if dup == nil {
+		return nil, ErrNoDuplicateBucket
+	}
+
+	var duplicatePayment *MPPayment
+	err = dup.ForEach(func(k, v []byte) error {
+		subBucket := dup.NestedReadBucket(k)
+		if subBucket == nil {
+			// We one bucket for each duplicate to be found.
+			return ErrNoDuplicateNestedBucket
+		}
+
+		seqBytes := subBucket.Get(duplicatePaymentSequenceKey)
+		if seqBytes == nil {
+			return err
+		}
+
+		// If this duplicate payment is not the sequence number we are
+		// looking for, we can continue.
+		if !bytes.Equal(seqBytes, sequenceNumber) {
+			return nil
+		}
+
+		duplicatePayment, err = fetchDuplicatePayment(subBucket)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// If none of the duplicate payments matched our sequence number, we
+	// failed to find the payment with this sequence number; something is
+	// wrong.
+	if duplicatePayment == nil {
+		return nil, ErrDuplicateNotFound
+	}
+
+	return duplicatePayment, nil
+}
+
+// DeletedPayment describes what was removed by deleting a payment, or only
+// its failed HTLC attempts.
+type DeletedPayment struct {
+	// Status is the status of the payment before the deletion.
+	Status PaymentStatus
+
+	// NumAttempts is the number of HTLC attempts that were removed.
+	NumAttempts int
+}
+
+// DeletePayment deletes a payment from the DB given its payment hash. If
+// failedHtlcsOnly is set, only failed HTLC attempts of the payment will be
+// deleted. If archiving of deleted payments is enabled, a deleted payment is
+// moved to the payments archive. The status of the payment before the
+// deletion and the number of removed HTLC attempts are returned.
+func (d *DB) DeletePayment(paymentHash lntypes.Hash,
+	failedHtlcsOnly bool) (*DeletedPayment, error) {
+
+	var deleted *DeletedPayment
+	err := kvdb.Update(d, func(tx kvdb.RwTx) error {
+		payments := tx.ReadWriteBucket(paymentsRootBucket)
+		if payments == nil {
+			return ErrPaymentNotInitiated
+		}
+
+		var err error
+		deleted, err = d.deletePayment(
+			tx, payments, paymentHash, failedHtlcsOnly,
+		)
+
+		return err
+	}, func() {
+		deleted = nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	d.paymentCache.invalidate(paymentHash)
+
+	return deleted, nil
+}
+
+// FetchPaymentBySeqNum returns the payment with the given sequence number, as
+// found in the index offsets of a payments query. Unlike a lookup by payment
+// hash, this always resolves to exactly one payment record, which may be a
+// legacy duplicate payment. ErrPaymentNotInitiated is returned if the sequence
+// number isn't known or the payment it points to has been deleted.
+func (d *DB) FetchPaymentBySeqNum(ctx context.Context,
+	seqNum uint64) (*MPPayment, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var payment *MPPayment
+	err := kvdb.View(d, func(tx kvdb.RTx) error {
+		notFound := fmt.Errorf("%w: no payment with sequence number %d",
+			ErrPaymentNotInitiated, seqNum)
+
+		indexes := tx.ReadBucket(paymentsIndexBucket)
+		if indexes == nil {
+			return notFound
+		}
+
+		var seqBytes [8]byte
+		byteOrder.PutUint64(seqBytes[:], seqNum)
+
+		indexValue := indexes.Get(seqBytes[:])
+		if indexValue == nil {
+			return notFound
+		}
+
+		paymentHash, err := deserializePaymentIndex(
+			bytes.NewReader(indexValue),
+		)
+		if err != nil {
+			return err
+		}
+
+		// The index entry might outlive the payment it points to, in
+		// which case we report the payment as not found.
+		payments := tx.ReadBucket(paymentsRootBucket)
+		if payments == nil ||
+			payments.NestedReadBucket(paymentHash[:]) == nil {
+
+			return notFound
+		}
+
+		payment, err = fetchPaymentWithSequenceNumber(
+			tx, paymentHash, seqBytes[:], false,
+		)
+
+		return err
+	}, func() {
+		payment = nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return payment, nil
+}
+
+// LookupPaymentByReference returns the payment carrying the given client
+// reference. ErrPaymentNotInitiated is returned if no payment carries it.
+func (d *DB) LookupPaymentByReference(ctx context.Context,
+	ref []byte) (*MPPayment, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var payment *MPPayment
+	err := kvdb.View(d, func(tx kvdb.RTx) error {
+		paymentHash, ok := lookupReferenceIndex(tx, ref)
+		if !ok {
+			return fmt.Errorf("%w: no payment with client "+
+				"reference %x", ErrPaymentNotInitiated, ref)
+		}
+
+		bucket, err := fetchPaymentBucket(tx, paymentHash)
+		if err != nil {
+			return err
+		}
+
+		payment, err = fetchPayment(bucket)
+
+		return err
+	}, func() {
+		payment = nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return payment, nil
+}
+
+// FetchPaymentByPreimage returns the payment that was settled with the given
+// preimage. The payment hashed from the preimage is looked up first, which
+// covers all payments but AMP ones. Since the shards of an AMP payment settle
+// with preimages of their own, all other payments are then scanned for a
+// settled attempt carrying the preimage. ErrPaymentNotInitiated is returned if
+// no payment was settled with the preimage.
+func (d *DB) FetchPaymentByPreimage(ctx context.Context,
+	preimage lntypes.Preimage) (*MPPayment, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var payment *MPPayment
+	err := d.withQueryTimeout(ctx, func(ctx context.Context) error {
+		return kvdb.View(d, func(tx kvdb.RTx) error {
+			var err error
+			payment, err = fetchPaymentByPreimage(ctx, tx, preimage)
+
+			return err
+		}, func() {
+			payment = nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return payment, nil
+}
+
+// errPreimageFound is used to stop the scan for a payment settled with a given
+// preimage once the payment is found.
+var errPreimageFound = errors.New("preimage found")
+
+// fetchPaymentByPreimage returns the payment that was settled with the given
+// preimage, see FetchPaymentByPreimage.
+func fetchPaymentByPreimage(ctx context.Context, tx kvdb.RTx,
+	preimage lntypes.Preimage) (*MPPayment, error) {
+
+	notFound := fmt.Errorf("%w: no payment settled with preimage of "+
+		"hash %v", ErrPaymentNotInitiated, preimage.Hash())
+
+	payments := tx.ReadBucket(paymentsRootBucket)
+	if payments == nil {
+		return nil, notFound
+	}
+
+	// A payment that isn't AMP is stored under the hash of its preimage.
+	hash := preimage.Hash()
+	bucket := payments.NestedReadBucket(hash[:])
+	if bucket != nil && hasSettlePreimage(bucket, preimage) {
+		return fetchPayment(bucket)
+	}
+
+	// Otherwise the preimage can only belong to a shard of an AMP payment,
+	// so we look for it in the settled attempts of all payments.
+	checkCancel := newScanCancelCheck(ctx)
+	err := payments.ForEach(func(k, _ []byte) error {
+		if err := checkCancel(); err != nil {
+			return err
+		}
+
+		bucket = payments.NestedReadBucket(k)
+		if bucket == nil || bytes.Equal(k, hash[:]) {
+			return nil
+		}
+
+		if hasSettlePreimage(bucket, preimage) {
+			return errPreimageFound
+		}
+
+		return nil
+	})
+	switch {
+	case errors.Is(err, errPreimageFound):
 		return fetchPayment(bucket)
+
+	case err != nil:
+		return nil, err
+	}
+
+	return nil, notFound
+}
+
+// hasSettlePreimage returns true if an htlc attempt of the given payment
+// bucket was settled with the given preimage. Settle info that can't be
+// parsed is skipped.
+func hasSettlePreimage(bucket kvdb.RBucket, preimage lntypes.Preimage) bool {
+	htlcsBucket := bucket.NestedReadBucket(paymentHtlcsBucket)
+	if htlcsBucket == nil {
+		return false
+	}
+
+	// The settle info keys share a prefix, so seeking to the prefix only
+	// visits the settled attempts.
+	c := htlcsBucket.ReadCursor()
+	k, v := c.Seek(htlcSettleInfoKey)
+	for ; bytes.HasPrefix(k, htlcSettleInfoKey); k, v = c.Next() {
+		settle, err := readHtlcSettleInfo(v)
+		if err != nil {
+			continue
+		}
+
+		if settle.Preimage == preimage {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FetchFailedAttempts returns the failed htlc attempts of the payment with the
+// given hash, ordered by attempt ID. Settled and in-flight attempts aren't
+// read at all, and only a summary of each failed attempt's route is read, see
+// deserializeRouteSummary. This makes it a lighter read than FetchPayment when
+// only the failures of a payment are of interest.
+func (d *DB) FetchFailedAttempts(ctx context.Context,
+	paymentHash lntypes.Hash) ([]HTLCAttempt, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var attempts []HTLCAttempt
+	err := kvdb.View(d, func(tx kvdb.RTx) error {
+		bucket, err := fetchPaymentBucket(tx, paymentHash)
+		if err != nil {
+			return err
+		}
+
+		htlcsBucket := bucket.NestedReadBucket(paymentHtlcsBucket)
+		if htlcsBucket == nil {
+			return nil
+		}
+
+		attempts, err = fetchFailedHtlcAttempts(htlcsBucket)
+
+		return err
+	}, func() {
+		attempts = nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return attempts, nil
+}
+
+// DeletePaymentBySeqNum deletes the payment with the given sequence number, as
+// found in the index offsets of a payments query, following the same rules as
+// DeletePayment. Since duplicate payments are stored with the payment they
+// duplicate, the sequence number of a duplicate resolves to that payment.
+// errNoSequenceNrIndex is returned if the sequence number isn't known.
+func (d *DB) DeletePaymentBySeqNum(ctx context.Context, seqNum uint64,
+	failedHtlcsOnly bool) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var paymentHash lntypes.Hash
+	err := kvdb.Update(d, func(tx kvdb.RwTx) error {
+		indexes := tx.ReadBucket(paymentsIndexBucket)
+		if indexes == nil {
+			return errNoSequenceNrIndex
+		}
+
+		var seqBytes [8]byte
+		byteOrder.PutUint64(seqBytes[:], seqNum)
+
+		indexValue := indexes.Get(seqBytes[:])
+		if indexValue == nil {
+			return errNoSequenceNrIndex
+		}
+
+		var err error
+		paymentHash, err = deserializePaymentIndex(
+			bytes.NewReader(indexValue),
+		)
+		if err != nil {
+			return err
+		}
+
+		// The index entry might outlive the payment it points to, in
+		// which case there's nothing to delete.
+		payments := tx.ReadWriteBucket(paymentsRootBucket)
+		if payments == nil ||
+			payments.NestedReadBucket(paymentHash[:]) == nil {
+
+			return fmt.Errorf("%w: sequence number %d points to "+
+				"payment %v", ErrPaymentNotInitiated, seqNum,
+				paymentHash)
+		}
+
+		_, err = d.deletePayment(
+			tx, payments, paymentHash, failedHtlcsOnly,
+		)
+
+		return err
+	}, func() {
+		paymentHash = lntypes.Hash{}
+	})
+	if err != nil {
+		return err
+	}
+
+	d.paymentCache.invalidate(paymentHash)
+
+	return nil
+}
+
+// deletePayment deletes the payment with the given hash, or only its failed
+// htlcs if failedHtlcsOnly is set, unless the payment isn't removable. The
+// status of the payment before the deletion and the number of removed htlc
+// attempts are returned.
+func (d *DB) deletePayment(tx kvdb.RwTx, payments kvdb.RwBucket,
+	paymentHash lntypes.Hash, failedHtlcsOnly bool) (*DeletedPayment,
+	error) {
+
+	bucket := payments.NestedReadWriteBucket(paymentHash[:])
+	if bucket == nil {
+		return nil, fmt.Errorf("non bucket element in payments bucket")
+	}
+
+	// If the payment has inflight HTLCs, we cannot safely delete the
+	// payment information, so we return an error.
+	paymentStatus, removable, err := isRemovable(bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !removable {
+		return nil, fmt.Errorf("payment '%v' has inflight HTLCs"+
+			"and therefore cannot be deleted: %w",
+			paymentHash.String(), paymentStatus.removable())
+	}
+
+	// Delete the failed HTLC attempts we found. Failed attempts don't
+	// count towards the stored state of the payment, so it stays valid.
+	if failedHtlcsOnly {
+		toDelete, err := fetchFailedHtlcKeys(bucket)
+		if err != nil {
+			return nil, err
+		}
+
+		err = deleteHtlcAttempts(bucket, toDelete)
+		if err != nil {
+			return nil, err
+		}
+
+		return &DeletedPayment{
+			Status:      paymentStatus,
+			NumAttempts: len(toDelete),
+		}, nil
 	}
 
-	// If we were not looking for the top level payment, we are looking for
-	// one of our duplicate payments. We need to iterate through the seq
-	// numbers in this bucket to find the correct payments. If we do not
-	// find a duplicate payments bucket here, something is wrong.
-	dup := bucket.NestedReadBucket(duplicatePaymentsBucket)
-	if dup == nil {
-		return nil, ErrNoDuplicateBucket
+	numAttempts, err := countHtlcAttempts(bucket)
+	if err != nil {
+		return nil, err
 	}
 
-	var duplicatePayment *MPPayment
-	err = dup.ForEach(func(k, v []byte) error {
-		subBucket := dup.NestedReadBucket(k)
-		if subBucket == nil {
-			// We one bucket for each duplicate to be found.
-			return ErrNoDuplicateNestedBucket
+	err = d.deletePaymentBucket(tx, payments, paymentHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeletedPayment{
+		Status:      paymentStatus,
+		NumAttempts: numAttempts,
+	}, nil
+}
+
+// countHtlcAttempts returns the number of htlc attempts of the payment in the
+// given bucket, without decoding them.
+func countHtlcAttempts(bucket kvdb.RBucket) (int, error) {
+	htlcsBucket := bucket.NestedReadBucket(paymentHtlcsBucket)
+	if htlcsBucket == nil {
+		return 0, nil
+	}
+
+	var numAttempts int
+	err := htlcsBucket.ForEach(func(k, _ []byte) error {
+		if bytes.HasPrefix(k, htlcAttemptInfoKey) {
+			numAttempts++
 		}
 
-		seqBytes := subBucket.Get(duplicatePaymentSequenceKey)
-		if seqBytes == nil {
-			return err
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return numAttempts, nil
+}
+
+// isRemovable returns the status of the payment in the given bucket and
+// whether the payment can be removed. Only the keys of the payment's htlc
+// attempts are inspected, so the attempts aren't decoded.
+func isRemovable(bucket kvdb.RBucket) (PaymentStatus, bool, error) {
+	status, err := fetchPaymentStatus(bucket)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return status, status.removable() == nil, nil
+}
+
+// DeletePayments deletes all completed and failed payments from the DB and
+// returns the number of payments that were altered along with the number of
+// HTLC attempts that were removed. If failedOnly is set, only
+// failed payments will be considered for deletion. If failedHtlsOnly is set,
+// the payment itself won't be deleted, only failed HTLC attempts. If archiving
+// of deleted payments is enabled, the deleted payments are moved to the
+// payments archive.
+//
+// The payments to delete are collected in a read transaction first and then
+// deleted in separate write transactions of at most the configured batch size
+// each. Every batch is committed on its own, so an interrupted call leaves the
+// payments of the committed batches deleted and all others untouched, and can
+// simply be repeated. If a batch fails, the numbers of payments altered and
+// attempts removed by the batches committed before it are returned along with
+// the error.
+func (d *DB) DeletePayments(failedOnly, failedHtlcsOnly bool) (int, int,
+	error) {
+
+	deleted, numAttempts, err := d.deletePayments(
+		failedOnly, failedHtlcsOnly,
+	)
+
+	return len(deleted), numAttempts, err
+}
+
+// deletePayments implements DeletePayments, returning the hashes of the
+// altered payments instead of their number.
+func (d *DB) deletePayments(failedOnly, failedHtlcsOnly bool) ([]lntypes.Hash,
+	int, error) {
+
+	// isCandidate returns true if the payment in the given bucket should
+	// be altered.
+	isCandidate := func(bucket kvdb.RBucket) (bool, error) {
+		// If the payment has inflight HTLCs, we cannot safely delete
+		// the payment information, so we skip it.
+		status, removable, err := isRemovable(bucket)
+		if err != nil || !removable {
+			return false, err
 		}
 
-		// If this duplicate payment is not the sequence number we are
-		// looking for, we can continue.
-		if !bytes.Equal(seqBytes, sequenceNumber) {
+		return !failedOnly || status == StatusFailed, nil
+	}
+
+	// The scan for candidates is bounded by the default query timeout, if
+	// configured.
+	var candidates []lntypes.Hash
+	scan := func(ctx context.Context) error {
+		checkCancel := newScanCancelCheck(ctx)
+
+		return kvdb.View(d, func(tx kvdb.RTx) error {
+			payments := tx.ReadBucket(paymentsRootBucket)
+			if payments == nil {
+				return nil
+			}
+
+			// Collect the payments first, so they can be
+			// prefetched in chunks.
+			var hashes []lntypes.Hash
+			err := payments.ForEach(func(k, _ []byte) error {
+				hash, err := lntypes.MakeHash(k)
+				if err != nil {
+					return err
+				}
+				hashes = append(hashes, hash)
+
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			return forEachPrefetched(tx, hashes, func(
+				hash lntypes.Hash) error {
+
+				if err := checkCancel(); err != nil {
+					return err
+				}
+
+				bucket := payments.NestedReadBucket(hash[:])
+				if bucket == nil {
+					// We only expect sub-buckets to be
+					// found in this top-level bucket.
+					return fmt.Errorf("non bucket " +
+						"element in payments bucket")
+				}
+
+				candidate, err := isCandidate(bucket)
+				if err != nil {
+					return err
+				}
+
+				if candidate {
+					candidates = append(candidates, hash)
+				}
+
+				return nil
+			})
+		}, func() {
+			candidates = nil
+		})
+	}
+	err := d.withQueryTimeout(context.Background(), scan)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	batchSize := d.paymentsDeleteBatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultPaymentsDeleteBatchSize
+	}
+
+	var (
+		altered     []lntypes.Hash
+		numAttempts int
+	)
+	for len(candidates) > 0 {
+		batch := candidates[:min(batchSize, len(candidates))]
+		candidates = candidates[len(batch):]
+
+		deleted, attempts, err := d.deletePaymentsBatch(
+			batch, isCandidate, failedHtlcsOnly,
+		)
+		if err != nil {
+			return altered, numAttempts, err
+		}
+
+		for _, hash := range deleted {
+			d.paymentCache.invalidate(hash)
+		}
+		altered = append(altered, deleted...)
+		numAttempts += attempts
+	}
+
+	return altered, numAttempts, nil
+}
+
+// deletePaymentsBatch deletes the given payments, or only their failed HTLC
+// attempts if failedHtlcsOnly is set, in a single write transaction and
+// returns the hashes of the payments that were altered along with the number
+// of removed attempts. As the payments might have changed since they were
+// collected, payments that are gone or no longer accepted by isCandidate are
+// skipped.
+func (d *DB) deletePaymentsBatch(batch []lntypes.Hash,
+	isCandidate func(kvdb.RBucket) (bool, error),
+	failedHtlcsOnly bool) ([]lntypes.Hash, int, error) {
+
+	var (
+		deleted     []lntypes.Hash
+		numAttempts int
+	)
+	err := kvdb.Update(d, func(tx kvdb.RwTx) error {
+		payments := tx.ReadWriteBucket(paymentsRootBucket)
+		if payments == nil {
 			return nil
 		}
 
-		duplicatePayment, err = fetchDuplicatePayment(subBucket)
-		if err != nil {
-			return err
+		return forEachPrefetched(tx, batch, func(
+			hash lntypes.Hash) error {
+
+			bucket := payments.NestedReadBucket(hash[:])
+			if bucket == nil {
+				return nil
+			}
+
+			candidate, err := isCandidate(bucket)
+			if err != nil || !candidate {
+				return err
+			}
+
+			result, err := d.deletePayment(
+				tx, payments, hash, failedHtlcsOnly,
+			)
+			if err != nil {
+				return err
+			}
+
+			deleted = append(deleted, hash)
+			numAttempts += result.NumAttempts
+
+			return nil
+		})
+	}, func() {
+		deleted = nil
+		numAttempts = 0
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return deleted, numAttempts, nil
+}
+
+// DeletePaymentsBefore deletes at most maxDeletes payments that were created
+// before the given cutoff time and returns the number of deleted payments.
+// Payments that are not in a removable status are always skipped. If
+// failedOnly is set, only failed payments are deleted.
+//
+// The candidates are looked up by walking the payments index page by page in
+// read transactions, so only the deletion itself holds the write lock. The
+// call can be repeated until it returns zero to purge all old payments.
+func (d *DB) DeletePaymentsBefore(ctx context.Context, cutoff time.Time,
+	failedOnly bool, maxDeletes int) (int, error) {
+
+	deleted, err := d.deletePaymentsBefore(
+		ctx, cutoff, failedOnly, maxDeletes,
+	)
+
+	return len(deleted), err
+}
+
+// deletePaymentsBefore implements DeletePaymentsBefore, returning the hashes
+// of the deleted payments instead of their number.
+func (d *DB) deletePaymentsBefore(ctx context.Context, cutoff time.Time,
+	failedOnly bool, maxDeletes int) ([]lntypes.Hash, error) {
+
+	if maxDeletes <= 0 {
+		return nil, fmt.Errorf("max deletes must be positive, got %d",
+			maxDeletes)
+	}
+
+	// isCandidate returns true if the payment can be deleted.
+	isCandidate := func(status PaymentStatus,
+		creationTime time.Time) bool {
+
+		if !creationTime.Before(cutoff) {
+			return false
+		}
+
+		if status.removable() != nil {
+			return false
+		}
+
+		return !failedOnly || status == StatusFailed
+	}
+
+	var (
+		candidates []lntypes.Hash
+		seen       = make(map[lntypes.Hash]struct{})
+	)
+	query := PaymentsQuery{
+		MaxPayments:       defaultPaymentsPageSize,
+		IncludeIncomplete: true,
+		SkipHops:          true,
+	}
+	err := d.ForEachPayment(ctx, query, func(payment *MPPayment) error {
+		hash := payment.Info.PaymentIdentifier
+
+		// Duplicate payments share the bucket of their payment, so we
+		// only consider each payment hash once.
+		if _, ok := seen[hash]; ok {
+			return nil
+		}
+		seen[hash] = struct{}{}
+
+		if !isCandidate(payment.Status, payment.Info.CreationTime) {
+			return nil
+		}
+
+		candidates = append(candidates, hash)
+		if len(candidates) >= maxDeletes {
+			return ErrStopIteration
 		}
 
 		return nil
@@ -715,273 +2422,424 @@ func fetchPaymentWithSequenceNumber(tx kvdb.RTx, paymentHash lntypes.Hash,
 		return nil, err
 	}
 
-	// If none of the duplicate payments matched our sequence number, we
-	// failed to find the payment with this sequence number; something is
-	// wrong.
-	if duplicatePayment == nil {
-		return nil, ErrDuplicateNotFound
+	if len(candidates) == 0 {
+		return nil, nil
 	}
 
-	return duplicatePayment, nil
-}
-
-// DeletePayment deletes a payment from the DB given its payment hash. If
-// failedHtlcsOnly is set, only failed HTLC attempts of the payment will be
-// deleted.
-func (d *DB) DeletePayment(paymentHash lntypes.Hash,
-	failedHtlcsOnly bool) error {
-
-	return kvdb.Update(d, func(tx kvdb.RwTx) error {
+	var deleted []lntypes.Hash
+	err = kvdb.Update(d, func(tx kvdb.RwTx) error {
 		payments := tx.ReadWriteBucket(paymentsRootBucket)
 		if payments == nil {
 			return nil
 		}
 
-		bucket := payments.NestedReadWriteBucket(paymentHash[:])
-		if bucket == nil {
-			return fmt.Errorf("non bucket element in payments " +
-				"bucket")
-		}
+		return forEachPrefetched(tx, candidates, func(
+			hash lntypes.Hash) error {
+
+			bucket := payments.NestedReadBucket(hash[:])
+			if bucket == nil {
+				return nil
+			}
+
+			// The payment might have changed since we looked it
+			// up, so we check again whether it can be deleted.
+			status, err := fetchPaymentStatus(bucket)
+			if err != nil {
+				return err
+			}
+
+			info, err := fetchCreationInfo(bucket)
+			if err != nil {
+				return err
+			}
+
+			if !isCandidate(status, info.CreationTime) {
+				return nil
+			}
+
+			err = d.deletePaymentBucket(tx, payments, hash)
+			if err != nil {
+				return err
+			}
+
+			deleted = append(deleted, hash)
+
+			return nil
+		})
+	}, func() {
+		deleted = nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hash := range deleted {
+		d.paymentCache.invalidate(hash)
+	}
+
+	return deleted, nil
+}
+
+// deletePaymentBucket deletes the bucket of the payment with the given hash
+// and all the indexes pointing to it. If archiving of deleted payments is
+// enabled, the payment is copied to the archive first.
+func (d *DB) deletePaymentBucket(tx kvdb.RwTx, payments kvdb.RwBucket,
+	paymentHash lntypes.Hash) error {
+
+	bucket := payments.NestedReadBucket(paymentHash[:])
+	if bucket == nil {
+		return ErrPaymentNotInitiated
+	}
+
+	seqNrs, err := fetchSequenceNumbers(bucket)
+	if err != nil {
+		return err
+	}
+
+	// Release the payment's client reference, if any, so it can be used
+	// by another payment.
+	if ref := bucket.Get(paymentClientReferenceKey); ref != nil {
+		err := deleteReferenceIndexEntry(
+			tx, copyBytes(ref), paymentHash,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Keep a copy of the payment in the archive if configured.
+	if d.archiveDeletedPayments {
+		err := archivePayment(tx, bucket, d.clock.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := payments.DeleteNestedBucket(paymentHash[:]); err != nil {
+		return err
+	}
+
+	if err := updateInFlightIndex(tx, paymentHash, false); err != nil {
+		return err
+	}
+
+	for _, k := range seqNrs {
+		if err := deletePaymentIndexEntry(tx, k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fetchSequenceNumbers fetches all the sequence numbers associated with a
+// payment, including those belonging to any duplicate payments.
+func fetchSequenceNumbers(paymentBucket kvdb.RBucket) ([][]byte, error) {
+	seqNum := paymentBucket.Get(paymentSequenceKey)
+	if seqNum == nil {
+		return nil, errors.New("expected sequence number")
+	}
+
+	sequenceNumbers := [][]byte{seqNum}
+
+	// Get the duplicate payments bucket, if it has no duplicates, just
+	// return early with the payment sequence number.
+	duplicates := paymentBucket.NestedReadBucket(duplicatePaymentsBucket)
+	if duplicates == nil {
+		return sequenceNumbers, nil
+	}
+
+	// If we do have duplicated, they are keyed by sequence number, so we
+	// iterate through the duplicates bucket and add them to our set of
+	// sequence numbers.
+	if err := duplicates.ForEach(func(k, v []byte) error {
+		sequenceNumbers = append(sequenceNumbers, k)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return sequenceNumbers, nil
+}
+
+// FetchAttemptRoute returns the serialized route of the given htlc attempt of
+// the payment, as it was registered. The routes are only stored if enabled by
+// the OptionStoreAttemptRoutes option, otherwise ErrAttemptRouteNotStored is
+// returned. The route can be decoded with DeserializeRoute.
+func (d *DB) FetchAttemptRoute(ctx context.Context, paymentHash lntypes.Hash,
+	attemptID uint64) ([]byte, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var aid [8]byte
+	binary.BigEndian.PutUint64(aid[:], attemptID)
 
-		// If the status is InFlight, we cannot safely delete
-		// the payment information, so we return early.
-		paymentStatus, err := fetchPaymentStatus(bucket)
+	var routeBytes []byte
+	err := kvdb.View(d, func(tx kvdb.RTx) error {
+		bucket, err := fetchPaymentBucket(tx, paymentHash)
 		if err != nil {
 			return err
 		}
 
-		// If the payment has inflight HTLCs, we cannot safely delete
-		// the payment information, so we return an error.
-		if err := paymentStatus.removable(); err != nil {
-			return fmt.Errorf("payment '%v' has inflight HTLCs"+
-				"and therefore cannot be deleted: %w",
-				paymentHash.String(), err)
+		routes := bucket.NestedReadBucket(paymentAttemptRoutesBucket)
+		if routes == nil {
+			return fmt.Errorf("%w: attempt %d of payment %v",
+				ErrAttemptRouteNotStored, attemptID,
+				paymentHash)
 		}
 
-		// Delete the failed HTLC attempts we found.
-		if failedHtlcsOnly {
-			toDelete, err := fetchFailedHtlcKeys(bucket)
-			if err != nil {
-				return err
-			}
+		v := routes.Get(aid[:])
+		if v == nil {
+			return fmt.Errorf("%w: attempt %d of payment %v",
+				ErrAttemptRouteNotStored, attemptID,
+				paymentHash)
+		}
+		routeBytes = copyBytes(v)
 
-			htlcsBucket := bucket.NestedReadWriteBucket(
-				paymentHtlcsBucket,
-			)
+		return nil
+	}, func() {
+		routeBytes = nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-			for _, htlcID := range toDelete {
-				err = htlcsBucket.Delete(
-					htlcBucketKey(htlcAttemptInfoKey, htlcID),
-				)
-				if err != nil {
-					return err
-				}
+	return routeBytes, nil
+}
 
-				err = htlcsBucket.Delete(
-					htlcBucketKey(htlcFailInfoKey, htlcID),
-				)
-				if err != nil {
-					return err
-				}
+// deleteHtlcAttempts removes the htlc attempts with the given bucket keys from
+// the payment bucket, along with their resolutions and stored routes.
+func deleteHtlcAttempts(bucket kvdb.RwBucket, htlcIDs [][]byte) error {
+	htlcsBucket := bucket.NestedReadWriteBucket(paymentHtlcsBucket)
+	if htlcsBucket == nil {
+		return nil
+	}
 
-				err = htlcsBucket.Delete(
-					htlcBucketKey(htlcSettleInfoKey, htlcID),
-				)
-				if err != nil {
-					return err
-				}
+	for _, htlcID := range htlcIDs {
+		keys := [][]byte{
+			htlcBucketKey(htlcAttemptInfoKey, htlcID),
+			htlcBucketKey(htlcFailInfoKey, htlcID),
+			htlcBucketKey(htlcSettleInfoKey, htlcID),
+		}
+		for _, key := range keys {
+			if err := htlcsBucket.Delete(key); err != nil {
+				return err
 			}
-
-			return nil
 		}
+	}
 
-		seqNrs, err := fetchSequenceNumbers(bucket)
-		if err != nil {
-			return err
-		}
+	return deleteAttemptRoutes(bucket, htlcIDs)
+}
 
-		if err := payments.DeleteNestedBucket(paymentHash[:]); err != nil {
-			return err
-		}
+// deleteAttemptRoutes deletes the stored routes of the given htlc attempts of
+// the payment, if any.
+func deleteAttemptRoutes(bucket kvdb.RwBucket, htlcIDs [][]byte) error {
+	routes := bucket.NestedReadWriteBucket(paymentAttemptRoutesBucket)
+	if routes == nil {
+		return nil
+	}
 
-		indexBucket := tx.ReadWriteBucket(paymentsIndexBucket)
-		for _, k := range seqNrs {
-			if err := indexBucket.Delete(k); err != nil {
-				return err
-			}
+	for _, aid := range htlcIDs {
+		if err := routes.Delete(aid); err != nil {
+			return err
 		}
+	}
 
-		return nil
-	}, func() {})
+	return nil
 }
 
-// DeletePayments deletes all completed and failed payments from the DB. If
-// failedOnly is set, only failed payments will be considered for deletion. If
-// failedHtlsOnly is set, the payment itself won't be deleted, only failed HTLC
-// attempts.
-func (d *DB) DeletePayments(failedOnly, failedHtlcsOnly bool) error {
-	return kvdb.Update(d, func(tx kvdb.RwTx) error {
-		payments := tx.ReadWriteBucket(paymentsRootBucket)
-		if payments == nil {
-			return nil
+// GCPaymentIndexes removes the entries of the payments index that don't point
+// to a stored payment anymore and returns the number of removed entries. An
+// entry is dangling if the payment it references doesn't exist, or if the
+// payment doesn't carry the entry's sequence number. The deletes keep the
+// index consistent, but such entries can still be left behind by earlier
+// versions, and they make queries over the index fail.
+//
+// The index is checked in batches of paymentIndexGCBatchSize entries, each
+// within its own write transaction, so the write lock isn't held for the whole
+// scan.
+func (d *DB) GCPaymentIndexes(ctx context.Context) (int, error) {
+	var (
+		numRemoved int
+
+		// startKey is the first index key of the next batch, nil
+		// means the batch starts at the first entry.
+		startKey []byte
+	)
+	for {
+		if err := ctx.Err(); err != nil {
+			return numRemoved, err
 		}
 
 		var (
-			// deleteBuckets is the set of payment buckets we need
-			// to delete.
-			deleteBuckets [][]byte
-
-			// deleteIndexes is the set of indexes pointing to these
-			// payments that need to be deleted.
-			deleteIndexes [][]byte
-
-			// deleteHtlcs maps a payment hash to the HTLC IDs we
-			// want to delete for that payment.
-			deleteHtlcs = make(map[lntypes.Hash][][]byte)
+			batchRemoved int
+			nextKey      []byte
 		)
-		err := payments.ForEach(func(k, _ []byte) error {
-			bucket := payments.NestedReadBucket(k)
-			if bucket == nil {
-				// We only expect sub-buckets to be found in
-				// this top-level bucket.
-				return fmt.Errorf("non bucket element in " +
-					"payments bucket")
-			}
-
-			// If the status is InFlight, we cannot safely delete
-			// the payment information, so we return early.
-			paymentStatus, err := fetchPaymentStatus(bucket)
-			if err != nil {
-				return err
-			}
-
-			// If the payment has inflight HTLCs, we cannot safely
-			// delete the payment information, so we return an nil
-			// to skip it.
-			if err := paymentStatus.removable(); err != nil {
+		err := kvdb.Update(d, func(tx kvdb.RwTx) error {
+			indexes := tx.ReadWriteBucket(paymentsIndexBucket)
+			if indexes == nil {
 				return nil
 			}
+			payments := tx.ReadBucket(paymentsRootBucket)
 
-			// If we requested to only delete failed payments, we
-			// can return if this one is not.
-			if failedOnly && paymentStatus != StatusFailed {
-				return nil
+			cursor := indexes.ReadCursor()
+			k, v := cursor.First()
+			if startKey != nil {
+				k, v = cursor.Seek(startKey)
 			}
 
-			// If we are only deleting failed HTLCs, fetch them.
-			if failedHtlcsOnly {
-				toDelete, err := fetchFailedHtlcKeys(bucket)
-				if err != nil {
-					return err
+			// We can't delete entries while iterating over them,
+			// so we collect the dangling ones first.
+			var (
+				dangling   [][]byte
+				numChecked int
+			)
+			for ; k != nil; k, v = cursor.Next() {
+				if numChecked == paymentIndexGCBatchSize {
+					nextKey = append([]byte(nil), k...)
+					break
 				}
+				numChecked++
 
-				hash, err := lntypes.MakeHash(k)
+				ok, err := paymentIndexValid(payments, k, v)
 				if err != nil {
 					return err
 				}
 
-				deleteHtlcs[hash] = toDelete
-
-				// We return, we are only deleting attempts.
-				return nil
+				if !ok {
+					key := append([]byte(nil), k...)
+					dangling = append(dangling, key)
+				}
 			}
 
-			// Add the bucket to the set of buckets we can delete.
-			deleteBuckets = append(deleteBuckets, k)
-
-			// Get all the sequence number associated with the
-			// payment, including duplicates.
-			seqNrs, err := fetchSequenceNumbers(bucket)
-			if err != nil {
-				return err
+			for _, k := range dangling {
+				err := deletePaymentIndexEntry(tx, k)
+				if err != nil {
+					return err
+				}
 			}
+			batchRemoved = len(dangling)
 
-			deleteIndexes = append(deleteIndexes, seqNrs...)
 			return nil
+		}, func() {
+			batchRemoved = 0
+			nextKey = nil
 		})
 		if err != nil {
-			return err
+			return numRemoved, err
 		}
 
-		// Delete the failed HTLC attempts we found.
-		for hash, htlcIDs := range deleteHtlcs {
-			bucket := payments.NestedReadWriteBucket(hash[:])
-			htlcsBucket := bucket.NestedReadWriteBucket(
-				paymentHtlcsBucket,
-			)
+		numRemoved += batchRemoved
+		if nextKey == nil {
+			break
+		}
+		startKey = nextKey
+	}
 
-			for _, aid := range htlcIDs {
-				if err := htlcsBucket.Delete(
-					htlcBucketKey(htlcAttemptInfoKey, aid),
-				); err != nil {
-					return err
-				}
+	if numRemoved > 0 {
+		log.Infof("Removed %d dangling payment index entries",
+			numRemoved)
+	}
 
-				if err := htlcsBucket.Delete(
-					htlcBucketKey(htlcFailInfoKey, aid),
-				); err != nil {
-					return err
-				}
+	return numRemoved, nil
+}
 
-				if err := htlcsBucket.Delete(
-					htlcBucketKey(htlcSettleInfoKey, aid),
-				); err != nil {
-					return err
-				}
-			}
-		}
+// paymentIndexValid returns true if the payment referenced by the given entry
+// of the payments index exists and carries the entry's sequence number, either
+// as its own or as the one of a duplicate payment.
+func paymentIndexValid(payments kvdb.RBucket, seqKey,
+	indexValue []byte) (bool, error) {
 
-		for _, k := range deleteBuckets {
-			if err := payments.DeleteNestedBucket(k); err != nil {
-				return err
-			}
-		}
+	paymentHash, err := deserializePaymentIndex(
+		bytes.NewReader(indexValue),
+	)
+	if err != nil {
+		return false, err
+	}
 
-		// Get our index bucket and delete all indexes pointing to the
-		// payments we are deleting.
-		indexBucket := tx.ReadWriteBucket(paymentsIndexBucket)
-		for _, k := range deleteIndexes {
-			if err := indexBucket.Delete(k); err != nil {
-				return err
-			}
+	if payments == nil {
+		return false, nil
+	}
+
+	bucket := payments.NestedReadBucket(paymentHash[:])
+	if bucket == nil {
+		return false, nil
+	}
+
+	seqNrs, err := fetchSequenceNumbers(bucket)
+	if err != nil {
+		return false, err
+	}
+
+	for _, seqNr := range seqNrs {
+		if bytes.Equal(seqNr, seqKey) {
+			return true, nil
 		}
+	}
 
-		return nil
-	}, func() {})
+	return false, nil
 }
 
-// fetchSequenceNumbers fetches all the sequence numbers associated with a
-// payment, including those belonging to any duplicate payments.
-func fetchSequenceNumbers(paymentBucket kvdb.RBucket) ([][]byte, error) {
-	seqNum := paymentBucket.Get(paymentSequenceKey)
-	if seqNum == nil {
-		return nil, errors.New("expected sequence number")
-	}
+const (
+	// paymentCreationInfoV1 is the version of the creation info layout
+	// that prefixes the original, unversioned layout with a version byte.
+	// The unversioned layout is referred to as version zero. Creation info
+	// stored in that layout was migrated to this version by migration 32.
+	paymentCreationInfoV1 = 1
+
+	// paymentCreationInfoVersion is the version new creation info is
+	// written with.
+	paymentCreationInfoVersion = paymentCreationInfoV1
+)
 
-	sequenceNumbers := [][]byte{seqNum}
+// ErrUnknownCreationInfoVersion is returned when reading payment creation
+// info that was written with a version this build of lnd doesn't know.
+var ErrUnknownCreationInfoVersion = errors.New("unknown payment creation " +
+	"info version")
 
-	// Get the duplicate payments bucket, if it has no duplicates, just
-	// return early with the payment sequence number.
-	duplicates := paymentBucket.NestedReadBucket(duplicatePaymentsBucket)
-	if duplicates == nil {
-		return sequenceNumbers, nil
+// serializePaymentCreationInfo writes the creation info with a leading version
+// byte, followed by the fields of the unversioned layout.
+func serializePaymentCreationInfo(w io.Writer, c *PaymentCreationInfo) error {
+	if _, err := w.Write([]byte{paymentCreationInfoVersion}); err != nil {
+		return err
 	}
 
-	// If we do have duplicated, they are keyed by sequence number, so we
-	// iterate through the duplicates bucket and add them to our set of
-	// sequence numbers.
-	if err := duplicates.ForEach(func(k, v []byte) error {
-		sequenceNumbers = append(sequenceNumbers, k)
-		return nil
-	}); err != nil {
+	return serializePaymentCreationInfoV0(w, c)
+}
+
+// deserializePaymentCreationInfo reads creation info written with any known
+// version. The version is given by the leading byte.
+func deserializePaymentCreationInfo(r io.Reader) (*PaymentCreationInfo,
+	error) {
+
+	var version [1]byte
+	if _, err := io.ReadFull(r, version[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.ErrUnexpectedEOF
+		}
+
 		return nil, err
 	}
 
-	return sequenceNumbers, nil
+	switch version[0] {
+	case paymentCreationInfoV1:
+		return deserializePaymentCreationInfoV0(r)
+
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrUnknownCreationInfoVersion,
+			version[0])
+	}
 }
 
+// serializePaymentCreationInfoV0 writes the creation info in the unversioned
+// layout.
+//
 // nolint: dupl
-func serializePaymentCreationInfo(w io.Writer, c *PaymentCreationInfo) error {
+func serializePaymentCreationInfoV0(w io.Writer,
+	c *PaymentCreationInfo) error {
+
 	var scratch [8]byte
 
 	if _, err := w.Write(c.PaymentIdentifier[:]); err != nil {
@@ -1006,10 +2864,19 @@ func serializePaymentCreationInfo(w io.Writer, c *PaymentCreationInfo) error {
 		return err
 	}
 
+	byteOrder.PutUint64(scratch[:], uint64(c.FeeLimit))
+	if _, err := w.Write(scratch[:]); err != nil {
+		return err
+	}
+
 	return nil
 }
 
-func deserializePaymentCreationInfo(r io.Reader) (*PaymentCreationInfo, error) {
+// deserializePaymentCreationInfoV0 reads creation info in the unversioned
+// layout.
+func deserializePaymentCreationInfoV0(r io.Reader) (*PaymentCreationInfo,
+	error) {
+
 	var scratch [8]byte
 
 	c := &PaymentCreationInfo{}
@@ -1042,6 +2909,20 @@ func deserializePaymentCreationInfo(r io.Reader) (*PaymentCreationInfo, error) {
 	}
 	c.PaymentRequest = payReq
 
+	// The fee limit was added later, so payments created before don't
+	// have it stored. We treat them as not having a fee limit.
+	_, err = io.ReadFull(r, scratch[:])
+	switch {
+	case errors.Is(err, io.EOF):
+		c.FeeLimit = NoFeeLimit
+
+	case err != nil:
+		return nil, err
+
+	default:
+		c.FeeLimit = lnwire.MilliSatoshi(byteOrder.Uint64(scratch[:]))
+	}
+
 	return c, nil
 }
 
@@ -1067,17 +2948,31 @@ func serializeHTLCAttemptInfo(w io.Writer, a *HTLCAttemptInfo) error {
 		return err
 	}
 
-	return nil
+	// The chain fee rate is optional, so we only write it if it's known.
+	if a.ChainFeeRate == 0 {
+		return nil
+	}
+
+	return WriteElements(w, uint64(a.ChainFeeRate))
 }
 
-func deserializeHTLCAttemptInfo(r io.Reader) (*HTLCAttemptInfo, error) {
+// deserializeHTLCAttemptInfo deserializes an HTLCAttemptInfo. If skipHops is
+// true, only a summary of the attempt's route is read, see
+// deserializeRouteSummary.
+func deserializeHTLCAttemptInfo(r io.Reader, skipHops bool) (*HTLCAttemptInfo,
+	error) {
+
 	a := &HTLCAttemptInfo{}
 	err := ReadElements(r, &a.sessionKey)
 	if err != nil {
 		return nil, err
 	}
 
-	a.Route, err = DeserializeRoute(r)
+	if skipHops {
+		a.Route, err = deserializeRouteSummary(r)
+	} else {
+		a.Route, err = DeserializeRoute(r)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -1104,9 +2999,30 @@ func deserializeHTLCAttemptInfo(r io.Reader) (*HTLCAttemptInfo, error) {
 
 	a.Hash = &hash
 
+	a.ChainFeeRate, err = deserializeChainFeeRate(r)
+	if err != nil {
+		return nil, err
+	}
+
 	return a, nil
 }
 
+// deserializeChainFeeRate reads the optional chain fee rate of an htlc attempt.
+// Attempts that were stored without a fee rate return zero.
+func deserializeChainFeeRate(r io.Reader) (chainfee.SatPerKWeight, error) {
+	var feeRate uint64
+	err := ReadElements(r, &feeRate)
+	switch {
+	case err == io.EOF:
+		return 0, nil
+
+	case err != nil:
+		return 0, err
+	}
+
+	return chainfee.SatPerKWeight(feeRate), nil
+}
+
 func serializeHop(w io.Writer, h *route.Hop) error {
 	if err := WriteElements(w,
 		h.PubKeyBytes[:],
@@ -1346,11 +3262,127 @@ func deserializeHop(r io.Reader) (*route.Hop, error) {
 		h.TotalAmtMsat = lnwire.MilliSatoshi(totalAmtMsatInt)
 	}
 
-	h.CustomRecords = tlvMap
+	// Only the custom records remain in the tlv map now. A hop without
+	// custom records is represented by a nil set, just like before it was
+	// stored. Zero-length values are read as empty, non-nil slices.
+	if len(tlvMap) > 0 {
+		h.CustomRecords = tlvMap
+	}
+
+	return h, nil
+}
+
+// normalizeHopCustomRecords replaces nil custom record values of the route's
+// hops by empty values. Both are serialized the same way, and are always read
+// back as empty values.
+func normalizeHopCustomRecords(rt *route.Route) {
+	for _, h := range rt.Hops {
+		for k, v := range h.CustomRecords {
+			if v == nil {
+				h.CustomRecords[k] = []byte{}
+			}
+		}
+	}
+}
+
+// skipHop reads past a hop serialized with serializeHop without decoding its
+// TLV records. A stub of the hop is returned, carrying its pubkey, the amount
+// it forwards and its encrypted data if it's part of a blinded path.
+func skipHop(r io.Reader) (*route.Hop, error) {
+	var (
+		pub              []byte
+		chanID           uint64
+		outgoingTimeLock uint32
+		legacyPayload    bool
+		numElements      uint32
+	)
+	h := &route.Hop{}
+	if err := ReadElements(r, &pub); err != nil {
+		return nil, err
+	}
+	copy(h.PubKeyBytes[:], pub)
+
+	if err := ReadElements(r,
+		&chanID, &outgoingTimeLock, &h.AmtToForward,
+	); err != nil {
+		return nil, err
+	}
+
+	err := binary.Read(r, byteOrder, &legacyPayload)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ReadElements(r, &numElements); err != nil {
+		return nil, err
+	}
+
+	encryptedDataType := uint64(record.EncryptedDataOnionType)
+	for i := uint32(0); i < numElements; i++ {
+		var tlvType uint64
+		if err := ReadElements(r, &tlvType); err != nil {
+			return nil, err
+		}
+
+		value, err := wire.ReadVarBytes(
+			r, 0, maxOnionPayloadSize, "tlv",
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		// The encrypted data is kept, so it can be told whether the
+		// hop is part of a blinded path.
+		if tlvType == encryptedDataType {
+			h.EncryptedData = value
+		}
+	}
 
 	return h, nil
 }
 
+// deserializeRouteSummary deserializes a route serialized with SerializeRoute,
+// but skips the decoding of all hop level data. The returned route contains
+// the route totals and, if the route has any hops, a single stub hop that
+// carries the pubkey of the final hop, the amount it received and its
+// encrypted data, if any. This keeps ReceiverAmt and TotalFees of the returned
+// route intact, and allows to tell whether the route ends in a blinded path.
+func deserializeRouteSummary(r io.Reader) (route.Route, error) {
+	rt := route.Route{}
+	if err := ReadElements(r,
+		&rt.TotalTimeLock, &rt.TotalAmount,
+	); err != nil {
+		return rt, err
+	}
+
+	var pub []byte
+	if err := ReadElements(r, &pub); err != nil {
+		return rt, err
+	}
+	copy(rt.SourcePubKey[:], pub)
+
+	var numHops uint32
+	if err := ReadElements(r, &numHops); err != nil {
+		return rt, err
+	}
+
+	var finalHop *route.Hop
+	for i := uint32(0); i < numHops; i++ {
+		hop, err := skipHop(r)
+		if err != nil {
+			return rt, err
+		}
+
+		finalHop = hop
+	}
+
+	if finalHop != nil {
+		rt.Hops = []*route.Hop{finalHop}
+	}
+
+	return rt, nil
+}
+
 // SerializeRoute serializes a route.
 func SerializeRoute(w io.Writer, r route.Route) error {
 	if err := WriteElements(w,
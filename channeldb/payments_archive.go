@@ -0,0 +1,285 @@
+package channeldb
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/lightningnetwork/lnd/kvdb"
+)
+
+var (
+	// paymentsArchiveBucket is the name of the top-level bucket that holds
+	// the payments that were deleted while archiving of deleted payments
+	// was enabled. Each archived payment is a copy of its original payment
+	// bucket, keyed by the payment's sequence number, with the time of its
+	// deletion added.
+	//
+	// Bucket hierarchy:
+	//
+	// payments-archive-bucket
+	//      |
+	//      |-- <seq-num>
+	//      |        |--payment-deleted-at: <deletion time>
+	//      |        |--sequence-key: <sequence number>
+	//      |        |--creation-info-key: <creation info>
+	//      |        |--fail-info-key: <(optional) fail info>
+	//      |        |--payment-htlcs-bucket
+	//      |        |--duplicate-bucket (only for old, completed payments)
+	//      |
+	//      |-- <seq-num>
+	//      |        |
+	//     ...      ...
+	//
+	paymentsArchiveBucket = []byte("payments-archive-bucket")
+
+	// paymentDeletedAtKey is a key used in an archived payment's bucket to
+	// store the time at which the payment was deleted.
+	paymentDeletedAtKey = []byte("payment-deleted-at")
+)
+
+// ArchivedPayment is a payment that was moved to the archive when it was
+// deleted.
+type ArchivedPayment struct {
+	// Payment is the payment as it was stored when it was deleted.
+	Payment *MPPayment
+
+	// DeletedAt is the time at which the payment was deleted.
+	DeletedAt time.Time
+}
+
+// ArchivedPaymentsResponse contains the result of a query to the payments
+// archive.
+type ArchivedPaymentsResponse struct {
+	// Payments is the set of archived payments returned for the query.
+	Payments []*ArchivedPayment
+
+	// FirstIndexOffset is the sequence number of the first returned
+	// payment. The offset can be used to continue reverse pagination.
+	FirstIndexOffset uint64
+
+	// LastIndexOffset is the sequence number of the last returned
+	// payment. The offset can be used to continue forward pagination.
+	LastIndexOffset uint64
+
+	// TotalCount is the total number of archived payments. This will only
+	// be set if the CountTotal field in the query was set to true. If the
+	// query had a creation date filter, only the payments within the
+	// queried time range are counted.
+	TotalCount uint64
+}
+
+// archivePayment copies the given payment bucket into the payments archive,
+// recording the time of deletion. It must be called before the payment bucket
+// is deleted.
+func archivePayment(tx kvdb.RwTx, payment kvdb.RBucket,
+	deletedAt time.Time) error {
+
+	archive, err := tx.CreateTopLevelBucket(paymentsArchiveBucket)
+	if err != nil {
+		return err
+	}
+
+	seqNum := payment.Get(paymentSequenceKey)
+	if seqNum == nil {
+		return ErrNoSequenceNumber
+	}
+
+	archived, err := archive.CreateBucket(copyBytes(seqNum))
+	if err != nil {
+		return fmt.Errorf("unable to archive payment: %w", err)
+	}
+
+	if err := copyBucket(archived, payment); err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	if err := serializeTime(&b, deletedAt); err != nil {
+		return err
+	}
+
+	return archived.Put(paymentDeletedAtKey, b.Bytes())
+}
+
+// copyBucket recursively copies all keys and nested buckets of src into dst.
+// The keys and values are copied, so src can be deleted afterwards within the
+// same transaction.
+func copyBucket(dst kvdb.RwBucket, src kvdb.RBucket) error {
+	return src.ForEach(func(k, v []byte) error {
+		// Values are only nil for nested buckets.
+		if v != nil {
+			return dst.Put(copyBytes(k), copyBytes(v))
+		}
+
+		nested, err := dst.CreateBucket(copyBytes(k))
+		if err != nil {
+			return err
+		}
+
+		return copyBucket(nested, src.NestedReadBucket(k))
+	})
+}
+
+// copyBytes returns a copy of the given byte slice.
+func copyBytes(b []byte) []byte {
+	return append([]byte(nil), b...)
+}
+
+// fetchArchivedPayment reads the archived payment stored in the given bucket.
+func fetchArchivedPayment(bucket kvdb.RBucket,
+	skipHops bool) (*ArchivedPayment, error) {
+
+	payment, err := fetchPaymentWithHops(bucket, skipHops)
+	if err != nil {
+		return nil, err
+	}
+
+	b := bucket.Get(paymentDeletedAtKey)
+	if b == nil {
+		return nil, fmt.Errorf("deletion time of archived payment %v "+
+			"not found", payment.Info.PaymentIdentifier)
+	}
+
+	deletedAt, err := deserializeTime(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+
+	return &ArchivedPayment{
+		Payment:   payment,
+		DeletedAt: deletedAt,
+	}, nil
+}
+
+// QueryArchivedPayments queries the archive of deleted payments. The query is
+// interpreted just like for QueryPayments, with the index offsets referring to
+// the sequence numbers the payments had before they were deleted.
+func (d *DB) QueryArchivedPayments(query PaymentsQuery) (
+	ArchivedPaymentsResponse, error) {
+
+	var resp ArchivedPaymentsResponse
+	if err := query.validateCreationDateRange(); err != nil {
+		return resp, err
+	}
+
+	if err := kvdb.View(d, func(tx kvdb.RTx) error {
+		archive := tx.ReadBucket(paymentsArchiveBucket)
+		if archive == nil {
+			return nil
+		}
+
+		// matches returns true if the archived payment satisfies the
+		// filters of the query.
+		matches := func(payment *MPPayment) bool {
+			if payment.Status != StatusSucceeded &&
+				!query.IncludeIncomplete {
+
+				return false
+			}
+
+			return query.inCreationDateRange(payment)
+		}
+
+		accumulatePayments := func(sequenceKey, _ []byte) (bool,
+			error) {
+
+			bucket := archive.NestedReadBucket(sequenceKey)
+			if bucket == nil {
+				return false, fmt.Errorf("non bucket element " +
+					"in payments archive")
+			}
+
+			archived, err := fetchArchivedPayment(
+				bucket, query.SkipHops || query.ExcludeHTLCs,
+			)
+			if err != nil {
+				return false, err
+			}
+
+			if !matches(archived.Payment) {
+				return false, nil
+			}
+
+			if query.ExcludeHTLCs {
+				archived.Payment.HTLCs = nil
+			}
+
+			resp.Payments = append(resp.Payments, archived)
+
+			return true, nil
+		}
+
+		paginator := newPaginator(
+			archive.ReadCursor(), query.Reversed, query.IndexOffset,
+			query.MaxPayments,
+		)
+		if err := paginator.query(accumulatePayments); err != nil {
+			return err
+		}
+
+		if !query.CountTotal {
+			return nil
+		}
+
+		var totalPayments uint64
+		err := archive.ForEach(func(sequenceKey, _ []byte) error {
+			// Without a date filter, every archived payment is
+			// counted.
+			if !query.hasCreationDateFilter() {
+				totalPayments++
+
+				return nil
+			}
+
+			bucket := archive.NestedReadBucket(sequenceKey)
+			if bucket == nil {
+				return fmt.Errorf("non bucket element in " +
+					"payments archive")
+			}
+
+			payment, err := fetchPaymentWithHops(bucket, true)
+			if err != nil {
+				return err
+			}
+
+			if query.inCreationDateRange(payment) {
+				totalPayments++
+			}
+
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("error counting archived payments: "+
+				"%w", err)
+		}
+
+		resp.TotalCount = totalPayments
+
+		return nil
+	}, func() {
+		resp = ArchivedPaymentsResponse{}
+	}); err != nil {
+		return resp, err
+	}
+
+	// Need to swap the payments slice order if reversed order.
+	if query.Reversed {
+		for l, r := 0, len(resp.Payments)-1; l < r; l, r = l+1, r-1 {
+			resp.Payments[l], resp.Payments[r] =
+				resp.Payments[r], resp.Payments[l]
+		}
+	}
+
+	// Set the first and last index of the returned payments so that the
+	// caller can resume from this point later on.
+	if len(resp.Payments) > 0 {
+		first := resp.Payments[0].Payment
+		last := resp.Payments[len(resp.Payments)-1].Payment
+
+		resp.FirstIndexOffset = first.SequenceNum
+		resp.LastIndexOffset = last.SequenceNum
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,141 @@
+package channeldb
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/clock"
+	"github.com/stretchr/testify/require"
+)
+
+// TestArchiveDeletedPayments tests that deleted payments are moved to the
+// payments archive if archiving is enabled, and that they are removed
+// entirely otherwise.
+func TestArchiveDeletedPayments(t *testing.T) {
+	t.Parallel()
+
+	t.Run("archive", func(t *testing.T) {
+		t.Parallel()
+		testArchiveDeletedPayments(t, true)
+	})
+	t.Run("no archive", func(t *testing.T) {
+		t.Parallel()
+		testArchiveDeletedPayments(t, false)
+	})
+}
+
+func testArchiveDeletedPayments(t *testing.T, archive bool) {
+	ctx := context.Background()
+
+	deletedAt := time.Unix(1_700_000_000, 0)
+	db, err := MakeTestDB(
+		t, OptionArchiveDeletedPayments(archive),
+		OptionClock(clock.NewTestClock(deletedAt)),
+	)
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+
+	payments := []*payment{
+		{status: StatusSucceeded},
+		{status: StatusFailed},
+		{status: StatusFailed},
+		{status: StatusInFlight},
+	}
+	createTestPayments(t, pControl, payments)
+
+	// Keep the payments as they were stored before their deletion.
+	stored, err := db.FetchPayments()
+	require.NoError(t, err)
+	require.Len(t, stored, len(payments))
+
+	// Deleting only the failed htlcs of a payment doesn't archive it.
+	_, err = db.DeletePayment(payments[0].id, true)
+	require.NoError(t, err)
+
+	resp, err := db.QueryArchivedPayments(PaymentsQuery{
+		MaxPayments:       math.MaxUint64,
+		IncludeIncomplete: true,
+	})
+	require.NoError(t, err)
+	require.Empty(t, resp.Payments)
+
+	stored[0], err = pControl.FetchPayment(payments[0].id)
+	require.NoError(t, err)
+
+	// Delete the succeeded payment on its own and the failed payments in
+	// bulk. The in-flight payment is kept.
+	_, err = db.DeletePayment(payments[0].id, false)
+	require.NoError(t, err)
+	_, _, err = db.DeletePayments(true, false)
+	require.NoError(t, err)
+
+	// The deleted payments are gone from the payments index.
+	queryResp, err := db.QueryPayments(ctx, PaymentsQuery{
+		MaxPayments:       math.MaxUint64,
+		IncludeIncomplete: true,
+	})
+	require.NoError(t, err)
+	require.Len(t, queryResp.Payments, 1)
+	require.Equal(
+		t, payments[3].id,
+		queryResp.Payments[0].Info.PaymentIdentifier,
+	)
+
+	resp, err = db.QueryArchivedPayments(PaymentsQuery{
+		MaxPayments:       math.MaxUint64,
+		IncludeIncomplete: true,
+		CountTotal:        true,
+	})
+	require.NoError(t, err)
+
+	// Without archiving, nothing is kept.
+	if !archive {
+		require.Empty(t, resp.Payments)
+		require.Zero(t, resp.TotalCount)
+
+		return
+	}
+
+	// Otherwise, the three deleted payments are archived in their
+	// original order with all their htlcs.
+	require.Len(t, resp.Payments, 3)
+	require.EqualValues(t, 3, resp.TotalCount)
+	for i, archived := range resp.Payments {
+		require.Equal(t, stored[i], archived.Payment)
+		require.True(t, deletedAt.Equal(archived.DeletedAt))
+	}
+	require.Equal(t, stored[0].SequenceNum, resp.FirstIndexOffset)
+	require.Equal(t, stored[2].SequenceNum, resp.LastIndexOffset)
+
+	// Incomplete payments are only returned if requested.
+	resp, err = db.QueryArchivedPayments(PaymentsQuery{
+		MaxPayments: math.MaxUint64,
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Payments, 1)
+	require.Equal(t, stored[0], resp.Payments[0].Payment)
+
+	// Paginate forwards starting after the first archived payment.
+	resp, err = db.QueryArchivedPayments(PaymentsQuery{
+		IndexOffset:       stored[0].SequenceNum,
+		MaxPayments:       1,
+		IncludeIncomplete: true,
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Payments, 1)
+	require.Equal(t, stored[1], resp.Payments[0].Payment)
+
+	// Paginate backwards from the end.
+	resp, err = db.QueryArchivedPayments(PaymentsQuery{
+		MaxPayments:       2,
+		Reversed:          true,
+		IncludeIncomplete: true,
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Payments, 2)
+	require.Equal(t, stored[1], resp.Payments[0].Payment)
+	require.Equal(t, stored[2], resp.Payments[1].Payment)
+}
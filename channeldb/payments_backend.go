@@ -0,0 +1,115 @@
+package channeldb
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lightningnetwork/lnd/kvdb"
+)
+
+// PaymentsBackend identifies the store payments are written to.
+type PaymentsBackend uint8
+
+const (
+	// PaymentsBackendKV means that payments are stored in the key-value
+	// buckets of the channel database.
+	PaymentsBackendKV PaymentsBackend = 1
+
+	// PaymentsBackendSQL means that payments are stored in native SQL
+	// tables.
+	PaymentsBackendSQL PaymentsBackend = 2
+)
+
+// String returns a human-readable name of the payments backend.
+func (b PaymentsBackend) String() string {
+	switch b {
+	case PaymentsBackendKV:
+		return "kv"
+
+	case PaymentsBackendSQL:
+		return "sql"
+
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(b))
+	}
+}
+
+var (
+	// PaymentsBackendMarkerKey is the key of the marker that records the
+	// backend payments are currently written to. Databases without the
+	// marker store their payments in the key-value buckets.
+	PaymentsBackendMarkerKey = []byte("payments-backend")
+
+	// ErrPaymentsBackendMismatch is returned when lnd is started with a
+	// payments backend other than the one recorded in the database.
+	ErrPaymentsBackendMismatch = errors.New("payments backend mismatch")
+
+	// ErrInvalidPaymentsBackendMarker is returned when the recorded
+	// payments backend marker can't be decoded.
+	ErrInvalidPaymentsBackendMarker = errors.New("invalid payments " +
+		"backend marker")
+)
+
+// FetchPaymentsBackend returns the payments backend recorded in the database
+// of the given transaction. If no backend was recorded, the payments are in
+// the key-value buckets.
+func FetchPaymentsBackend(tx kvdb.RTx) (PaymentsBackend, error) {
+	marker, err := CheckMarkerPresent(tx, PaymentsBackendMarkerKey)
+	switch {
+	case errors.Is(err, ErrMarkerNotPresent):
+		return PaymentsBackendKV, nil
+
+	case err != nil:
+		return 0, err
+	}
+
+	if len(marker) != 1 {
+		return 0, fmt.Errorf("%w: expected 1 byte, got %d",
+			ErrInvalidPaymentsBackendMarker, len(marker))
+	}
+
+	return PaymentsBackend(marker[0]), nil
+}
+
+// PutPaymentsBackend records the given backend as the one payments are written
+// to. It's meant to be called by the migration that moves the payments to
+// another backend, within the same transaction that completes it.
+func PutPaymentsBackend(tx kvdb.RwTx, backend PaymentsBackend) error {
+	return AddMarker(tx, PaymentsBackendMarkerKey, []byte{byte(backend)})
+}
+
+// checkPaymentsBackend makes sure the configured payments backend is the one
+// recorded in the database, so payments are never written to a store other
+// than the one that holds their history. If force is set, the check is
+// skipped. The recorded backend is left as is in that case, so the next start
+// without force still detects the mismatch.
+func (d *DB) checkPaymentsBackend(configured PaymentsBackend,
+	force bool) error {
+
+	if force {
+		log.Warnf("Forcing the %v payments backend, skipping the "+
+			"payments backend check", configured)
+
+		return nil
+	}
+
+	var recorded PaymentsBackend
+	err := kvdb.View(d, func(tx kvdb.RTx) error {
+		var err error
+		recorded, err = FetchPaymentsBackend(tx)
+
+		return err
+	}, func() {})
+	if err != nil {
+		return err
+	}
+
+	if recorded == configured {
+		return nil
+	}
+
+	return fmt.Errorf("%w: payments are stored in the %v backend, but "+
+		"the %v backend is configured; use --force-payments-backend "+
+		"to start anyway", ErrPaymentsBackendMismatch, recorded,
+		configured)
+}
@@ -0,0 +1,146 @@
+package channeldb
+
+import (
+	"testing"
+
+	"github.com/lightningnetwork/lnd/kvdb"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPaymentsBackendMarker tests that the database can only be opened with
+// the payments backend recorded in it, unless it's forced, in which case the
+// recorded backend is kept.
+func TestPaymentsBackendMarker(t *testing.T) {
+	t.Parallel()
+
+	kv, sql := PaymentsBackendKV, PaymentsBackendSQL
+
+	tests := []struct {
+		name       string
+		recorded   *PaymentsBackend
+		configured PaymentsBackend
+		force      bool
+		expectErr  bool
+		expected   PaymentsBackend
+	}{
+		{
+			name:       "no marker, kv configured",
+			configured: PaymentsBackendKV,
+			expected:   PaymentsBackendKV,
+		},
+		{
+			name:       "no marker, sql configured",
+			configured: PaymentsBackendSQL,
+			expectErr:  true,
+		},
+		{
+			name:       "kv marker, kv configured",
+			recorded:   &kv,
+			configured: PaymentsBackendKV,
+			expected:   PaymentsBackendKV,
+		},
+		{
+			name:       "kv marker, sql configured",
+			recorded:   &kv,
+			configured: PaymentsBackendSQL,
+			expectErr:  true,
+		},
+		{
+			name:       "sql marker, kv configured",
+			recorded:   &sql,
+			configured: PaymentsBackendKV,
+			expectErr:  true,
+		},
+		{
+			name:       "sql marker, sql configured",
+			recorded:   &sql,
+			configured: PaymentsBackendSQL,
+			expected:   PaymentsBackendSQL,
+		},
+		{
+			name:       "sql marker, kv forced",
+			recorded:   &sql,
+			configured: PaymentsBackendKV,
+			force:      true,
+			expected:   PaymentsBackendSQL,
+		},
+		{
+			name:       "no marker, sql forced",
+			configured: PaymentsBackendSQL,
+			force:      true,
+			expected:   PaymentsBackendKV,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			backend, cleanup, err := kvdb.GetTestBackend(
+				t.TempDir(), "cdb",
+			)
+			require.NoError(t, err)
+			t.Cleanup(cleanup)
+
+			db, err := CreateWithBackend(backend)
+			require.NoError(t, err)
+
+			putMarker := func(tx kvdb.RwTx) error {
+				return PutPaymentsBackend(tx, *test.recorded)
+			}
+			if test.recorded != nil {
+				err := kvdb.Update(db, putMarker, func() {})
+				require.NoError(t, err)
+			}
+
+			// Reopen the database with the configured backend.
+			db, err = CreateWithBackend(
+				backend, OptionPaymentsBackend(test.configured),
+				OptionForcePaymentsBackend(test.force),
+			)
+			if test.expectErr {
+				require.ErrorIs(
+					t, err, ErrPaymentsBackendMismatch,
+				)
+				return
+			}
+			require.NoError(t, err)
+
+			var recorded PaymentsBackend
+			err = kvdb.View(db, func(tx kvdb.RTx) error {
+				var err error
+				recorded, err = FetchPaymentsBackend(tx)
+
+				return err
+			}, func() {})
+			require.NoError(t, err)
+			require.Equal(t, test.expected, recorded)
+		})
+	}
+}
+
+// TestPaymentsBackendInvalidMarker tests that a payments backend marker of an
+// unexpected length is rejected.
+func TestPaymentsBackendInvalidMarker(t *testing.T) {
+	t.Parallel()
+
+	backend, cleanup, err := kvdb.GetTestBackend(t.TempDir(), "cdb")
+	require.NoError(t, err)
+	t.Cleanup(cleanup)
+
+	db, err := CreateWithBackend(backend)
+	require.NoError(t, err)
+
+	err = kvdb.Update(db, func(tx kvdb.RwTx) error {
+		return AddMarker(tx, PaymentsBackendMarkerKey, []byte{1, 2})
+	}, func() {})
+	require.NoError(t, err)
+
+	err = kvdb.View(db, func(tx kvdb.RTx) error {
+		_, err := FetchPaymentsBackend(tx)
+		return err
+	}, func() {})
+	require.ErrorIs(t, err, ErrInvalidPaymentsBackendMarker)
+}
@@ -0,0 +1,115 @@
+package channeldb
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lightningnetwork/lnd/kvdb"
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+// ErrCorruptHTLCAttempt is returned when reading a payment with an htlc
+// attempt that couldn't be parsed while strict payment decoding is enabled.
+var ErrCorruptHTLCAttempt = errors.New("corrupt htlc attempt")
+
+// QuarantinedHTLC is an htlc attempt whose stored data couldn't be parsed.
+type QuarantinedHTLC struct {
+	// PaymentIdentifier is the identifier of the payment the attempt
+	// belongs to.
+	PaymentIdentifier lntypes.Hash
+
+	// AttemptID is the ID of the quarantined attempt.
+	AttemptID uint64
+
+	// Corruption describes the records of the attempt that couldn't be
+	// parsed, including their raw data.
+	Corruption *HTLCCorruption
+}
+
+// quarantineKey identifies a quarantined htlc attempt.
+type quarantineKey struct {
+	paymentHash lntypes.Hash
+	attemptID   uint64
+}
+
+// checkQuarantine returns an error if strict payment decoding is enabled and
+// the given payment has quarantined htlc attempts. Otherwise the quarantined
+// attempts that weren't logged before are logged.
+func (d *DB) checkQuarantine(payment *MPPayment) error {
+	paymentHash := payment.Info.PaymentIdentifier
+	for _, htlc := range payment.HTLCs {
+		if htlc.Corrupt == nil {
+			continue
+		}
+
+		if d.strictPaymentDecoding {
+			return fmt.Errorf("%w %v of payment %v: %v",
+				ErrCorruptHTLCAttempt, htlc.AttemptID,
+				paymentHash, htlc.Corrupt.Err)
+		}
+
+		key := quarantineKey{
+			paymentHash: paymentHash,
+			attemptID:   htlc.AttemptID,
+		}
+		_, logged := d.loggedQuarantines.LoadOrStore(key, struct{}{})
+		if logged {
+			continue
+		}
+
+		log.Errorf("Quarantined htlc attempt %v of payment %v: %v",
+			htlc.AttemptID, paymentHash, htlc.Corrupt.Err)
+	}
+
+	return nil
+}
+
+// FetchQuarantinedHTLCs checks all stored payments for htlc attempts that
+// couldn't be parsed and returns them along with their raw data, so they can
+// be repaired manually.
+func (d *DB) FetchQuarantinedHTLCs() ([]*QuarantinedHTLC, error) {
+	var quarantined []*QuarantinedHTLC
+
+	err := kvdb.View(d, func(tx kvdb.RTx) error {
+		payments := tx.ReadBucket(paymentsRootBucket)
+		if payments == nil {
+			return nil
+		}
+
+		return payments.ForEach(func(k, _ []byte) error {
+			bucket := payments.NestedReadBucket(k)
+			if bucket == nil {
+				return fmt.Errorf("non bucket element in " +
+					"payments bucket")
+			}
+
+			payment, err := fetchPayment(bucket)
+			if err != nil {
+				return err
+			}
+
+			paymentHash := payment.Info.PaymentIdentifier
+			for _, htlc := range payment.HTLCs {
+				if htlc.Corrupt == nil {
+					continue
+				}
+
+				q := &QuarantinedHTLC{
+					PaymentIdentifier: paymentHash,
+					AttemptID:         htlc.AttemptID,
+					Corruption:        htlc.Corrupt,
+				}
+				quarantined = append(quarantined, q)
+			}
+
+			return nil
+		})
+	}, func() {
+		quarantined = nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return quarantined, nil
+}
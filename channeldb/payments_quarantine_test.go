@@ -0,0 +1,299 @@
+package channeldb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightningnetwork/lnd/kvdb"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/require"
+)
+
+// TestQuarantineCorruptHTLCs tests that htlc attempts whose stored data can't
+// be parsed are quarantined instead of failing the read of their payment, and
+// that strict payment decoding restores the fail-fast behavior.
+func TestQuarantineCorruptHTLCs(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	// invalidFailure returns a fail info with an unknown failure message.
+	invalidFailure := func(t *testing.T, _ []byte) []byte {
+		var b bytes.Buffer
+		require.NoError(t, serializeTime(&b, time.Unix(1, 0)))
+		require.NoError(t, wire.WriteVarBytes(&b, 0, []byte{0, 1}))
+		require.NoError(t, WriteElements(&b, byte(0), uint32(0)))
+
+		return b.Bytes()
+	}
+
+	// truncate cuts the stored record to the given length.
+	truncate := func(n int) func(*testing.T, []byte) []byte {
+		return func(t *testing.T, v []byte) []byte {
+			require.Greater(t, len(v), n)
+
+			return v[:n]
+		}
+	}
+
+	// The payment of each test case has a failed attempt with ID 0 and a
+	// settled attempt with ID 1.
+	testCases := []struct {
+		name      string
+		prefix    []byte
+		attemptID uint64
+		corrupt   func(*testing.T, []byte) []byte
+	}{
+		{
+			name:      "session key",
+			prefix:    htlcAttemptInfoKey,
+			attemptID: 0,
+			corrupt:   truncate(16),
+		},
+		{
+			name:      "route",
+			prefix:    htlcAttemptInfoKey,
+			attemptID: 1,
+			corrupt:   truncate(60),
+		},
+		{
+			name:      "settle info",
+			prefix:    htlcSettleInfoKey,
+			attemptID: 1,
+			corrupt:   truncate(20),
+		},
+		{
+			name:      "fail info",
+			prefix:    htlcFailInfoKey,
+			attemptID: 0,
+			corrupt:   truncate(4),
+		},
+		{
+			name:      "failure message",
+			prefix:    htlcFailInfoKey,
+			attemptID: 0,
+			corrupt:   invalidFailure,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, err := MakeTestDB(t)
+			require.NoError(t, err)
+
+			pControl := NewPaymentControl(db)
+			hash := createSettledPaymentWithFailedAttempt(
+				t, pControl,
+			)
+
+			var aid [8]byte
+			binary.BigEndian.PutUint64(aid[:], tc.attemptID)
+			key := htlcBucketKey(tc.prefix, aid[:])
+
+			raw := corruptHTLCRecord(t, db, hash, key, tc.corrupt)
+
+			// The payment can still be read, with the corrupt
+			// attempt quarantined and its status preserved.
+			payment, err := pControl.FetchPayment(hash)
+			require.NoError(t, err)
+			require.Equal(t, StatusSucceeded, payment.Status)
+			require.Len(t, payment.HTLCs, 2)
+
+			for _, htlc := range payment.HTLCs {
+				if htlc.AttemptID != tc.attemptID {
+					require.Nil(t, htlc.Corrupt)
+					continue
+				}
+
+				require.NotNil(t, htlc.Corrupt)
+				require.Error(t, htlc.Corrupt.Err)
+				require.Equal(
+					t, map[string][]byte{string(key): raw},
+					htlc.Corrupt.Records,
+				)
+			}
+			require.NotNil(t, payment.HTLCs[0].Failure)
+			require.NotNil(t, payment.HTLCs[1].Settle)
+
+			// Queries proceed as well.
+			query := PaymentsQuery{
+				MaxPayments:       math.MaxUint64,
+				IncludeIncomplete: true,
+			}
+			resp, err := db.QueryPayments(ctx, query)
+			require.NoError(t, err)
+			require.Len(t, resp.Payments, 1)
+
+			// The quarantined attempt is listed with its raw data.
+			quarantined, err := db.FetchQuarantinedHTLCs()
+			require.NoError(t, err)
+			require.Len(t, quarantined, 1)
+			require.Equal(t, hash, quarantined[0].PaymentIdentifier)
+			require.Equal(t, tc.attemptID, quarantined[0].AttemptID)
+			require.Equal(
+				t, raw,
+				quarantined[0].Corruption.Records[string(key)],
+			)
+
+			// In strict mode, reading the payment fails.
+			db.strictPaymentDecoding = true
+
+			_, err = pControl.FetchPayment(hash)
+			require.ErrorIs(t, err, ErrCorruptHTLCAttempt)
+
+			_, err = db.QueryPayments(ctx, query)
+			require.ErrorIs(t, err, ErrCorruptHTLCAttempt)
+
+			_, err = db.FetchPayments()
+			require.ErrorIs(t, err, ErrCorruptHTLCAttempt)
+		})
+	}
+}
+
+// TestUnreadableFailureMessage tests that an htlc failure whose message can't
+// be decoded is read with the HTLCFailUnreadable reason and its other details,
+// while the record is quarantined.
+func TestUnreadableFailureMessage(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+	hash := createSettledPaymentWithFailedAttempt(t, pControl)
+
+	// Store a fail info of the failed attempt with a message consisting of
+	// an unknown failure code only.
+	failTime := time.Unix(1, 0)
+	unknownMessage := func(t *testing.T, _ []byte) []byte {
+		var b bytes.Buffer
+		require.NoError(t, serializeTime(&b, failTime))
+		require.NoError(t, wire.WriteVarBytes(&b, 0, []byte{0, 1}))
+		require.NoError(t, WriteElements(
+			&b, byte(HTLCFailMessage), uint32(2),
+		))
+
+		return b.Bytes()
+	}
+
+	var aid [8]byte
+	key := htlcBucketKey(htlcFailInfoKey, aid[:])
+	corruptHTLCRecord(t, db, hash, key, unknownMessage)
+
+	payment, err := pControl.FetchPayment(hash)
+	require.NoError(t, err)
+	require.Equal(t, StatusSucceeded, payment.Status)
+
+	failed := payment.HTLCs[0]
+	require.NotNil(t, failed.Corrupt)
+	require.ErrorIs(t, failed.Corrupt.Err, errUnreadableFailureMessage)
+
+	require.Equal(t, &HTLCFailInfo{
+		FailTime:           failTime,
+		Reason:             HTLCFailUnreadable,
+		FailureSourceIndex: 2,
+	}, failed.Failure)
+}
+
+// TestQuarantinedInFlightAttempt tests that a quarantined in-flight attempt is
+// assumed to use up the rest of the payment amount, as its actual amount is
+// unknown.
+func TestQuarantinedInFlightAttempt(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+	hash, _, ids := registerShards(t, pControl, 2)
+
+	var aid [8]byte
+	binary.BigEndian.PutUint64(aid[:], ids[0])
+	key := htlcBucketKey(htlcAttemptInfoKey, aid[:])
+
+	truncate := func(t *testing.T, v []byte) []byte {
+		return v[:60]
+	}
+	corruptHTLCRecord(t, db, hash, key, truncate)
+
+	payment, err := pControl.FetchPayment(hash)
+	require.NoError(t, err)
+	require.Equal(t, StatusInFlight, payment.Status)
+	require.NotNil(t, payment.HTLCs[0].Corrupt)
+	require.Equal(t, 2, payment.State.NumAttemptsInFlight)
+	require.Zero(t, payment.State.RemainingAmt)
+
+	// No more attempts can be registered for the payment.
+	_, attempt, _, err := genInfo()
+	require.NoError(t, err)
+	attempt.AttemptID = 2
+
+	_, err = pControl.RegisterAttempt(hash, attempt)
+	require.ErrorIs(t, err, ErrCorruptHTLCAttempt)
+}
+
+// createSettledPaymentWithFailedAttempt creates a succeeded payment with a
+// failed attempt with ID 0 and a settled attempt with ID 1.
+func createSettledPaymentWithFailedAttempt(t *testing.T,
+	pControl *PaymentControl) lntypes.Hash {
+
+	info, attempt, preimg, err := genInfo()
+	require.NoError(t, err)
+
+	hash := info.PaymentIdentifier
+	require.NoError(t, pControl.InitPayment(hash, info))
+
+	attempt.AttemptID = 0
+	_, err = pControl.RegisterAttempt(hash, attempt)
+	require.NoError(t, err)
+
+	_, _, err = pControl.FailAttempt(hash, 0, &HTLCFailInfo{
+		Reason: HTLCFailUnreadable,
+	})
+	require.NoError(t, err)
+
+	attempt.AttemptID = 1
+	_, err = pControl.RegisterAttempt(hash, attempt)
+	require.NoError(t, err)
+
+	_, _, err = pControl.SettleAttempt(hash, 1, &HTLCSettleInfo{
+		Preimage: preimg,
+	})
+	require.NoError(t, err)
+
+	return hash
+}
+
+// corruptHTLCRecord overwrites the htlc record stored under the given key of
+// the payment with the output of corrupt, returning the corrupted value.
+func corruptHTLCRecord(t *testing.T, db *DB, hash lntypes.Hash, key []byte,
+	corrupt func(*testing.T, []byte) []byte) []byte {
+
+	var raw []byte
+	err := kvdb.Update(db, func(tx kvdb.RwTx) error {
+		payments := tx.ReadWriteBucket(paymentsRootBucket)
+		htlcs := payments.NestedReadWriteBucket(hash[:]).
+			NestedReadWriteBucket(paymentHtlcsBucket)
+
+		v := htlcs.Get(key)
+		require.NotNil(t, v)
+
+		raw = corrupt(t, copyBytes(v))
+
+		return htlcs.Put(key, raw)
+	}, func() {
+		raw = nil
+	})
+	require.NoError(t, err)
+
+	return raw
+}
@@ -0,0 +1,148 @@
+package channeldb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/lightningnetwork/lnd/kvdb"
+)
+
+// PaymentStats holds the number of stored payments per status.
+type PaymentStats struct {
+	// Initiated is the number of payments that haven't made any attempt
+	// yet.
+	Initiated uint64
+
+	// InFlight is the number of payments with attempts in flight.
+	InFlight uint64
+
+	// Succeeded is the number of succeeded payments.
+	Succeeded uint64
+
+	// Failed is the number of failed payments.
+	Failed uint64
+}
+
+// Total returns the number of payments across all statuses.
+func (s *PaymentStats) Total() uint64 {
+	return s.Initiated + s.InFlight + s.Succeeded + s.Failed
+}
+
+// add counts a payment with the given status.
+func (s *PaymentStats) add(status PaymentStatus) error {
+	switch status {
+	case StatusInitiated:
+		s.Initiated++
+
+	case StatusInFlight:
+		s.InFlight++
+
+	case StatusSucceeded:
+		s.Succeeded++
+
+	case StatusFailed:
+		s.Failed++
+
+	default:
+		return fmt.Errorf("%w: %v", ErrUnknownPaymentStatus, status)
+	}
+
+	return nil
+}
+
+// FetchPaymentStats counts the stored payments per status. If a creation date
+// filter, expressed in Unix seconds, is set, only the payments created within
+// the range are counted, with the same semantics as the filter of
+// QueryPayments. The status and creation time of each payment are read
+// without decoding its attempts, so this is much cheaper than querying the
+// payments, although all of them are still visited.
+//
+// The count is aborted with an error wrapping the context's error if the
+// context is canceled while the payments are scanned.
+func (d *DB) FetchPaymentStats(ctx context.Context, creationDateStart,
+	creationDateEnd int64) (*PaymentStats, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	query := PaymentsQuery{
+		CreationDateStart: creationDateStart,
+		CreationDateEnd:   creationDateEnd,
+	}
+	if err := query.validateCreationDateRange(); err != nil {
+		return nil, err
+	}
+
+	var stats PaymentStats
+	err := kvdb.View(d, func(tx kvdb.RTx) error {
+		indexes := tx.ReadBucket(paymentsIndexBucket)
+		if indexes == nil {
+			return nil
+		}
+
+		checkCancel := newScanCancelCheck(ctx)
+		return indexes.ForEach(func(sequenceKey, hash []byte) error {
+			if err := checkCancel(); err != nil {
+				return err
+			}
+
+			paymentHash, err := deserializePaymentIndex(
+				bytes.NewReader(hash),
+			)
+			if err != nil {
+				return err
+			}
+
+			bucket, err := fetchPaymentBucket(tx, paymentHash)
+			if err != nil {
+				return err
+			}
+
+			// Legacy duplicate payments share the bucket of their
+			// payment and are rare, so they're fully fetched.
+			seqBytes := bucket.Get(paymentSequenceKey)
+			if !bytes.Equal(seqBytes, sequenceKey) {
+				payment, err := fetchPaymentWithSequenceNumber(
+					tx, paymentHash, sequenceKey, true,
+				)
+				if err != nil {
+					return err
+				}
+
+				if !query.inCreationDateRange(payment) {
+					return nil
+				}
+
+				return stats.add(payment.Status)
+			}
+
+			if query.hasCreationDateFilter() {
+				info, err := fetchCreationInfo(bucket)
+				if err != nil {
+					return err
+				}
+
+				payment := &MPPayment{Info: info}
+				if !query.inCreationDateRange(payment) {
+					return nil
+				}
+			}
+
+			status, err := fetchPaymentStatus(bucket)
+			if err != nil {
+				return err
+			}
+
+			return stats.add(status)
+		})
+	}, func() {
+		stats = PaymentStats{}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
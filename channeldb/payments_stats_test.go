@@ -0,0 +1,87 @@
+package channeldb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestFetchPaymentStats tests that the payments are counted per status, and
+// that only the payments created within the given range are counted.
+func TestFetchPaymentStats(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+
+	// An empty database has no payments to count.
+	stats, err := db.FetchPaymentStats(ctx, 0, 0)
+	require.NoError(t, err)
+	require.Zero(t, stats.Total())
+
+	early, late := time.Unix(1000, 0), time.Unix(2000, 0)
+	payments := []*payment{
+		{status: StatusSucceeded, creationTime: early},
+		{status: StatusFailed, creationTime: early},
+		{status: StatusFailed, creationTime: late},
+		{status: StatusInFlight, creationTime: late},
+		{status: StatusSucceeded, creationTime: late},
+	}
+	createTestPayments(t, pControl, payments)
+
+	// A payment without attempts is counted as initiated.
+	info, _, preimg, err := genInfo()
+	require.NoError(t, err)
+	info.CreationTime = late
+	require.NoError(t, pControl.InitPayment(info.PaymentIdentifier, info))
+
+	stats, err = db.FetchPaymentStats(ctx, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, &PaymentStats{
+		Initiated: 1,
+		InFlight:  1,
+		Succeeded: 2,
+		Failed:    2,
+	}, stats)
+	require.EqualValues(t, 6, stats.Total())
+
+	// Only the early payments are within the first range, and only the
+	// late ones are within the second.
+	stats, err = db.FetchPaymentStats(ctx, 0, early.Unix())
+	require.NoError(t, err)
+	require.Equal(t, &PaymentStats{Succeeded: 1, Failed: 1}, stats)
+
+	stats, err = db.FetchPaymentStats(ctx, late.Unix(), 0)
+	require.NoError(t, err)
+	require.Equal(t, &PaymentStats{
+		Initiated: 1,
+		InFlight:  1,
+		Succeeded: 1,
+		Failed:    1,
+	}, stats)
+
+	// Legacy duplicate payments are counted as well.
+	payment, err := pControl.FetchPayment(info.PaymentIdentifier)
+	require.NoError(t, err)
+	appendDuplicatePayment(
+		t, db, info.PaymentIdentifier, payment.SequenceNum+100, preimg,
+	)
+
+	stats, err = db.FetchPaymentStats(ctx, 0, 0)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, stats.Succeeded)
+	require.EqualValues(t, 7, stats.Total())
+
+	// The count is aborted if the context is canceled.
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	_, err = db.FetchPaymentStats(canceled, 0, 0)
+	require.ErrorIs(t, err, context.Canceled)
+}
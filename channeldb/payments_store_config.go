@@ -0,0 +1,144 @@
+package channeldb
+
+import (
+	"fmt"
+	"time"
+)
+
+// PaymentsStoreConfig holds the options the payments store runs with, after
+// the defaults and all option modifiers were applied. It's collected when the
+// database is created, so it can be reported when diagnosing a node remotely.
+type PaymentsStoreConfig struct {
+	// KeepFailedPaymentAttempts is true if failed htlc attempts are kept
+	// on disk.
+	KeepFailedPaymentAttempts bool `json:"keep_failed_payment_attempts"`
+
+	// MaxInFlightAttempts is the maximum number of in-flight htlc attempts
+	// a single payment may have. Zero means no limit.
+	MaxInFlightAttempts uint32 `json:"max_in_flight_attempts"`
+
+	// ArchiveDeletedPayments is true if deleted payments are moved to the
+	// payments archive.
+	ArchiveDeletedPayments bool `json:"archive_deleted_payments"`
+
+	// StrictPaymentDecoding is true if reading a payment with an attempt
+	// that can't be parsed fails instead of quarantining the attempt.
+	StrictPaymentDecoding bool `json:"strict_payment_decoding"`
+
+	// ReuseSequenceOnRetry is true if a payment that is initiated again
+	// after it failed keeps its sequence number.
+	ReuseSequenceOnRetry bool `json:"reuse_sequence_on_retry"`
+
+	// StoreAttemptRoutes is true if the serialized route of every htlc
+	// attempt is stored separately for debugging.
+	StoreAttemptRoutes bool `json:"store_attempt_routes"`
+
+	// SkipPaymentRequests is true if the payment request of new payments
+	// isn't stored.
+	SkipPaymentRequests bool `json:"skip_payment_requests"`
+
+	// PaymentCacheSize is the maximum number of in-flight payments kept in
+	// the in-memory payment cache. Zero means the cache is disabled.
+	PaymentCacheSize int `json:"payment_cache_size"`
+
+	// DeleteBatchSize is the maximum number of payments that are deleted
+	// in a single database transaction.
+	DeleteBatchSize int `json:"delete_batch_size"`
+
+	// MaxQueryPayments is the maximum number of payments returned by a
+	// single payments query.
+	MaxQueryPayments uint64 `json:"max_query_payments"`
+
+	// QueryTimeout bounds the read transactions of payments queries whose
+	// context has no deadline. Zero means no timeout.
+	QueryTimeout time.Duration `json:"query_timeout"`
+
+	// Backend is the backend payments are written to.
+	Backend string `json:"backend"`
+
+	// ForceBackend is true if the database was opened with the configured
+	// backend, even if payments were recorded to be written to another
+	// one.
+	ForceBackend bool `json:"force_backend"`
+
+	// DefaultPageSize is the number of payments returned by a payments
+	// query that doesn't set a maximum.
+	DefaultPageSize uint64 `json:"default_page_size"`
+
+	// PaymentSeqBlockSize is the number of payment sequence numbers that
+	// are reserved in the database at once.
+	PaymentSeqBlockSize uint64 `json:"payment_seq_block_size"`
+
+	// AttemptIDBlockSize is the number of htlc attempt IDs that are
+	// reserved in the database at once.
+	AttemptIDBlockSize uint64 `json:"attempt_id_block_size"`
+
+	// DBVersion is the schema version of the database when it was opened,
+	// after any migrations were applied.
+	DBVersion uint32 `json:"db_version"`
+
+	// LatestDBVersion is the schema version this build of lnd migrates
+	// the database to.
+	LatestDBVersion uint32 `json:"latest_db_version"`
+}
+
+// newPaymentsStoreConfig collects the payments store config from the given
+// resolved options and the schema version of the database.
+func newPaymentsStoreConfig(opts *Options,
+	dbVersion uint32) *PaymentsStoreConfig {
+
+	return &PaymentsStoreConfig{
+		KeepFailedPaymentAttempts: opts.keepFailedPaymentAttempts,
+		MaxInFlightAttempts:       opts.maxInFlightAttempts,
+		ArchiveDeletedPayments:    opts.archiveDeletedPayments,
+		StrictPaymentDecoding:     opts.strictPaymentDecoding,
+		ReuseSequenceOnRetry:      opts.reuseSequenceOnRetry,
+		StoreAttemptRoutes:        opts.storeAttemptRoutes,
+		SkipPaymentRequests:       opts.skipPaymentRequests,
+		PaymentCacheSize:          opts.paymentCacheSize,
+		DeleteBatchSize:           opts.paymentsDeleteBatchSize,
+		MaxQueryPayments:          opts.maxQueryPayments,
+		QueryTimeout:              opts.paymentsQueryTimeout,
+		Backend:                   opts.paymentsBackend.String(),
+		ForceBackend:              opts.forcePaymentsBackend,
+		DefaultPageSize:           defaultPaymentsPageSize,
+		PaymentSeqBlockSize:       paymentSeqBlockSize,
+		AttemptIDBlockSize:        attemptIDBlockSize,
+		DBVersion:                 dbVersion,
+		LatestDBVersion:           LatestDBVersion(),
+	}
+}
+
+// ToMap returns the config as a flat map, keyed by the json names of its
+// fields.
+func (c *PaymentsStoreConfig) ToMap() map[string]string {
+	return map[string]string{
+		"keep_failed_payment_attempts": fmt.Sprint(
+			c.KeepFailedPaymentAttempts,
+		),
+		"max_in_flight_attempts": fmt.Sprint(c.MaxInFlightAttempts),
+		"archive_deleted_payments": fmt.Sprint(
+			c.ArchiveDeletedPayments,
+		),
+		"strict_payment_decoding": fmt.Sprint(c.StrictPaymentDecoding),
+		"reuse_sequence_on_retry": fmt.Sprint(c.ReuseSequenceOnRetry),
+		"store_attempt_routes":    fmt.Sprint(c.StoreAttemptRoutes),
+		"skip_payment_requests":   fmt.Sprint(c.SkipPaymentRequests),
+		"payment_cache_size":      fmt.Sprint(c.PaymentCacheSize),
+		"delete_batch_size":       fmt.Sprint(c.DeleteBatchSize),
+		"max_query_payments":      fmt.Sprint(c.MaxQueryPayments),
+		"query_timeout":           c.QueryTimeout.String(),
+		"backend":                 c.Backend,
+		"force_backend":           fmt.Sprint(c.ForceBackend),
+		"default_page_size":       fmt.Sprint(c.DefaultPageSize),
+		"payment_seq_block_size":  fmt.Sprint(c.PaymentSeqBlockSize),
+		"attempt_id_block_size":   fmt.Sprint(c.AttemptIDBlockSize),
+		"db_version":              fmt.Sprint(c.DBVersion),
+		"latest_db_version":       fmt.Sprint(c.LatestDBVersion),
+	}
+}
+
+// PaymentsStoreConfig returns the options the payments store runs with.
+func (d *DB) PaymentsStoreConfig() PaymentsStoreConfig {
+	return *d.paymentsStoreConfig
+}
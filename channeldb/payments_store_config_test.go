@@ -0,0 +1,55 @@
+package channeldb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestPaymentsStoreConfig checks that the payments store config reflects the
+// options the database was created with, and that every one of its fields is
+// reported, so new options can't be left out silently.
+func TestPaymentsStoreConfig(t *testing.T) {
+	t.Parallel()
+
+	// Set all payment options to non-default values.
+	db, err := MakeTestDB(
+		t, OptionKeepFailedPaymentAttempts(true),
+		OptionMaxInFlightAttempts(5),
+		OptionArchiveDeletedPayments(true),
+		OptionStrictPaymentDecoding(true),
+		OptionReuseSequenceOnRetry(true),
+		OptionStoreAttemptRoutes(true),
+		OptionSkipPaymentRequests(true),
+		OptionPaymentCacheSize(10),
+		OptionPaymentsDeleteBatchSize(50),
+		OptionMaxQueryPayments(100),
+		OptionPaymentsQueryTimeout(time.Minute),
+		OptionForcePaymentsBackend(true),
+	)
+	require.NoError(t, err)
+
+	cfg := db.PaymentsStoreConfig()
+	require.EqualValues(t, 5, cfg.MaxInFlightAttempts)
+	require.Equal(t, LatestDBVersion(), cfg.DBVersion)
+
+	// Every field must be populated and show up in the flat map under its
+	// json name.
+	flat := cfg.ToMap()
+	v := reflect.ValueOf(cfg)
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+
+		require.Falsef(t, v.Field(i).IsZero(), "field %v not set",
+			field.Name)
+
+		key := field.Tag.Get("json")
+		require.NotEmptyf(t, key, "field %v has no json name",
+			field.Name)
+		require.Containsf(t, flat, key, "field %v not reported",
+			field.Name)
+	}
+	require.Len(t, flat, v.NumField())
+}
@@ -2,9 +2,15 @@ package channeldb
 
 import (
 	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"math"
 	"reflect"
+	"slices"
+	"sort"
 	"testing"
 	"time"
 
@@ -13,6 +19,7 @@ import (
 	"github.com/davecgh/go-spew/spew"
 	"github.com/lightningnetwork/lnd/kvdb"
 	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/lightningnetwork/lnd/lnwire"
 	"github.com/lightningnetwork/lnd/record"
 	"github.com/lightningnetwork/lnd/routing/route"
 	"github.com/stretchr/testify/require"
@@ -109,6 +116,7 @@ func makeFakeInfo() (*PaymentCreationInfo, *HTLCAttemptInfo) {
 		// failures due to the monotonic time component.
 		CreationTime:   time.Unix(time.Now().Unix(), 0),
 		PaymentRequest: []byte(""),
+		FeeLimit:       500,
 	}
 
 	a := NewHtlcAttempt(
@@ -143,7 +151,7 @@ func TestSentPaymentSerialization(t *testing.T) {
 		t.Fatalf("unable to serialize info: %v", err)
 	}
 
-	newWireInfo, err := deserializeHTLCAttemptInfo(&b)
+	newWireInfo, err := deserializeHTLCAttemptInfo(&b, false)
 	require.NoError(t, err, "unable to deserialize info")
 	newWireInfo.AttemptID = s.AttemptID
 
@@ -171,6 +179,141 @@ func TestSentPaymentSerialization(t *testing.T) {
 	}
 }
 
+// TestPaymentCreationInfoFeeLimit tests that the fee limit of a payment is
+// round-tripped, and that creation info stored without a fee limit is read
+// as not having one.
+func TestPaymentCreationInfoFeeLimit(t *testing.T) {
+	t.Parallel()
+
+	c, _ := makeFakeInfo()
+
+	var b bytes.Buffer
+	require.NoError(t, serializePaymentCreationInfo(&b, c))
+	serialized := b.Bytes()
+
+	info, err := deserializePaymentCreationInfo(bytes.NewReader(serialized))
+	require.NoError(t, err)
+	require.Equal(t, c, info)
+
+	// Creation info written before the fee limit was added ends right
+	// after the payment request.
+	legacy := serialized[:len(serialized)-8]
+	info, err = deserializePaymentCreationInfo(bytes.NewReader(legacy))
+	require.NoError(t, err)
+	require.Equal(t, NoFeeLimit, info.FeeLimit)
+
+	expected := *c
+	expected.FeeLimit = NoFeeLimit
+	require.Equal(t, &expected, info)
+
+	// A partially written fee limit is an error.
+	partial := serialized[:len(serialized)-4]
+	_, err = deserializePaymentCreationInfo(bytes.NewReader(partial))
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+// TestPaymentCreationInfoVersions tests that creation info is written with a
+// leading version byte followed by the unversioned layout, and that unknown
+// versions are rejected.
+func TestPaymentCreationInfoVersions(t *testing.T) {
+	t.Parallel()
+
+	var hash lntypes.Hash
+	for i := range hash {
+		hash[i] = byte(i + 1)
+	}
+	expected := &PaymentCreationInfo{
+		PaymentIdentifier: hash,
+		Value:             1000,
+		CreationTime:      time.Unix(1_700_000_000, 0),
+		PaymentRequest:    []byte("lnbc"),
+		FeeLimit:          500,
+	}
+
+	// The creation info above in the unversioned layout, as written by
+	// older versions of lnd.
+	v0, err := hex.DecodeString("0102030405060708090a0b0c0d0e0f101112" +
+		"131415161718191a1b1c1d1e1f2000000000000003e817979cfe362a0000" +
+		"000000046c6e626300000000000001f4")
+	require.NoError(t, err)
+
+	// New creation info is written with the version byte, followed by the
+	// unversioned layout.
+	var b bytes.Buffer
+	require.NoError(t, serializePaymentCreationInfo(&b, expected))
+
+	v1 := b.Bytes()
+	require.Equal(t, byte(paymentCreationInfoV1), v1[0])
+	require.Equal(t, v0, v1[1:])
+
+	info, err := deserializePaymentCreationInfo(bytes.NewReader(v1))
+	require.NoError(t, err)
+	require.Equal(t, expected, info)
+
+	// The same holds for an empty payment request.
+	noReq := *expected
+	noReq.PaymentRequest = []byte{}
+
+	b.Reset()
+	require.NoError(t, serializePaymentCreationInfo(&b, &noReq))
+
+	info, err = deserializePaymentCreationInfo(&b)
+	require.NoError(t, err)
+	require.Equal(t, &noReq, info)
+
+	// A version from the future is rejected instead of being misread.
+	future := append([]byte{paymentCreationInfoV1 + 1}, v0...)
+	_, err = deserializePaymentCreationInfo(bytes.NewReader(future))
+	require.ErrorIs(t, err, ErrUnknownCreationInfoVersion)
+
+	_, err = deserializePaymentCreationInfo(bytes.NewReader(nil))
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+// TestHTLCAttemptInfoChainFeeRate tests that the chain fee rate of an htlc
+// attempt is round-tripped, and that attempts stored without a fee rate are
+// read as having none.
+func TestHTLCAttemptInfoChainFeeRate(t *testing.T) {
+	t.Parallel()
+
+	_, a := makeFakeInfo()
+	a.ChainFeeRate = 2500
+
+	var b bytes.Buffer
+	require.NoError(t, serializeHTLCAttemptInfo(&b, a))
+	serialized := b.Bytes()
+
+	info, err := deserializeHTLCAttemptInfo(
+		bytes.NewReader(serialized), false,
+	)
+	require.NoError(t, err)
+	require.Equal(t, a.ChainFeeRate, info.ChainFeeRate)
+
+	summary, err := deserializeHTLCAttemptInfo(
+		bytes.NewReader(serialized), true,
+	)
+	require.NoError(t, err)
+	require.Equal(t, a.ChainFeeRate, summary.ChainFeeRate)
+
+	// Attempts without a fee rate end right after the hash, just like the
+	// ones written before the fee rate was added.
+	legacy := serialized[:len(serialized)-8]
+	info, err = deserializeHTLCAttemptInfo(bytes.NewReader(legacy), false)
+	require.NoError(t, err)
+	require.Zero(t, info.ChainFeeRate)
+	require.Equal(t, a.Hash, info.Hash)
+
+	a.ChainFeeRate = 0
+	b.Reset()
+	require.NoError(t, serializeHTLCAttemptInfo(&b, a))
+	require.Equal(t, legacy, b.Bytes())
+
+	// A partially written fee rate is an error.
+	partial := serialized[:len(serialized)-4]
+	_, err = deserializeHTLCAttemptInfo(bytes.NewReader(partial), false)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
 // assertRouteEquals compares to routes for equality and returns an error if
 // they are not equal.
 func assertRouteEqual(a, b *route.Route) error {
@@ -182,6 +325,97 @@ func assertRouteEqual(a, b *route.Route) error {
 	return nil
 }
 
+// TestQueryPaymentsInvalidDateRange tests that payments can't be queried or
+// counted with a negative creation date or an inverted date range, while a
+// range of a single second is allowed.
+func TestQueryPaymentsInvalidDateRange(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+
+	created := time.Unix(1000, 0)
+	payments := []*payment{
+		{status: StatusSucceeded, creationTime: created},
+	}
+	createTestPayments(t, pControl, payments)
+
+	tests := []struct {
+		name      string
+		start     int64
+		end       int64
+		expectErr bool
+		expected  int
+	}{
+		{
+			name:      "inverted range",
+			start:     created.Unix() + 1,
+			end:       created.Unix(),
+			expectErr: true,
+		},
+		{
+			name:      "negative start",
+			start:     -1,
+			expectErr: true,
+		},
+		{
+			name:      "negative end",
+			end:       -1,
+			expectErr: true,
+		},
+		{
+			name:     "single second",
+			start:    created.Unix(),
+			end:      created.Unix(),
+			expected: 1,
+		},
+		{
+			name:     "open end",
+			start:    created.Unix() + 1,
+			expected: 0,
+		},
+	}
+
+	for _, test := range tests {
+		query := PaymentsQuery{
+			MaxPayments:       math.MaxUint64,
+			IncludeIncomplete: true,
+			CreationDateStart: test.start,
+			CreationDateEnd:   test.end,
+		}
+
+		resp, err := db.QueryPayments(ctx, query)
+		stats, statsErr := db.FetchPaymentStats(
+			ctx, test.start, test.end,
+		)
+		_, archiveErr := db.QueryArchivedPayments(query)
+
+		if test.expectErr {
+			require.ErrorIs(t, err, ErrInvalidDateRange, test.name)
+			require.ErrorIs(
+				t, statsErr, ErrInvalidDateRange, test.name,
+			)
+			require.ErrorIs(
+				t, archiveErr, ErrInvalidDateRange, test.name,
+			)
+
+			continue
+		}
+
+		require.NoError(t, err, test.name)
+		require.Len(t, resp.Payments, test.expected, test.name)
+
+		require.NoError(t, statsErr, test.name)
+		require.EqualValues(t, test.expected, stats.Total(), test.name)
+
+		require.NoError(t, archiveErr, test.name)
+	}
+}
+
 // TestRouteSerialization tests serialization of a regular and blinded route.
 func TestRouteSerialization(t *testing.T) {
 	t.Parallel()
@@ -190,6 +424,68 @@ func TestRouteSerialization(t *testing.T) {
 	testSerializeRoute(t, testBlindedRoute)
 }
 
+// TestRouteFeesRoundTrip checks that the fees of a stored route, both in
+// total and per hop, match the fees of the route that was registered. The
+// summary of the route that is read when hops are skipped still yields the
+// total fee.
+func TestRouteFeesRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+
+	info, attempt, _, err := genInfo()
+	require.NoError(t, err)
+
+	// Make the first two hops charge different fees for forwarding the
+	// payment through a 3-hop route.
+	rt := attempt.Route.Copy()
+	rt.Hops[0].AmtToForward = info.Value + 30
+	rt.Hops[1].AmtToForward = info.Value
+	rt.Hops[2].AmtToForward = info.Value
+	rt.TotalAmount = info.Value + 50
+	attempt.Route = *rt
+
+	require.Len(t, rt.Hops, 3)
+	require.Equal(t, []lnwire.MilliSatoshi{20, 30, 0}, rt.PerHopFees())
+
+	hash := info.PaymentIdentifier
+	require.NoError(t, pControl.InitPayment(hash, info))
+	_, err = pControl.RegisterAttempt(hash, attempt)
+	require.NoError(t, err)
+
+	payment, err := pControl.FetchPayment(hash)
+	require.NoError(t, err)
+	require.Len(t, payment.HTLCs, 1)
+
+	stored := payment.HTLCs[0].Route
+	require.Equal(t, rt.TotalFees(), stored.TotalFees())
+	require.Equal(t, rt.PerHopFees(), stored.PerHopFees())
+	for i, hop := range rt.Hops {
+		storedHop := stored.Hops[i]
+		require.Equal(t, hop.AmtToForward, storedHop.AmtToForward)
+		require.Equal(
+			t, hop.OutgoingTimeLock, storedHop.OutgoingTimeLock,
+		)
+	}
+
+	resp, err := db.QueryPayments(ctx, PaymentsQuery{
+		MaxPayments:       1,
+		IncludeIncomplete: true,
+		SkipHops:          true,
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Payments, 1)
+
+	summary := resp.Payments[0].HTLCs[0].Route
+	require.Equal(t, rt.TotalFees(), summary.TotalFees())
+	require.Equal(t, rt.ReceiverAmt(), summary.ReceiverAmt())
+}
+
 func testSerializeRoute(t *testing.T, route route.Route) {
 	var b bytes.Buffer
 	err := SerializeRoute(&b, route)
@@ -232,6 +528,8 @@ func deletePayment(t *testing.T, db *DB, paymentHash lntypes.Hash, seqNr uint64)
 // TestQueryPayments tests retrieval of payments with forwards and reversed
 // queries.
 func TestQueryPayments(t *testing.T) {
+	ctx := context.Background()
+
 	// Define table driven test for QueryPayments.
 	// Test payments have sequence indices [1, 3, 4, 5, 6, 7].
 	// Note that the payment with index 7 has the same payment hash as 6,
@@ -486,7 +784,7 @@ func TestQueryPayments(t *testing.T) {
 
 			// Make a preliminary query to make sure it's ok to
 			// query when we have no payments.
-			resp, err := db.QueryPayments(tt.query)
+			resp, err := db.QueryPayments(ctx, tt.query)
 			require.NoError(t, err)
 			require.Len(t, resp.Payments, 0)
 
@@ -561,7 +859,7 @@ func TestQueryPayments(t *testing.T) {
 					len(allPayments), 6)
 			}
 
-			querySlice, err := db.QueryPayments(tt.query)
+			querySlice, err := db.QueryPayments(ctx, tt.query)
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
@@ -591,211 +889,2250 @@ func TestQueryPayments(t *testing.T) {
 	}
 }
 
-// TestFetchPaymentWithSequenceNumber tests lookup of payments with their
-// sequence number. It sets up one payment with no duplicates, and another with
-// two duplicates in its duplicates bucket then uses these payments to test the
-// case where a specific duplicate is not found and the duplicates bucket is not
-// present when we expect it to be.
-func TestFetchPaymentWithSequenceNumber(t *testing.T) {
+// TestQueryPaymentsCountTotalDateFilter tests that the total payment count
+// returned by QueryPayments only includes the payments created within the
+// queried time range if a creation date filter is set.
+func TestQueryPaymentsCountTotalDateFilter(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
 	db, err := MakeTestDB(t)
 	require.NoError(t, err)
 
 	pControl := NewPaymentControl(db)
 
-	// Generate a test payment which does not have duplicates.
-	noDuplicates, _, _, err := genInfo()
-	require.NoError(t, err)
+	// Create a number of payments on each of three consecutive days.
+	const (
+		numDays        = 3
+		paymentsPerDay = 4
+		day            = int64(24 * 60 * 60)
+	)
+	start := time.Unix(1_700_000_000, 0)
 
-	// Create a new payment entry in the database.
-	err = pControl.InitPayment(noDuplicates.PaymentIdentifier, noDuplicates)
-	require.NoError(t, err)
+	for d := 0; d < numDays; d++ {
+		for i := 0; i < paymentsPerDay; i++ {
+			info, _, _, err := genInfo()
+			require.NoError(t, err)
 
-	// Fetch the payment so we can get its sequence nr.
-	noDuplicatesPayment, err := pControl.FetchPayment(
-		noDuplicates.PaymentIdentifier,
-	)
-	require.NoError(t, err)
+			info.CreationTime = start.Add(
+				time.Duration(int64(d)*day+int64(i)) *
+					time.Second,
+			)
 
-	// Generate a test payment which we will add duplicates to.
-	hasDuplicates, _, preimg, err := genInfo()
+			err = pControl.InitPayment(
+				info.PaymentIdentifier, info,
+			)
+			require.NoError(t, err)
+		}
+	}
+
+	// Without a date filter, all payments are counted even though only
+	// a single payment is returned.
+	resp, err := db.QueryPayments(ctx, PaymentsQuery{
+		MaxPayments:       1,
+		IncludeIncomplete: true,
+		CountTotal:        true,
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Payments, 1)
+	require.EqualValues(t, numDays*paymentsPerDay, resp.TotalCount)
+
+	// Now query only the second day. The total count should only include
+	// the payments of that day.
+	dayStart := start.Unix() + day
+	resp, err = db.QueryPayments(ctx, PaymentsQuery{
+		MaxPayments:       1,
+		IncludeIncomplete: true,
+		CountTotal:        true,
+		CreationDateStart: dayStart,
+		CreationDateEnd:   dayStart + day - 1,
+	})
 	require.NoError(t, err)
+	require.Len(t, resp.Payments, 1)
+	require.EqualValues(t, paymentsPerDay, resp.TotalCount)
+
+	// An open-ended range starting at the second day should count the
+	// payments of the last two days.
+	resp, err = db.QueryPayments(ctx, PaymentsQuery{
+		MaxPayments:       math.MaxUint64,
+		IncludeIncomplete: true,
+		CountTotal:        true,
+		CreationDateStart: dayStart,
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Payments, 2*paymentsPerDay)
+	require.EqualValues(t, 2*paymentsPerDay, resp.TotalCount)
+}
 
-	// Create a new payment entry in the database.
-	err = pControl.InitPayment(hasDuplicates.PaymentIdentifier, hasDuplicates)
+// TestQueryPaymentsCountOnly tests that a query with CountTotal set and a zero
+// MaxPayments only counts the payments, without reading any of them.
+func TestQueryPaymentsCountOnly(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	// With strict decoding, reading a payment with a corrupt attempt
+	// fails, which tells us whether the query read the payment.
+	db, err := MakeTestDB(t, OptionStrictPaymentDecoding(true))
 	require.NoError(t, err)
 
-	// Fetch the payment so we can get its sequence nr.
-	hasDuplicatesPayment, err := pControl.FetchPayment(
-		hasDuplicates.PaymentIdentifier,
-	)
+	pControl := NewPaymentControl(db)
+	hash := createSettledPaymentWithFailedAttempt(t, pControl)
+
+	var aid [8]byte
+	key := htlcBucketKey(htlcAttemptInfoKey, aid[:])
+	truncate := func(_ *testing.T, v []byte) []byte {
+		return v[:16]
+	}
+	corruptHTLCRecord(t, db, hash, key, truncate)
+
+	// Add a few more intact payments.
+	const numPayments = 4
+	for i := 1; i < numPayments; i++ {
+		info, _, _, err := genInfo()
+		require.NoError(t, err)
+
+		err = pControl.InitPayment(info.PaymentIdentifier, info)
+		require.NoError(t, err)
+	}
+
+	// A count-only query succeeds and returns no payments.
+	resp, err := db.QueryPayments(ctx, PaymentsQuery{
+		IncludeIncomplete: true,
+		CountTotal:        true,
+	})
 	require.NoError(t, err)
+	require.Empty(t, resp.Payments)
+	require.EqualValues(t, numPayments, resp.TotalCount)
+	require.Zero(t, resp.FirstIndexOffset)
+	require.Zero(t, resp.LastIndexOffset)
+
+	// A query that returns the corrupt payment reads it and fails.
+	_, err = db.QueryPayments(ctx, PaymentsQuery{
+		MaxPayments:       1,
+		IncludeIncomplete: true,
+		CountTotal:        true,
+	})
+	require.Error(t, err)
+}
 
-	// We declare the sequence numbers used here so that we can reference
-	// them in tests.
-	var (
-		duplicateOneSeqNr = hasDuplicatesPayment.SequenceNum + 1
-		duplicateTwoSeqNr = hasDuplicatesPayment.SequenceNum + 2
-	)
+// TestQueryPaymentsHasMore tests that the HasMore flag of a payments query
+// response is only set if another matching payment exists after a full page,
+// including for page sizes that exactly divide the matching payments.
+func TestQueryPaymentsHasMore(t *testing.T) {
+	t.Parallel()
 
-	// Add two duplicates to our second payment.
-	appendDuplicatePayment(
-		t, db, hasDuplicates.PaymentIdentifier, duplicateOneSeqNr, preimg,
-	)
-	appendDuplicatePayment(
-		t, db, hasDuplicates.PaymentIdentifier, duplicateTwoSeqNr, preimg,
+	ctx := context.Background()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	// Create six succeeded payments with the sequence numbers one to six,
+	// followed by two in-flight payments.
+	const numSettled = 6
+	var payments []*payment
+	for i := 0; i < numSettled; i++ {
+		payments = append(payments, &payment{status: StatusSucceeded})
+	}
+	payments = append(
+		payments, &payment{status: StatusInFlight},
+		&payment{status: StatusInFlight},
 	)
+	createTestPayments(t, NewPaymentControl(db), payments)
 
 	tests := []struct {
-		name           string
-		paymentHash    lntypes.Hash
-		sequenceNumber uint64
-		expectedErr    error
+		name       string
+		query      PaymentsQuery
+		firstIndex uint64
+		lastIndex  uint64
+		hasMore    bool
 	}{
 		{
-			name:           "lookup payment without duplicates",
-			paymentHash:    noDuplicates.PaymentIdentifier,
-			sequenceNumber: noDuplicatesPayment.SequenceNum,
-			expectedErr:    nil,
+			name: "first of two exact pages",
+			query: PaymentsQuery{
+				MaxPayments: 3,
+			},
+			firstIndex: 1,
+			lastIndex:  3,
+			hasMore:    true,
 		},
 		{
-			name:           "lookup payment with duplicates",
-			paymentHash:    hasDuplicates.PaymentIdentifier,
-			sequenceNumber: hasDuplicatesPayment.SequenceNum,
-			expectedErr:    nil,
+			name: "second of two exact pages",
+			query: PaymentsQuery{
+				IndexOffset: 3,
+				MaxPayments: 3,
+			},
+			firstIndex: 4,
+			lastIndex:  6,
+			hasMore:    false,
 		},
 		{
-			name:           "lookup first duplicate",
-			paymentHash:    hasDuplicates.PaymentIdentifier,
-			sequenceNumber: duplicateOneSeqNr,
-			expectedErr:    nil,
+			name: "single exact page",
+			query: PaymentsQuery{
+				MaxPayments: numSettled,
+			},
+			firstIndex: 1,
+			lastIndex:  6,
+			hasMore:    false,
 		},
 		{
-			name:           "lookup second duplicate",
-			paymentHash:    hasDuplicates.PaymentIdentifier,
-			sequenceNumber: duplicateTwoSeqNr,
-			expectedErr:    nil,
+			name: "one short of all payments",
+			query: PaymentsQuery{
+				MaxPayments: numSettled - 1,
+			},
+			firstIndex: 1,
+			lastIndex:  5,
+			hasMore:    true,
 		},
 		{
-			name:           "lookup non-existent duplicate",
-			paymentHash:    hasDuplicates.PaymentIdentifier,
-			sequenceNumber: 999999,
-			expectedErr:    ErrDuplicateNotFound,
+			name: "final partial page",
+			query: PaymentsQuery{
+				IndexOffset: 4,
+				MaxPayments: 4,
+			},
+			firstIndex: 5,
+			lastIndex:  6,
+			hasMore:    false,
 		},
 		{
-			name:           "lookup duplicate, no duplicates bucket",
-			paymentHash:    noDuplicates.PaymentIdentifier,
-			sequenceNumber: duplicateTwoSeqNr,
-			expectedErr:    ErrNoDuplicateBucket,
+			name: "incomplete payments match",
+			query: PaymentsQuery{
+				MaxPayments:       numSettled,
+				IncludeIncomplete: true,
+			},
+			firstIndex: 1,
+			lastIndex:  6,
+			hasMore:    true,
+		},
+		{
+			name: "reversed first page",
+			query: PaymentsQuery{
+				MaxPayments: 3,
+				Reversed:    true,
+			},
+			firstIndex: 4,
+			lastIndex:  6,
+			hasMore:    true,
+		},
+		{
+			name: "reversed exact final page",
+			query: PaymentsQuery{
+				IndexOffset: 4,
+				MaxPayments: 3,
+				Reversed:    true,
+			},
+			firstIndex: 1,
+			lastIndex:  3,
+			hasMore:    false,
+		},
+		{
+			name: "unlimited",
+			query: PaymentsQuery{
+				MaxPayments: math.MaxUint64,
+			},
+			firstIndex: 1,
+			lastIndex:  6,
+			hasMore:    false,
 		},
 	}
 
 	for _, test := range tests {
-		test := test
-
 		t.Run(test.name, func(t *testing.T) {
-			err := kvdb.Update(
-				db, func(tx walletdb.ReadWriteTx) error {
-					var seqNrBytes [8]byte
-					byteOrder.PutUint64(
-						seqNrBytes[:], test.sequenceNumber,
-					)
+			resp, err := db.QueryPayments(ctx, test.query)
+			require.NoError(t, err)
 
-					_, err := fetchPaymentWithSequenceNumber(
-						tx, test.paymentHash, seqNrBytes[:],
-					)
-					return err
-				}, func() {},
+			require.Equal(t, test.firstIndex, resp.FirstIndexOffset)
+			require.Equal(t, test.lastIndex, resp.LastIndexOffset)
+			require.EqualValues(
+				t, test.lastIndex-test.firstIndex+1,
+				len(resp.Payments),
 			)
-			require.Equal(t, test.expectedErr, err)
+			require.Equal(t, test.hasMore, resp.HasMore)
 		})
 	}
 }
 
-// appendDuplicatePayment adds a duplicate payment to an existing payment. Note
-// that this function requires a unique sequence number.
-//
-// This code is *only* intended to replicate legacy duplicate payments in lnd,
+// TestQueryPaymentsMaxQueryPayments tests that the number of payments a query
+// asks for is clamped to the configured maximum, and that the remaining
+// payments can still be paged through.
+func TestQueryPaymentsMaxQueryPayments(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	const maxQueryPayments = 3
+	db, err := MakeTestDB(t, OptionMaxQueryPayments(maxQueryPayments))
+	require.NoError(t, err)
+
+	payments := make([]*payment, 5)
+	for i := range payments {
+		payments[i] = &payment{status: StatusSucceeded}
+	}
+	createTestPayments(t, NewPaymentControl(db), payments)
+
+	// A huge page is clamped to the maximum, and the response signals
+	// that there are more payments.
+	resp, err := db.QueryPayments(ctx, PaymentsQuery{
+		MaxPayments: math.MaxUint64,
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Payments, maxQueryPayments)
+	require.True(t, resp.HasMore)
+
+	// The rest of the payments fit within the maximum.
+	resp, err = db.QueryPayments(ctx, PaymentsQuery{
+		IndexOffset: resp.LastIndexOffset,
+		MaxPayments: math.MaxUint64,
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Payments, len(payments)-maxQueryPayments)
+	require.False(t, resp.HasMore)
+
+	// Iterating with a page size above the maximum still visits all
+	// payments.
+	var numPayments int
+	err = db.ForEachPayment(ctx, PaymentsQuery{
+		MaxPayments: math.MaxUint64,
+	}, func(*MPPayment) error {
+		numPayments++
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, len(payments), numPayments)
+}
+
+// TestQueryPaymentsPagesMatchUnpaged tests that paginating through the
+// payments in either direction, with any page size, returns exactly the
+// payments of a single unpaged query. A gap in the sequence numbers and a
+// creation date filter that excludes payments at both ends make sure the
+// index offsets are always resumed from strictly.
+func TestQueryPaymentsPagesMatchUnpaged(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+
+	const numPayments = 8
+	start := time.Unix(1_700_000_000, 0)
+
+	var hashes []lntypes.Hash
+	for i := 0; i < numPayments; i++ {
+		info, _, _, err := genInfo()
+		require.NoError(t, err)
+
+		info.CreationTime = start.Add(time.Duration(i) * time.Second)
+
+		err = pControl.InitPayment(info.PaymentIdentifier, info)
+		require.NoError(t, err)
+
+		hashes = append(hashes, info.PaymentIdentifier)
+	}
+
+	// Delete a payment in the middle to leave a gap in the sequence
+	// numbers.
+	_, err = db.DeletePayment(hashes[4], false)
+	require.NoError(t, err)
+
+	// Exclude the first and the last payment by their creation date.
+	baseQuery := PaymentsQuery{
+		IncludeIncomplete: true,
+		CreationDateStart: start.Unix() + 1,
+		CreationDateEnd:   start.Unix() + numPayments - 2,
+	}
+
+	unpagedQuery := baseQuery
+	unpagedQuery.MaxPayments = math.MaxUint64
+	unpaged, err := db.QueryPayments(ctx, unpagedQuery)
+	require.NoError(t, err)
+	require.Len(t, unpaged.Payments, numPayments-3)
+
+	var want []lntypes.Hash
+	for _, p := range unpaged.Payments {
+		want = append(want, p.Info.PaymentIdentifier)
+	}
+
+	for pageSize := uint64(1); pageSize <= numPayments; pageSize++ {
+		for _, reversed := range []bool{false, true} {
+			query := baseQuery
+			query.MaxPayments = pageSize
+			query.Reversed = reversed
+
+			var got []lntypes.Hash
+			for i := 0; ; i++ {
+				require.Less(t, i, numPayments, "no progress")
+
+				resp, err := db.QueryPayments(ctx, query)
+				require.NoError(t, err)
+
+				if len(resp.Payments) == 0 {
+					break
+				}
+				require.LessOrEqual(
+					t, uint64(len(resp.Payments)), pageSize,
+				)
+
+				var page []lntypes.Hash
+				for _, p := range resp.Payments {
+					page = append(
+						page, p.Info.PaymentIdentifier,
+					)
+				}
+
+				// Pages are returned in ascending order, so
+				// reversed pages are prepended.
+				offset := resp.LastIndexOffset
+				if reversed {
+					got = append(page, got...)
+					offset = resp.FirstIndexOffset
+				} else {
+					got = append(got, page...)
+				}
+				query.IndexOffset = offset
+			}
+
+			require.Equal(
+				t, want, got, "page size %v, reversed %v",
+				pageSize, reversed,
+			)
+		}
+	}
+}
+
+// TestFetchPaymentsByHopPubKey tests that payments can be looked up by the
+// nodes their htlc attempts were routed through, including intermediate hops
+// and the hops of failed attempts.
+func TestFetchPaymentsByHopPubKey(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+
+	var (
+		nodeA = route.Vertex{1}
+		nodeB = route.Vertex{2}
+		nodeC = route.Vertex{3}
+		nodeD = route.Vertex{4}
+		nodeE = route.Vertex{5}
+		nodeF = route.Vertex{6}
+	)
+
+	// makeRoute creates a route for the full payment amount through the
+	// given nodes, the last one being the destination.
+	makeRoute := func(amt lnwire.MilliSatoshi,
+		nodes ...route.Vertex) route.Route {
+
+		hops := make([]*route.Hop, 0, len(nodes))
+		for i, node := range nodes {
+			hops = append(hops, &route.Hop{
+				PubKeyBytes:      node,
+				ChannelID:        uint64(i + 1),
+				OutgoingTimeLock: 100,
+				AmtToForward:     amt,
+			})
+		}
+
+		return route.Route{
+			TotalTimeLock: 100,
+			TotalAmount:   amt,
+			SourcePubKey:  vertex,
+			Hops:          hops,
+		}
+	}
+
+	// Create the following payments, each htlc attempt listed with the
+	// nodes it was routed through:
+	//   1. A -> B -> C
+	//   2. D -> C
+	//   3. A -> E
+	//   4. D -> B (failed), E
+	paymentRoutes := [][][]route.Vertex{
+		{{nodeA, nodeB, nodeC}},
+		{{nodeD, nodeC}},
+		{{nodeA, nodeE}},
+		{{nodeD, nodeB}, {nodeE}},
+	}
+
+	var seqNrs []uint64
+	for _, attemptRoutes := range paymentRoutes {
+		info, attempt, _, err := genInfo()
+		require.NoError(t, err)
+
+		err = pControl.InitPayment(info.PaymentIdentifier, info)
+		require.NoError(t, err)
+
+		for i, nodes := range attemptRoutes {
+			a := *attempt
+			a.AttemptID = uint64(i)
+			a.Route = makeRoute(info.Value, nodes...)
+
+			_, err = pControl.RegisterAttempt(
+				info.PaymentIdentifier, &a,
+			)
+			require.NoError(t, err)
+
+			// All but the last attempt of a payment fail.
+			if i == len(attemptRoutes)-1 {
+				continue
+			}
+
+			_, _, err = pControl.FailAttempt(
+				info.PaymentIdentifier, a.AttemptID,
+				&HTLCFailInfo{Reason: HTLCFailUnreadable},
+			)
+			require.NoError(t, err)
+		}
+
+		payment, err := pControl.FetchPayment(info.PaymentIdentifier)
+		require.NoError(t, err)
+		seqNrs = append(seqNrs, payment.SequenceNum)
+	}
+
+	query := PaymentsQuery{
+		MaxPayments:       math.MaxUint64,
+		IncludeIncomplete: true,
+		CountTotal:        true,
+	}
+
+	tests := []struct {
+		name     string
+		node     route.Vertex
+		expected []uint64
+	}{
+		{
+			name:     "intermediate hop and failed attempt",
+			node:     nodeB,
+			expected: []uint64{seqNrs[0], seqNrs[3]},
+		},
+		{
+			name:     "destination",
+			node:     nodeC,
+			expected: []uint64{seqNrs[0], seqNrs[1]},
+		},
+		{
+			name:     "first hop",
+			node:     nodeA,
+			expected: []uint64{seqNrs[0], seqNrs[2]},
+		},
+		{
+			name:     "destination of last attempt",
+			node:     nodeE,
+			expected: []uint64{seqNrs[2], seqNrs[3]},
+		},
+		{
+			name: "unknown node",
+			node: nodeF,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := db.FetchPaymentsByHopPubKey(
+				ctx, test.node, query,
+			)
+			require.NoError(t, err)
+
+			var got []uint64
+			for _, p := range resp.Payments {
+				got = append(got, p.SequenceNum)
+			}
+			require.Equal(t, test.expected, got)
+			require.EqualValues(
+				t, len(test.expected), resp.TotalCount,
+			)
+		})
+	}
+
+	// Finally, page through the payments routed through node D one at a
+	// time. The hops need to be matched even if only a summary of the
+	// routes was requested.
+	pageQuery := PaymentsQuery{
+		MaxPayments:       1,
+		IncludeIncomplete: true,
+		SkipHops:          true,
+	}
+
+	resp, err := db.FetchPaymentsByHopPubKey(ctx, nodeD, pageQuery)
+	require.NoError(t, err)
+	require.Len(t, resp.Payments, 1)
+	require.Equal(t, seqNrs[1], resp.Payments[0].SequenceNum)
+
+	pageQuery.IndexOffset = resp.LastIndexOffset
+	resp, err = db.FetchPaymentsByHopPubKey(ctx, nodeD, pageQuery)
+	require.NoError(t, err)
+	require.Len(t, resp.Payments, 1)
+	require.Equal(t, seqNrs[3], resp.Payments[0].SequenceNum)
+
+	pageQuery.IndexOffset = resp.LastIndexOffset
+	resp, err = db.FetchPaymentsByHopPubKey(ctx, nodeD, pageQuery)
+	require.NoError(t, err)
+	require.Empty(t, resp.Payments)
+}
+
+// TestFetchAttemptsBySetID tests that the htlc attempts of an AMP payment can
+// be looked up by their set ID.
+func TestFetchAttemptsBySetID(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+
+	// AMP payments use their set ID as payment identifier.
+	setID := [32]byte{1, 2, 3}
+	info, _, _, err := genInfo()
+	require.NoError(t, err)
+	info.PaymentIdentifier = setID
+	info.Value *= 2
+
+	require.NoError(t, pControl.InitPayment(setID, info))
+
+	// Send the payment in two AMP shards.
+	total := info.Value
+	for i := 0; i < 2; i++ {
+		rt := *testRoute.Copy()
+		finalHop := rt.FinalHop()
+		finalHop.MPP = record.NewMPP(total, [32]byte{0x42})
+		finalHop.AMP = record.NewAMP(
+			[32]byte{byte(i)}, setID, uint32(i),
+		)
+
+		attempt := NewHtlcAttempt(
+			uint64(i), priv, rt, time.Time{}, nil,
+		)
+		_, err := pControl.RegisterAttempt(
+			setID, &attempt.HTLCAttemptInfo,
+		)
+		require.NoError(t, err)
+	}
+
+	// A regular payment is stored next to the AMP payment.
+	regular, attempt, _, err := genInfo()
+	require.NoError(t, err)
+	require.NoError(
+		t, pControl.InitPayment(regular.PaymentIdentifier, regular),
+	)
+	_, err = pControl.RegisterAttempt(regular.PaymentIdentifier, attempt)
+	require.NoError(t, err)
+
+	attempts, err := db.FetchAttemptsBySetID(ctx, setID)
+	require.NoError(t, err)
+	require.Len(t, attempts, 2)
+	for i, a := range attempts {
+		require.EqualValues(t, i, a.AttemptID)
+
+		amp := a.Route.FinalHop().AMP
+		require.NotNil(t, amp)
+		require.Equal(t, setID, amp.SetID())
+		require.EqualValues(t, i, amp.ChildIndex())
+	}
+
+	// The attempts of a payment without AMP records aren't returned.
+	attempts, err = db.FetchAttemptsBySetID(
+		ctx, regular.PaymentIdentifier,
+	)
+	require.NoError(t, err)
+	require.Empty(t, attempts)
+
+	// Neither is anything returned for an unknown set ID.
+	attempts, err = db.FetchAttemptsBySetID(ctx, [32]byte{9})
+	require.NoError(t, err)
+	require.Empty(t, attempts)
+}
+
+// TestQueryPaymentsLabelContains tests that payments can be queried by a part
+// of their label, and that the total count respects the label filter.
+func TestQueryPaymentsLabelContains(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+
+	labels := []string{"", "order-1", "refund order-2", "order-3", "misc"}
+	hashes := make([]lntypes.Hash, len(labels))
+	for i, label := range labels {
+		info, _, _, err := genInfo()
+		require.NoError(t, err)
+		info.Label = label
+
+		err = pControl.InitPayment(info.PaymentIdentifier, info)
+		require.NoError(t, err)
+
+		hashes[i] = info.PaymentIdentifier
+	}
+
+	resp, err := db.QueryPayments(ctx, PaymentsQuery{
+		MaxPayments:       2,
+		IncludeIncomplete: true,
+		CountTotal:        true,
+		LabelContains:     "order-",
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Payments, 2)
+	require.EqualValues(t, 3, resp.TotalCount)
+	require.Equal(t, hashes[1], resp.Payments[0].Info.PaymentIdentifier)
+	require.Equal(t, hashes[2], resp.Payments[1].Info.PaymentIdentifier)
+
+	// Continue after the last returned payment.
+	resp, err = db.QueryPayments(ctx, PaymentsQuery{
+		IndexOffset:       resp.LastIndexOffset,
+		MaxPayments:       2,
+		IncludeIncomplete: true,
+		LabelContains:     "order-",
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Payments, 1)
+	require.Equal(t, hashes[3], resp.Payments[0].Info.PaymentIdentifier)
+	require.Equal(t, "order-3", resp.Payments[0].Info.Label)
+}
+
+// TestQueryPaymentsPaymentAddr tests that payments can be queried by the
+// payment address they were sent to.
+func TestQueryPaymentsPaymentAddr(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+
+	addrs := []*[32]byte{nil, {1}, {2}, {1}}
+	hashes := make([]lntypes.Hash, len(addrs))
+	for i, addr := range addrs {
+		info, _, _, err := genInfo()
+		require.NoError(t, err)
+		info.PaymentAddr = addr
+
+		err = pControl.InitPayment(info.PaymentIdentifier, info)
+		require.NoError(t, err)
+
+		hashes[i] = info.PaymentIdentifier
+	}
+
+	resp, err := db.QueryPayments(ctx, PaymentsQuery{
+		MaxPayments:       math.MaxUint64,
+		IncludeIncomplete: true,
+		CountTotal:        true,
+		PaymentAddr:       &[32]byte{1},
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Payments, 2)
+	require.EqualValues(t, 2, resp.TotalCount)
+	require.Equal(t, hashes[1], resp.Payments[0].Info.PaymentIdentifier)
+	require.Equal(t, hashes[3], resp.Payments[1].Info.PaymentIdentifier)
+
+	// No payment was sent to an unknown address.
+	resp, err = db.QueryPayments(ctx, PaymentsQuery{
+		MaxPayments:       math.MaxUint64,
+		IncludeIncomplete: true,
+		PaymentAddr:       &[32]byte{3},
+	})
+	require.NoError(t, err)
+	require.Empty(t, resp.Payments)
+}
+
+// TestQueryPaymentsBlindedOnly tests that payments can be restricted to the
+// ones that targeted a blinded path, also when their hops are skipped.
+func TestQueryPaymentsBlindedOnly(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+
+	blinded := []bool{false, true, false}
+	hashes := make([]lntypes.Hash, len(blinded))
+	for i, isBlinded := range blinded {
+		info, attempt, _, err := genInfo()
+		require.NoError(t, err)
+
+		// Attempts to a blinded path don't carry an MPP record.
+		if isBlinded {
+			finalHop := attempt.Route.FinalHop()
+			finalHop.EncryptedData = []byte{1, 2, 3}
+			finalHop.MPP = nil
+		}
+
+		err = pControl.InitPayment(info.PaymentIdentifier, info)
+		require.NoError(t, err)
+
+		_, err = pControl.RegisterAttempt(
+			info.PaymentIdentifier, attempt,
+		)
+		require.NoError(t, err)
+
+		hashes[i] = info.PaymentIdentifier
+	}
+
+	for _, skipHops := range []bool{false, true} {
+		resp, err := db.QueryPayments(ctx, PaymentsQuery{
+			MaxPayments:       math.MaxUint64,
+			IncludeIncomplete: true,
+			CountTotal:        true,
+			BlindedOnly:       true,
+			SkipHops:          skipHops,
+		})
+		require.NoError(t, err)
+		require.Len(t, resp.Payments, 1)
+		require.EqualValues(t, 1, resp.TotalCount)
+
+		payment := resp.Payments[0]
+		require.Equal(t, hashes[1], payment.Info.PaymentIdentifier)
+		require.True(t, payment.IsBlinded())
+	}
+
+	// Without the filter, all payments are returned, and only one of them
+	// is blinded.
+	resp, err := db.QueryPayments(ctx, PaymentsQuery{
+		MaxPayments:       math.MaxUint64,
+		IncludeIncomplete: true,
+		SkipHops:          true,
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Payments, len(blinded))
+	for i, payment := range resp.Payments {
+		require.Equal(t, blinded[i], payment.IsBlinded())
+	}
+}
+
+// TestForEachPayment tests that ForEachPayment visits all matching payments
+// across page boundaries in both directions, and that the iteration can be
+// stopped early.
+func TestForEachPayment(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+
+	// Create one payment more than fits into a single page, so the
+	// iteration has to cross exactly one page boundary.
+	const pageSize = 3
+
+	var hashes []lntypes.Hash
+	for i := 0; i < pageSize+1; i++ {
+		info, _, _, err := genInfo()
+		require.NoError(t, err)
+
+		err = pControl.InitPayment(info.PaymentIdentifier, info)
+		require.NoError(t, err)
+
+		hashes = append(hashes, info.PaymentIdentifier)
+	}
+
+	reversedHashes := make([]lntypes.Hash, len(hashes))
+	for i, hash := range hashes {
+		reversedHashes[len(hashes)-1-i] = hash
+	}
+
+	// collect returns a callback that records the hashes of the visited
+	// payments and stops the iteration once stopAfter payments have been
+	// visited.
+	collect := func(visited *[]lntypes.Hash,
+		stopAfter int) func(*MPPayment) error {
+
+		return func(payment *MPPayment) error {
+			*visited = append(
+				*visited, payment.Info.PaymentIdentifier,
+			)
+
+			// The callback is free to access the database, as no
+			// transaction is held while it runs.
+			_, err := pControl.FetchPayment(
+				payment.Info.PaymentIdentifier,
+			)
+			if err != nil {
+				return err
+			}
+
+			if len(*visited) == stopAfter {
+				return ErrStopIteration
+			}
+
+			return nil
+		}
+	}
+
+	tests := []struct {
+		name      string
+		reversed  bool
+		stopAfter int
+		expected  []lntypes.Hash
+	}{
+		{
+			name:     "forward",
+			expected: hashes,
+		},
+		{
+			name:     "reversed",
+			reversed: true,
+			expected: reversedHashes,
+		},
+		{
+			name:      "stop within first page",
+			stopAfter: 2,
+			expected:  hashes[:2],
+		},
+		{
+			name:      "stop at page boundary",
+			stopAfter: pageSize,
+			expected:  hashes[:pageSize],
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			query := PaymentsQuery{
+				MaxPayments:       pageSize,
+				Reversed:          test.reversed,
+				IncludeIncomplete: true,
+			}
+
+			var visited []lntypes.Hash
+			err := db.ForEachPayment(
+				context.Background(), query,
+				collect(&visited, test.stopAfter),
+			)
+			require.NoError(t, err)
+			require.Equal(t, test.expected, visited)
+		})
+	}
+
+	// Errors returned by the callback are passed through.
+	errCallback := errors.New("callback error")
+	err = db.ForEachPayment(
+		context.Background(), PaymentsQuery{IncludeIncomplete: true},
+		func(*MPPayment) error {
+			return errCallback
+		},
+	)
+	require.ErrorIs(t, err, errCallback)
+
+	// A canceled context stops the iteration before any payment is
+	// visited.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var visited []lntypes.Hash
+	err = db.ForEachPayment(
+		ctx, PaymentsQuery{IncludeIncomplete: true},
+		collect(&visited, 0),
+	)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Empty(t, visited)
+}
+
+// TestFetchPaymentWithSequenceNumber tests lookup of payments with their
+// sequence number. It sets up one payment with no duplicates, and another with
+// two duplicates in its duplicates bucket then uses these payments to test the
+// case where a specific duplicate is not found and the duplicates bucket is not
+// present when we expect it to be.
+func TestFetchPaymentWithSequenceNumber(t *testing.T) {
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+
+	// Generate a test payment which does not have duplicates.
+	noDuplicates, _, _, err := genInfo()
+	require.NoError(t, err)
+
+	// Create a new payment entry in the database.
+	err = pControl.InitPayment(noDuplicates.PaymentIdentifier, noDuplicates)
+	require.NoError(t, err)
+
+	// Fetch the payment so we can get its sequence nr.
+	noDuplicatesPayment, err := pControl.FetchPayment(
+		noDuplicates.PaymentIdentifier,
+	)
+	require.NoError(t, err)
+
+	// Generate a test payment which we will add duplicates to.
+	hasDuplicates, _, preimg, err := genInfo()
+	require.NoError(t, err)
+
+	// Create a new payment entry in the database.
+	err = pControl.InitPayment(hasDuplicates.PaymentIdentifier, hasDuplicates)
+	require.NoError(t, err)
+
+	// Fetch the payment so we can get its sequence nr.
+	hasDuplicatesPayment, err := pControl.FetchPayment(
+		hasDuplicates.PaymentIdentifier,
+	)
+	require.NoError(t, err)
+
+	// We declare the sequence numbers used here so that we can reference
+	// them in tests.
+	var (
+		duplicateOneSeqNr = hasDuplicatesPayment.SequenceNum + 1
+		duplicateTwoSeqNr = hasDuplicatesPayment.SequenceNum + 2
+	)
+
+	// Add two duplicates to our second payment.
+	appendDuplicatePayment(
+		t, db, hasDuplicates.PaymentIdentifier, duplicateOneSeqNr, preimg,
+	)
+	appendDuplicatePayment(
+		t, db, hasDuplicates.PaymentIdentifier, duplicateTwoSeqNr, preimg,
+	)
+
+	tests := []struct {
+		name           string
+		paymentHash    lntypes.Hash
+		sequenceNumber uint64
+		expectedErr    error
+	}{
+		{
+			name:           "lookup payment without duplicates",
+			paymentHash:    noDuplicates.PaymentIdentifier,
+			sequenceNumber: noDuplicatesPayment.SequenceNum,
+			expectedErr:    nil,
+		},
+		{
+			name:           "lookup payment with duplicates",
+			paymentHash:    hasDuplicates.PaymentIdentifier,
+			sequenceNumber: hasDuplicatesPayment.SequenceNum,
+			expectedErr:    nil,
+		},
+		{
+			name:           "lookup first duplicate",
+			paymentHash:    hasDuplicates.PaymentIdentifier,
+			sequenceNumber: duplicateOneSeqNr,
+			expectedErr:    nil,
+		},
+		{
+			name:           "lookup second duplicate",
+			paymentHash:    hasDuplicates.PaymentIdentifier,
+			sequenceNumber: duplicateTwoSeqNr,
+			expectedErr:    nil,
+		},
+		{
+			name:           "lookup non-existent duplicate",
+			paymentHash:    hasDuplicates.PaymentIdentifier,
+			sequenceNumber: 999999,
+			expectedErr:    ErrDuplicateNotFound,
+		},
+		{
+			name:           "lookup duplicate, no duplicates bucket",
+			paymentHash:    noDuplicates.PaymentIdentifier,
+			sequenceNumber: duplicateTwoSeqNr,
+			expectedErr:    ErrNoDuplicateBucket,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			err := kvdb.Update(
+				db, func(tx walletdb.ReadWriteTx) error {
+					var seqNrBytes [8]byte
+					byteOrder.PutUint64(
+						seqNrBytes[:], test.sequenceNumber,
+					)
+
+					_, err := fetchPaymentWithSequenceNumber(
+						tx, test.paymentHash,
+						seqNrBytes[:], false,
+					)
+					return err
+				}, func() {},
+			)
+			require.Equal(t, test.expectedErr, err)
+		})
+	}
+}
+
+// appendDuplicatePayment adds a duplicate payment to an existing payment. Note
+// that this function requires a unique sequence number.
+//
+// This code is *only* intended to replicate legacy duplicate payments in lnd,
 // our current schema does not allow duplicates.
 func appendDuplicatePayment(t *testing.T, db *DB, paymentHash lntypes.Hash,
 	seqNr uint64, preImg lntypes.Preimage) {
 
-	err := kvdb.Update(db, func(tx walletdb.ReadWriteTx) error {
-		bucket, err := fetchPaymentBucketUpdate(
-			tx, paymentHash,
+	err := kvdb.Update(db, func(tx walletdb.ReadWriteTx) error {
+		bucket, err := fetchPaymentBucketUpdate(
+			tx, paymentHash,
+		)
+		if err != nil {
+			return err
+		}
+
+		// Create the duplicates bucket if it is not
+		// present.
+		dup, err := bucket.CreateBucketIfNotExists(
+			duplicatePaymentsBucket,
+		)
+		if err != nil {
+			return err
+		}
+
+		var sequenceKey [8]byte
+		byteOrder.PutUint64(sequenceKey[:], seqNr)
+
+		// Create duplicate payments for the two dup
+		// sequence numbers we've setup.
+		putDuplicatePayment(t, dup, sequenceKey[:], paymentHash, preImg)
+
+		// Finally, once we have created our entry we add an index for
+		// it.
+		err = createPaymentIndexEntry(tx, sequenceKey[:], paymentHash)
+		require.NoError(t, err)
+
+		return nil
+	}, func() {})
+	require.NoError(t, err, "could not create payment")
+}
+
+// putDuplicatePayment creates a duplicate payment in the duplicates bucket
+// provided with the minimal information required for successful reading.
+func putDuplicatePayment(t *testing.T, duplicateBucket kvdb.RwBucket,
+	sequenceKey []byte, paymentHash lntypes.Hash,
+	preImg lntypes.Preimage) {
+
+	paymentBucket, err := duplicateBucket.CreateBucketIfNotExists(
+		sequenceKey,
+	)
+	require.NoError(t, err)
+
+	err = paymentBucket.Put(duplicatePaymentSequenceKey, sequenceKey)
+	require.NoError(t, err)
+
+	// Generate fake information for the duplicate payment.
+	info, _, _, err := genInfo()
+	require.NoError(t, err)
+
+	// Write the payment info to disk under the creation info key. This code
+	// is copied rather than using serializePaymentCreationInfo to ensure
+	// we always write in the legacy format used by duplicate payments.
+	var b bytes.Buffer
+	var scratch [8]byte
+	_, err = b.Write(paymentHash[:])
+	require.NoError(t, err)
+
+	byteOrder.PutUint64(scratch[:], uint64(info.Value))
+	_, err = b.Write(scratch[:])
+	require.NoError(t, err)
+
+	err = serializeTime(&b, info.CreationTime)
+	require.NoError(t, err)
+
+	byteOrder.PutUint32(scratch[:4], 0)
+	_, err = b.Write(scratch[:4])
+	require.NoError(t, err)
+
+	// Get the PaymentCreationInfo.
+	err = paymentBucket.Put(duplicatePaymentCreationInfoKey, b.Bytes())
+	require.NoError(t, err)
+
+	// Duolicate payments are only stored for successes, so add the
+	// preimage.
+	err = paymentBucket.Put(duplicatePaymentSettleInfoKey, preImg[:])
+	require.NoError(t, err)
+}
+
+// TestQueryPaymentsSkipHops asserts that querying payments with SkipHops set
+// only returns a summary of the attempted routes, while the amounts and fees of
+// the payment still match the ones of the fully hydrated payment.
+func TestQueryPaymentsSkipHops(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+
+	info, attempt, preimg, err := genInfo()
+	require.NoError(t, err)
+
+	err = pControl.InitPayment(info.PaymentIdentifier, info)
+	require.NoError(t, err)
+
+	_, err = pControl.RegisterAttempt(info.PaymentIdentifier, attempt)
+	require.NoError(t, err)
+
+	_, _, err = pControl.SettleAttempt(
+		info.PaymentIdentifier, attempt.AttemptID,
+		&HTLCSettleInfo{
+			Preimage: preimg,
+		},
+	)
+	require.NoError(t, err)
+
+	query := PaymentsQuery{
+		MaxPayments:       math.MaxUint64,
+		IncludeIncomplete: true,
+	}
+	full, err := db.QueryPayments(ctx, query)
+	require.NoError(t, err)
+	require.Len(t, full.Payments, 1)
+
+	query.SkipHops = true
+	summary, err := db.QueryPayments(ctx, query)
+	require.NoError(t, err)
+	require.Len(t, summary.Payments, 1)
+
+	fullPayment, summaryPayment := full.Payments[0], summary.Payments[0]
+	require.Equal(t, fullPayment.Status, summaryPayment.Status)
+	require.Equal(t, fullPayment.State, summaryPayment.State)
+	require.Len(t, summaryPayment.HTLCs, 1)
+
+	// The summarized route should only carry a stub of the final hop.
+	fullRoute := fullPayment.HTLCs[0].Route
+	summaryRoute := summaryPayment.HTLCs[0].Route
+	require.Len(t, summaryRoute.Hops, 1)
+	require.Equal(t, &route.Hop{
+		PubKeyBytes:  fullRoute.FinalHop().PubKeyBytes,
+		AmtToForward: fullRoute.FinalHop().AmtToForward,
+	}, summaryRoute.Hops[0])
+
+	require.Equal(t, fullRoute.TotalAmount, summaryRoute.TotalAmount)
+	require.Equal(t, fullRoute.TotalTimeLock, summaryRoute.TotalTimeLock)
+	require.Equal(t, fullRoute.SourcePubKey, summaryRoute.SourcePubKey)
+	require.Equal(t, fullRoute.TotalFees(), summaryRoute.TotalFees())
+	require.Equal(t, fullRoute.ReceiverAmt(), summaryRoute.ReceiverAmt())
+
+	fullSent, fullFees := fullPayment.SentAmt()
+	summarySent, summaryFees := summaryPayment.SentAmt()
+	require.Equal(t, fullSent, summarySent)
+	require.Equal(t, fullFees, summaryFees)
+
+	// Resolution info should be left untouched.
+	require.Equal(t, fullPayment.HTLCs[0].Settle,
+		summaryPayment.HTLCs[0].Settle)
+	require.Equal(t, fullPayment.HTLCs[0].AttemptTime,
+		summaryPayment.HTLCs[0].AttemptTime)
+	require.Equal(t, fullPayment.HTLCs[0].Hash, summaryPayment.HTLCs[0].Hash)
+}
+
+// populatePayments writes numPayments payments with numAttempts HTLC attempts
+// each into the database using a single transaction. If settled is set, the
+// attempts are settled, otherwise they are left in flight.
+func populatePayments(b testing.TB, db *DB, numPayments, numAttempts int,
+	settled bool) {
+
+	b.Helper()
+
+	err := kvdb.Update(db, func(tx kvdb.RwTx) error {
+		if _, err := tx.CreateTopLevelBucket(
+			paymentsIndexBucket,
+		); err != nil {
+			return err
+		}
+
+		for i := 0; i < numPayments; i++ {
+			var hash lntypes.Hash
+			byteOrder.PutUint64(hash[:], uint64(i))
+
+			bucket, err := createPaymentBucket(tx, hash)
+			if err != nil {
+				return err
+			}
+
+			// Sequence numbers and creation times start at one,
+			// since a zero timestamp is read back as a zero time.
+			var seqNum [8]byte
+			byteOrder.PutUint64(seqNum[:], uint64(i+1))
+			createTime := time.Unix(int64(i+1), 0)
+
+			err = createPaymentIndexEntry(tx, seqNum[:], hash)
+			if err != nil {
+				return err
+			}
+
+			if err := bucket.Put(
+				paymentSequenceKey, seqNum[:],
+			); err != nil {
+				return err
+			}
+
+			var info bytes.Buffer
+			err = serializePaymentCreationInfo(&info,
+				&PaymentCreationInfo{
+					PaymentIdentifier: hash,
+					Value: testRoute.ReceiverAmt() *
+						lnwire.MilliSatoshi(
+							numAttempts,
+						),
+					CreationTime: createTime,
+				},
+			)
+			if err != nil {
+				return err
+			}
+
+			err = bucket.Put(paymentCreationInfoKey, info.Bytes())
+			if err != nil {
+				return err
+			}
+
+			htlcs, err := bucket.CreateBucket(paymentHtlcsBucket)
+			if err != nil {
+				return err
+			}
+
+			for j := 0; j < numAttempts; j++ {
+				var aid [8]byte
+				byteOrder.PutUint64(aid[:], uint64(j))
+
+				attempt := NewHtlcAttempt(
+					uint64(j), priv, testRoute,
+					createTime, &hash,
+				)
+
+				var a bytes.Buffer
+				err := serializeHTLCAttemptInfo(
+					&a, &attempt.HTLCAttemptInfo,
+				)
+				if err != nil {
+					return err
+				}
+
+				err = htlcs.Put(
+					htlcBucketKey(htlcAttemptInfoKey, aid[:]),
+					a.Bytes(),
+				)
+				if err != nil {
+					return err
+				}
+
+				if !settled {
+					continue
+				}
+
+				var s bytes.Buffer
+				err = serializeHTLCSettleInfo(
+					&s, &HTLCSettleInfo{
+						SettleTime: createTime,
+					},
+				)
+				if err != nil {
+					return err
+				}
+
+				err = htlcs.Put(
+					htlcBucketKey(htlcSettleInfoKey, aid[:]),
+					s.Bytes(),
+				)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}, func() {})
+	require.NoError(b, err)
+}
+
+// BenchmarkFetchInFlightPayments measures fetching a few in-flight payments
+// among many terminated ones through the in-flight index, compared to
+// building the index from all payments first.
+func BenchmarkFetchInFlightPayments(b *testing.B) {
+	ctx := context.Background()
+
+	const (
+		numPayments = 10_000
+		numInFlight = 5
+	)
+
+	db, err := MakeTestDB(b)
+	require.NoError(b, err)
+
+	populatePayments(b, db, numPayments, 1, true)
+
+	pControl := NewPaymentControl(db)
+	for i := 0; i < numInFlight; i++ {
+		info, _, _, err := genInfo()
+		require.NoError(b, err)
+
+		err = pControl.InitPayment(info.PaymentIdentifier, info)
+		require.NoError(b, err)
+	}
+
+	dropIndex := func() {
+		err := kvdb.Update(db, func(tx kvdb.RwTx) error {
+			return tx.DeleteTopLevelBucket(
+				paymentsInFlightIndexBucket,
+			)
+		}, func() {})
+		require.NoError(b, err)
+	}
+
+	for _, indexed := range []bool{true, false} {
+		name := "indexed"
+		if !indexed {
+			name = "build_index"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if !indexed {
+					b.StopTimer()
+					dropIndex()
+					b.StartTimer()
+				}
+
+				inFlight, err := pControl.FetchInFlightPayments(
+					ctx,
+				)
+				require.NoError(b, err)
+				require.Len(b, inFlight, numInFlight)
+			}
+		})
+	}
+}
+
+// BenchmarkDeletePaymentsNonRemovable measures scanning many non-removable
+// payments for the few removable ones to delete, by checking only the status
+// of each payment compared to decoding each payment in full.
+func BenchmarkDeletePaymentsNonRemovable(b *testing.B) {
+	const (
+		numPayments = 10_000
+		numAttempts = 3
+	)
+
+	db, err := MakeTestDB(b)
+	require.NoError(b, err)
+
+	// The populated payments only have in-flight attempts, so none of them
+	// can be removed.
+	populatePayments(b, db, numPayments, numAttempts, false)
+
+	b.Run("status", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			numDeleted, _, err := db.DeletePayments(false, false)
+			require.NoError(b, err)
+			require.Zero(b, numDeleted)
+		}
+	})
+
+	b.Run("hydrated", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			var numRemovable int
+			err := kvdb.View(db, func(tx kvdb.RTx) error {
+				payments := tx.ReadBucket(paymentsRootBucket)
+
+				return payments.ForEach(func(k,
+					_ []byte) error {
+
+					bucket := payments.NestedReadBucket(k)
+					payment, err := fetchPayment(bucket)
+					if err != nil {
+						return err
+					}
+
+					status := payment.Status
+					if status.removable() == nil {
+						numRemovable++
+					}
+
+					return nil
+				})
+			}, func() {
+				numRemovable = 0
+			})
+			require.NoError(b, err)
+			require.Zero(b, numRemovable)
+		}
+	})
+}
+
+// BenchmarkQueryPayments measures the speedup of querying payments with only a
+// summary of their routes or without their htlcs compared to fully hydrated
+// payments.
+func BenchmarkQueryPayments(b *testing.B) {
+	ctx := context.Background()
+
+	const (
+		numPayments = 10_000
+		numAttempts = 3
+	)
+
+	db, err := MakeTestDB(b)
+	require.NoError(b, err)
+
+	populatePayments(b, db, numPayments, numAttempts, true)
+
+	queries := []struct {
+		name  string
+		query PaymentsQuery
+	}{
+		{
+			name: "full",
+		},
+		{
+			name: "skip_hops",
+			query: PaymentsQuery{
+				SkipHops: true,
+			},
+		},
+		{
+			name: "exclude_htlcs",
+			query: PaymentsQuery{
+				ExcludeHTLCs: true,
+			},
+		},
+	}
+
+	for _, q := range queries {
+		query := q.query
+		query.MaxPayments = math.MaxUint64
+
+		b.Run(q.name, func(b *testing.B) {
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				resp, err := db.QueryPayments(ctx, query)
+				require.NoError(b, err)
+				require.Len(b, resp.Payments, numPayments)
+			}
+		})
+	}
+}
+
+// TestQueryPaymentsExcludeHTLCs tests that payments queried without their
+// htlcs have the same status and state as the fully hydrated payments.
+func TestQueryPaymentsExcludeHTLCs(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+
+	payments := []*payment{
+		{status: StatusInFlight},
+		{status: StatusSucceeded},
+		{status: StatusFailed},
+	}
+	createTestPayments(t, pControl, payments)
+
+	full, err := db.QueryPayments(ctx, PaymentsQuery{
+		MaxPayments:       math.MaxUint64,
+		IncludeIncomplete: true,
+	})
+	require.NoError(t, err)
+	require.Len(t, full.Payments, len(payments))
+
+	resp, err := db.QueryPayments(ctx, PaymentsQuery{
+		MaxPayments:       math.MaxUint64,
+		IncludeIncomplete: true,
+		ExcludeHTLCs:      true,
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Payments, len(payments))
+
+	for i, p := range resp.Payments {
+		require.Nil(t, p.HTLCs)
+		require.Equal(t, payments[i].status, p.Status)
+		require.Equal(t, full.Payments[i].Status, p.Status)
+		require.Equal(t, full.Payments[i].State, p.State)
+		require.Equal(t, full.Payments[i].Info, p.Info)
+		require.Equal(
+			t, full.Payments[i].FailureReason, p.FailureReason,
+		)
+	}
+
+	// The htlcs are still used to match the payments to a hop.
+	hopResp, err := db.FetchPaymentsByHopPubKey(
+		ctx, testHop1.PubKeyBytes, PaymentsQuery{
+			MaxPayments:       math.MaxUint64,
+			IncludeIncomplete: true,
+			ExcludeHTLCs:      true,
+		},
+	)
+	require.NoError(t, err)
+	require.Len(t, hopResp.Payments, len(payments))
+	for _, p := range hopResp.Payments {
+		require.Nil(t, p.HTLCs)
+	}
+}
+
+// TestQueryPaymentsCanceled tests that a payments query that is canceled while
+// it scans the payments is aborted promptly with the context's error.
+func TestQueryPaymentsCanceled(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+
+	const numPayments = 5 * paymentScanCheckInterval
+	for i := 0; i < numPayments; i++ {
+		info, _, _, err := genInfo()
+		require.NoError(t, err)
+
+		err = pControl.InitPayment(info.PaymentIdentifier, info)
+		require.NoError(t, err)
+	}
+
+	// A canceled context aborts the query before the first page is
+	// filled.
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = db.QueryPayments(canceledCtx, PaymentsQuery{
+		MaxPayments:       math.MaxUint64,
+		IncludeIncomplete: true,
+	})
+	require.ErrorIs(t, err, context.Canceled)
+
+	// Cancel the context in the middle of the scan. The filter is called
+	// for every scanned payment, so we can tell how far the scan got.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const cancelAfter = paymentScanCheckInterval + 1
+	var numScanned int
+	filter := func(*MPPayment) bool {
+		numScanned++
+		if numScanned == cancelAfter {
+			cancel()
+		}
+
+		return true
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := db.queryPayments(ctx, PaymentsQuery{
+			MaxPayments:       math.MaxUint64,
+			IncludeIncomplete: true,
+		}, filter)
+		errChan <- err
+	}()
+
+	select {
+	case err := <-errChan:
+		require.ErrorIs(t, err, context.Canceled)
+
+	case <-time.After(10 * time.Second):
+		t.Fatal("query not aborted")
+	}
+
+	// The scan must have stopped at the next check of the context.
+	require.Less(t, numScanned, cancelAfter+paymentScanCheckInterval)
+}
+
+// TestPaymentLegacyUnknownFailureReason tests that payments stored with a
+// failure reason that isn't known are read with FailureReasonLegacyUnknown,
+// keeping the stored reason byte.
+func TestPaymentLegacyUnknownFailureReason(t *testing.T) {
+	t.Parallel()
+
+	var (
+		firstUnknown  = byte(FailureReasonCanceled) + 1
+		legacyUnknown = byte(FailureReasonLegacyUnknown)
+	)
+
+	testCases := []struct {
+		name        string
+		stored      byte
+		expected    FailureReason
+		expectedRaw *byte
+	}{
+		{
+			name:     "known reason",
+			stored:   byte(FailureReasonNoRoute),
+			expected: FailureReasonNoRoute,
+		},
+		{
+			name:        "first unknown reason",
+			stored:      firstUnknown,
+			expected:    FailureReasonLegacyUnknown,
+			expectedRaw: &firstUnknown,
+		},
+		{
+			name:        "legacy unknown reason",
+			stored:      legacyUnknown,
+			expected:    FailureReasonLegacyUnknown,
+			expectedRaw: &legacyUnknown,
+		},
+	}
+
+	require.Equal(t, "legacy_unknown", FailureReasonLegacyUnknown.String())
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			testLegacyUnknownFailureReason(
+				t, tc.stored, tc.expected, tc.expectedRaw,
+			)
+		})
+	}
+}
+
+func testLegacyUnknownFailureReason(t *testing.T, stored byte,
+	expected FailureReason, expectedRaw *byte) {
+
+	ctx := context.Background()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+
+	info, _, _, err := genInfo()
+	require.NoError(t, err)
+	hash := info.PaymentIdentifier
+
+	require.NoError(t, pControl.InitPayment(hash, info))
+	_, err = pControl.Fail(hash, FailureReasonError)
+	require.NoError(t, err)
+
+	// Overwrite the failure reason with the raw byte, as it could have
+	// been stored by an old version.
+	err = kvdb.Update(db, func(tx kvdb.RwTx) error {
+		payments := tx.ReadWriteBucket(paymentsRootBucket)
+		bucket := payments.NestedReadWriteBucket(hash[:])
+
+		return bucket.Put(paymentFailInfoKey, []byte{stored})
+	}, func() {})
+	require.NoError(t, err)
+
+	payment, err := pControl.FetchPayment(hash)
+	require.NoError(t, err)
+	require.Equal(t, StatusFailed, payment.Status)
+	require.Equal(t, expected, *payment.FailureReason)
+	require.Equal(t, expectedRaw, payment.RawFailureReason)
+
+	// The payment can also be queried.
+	resp, err := db.QueryPayments(ctx, PaymentsQuery{
+		MaxPayments:       math.MaxUint64,
+		IncludeIncomplete: true,
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Payments, 1)
+	require.Equal(t, payment, resp.Payments[0])
+}
+
+// TestDeletePaymentsBefore tests that only removable payments created before
+// the cutoff are deleted, and that the number of deletions per call is
+// limited.
+func TestDeletePaymentsBefore(t *testing.T) {
+	t.Parallel()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+
+	var (
+		cutoff = time.Unix(1_700_000_000, 0)
+		old    = cutoff.Add(-time.Hour)
+		recent = cutoff.Add(time.Hour)
+	)
+	payments := []*payment{
+		{status: StatusFailed, creationTime: old},
+		{status: StatusSucceeded, creationTime: old},
+		{status: StatusInFlight, creationTime: old},
+		{status: StatusFailed, creationTime: old},
+		{status: StatusFailed, creationTime: recent},
+		{status: StatusSucceeded, creationTime: recent},
+		{status: StatusInFlight, creationTime: recent},
+	}
+	createTestPayments(t, pControl, payments)
+
+	ctx := context.Background()
+
+	// A batch size is required.
+	_, err = db.DeletePaymentsBefore(ctx, cutoff, false, 0)
+	require.Error(t, err)
+
+	// Delete the old failed payments one at a time.
+	numDeleted, err := db.DeletePaymentsBefore(ctx, cutoff, true, 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, numDeleted)
+	assertPayments(t, db, payments[1:])
+
+	numDeleted, err = db.DeletePaymentsBefore(ctx, cutoff, true, 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, numDeleted)
+	assertPayments(t, db, append(payments[1:3:3], payments[4:]...))
+
+	// No old failed payments are left.
+	numDeleted, err = db.DeletePaymentsBefore(ctx, cutoff, true, 10)
+	require.NoError(t, err)
+	require.Zero(t, numDeleted)
+
+	// Without the failed only flag, the old succeeded payment is deleted
+	// as well, while the old in-flight payment survives.
+	numDeleted, err = db.DeletePaymentsBefore(ctx, cutoff, false, 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, numDeleted)
+	assertPayments(t, db, append(payments[2:3:3], payments[4:]...))
+
+	// With a cutoff after all payments, only the in-flight payments are
+	// kept.
+	numDeleted, err = db.DeletePaymentsBefore(
+		ctx, recent.Add(time.Second), false, 10,
+	)
+	require.NoError(t, err)
+	require.Equal(t, 2, numDeleted)
+	assertPayments(t, db, []*payment{payments[2], payments[6]})
+}
+
+// updateCountingBackend wraps a backend to count its write transactions. It
+// fails the write transaction with the number failUpdate without running it.
+type updateCountingBackend struct {
+	kvdb.Backend
+
+	updates    int
+	failUpdate int
+}
+
+// Update counts the write transaction and runs it unless it's the one that
+// should fail.
+func (b *updateCountingBackend) Update(f func(tx kvdb.RwTx) error,
+	reset func()) error {
+
+	b.updates++
+	if b.updates == b.failUpdate {
+		return errors.New("injected update failure")
+	}
+
+	return b.Backend.Update(f, reset)
+}
+
+// TestDeletePaymentsBatches tests that DeletePayments deletes the payments in
+// several transactions of the configured batch size, and that a failing batch
+// leaves the payments of the batches committed before it deleted and all
+// others intact.
+func TestDeletePaymentsBatches(t *testing.T) {
+	t.Parallel()
+
+	const batchSize = 2
+
+	// setup creates five failed payments and a single in-flight payment.
+	// It returns the payments and the hashes of the failed payments in
+	// the order they are deleted in.
+	setup := func(t *testing.T) (*DB, *updateCountingBackend, []*payment,
+		[]lntypes.Hash) {
+
+		db, err := MakeTestDB(
+			t, OptionPaymentsDeleteBatchSize(batchSize),
 		)
-		if err != nil {
-			return err
+		require.NoError(t, err)
+
+		payments := []*payment{
+			{status: StatusFailed},
+			{status: StatusFailed},
+			{status: StatusInFlight},
+			{status: StatusFailed},
+			{status: StatusFailed},
+			{status: StatusFailed},
 		}
+		createTestPayments(t, NewPaymentControl(db), payments)
 
-		// Create the duplicates bucket if it is not
-		// present.
-		dup, err := bucket.CreateBucketIfNotExists(
-			duplicatePaymentsBucket,
-		)
+		var failed []lntypes.Hash
+		for _, p := range payments {
+			if p.status == StatusFailed {
+				failed = append(failed, p.id)
+			}
+		}
+		sort.Slice(failed, func(i, j int) bool {
+			return bytes.Compare(failed[i][:], failed[j][:]) < 0
+		})
+
+		backend := &updateCountingBackend{Backend: db.Backend}
+		db.Backend = backend
+
+		return db, backend, payments, failed
+	}
+
+	// without returns the given payments, except for the ones with the
+	// given hashes.
+	without := func(payments []*payment,
+		hashes []lntypes.Hash) []*payment {
+
+		var left []*payment
+		for _, p := range payments {
+			if !slices.Contains(hashes, p.id) {
+				left = append(left, p)
+			}
+		}
+
+		return left
+	}
+
+	t.Run("all batches", func(t *testing.T) {
+		t.Parallel()
+
+		db, backend, payments, failed := setup(t)
+
+		numDeleted, _, err := db.DeletePayments(false, false)
+		require.NoError(t, err)
+		require.Equal(t, len(failed), numDeleted)
+
+		// The five failed payments are deleted in three batches.
+		require.Equal(t, 3, backend.updates)
+		assertPayments(t, db, without(payments, failed))
+	})
+
+	t.Run("failing batch", func(t *testing.T) {
+		t.Parallel()
+
+		db, backend, payments, failed := setup(t)
+
+		// Fail the second batch.
+		backend.failUpdate = 2
+
+		numDeleted, _, err := db.DeletePayments(false, false)
+		require.Error(t, err)
+		require.Equal(t, batchSize, numDeleted)
+
+		// Only the payments of the first batch are deleted, and no
+		// batch was attempted after the failing one.
+		require.Equal(t, 2, backend.updates)
+		assertPayments(t, db, without(payments, failed[:batchSize]))
+
+		// Repeating the call deletes the remaining payments.
+		backend.failUpdate = 0
+		backend.updates = 0
+
+		numDeleted, _, err = db.DeletePayments(false, false)
+		require.NoError(t, err)
+		require.Equal(t, len(failed)-batchSize, numDeleted)
+		require.Equal(t, 2, backend.updates)
+		assertPayments(t, db, without(payments, failed))
+	})
+}
+
+// TestGCPaymentIndexes tests that dangling entries of the payments index are
+// removed across several batches, and that queries over the index succeed
+// again afterwards.
+func TestGCPaymentIndexes(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+
+	// Create three payments, which get the sequence numbers 1 to 3.
+	var hashes []lntypes.Hash
+	for i := 0; i < 3; i++ {
+		info, _, _, err := genInfo()
+		require.NoError(t, err)
+
+		err = pControl.InitPayment(info.PaymentIdentifier, info)
+		require.NoError(t, err)
+
+		hashes = append(hashes, info.PaymentIdentifier)
+	}
+
+	// Fabricate dangling index entries: the payment of one entry is
+	// removed, one entry references a payment that carries a different
+	// sequence number, and more than a batch of entries reference unknown
+	// payments.
+	const numUnknown = paymentIndexGCBatchSize + 10
+	err = kvdb.Update(db, func(tx kvdb.RwTx) error {
+		payments := tx.ReadWriteBucket(paymentsRootBucket)
+		err := payments.DeleteNestedBucket(hashes[1][:])
 		if err != nil {
 			return err
 		}
 
-		var sequenceKey [8]byte
-		byteOrder.PutUint64(sequenceKey[:], seqNr)
+		seqKey := func(seqNum uint64) []byte {
+			var b [8]byte
+			byteOrder.PutUint64(b[:], seqNum)
 
-		// Create duplicate payments for the two dup
-		// sequence numbers we've setup.
-		putDuplicatePayment(t, dup, sequenceKey[:], paymentHash, preImg)
+			return b[:]
+		}
 
-		// Finally, once we have created our entry we add an index for
-		// it.
-		err = createPaymentIndexEntry(tx, sequenceKey[:], paymentHash)
-		require.NoError(t, err)
+		err = createPaymentIndexEntry(tx, seqKey(10), hashes[0])
+		if err != nil {
+			return err
+		}
+
+		for i := 0; i < numUnknown; i++ {
+			hash := lntypes.Hash{byte(i), byte(i >> 8), 1}
+			err := createPaymentIndexEntry(
+				tx, seqKey(uint64(100+i)), hash,
+			)
+			if err != nil {
+				return err
+			}
+		}
 
 		return nil
 	}, func() {})
-	require.NoError(t, err, "could not create payment")
-}
+	require.NoError(t, err)
 
-// putDuplicatePayment creates a duplicate payment in the duplicates bucket
-// provided with the minimal information required for successful reading.
-func putDuplicatePayment(t *testing.T, duplicateBucket kvdb.RwBucket,
-	sequenceKey []byte, paymentHash lntypes.Hash,
-	preImg lntypes.Preimage) {
+	query := PaymentsQuery{
+		MaxPayments:       10,
+		Reversed:          true,
+		IncludeIncomplete: true,
+	}
+	_, err = db.QueryPayments(ctx, query)
+	require.Error(t, err)
 
-	paymentBucket, err := duplicateBucket.CreateBucketIfNotExists(
-		sequenceKey,
-	)
+	numRemoved, err := db.GCPaymentIndexes(ctx)
 	require.NoError(t, err)
+	require.Equal(t, numUnknown+2, numRemoved)
 
-	err = paymentBucket.Put(duplicatePaymentSequenceKey, sequenceKey)
+	// Only the intact payments are left.
+	resp, err := db.QueryPayments(ctx, query)
 	require.NoError(t, err)
+	require.Len(t, resp.Payments, 2)
+	require.Equal(t, hashes[0], resp.Payments[0].Info.PaymentIdentifier)
+	require.Equal(t, hashes[2], resp.Payments[1].Info.PaymentIdentifier)
 
-	// Generate fake information for the duplicate payment.
+	// A second run has nothing left to remove.
+	numRemoved, err = db.GCPaymentIndexes(ctx)
+	require.NoError(t, err)
+	require.Zero(t, numRemoved)
+
+	// A canceled context aborts the scan.
+	canceledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	_, err = db.GCPaymentIndexes(canceledCtx)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+// TestPaymentsCount tests that the stored payments count is built on first use
+// and matches the number of payments index entries across initiating,
+// retrying and deleting payments.
+func TestPaymentsCount(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	pControl := NewPaymentControl(db)
+
+	// assertCount checks that the stored count and the count returned by
+	// a query match the number of index entries.
+	assertCount := func(expected int) {
+		t.Helper()
+
+		resp, err := db.QueryPayments(ctx, PaymentsQuery{
+			CountTotal: true,
+		})
+		require.NoError(t, err)
+		require.EqualValues(t, expected, resp.TotalCount)
+
+		var (
+			stored     uint64
+			ok         bool
+			numEntries int
+		)
+		err = kvdb.View(db, func(tx kvdb.RTx) error {
+			stored, ok = fetchPaymentsCount(tx)
+
+			indexes := tx.ReadBucket(paymentsIndexBucket)
+			return indexes.ForEach(func(_, _ []byte) error {
+				numEntries++
+				return nil
+			})
+		}, func() {
+			numEntries = 0
+		})
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.EqualValues(t, expected, stored)
+		require.Equal(t, expected, numEntries)
+	}
+
+	assertCount(0)
+
+	payments := []*payment{
+		{status: StatusFailed},
+		{status: StatusSucceeded},
+		{status: StatusInFlight},
+		{status: StatusFailed},
+	}
+	createTestPayments(t, pControl, payments)
+	assertCount(4)
+
+	// Retrying a failed payment moves it to a new sequence number.
 	info, _, _, err := genInfo()
 	require.NoError(t, err)
+	info.PaymentIdentifier = payments[0].id
+	require.NoError(t, pControl.InitPayment(payments[0].id, info))
+	assertCount(4)
 
-	// Write the payment info to disk under the creation info key. This code
-	// is copied rather than using serializePaymentCreationInfo to ensure
-	// we always write in the legacy format used by duplicate payments.
-	var b bytes.Buffer
-	var scratch [8]byte
-	_, err = b.Write(paymentHash[:])
+	// Deleting payments one by one and all at once removes their entries.
+	_, err = db.DeletePayment(payments[3].id, false)
 	require.NoError(t, err)
+	assertCount(3)
 
-	byteOrder.PutUint64(scratch[:], uint64(info.Value))
-	_, err = b.Write(scratch[:])
+	// Only the in-flight payment can't be deleted.
+	_, _, err = db.DeletePayments(false, false)
 	require.NoError(t, err)
+	assertCount(1)
 
-	err = serializeTime(&b, info.CreationTime)
+	// Removing dangling index entries updates the count as well.
+	err = kvdb.Update(db, func(tx kvdb.RwTx) error {
+		bucket := tx.ReadWriteBucket(paymentsRootBucket)
+		return bucket.DeleteNestedBucket(payments[2].id[:])
+	}, func() {})
 	require.NoError(t, err)
 
-	byteOrder.PutUint32(scratch[:4], 0)
-	_, err = b.Write(scratch[:4])
+	numRemoved, err := db.GCPaymentIndexes(ctx)
 	require.NoError(t, err)
+	require.Equal(t, 1, numRemoved)
+	assertCount(0)
 
-	// Get the PaymentCreationInfo.
-	err = paymentBucket.Put(duplicatePaymentCreationInfoKey, b.Bytes())
+	// Without a stored count, as for databases created before it existed,
+	// the count is rebuilt from the index.
+	require.NoError(t, pControl.InitPayment(info.PaymentIdentifier, info))
+	assertCount(1)
+
+	err = kvdb.Update(db, func(tx kvdb.RwTx) error {
+		return tx.DeleteTopLevelBucket(paymentsCountBucket)
+	}, func() {})
 	require.NoError(t, err)
 
-	// Duolicate payments are only stored for successes, so add the
-	// preimage.
-	err = paymentBucket.Put(duplicatePaymentSettleInfoKey, preImg[:])
+	assertCount(1)
+}
+
+// TestQueryPaymentsPrefilter tests that payments that are filtered out by
+// their status are skipped without decoding their attempts, which makes
+// scanning them much cheaper.
+//
+// NOTE: The test isn't run in parallel, as it measures allocations.
+func TestQueryPaymentsPrefilter(t *testing.T) {
+	ctx := context.Background()
+
+	const numPayments = 5_000
+
+	db, err := MakeTestDB(t)
+	require.NoError(t, err)
+
+	// All payments have in-flight attempts, so none of them is returned
+	// if incomplete payments aren't requested.
+	populatePayments(t, db, numPayments, 3, false)
+
+	query := PaymentsQuery{
+		MaxPayments: 10,
+		Reversed:    true,
+	}
+	resp, err := db.QueryPayments(ctx, query)
+	require.NoError(t, err)
+	require.Empty(t, resp.Payments)
+
+	skippedAllocs := testing.AllocsPerRun(1, func() {
+		_, err := db.QueryPayments(ctx, query)
+		require.NoError(t, err)
+	})
+
+	// Decoding all the payments takes many times more allocations.
+	query.MaxPayments = math.MaxUint64
+	query.IncludeIncomplete = true
+	decodedAllocs := testing.AllocsPerRun(1, func() {
+		resp, err := db.QueryPayments(ctx, query)
+		require.NoError(t, err)
+		require.Len(t, resp.Payments, numPayments)
+	})
+
+	require.Less(t, skippedAllocs*4, decodedAllocs)
+}
+
+// TestPaymentsQueryTimeout checks that payments queries are bounded by the
+// default query timeout unless the caller sets its own deadline, and that
+// exceeding it results in ErrQueryTimeout.
+func TestPaymentsQueryTimeout(t *testing.T) {
+	t.Parallel()
+
+	const timeout = 50 * time.Millisecond
+
+	db, err := MakeTestDB(t, OptionPaymentsQueryTimeout(timeout))
+	require.NoError(t, err)
+
+	// slowQuery mocks a query that takes longer than the timeout, unless
+	// its context is done first.
+	slowQuery := func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-time.After(10 * timeout):
+			return nil
+		}
+	}
+
+	// A query exceeding the default timeout fails with the typed error.
+	err = db.withQueryTimeout(context.Background(), slowQuery)
+	require.ErrorIs(t, err, ErrQueryTimeout)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// A deadline set by the caller takes precedence, so exceeding it
+	// isn't reported as a timeout of the default.
+	ctx, cancel := context.WithTimeout(context.Background(), timeout/2)
+	defer cancel()
+
+	err = db.withQueryTimeout(ctx, slowQuery)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.NotErrorIs(t, err, ErrQueryTimeout)
+
+	// Queries finishing in time are unaffected.
+	pControl := NewPaymentControl(db)
+	createTestPayments(t, pControl, []*payment{{status: StatusSucceeded}})
+
+	resp, err := db.QueryPayments(context.Background(), PaymentsQuery{
+		MaxPayments: 10,
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Payments, 1)
+
+	// Without a default timeout, the query context has no deadline.
+	db, err = MakeTestDB(t)
+	require.NoError(t, err)
+
+	err = db.withQueryTimeout(
+		context.Background(), func(ctx context.Context) error {
+			_, ok := ctx.Deadline()
+			require.False(t, ok)
+
+			return nil
+		},
+	)
 	require.NoError(t, err)
 }
@@ -1390,6 +1390,24 @@ var listPaymentsCommand = cli.Command{
 				"payments with creation date less than or " +
 				"equal to it",
 		},
+		cli.BoolFlag{
+			Name: "skip_hops",
+			Usage: "if set, the routes of the returned HTLC " +
+				"attempts only contain their totals and the " +
+				"final hop, which speeds up the query on " +
+				"systems with many payments",
+		},
+		cli.BoolFlag{
+			Name: "skip_htlcs",
+			Usage: "if set, the HTLC attempts of the returned " +
+				"payments are omitted, while their fee and " +
+				"preimage are still reported",
+		},
+		cli.StringFlag{
+			Name: "payment_addr",
+			Usage: "(optional) the hex-encoded payment address, " +
+				"if set, only payments sent to it are returned",
+		},
 	},
 	Action: actionDecorator(listPayments),
 }
@@ -1407,6 +1425,17 @@ func listPayments(ctx *cli.Context) error {
 		CountTotalPayments: ctx.Bool("count_total_payments"),
 		CreationDateStart:  ctx.Uint64("creation_date_start"),
 		CreationDateEnd:    ctx.Uint64("creation_date_end"),
+		SkipHops:           ctx.Bool("skip_hops"),
+		SkipHtlcs:          ctx.Bool("skip_htlcs"),
+	}
+
+	if ctx.IsSet("payment_addr") {
+		addr, err := hex.DecodeString(ctx.String("payment_addr"))
+		if err != nil {
+			return fmt.Errorf("unable to decode payment_addr: %w",
+				err)
+		}
+		req.PaymentAddr = addr
 	}
 
 	payments, err := client.ListPayments(ctxc, req)
@@ -1414,10 +1443,87 @@ func listPayments(ctx *cli.Context) error {
 		return err
 	}
 
+	// A single query returns at most as many payments as lnd allows. If
+	// no maximum was requested, we fetch the remaining pages as well so
+	// all matching payments are listed.
+	for req.MaxPayments == 0 && payments.HasMore {
+		req.CountTotalPayments = false
+		if req.Reversed {
+			req.IndexOffset = payments.FirstIndexOffset
+		} else {
+			req.IndexOffset = payments.LastIndexOffset
+		}
+
+		page, err := client.ListPayments(ctxc, req)
+		if err != nil {
+			return err
+		}
+
+		// The payments of each page are in ascending index order, so
+		// the pages are merged in the order they're read in.
+		if req.Reversed {
+			payments.Payments = append(
+				page.Payments, payments.Payments...,
+			)
+			payments.FirstIndexOffset = page.FirstIndexOffset
+		} else {
+			payments.Payments = append(
+				payments.Payments, page.Payments...,
+			)
+			payments.LastIndexOffset = page.LastIndexOffset
+		}
+		payments.HasMore = page.HasMore
+	}
+
 	printRespJSON(payments)
 	return nil
 }
 
+var countPaymentsCommand = cli.Command{
+	Name:     "countpayments",
+	Category: "Payments",
+	Usage:    "Count all outgoing payments per status.",
+	Description: `
+	Count the payments stored in the database per status, without listing
+	them. The count can be restricted to the payments created within a
+	time range.
+	`,
+	Flags: []cli.Flag{
+		cli.Uint64Flag{
+			Name: "creation_date_start",
+			Usage: "timestamp in seconds, if set, count " +
+				"payments with creation date greater than or " +
+				"equal to it",
+		},
+		cli.Uint64Flag{
+			Name: "creation_date_end",
+			Usage: "timestamp in seconds, if set, count " +
+				"payments with creation date less than or " +
+				"equal to it",
+		},
+	},
+	Action: actionDecorator(countPayments),
+}
+
+func countPayments(ctx *cli.Context) error {
+	ctxc := getContext()
+	client, cleanUp := getClient(ctx)
+	defer cleanUp()
+
+	req := &lnrpc.CountPaymentsRequest{
+		CreationDateStart: ctx.Uint64("creation_date_start"),
+		CreationDateEnd:   ctx.Uint64("creation_date_end"),
+	}
+
+	resp, err := client.CountPayments(ctxc, req)
+	if err != nil {
+		return err
+	}
+
+	printRespJSON(resp)
+	return nil
+}
+
 var forwardingHistoryCommand = cli.Command{
 	Name:      "fwdinghistory",
 	Category:  "Payments",
@@ -1731,11 +1837,6 @@ func deletePayments(ctx *cli.Context) error {
 		failedHTLCsOnly  = ctx.Bool("failed_htlcs_only")
 		includeNonFailed = ctx.Bool("include_non_failed")
 		err              error
-		okMsg            = struct {
-			OK bool `json:"ok"`
-		}{
-			OK: true,
-		}
 	)
 
 	// We pack two RPCs into the same CLI so there are a few non-valid
@@ -1762,15 +1863,18 @@ func deletePayments(ctx *cli.Context) error {
 				err)
 		}
 
-		_, err = client.DeletePayment(ctxc, &lnrpc.DeletePaymentRequest{
+		req := &lnrpc.DeletePaymentRequest{
 			PaymentHash:     paymentHash,
 			FailedHtlcsOnly: failedHTLCsOnly,
-		})
+		}
+		resp, err := client.DeletePayment(ctxc, req)
 		if err != nil {
 			return fmt.Errorf("error deleting single payment: %w",
 				err)
 		}
 
+		printRespJSON(resp)
+
 	case all:
 		what := "failed"
 		if includeNonFailed {
@@ -1782,7 +1886,7 @@ func deletePayments(ctx *cli.Context) error {
 
 		fmt.Printf("Removing %s payments, this might take a while...\n",
 			what)
-		_, err = client.DeleteAllPayments(
+		resp, err := client.DeleteAllPayments(
 			ctxc, &lnrpc.DeleteAllPaymentsRequest{
 				AllPayments:        includeNonFailed,
 				FailedPaymentsOnly: !includeNonFailed,
@@ -1792,11 +1896,9 @@ func deletePayments(ctx *cli.Context) error {
 		if err != nil {
 			return fmt.Errorf("error deleting payments: %w", err)
 		}
-	}
 
-	// Users are confused by empty JSON outputs so let's return a simple OK
-	// instead of just printing the empty response RPC message.
-	printJSON(okMsg)
+		printRespJSON(resp)
+	}
 
 	return nil
 }
@@ -474,6 +474,7 @@ func main() {
 		listChannelsCommand,
 		closedChannelsCommand,
 		listPaymentsCommand,
+		countPaymentsCommand,
 		describeGraphCommand,
 		getNodeMetricsCommand,
 		getChanInfoCommand,
@@ -397,6 +397,28 @@ type Config struct {
 
 	KeepFailedPaymentAttempts bool `long:"keep-failed-payment-attempts" description:"Keeps persistent record of all failed payment attempts for successfully settled payments."`
 
+	MaxPaymentInFlightAttempts uint32 `long:"max-payment-inflight-attempts" description:"The maximum number of in-flight htlc attempts a single payment may have. Registering another attempt fails until one of them is resolved. Set to 0 to not limit the number of in-flight attempts."`
+
+	MaxQueryPayments uint64 `long:"max-query-payments" description:"The maximum number of payments returned by a single payments query, such as ListPayments. If more payments match, the response indicates that there are more and the rest can be queried page by page."`
+
+	PaymentsDeleteBatchSize int `long:"payments-delete-batch-size" description:"The maximum number of payments that are deleted in a single database transaction when deleting payments in bulk. Smaller batches keep the database responsive while deleting, larger ones finish faster."`
+
+	ShadowPayments bool `long:"shadow-payments" description:"Replicate all payment writes to a separate shadow payments database (shadow-payments.db next to the channel database) to validate it alongside the channel database. Reads are always served by the channel database. Only supported with the bolt database backend."`
+
+	ArchiveDeletedPayments bool `long:"archive-deleted-payments" description:"Moves deleted payments to a separate archive instead of removing them entirely, keeping a record of them while the active payments stay slim."`
+
+	StrictPaymentDecoding bool `long:"strict-payment-decoding" description:"Fail reading a payment if one of its htlc attempts can't be parsed, instead of quarantining the corrupt attempt and continuing."`
+
+	ReusePaymentSequenceOnRetry bool `long:"reuse-payment-sequence-on-retry" description:"Keep the sequence number of a failed payment when it is sent again, instead of assigning it a new one. This keeps the payment's index offset stable across retries."`
+
+	PaymentsQueryTimeout time.Duration `long:"payments-query-timeout" description:"The maximum time a single read transaction of a payments query may take, unless the caller sets its own deadline. Queries exceeding it fail with a timeout error. Set to 0 to disable the timeout."`
+
+	StorePaymentAttemptRoutes bool `long:"store-payment-attempt-routes" description:"Additionally store the serialized route of every payment attempt, so the exact route that was sent can be inspected when debugging routing failures. This increases the size of the stored payments."`
+
+	SkipPaymentRequests bool `long:"skip-payment-requests" description:"Don't store the payment request of new payments. The payments are still stored with their hash and amount, but are returned without a payment request. This reduces the size of the stored payments for nodes that don't need the payment requests back."`
+
+	ForcePaymentsBackend bool `long:"force-payments-backend" description:"Start even if the payments were moved to another database backend than the configured one. New payments are then written to the configured backend, which splits the payment history between the two backends. The recorded backend isn't changed, so the option is needed on every start."`
+
 	StoreFinalHtlcResolutions bool `long:"store-final-htlc-resolutions" description:"Persistently store the final resolution of incoming htlcs."`
 
 	DefaultRemoteMaxHtlcs uint16 `long:"default-remote-max-htlcs" description:"The default max_htlc applied when opening or accepting channels. This value limits the number of concurrent HTLCs that the remote party can add to the commitment. The maximum possible value is 483."`
@@ -694,6 +716,8 @@ func DefaultConfig() Config {
 		ChannelCommitBatchSize:    defaultChannelCommitBatchSize,
 		CoinSelectionStrategy:     defaultCoinSelectionStrategy,
 		KeepFailedPaymentAttempts: defaultKeepFailedPaymentAttempts,
+		MaxQueryPayments:          channeldb.DefaultMaxQueryPayments,
+		PaymentsDeleteBatchSize:   channeldb.DefaultPaymentsDeleteBatchSize,
 		RemoteSigner: &lncfg.RemoteSigner{
 			Timeout: lncfg.DefaultRemoteSignerRPCTimeout,
 		},
@@ -1649,6 +1673,20 @@ func ValidateConfig(cfg Config, interceptor signal.Interceptor, fileParser,
 			maxPendingCommitInterval)
 	}
 
+	if cfg.ShadowPayments && cfg.DB.Backend != lncfg.BoltBackend {
+		return nil, mkErr("shadow-payments is only supported with " +
+			"the bolt database backend")
+	}
+
+	if cfg.MaxQueryPayments == 0 {
+		return nil, mkErr("max-query-payments must be positive")
+	}
+
+	if cfg.PaymentsDeleteBatchSize <= 0 {
+		return nil, mkErr("payments-delete-batch-size (%v) must be "+
+			"positive", cfg.PaymentsDeleteBatchSize)
+	}
+
 	if err := cfg.Gossip.Parse(); err != nil {
 		return nil, mkErr("error parsing gossip syncer: %v", err)
 	}
@@ -882,6 +882,10 @@ type DatabaseInstances struct {
 	// for native SQL queries for tables that already support it. This may
 	// be nil if the use-native-sql flag was not set.
 	NativeSQLStore *sqldb.BaseDB
+
+	// ShadowPaymentsDB is the database that payment writes are replicated
+	// to. This is nil if the shadow-payments flag was not set.
+	ShadowPaymentsDB *channeldb.DB
 }
 
 // DefaultDatabaseBuilder is a type that builds the default database backends
@@ -974,9 +978,32 @@ func (d *DefaultDatabaseBuilder) BuildDatabase(
 		channeldb.OptionKeepFailedPaymentAttempts(
 			cfg.KeepFailedPaymentAttempts,
 		),
+		channeldb.OptionMaxInFlightAttempts(
+			cfg.MaxPaymentInFlightAttempts,
+		),
+		channeldb.OptionMaxQueryPayments(cfg.MaxQueryPayments),
+		channeldb.OptionPaymentsDeleteBatchSize(
+			cfg.PaymentsDeleteBatchSize,
+		),
+		channeldb.OptionArchiveDeletedPayments(
+			cfg.ArchiveDeletedPayments,
+		),
+		channeldb.OptionStrictPaymentDecoding(
+			cfg.StrictPaymentDecoding,
+		),
+		channeldb.OptionReuseSequenceOnRetry(
+			cfg.ReusePaymentSequenceOnRetry,
+		),
+		channeldb.OptionPaymentsQueryTimeout(cfg.PaymentsQueryTimeout),
+		channeldb.OptionStoreAttemptRoutes(
+			cfg.StorePaymentAttemptRoutes,
+		),
+		channeldb.OptionSkipPaymentRequests(cfg.SkipPaymentRequests),
+		channeldb.OptionForcePaymentsBackend(cfg.ForcePaymentsBackend),
 		channeldb.OptionStoreFinalHtlcResolutions(
 			cfg.StoreFinalHtlcResolutions,
 		),
+		channeldb.OptionPaymentCacheSize(cfg.Caches.PaymentCacheSize),
 		channeldb.OptionPruneRevocationLog(cfg.DB.PruneRevocation),
 		channeldb.OptionNoRevLogAmtData(cfg.DB.NoRevLogAmtData),
 	}
@@ -1025,6 +1052,46 @@ func (d *DefaultDatabaseBuilder) BuildDatabase(
 	// using the same struct (and DB backend) instance.
 	dbs.ChanStateDB = dbs.GraphDB
 
+	// Open the shadow payments database, if requested. It only holds
+	// payments, so it doesn't need a graph cache.
+	if cfg.ShadowPayments {
+		shadowBackend, err := kvdb.GetBoltBackend(
+			&kvdb.BoltBackendConfig{
+				DBPath:            cfg.graphDatabaseDir(),
+				DBFileName:        lncfg.ShadowPaymentsDBName,
+				DBTimeout:         cfg.DB.Bolt.DBTimeout,
+				NoFreelistSync:    cfg.DB.Bolt.NoFreelistSync,
+				AutoCompact:       cfg.DB.Bolt.AutoCompact,
+				AutoCompactMinAge: cfg.DB.Bolt.AutoCompactMinAge,
+			},
+		)
+		if err != nil {
+			cleanUp()
+
+			err := fmt.Errorf("unable to open shadow payments "+
+				"DB: %w", err)
+			d.logger.Error(err)
+			return nil, nil, err
+		}
+		databaseBackends.CloseFuncs["shadow payments"] =
+			shadowBackend.Close
+
+		shadowOptions := append(
+			dbOptions, channeldb.OptionSetUseGraphCache(false),
+		)
+		dbs.ShadowPaymentsDB, err = channeldb.CreateWithBackend(
+			shadowBackend, shadowOptions...,
+		)
+		if err != nil {
+			cleanUp()
+
+			err := fmt.Errorf("unable to open shadow payments "+
+				"DB: %w", err)
+			d.logger.Error(err)
+			return nil, nil, err
+		}
+	}
+
 	// Instantiate a native SQL invoice store if the flag is set.
 	if d.cfg.DB.UseNativeSQL {
 		// KV invoice db resides in the same database as the graph and
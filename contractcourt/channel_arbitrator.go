@@ -53,12 +53,35 @@ type WitnessSubscription struct {
 	// TODO(roasbeef): couple with WitnessType?
 	WitnessUpdates <-chan lntypes.Preimage
 
+	// AbandonRequests is closed once the htlc of the subscription is
+	// requested to be abandoned before it expires, because it's known that
+	// it will never be settled. It may be nil if abandoning isn't
+	// supported.
+	AbandonRequests <-chan struct{}
+
 	// CancelSubscription is a function closure that should be used by a
 	// client to cancel the subscription once they are no longer interested
 	// in receiving new updates.
 	CancelSubscription func()
 }
 
+// IncomingChanInfo describes the channel that an incoming htlc was received
+// over, as known to the channel arbitrator.
+type IncomingChanInfo struct {
+	// ShortChanID is the short channel ID that the switch uses to address
+	// the channel.
+	ShortChanID lnwire.ShortChannelID
+
+	// Peer is the public key of the channel peer. It's unset if the
+	// channel state couldn't be recovered.
+	Peer [33]byte
+
+	// ConfirmedScid is the confirmed short channel ID of the channel. It's
+	// unset for zero-conf channels that haven't confirmed, or if the
+	// channel state couldn't be recovered.
+	ConfirmedScid lnwire.ShortChannelID
+}
+
 // WitnessBeacon is a global beacon of witnesses. Contract resolvers will use
 // this interface to lookup witnesses (preimages typically) of contracts
 // they're trying to resolve, add new preimages they resolve, and finally
@@ -69,7 +92,7 @@ type WitnessSubscription struct {
 type WitnessBeacon interface {
 	// SubscribeUpdates returns a channel that will be sent upon *each* time
 	// a new preimage is discovered.
-	SubscribeUpdates(chanID lnwire.ShortChannelID, htlc *channeldb.HTLC,
+	SubscribeUpdates(chanInfo IncomingChanInfo, htlc *channeldb.HTLC,
 		payload *hop.Payload,
 		nextHopOnionBlob []byte) (*WitnessSubscription, error)
 
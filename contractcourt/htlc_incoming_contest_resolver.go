@@ -34,6 +34,12 @@ type htlcIncomingContestResolver struct {
 	// successfully.
 	htlcExpiry uint32
 
+	// peer and confirmedScid describe the channel that the htlc was
+	// received over. They are only set once the channel state has been
+	// supplemented.
+	peer          [33]byte
+	confirmedScid lnwire.ShortChannelID
+
 	// htlcSuccessResolver is the inner resolver that may be utilized if we
 	// learn of the preimage.
 	*htlcSuccessResolver
@@ -287,8 +293,9 @@ func (h *htlcIncomingContestResolver) Resolve(
 	}
 
 	var (
-		hodlChan       <-chan interface{}
-		witnessUpdates <-chan lntypes.Preimage
+		hodlChan        <-chan interface{}
+		witnessUpdates  <-chan lntypes.Preimage
+		abandonRequests <-chan struct{}
 	)
 	if payload.FwdInfo.NextHop == hop.Exit {
 		// Create a buffered hodl chan to prevent deadlock.
@@ -354,9 +361,13 @@ func (h *htlcIncomingContestResolver) Resolve(
 		// NOTE: This is done BEFORE opportunistically querying the db,
 		// to ensure the preimage can't be delivered between querying
 		// and registering for the preimage subscription.
+		chanInfo := IncomingChanInfo{
+			ShortChanID:   h.htlcSuccessResolver.ShortChanID,
+			Peer:          h.peer,
+			ConfirmedScid: h.confirmedScid,
+		}
 		preimageSubscription, err := h.PreimageDB.SubscribeUpdates(
-			h.htlcSuccessResolver.ShortChanID, &h.htlc,
-			payload, nextHopOnionBlob,
+			chanInfo, &h.htlc, payload, nextHopOnionBlob,
 		)
 		if err != nil {
 			return nil, err
@@ -379,6 +390,7 @@ func (h *htlcIncomingContestResolver) Resolve(
 		}
 
 		witnessUpdates = preimageSubscription.WitnessUpdates
+		abandonRequests = preimageSubscription.AbandonRequests
 	}
 
 	for {
@@ -403,6 +415,37 @@ func (h *htlcIncomingContestResolver) Resolve(
 			htlcResolution := hodlItem.(invoices.HtlcResolution)
 			return processHtlcResolution(htlcResolution)
 
+		// The htlc is requested to be abandoned before it expires, as
+		// it's known that it will never be settled. We make sure that
+		// the preimage didn't turn up in the meantime, in which case we
+		// still claim the htlc.
+		case <-abandonRequests:
+			preimage, ok := h.PreimageDB.LookupPreimage(h.htlc.RHash)
+			if ok {
+				if err := applyPreimage(preimage); err != nil {
+					return nil, err
+				}
+
+				return h.htlcSuccessResolver, nil
+			}
+
+			log.Infof("%T(%v): HTLC abandoned before its expiry "+
+				"(expiry=%v)", h, h.htlcResolution.ClaimOutpoint,
+				h.htlcExpiry)
+			h.resolved = true
+
+			if err := h.processFinalHtlcFail(); err != nil {
+				return nil, err
+			}
+
+			// Checkpoint our resolver with an abandoned outcome
+			// because we take no further action on this htlc.
+			report := h.report().resolverReport(
+				nil, channeldb.ResolverTypeIncomingHtlc,
+				channeldb.ResolverOutcomeAbandoned,
+			)
+			return nil, h.Checkpoint(h, report)
+
 		case newBlock, ok := <-blockEpochs.Epochs:
 			if !ok {
 				return nil, errResolverShuttingDown
@@ -519,6 +562,28 @@ func (h *htlcIncomingContestResolver) Supplement(htlc channeldb.HTLC) {
 	h.htlc = htlc
 }
 
+// SupplementState allows the user of a ContractResolver to supplement it with
+// state required for the proper resolution of a contract.
+//
+// NOTE: Part of the ContractResolver interface.
+func (h *htlcIncomingContestResolver) SupplementState(
+	state *channeldb.OpenChannel) {
+
+	h.htlcSuccessResolver.SupplementState(state)
+
+	// Remember the peer and the confirmed SCID of the channel, so that
+	// they can be reported to the interceptor of the htlc.
+	copy(h.peer[:], state.IdentityPub.SerializeCompressed())
+
+	switch {
+	case !state.IsZeroConf():
+		h.confirmedScid = state.ShortChannelID
+
+	case state.ZeroConfConfirmed():
+		h.confirmedScid = state.ZeroConfRealScid()
+	}
+}
+
 // SupplementDeadline does nothing for an incoming htlc resolver.
 //
 // NOTE: Part of the htlcContractResolver interface.
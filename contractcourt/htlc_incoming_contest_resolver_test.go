@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"io"
 	"testing"
+	"time"
 
+	"github.com/btcsuite/btcd/btcec/v2"
 	sphinx "github.com/lightningnetwork/lightning-onion"
 	"github.com/lightningnetwork/lnd/chainntnfs"
 	"github.com/lightningnetwork/lnd/channeldb"
@@ -13,6 +15,7 @@ import (
 	"github.com/lightningnetwork/lnd/invoices"
 	"github.com/lightningnetwork/lnd/kvdb"
 	"github.com/lightningnetwork/lnd/lnmock"
+	"github.com/lightningnetwork/lnd/lntest/channels"
 	"github.com/lightningnetwork/lnd/lntest/mock"
 	"github.com/lightningnetwork/lnd/lntypes"
 	"github.com/lightningnetwork/lnd/lnwallet"
@@ -101,6 +104,100 @@ func TestHtlcIncomingResolverFwdContestedTimeout(t *testing.T) {
 	ctx.waitForResult(false)
 }
 
+// TestHtlcIncomingResolverFwdChanInfo tests that the peer and the confirmed
+// SCID of the channel are passed on when subscribing to the preimage of a
+// forwarded htlc.
+func TestHtlcIncomingResolverFwdChanInfo(t *testing.T) {
+	t.Parallel()
+	defer timeout()()
+
+	ctx := newIncomingResolverTestContext(t, false)
+
+	_, peer := btcec.PrivKeyFromBytes(channels.AlicesPrivKey)
+	scid := lnwire.NewShortChanIDFromInt(5)
+	ctx.resolver.ShortChanID = scid
+	ctx.resolver.SupplementState(&channeldb.OpenChannel{
+		IdentityPub:    peer,
+		ShortChannelID: scid,
+	})
+
+	ctx.resolve()
+
+	var expectedPeer [33]byte
+	copy(expectedPeer[:], peer.SerializeCompressed())
+
+	select {
+	case chanInfo := <-ctx.witnessBeacon.subscriptions:
+		require.Equal(t, IncomingChanInfo{
+			ShortChanID:   scid,
+			Peer:          expectedPeer,
+			ConfirmedScid: scid,
+		}, chanInfo)
+
+	case <-time.After(defaultTimeout):
+		t.Fatal("expected preimage subscription")
+	}
+
+	// Simulate a new block coming in. HTLC expires.
+	ctx.notifyEpoch(testHtlcExpiry)
+	ctx.waitForResult(false)
+}
+
+// TestHtlcIncomingResolverFwdAbandoned tests resolution of a forwarded htlc
+// that is requested to be abandoned before it expires.
+func TestHtlcIncomingResolverFwdAbandoned(t *testing.T) {
+	t.Parallel()
+	defer timeout()()
+
+	ctx := newIncomingResolverTestContext(t, false)
+
+	reportChan := make(chan *channeldb.ResolverReport)
+	ctx.resolver.Checkpoint = func(_ ContractResolver,
+		reports ...*channeldb.ResolverReport) error {
+
+		for _, report := range reports {
+			reportChan <- report
+		}
+
+		return nil
+	}
+
+	ctx.resolve()
+
+	// Simulate a new block coming in. HTLC is not yet expired.
+	ctx.notifyEpoch(testInitialBlockHeight + 1)
+
+	// Request the htlc to be abandoned, which resolves it right away.
+	close(ctx.witnessBeacon.abandonRequests)
+
+	assertResolverReport(t, reportChan, &channeldb.ResolverReport{
+		Amount:          lnwire.MilliSatoshi(testHtlcAmount).ToSatoshis(),
+		ResolverType:    channeldb.ResolverTypeIncomingHtlc,
+		ResolverOutcome: channeldb.ResolverOutcomeAbandoned,
+	})
+
+	ctx.waitForResult(false)
+}
+
+// TestHtlcIncomingResolverFwdAbandonPreimageKnown tests that a forwarded htlc
+// is still claimed if its preimage became known by the time it's requested to
+// be abandoned.
+func TestHtlcIncomingResolverFwdAbandonPreimageKnown(t *testing.T) {
+	t.Parallel()
+	defer timeout()()
+
+	ctx := newIncomingResolverTestContext(t, false)
+	ctx.resolve()
+
+	// Simulate a new block coming in. HTLC is not yet expired.
+	ctx.notifyEpoch(testInitialBlockHeight + 1)
+
+	ctx.witnessBeacon.lookupPreimage[testResHash] = testResPreimage
+	close(ctx.witnessBeacon.abandonRequests)
+
+	ctx.waitForResult(true)
+}
+
 // TestHtlcIncomingResolverFwdTimeout tests resolution of a forwarded htlc that
 // has already expired when the resolver starts.
 func TestHtlcIncomingResolverFwdTimeout(t *testing.T) {
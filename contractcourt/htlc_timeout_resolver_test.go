@@ -22,7 +22,6 @@ import (
 	"github.com/lightningnetwork/lnd/lntest/mock"
 	"github.com/lightningnetwork/lnd/lntypes"
 	"github.com/lightningnetwork/lnd/lnwallet"
-	"github.com/lightningnetwork/lnd/lnwire"
 	"github.com/stretchr/testify/require"
 )
 
@@ -33,6 +32,8 @@ var (
 
 type mockWitnessBeacon struct {
 	preImageUpdates chan lntypes.Preimage
+	abandonRequests chan struct{}
+	subscriptions   chan IncomingChanInfo
 	newPreimages    chan []lntypes.Preimage
 	lookupPreimage  map[lntypes.Hash]lntypes.Preimage
 }
@@ -40,18 +41,28 @@ type mockWitnessBeacon struct {
 func newMockWitnessBeacon() *mockWitnessBeacon {
 	return &mockWitnessBeacon{
 		preImageUpdates: make(chan lntypes.Preimage, 1),
+		abandonRequests: make(chan struct{}),
+		subscriptions:   make(chan IncomingChanInfo, 1),
 		newPreimages:    make(chan []lntypes.Preimage),
 		lookupPreimage:  make(map[lntypes.Hash]lntypes.Preimage),
 	}
 }
 
 func (m *mockWitnessBeacon) SubscribeUpdates(
-	chanID lnwire.ShortChannelID, htlc *channeldb.HTLC,
+	chanInfo IncomingChanInfo, htlc *channeldb.HTLC,
 	payload *hop.Payload,
 	nextHopOnionBlob []byte) (*WitnessSubscription, error) {
 
+	// Record the channel info of the subscription, without blocking
+	// tests that don't read it.
+	select {
+	case m.subscriptions <- chanInfo:
+	default:
+	}
+
 	return &WitnessSubscription{
 		WitnessUpdates:     m.preImageUpdates,
+		AbandonRequests:    m.abandonRequests,
 		CancelSubscription: func() {},
 	}, nil
 }
@@ -3,6 +3,7 @@ package htlcswitch
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/lightningnetwork/lnd/channeldb/models"
 )
@@ -12,11 +13,20 @@ import (
 // way.
 type heldHtlcSet struct {
 	set map[models.CircuitKey]InterceptedForward
+
+	// heldSince records when each forward was added to the set.
+	heldSince map[models.CircuitKey]time.Time
+
+	// timers holds the running timers of the forwards in the set. A timer
+	// is stopped when its forward is removed from the set.
+	timers map[models.CircuitKey]*time.Timer
 }
 
 func newHeldHtlcSet() *heldHtlcSet {
 	return &heldHtlcSet{
-		set: make(map[models.CircuitKey]InterceptedForward),
+		set:       make(map[models.CircuitKey]InterceptedForward),
+		heldSince: make(map[models.CircuitKey]time.Time),
+		timers:    make(map[models.CircuitKey]*time.Timer),
 	}
 }
 
@@ -34,7 +44,13 @@ func (h *heldHtlcSet) popAll(cb func(InterceptedForward)) {
 		cb(fwd)
 	}
 
+	for _, timer := range h.timers {
+		timer.Stop()
+	}
+
 	h.set = make(map[models.CircuitKey]InterceptedForward)
+	h.heldSince = make(map[models.CircuitKey]time.Time)
+	h.timers = make(map[models.CircuitKey]*time.Timer)
 }
 
 // popAutoFails calls the callback for each forward that has an auto-fail height
@@ -47,7 +63,7 @@ func (h *heldHtlcSet) popAutoFails(height uint32, cb func(InterceptedForward)) {
 
 		cb(fwd)
 
-		delete(h.set, key)
+		h.remove(key)
 	}
 }
 
@@ -58,11 +74,70 @@ func (h *heldHtlcSet) pop(key models.CircuitKey) (InterceptedForward, error) {
 		return nil, fmt.Errorf("fwd %v not found", key)
 	}
 
-	delete(h.set, key)
+	h.remove(key)
 
 	return intercepted, nil
 }
 
+// remove removes the specified forward from the set and stops its timer.
+func (h *heldHtlcSet) remove(key models.CircuitKey) {
+	h.stopTimer(key)
+
+	delete(h.set, key)
+	delete(h.heldSince, key)
+}
+
+// startTimer starts a timer that calls f once the given duration passed since
+// the specified forward was added to the set. A timer that is already running
+// for the forward is stopped first.
+func (h *heldHtlcSet) startTimer(key models.CircuitKey, d time.Duration,
+	f func()) error {
+
+	heldSince, ok := h.heldSince[key]
+	if !ok {
+		return fmt.Errorf("fwd %v not found", key)
+	}
+
+	h.stopTimer(key)
+
+	remaining := d - time.Since(heldSince)
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	h.timers[key] = time.AfterFunc(remaining, f)
+
+	return nil
+}
+
+// stopTimer stops the timer of the specified forward, if it has one.
+func (h *heldHtlcSet) stopTimer(key models.CircuitKey) {
+	timer, ok := h.timers[key]
+	if !ok {
+		return
+	}
+
+	timer.Stop()
+	delete(h.timers, key)
+}
+
+// held returns for how long the specified forward has been part of the set.
+func (h *heldHtlcSet) held(key models.CircuitKey) (time.Duration, bool) {
+	heldSince, ok := h.heldSince[key]
+	if !ok {
+		return 0, false
+	}
+
+	return time.Since(heldSince), true
+}
+
+// get returns the specified forward, if it is part of the set.
+func (h *heldHtlcSet) get(key models.CircuitKey) (InterceptedForward, bool) {
+	fwd, ok := h.set[key]
+
+	return fwd, ok
+}
+
 // exists tests whether the specified forward is part of the set.
 func (h *heldHtlcSet) exists(key models.CircuitKey) bool {
 	_, ok := h.set[key]
@@ -84,6 +159,7 @@ func (h *heldHtlcSet) push(key models.CircuitKey,
 	}
 
 	h.set[key] = fwd
+	h.heldSince[key] = time.Now()
 
 	return nil
 }
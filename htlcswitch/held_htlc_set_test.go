@@ -2,6 +2,7 @@ package htlcswitch
 
 import (
 	"testing"
+	"time"
 
 	"github.com/lightningnetwork/lnd/channeldb/models"
 	"github.com/lightningnetwork/lnd/lnwire"
@@ -124,3 +125,51 @@ func TestHeldHtlcSetAutoFails(t *testing.T) {
 		},
 	)
 }
+
+// TestHeldHtlcSetTimers tests that the timers of held forwards are stopped when
+// the forwards are removed from the set.
+func TestHeldHtlcSetTimers(t *testing.T) {
+	set := newHeldHtlcSet()
+
+	key := models.CircuitKey{
+		ChanID: lnwire.NewShortChanIDFromInt(1),
+		HtlcID: 2,
+	}
+	fwd := &interceptedForward{
+		htlc: &lnwire.UpdateAddHTLC{},
+	}
+
+	// A timer can only be started for a held forward.
+	fired := make(chan struct{}, 2)
+	fire := func() { fired <- struct{}{} }
+	require.Error(t, set.startTimer(key, 0, fire))
+
+	// A running timer is replaced when a new one is started.
+	require.NoError(t, set.push(key, fwd))
+	require.NoError(t, set.startTimer(key, time.Hour, fire))
+	require.NoError(t, set.startTimer(key, 0, fire))
+
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		require.Fail(t, "timer didn't fire")
+	}
+
+	// Popping the forward stops its timer.
+	require.NoError(t, set.startTimer(key, 50*time.Millisecond, fire))
+	_, err := set.pop(key)
+	require.NoError(t, err)
+	require.Empty(t, set.timers)
+
+	// So does popping all forwards.
+	require.NoError(t, set.push(key, fwd))
+	require.NoError(t, set.startTimer(key, 50*time.Millisecond, fire))
+	set.popAll(func(_ InterceptedForward) {})
+	require.Empty(t, set.timers)
+
+	select {
+	case <-fired:
+		require.Fail(t, "timer of removed forward fired")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
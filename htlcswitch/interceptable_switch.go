@@ -4,6 +4,7 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/go-errors/errors"
 	"github.com/lightningnetwork/lnd/chainntnfs"
@@ -23,6 +24,10 @@ var (
 	ErrUnsupportedFailureCode = errors.New("unsupported failure code")
 
 	errBlockStreamStopped = errors.New("block epoch stream stopped")
+
+	// ErrInvalidHoldPolicy is returned when a hold policy has a deadline
+	// action that can't be applied automatically.
+	ErrInvalidHoldPolicy = errors.New("invalid interceptor hold policy")
 )
 
 // InterceptableSwitch is an implementation of ForwardingSwitch interface.
@@ -50,6 +55,14 @@ type InterceptableSwitch struct {
 	// client connect and disconnect.
 	interceptorRegistration chan ForwardInterceptor
 
+	// holdPolicyUpdates is where we receive the hold policy overrides of
+	// interceptor sessions.
+	holdPolicyUpdates chan InterceptorHoldPolicy
+
+	// holdDeadlines is where the hold timers of held forwards signal that
+	// their deadline passed.
+	holdDeadlines chan InterceptedForward
+
 	// requireInterceptor indicates whether processing should block if no
 	// interceptor is connected.
 	requireInterceptor bool
@@ -57,6 +70,14 @@ type InterceptableSwitch struct {
 	// interceptor is the handler for intercepted packets.
 	interceptor ForwardInterceptor
 
+	// holdPolicy is the hold policy that applies to held forwards, unless
+	// the current interceptor session overrides it.
+	holdPolicy InterceptorHoldPolicy
+
+	// sessionHoldPolicy is the hold policy of the current interceptor
+	// session, if it overrides the configured one.
+	sessionHoldPolicy *InterceptorHoldPolicy
+
 	// heldHtlcSet keeps track of outstanding intercepted forwards.
 	heldHtlcSet *heldHtlcSet
 
@@ -107,6 +128,23 @@ const (
 	FwdActionFail
 )
 
+// String returns a human-readable name of the action.
+func (a FwdAction) String() string {
+	switch a {
+	case FwdActionResume:
+		return "resume"
+
+	case FwdActionSettle:
+		return "settle"
+
+	case FwdActionFail:
+		return "fail"
+
+	default:
+		return fmt.Sprintf("unknown<%d>", int(a))
+	}
+}
+
 // FwdResolution defines the action to be taken on an intercepted packet.
 type FwdResolution struct {
 	// Key is the incoming circuit key of the htlc.
@@ -133,6 +171,38 @@ type fwdResolution struct {
 	errChan    chan error
 }
 
+// InterceptorHoldPolicy defines for how long intercepted forwards are held at
+// most, and how they are resolved if the interceptor doesn't resolve them in
+// time. This prevents forwards from being held until they are about to expire
+// when the interceptor hangs.
+type InterceptorHoldPolicy struct {
+	// Deadline is the maximum time a forward is held, counted from the
+	// moment it was intercepted. A deadline of zero disables it.
+	Deadline time.Duration
+
+	// Action is the action applied to forwards that are still held when
+	// their deadline passes. Only FwdActionResume and FwdActionFail are
+	// supported. Forwards are failed with a temporary channel failure.
+	Action FwdAction
+}
+
+// Validate checks that the hold policy can be applied.
+func (p InterceptorHoldPolicy) Validate() error {
+	if p.Deadline < 0 {
+		return fmt.Errorf("%w: negative deadline %v",
+			ErrInvalidHoldPolicy, p.Deadline)
+	}
+
+	switch p.Action {
+	case FwdActionResume, FwdActionFail:
+		return nil
+
+	default:
+		return fmt.Errorf("%w: unsupported deadline action %v",
+			ErrInvalidHoldPolicy, p.Action)
+	}
+}
+
 // InterceptableSwitchConfig contains the configuration of InterceptableSwitch.
 type InterceptableSwitchConfig struct {
 	// Switch is a reference to the actual switch implementation that
@@ -158,6 +228,11 @@ type InterceptableSwitchConfig struct {
 	// RequireInterceptor indicates whether processing should block if no
 	// interceptor is connected.
 	RequireInterceptor bool
+
+	// HoldPolicy is the hold policy of intercepted forwards. Interceptor
+	// sessions may override it. Forwards that are resolved on chain are
+	// exempt from it.
+	HoldPolicy InterceptorHoldPolicy
 }
 
 // NewInterceptableSwitch returns an instance of InterceptableSwitch.
@@ -170,17 +245,24 @@ func NewInterceptableSwitch(cfg *InterceptableSwitchConfig) (
 			cfg.CltvInterceptDelta, cfg.CltvRejectDelta)
 	}
 
+	if err := cfg.HoldPolicy.Validate(); err != nil {
+		return nil, err
+	}
+
 	return &InterceptableSwitch{
 		htlcSwitch:              cfg.Switch,
 		intercepted:             make(chan *interceptedPackets),
 		onchainIntercepted:      make(chan InterceptedForward),
 		interceptorRegistration: make(chan ForwardInterceptor),
+		holdPolicyUpdates:       make(chan InterceptorHoldPolicy),
+		holdDeadlines:           make(chan InterceptedForward),
 		heldHtlcSet:             newHeldHtlcSet(),
 		resolutionChan:          make(chan *fwdResolution),
 		requireInterceptor:      cfg.RequireInterceptor,
 		cltvRejectDelta:         cfg.CltvRejectDelta,
 		cltvInterceptDelta:      cfg.CltvInterceptDelta,
 		notifier:                cfg.Notifier,
+		holdPolicy:              cfg.HoldPolicy,
 
 		quit: make(chan struct{}),
 	}, nil
@@ -200,6 +282,17 @@ func (s *InterceptableSwitch) SetInterceptor(
 	}
 }
 
+// SetHoldPolicy overrides the hold policy for the current interceptor session.
+// It applies to all forwards held during the session, including those that
+// were held when the interceptor connected, until the interceptor disconnects.
+func (s *InterceptableSwitch) SetHoldPolicy(policy InterceptorHoldPolicy) {
+	select {
+	case s.holdPolicyUpdates <- policy:
+
+	case <-s.quit:
+	}
+}
+
 func (s *InterceptableSwitch) Start() error {
 	blockEpochStream, err := s.notifier.RegisterBlockEpochNtfn(nil)
 	if err != nil {
@@ -252,6 +345,17 @@ func (s *InterceptableSwitch) run() error {
 		case interceptor := <-s.interceptorRegistration:
 			s.setInterceptor(interceptor)
 
+		case policy := <-s.holdPolicyUpdates:
+			// The override only applies to a connected
+			// interceptor's session.
+			if s.interceptor != nil {
+				s.sessionHoldPolicy = &policy
+				s.restartHoldTimers()
+			}
+
+		case fwd := <-s.holdDeadlines:
+			s.resolveHoldDeadline(fwd)
+
 		case packets := <-s.intercepted:
 			var notIntercepted []*htlcPacket
 			for _, p := range packets.packets {
@@ -321,6 +425,105 @@ func (s *InterceptableSwitch) failExpiredHtlcs() {
 	)
 }
 
+// currentHoldPolicy returns the hold policy of the current interceptor
+// session.
+func (s *InterceptableSwitch) currentHoldPolicy() InterceptorHoldPolicy {
+	if s.sessionHoldPolicy != nil {
+		return *s.sessionHoldPolicy
+	}
+
+	return s.holdPolicy
+}
+
+// startHoldTimer starts the timer that resolves the given forward once its
+// hold deadline passes, if the forward is held and the hold policy has a
+// deadline. The deadline is counted from the moment the forward was held, and
+// replaces the one the forward had before.
+func (s *InterceptableSwitch) startHoldTimer(fwd InterceptedForward) {
+	// Forwards that are resolved on chain are exempt from the hold policy.
+	if _, ok := fwd.(*interceptedForward); !ok {
+		return
+	}
+
+	inKey := fwd.Packet().IncomingCircuit
+
+	// The forward isn't held if it was resolved right away, and another
+	// forward may be held for the same htlc already.
+	held, ok := s.heldHtlcSet.get(inKey)
+	if !ok || held != fwd {
+		return
+	}
+
+	policy := s.currentHoldPolicy()
+	if policy.Deadline == 0 {
+		s.heldHtlcSet.stopTimer(inKey)
+
+		return
+	}
+
+	// The timer is stopped when the forward is removed from the held set.
+	err := s.heldHtlcSet.startTimer(inKey, policy.Deadline, func() {
+		select {
+		case s.holdDeadlines <- fwd:
+
+		case <-s.quit:
+		}
+	})
+	if err != nil {
+		log.Errorf("Cannot start hold timer of forward %v: %v", inKey,
+			err)
+	}
+}
+
+// restartHoldTimers applies the current hold policy to all held forwards.
+func (s *InterceptableSwitch) restartHoldTimers() {
+	s.heldHtlcSet.forEach(s.startHoldTimer)
+}
+
+// resolveHoldDeadline applies the deadline action of the hold policy to a
+// forward that is still held after its hold deadline.
+func (s *InterceptableSwitch) resolveHoldDeadline(fwd InterceptedForward) {
+	inKey := fwd.Packet().IncomingCircuit
+
+	held, ok := s.heldHtlcSet.get(inKey)
+	if !ok || held != fwd {
+		return
+	}
+
+	// A timer may fire right before it is replaced by one of a new hold
+	// policy. Only apply the deadline if it passed under the current one.
+	policy := s.currentHoldPolicy()
+	heldFor, _ := s.heldHtlcSet.held(inKey)
+	if policy.Deadline == 0 || heldFor < policy.Deadline {
+		return
+	}
+
+	if _, err := s.heldHtlcSet.pop(inKey); err != nil {
+		log.Errorf("Cannot pop held forward %v: %v", inKey, err)
+		return
+	}
+
+	log.Infof("Hold deadline of intercepted htlc %v passed, applying "+
+		"action %v", inKey, policy.Action)
+
+	var err error
+	switch policy.Action {
+	case FwdActionResume:
+		err = fwd.Resume()
+
+	case FwdActionFail:
+		err = fwd.FailWithCode(lnwire.CodeTemporaryChannelFailure)
+
+	default:
+		err = fmt.Errorf("unsupported deadline action %v",
+			policy.Action)
+	}
+	if err != nil {
+		log.Errorf("Cannot resolve held forward %v after its hold "+
+			"deadline: %v", inKey, err)
+	}
+}
+
 func (s *InterceptableSwitch) sendForward(fwd InterceptedForward) {
 	err := s.interceptor(fwd.Packet())
 	if err != nil {
@@ -333,6 +536,14 @@ func (s *InterceptableSwitch) sendForward(fwd InterceptedForward) {
 func (s *InterceptableSwitch) setInterceptor(interceptor ForwardInterceptor) {
 	s.interceptor = interceptor
 
+	// A new session starts with the configured hold policy. The deadlines
+	// of the forwards that are still held are reset to it in the same step,
+	// so that no forward keeps the policy of the previous session.
+	if s.sessionHoldPolicy != nil {
+		s.sessionHoldPolicy = nil
+		s.restartHoldTimers()
+	}
+
 	// Replay all currently held htlcs. When an interceptor is not required,
 	// there may be none because they've been cleared after the previous
 	// disconnect.
@@ -463,12 +674,22 @@ func (s *InterceptableSwitch) interceptForward(packet *htlcPacket,
 			return false, nil
 		}
 
+		// Look up the peer and the channel the htlc came in from, so
+		// that the interceptor doesn't need to map the incoming
+		// channel itself.
+		peer, alias, confirmedScid := s.htlcSwitch.incomingChanContext(
+			packet.incomingChanID,
+		)
+
 		intercepted := &interceptedForward{
 			htlc:       htlc,
 			packet:     packet,
 			htlcSwitch: s.htlcSwitch,
 			autoFailHeight: int32(packet.incomingTimeout -
 				s.cltvRejectDelta),
+			incomingPeer:          peer,
+			incomingAlias:         alias,
+			incomingConfirmedScid: confirmedScid,
 		}
 
 		// Handle forwards that are too close to expiry.
@@ -492,7 +713,18 @@ func (s *InterceptableSwitch) interceptForward(packet *htlcPacket,
 			return true, nil
 		}
 
-		return s.forward(intercepted, isReplay)
+		held, err := s.forward(intercepted, isReplay)
+		if err != nil || !held {
+			return held, err
+		}
+
+		// Limit the time the forward is held. Only forwards that are
+		// intercepted off-chain have a hold deadline. Forwards that
+		// are resolved on chain can't be resumed, and failing them
+		// abandons the htlc.
+		s.startHoldTimer(intercepted)
+
+		return true, nil
 
 	default:
 		return false, nil
@@ -587,6 +819,12 @@ type interceptedForward struct {
 	packet         *htlcPacket
 	htlcSwitch     *Switch
 	autoFailHeight int32
+
+	// incomingPeer, incomingAlias and incomingConfirmedScid describe the
+	// peer and the channel that the htlc came in from.
+	incomingPeer          [33]byte
+	incomingAlias         lnwire.ShortChannelID
+	incomingConfirmedScid lnwire.ShortChannelID
 }
 
 // Packet returns the intercepted htlc packet.
@@ -605,6 +843,10 @@ func (f *interceptedForward) Packet() InterceptedPacket {
 		CustomRecords:  f.packet.customRecords,
 		OnionBlob:      f.htlc.OnionBlob,
 		AutoFailHeight: f.autoFailHeight,
+
+		IncomingPeer:          f.incomingPeer,
+		IncomingAlias:         f.incomingAlias,
+		IncomingConfirmedScid: f.incomingConfirmedScid,
 	}
 }
 
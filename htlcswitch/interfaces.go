@@ -310,6 +310,10 @@ type InterceptableHtlcForwarder interface {
 	// SetInterceptor sets a ForwardInterceptor.
 	SetInterceptor(interceptor ForwardInterceptor)
 
+	// SetHoldPolicy overrides the hold policy for the session of the
+	// current ForwardInterceptor.
+	SetHoldPolicy(policy InterceptorHoldPolicy)
+
 	// Resolve resolves an intercepted packet.
 	Resolve(res *FwdResolution) error
 }
@@ -359,6 +363,19 @@ type InterceptedPacket struct {
 	// AutoFailHeight is the block height at which this intercept will be
 	// failed back automatically.
 	AutoFailHeight int32
+
+	// IncomingPeer is the public key of the peer that offered the htlc.
+	// It's unset if the incoming channel can't be found.
+	IncomingPeer [33]byte
+
+	// IncomingAlias is an alias of the incoming channel. It's only set for
+	// zero-conf and option-scid-alias channels.
+	IncomingAlias lnwire.ShortChannelID
+
+	// IncomingConfirmedScid is the confirmed short channel ID of the
+	// incoming channel. It's unset for zero-conf channels that haven't
+	// confirmed yet.
+	IncomingConfirmedScid lnwire.ShortChannelID
 }
 
 // InterceptedForward is passed to the ForwardInterceptor for every forwarded
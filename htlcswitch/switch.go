@@ -510,6 +510,34 @@ func (s *Switch) GetAttemptResult(attemptID uint64, paymentHash lntypes.Hash,
 	return resultChan, nil
 }
 
+// HasAttemptCircuit returns true if the switch has a circuit for the locally
+// initiated htlc attempt with the given attemptID. The circuit exists from the
+// time the htlc is dispatched until its result has been stored, including
+// while the htlc is being resolved on chain.
+func (s *Switch) HasAttemptCircuit(attemptID uint64) bool {
+	inKey := CircuitKey{
+		ChanID: hop.Source,
+		HtlcID: attemptID,
+	}
+
+	return s.circuits.LookupCircuit(inKey) != nil
+}
+
+// HasAttemptResult returns true if the result of the locally initiated htlc
+// attempt with the given attemptID is available in the network result store.
+func (s *Switch) HasAttemptResult(attemptID uint64) (bool, error) {
+	_, err := s.networkResults.getResult(attemptID)
+	switch {
+	case errors.Is(err, ErrPaymentIDNotFound):
+		return false, nil
+
+	case err != nil:
+		return false, err
+	}
+
+	return true, nil
+}
+
 // CleanStore calls the underlying result store, telling it is safe to delete
 // all entries except the ones in the keepPids map. This should be called
 // preiodically to let the switch clean up payment results that we have
@@ -2443,6 +2471,42 @@ func (s *Switch) GetLinkByShortID(chanID lnwire.ShortChannelID) (ChannelLink,
 	return link, nil
 }
 
+// incomingChanContext returns the peer of the link with the given short
+// channel ID, along with an alias and the confirmed SCID of the link's
+// channel. The alias is only set for zero-conf and option-scid-alias channels,
+// and the confirmed SCID isn't set for zero-conf channels that haven't
+// confirmed yet. Zero values are returned if the link can't be found.
+func (s *Switch) incomingChanContext(chanID lnwire.ShortChannelID) ([33]byte,
+	lnwire.ShortChannelID, lnwire.ShortChannelID) {
+
+	var (
+		peer                 [33]byte
+		alias, confirmedScid lnwire.ShortChannelID
+	)
+
+	link, err := s.GetLinkByShortID(chanID)
+	if err != nil {
+		log.Debugf("Unable to find incoming link %v: %v", chanID, err)
+
+		return peer, alias, confirmedScid
+	}
+
+	peer = link.PeerPubKey()
+	if aliases := link.getAliases(); len(aliases) > 0 {
+		alias = aliases[0]
+	}
+
+	switch {
+	case !link.isZeroConf():
+		confirmedScid = link.ShortChanID()
+
+	case link.zeroConfConfirmed():
+		confirmedScid = link.confirmedScid()
+	}
+
+	return peer, alias, confirmedScid
+}
+
 // getLinkByShortID attempts to return the link which possesses the target
 // short channel ID.
 //
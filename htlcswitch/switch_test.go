@@ -3979,9 +3979,21 @@ func TestSwitchHoldForward(t *testing.T) {
 	assertNumCircuits(t, c.s, 0, 0)
 	assertOutgoingLinkReceive(t, c.bobChannelLink, false)
 
+	// The interceptor learns about the peer and the channel that the htlc
+	// came in from.
+	intercepted := c.forwardInterceptor.getIntercepted()
+	require.Equal(
+		t, c.aliceChannelLink.PeerPubKey(), intercepted.IncomingPeer,
+	)
+	require.Equal(
+		t, c.aliceChannelLink.ShortChanID(),
+		intercepted.IncomingConfirmedScid,
+	)
+	require.Zero(t, intercepted.IncomingAlias)
+
 	require.NoError(t, switchForwardInterceptor.Resolve(&FwdResolution{
 		Action: FwdActionResume,
-		Key:    c.forwardInterceptor.getIntercepted().IncomingCircuit,
+		Key:    intercepted.IncomingCircuit,
 	}))
 	receivedPkt = assertOutgoingLinkReceive(t, c.bobChannelLink, true)
 	assertNumCircuits(t, c.s, 1, 1)
@@ -4175,7 +4187,7 @@ func TestSwitchHoldForward(t *testing.T) {
 	)
 
 	// A replay of the held packet is expected.
-	intercepted := c.forwardInterceptor.getIntercepted()
+	intercepted = c.forwardInterceptor.getIntercepted()
 
 	// Settle the packet.
 	require.NoError(t, switchForwardInterceptor.Resolve(&FwdResolution{
@@ -4258,6 +4270,188 @@ func TestInterceptableSwitchWatchDog(t *testing.T) {
 	}))
 }
 
+// mockOnchainForward is an intercepted forward that is resolved on chain.
+type mockOnchainForward struct {
+	packet  InterceptedPacket
+	actions chan FwdAction
+}
+
+func (f *mockOnchainForward) Packet() InterceptedPacket {
+	return f.packet
+}
+
+func (f *mockOnchainForward) Resume() error {
+	f.actions <- FwdActionResume
+	return nil
+}
+
+func (f *mockOnchainForward) Settle(lntypes.Preimage) error {
+	f.actions <- FwdActionSettle
+	return nil
+}
+
+func (f *mockOnchainForward) Fail([]byte) error {
+	f.actions <- FwdActionFail
+	return nil
+}
+
+func (f *mockOnchainForward) FailWithCode(lnwire.FailCode) error {
+	f.actions <- FwdActionFail
+	return nil
+}
+
+// TestInterceptableSwitchHoldDeadline tests that held forwards are resolved
+// with the action of the hold policy once their hold deadline passes, and that
+// interceptor sessions can override the configured hold policy.
+func TestInterceptableSwitchHoldDeadline(t *testing.T) {
+	t.Parallel()
+
+	const deadline = 100 * time.Millisecond
+
+	c := newInterceptableSwitchTestContext(t)
+	defer c.finish()
+
+	// A hold policy that can't be applied automatically is rejected.
+	_, err := NewInterceptableSwitch(&InterceptableSwitchConfig{
+		Switch:             c.s,
+		CltvRejectDelta:    c.cltvRejectDelta,
+		CltvInterceptDelta: c.cltvInterceptDelta,
+		HoldPolicy: InterceptorHoldPolicy{
+			Deadline: deadline,
+			Action:   FwdActionSettle,
+		},
+	})
+	require.ErrorIs(t, err, ErrInvalidHoldPolicy)
+
+	notifier := &mock.ChainNotifier{
+		EpochChan: make(chan *chainntnfs.BlockEpoch, 1),
+	}
+	notifier.EpochChan <- &chainntnfs.BlockEpoch{Height: testStartingHeight}
+
+	switchForwardInterceptor, err := NewInterceptableSwitch(
+		&InterceptableSwitchConfig{
+			Switch:             c.s,
+			CltvRejectDelta:    c.cltvRejectDelta,
+			CltvInterceptDelta: c.cltvInterceptDelta,
+			Notifier:           notifier,
+			HoldPolicy: InterceptorHoldPolicy{
+				Deadline: deadline,
+				Action:   FwdActionResume,
+			},
+		},
+	)
+	require.NoError(t, err)
+	require.NoError(t, switchForwardInterceptor.Start())
+	defer func() {
+		require.NoError(t, switchForwardInterceptor.Stop())
+	}()
+
+	switchForwardInterceptor.SetInterceptor(
+		c.forwardInterceptor.InterceptForwardHtlc,
+	)
+	linkQuit := make(chan struct{})
+
+	// The interceptor doesn't resolve the forward, so it is resumed once
+	// its hold deadline passes.
+	require.NoError(t, switchForwardInterceptor.ForwardPackets(
+		linkQuit, false, c.createTestPacket(),
+	))
+	intercepted := c.forwardInterceptor.getIntercepted()
+
+	receivedPkt := assertOutgoingLinkReceive(t, c.bobChannelLink, true)
+	assertNumCircuits(t, c.s, 1, 1)
+
+	// It is too late now to resolve. Expect an error.
+	require.Error(t, switchForwardInterceptor.Resolve(&FwdResolution{
+		Action:   FwdActionSettle,
+		Key:      intercepted.IncomingCircuit,
+		Preimage: c.preimage,
+	}))
+
+	require.NoError(t, switchForwardInterceptor.ForwardPackets(
+		linkQuit, false,
+		c.createSettlePacket(receivedPkt.outgoingHTLCID),
+	))
+	assertOutgoingLinkReceive(t, c.aliceChannelLink, true)
+	assertNumCircuits(t, c.s, 0, 0)
+
+	// A forward that is resolved in time isn't touched anymore when its
+	// hold deadline passes.
+	require.NoError(t, switchForwardInterceptor.ForwardPackets(
+		linkQuit, false, c.createTestPacket(),
+	))
+	intercepted = c.forwardInterceptor.getIntercepted()
+
+	require.NoError(t, switchForwardInterceptor.Resolve(&FwdResolution{
+		Action:   FwdActionSettle,
+		Key:      intercepted.IncomingCircuit,
+		Preimage: c.preimage,
+	}))
+	assertOutgoingLinkReceive(t, c.aliceChannelLink, true)
+	assertOutgoingLinkReceive(t, c.bobChannelLink, false)
+	assertNumCircuits(t, c.s, 0, 0)
+
+	// The session overrides the hold policy, so the forward is failed
+	// once its hold deadline passes. This also applies to the forwards
+	// that were held before the override arrived.
+	require.NoError(t, switchForwardInterceptor.ForwardPackets(
+		linkQuit, false, c.createTestPacket(),
+	))
+	_ = c.forwardInterceptor.getIntercepted()
+
+	switchForwardInterceptor.SetHoldPolicy(InterceptorHoldPolicy{
+		Deadline: deadline,
+		Action:   FwdActionFail,
+	})
+
+	packet := assertOutgoingLinkReceive(t, c.aliceChannelLink, true)
+	require.IsType(t, &lnwire.UpdateFailHTLC{}, packet.htlc)
+	assertOutgoingLinkReceive(t, c.bobChannelLink, false)
+	assertNumCircuits(t, c.s, 0, 0)
+
+	// Forwards that are resolved on chain are exempt from the hold
+	// deadline.
+	onchainFwd := &mockOnchainForward{
+		packet: InterceptedPacket{
+			IncomingCircuit: models.CircuitKey{
+				ChanID: c.aliceChannelLink.ShortChanID(),
+				HtlcID: 100,
+			},
+		},
+		actions: make(chan FwdAction, 1),
+	}
+	require.NoError(t, switchForwardInterceptor.ForwardPacket(onchainFwd))
+	_ = c.forwardInterceptor.getIntercepted()
+
+	select {
+	case action := <-onchainFwd.actions:
+		require.Failf(t, "unexpected resolution", "action: %v", action)
+
+	case <-time.After(5 * deadline):
+	}
+
+	// A new session starts with the configured hold policy again.
+	switchForwardInterceptor.SetInterceptor(nil)
+	require.Equal(t, FwdActionResume, <-onchainFwd.actions)
+
+	switchForwardInterceptor.SetInterceptor(
+		c.forwardInterceptor.InterceptForwardHtlc,
+	)
+
+	require.NoError(t, switchForwardInterceptor.ForwardPackets(
+		linkQuit, false, c.createTestPacket(),
+	))
+	_ = c.forwardInterceptor.getIntercepted()
+
+	receivedPkt = assertOutgoingLinkReceive(t, c.bobChannelLink, true)
+	require.NoError(t, switchForwardInterceptor.ForwardPackets(
+		linkQuit, false,
+		c.createSettlePacket(receivedPkt.outgoingHTLCID),
+	))
+	assertOutgoingLinkReceive(t, c.aliceChannelLink, true)
+	assertNumCircuits(t, c.s, 0, 0)
+}
+
 // TestSwitchDustForwarding tests that the switch properly fails HTLC's which
 // have incoming or outgoing links that breach their dust thresholds.
 func TestSwitchDustForwarding(t *testing.T) {
@@ -14,7 +14,8 @@ var (
 	ErrCannotResume = errors.New("cannot resume in the on-chain flow")
 
 	// ErrCannotFail is returned when an intercepted forward cannot be failed.
-	// This is the case in the on-chain resolution flow.
+	// This is the case in the on-chain resolution flow if the preimage is
+	// already known or the htlc has already been resolved.
 	ErrCannotFail = errors.New("cannot fail in the on-chain flow")
 
 	// ErrPreimageMismatch is returned when the preimage that is specified to
@@ -54,17 +55,17 @@ func (f *interceptedForward) Resume() error {
 // Fail notifies the intention to fail an existing hold forward with an
 // encrypted failure reason.
 func (f *interceptedForward) Fail(_ []byte) error {
-	// We can't actively fail an htlc. The best we could do is abandon the
-	// resolver, but this wouldn't be a safe operation. There may be a race
-	// with the preimage beacon supplying a preimage. Therefore we don't
-	// attempt to fail and just return an error here.
-	return ErrCannotFail
+	// We can't actively fail an htlc on-chain. Instead we request the
+	// resolver to abandon the htlc, so that it stops waiting for the
+	// preimage. The beacon makes sure that this can't race with a preimage
+	// being supplied, and errors if the preimage is already known.
+	return f.beacon.AbandonHtlc(f.packet.Hash, f.packet.IncomingCircuit)
 }
 
 // FailWithCode notifies the intention to fail an existing hold forward with the
 // specified failure code.
 func (f *interceptedForward) FailWithCode(_ lnwire.FailCode) error {
-	return ErrCannotFail
+	return f.beacon.AbandonHtlc(f.packet.Hash, f.packet.IncomingCircuit)
 }
 
 // Settle notifies the intention to settle an existing hold forward with a given
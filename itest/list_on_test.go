@@ -185,6 +185,22 @@ var allTestCases = []*lntest.TestCase{
 		Name:     "list payments",
 		TestFunc: testListPayments,
 	},
+	{
+		Name:     "list payments pagination",
+		TestFunc: testListPaymentsPagination,
+	},
+	{
+		Name:     "list payments skip htlcs",
+		TestFunc: testListPaymentsSkipHtlcs,
+	},
+	{
+		Name:     "count payments",
+		TestFunc: testCountPayments,
+	},
+	{
+		Name:     "list payments failure details",
+		TestFunc: testListPaymentsFailureDetails,
+	},
 	{
 		Name:     "send direct payment",
 		TestFunc: testSendDirectPayment,
@@ -402,6 +418,10 @@ var allTestCases = []*lntest.TestCase{
 		Name:     "sendtoroute multi path payment",
 		TestFunc: testSendToRouteMultiPath,
 	},
+	{
+		Name:     "delete failed htlcs of mpp payment",
+		TestFunc: testDeleteFailedHtlcsMultiPath,
+	},
 	{
 		Name:     "send multi path payment",
 		TestFunc: testSendMultiPathPayment,
@@ -430,6 +450,14 @@ var allTestCases = []*lntest.TestCase{
 		Name:     "forward interceptor",
 		TestFunc: testForwardInterceptorBasic,
 	},
+	{
+		Name:     "forward interceptor on-chain fail",
+		TestFunc: testForwardInterceptorOnChainFail,
+	},
+	{
+		Name:     "forward interceptor hold deadline",
+		TestFunc: testForwardInterceptorHoldDeadline,
+	},
 	{
 		Name:     "zero conf channel open",
 		TestFunc: testZeroConfChannelOpen,
@@ -506,6 +534,18 @@ var allTestCases = []*lntest.TestCase{
 		Name:     "trackpayments compatible",
 		TestFunc: testTrackPaymentsCompatible,
 	},
+	{
+		Name:     "trackpayments deleted",
+		TestFunc: testTrackPaymentsDeleted,
+	},
+	{
+		Name:     "trackpayment attempt events",
+		TestFunc: testTrackPaymentAttemptEvents,
+	},
+	{
+		Name:     "trackpayment by seqnum",
+		TestFunc: testTrackPaymentBySeqNum,
+	},
 	{
 		Name:     "open channel fee policy",
 		TestFunc: testOpenChannelUpdateFeePolicy,
@@ -622,4 +662,8 @@ var allTestCases = []*lntest.TestCase{
 		Name:     "sweep commit output and anchor",
 		TestFunc: testSweepCommitOutputAndAnchor,
 	},
+	{
+		Name:     "payment lifecycle state",
+		TestFunc: testPaymentLifecycleState,
+	},
 }
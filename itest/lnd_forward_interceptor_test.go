@@ -6,8 +6,10 @@ import (
 	"time"
 
 	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/lightningnetwork/lnd/chainreg"
 	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/invoicesrpc"
 	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
 	"github.com/lightningnetwork/lnd/lntest"
 	"github.com/lightningnetwork/lnd/lntest/node"
@@ -240,6 +242,15 @@ func testForwardInterceptorBasic(ht *lntest.HarnessTest) {
 		require.Less(ht, request.OutgoingAmountMsat,
 			request.IncomingAmountMsat)
 
+		// The htlc is reported to come in from Alice over the channel
+		// that she opened with Bob.
+		require.Equal(ht, alice.PubKey[:], request.IncomingPeerPubkey)
+		require.Equal(
+			ht, request.IncomingCircuitKey.ChanId,
+			request.IncomingConfirmedScid,
+		)
+		require.Zero(ht, request.IncomingAliasScid)
+
 		value, ok := request.CustomRecords[customTestKey]
 		require.True(ht, ok, "expected custom record")
 		require.Equal(ht, customTestValue, value)
@@ -344,6 +355,230 @@ func testForwardInterceptorBasic(ht *lntest.HarnessTest) {
 	ht.CloseChannel(bob, cpBC)
 }
 
+// testForwardInterceptorOnChainFail tests that an htlc that is intercepted in
+// the on-chain resolution flow can be failed by the interceptor while its
+// preimage is unknown. The resolver then stops waiting for the preimage, and
+// the htlc is timed out by the sender right after its expiry.
+func testForwardInterceptorOnChainFail(ht *lntest.HarnessTest) {
+	ts := newInterceptorTestScenario(ht)
+
+	alice, bob, carol := ts.alice, ts.bob, ts.carol
+
+	// Open and wait for channels.
+	const chanAmt = btcutil.Amount(300000)
+	p := lntest.OpenChannelParams{Amt: chanAmt}
+	reqs := []*lntest.OpenChannelRequest{
+		{Local: alice, Remote: bob, Param: p},
+		{Local: bob, Remote: carol, Param: p},
+	}
+	resp := ht.OpenMultiChannelsAsync(reqs)
+	cpAB, cpBC := resp[0], resp[1]
+
+	// Make sure Alice is aware of channel Bob=>Carol.
+	ht.AssertTopologyChannelOpen(alice, cpBC)
+
+	// Connect the interceptor.
+	interceptor, cancelInterceptor := bob.RPC.HtlcInterceptor()
+	defer cancelInterceptor()
+
+	// Carol adds a hold invoice, so that the htlc stays pending on both
+	// channels.
+	var preimage lntypes.Preimage
+	copy(preimage[:], ht.Random32Bytes())
+	payHash := preimage.Hash()
+	invoiceReq := &invoicesrpc.AddHoldInvoiceRequest{
+		Value:      30000,
+		CltvExpiry: finalCltvDelta,
+		Hash:       payHash[:],
+	}
+	carolInvoice := carol.RPC.AddHoldInvoice(invoiceReq)
+
+	alice.RPC.SendPayment(&routerrpc.SendPaymentRequest{
+		PaymentRequest: carolInvoice.PaymentRequest,
+		TimeoutSeconds: 60,
+		FeeLimitMsat:   noFeeLimitMsat,
+	})
+
+	// Resume the htlc that is intercepted by Bob's link, so that it's
+	// forwarded to Carol.
+	packet := ht.ReceiveHtlcInterceptor(interceptor)
+	err := interceptor.Send(&routerrpc.ForwardHtlcInterceptResponse{
+		IncomingCircuitKey: packet.IncomingCircuitKey,
+		Action:             routerrpc.ResolveHoldForwardAction_RESUME,
+	})
+	require.NoError(ht, err, "failed to send request")
+
+	ht.AssertActiveHtlcs(alice, payHash[:])
+	ht.AssertActiveHtlcs(bob, payHash[:])
+	ht.AssertActiveHtlcs(carol, payHash[:])
+
+	// Alice force closes her channel with Bob, which makes Bob resolve the
+	// incoming htlc on-chain.
+	closeStream, _ := ht.CloseChannelAssertPending(alice, cpAB, true)
+	ht.AssertStreamChannelForceClosed(alice, cpAB, true, closeStream)
+
+	// Bob's resolver waits for the preimage, which notifies the
+	// interceptor once more.
+	packet = ht.ReceiveHtlcInterceptor(interceptor)
+	require.Equal(ht, payHash[:], packet.PaymentHash)
+
+	// The interceptor knows that the htlc will never be settled and fails
+	// it, which is possible as the preimage is unknown.
+	err = interceptor.Send(&routerrpc.ForwardHtlcInterceptResponse{
+		IncomingCircuitKey: packet.IncomingCircuitKey,
+		Action:             routerrpc.ResolveHoldForwardAction_FAIL,
+	})
+	require.NoError(ht, err, "failed to send request")
+
+	// Bob abandons the htlc before it expires, so it's no longer reported
+	// as pending.
+	op := ht.OutPointFromChannelPoint(cpAB)
+	err = wait.NoError(func() error {
+		pending := bob.RPC.PendingChannels()
+		for _, ch := range pending.PendingForceClosingChannels {
+			if ch.Channel.ChannelPoint != op.String() {
+				continue
+			}
+
+			if len(ch.PendingHtlcs) != 0 {
+				return fmt.Errorf("got %d pending htlcs, want 0",
+					len(ch.PendingHtlcs))
+			}
+		}
+
+		return nil
+	}, defaultTimeout)
+	require.NoError(ht, err, "htlc not abandoned")
+
+	// Carol cancels the invoice, so that the htlc is also removed from the
+	// channel between Bob and Carol.
+	carol.RPC.CancelInvoice(payHash[:])
+	ht.AssertNumActiveHtlcs(carol, 0)
+
+	// Mine blocks until the htlc expires on Alice's commitment.
+	forceClose := ht.AssertChannelPendingForceClose(alice, cpAB)
+	require.Len(ht, forceClose.PendingHtlcs, 1)
+	htlc := forceClose.PendingHtlcs[0]
+
+	_, height := ht.Miner.GetBestBlock()
+	if int32(htlc.MaturityHeight) > height {
+		ht.MineEmptyBlocks(int(htlc.MaturityHeight) - int(height))
+	}
+
+	// Mine a block to trigger the sweeper, after which Alice's timeout
+	// transaction must spend the htlc output right away.
+	ht.MineEmptyBlocks(1)
+
+	htlcOutpoint, err := wire.NewOutPointFromString(htlc.Outpoint)
+	require.NoError(ht, err)
+	ht.Miner.AssertOutpointInMempool(*htlcOutpoint)
+
+	// Finally, close the remaining channel.
+	ht.CloseChannel(bob, cpBC)
+}
+
+// testForwardInterceptorHoldDeadline tests that htlcs aren't held forever if
+// the interceptor doesn't resolve them. Once the hold deadline passes, they
+// are resolved with the configured action, or the one of the interceptor
+// session.
+func testForwardInterceptorHoldDeadline(ht *lntest.HarnessTest) {
+	ts := newInterceptorTestScenario(ht)
+
+	alice, bob, carol := ts.alice, ts.bob, ts.carol
+
+	// Bob requires an interceptor, so that held htlcs aren't resumed when
+	// the interceptor disconnects. Htlcs that are held for longer than the
+	// hold deadline are failed.
+	ht.RestartNodeWithExtraArgs(bob, []string{
+		"--requireinterceptor",
+		"--routerrpc.interceptorholddeadline=3s",
+		"--routerrpc.interceptordeadlineaction=fail",
+	})
+	ht.EnsureConnected(alice, bob)
+	ht.EnsureConnected(bob, carol)
+
+	// Open and wait for channels.
+	const chanAmt = btcutil.Amount(300000)
+	p := lntest.OpenChannelParams{Amt: chanAmt}
+	reqs := []*lntest.OpenChannelRequest{
+		{Local: alice, Remote: bob, Param: p},
+		{Local: bob, Remote: carol, Param: p},
+	}
+	resp := ht.OpenMultiChannelsAsync(reqs)
+	cpAB, cpBC := resp[0], resp[1]
+
+	// Make sure Alice is aware of channel Bob=>Carol.
+	ht.AssertTopologyChannelOpen(alice, cpBC)
+
+	// sendPayment sends a payment from Alice to Carol in the background
+	// and returns a channel that receives its htlc attempt.
+	sendPayment := func(tc *interceptorTestCase) chan *lnrpc.HTLCAttempt {
+		attempts := make(chan *lnrpc.HTLCAttempt, 1)
+		go func() {
+			attempts <- ts.sendPaymentAndAssertAction(tc)
+		}()
+
+		return attempts
+	}
+
+	// waitAttempt waits for the htlc attempt of a payment.
+	waitAttempt := func(
+		attempts chan *lnrpc.HTLCAttempt) *lnrpc.HTLCAttempt {
+
+		select {
+		case attempt := <-attempts:
+			return attempt
+
+		case <-time.After(defaultTimeout):
+			require.Fail(ht, "timeout waiting for payment")
+
+			return nil
+		}
+	}
+
+	// The test cases are failed and resumed htlcs. The interceptor
+	// doesn't resolve either of them.
+	tcs := ts.prepareTestCases()[:2]
+	failCase, resumeCase := tcs[0], tcs[1]
+
+	// Connect the interceptor and kill it once it received the htlc. The
+	// htlc stays held, as an interceptor is required, until it's failed
+	// after the hold deadline.
+	interceptor, cancelInterceptor := bob.RPC.HtlcInterceptor()
+
+	attempts := sendPayment(failCase)
+	packet := ht.ReceiveHtlcInterceptor(interceptor)
+	require.Equal(ht, failCase.invoice.RHash, packet.PaymentHash)
+
+	cancelInterceptor()
+
+	ts.assertAction(failCase, waitAttempt(attempts))
+
+	// Connect another interceptor, which overrides the hold policy for its
+	// session so that held htlcs are resumed after the hold deadline.
+	interceptor, cancelInterceptor = bob.RPC.HtlcInterceptor()
+	defer cancelInterceptor()
+
+	err := interceptor.Send(&routerrpc.ForwardHtlcInterceptResponse{
+		SessionHoldDeadlineMs: 2000,
+	})
+	require.NoError(ht, err, "failed to send session config")
+
+	// The interceptor hangs and doesn't resolve the htlc, which is
+	// resumed after the hold deadline and settled by Carol.
+	attempts = sendPayment(resumeCase)
+	packet = ht.ReceiveHtlcInterceptor(interceptor)
+	require.Equal(ht, resumeCase.invoice.RHash, packet.PaymentHash)
+
+	ts.assertAction(resumeCase, waitAttempt(attempts))
+
+	// Finally, close channels and restore Bob's config.
+	ht.CloseChannel(alice, cpAB)
+	ht.CloseChannel(bob, cpBC)
+
+	ht.RestartNodeWithExtraArgs(bob, nil)
+}
+
 // interceptorTestScenario is a helper struct to hold the test context and
 // provide the needed functionality.
 type interceptorTestScenario struct {
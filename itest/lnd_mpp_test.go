@@ -158,6 +158,133 @@ func testSendToRouteMultiPath(ht *lntest.HarnessTest) {
 	mts.closeChannels()
 }
 
+// testDeleteFailedHtlcsMultiPath tests that deleting the failed HTLCs of a
+// settled MPP payment reports the number of removed attempts, and that the
+// settled shards are removed along with the payment afterwards.
+func testDeleteFailedHtlcsMultiPath(ht *lntest.HarnessTest) {
+	mts := newMppTestScenario(ht)
+
+	// Each channel to Bob can only carry a single shard, so the payment is
+	// split into two shards. The failing shards are too large for the
+	// channels behind Carol and are rejected by her.
+	const (
+		paymentAmt    = btcutil.Amount(200000)
+		shardAmt      = paymentAmt / 2
+		chanAmt       = shardAmt * 3 / 2
+		numFailShards = 2
+	)
+
+	req := &mppOpenChannelRequest{
+		// The channel Alice->Carol has to carry the failing shards, so
+		// we make it large enough to forward them to Carol.
+		amtAliceCarol: chanAmt + shardAmt,
+		amtAliceDave:  chanAmt,
+		amtCarolBob:   chanAmt,
+		amtCarolEve:   chanAmt,
+		amtDaveBob:    chanAmt,
+		amtEveBob:     chanAmt,
+	}
+	mts.openChannels(req)
+
+	payReqs, rHashes, invoices := ht.CreatePayReqs(mts.bob, paymentAmt, 1)
+	rHash := rHashes[0]
+	payAddr := mts.bob.RPC.DecodePayReq(payReqs[0]).PaymentAddr
+
+	// sendShard sends a shard of the given amount along the given hops
+	// and returns the resulting attempt.
+	sendShard := func(amt btcutil.Amount,
+		hops []*node.HarnessNode) *lnrpc.HTLCAttempt {
+
+		r := mts.buildRoute(amt, mts.alice, hops)
+
+		hop := r.Hops[len(r.Hops)-1]
+		hop.TlvPayload = true
+		hop.MppRecord = &lnrpc.MPPRecord{
+			PaymentAddr:  payAddr,
+			TotalAmtMsat: int64(paymentAmt * 1000),
+		}
+
+		// Temporary failures of a shard shouldn't fail the payment,
+		// so it can still be completed by the other shards.
+		sendReq := &routerrpc.SendToRouteRequest{
+			PaymentHash: rHash,
+			Route:       r,
+			SkipTempErr: true,
+		}
+
+		return mts.alice.RPC.SendToRouteV2(sendReq)
+	}
+
+	// Send the failing shards first, one after the other.
+	for i := 0; i < numFailShards; i++ {
+		resp := sendShard(paymentAmt, []*node.HarnessNode{
+			mts.carol, mts.bob,
+		})
+		require.NotNil(ht, resp.Failure, "expected shard to fail")
+	}
+
+	// Now complete the payment with two shards sent in parallel, as Bob
+	// only settles them once the full amount has arrived.
+	sendRoutes := [][]*node.HarnessNode{
+		{mts.carol, mts.bob},
+		{mts.dave, mts.bob},
+	}
+	responses := make(chan *lnrpc.HTLCAttempt, len(sendRoutes))
+	for _, hops := range sendRoutes {
+		go func(hops []*node.HarnessNode) {
+			responses <- sendShard(shardAmt, hops)
+		}(hops)
+	}
+
+	timer := time.After(defaultTimeout)
+	for range sendRoutes {
+		select {
+		case resp := <-responses:
+			require.Nil(ht, resp.Failure, "received shard failure")
+
+		case <-timer:
+			require.Fail(ht, "response not received")
+		}
+	}
+
+	var preimage lntypes.Preimage
+	copy(preimage[:], invoices[0].RPreimage)
+	payment := ht.AssertPaymentStatus(
+		mts.alice, preimage, lnrpc.Payment_SUCCEEDED,
+	)
+	require.Len(ht, payment.Htlcs, numFailShards+len(sendRoutes))
+
+	// Delete the failed HTLCs only. The payment itself is kept, and the
+	// number of removed attempts matches the number of failed shards.
+	resp := mts.alice.RPC.DeletePayment(&lnrpc.DeletePaymentRequest{
+		PaymentHash:     rHash,
+		FailedHtlcsOnly: true,
+	})
+	require.Zero(ht, resp.NumPaymentsDeleted)
+	require.EqualValues(ht, numFailShards, resp.NumHtlcsDeleted)
+	require.Equal(ht, lnrpc.Payment_SUCCEEDED, resp.PriorStatus)
+
+	payment = ht.AssertPaymentStatus(
+		mts.alice, preimage, lnrpc.Payment_SUCCEEDED,
+	)
+	require.Len(ht, payment.Htlcs, len(sendRoutes))
+	for _, htlc := range payment.Htlcs {
+		require.Equal(ht, lnrpc.HTLCAttempt_SUCCEEDED, htlc.Status)
+	}
+
+	// Deleting the whole payment removes the settled shards as well.
+	resp = mts.alice.RPC.DeletePayment(&lnrpc.DeletePaymentRequest{
+		PaymentHash: rHash,
+	})
+	require.EqualValues(ht, 1, resp.NumPaymentsDeleted)
+	require.EqualValues(ht, len(sendRoutes), resp.NumHtlcsDeleted)
+	require.Equal(ht, lnrpc.Payment_SUCCEEDED, resp.PriorStatus)
+	ht.AssertNumPayments(mts.alice, 0)
+
+	// Finally, close all channels.
+	mts.closeChannels()
+}
+
 // mppTestScenario defines a test scenario used for testing MPP-related tests.
 // It has two standby nodes, alice and bob, and three new nodes, carol, dave,
 // and eve.
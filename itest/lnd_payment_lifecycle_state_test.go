@@ -0,0 +1,106 @@
+package itest
+
+import (
+	"fmt"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/invoicesrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
+	"github.com/lightningnetwork/lnd/lntest"
+	"github.com/lightningnetwork/lnd/lntest/wait"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// testPaymentLifecycleState checks that the internal state of the payment
+// lifecycle of an in-flight payment can be inspected, and that it reports the
+// lifecycle waiting for the result of a shard held by the receiver.
+func testPaymentLifecycleState(ht *lntest.HarnessTest) {
+	// Open a channel between alice and bob.
+	alice, bob := ht.Alice, ht.Bob
+	chanPoint := ht.OpenChannel(
+		alice, bob, lntest.OpenChannelParams{Amt: 300000},
+	)
+
+	// Create a hold invoice for bob, so the payment stays in flight until
+	// we settle it.
+	var (
+		preimage = lntypes.Preimage{4, 5, 6}
+		payHash  = preimage.Hash()
+	)
+	invoiceReq := &invoicesrpc.AddHoldInvoiceRequest{
+		Value:      30000,
+		CltvExpiry: 40,
+		Hash:       payHash[:],
+	}
+	bobInvoice := bob.RPC.AddHoldInvoice(invoiceReq)
+
+	// The payment hasn't been sent yet, so there's no lifecycle to
+	// inspect.
+	assertLifecycleNotFound(ht, alice.RPC.Router, payHash)
+
+	// Subscribe the invoice and pay it from alice.
+	stream := bob.RPC.SubscribeSingleInvoice(payHash[:])
+	req := &routerrpc.SendPaymentRequest{
+		PaymentRequest: bobInvoice.PaymentRequest,
+		TimeoutSeconds: 60,
+		FeeLimitMsat:   noFeeLimitMsat,
+	}
+	alice.RPC.SendPayment(req)
+
+	ht.AssertInvoiceState(stream, lnrpc.Invoice_ACCEPTED)
+
+	// With the htlc held by bob, alice's lifecycle should be waiting for
+	// the result of its single shard.
+	var state *routerrpc.GetPaymentLifecycleStateResponse
+	err := wait.NoError(func() error {
+		state = alice.RPC.GetPaymentLifecycleState(payHash[:])
+
+		waiting := routerrpc.
+			PaymentLifecycleStep_LIFECYCLE_STEP_WAITING_FOR_RESULTS
+		if state.Step != waiting {
+			return fmt.Errorf("expected step %v, got %v", waiting,
+				state.Step)
+		}
+
+		return nil
+	}, defaultTimeout)
+	require.NoError(ht, err, "lifecycle not waiting for results")
+
+	require.Len(ht, state.InflightAttemptIds, 1)
+	require.NotEmpty(ht, state.Transitions)
+
+	lastTransition := state.Transitions[len(state.Transitions)-1]
+	require.Equal(ht, state.Step, lastTransition.Step)
+	require.NotZero(ht, lastTransition.TimestampNs)
+
+	// Settle the invoice and wait for the payment to succeed.
+	bob.RPC.SettleInvoice(preimage[:])
+	ht.AssertPaymentStatus(alice, preimage, lnrpc.Payment_SUCCEEDED)
+
+	// Once the payment is done, its lifecycle is gone.
+	assertLifecycleNotFound(ht, alice.RPC.Router, payHash)
+
+	ht.CloseChannel(alice, chanPoint)
+}
+
+// assertLifecycleNotFound asserts that the node eventually reports that no
+// payment lifecycle is active for the given payment hash.
+func assertLifecycleNotFound(ht *lntest.HarnessTest,
+	router routerrpc.RouterClient, payHash lntypes.Hash) {
+
+	req := &routerrpc.GetPaymentLifecycleStateRequest{
+		PaymentHash: payHash[:],
+	}
+	err := wait.NoError(func() error {
+		_, err := router.GetPaymentLifecycleState(ht.Context(), req)
+		if status.Code(err) != codes.NotFound {
+			return fmt.Errorf("expected NotFound, got: %v", err)
+		}
+
+		return nil
+	}, defaultTimeout)
+	require.NoError(ht, err, "lifecycle still active")
+}
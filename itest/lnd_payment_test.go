@@ -10,6 +10,7 @@ import (
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/lightningnetwork/lnd/input"
 	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/devrpc"
 	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
 	"github.com/lightningnetwork/lnd/lntest"
 	"github.com/lightningnetwork/lnd/lntest/node"
@@ -323,6 +324,279 @@ func testListPayments(ht *lntest.HarnessTest) {
 	ht.CloseChannel(alice, chanPoint)
 }
 
+// testListPaymentsSkipHtlcs checks that payments listed without their HTLC
+// attempts still report their fee and preimage.
+func testListPaymentsSkipHtlcs(ht *lntest.HarnessTest) {
+	alice, bob := ht.Alice, ht.Bob
+
+	// Check that there are no payments before test.
+	ht.AssertNumPayments(alice, 0)
+
+	// Set up the route Alice -> Bob -> Carol, so Bob charges a fee for
+	// forwarding the payment.
+	const chanAmt = btcutil.Amount(100000)
+	params := lntest.OpenChannelParams{Amt: chanAmt}
+
+	carol := ht.NewNode("Carol", nil)
+	defer ht.Shutdown(carol)
+
+	ht.ConnectNodes(bob, carol)
+	chanPointAlice := ht.OpenChannel(alice, bob, params)
+	chanPointBob := ht.OpenChannel(bob, carol, params)
+	ht.AssertTopologyChannelOpen(alice, chanPointBob)
+
+	const paymentAmt = 1000
+	preimage := ht.Random32Bytes()
+	invoiceResp := carol.RPC.AddInvoice(&lnrpc.Invoice{
+		RPreimage: preimage,
+		Value:     paymentAmt,
+	})
+	ht.CompletePaymentRequests(alice, []string{invoiceResp.PaymentRequest})
+
+	// The full payment carries the route through Bob, whose fee is what
+	// the summary has to report.
+	p := ht.AssertNumPayments(alice, 1)[0]
+	require.Len(ht, p.Htlcs, 1)
+	require.Len(ht, p.Htlcs[0].Route.Hops, 2)
+
+	fee := p.Htlcs[0].Route.TotalFeesMsat
+	require.Positive(ht, fee, "no fee paid to Bob")
+
+	resp := alice.RPC.ListPayments(&lnrpc.ListPaymentsRequest{
+		SkipHtlcs: true,
+	})
+	require.Len(ht, resp.Payments, 1)
+
+	summary := resp.Payments[0]
+	require.Empty(ht, summary.Htlcs)
+	require.Equal(ht, lnrpc.Payment_SUCCEEDED, summary.Status)
+	require.Equal(ht, fee, summary.FeeMsat)
+	require.Equal(ht, fee/1000, summary.FeeSat)
+	require.Equal(ht, hex.EncodeToString(preimage), summary.PaymentPreimage)
+
+	// Delete all payments from Alice, so other tests start without any.
+	alice.RPC.DeleteAllPayments()
+	ht.AssertNumPayments(alice, 0)
+
+	ht.CloseChannel(alice, chanPointAlice)
+	ht.CloseChannel(bob, chanPointBob)
+}
+
+// testCountPayments checks that the payments of a node are counted per
+// status.
+func testCountPayments(ht *lntest.HarnessTest) {
+	alice, bob := ht.Alice, ht.Bob
+
+	// Check that there are no payments before test.
+	ht.AssertNumPayments(alice, 0)
+
+	chanPoint := ht.OpenChannel(
+		alice, bob, lntest.OpenChannelParams{Amt: 100000},
+	)
+
+	// Make a few payments to Bob that succeed.
+	const numSucceeded = 3
+	payReqs, _, _ := ht.CreatePayReqs(bob, 1000, numSucceeded)
+	ht.CompletePaymentRequests(alice, payReqs)
+
+	// And a few that fail, as Bob doesn't know their payment hash.
+	const numFailed = 2
+	for i := 0; i < numFailed; i++ {
+		req := &routerrpc.SendPaymentRequest{
+			Dest:           bob.PubKey[:],
+			Amt:            1000,
+			PaymentHash:    ht.Random32Bytes(),
+			FinalCltvDelta: finalCltvDelta,
+			TimeoutSeconds: 60,
+			FeeLimitMsat:   noFeeLimitMsat,
+		}
+		ht.SendPaymentAssertFail(
+			alice, req,
+			lnrpc.PaymentFailureReason_FAILURE_REASON_INCORRECT_PAYMENT_DETAILS, //nolint:lll
+		)
+	}
+
+	resp := alice.RPC.CountPayments(&lnrpc.CountPaymentsRequest{})
+	require.EqualValues(ht, numSucceeded, resp.NumSucceeded)
+	require.EqualValues(ht, numFailed, resp.NumFailed)
+	require.Zero(ht, resp.NumInFlight)
+	require.Zero(ht, resp.NumInitiated)
+	require.EqualValues(ht, numSucceeded+numFailed, resp.TotalNumPayments)
+
+	// The count matches the total of the listed payments.
+	list := alice.RPC.ListPayments(&lnrpc.ListPaymentsRequest{
+		IncludeIncomplete:  true,
+		CountTotalPayments: true,
+	})
+	require.Equal(ht, list.TotalNumPayments, resp.TotalNumPayments)
+
+	// No payments were created after the current time.
+	resp = alice.RPC.CountPayments(&lnrpc.CountPaymentsRequest{
+		CreationDateStart: uint64(time.Now().Add(time.Hour).Unix()),
+	})
+	require.Zero(ht, resp.TotalNumPayments)
+
+	// Delete all payments from Alice, so other tests start without any.
+	alice.RPC.DeleteAllPayments()
+	ht.AssertNumPayments(alice, 0)
+
+	ht.CloseChannel(alice, chanPoint)
+}
+
+// testListPaymentsFailureDetails checks that the failure of an htlc attempt is
+// listed with its failure code and the node that generated it.
+func testListPaymentsFailureDetails(ht *lntest.HarnessTest) {
+	alice, bob := ht.Alice, ht.Bob
+
+	// Check that there are no payments before test.
+	ht.AssertNumPayments(alice, 0)
+
+	// Set up the route Alice -> Bob -> Carol. The channel to Carol is
+	// funded by her, so Bob has no balance to forward the payment and
+	// fails it with a temporary channel failure.
+	const chanAmt = btcutil.Amount(100000)
+	params := lntest.OpenChannelParams{Amt: chanAmt}
+
+	carol := ht.NewNode("Carol", nil)
+	defer ht.Shutdown(carol)
+
+	ht.ConnectNodes(carol, bob)
+	chanPointAlice := ht.OpenChannel(alice, bob, params)
+	chanPointCarol := ht.OpenChannel(carol, bob, params)
+	ht.AssertTopologyChannelOpen(alice, chanPointCarol)
+
+	invoiceResp := carol.RPC.AddInvoice(&lnrpc.Invoice{Value: 1000})
+	req := &routerrpc.SendPaymentRequest{
+		PaymentRequest: invoiceResp.PaymentRequest,
+		TimeoutSeconds: 60,
+		FeeLimitMsat:   noFeeLimitMsat,
+		MaxParts:       1,
+	}
+	ht.SendPaymentAssertFail(
+		alice, req, lnrpc.PaymentFailureReason_FAILURE_REASON_NO_ROUTE,
+	)
+
+	// The failure of the attempt points to Bob, the first hop of the
+	// route.
+	p := ht.AssertNumPayments(alice, 1)[0]
+	require.Equal(ht, lnrpc.Payment_FAILED, p.Status)
+	require.NotEmpty(ht, p.Htlcs)
+
+	htlc := p.Htlcs[0]
+	require.Equal(ht, lnrpc.HTLCAttempt_FAILED, htlc.Status)
+	require.Equal(
+		ht, lnrpc.Failure_TEMPORARY_CHANNEL_FAILURE, htlc.Failure.Code,
+	)
+	require.EqualValues(ht, 1, htlc.Failure.FailureSourceIndex)
+	require.Equal(ht, bob.PubKey[:], htlc.Failure.FailureSourcePubKey)
+
+	// Without the intermediate hops, the failure still carries its code
+	// and index, but the node can't be resolved.
+	resp := alice.RPC.ListPayments(&lnrpc.ListPaymentsRequest{
+		IncludeIncomplete: true,
+		SkipHops:          true,
+	})
+	require.Len(ht, resp.Payments, 1)
+	require.NotEmpty(ht, resp.Payments[0].Htlcs)
+
+	failure := resp.Payments[0].Htlcs[0].Failure
+	require.Equal(
+		ht, lnrpc.Failure_TEMPORARY_CHANNEL_FAILURE, failure.Code,
+	)
+	require.EqualValues(ht, 1, failure.FailureSourceIndex)
+	require.Empty(ht, failure.FailureSourcePubKey)
+
+	// Delete all payments from Alice, so other tests start without any.
+	alice.RPC.DeleteAllPayments()
+	ht.AssertNumPayments(alice, 0)
+
+	ht.CloseChannel(alice, chanPointAlice)
+	ht.CloseChannel(carol, chanPointCarol)
+}
+
+// testListPaymentsPagination checks that paging through a large payment
+// history, both forwards and backwards, returns every payment exactly once.
+func testListPaymentsPagination(ht *lntest.HarnessTest) {
+	const (
+		numPayments = 10000
+		pageSize    = 1000
+	)
+
+	// Use a fresh node, so its history only holds the synthetic payments.
+	carol := ht.NewNode("Carol", nil)
+	defer ht.Shutdown(carol)
+
+	resp := carol.RPC.PopulatePayments(&devrpc.PopulatePaymentsRequest{
+		NumPayments:        numPayments,
+		AttemptsPerPayment: 2,
+		SucceededPercent:   60,
+	})
+	require.EqualValues(ht, numPayments, resp.NumSucceeded+resp.NumFailed)
+
+	// fetchAll pages through all payments in the given direction and
+	// returns them in the order they were returned.
+	fetchAll := func(reversed bool) []*lnrpc.Payment {
+		var (
+			payments []*lnrpc.Payment
+			offset   uint64
+		)
+		for {
+			req := &lnrpc.ListPaymentsRequest{
+				IncludeIncomplete: true,
+				IndexOffset:       offset,
+				MaxPayments:       pageSize,
+				Reversed:          reversed,
+				SkipHops:          true,
+			}
+			page := carol.RPC.ListPayments(req)
+			if len(page.Payments) == 0 {
+				return payments
+			}
+
+			payments = append(payments, page.Payments...)
+
+			if reversed {
+				offset = page.FirstIndexOffset
+			} else {
+				offset = page.LastIndexOffset
+			}
+		}
+	}
+
+	// Paging forwards returns every payment once in ascending order, with
+	// the status mix that was requested.
+	forward := fetchAll(false)
+	require.Len(ht, forward, numPayments)
+
+	var numSucceeded uint32
+	for i, p := range forward {
+		if i > 0 {
+			require.Less(ht, forward[i-1].PaymentIndex,
+				p.PaymentIndex)
+		}
+
+		if p.Status == lnrpc.Payment_SUCCEEDED {
+			numSucceeded++
+		}
+	}
+	require.Equal(ht, resp.NumSucceeded, numSucceeded)
+
+	// Paging backwards returns the same payments in reverse.
+	backward := fetchAll(true)
+	require.Len(ht, backward, numPayments)
+	for i, p := range backward {
+		require.Equal(ht, forward[len(forward)-1-i].PaymentIndex,
+			p.PaymentIndex)
+	}
+
+	// The payment count covers the whole history.
+	count := carol.RPC.ListPayments(&lnrpc.ListPaymentsRequest{
+		MaxPayments:        1,
+		CountTotalPayments: true,
+	})
+	require.EqualValues(ht, numPayments, count.TotalNumPayments)
+}
+
 // testPaymentFollowingChannelOpen tests that the channel transition from
 // 'pending' to 'open' state does not cause any inconsistencies within other
 // subsystems trying to update the channel state in the db. We follow this
@@ -2,13 +2,19 @@ package itest
 
 import (
 	"encoding/hex"
+	"errors"
+	"io"
 	"time"
 
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/invoicesrpc"
 	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
 	"github.com/lightningnetwork/lnd/lntest"
+	"github.com/lightningnetwork/lnd/lntypes"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // testTrackPayments tests whether a client that calls the TrackPayments api
@@ -174,3 +180,263 @@ func testTrackPaymentsCompatible(ht *lntest.HarnessTest) {
 
 	ht.CloseChannel(alice, channel)
 }
+
+// testTrackPaymentsDeleted checks that a client tracking payments is notified
+// when a payment is deleted, and that the deleted payment can't be tracked
+// anymore.
+func testTrackPaymentsDeleted(ht *lntest.HarnessTest) {
+	// Open a channel between alice and bob.
+	alice, bob := ht.Alice, ht.Bob
+	channel := ht.OpenChannel(
+		alice, bob, lntest.OpenChannelParams{
+			Amt: btcutil.Amount(300000),
+		},
+	)
+
+	// Call the TrackPayments api to listen for final payment updates.
+	req := &routerrpc.TrackPaymentsRequest{
+		NoInflightUpdates: true,
+	}
+	tracker := alice.RPC.TrackPayments(req)
+
+	// Send a payment to bob for a payment hash he doesn't know about, so
+	// it fails.
+	payHash := ht.Random32Bytes()
+	sendReq := &routerrpc.SendPaymentRequest{
+		Dest:           bob.PubKey[:],
+		Amt:            1000,
+		PaymentHash:    payHash,
+		FinalCltvDelta: finalCltvDelta,
+		TimeoutSeconds: 60,
+		FeeLimitMsat:   noFeeLimitMsat,
+	}
+	ht.SendPaymentAssertFail(
+		alice, sendReq,
+		lnrpc.PaymentFailureReason_FAILURE_REASON_INCORRECT_PAYMENT_DETAILS, //nolint:lll
+	)
+
+	// The tracker should report the failed payment.
+	update, err := tracker.Recv()
+	require.NoError(ht, err, "unable to receive failed update")
+	require.Equal(ht, lnrpc.Payment_FAILED, update.Status)
+	require.Equal(ht, hex.EncodeToString(payHash), update.PaymentHash)
+
+	// Delete the payment using a separate call.
+	alice.RPC.DeletePayment(&lnrpc.DeletePaymentRequest{
+		PaymentHash: payHash,
+	})
+
+	// The tracker should now receive the deletion signal.
+	update, err = tracker.Recv()
+	require.NoError(ht, err, "unable to receive deleted update")
+	require.Equal(ht, lnrpc.Payment_DELETED, update.Status)
+	require.Equal(ht, hex.EncodeToString(payHash), update.PaymentHash)
+
+	// Tracking the deleted payment should fail with NotFound.
+	stream := alice.RPC.TrackPaymentV2(payHash)
+	_, err = stream.Recv()
+	require.Equal(ht, codes.NotFound, status.Code(err))
+
+	ht.CloseChannel(alice, channel)
+}
+
+// testTrackPaymentAttemptEvents checks that a client tracking a multi-shard
+// payment with attempt events receives one event per transition of each of
+// its htlc attempts.
+func testTrackPaymentAttemptEvents(ht *lntest.HarnessTest) {
+	mts := newMppTestScenario(ht)
+
+	const paymentAmt = btcutil.Amount(300000)
+
+	// Set up a network with three different paths Alice <-> Bob, so the
+	// payment can only succeed if it's split into multiple shards.
+	req := &mppOpenChannelRequest{
+		amtAliceCarol: 285000,
+		amtAliceDave:  155000,
+		amtCarolBob:   200000,
+		amtCarolEve:   155000,
+		amtDaveBob:    155000,
+		amtEveBob:     155000,
+	}
+	mts.openChannels(req)
+
+	payReqs, rHashes, _ := ht.CreatePayReqs(mts.bob, paymentAmt, 1)
+
+	paymentClient := mts.alice.RPC.SendPayment(
+		&routerrpc.SendPaymentRequest{
+			PaymentRequest: payReqs[0],
+			MaxParts:       10,
+			TimeoutSeconds: 60,
+			FeeLimitMsat:   noFeeLimitMsat,
+		},
+	)
+
+	// Wait for the payment to be initiated before tracking it.
+	_, err := paymentClient.Recv()
+	require.NoError(ht, err, "unable to get payment update")
+
+	stream := mts.alice.RPC.TrackPaymentV2WithRequest(
+		&routerrpc.TrackPaymentRequest{
+			PaymentHash:          rHashes[0],
+			IncludeAttemptEvents: true,
+		},
+	)
+
+	// The first update is the state of the payment when subscribing, so it
+	// isn't caused by an attempt.
+	first, err := stream.Recv()
+	require.NoError(ht, err, "unable to receive payment update")
+	require.Nil(ht, first.AttemptEvent)
+
+	// Collect the attempt events until the payment is terminated.
+	type attemptEvent struct {
+		id         uint64
+		transition lnrpc.PaymentAttemptEvent_Transition
+	}
+
+	events := make(map[attemptEvent]int)
+	final := first
+	for {
+		update, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(ht, err, "unable to receive payment update")
+		require.NotNil(ht, update.AttemptEvent)
+
+		events[attemptEvent{
+			id:         update.AttemptEvent.AttemptId,
+			transition: update.AttemptEvent.Transition,
+		}]++
+		final = update
+	}
+	require.Equal(ht, lnrpc.Payment_SUCCEEDED, final.Status)
+	require.Greater(ht, len(final.Htlcs), 1, "payment wasn't split")
+
+	// Every transition that happened after subscribing is streamed exactly
+	// once, and no other.
+	known := make(map[uint64]*lnrpc.HTLCAttempt)
+	for _, htlc := range first.Htlcs {
+		known[htlc.AttemptId] = htlc
+	}
+
+	const registered = lnrpc.PaymentAttemptEvent_REGISTERED
+
+	expected := make(map[attemptEvent]int)
+	for _, htlc := range final.Htlcs {
+		before, ok := known[htlc.AttemptId]
+		if !ok {
+			expected[attemptEvent{
+				id:         htlc.AttemptId,
+				transition: registered,
+			}] = 1
+		}
+
+		if ok && before.Status != lnrpc.HTLCAttempt_IN_FLIGHT {
+			continue
+		}
+
+		transition := lnrpc.PaymentAttemptEvent_FAILED
+		if htlc.Status == lnrpc.HTLCAttempt_SUCCEEDED {
+			transition = lnrpc.PaymentAttemptEvent_SETTLED
+		}
+		expected[attemptEvent{
+			id:         htlc.AttemptId,
+			transition: transition,
+		}] = 1
+	}
+	require.Equal(ht, expected, events)
+
+	// Finally, close all channels.
+	mts.closeChannels()
+}
+
+// testTrackPaymentBySeqNum checks that tracking a payment by its sequence
+// number only streams updates of that payment, even while another payment
+// settles.
+func testTrackPaymentBySeqNum(ht *lntest.HarnessTest) {
+	// Open a channel between alice and bob.
+	alice, bob := ht.Alice, ht.Bob
+	chanPoint := ht.OpenChannel(
+		alice, bob, lntest.OpenChannelParams{Amt: 300000},
+	)
+
+	// Create two hold invoices for bob, so both payments stay in flight
+	// until we settle them.
+	preimages := []lntypes.Preimage{{1, 2, 3}, {4, 5, 6}}
+	for _, preimage := range preimages {
+		payHash := preimage.Hash()
+		invoice := bob.RPC.AddHoldInvoice(
+			&invoicesrpc.AddHoldInvoiceRequest{
+				Value:      30000,
+				CltvExpiry: 40,
+				Hash:       payHash[:],
+			},
+		)
+
+		stream := bob.RPC.SubscribeSingleInvoice(payHash[:])
+		alice.RPC.SendPayment(&routerrpc.SendPaymentRequest{
+			PaymentRequest: invoice.PaymentRequest,
+			TimeoutSeconds: 60,
+			FeeLimitMsat:   noFeeLimitMsat,
+		})
+		ht.AssertInvoiceState(stream, lnrpc.Invoice_ACCEPTED)
+	}
+
+	// Look up the sequence number of the first payment and start tracking
+	// it.
+	first := ht.AssertPaymentStatus(
+		alice, preimages[0], lnrpc.Payment_IN_FLIGHT,
+	)
+	seqNum := first.PaymentIndex
+
+	tracker := alice.RPC.TrackPaymentBySeqNum(
+		&routerrpc.TrackPaymentBySeqNumRequest{
+			SequenceNumber: seqNum,
+		},
+	)
+
+	// The first update is the current state of the first payment.
+	update := ht.ReceiveTrackPayment(tracker)
+	require.Equal(ht, first.PaymentHash, update.PaymentHash)
+	require.Equal(ht, seqNum, update.PaymentIndex)
+	require.Equal(ht, lnrpc.Payment_IN_FLIGHT, update.Status)
+
+	// Settle the second payment first, and then the first one.
+	bob.RPC.SettleInvoice(preimages[1][:])
+	ht.AssertPaymentStatus(alice, preimages[1], lnrpc.Payment_SUCCEEDED)
+
+	bob.RPC.SettleInvoice(preimages[0][:])
+	ht.AssertPaymentStatus(alice, preimages[0], lnrpc.Payment_SUCCEEDED)
+
+	// The stream should only have carried updates of the first payment,
+	// ending with its success.
+	for {
+		next, err := tracker.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(ht, err, "unable to receive payment update")
+		require.Equal(ht, first.PaymentHash, next.PaymentHash)
+		require.Equal(ht, seqNum, next.PaymentIndex)
+
+		update = next
+	}
+	require.Equal(ht, lnrpc.Payment_SUCCEEDED, update.Status)
+
+	// Once deleted, the payment can't be tracked by its sequence number
+	// anymore.
+	payHash := preimages[0].Hash()
+	alice.RPC.DeletePayment(&lnrpc.DeletePaymentRequest{
+		PaymentHash: payHash[:],
+	})
+
+	req := &routerrpc.TrackPaymentBySeqNumRequest{SequenceNumber: seqNum}
+	stream, err := alice.RPC.Router.TrackPaymentBySeqNum(ht.Context(), req)
+	require.NoError(ht, err)
+
+	_, err = stream.Recv()
+	require.Equal(ht, codes.NotFound, status.Code(err))
+
+	ht.CloseChannel(alice, chanPoint)
+}
@@ -37,6 +37,11 @@ type Caches struct {
 	// RPCGraphCacheDuration is used to control the flush interval of the
 	// channel graph cache.
 	RPCGraphCacheDuration time.Duration `long:"rpc-graph-cache-duration" description:"The period of time expressed as a duration (1s, 1m, 1h, etc) that the RPC response to DescribeGraph should be cached for."`
+
+	// PaymentCacheSize is the maximum number of in-flight payments kept
+	// in memory, so the payment lifecycle doesn't need to read them from
+	// the database after every update. Zero disables the cache.
+	PaymentCacheSize int `long:"payment-cache-size" description:"Maximum number of in-flight payments kept in the payment cache, which is used to avoid reading a payment from the database after every update while it is being sent. Setting the value to zero disables the cache."`
 }
 
 // Validate checks the Caches configuration for values that are too small to be
@@ -50,6 +55,10 @@ func (c *Caches) Validate() error {
 		return fmt.Errorf("channel cache size %d is less than min: %d",
 			c.ChannelCacheSize, MinChannelCacheSize)
 	}
+	if c.PaymentCacheSize < 0 {
+		return fmt.Errorf("payment cache size %d must not be negative",
+			c.PaymentCacheSize)
+	}
 
 	return nil
 }
@@ -19,12 +19,13 @@ import (
 )
 
 const (
-	ChannelDBName     = "channel.db"
-	MacaroonDBName    = "macaroons.db"
-	DecayedLogDbName  = "sphinxreplay.db"
-	TowerClientDBName = "wtclient.db"
-	TowerServerDBName = "watchtower.db"
-	WalletDBName      = "wallet.db"
+	ChannelDBName        = "channel.db"
+	MacaroonDBName       = "macaroons.db"
+	DecayedLogDbName     = "sphinxreplay.db"
+	TowerClientDBName    = "wtclient.db"
+	TowerServerDBName    = "watchtower.db"
+	WalletDBName         = "wallet.db"
+	ShadowPaymentsDBName = "shadow-payments.db"
 
 	SqliteChannelDBName  = "channel.sqlite"
 	SqliteChainDBName    = "chain.sqlite"
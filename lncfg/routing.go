@@ -7,4 +7,6 @@ type Routing struct {
 	AssumeChannelValid bool `long:"assumechanvalid" description:"DEPRECATED: Skip checking channel spentness during graph validation. This speedup comes at the risk of using an unvalidated view of the network for routing. (default: false)" hidden:"true"`
 
 	StrictZombiePruning bool `long:"strictgraphpruning" description:"If true, then the graph will be pruned more aggressively for zombies. In practice this means that edges with a single stale edge will be considered a zombie."`
+
+	CheckStaleAttempts bool `long:"checkstaleattempts" description:"If true, then in-flight HTLC attempts of outgoing payments that have neither a circuit nor a result in the switch are reported on startup."`
 }
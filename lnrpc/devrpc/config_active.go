@@ -16,4 +16,5 @@ import (
 type Config struct {
 	ActiveNetParams *chaincfg.Params
 	GraphDB         *channeldb.ChannelGraph
+	PaymentsDB      *channeldb.DB
 }
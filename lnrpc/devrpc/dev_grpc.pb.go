@@ -23,6 +23,11 @@ type DevClient interface {
 	// ImportGraph imports a ChannelGraph into the graph database. Should only be
 	// used for development.
 	ImportGraph(ctx context.Context, in *lnrpc.ChannelGraph, opts ...grpc.CallOption) (*ImportGraphResponse, error)
+	// PopulatePayments writes synthetic payments that already reached a terminal
+	// state directly to the payments database. It is used to quickly build up a
+	// large payment history for testing and refuses to run on mainnet. Should
+	// only be used for development.
+	PopulatePayments(ctx context.Context, in *PopulatePaymentsRequest, opts ...grpc.CallOption) (*PopulatePaymentsResponse, error)
 }
 
 type devClient struct {
@@ -42,6 +47,15 @@ func (c *devClient) ImportGraph(ctx context.Context, in *lnrpc.ChannelGraph, opt
 	return out, nil
 }
 
+func (c *devClient) PopulatePayments(ctx context.Context, in *PopulatePaymentsRequest, opts ...grpc.CallOption) (*PopulatePaymentsResponse, error) {
+	out := new(PopulatePaymentsResponse)
+	err := c.cc.Invoke(ctx, "/devrpc.Dev/PopulatePayments", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // DevServer is the server API for Dev service.
 // All implementations must embed UnimplementedDevServer
 // for forward compatibility
@@ -50,6 +64,11 @@ type DevServer interface {
 	// ImportGraph imports a ChannelGraph into the graph database. Should only be
 	// used for development.
 	ImportGraph(context.Context, *lnrpc.ChannelGraph) (*ImportGraphResponse, error)
+	// PopulatePayments writes synthetic payments that already reached a terminal
+	// state directly to the payments database. It is used to quickly build up a
+	// large payment history for testing and refuses to run on mainnet. Should
+	// only be used for development.
+	PopulatePayments(context.Context, *PopulatePaymentsRequest) (*PopulatePaymentsResponse, error)
 	mustEmbedUnimplementedDevServer()
 }
 
@@ -60,6 +79,9 @@ type UnimplementedDevServer struct {
 func (UnimplementedDevServer) ImportGraph(context.Context, *lnrpc.ChannelGraph) (*ImportGraphResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ImportGraph not implemented")
 }
+func (UnimplementedDevServer) PopulatePayments(context.Context, *PopulatePaymentsRequest) (*PopulatePaymentsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PopulatePayments not implemented")
+}
 func (UnimplementedDevServer) mustEmbedUnimplementedDevServer() {}
 
 // UnsafeDevServer may be embedded to opt out of forward compatibility for this service.
@@ -91,6 +113,24 @@ func _Dev_ImportGraph_Handler(srv interface{}, ctx context.Context, dec func(int
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Dev_PopulatePayments_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PopulatePaymentsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DevServer).PopulatePayments(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/devrpc.Dev/PopulatePayments",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DevServer).PopulatePayments(ctx, req.(*PopulatePaymentsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Dev_ServiceDesc is the grpc.ServiceDesc for Dev service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -102,6 +142,10 @@ var Dev_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "ImportGraph",
 			Handler:    _Dev_ImportGraph_Handler,
 		},
+		{
+			MethodName: "PopulatePayments",
+			Handler:    _Dev_PopulatePayments_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "devrpc/dev.proto",
@@ -5,13 +5,16 @@ package devrpc
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
 
+	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/wire"
@@ -20,12 +23,26 @@ import (
 	"github.com/lightningnetwork/lnd/channeldb/models"
 	"github.com/lightningnetwork/lnd/lncfg"
 	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lntypes"
 	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/routing/route"
+	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
 	"gopkg.in/macaroon-bakery.v2/bakery"
 )
 
 const (
+	// populateWorkers is the number of payments PopulatePayments writes
+	// concurrently, allowing their database transactions to be batched.
+	populateWorkers = 256
+
+	// maxAttemptsPerPayment is the maximum number of HTLC attempts of a
+	// synthetic payment.
+	maxAttemptsPerPayment = 100
+
+	// syntheticPaymentAmt is the amount of each synthetic payment.
+	syntheticPaymentAmt = lnwire.MilliSatoshi(1000)
+
 	// subServerName is the name of the sub rpc server. We'll use this name
 	// to register ourselves, and we also require that the main
 	// SubServerConfigDispatcher instance recognize tt as the name of our
@@ -40,6 +57,10 @@ var (
 			Entity: "offchain",
 			Action: "write",
 		}},
+		"/devrpc.Dev/PopulatePayments": {{
+			Entity: "offchain",
+			Action: "write",
+		}},
 	}
 )
 
@@ -342,3 +363,161 @@ func (s *Server) ImportGraph(ctx context.Context,
 
 	return &ImportGraphResponse{}, nil
 }
+
+// PopulatePayments writes synthetic payments that already reached a terminal
+// state directly to the payments database.
+//
+// NOTE: Part of the DevServer interface.
+func (s *Server) PopulatePayments(ctx context.Context,
+	req *PopulatePaymentsRequest) (*PopulatePaymentsResponse, error) {
+
+	if s.cfg.ActiveNetParams.Net == wire.MainNet {
+		return nil, errors.New("refusing to populate payments on " +
+			"mainnet")
+	}
+
+	if req.NumPayments == 0 {
+		return nil, errors.New("num_payments must be set")
+	}
+
+	if req.SucceededPercent > 100 {
+		return nil, fmt.Errorf("invalid succeeded_percent: %v",
+			req.SucceededPercent)
+	}
+
+	numAttempts := req.AttemptsPerPayment
+	if numAttempts == 0 {
+		numAttempts = 1
+	}
+	if numAttempts > maxAttemptsPerPayment {
+		return nil, fmt.Errorf("at most %v attempts per payment are "+
+			"allowed", maxAttemptsPerPayment)
+	}
+
+	// All attempts share the same session key and a single hop route to
+	// a made up destination.
+	sessionKey, err := btcec.NewPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	rt := route.Route{
+		TotalTimeLock: 100,
+		TotalAmount:   syntheticPaymentAmt,
+		SourcePubKey:  route.Vertex{1},
+		Hops: []*route.Hop{{
+			PubKeyBytes:      route.Vertex{2},
+			ChannelID:        1,
+			OutgoingTimeLock: 100,
+			AmtToForward:     syntheticPaymentAmt,
+		}},
+	}
+
+	// The payments are written through a payment control of their own,
+	// so they don't show up in payment subscriptions. Its attempt IDs are
+	// reserved from the same persistent sequence as the attempts of real
+	// payments, so they can't collide.
+	pControl := channeldb.NewPaymentControl(s.cfg.PaymentsDB)
+
+	// Spread the succeeded payments evenly over all payments.
+	var numSucceeded uint32
+	for i := uint32(0); i < req.NumPayments; i++ {
+		if i%100 < req.SucceededPercent {
+			numSucceeded++
+		}
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(populateWorkers)
+	for i := uint32(0); i < req.NumPayments; i++ {
+		succeeded := i%100 < req.SucceededPercent
+
+		g.Go(func() error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			return s.writeSyntheticPayment(
+				ctx, pControl, sessionKey, rt, numAttempts,
+				succeeded,
+			)
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, fmt.Errorf("unable to populate payments: %w", err)
+	}
+
+	log.Infof("Populated %v synthetic payments", req.NumPayments)
+
+	return &PopulatePaymentsResponse{
+		NumSucceeded: numSucceeded,
+		NumFailed:    req.NumPayments - numSucceeded,
+	}, nil
+}
+
+// writeSyntheticPayment writes a single synthetic payment with the given
+// number of attempts. All attempts but the last one of a succeeded payment are
+// failed, and a payment that doesn't succeed is failed as a whole.
+func (s *Server) writeSyntheticPayment(ctx context.Context,
+	pControl *channeldb.PaymentControl, sessionKey *btcec.PrivateKey,
+	rt route.Route, numAttempts uint32, succeeded bool) error {
+
+	var preimage lntypes.Preimage
+	if _, err := rand.Read(preimage[:]); err != nil {
+		return err
+	}
+	hash := preimage.Hash()
+
+	now := time.Now()
+	info := &channeldb.PaymentCreationInfo{
+		PaymentIdentifier: hash,
+		Value:             syntheticPaymentAmt,
+		CreationTime:      now,
+		FeeLimit:          channeldb.NoFeeLimit,
+	}
+	if err := pControl.InitPayment(hash, info); err != nil {
+		return err
+	}
+
+	for i := uint32(0); i < numAttempts; i++ {
+		attemptID, err := pControl.NextAttemptID(ctx)
+		if err != nil {
+			return err
+		}
+
+		attempt := channeldb.NewHtlcAttempt(
+			attemptID, sessionKey, rt, now, &hash,
+		)
+		_, err = pControl.RegisterAttempt(
+			hash, &attempt.HTLCAttemptInfo,
+		)
+		if err != nil {
+			return err
+		}
+
+		if succeeded && i == numAttempts-1 {
+			_, _, err = pControl.SettleAttempt(
+				hash, attemptID, &channeldb.HTLCSettleInfo{
+					Preimage:   preimage,
+					SettleTime: now,
+				},
+			)
+
+			return err
+		}
+
+		_, _, err = pControl.FailAttempt(
+			hash, attemptID, &channeldb.HTLCFailInfo{
+				FailTime:           now,
+				Reason:             channeldb.HTLCFailUnknown,
+				FailureSourceIndex: 1,
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := pControl.Fail(hash, channeldb.FailureReasonNoRoute)
+
+	return err
+}
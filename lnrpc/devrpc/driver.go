@@ -47,6 +47,11 @@ func createNewSubServer(configRegistry lnrpc.SubServerConfigDispatcher) (
 			"DevRPC")
 	}
 
+	if config.PaymentsDB == nil {
+		return nil, nil, fmt.Errorf("PaymentsDB must be set to " +
+			"create DevRPC")
+	}
+
 	return New(config)
 }
 
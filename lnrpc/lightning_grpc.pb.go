@@ -246,9 +246,15 @@ type LightningClient interface {
 	// lncli: `listpayments`
 	// ListPayments returns a list of all outgoing payments.
 	ListPayments(ctx context.Context, in *ListPaymentsRequest, opts ...grpc.CallOption) (*ListPaymentsResponse, error)
+	// lncli: `countpayments`
+	// CountPayments returns the number of outgoing payments per status, without
+	// listing them.
+	CountPayments(ctx context.Context, in *CountPaymentsRequest, opts ...grpc.CallOption) (*CountPaymentsResponse, error)
 	// lncli: `deletepayments`
 	// DeletePayment deletes an outgoing payment from DB. Note that it will not
-	// attempt to delete an In-Flight payment, since that would be unsafe.
+	// attempt to delete an In-Flight payment, since that would be unsafe. Streams
+	// tracking the deleted payment receive a final update with the DELETED
+	// status.
 	DeletePayment(ctx context.Context, in *DeletePaymentRequest, opts ...grpc.CallOption) (*DeletePaymentResponse, error)
 	// lncli: `deletepayments --all`
 	// DeleteAllPayments deletes all outgoing payments from DB. Note that it will
@@ -983,6 +989,15 @@ func (c *lightningClient) ListPayments(ctx context.Context, in *ListPaymentsRequ
 	return out, nil
 }
 
+func (c *lightningClient) CountPayments(ctx context.Context, in *CountPaymentsRequest, opts ...grpc.CallOption) (*CountPaymentsResponse, error) {
+	out := new(CountPaymentsResponse)
+	err := c.cc.Invoke(ctx, "/lnrpc.Lightning/CountPayments", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *lightningClient) DeletePayment(ctx context.Context, in *DeletePaymentRequest, opts ...grpc.CallOption) (*DeletePaymentResponse, error) {
 	out := new(DeletePaymentResponse)
 	err := c.cc.Invoke(ctx, "/lnrpc.Lightning/DeletePayment", in, out, opts...)
@@ -1567,9 +1582,15 @@ type LightningServer interface {
 	// lncli: `listpayments`
 	// ListPayments returns a list of all outgoing payments.
 	ListPayments(context.Context, *ListPaymentsRequest) (*ListPaymentsResponse, error)
+	// lncli: `countpayments`
+	// CountPayments returns the number of outgoing payments per status, without
+	// listing them.
+	CountPayments(context.Context, *CountPaymentsRequest) (*CountPaymentsResponse, error)
 	// lncli: `deletepayments`
 	// DeletePayment deletes an outgoing payment from DB. Note that it will not
-	// attempt to delete an In-Flight payment, since that would be unsafe.
+	// attempt to delete an In-Flight payment, since that would be unsafe. Streams
+	// tracking the deleted payment receive a final update with the DELETED
+	// status.
 	DeletePayment(context.Context, *DeletePaymentRequest) (*DeletePaymentResponse, error)
 	// lncli: `deletepayments --all`
 	// DeleteAllPayments deletes all outgoing payments from DB. Note that it will
@@ -1861,6 +1882,9 @@ func (UnimplementedLightningServer) DecodePayReq(context.Context, *PayReqString)
 func (UnimplementedLightningServer) ListPayments(context.Context, *ListPaymentsRequest) (*ListPaymentsResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ListPayments not implemented")
 }
+func (UnimplementedLightningServer) CountPayments(context.Context, *CountPaymentsRequest) (*CountPaymentsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CountPayments not implemented")
+}
 func (UnimplementedLightningServer) DeletePayment(context.Context, *DeletePaymentRequest) (*DeletePaymentResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DeletePayment not implemented")
 }
@@ -2705,6 +2729,24 @@ func _Lightning_ListPayments_Handler(srv interface{}, ctx context.Context, dec f
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Lightning_CountPayments_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CountPaymentsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LightningServer).CountPayments(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/lnrpc.Lightning/CountPayments",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LightningServer).CountPayments(ctx, req.(*CountPaymentsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Lightning_DeletePayment_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(DeletePaymentRequest)
 	if err := dec(in); err != nil {
@@ -3371,6 +3413,10 @@ var Lightning_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "ListPayments",
 			Handler:    _Lightning_ListPayments_Handler,
 		},
+		{
+			MethodName: "CountPayments",
+			Handler:    _Lightning_CountPayments_Handler,
+		},
 		{
 			MethodName: "DeletePayment",
 			Handler:    _Lightning_DeletePayment_Handler,
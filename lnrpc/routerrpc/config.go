@@ -1,10 +1,24 @@
 package routerrpc
 
 import (
+	"fmt"
+	"time"
+
+	"github.com/lightningnetwork/lnd/htlcswitch"
 	"github.com/lightningnetwork/lnd/macaroons"
 	"github.com/lightningnetwork/lnd/routing"
 )
 
+const (
+	// deadlineActionResume resumes intercepted htlcs whose hold deadline
+	// passed.
+	deadlineActionResume = "resume"
+
+	// deadlineActionFail fails intercepted htlcs whose hold deadline
+	// passed.
+	deadlineActionFail = "fail"
+)
+
 // Config is the main configuration file for the router RPC server. It contains
 // all the items required for the router RPC server to carry out its duties.
 // The fields with struct tags are meant to be parsed as normal configuration
@@ -27,6 +41,15 @@ type Config struct {
 	// directory, named DefaultRouterMacFilename.
 	RouterMacPath string `long:"routermacaroonpath" description:"Path to the router macaroon"`
 
+	// InterceptorHoldDeadline is the maximum time an htlc is held by the
+	// htlc interceptor before it is resolved automatically. Zero disables
+	// the deadline.
+	InterceptorHoldDeadline time.Duration `long:"interceptorholddeadline" description:"The maximum time an htlc is held by the htlc interceptor. Htlcs that the interceptor doesn't resolve in time are resolved with the interceptordeadlineaction. Interceptor sessions can override it. Set to 0 to hold htlcs until the interceptor resolves them or they are about to expire."`
+
+	// InterceptorDeadlineAction is the action that is applied to held
+	// htlcs once their hold deadline passes.
+	InterceptorDeadlineAction string `long:"interceptordeadlineaction" description:"The action applied to htlcs that are still held by the htlc interceptor when their hold deadline passes. They are either resumed or failed with a temporary channel failure." choice:"resume" choice:"fail"`
+
 	// NetworkDir is the main network directory wherein the router rpc
 	// server will find the macaroon named DefaultRouterMacFilename.
 	NetworkDir string
@@ -73,10 +96,39 @@ func DefaultConfig() *Config {
 	}
 
 	return &Config{
-		RoutingConfig: defaultRoutingConfig,
+		RoutingConfig:             defaultRoutingConfig,
+		InterceptorDeadlineAction: deadlineActionResume,
 	}
 }
 
+// GetInterceptorHoldPolicy returns the hold policy of intercepted htlcs based
+// on this sub server config.
+func GetInterceptorHoldPolicy(cfg *Config) (htlcswitch.InterceptorHoldPolicy,
+	error) {
+
+	policy := htlcswitch.InterceptorHoldPolicy{
+		Deadline: cfg.InterceptorHoldDeadline,
+	}
+
+	switch cfg.InterceptorDeadlineAction {
+	case "", deadlineActionResume:
+		policy.Action = htlcswitch.FwdActionResume
+
+	case deadlineActionFail:
+		policy.Action = htlcswitch.FwdActionFail
+
+	default:
+		return policy, fmt.Errorf("unknown interceptor deadline "+
+			"action: %v", cfg.InterceptorDeadlineAction)
+	}
+
+	if err := policy.Validate(); err != nil {
+		return policy, err
+	}
+
+	return policy, nil
+}
+
 // GetRoutingConfig returns the routing config based on this sub server config.
 func GetRoutingConfig(cfg *Config) *RoutingConfig {
 	return &RoutingConfig{
@@ -2,6 +2,8 @@ package routerrpc
 
 import (
 	"errors"
+	"math"
+	"time"
 
 	"github.com/lightningnetwork/lnd/channeldb/models"
 	"github.com/lightningnetwork/lnd/htlcswitch"
@@ -53,18 +55,72 @@ func (r *forwardInterceptor) run() error {
 	r.htlcSwitch.SetInterceptor(r.onIntercept)
 	defer r.htlcSwitch.SetInterceptor(nil)
 
-	for {
+	for first := true; ; first = false {
 		resp, err := r.stream.Recv()
 		if err != nil {
 			return err
 		}
 
+		// The first message of the stream may configure the session
+		// instead of resolving an htlc.
+		if resp.SessionHoldDeadlineMs != 0 || resp.SessionDeadlineFail {
+			if !first {
+				return status.Errorf(codes.InvalidArgument,
+					"session hold deadline can only be "+
+						"set in the first message")
+			}
+
+			if err := r.initSession(resp); err != nil {
+				return err
+			}
+
+			continue
+		}
+
 		if err := r.resolveFromClient(resp); err != nil {
 			return err
 		}
 	}
 }
 
+// initSession applies the session config that arrived from the client in the
+// first message of the stream.
+func (r *forwardInterceptor) initSession(
+	in *ForwardHtlcInterceptResponse) error {
+
+	if in.IncomingCircuitKey != nil {
+		return status.Errorf(codes.InvalidArgument,
+			"session config must not resolve an htlc")
+	}
+
+	deadlineMs := in.SessionHoldDeadlineMs
+	if deadlineMs == 0 {
+		return status.Errorf(codes.InvalidArgument,
+			"session deadline action requires a session hold "+
+				"deadline")
+	}
+
+	if deadlineMs > uint64(math.MaxInt64/int64(time.Millisecond)) {
+		return status.Errorf(codes.InvalidArgument,
+			"session hold deadline of %v ms too large", deadlineMs)
+	}
+
+	policy := htlcswitch.InterceptorHoldPolicy{
+		Deadline: time.Duration(deadlineMs) * time.Millisecond,
+		Action:   htlcswitch.FwdActionResume,
+	}
+	if in.SessionDeadlineFail {
+		policy.Action = htlcswitch.FwdActionFail
+	}
+
+	log.Debugf("Interceptor session hold deadline set to %v, action=%v",
+		policy.Deadline, policy.Action)
+
+	r.htlcSwitch.SetHoldPolicy(policy)
+
+	return nil
+}
+
 // onIntercept is the function that is called by the switch for every forwarded
 // packet. Our interceptor makes sure we hold the packet and then signal to the
 // main loop to handle the packet. We only return true if we were able
@@ -91,6 +147,13 @@ func (r *forwardInterceptor) onIntercept(
 		CustomRecords:           htlc.CustomRecords,
 		OnionBlob:               htlc.OnionBlob[:],
 		AutoFailHeight:          htlc.AutoFailHeight,
+		IncomingAliasScid:       htlc.IncomingAlias.ToUint64(),
+		IncomingConfirmedScid:   htlc.IncomingConfirmedScid.ToUint64(),
+	}
+
+	// The peer is unknown if the incoming channel couldn't be found.
+	if htlc.IncomingPeer != [33]byte{} {
+		interceptionRequest.IncomingPeerPubkey = htlc.IncomingPeer[:]
 	}
 
 	return r.stream.Send(interceptionRequest)
@@ -98,6 +98,26 @@ type RouterBackend struct {
 	// management after manually setting channel status.
 	SetChannelAuto func(wire.OutPoint) error
 
+	// FetchPaymentLifecycleState returns a snapshot of the internal state
+	// of the payment lifecycle that is sending the given payment.
+	FetchPaymentLifecycleState func(lntypes.Hash) (
+		*routing.PaymentLifecycleState, error)
+
+	// FetchPaymentBySeqNum returns the payment with the given sequence
+	// number.
+	FetchPaymentBySeqNum func(context.Context, uint64) (
+		*channeldb.MPPayment, error)
+
+	// LookupPaymentByReference returns the payment carrying the given
+	// client reference.
+	LookupPaymentByReference func(context.Context, []byte) (
+		*channeldb.MPPayment, error)
+
+	// ReconcileStaleAttempts returns the in-flight htlc attempts that are
+	// unknown to the switch, failing them if the flag is set.
+	ReconcileStaleAttempts func(context.Context, bool) (
+		[]*routing.StaleAttempt, error)
+
 	// UseStatusInitiated is a boolean that indicates whether the router
 	// should use the new status code `Payment_INITIATED`.
 	//
@@ -787,6 +807,25 @@ func (r *RouterBackend) extractIntentFromSendRequest(
 	}
 	payIntent.TimePref = rpcPayReq.TimePref
 
+	// Pass along the payment's label.
+	if len(rpcPayReq.Label) > channeldb.MaxPaymentLabelLength {
+		return nil, fmt.Errorf("label exceeds maximum length of %d "+
+			"bytes", channeldb.MaxPaymentLabelLength)
+	}
+	payIntent.Label = rpcPayReq.Label
+
+	// Pass along the maximum number of attempts recorded for the payment.
+	payIntent.MaxRecordedAttempts = rpcPayReq.MaxRecordedAttempts
+
+	// Pass along the payment's client reference.
+	ref := rpcPayReq.ClientReference
+	if len(ref) > channeldb.MaxClientReferenceLength {
+		return nil, fmt.Errorf("client reference exceeds maximum "+
+			"length of %d bytes",
+			channeldb.MaxClientReferenceLength)
+	}
+	payIntent.ClientReference = ref
+
 	// Pass along restrictions on the outgoing channels that may be used.
 	payIntent.OutgoingChannelIDs = rpcPayReq.OutgoingChanIds
 
@@ -1318,9 +1357,10 @@ func (r *RouterBackend) MarshalHTLCAttempt(
 	}
 
 	rpcAttempt := &lnrpc.HTLCAttempt{
-		AttemptId:     htlc.AttemptID,
-		AttemptTimeNs: MarshalTimeNano(htlc.AttemptTime),
-		Route:         route,
+		AttemptId:        htlc.AttemptID,
+		AttemptTimeNs:    MarshalTimeNano(htlc.AttemptTime),
+		Route:            route,
+		ChainFeeSatPerKw: uint64(htlc.ChainFeeRate),
 	}
 
 	switch {
@@ -1342,6 +1382,20 @@ func (r *RouterBackend) MarshalHTLCAttempt(
 		if err != nil {
 			return nil, err
 		}
+
+		// The source of an unreadable failure is unknown, and an
+		// abandoned attempt has no failure source at all. Attempts
+		// stored with a failure source index that doesn't point into
+		// their route are left without a source as well.
+		reason := htlc.Failure.Reason
+		if reason != channeldb.HTLCFailUnreadable &&
+			reason != channeldb.HTLCFailAbandoned {
+			source, err := htlc.FailureSourceNode()
+			if err == nil {
+				rpcAttempt.Failure.FailureSourcePubKey =
+					source[:]
+			}
+		}
 	default:
 		rpcAttempt.Status = lnrpc.HTLCAttempt_IN_FLIGHT
 	}
@@ -1368,6 +1422,9 @@ func marshallHtlcFailure(failure *channeldb.HTLCFailInfo) (*lnrpc.Failure,
 	case channeldb.HTLCFailInternal:
 		rpcFailure.Code = lnrpc.Failure_INTERNAL_FAILURE
 
+	case channeldb.HTLCFailAbandoned:
+		rpcFailure.Code = lnrpc.Failure_ABANDONED_FAILURE
+
 	case channeldb.HTLCFailMessage:
 		err := marshallWireError(failure.Message, rpcFailure)
 		if err != nil {
@@ -1568,19 +1625,14 @@ func marshallChannelUpdate(update *lnwire.ChannelUpdate) *lnrpc.ChannelUpdate {
 func (r *RouterBackend) MarshallPayment(payment *channeldb.MPPayment) (
 	*lnrpc.Payment, error) {
 
-	// Fetch the payment's preimage and the total paid in fees.
-	var (
-		fee      lnwire.MilliSatoshi
-		preimage lntypes.Preimage
-	)
-	for _, htlc := range payment.HTLCs {
-		// If any of the htlcs have settled, extract a valid
-		// preimage.
-		if htlc.Settle != nil {
-			preimage = htlc.Settle.Preimage
-			fee += htlc.Route.TotalFees()
-		}
+	// Fetch the payment's preimage and the total paid in fees. Both are
+	// derived from the settled htlcs, so they are set even if the htlcs
+	// themselves are later omitted from the response.
+	var preimage lntypes.Preimage
+	if settled, _ := payment.TerminalInfo(); settled != nil {
+		preimage = settled.Settle.Preimage
 	}
+	_, fee := payment.SettledAmt()
 
 	msatValue := int64(payment.Info.Value)
 	satValue := int64(payment.Info.Value.ToSatoshis())
@@ -1612,6 +1664,11 @@ func (r *RouterBackend) MarshallPayment(payment *channeldb.MPPayment) (
 		return nil, err
 	}
 
+	var paymentAddr []byte
+	if payment.Info.PaymentAddr != nil {
+		paymentAddr = payment.Info.PaymentAddr[:]
+	}
+
 	return &lnrpc.Payment{
 		// TODO: set this to setID for AMP-payments?
 		PaymentHash:     hex.EncodeToString(paymentID[:]),
@@ -1629,9 +1686,88 @@ func (r *RouterBackend) MarshallPayment(payment *channeldb.MPPayment) (
 		Htlcs:           htlcs,
 		PaymentIndex:    payment.SequenceNum,
 		FailureReason:   failureReason,
+		Label:           payment.Info.Label,
+		PaymentAddr:     paymentAddr,
+		Timings:         marshallPaymentTimings(payment.Timings),
+		ClientReference: payment.Info.ClientReference,
+		FailureDetail: marshallPaymentFailureDetail(
+			payment.FailureDetail,
+		),
 	}, nil
 }
 
+// marshallPaymentFailureDetail converts the failure detail of a payment to its
+// rpc representation, returning nil if there is no failure detail.
+func marshallPaymentFailureDetail(
+	detail *channeldb.PaymentFailureDetail) *lnrpc.PaymentFailureDetail {
+
+	if detail == nil {
+		return nil
+	}
+
+	return &lnrpc.PaymentFailureDetail{
+		Error:       detail.Error,
+		NumAttempts: detail.NumAttempts,
+	}
+}
+
+// marshallPaymentTimings converts the timings of a payment to its rpc
+// representation, returning nil if there are no timings.
+func marshallPaymentTimings(
+	timings *channeldb.PaymentTimings) *lnrpc.PaymentTimings {
+
+	if timings == nil {
+		return nil
+	}
+
+	return &lnrpc.PaymentTimings{
+		InitCommittedTimeNs: MarshalTimeNano(timings.InitCommitted),
+		FirstRouteFoundTimeNs: MarshalTimeNano(
+			timings.FirstRouteFound,
+		),
+		FirstAttemptRegisteredTimeNs: MarshalTimeNano(
+			timings.FirstAttemptRegistered,
+		),
+		FirstAttemptDispatchedTimeNs: MarshalTimeNano(
+			timings.FirstAttemptDispatched,
+		),
+	}
+}
+
+// marshallAttemptEvent converts the transition of the htlc attempt with the
+// given ID to its rpc representation.
+func marshallAttemptEvent(attemptID uint64,
+	event channeldb.AttemptEvent) (*lnrpc.PaymentAttemptEvent, error) {
+
+	var transition lnrpc.PaymentAttemptEvent_Transition
+	switch event {
+	case channeldb.AttemptEventRegistered:
+		transition = lnrpc.PaymentAttemptEvent_REGISTERED
+
+	case channeldb.AttemptEventSettled:
+		transition = lnrpc.PaymentAttemptEvent_SETTLED
+
+	case channeldb.AttemptEventFailed:
+		transition = lnrpc.PaymentAttemptEvent_FAILED
+
+	default:
+		return nil, fmt.Errorf("unhandled attempt event %v", event)
+	}
+
+	return &lnrpc.PaymentAttemptEvent{
+		AttemptId:  attemptID,
+		Transition: transition,
+	}, nil
+}
+
+// MarshallPaymentStatus converts a channeldb.PaymentStatus to the type
+// expected by the RPC, reporting initiated payments as such.
+func MarshallPaymentStatus(dbStatus channeldb.PaymentStatus) (
+	lnrpc.Payment_PaymentStatus, error) {
+
+	return convertPaymentStatus(dbStatus, true)
+}
+
 // convertPaymentStatus converts a channeldb.PaymentStatus to the type expected
 // by the RPC.
 func convertPaymentStatus(dbStatus channeldb.PaymentStatus, useInit bool) (
@@ -1685,6 +1821,12 @@ func marshallPaymentFailureReason(reason *channeldb.FailureReason) (
 
 	case channeldb.FailureReasonInsufficientBalance:
 		return lnrpc.PaymentFailureReason_FAILURE_REASON_INSUFFICIENT_BALANCE, nil
+
+	case channeldb.FailureReasonCanceled:
+		return lnrpc.PaymentFailureReason_FAILURE_REASON_CANCELED, nil
+
+	case channeldb.FailureReasonLegacyUnknown:
+		return lnrpc.PaymentFailureReason_FAILURE_REASON_LEGACY_UNKNOWN, nil
 	}
 
 	return 0, errors.New("unknown failure reason")
@@ -7,7 +7,9 @@ import (
 	"testing"
 
 	"github.com/btcsuite/btcd/btcutil"
+	"github.com/lightningnetwork/lnd/channeldb"
 	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lntypes"
 	"github.com/lightningnetwork/lnd/lnwire"
 	"github.com/lightningnetwork/lnd/routing"
 	"github.com/lightningnetwork/lnd/routing/route"
@@ -475,3 +477,186 @@ func testUnmarshalAMP(t *testing.T, test unmarshalAMPTest) {
 		t.Fatalf("test case has non-standard outcome")
 	}
 }
+
+// TestMarshallPaymentFailureReason checks that every failure reason a payment
+// can be read with maps to an rpc failure reason.
+func TestMarshallPaymentFailureReason(t *testing.T) {
+	t.Parallel()
+
+	reasons := map[channeldb.FailureReason]lnrpc.PaymentFailureReason{
+		channeldb.FailureReasonTimeout:             lnrpc.PaymentFailureReason_FAILURE_REASON_TIMEOUT,
+		channeldb.FailureReasonNoRoute:             lnrpc.PaymentFailureReason_FAILURE_REASON_NO_ROUTE,
+		channeldb.FailureReasonError:               lnrpc.PaymentFailureReason_FAILURE_REASON_ERROR,
+		channeldb.FailureReasonPaymentDetails:      lnrpc.PaymentFailureReason_FAILURE_REASON_INCORRECT_PAYMENT_DETAILS,
+		channeldb.FailureReasonInsufficientBalance: lnrpc.PaymentFailureReason_FAILURE_REASON_INSUFFICIENT_BALANCE,
+		channeldb.FailureReasonCanceled:            lnrpc.PaymentFailureReason_FAILURE_REASON_CANCELED,
+		channeldb.FailureReasonLegacyUnknown:       lnrpc.PaymentFailureReason_FAILURE_REASON_LEGACY_UNKNOWN,
+	}
+
+	for reason, expected := range reasons {
+		reason := reason
+
+		rpcReason, err := marshallPaymentFailureReason(&reason)
+		require.NoError(t, err, reason)
+		require.Equal(t, expected, rpcReason, reason)
+	}
+
+	rpcReason, err := marshallPaymentFailureReason(nil)
+	require.NoError(t, err)
+	require.Equal(
+		t, lnrpc.PaymentFailureReason_FAILURE_REASON_NONE, rpcReason,
+	)
+}
+
+// TestMarshallPaymentFeeAndPreimage checks that the fee and preimage of a
+// payment are derived from its settled attempts only.
+func TestMarshallPaymentFeeAndPreimage(t *testing.T) {
+	t.Parallel()
+
+	backend := &RouterBackend{
+		FetchChannelCapacity: func(chanID uint64) (btcutil.Amount,
+			error) {
+
+			return 0, nil
+		},
+	}
+
+	// newAttempt returns an attempt that pays 1000 msat to the receiver
+	// through a single intermediate hop charging the given fee.
+	newAttempt := func(id uint64,
+		fee lnwire.MilliSatoshi) channeldb.HTLCAttempt {
+
+		hops := []*route.Hop{
+			{PubKeyBytes: route.Vertex{2}, AmtToForward: 1000},
+			{PubKeyBytes: route.Vertex{3}, AmtToForward: 1000},
+		}
+
+		return channeldb.HTLCAttempt{
+			HTLCAttemptInfo: channeldb.HTLCAttemptInfo{
+				AttemptID: id,
+				Route: route.Route{
+					TotalAmount:  1000 + fee,
+					SourcePubKey: route.Vertex{1},
+					Hops:         hops,
+				},
+			},
+		}
+	}
+
+	preimage := lntypes.Preimage{1}
+
+	failed := newAttempt(0, 20)
+	failed.Failure = &channeldb.HTLCFailInfo{
+		Reason: channeldb.HTLCFailUnreadable,
+	}
+
+	settled := newAttempt(1, 10)
+	settled.Settle = &channeldb.HTLCSettleInfo{Preimage: preimage}
+
+	payment := &channeldb.MPPayment{
+		Info: &channeldb.PaymentCreationInfo{
+			PaymentIdentifier: preimage.Hash(),
+			Value:             1000,
+		},
+		HTLCs:  []channeldb.HTLCAttempt{failed, settled},
+		Status: channeldb.StatusSucceeded,
+	}
+
+	rpcPayment, err := backend.MarshallPayment(payment)
+	require.NoError(t, err)
+	require.EqualValues(t, 10, rpcPayment.FeeMsat)
+	require.Equal(t, preimage.String(), rpcPayment.PaymentPreimage)
+	require.Len(t, rpcPayment.Htlcs, 2)
+
+	// Without a settled attempt, neither a fee nor a preimage is set.
+	payment.HTLCs = []channeldb.HTLCAttempt{failed}
+	payment.Status = channeldb.StatusFailed
+
+	rpcPayment, err = backend.MarshallPayment(payment)
+	require.NoError(t, err)
+	require.Zero(t, rpcPayment.FeeMsat)
+	require.Equal(
+		t, lntypes.Preimage{}.String(), rpcPayment.PaymentPreimage,
+	)
+}
+
+// TestMarshalHTLCAttemptFailure checks that abandoned attempts are marshalled
+// with their own failure code and without a failure source, while other
+// failures carry the node that generated them.
+func TestMarshalHTLCAttemptFailure(t *testing.T) {
+	t.Parallel()
+
+	backend := &RouterBackend{
+		FetchChannelCapacity: func(chanID uint64) (btcutil.Amount,
+			error) {
+
+			return 0, nil
+		},
+	}
+
+	hopKey := route.Vertex{2}
+	attempt := channeldb.HTLCAttempt{
+		HTLCAttemptInfo: channeldb.HTLCAttemptInfo{
+			Route: route.Route{
+				SourcePubKey: route.Vertex{1},
+				Hops: []*route.Hop{{
+					PubKeyBytes: hopKey,
+				}},
+			},
+		},
+	}
+
+	// An attempt that failed at the hop carries the hop's public key.
+	attempt.Failure = &channeldb.HTLCFailInfo{
+		Reason:             channeldb.HTLCFailUnknown,
+		FailureSourceIndex: 1,
+	}
+	rpcAttempt, err := backend.MarshalHTLCAttempt(attempt)
+	require.NoError(t, err)
+	require.Equal(t, lnrpc.HTLCAttempt_FAILED, rpcAttempt.Status)
+	require.Equal(
+		t, lnrpc.Failure_UNKNOWN_FAILURE, rpcAttempt.Failure.Code,
+	)
+	require.Equal(t, hopKey[:], rpcAttempt.Failure.FailureSourcePubKey)
+
+	// A decoded failure message carries its code along with the index
+	// and the public key of the node that generated it.
+	attempt.Failure = &channeldb.HTLCFailInfo{
+		Reason:             channeldb.HTLCFailMessage,
+		Message:            lnwire.NewTemporaryChannelFailure(nil),
+		FailureSourceIndex: 1,
+	}
+	rpcAttempt, err = backend.MarshalHTLCAttempt(attempt)
+	require.NoError(t, err)
+	require.Equal(
+		t, lnrpc.Failure_TEMPORARY_CHANNEL_FAILURE,
+		rpcAttempt.Failure.Code,
+	)
+	require.EqualValues(t, 1, rpcAttempt.Failure.FailureSourceIndex)
+	require.Equal(t, hopKey[:], rpcAttempt.Failure.FailureSourcePubKey)
+
+	// The source of an unreadable failure is unknown.
+	attempt.Failure = &channeldb.HTLCFailInfo{
+		Reason:             channeldb.HTLCFailUnreadable,
+		FailureSourceIndex: 1,
+	}
+	rpcAttempt, err = backend.MarshalHTLCAttempt(attempt)
+	require.NoError(t, err)
+	require.Equal(
+		t, lnrpc.Failure_UNREADABLE_FAILURE, rpcAttempt.Failure.Code,
+	)
+	require.Empty(t, rpcAttempt.Failure.FailureSourcePubKey)
+
+	// An abandoned attempt counts as failed, but is distinguishable by
+	// its failure code and has no failure source.
+	attempt.Failure = &channeldb.HTLCFailInfo{
+		Reason: channeldb.HTLCFailAbandoned,
+	}
+	rpcAttempt, err = backend.MarshalHTLCAttempt(attempt)
+	require.NoError(t, err)
+	require.Equal(t, lnrpc.HTLCAttempt_FAILED, rpcAttempt.Status)
+	require.Equal(
+		t, lnrpc.Failure_ABANDONED_FAILURE, rpcAttempt.Failure.Code,
+	)
+	require.Empty(t, rpcAttempt.Failure.FailureSourcePubKey)
+}
@@ -30,6 +30,14 @@ type RouterClient interface {
 	// TrackPaymentV2 returns an update stream for the payment identified by the
 	// payment hash.
 	TrackPaymentV2(ctx context.Context, in *TrackPaymentRequest, opts ...grpc.CallOption) (Router_TrackPaymentV2Client, error)
+	// TrackPaymentBySeqNum returns an update stream for the payment identified by
+	// its sequence number. Unlike a payment hash, the sequence number always
+	// refers to exactly one payment record, so only updates of that record are
+	// streamed back.
+	TrackPaymentBySeqNum(ctx context.Context, in *TrackPaymentBySeqNumRequest, opts ...grpc.CallOption) (Router_TrackPaymentBySeqNumClient, error)
+	// LookupPaymentByReference returns the payment that was sent with the given
+	// client reference.
+	LookupPaymentByReference(ctx context.Context, in *LookupPaymentByReferenceRequest, opts ...grpc.CallOption) (*lnrpc.Payment, error)
 	// TrackPayments returns an update stream for every payment that is not in a
 	// terminal state. Note that if payments are in-flight while starting a new
 	// subscription, the start of the payment stream could produce out-of-order
@@ -116,6 +124,18 @@ type RouterClient interface {
 	// channel to stay disabled until a subsequent manual request of either
 	// "enable" or "auto".
 	UpdateChanStatus(ctx context.Context, in *UpdateChanStatusRequest, opts ...grpc.CallOption) (*UpdateChanStatusResponse, error)
+	// GetPaymentLifecycleState returns a snapshot of the internal state of the
+	// payment lifecycle that is currently sending the payment identified by the
+	// payment hash. It is a debugging feature that is only available while the
+	// payment is in flight, a NotFound error is returned otherwise.
+	GetPaymentLifecycleState(ctx context.Context, in *GetPaymentLifecycleStateRequest, opts ...grpc.CallOption) (*GetPaymentLifecycleStateResponse, error)
+	// ReconcileStaleAttempts compares the in-flight HTLC attempts of outgoing
+	// payments against the switch and returns the attempts that have neither a
+	// circuit nor a stored result, for example because they were lost during a
+	// crash. If fail_stale is set, these attempts are marked as failed so their
+	// payments can make progress again. It is a debugging feature that should
+	// be used with care.
+	ReconcileStaleAttempts(ctx context.Context, in *ReconcileStaleAttemptsRequest, opts ...grpc.CallOption) (*ReconcileStaleAttemptsResponse, error)
 }
 
 type routerClient struct {
@@ -190,8 +210,49 @@ func (x *routerTrackPaymentV2Client) Recv() (*lnrpc.Payment, error) {
 	return m, nil
 }
 
+func (c *routerClient) TrackPaymentBySeqNum(ctx context.Context, in *TrackPaymentBySeqNumRequest, opts ...grpc.CallOption) (Router_TrackPaymentBySeqNumClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Router_ServiceDesc.Streams[2], "/routerrpc.Router/TrackPaymentBySeqNum", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &routerTrackPaymentBySeqNumClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Router_TrackPaymentBySeqNumClient interface {
+	Recv() (*lnrpc.Payment, error)
+	grpc.ClientStream
+}
+
+type routerTrackPaymentBySeqNumClient struct {
+	grpc.ClientStream
+}
+
+func (x *routerTrackPaymentBySeqNumClient) Recv() (*lnrpc.Payment, error) {
+	m := new(lnrpc.Payment)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *routerClient) LookupPaymentByReference(ctx context.Context, in *LookupPaymentByReferenceRequest, opts ...grpc.CallOption) (*lnrpc.Payment, error) {
+	out := new(lnrpc.Payment)
+	err := c.cc.Invoke(ctx, "/routerrpc.Router/LookupPaymentByReference", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *routerClient) TrackPayments(ctx context.Context, in *TrackPaymentsRequest, opts ...grpc.CallOption) (Router_TrackPaymentsClient, error) {
-	stream, err := c.cc.NewStream(ctx, &Router_ServiceDesc.Streams[2], "/routerrpc.Router/TrackPayments", opts...)
+	stream, err := c.cc.NewStream(ctx, &Router_ServiceDesc.Streams[3], "/routerrpc.Router/TrackPayments", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -314,7 +375,7 @@ func (c *routerClient) BuildRoute(ctx context.Context, in *BuildRouteRequest, op
 }
 
 func (c *routerClient) SubscribeHtlcEvents(ctx context.Context, in *SubscribeHtlcEventsRequest, opts ...grpc.CallOption) (Router_SubscribeHtlcEventsClient, error) {
-	stream, err := c.cc.NewStream(ctx, &Router_ServiceDesc.Streams[3], "/routerrpc.Router/SubscribeHtlcEvents", opts...)
+	stream, err := c.cc.NewStream(ctx, &Router_ServiceDesc.Streams[4], "/routerrpc.Router/SubscribeHtlcEvents", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -347,7 +408,7 @@ func (x *routerSubscribeHtlcEventsClient) Recv() (*HtlcEvent, error) {
 
 // Deprecated: Do not use.
 func (c *routerClient) SendPayment(ctx context.Context, in *SendPaymentRequest, opts ...grpc.CallOption) (Router_SendPaymentClient, error) {
-	stream, err := c.cc.NewStream(ctx, &Router_ServiceDesc.Streams[4], "/routerrpc.Router/SendPayment", opts...)
+	stream, err := c.cc.NewStream(ctx, &Router_ServiceDesc.Streams[5], "/routerrpc.Router/SendPayment", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -380,7 +441,7 @@ func (x *routerSendPaymentClient) Recv() (*PaymentStatus, error) {
 
 // Deprecated: Do not use.
 func (c *routerClient) TrackPayment(ctx context.Context, in *TrackPaymentRequest, opts ...grpc.CallOption) (Router_TrackPaymentClient, error) {
-	stream, err := c.cc.NewStream(ctx, &Router_ServiceDesc.Streams[5], "/routerrpc.Router/TrackPayment", opts...)
+	stream, err := c.cc.NewStream(ctx, &Router_ServiceDesc.Streams[6], "/routerrpc.Router/TrackPayment", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -412,7 +473,7 @@ func (x *routerTrackPaymentClient) Recv() (*PaymentStatus, error) {
 }
 
 func (c *routerClient) HtlcInterceptor(ctx context.Context, opts ...grpc.CallOption) (Router_HtlcInterceptorClient, error) {
-	stream, err := c.cc.NewStream(ctx, &Router_ServiceDesc.Streams[6], "/routerrpc.Router/HtlcInterceptor", opts...)
+	stream, err := c.cc.NewStream(ctx, &Router_ServiceDesc.Streams[7], "/routerrpc.Router/HtlcInterceptor", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -451,6 +512,24 @@ func (c *routerClient) UpdateChanStatus(ctx context.Context, in *UpdateChanStatu
 	return out, nil
 }
 
+func (c *routerClient) GetPaymentLifecycleState(ctx context.Context, in *GetPaymentLifecycleStateRequest, opts ...grpc.CallOption) (*GetPaymentLifecycleStateResponse, error) {
+	out := new(GetPaymentLifecycleStateResponse)
+	err := c.cc.Invoke(ctx, "/routerrpc.Router/GetPaymentLifecycleState", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routerClient) ReconcileStaleAttempts(ctx context.Context, in *ReconcileStaleAttemptsRequest, opts ...grpc.CallOption) (*ReconcileStaleAttemptsResponse, error) {
+	out := new(ReconcileStaleAttemptsResponse)
+	err := c.cc.Invoke(ctx, "/routerrpc.Router/ReconcileStaleAttempts", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // RouterServer is the server API for Router service.
 // All implementations must embed UnimplementedRouterServer
 // for forward compatibility
@@ -466,6 +545,14 @@ type RouterServer interface {
 	// TrackPaymentV2 returns an update stream for the payment identified by the
 	// payment hash.
 	TrackPaymentV2(*TrackPaymentRequest, Router_TrackPaymentV2Server) error
+	// TrackPaymentBySeqNum returns an update stream for the payment identified by
+	// its sequence number. Unlike a payment hash, the sequence number always
+	// refers to exactly one payment record, so only updates of that record are
+	// streamed back.
+	TrackPaymentBySeqNum(*TrackPaymentBySeqNumRequest, Router_TrackPaymentBySeqNumServer) error
+	// LookupPaymentByReference returns the payment that was sent with the given
+	// client reference.
+	LookupPaymentByReference(context.Context, *LookupPaymentByReferenceRequest) (*lnrpc.Payment, error)
 	// TrackPayments returns an update stream for every payment that is not in a
 	// terminal state. Note that if payments are in-flight while starting a new
 	// subscription, the start of the payment stream could produce out-of-order
@@ -552,6 +639,18 @@ type RouterServer interface {
 	// channel to stay disabled until a subsequent manual request of either
 	// "enable" or "auto".
 	UpdateChanStatus(context.Context, *UpdateChanStatusRequest) (*UpdateChanStatusResponse, error)
+	// GetPaymentLifecycleState returns a snapshot of the internal state of the
+	// payment lifecycle that is currently sending the payment identified by the
+	// payment hash. It is a debugging feature that is only available while the
+	// payment is in flight, a NotFound error is returned otherwise.
+	GetPaymentLifecycleState(context.Context, *GetPaymentLifecycleStateRequest) (*GetPaymentLifecycleStateResponse, error)
+	// ReconcileStaleAttempts compares the in-flight HTLC attempts of outgoing
+	// payments against the switch and returns the attempts that have neither a
+	// circuit nor a stored result, for example because they were lost during a
+	// crash. If fail_stale is set, these attempts are marked as failed so their
+	// payments can make progress again. It is a debugging feature that should
+	// be used with care.
+	ReconcileStaleAttempts(context.Context, *ReconcileStaleAttemptsRequest) (*ReconcileStaleAttemptsResponse, error)
 	mustEmbedUnimplementedRouterServer()
 }
 
@@ -565,6 +664,12 @@ func (UnimplementedRouterServer) SendPaymentV2(*SendPaymentRequest, Router_SendP
 func (UnimplementedRouterServer) TrackPaymentV2(*TrackPaymentRequest, Router_TrackPaymentV2Server) error {
 	return status.Errorf(codes.Unimplemented, "method TrackPaymentV2 not implemented")
 }
+func (UnimplementedRouterServer) TrackPaymentBySeqNum(*TrackPaymentBySeqNumRequest, Router_TrackPaymentBySeqNumServer) error {
+	return status.Errorf(codes.Unimplemented, "method TrackPaymentBySeqNum not implemented")
+}
+func (UnimplementedRouterServer) LookupPaymentByReference(context.Context, *LookupPaymentByReferenceRequest) (*lnrpc.Payment, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LookupPaymentByReference not implemented")
+}
 func (UnimplementedRouterServer) TrackPayments(*TrackPaymentsRequest, Router_TrackPaymentsServer) error {
 	return status.Errorf(codes.Unimplemented, "method TrackPayments not implemented")
 }
@@ -613,6 +718,12 @@ func (UnimplementedRouterServer) HtlcInterceptor(Router_HtlcInterceptorServer) e
 func (UnimplementedRouterServer) UpdateChanStatus(context.Context, *UpdateChanStatusRequest) (*UpdateChanStatusResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method UpdateChanStatus not implemented")
 }
+func (UnimplementedRouterServer) GetPaymentLifecycleState(context.Context, *GetPaymentLifecycleStateRequest) (*GetPaymentLifecycleStateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPaymentLifecycleState not implemented")
+}
+func (UnimplementedRouterServer) ReconcileStaleAttempts(context.Context, *ReconcileStaleAttemptsRequest) (*ReconcileStaleAttemptsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReconcileStaleAttempts not implemented")
+}
 func (UnimplementedRouterServer) mustEmbedUnimplementedRouterServer() {}
 
 // UnsafeRouterServer may be embedded to opt out of forward compatibility for this service.
@@ -668,6 +779,45 @@ func (x *routerTrackPaymentV2Server) Send(m *lnrpc.Payment) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _Router_TrackPaymentBySeqNum_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(TrackPaymentBySeqNumRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(RouterServer).TrackPaymentBySeqNum(m, &routerTrackPaymentBySeqNumServer{stream})
+}
+
+type Router_TrackPaymentBySeqNumServer interface {
+	Send(*lnrpc.Payment) error
+	grpc.ServerStream
+}
+
+type routerTrackPaymentBySeqNumServer struct {
+	grpc.ServerStream
+}
+
+func (x *routerTrackPaymentBySeqNumServer) Send(m *lnrpc.Payment) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Router_LookupPaymentByReference_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LookupPaymentByReferenceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouterServer).LookupPaymentByReference(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/routerrpc.Router/LookupPaymentByReference",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouterServer).LookupPaymentByReference(ctx, req.(*LookupPaymentByReferenceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Router_TrackPayments_Handler(srv interface{}, stream grpc.ServerStream) error {
 	m := new(TrackPaymentsRequest)
 	if err := stream.RecvMsg(m); err != nil {
@@ -976,6 +1126,42 @@ func _Router_UpdateChanStatus_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Router_GetPaymentLifecycleState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPaymentLifecycleStateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouterServer).GetPaymentLifecycleState(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/routerrpc.Router/GetPaymentLifecycleState",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouterServer).GetPaymentLifecycleState(ctx, req.(*GetPaymentLifecycleStateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Router_ReconcileStaleAttempts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReconcileStaleAttemptsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouterServer).ReconcileStaleAttempts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/routerrpc.Router/ReconcileStaleAttempts",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouterServer).ReconcileStaleAttempts(ctx, req.(*ReconcileStaleAttemptsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Router_ServiceDesc is the grpc.ServiceDesc for Router service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -983,6 +1169,10 @@ var Router_ServiceDesc = grpc.ServiceDesc{
 	ServiceName: "routerrpc.Router",
 	HandlerType: (*RouterServer)(nil),
 	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "LookupPaymentByReference",
+			Handler:    _Router_LookupPaymentByReference_Handler,
+		},
 		{
 			MethodName: "EstimateRouteFee",
 			Handler:    _Router_EstimateRouteFee_Handler,
@@ -1027,6 +1217,14 @@ var Router_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "UpdateChanStatus",
 			Handler:    _Router_UpdateChanStatus_Handler,
 		},
+		{
+			MethodName: "GetPaymentLifecycleState",
+			Handler:    _Router_GetPaymentLifecycleState_Handler,
+		},
+		{
+			MethodName: "ReconcileStaleAttempts",
+			Handler:    _Router_ReconcileStaleAttempts_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -1039,6 +1237,11 @@ var Router_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _Router_TrackPaymentV2_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "TrackPaymentBySeqNum",
+			Handler:       _Router_TrackPaymentBySeqNum_Handler,
+			ServerStreams: true,
+		},
 		{
 			StreamName:    "TrackPayments",
 			Handler:       _Router_TrackPayments_Handler,
@@ -85,6 +85,14 @@ var (
 			Entity: "offchain",
 			Action: "read",
 		}},
+		"/routerrpc.Router/TrackPaymentBySeqNum": {{
+			Entity: "offchain",
+			Action: "read",
+		}},
+		"/routerrpc.Router/LookupPaymentByReference": {{
+			Entity: "offchain",
+			Action: "read",
+		}},
 		"/routerrpc.Router/TrackPayments": {{
 			Entity: "offchain",
 			Action: "read",
@@ -141,6 +149,14 @@ var (
 			Entity: "offchain",
 			Action: "write",
 		}},
+		"/routerrpc.Router/GetPaymentLifecycleState": {{
+			Entity: "offchain",
+			Action: "read",
+		}},
+		"/routerrpc.Router/ReconcileStaleAttempts": {{
+			Entity: "offchain",
+			Action: "write",
+		}},
 	}
 
 	// DefaultRouterMacFilename is the default name of the router macaroon
@@ -365,7 +381,7 @@ func (s *Server) SendPaymentV2(req *SendPaymentRequest,
 
 	// Track the payment and return.
 	return s.trackPayment(
-		sub, payHash, stream, req.NoInflightUpdates,
+		sub, payHash, stream, req.NoInflightUpdates, false,
 	)
 }
 
@@ -1251,7 +1267,78 @@ func (s *Server) TrackPaymentV2(request *TrackPaymentRequest,
 		return err
 	}
 
-	return s.trackPayment(sub, payHash, stream, request.NoInflightUpdates)
+	return s.trackPayment(
+		sub, payHash, stream, request.NoInflightUpdates,
+		request.IncludeAttemptEvents,
+	)
+}
+
+// TrackPaymentBySeqNum returns a stream of state updates for the payment with
+// the given sequence number. Updates of other payment records sharing the same
+// payment hash are filtered out. The stream is closed when the payment
+// completes.
+func (s *Server) TrackPaymentBySeqNum(request *TrackPaymentBySeqNumRequest,
+	stream Router_TrackPaymentBySeqNumServer) error {
+
+	seqNum := request.SequenceNumber
+
+	log.Debugf("TrackPaymentBySeqNum called for sequence number %d",
+		seqNum)
+
+	router := s.cfg.RouterBackend
+	payment, err := router.FetchPaymentBySeqNum(stream.Context(), seqNum)
+	switch {
+	case errors.Is(err, channeldb.ErrPaymentNotInitiated):
+		return status.Error(codes.NotFound, err.Error())
+	case err != nil:
+		return err
+	}
+
+	// A payment in a terminal state won't receive any further updates, so
+	// we send it back right away. This also covers legacy duplicate
+	// payments, which aren't tracked by the control tower.
+	if payment.Terminated() {
+		rpcPayment, err := router.MarshallPayment(payment)
+		if err != nil {
+			return err
+		}
+
+		return stream.Send(rpcPayment)
+	}
+
+	payHash := payment.Info.PaymentIdentifier
+	sub, err := s.subscribePayment(payHash)
+	if err != nil {
+		return err
+	}
+
+	// Only forward updates of the payment record we were asked to track,
+	// as well as its deletion.
+	send := func(p *lnrpc.Payment) error {
+		if p.Status != lnrpc.Payment_DELETED &&
+			p.PaymentIndex != seqNum {
+
+			return nil
+		}
+
+		return stream.Send(p)
+	}
+
+	err = s.trackPaymentStream(
+		stream.Context(), sub, request.NoInflightUpdates, false, send,
+	)
+	if errors.Is(err, context.Canceled) {
+		log.Infof("Payment stream for sequence number %d canceled",
+			seqNum)
+
+		return nil
+	}
+	if err != nil {
+		log.Errorf("TrackPaymentBySeqNum got error for sequence "+
+			"number %d: %v", seqNum, err)
+	}
+
+	return err
 }
 
 // subscribePayment subscribes to the payment updates for the given payment
@@ -1276,10 +1363,11 @@ func (s *Server) subscribePayment(identifier lntypes.Hash) (
 // trackPayment writes payment status updates to the provided stream.
 func (s *Server) trackPayment(subscription routing.ControlTowerSubscriber,
 	identifier lntypes.Hash, stream Router_TrackPaymentV2Server,
-	noInflightUpdates bool) error {
+	noInflightUpdates, includeAttemptEvents bool) error {
 
 	err := s.trackPaymentStream(
-		stream.Context(), subscription, noInflightUpdates, stream.Send,
+		stream.Context(), subscription, noInflightUpdates,
+		includeAttemptEvents, stream.Send,
 	)
 
 	// If the context is canceled, we don't return an error.
@@ -1313,7 +1401,7 @@ func (s *Server) TrackPayments(request *TrackPaymentsRequest,
 	// Stream updates to the client.
 	err = s.trackPaymentStream(
 		stream.Context(), subscription, request.NoInflightUpdates,
-		stream.Send,
+		false, stream.Send,
 	)
 
 	if errors.Is(err, context.Canceled) {
@@ -1323,10 +1411,12 @@ func (s *Server) TrackPayments(request *TrackPaymentsRequest,
 	return err
 }
 
-// trackPaymentStream streams payment updates to the client.
+// trackPaymentStream streams payment updates to the client. If
+// includeAttemptEvents is set, updates caused by an htlc attempt carry the
+// attempt's transition.
 func (s *Server) trackPaymentStream(context context.Context,
-	subscription routing.ControlTowerSubscriber, noInflightUpdates bool,
-	send func(*lnrpc.Payment) error) error {
+	subscription routing.ControlTowerSubscriber, noInflightUpdates,
+	includeAttemptEvents bool, send func(*lnrpc.Payment) error) error {
 
 	defer subscription.Close()
 
@@ -1338,7 +1428,28 @@ func (s *Server) trackPaymentStream(context context.Context,
 				// No more payment updates.
 				return nil
 			}
-			result := item.(*channeldb.MPPayment)
+
+			// If the payment was deleted, we let the client know
+			// with a final update carrying the deleted status.
+			deleted, ok := item.(*routing.PaymentDeletedEvent)
+			if ok {
+				hash := deleted.PaymentIdentifier
+
+				log.Tracef("Payment %v deleted", hash)
+
+				err := send(&lnrpc.Payment{
+					PaymentHash: hash.String(),
+					Status:      lnrpc.Payment_DELETED,
+				})
+				if err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			update := item.(*routing.PaymentUpdateEvent)
+			result := update.Payment
 
 			log.Tracef("Payment %v updated to state %v",
 				result.Info.PaymentIdentifier, result.Status)
@@ -1360,6 +1471,16 @@ func (s *Server) trackPaymentStream(context context.Context,
 				return err
 			}
 
+			if includeAttemptEvents && update.AttemptID != nil {
+				event, err := marshallAttemptEvent(
+					*update.AttemptID, update.AttemptEvent,
+				)
+				if err != nil {
+					return err
+				}
+				rpcPayment.AttemptEvent = event
+			}
+
 			// Send event to the client.
 			err = send(rpcPayment)
 			if err != nil {
@@ -1545,3 +1666,131 @@ func (s *Server) UpdateChanStatus(ctx context.Context,
 	}
 	return &UpdateChanStatusResponse{}, nil
 }
+
+// GetPaymentLifecycleState returns a snapshot of the internal state of the
+// payment lifecycle that is currently sending the given payment.
+func (s *Server) GetPaymentLifecycleState(_ context.Context,
+	req *GetPaymentLifecycleStateRequest) (
+	*GetPaymentLifecycleStateResponse, error) {
+
+	payHash, err := lntypes.MakeHash(req.PaymentHash)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	log.Debugf("GetPaymentLifecycleState called for payment %v", payHash)
+
+	state, err := s.cfg.RouterBackend.FetchPaymentLifecycleState(payHash)
+	switch {
+	case errors.Is(err, routing.ErrPaymentLifecycleNotFound):
+		return nil, status.Error(codes.NotFound, err.Error())
+
+	case err != nil:
+		return nil, err
+	}
+
+	resp := &GetPaymentLifecycleStateResponse{
+		Step:               marshallLifecycleStep(state.Step),
+		InflightAttemptIds: state.InFlightAttempts,
+	}
+	for _, t := range state.Transitions {
+		resp.Transitions = append(
+			resp.Transitions, &PaymentLifecycleTransition{
+				Step:        marshallLifecycleStep(t.Step),
+				TimestampNs: t.Time.UnixNano(),
+			},
+		)
+	}
+
+	return resp, nil
+}
+
+// LookupPaymentByReference returns the payment that was sent with the given
+// client reference.
+func (s *Server) LookupPaymentByReference(ctx context.Context,
+	req *LookupPaymentByReferenceRequest) (*lnrpc.Payment, error) {
+
+	ref := req.ClientReference
+	if len(ref) == 0 || len(ref) > channeldb.MaxClientReferenceLength {
+		return nil, status.Errorf(codes.InvalidArgument, "client "+
+			"reference must be between 1 and %d bytes",
+			channeldb.MaxClientReferenceLength)
+	}
+
+	log.Debugf("LookupPaymentByReference called for reference %x", ref)
+
+	router := s.cfg.RouterBackend
+	payment, err := router.LookupPaymentByReference(ctx, ref)
+	switch {
+	case errors.Is(err, channeldb.ErrPaymentNotInitiated):
+		return nil, status.Error(codes.NotFound, err.Error())
+
+	case err != nil:
+		return nil, err
+	}
+
+	return router.MarshallPayment(payment)
+}
+
+// ReconcileStaleAttempts returns the in-flight htlc attempts that are unknown
+// to the switch, optionally failing them.
+func (s *Server) ReconcileStaleAttempts(ctx context.Context,
+	req *ReconcileStaleAttemptsRequest) (*ReconcileStaleAttemptsResponse,
+	error) {
+
+	log.Debugf("ReconcileStaleAttempts called with fail_stale=%v",
+		req.FailStale)
+
+	stale, err := s.cfg.RouterBackend.ReconcileStaleAttempts(
+		ctx, req.FailStale,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &ReconcileStaleAttemptsResponse{}
+	for _, a := range stale {
+		hash := a.PaymentIdentifier
+		resp.StaleAttempts = append(resp.StaleAttempts, &StaleAttempt{
+			PaymentHash:   hash[:],
+			AttemptId:     a.AttemptID,
+			AttemptTimeNs: a.AttemptTime.UnixNano(),
+			Failed:        a.Failed,
+		})
+	}
+
+	return resp, nil
+}
+
+// marshallLifecycleStep converts a payment lifecycle step into its rpc
+// counterpart.
+func marshallLifecycleStep(step routing.LifecycleStep) PaymentLifecycleStep {
+	switch step {
+	case routing.LifecycleStepFetchingPayment:
+		return PaymentLifecycleStep_LIFECYCLE_STEP_FETCHING_PAYMENT
+
+	case routing.LifecycleStepPathfinding:
+		return PaymentLifecycleStep_LIFECYCLE_STEP_PATHFINDING
+
+	case routing.LifecycleStepRegisteringAttempt:
+		return PaymentLifecycleStep_LIFECYCLE_STEP_REGISTERING_ATTEMPT
+
+	case routing.LifecycleStepSendingAttempt:
+		return PaymentLifecycleStep_LIFECYCLE_STEP_SENDING_ATTEMPT
+
+	case routing.LifecycleStepWaitingForResults:
+		return PaymentLifecycleStep_LIFECYCLE_STEP_WAITING_FOR_RESULTS
+
+	case routing.LifecycleStepExiting:
+		return PaymentLifecycleStep_LIFECYCLE_STEP_EXITING
+
+	case routing.LifecycleStepBlockedOnDBWrite:
+		return PaymentLifecycleStep_LIFECYCLE_STEP_BLOCKED_ON_DB_WRITE
+
+	case routing.LifecycleStepPausedByBackpressure:
+		return PaymentLifecycleStep_LIFECYCLE_STEP_PAUSED_BY_BACKPRESSURE
+
+	default:
+		return PaymentLifecycleStep_LIFECYCLE_STEP_INITIALIZING
+	}
+}
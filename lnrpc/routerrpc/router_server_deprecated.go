@@ -46,6 +46,16 @@ func (i *legacyTrackPaymentServer) Send(p *lnrpc.Payment) error {
 		case lnrpc.PaymentFailureReason_FAILURE_REASON_INSUFFICIENT_BALANCE:
 			state = PaymentState_FAILED_INSUFFICIENT_BALANCE
 
+		// The deprecated payment states have no canceled state, so we
+		// report a canceled payment as failed with an error.
+		case lnrpc.PaymentFailureReason_FAILURE_REASON_CANCELED:
+			state = PaymentState_FAILED_ERROR
+
+		// The same goes for payments failed with a legacy reason that
+		// isn't known.
+		case lnrpc.PaymentFailureReason_FAILURE_REASON_LEGACY_UNKNOWN:
+			state = PaymentState_FAILED_ERROR
+
 		default:
 			return fmt.Errorf("unknown failure reason %v",
 				p.FailureReason)
@@ -1,19 +1,24 @@
 package routerrpc
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/lightningnetwork/lnd/channeldb"
 	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lntypes"
 	"github.com/lightningnetwork/lnd/lnwire"
 	"github.com/lightningnetwork/lnd/queue"
 	"github.com/lightningnetwork/lnd/routing"
 	"github.com/lightningnetwork/lnd/zpay32"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type streamMock struct {
@@ -71,6 +76,14 @@ func (t *controlTowerMock) SubscribeAllPayments() (
 	}, nil
 }
 
+func (t *controlTowerMock) SubscribePayment(lntypes.Hash) (
+	routing.ControlTowerSubscriber, error) {
+
+	return &controlTowerSubscriberMock{
+		updates: t.queue.ChanOut(),
+	}, nil
+}
+
 // TestTrackPaymentsReturnsOnCancelContext tests whether TrackPayments returns
 // when the stream context is cancelled.
 func TestTrackPaymentsReturnsOnCancelContext(t *testing.T) {
@@ -127,13 +140,17 @@ func TestTrackPaymentsInflightUpdates(t *testing.T) {
 	}()
 
 	// Enqueue some payment updates on the mock.
-	towerMock.queue.ChanIn() <- &channeldb.MPPayment{
-		Info:   &channeldb.PaymentCreationInfo{},
-		Status: channeldb.StatusInFlight,
+	towerMock.queue.ChanIn() <- &routing.PaymentUpdateEvent{
+		Payment: &channeldb.MPPayment{
+			Info:   &channeldb.PaymentCreationInfo{},
+			Status: channeldb.StatusInFlight,
+		},
 	}
-	towerMock.queue.ChanIn() <- &channeldb.MPPayment{
-		Info:   &channeldb.PaymentCreationInfo{},
-		Status: channeldb.StatusSucceeded,
+	towerMock.queue.ChanIn() <- &routing.PaymentUpdateEvent{
+		Payment: &channeldb.MPPayment{
+			Info:   &channeldb.PaymentCreationInfo{},
+			Status: channeldb.StatusSucceeded,
+		},
 	}
 
 	// Wait until there's 2 updates or the deadline is exceeded.
@@ -189,13 +206,17 @@ func TestTrackPaymentsNoInflightUpdates(t *testing.T) {
 	}()
 
 	// Enqueue some payment updates on the mock.
-	towerMock.queue.ChanIn() <- &channeldb.MPPayment{
-		Info:   &channeldb.PaymentCreationInfo{},
-		Status: channeldb.StatusInFlight,
+	towerMock.queue.ChanIn() <- &routing.PaymentUpdateEvent{
+		Payment: &channeldb.MPPayment{
+			Info:   &channeldb.PaymentCreationInfo{},
+			Status: channeldb.StatusInFlight,
+		},
 	}
-	towerMock.queue.ChanIn() <- &channeldb.MPPayment{
-		Info:   &channeldb.PaymentCreationInfo{},
-		Status: channeldb.StatusSucceeded,
+	towerMock.queue.ChanIn() <- &routing.PaymentUpdateEvent{
+		Payment: &channeldb.MPPayment{
+			Info:   &channeldb.PaymentCreationInfo{},
+			Status: channeldb.StatusSucceeded,
+		},
 	}
 
 	// Wait until there's 1 update or the deadline is exceeded.
@@ -218,6 +239,288 @@ func TestTrackPaymentsNoInflightUpdates(t *testing.T) {
 	require.Equal(t, lnrpc.Payment_SUCCEEDED, payment.Status)
 }
 
+// TestTrackPaymentsDeletedUpdate tests that the deletion of a payment is
+// propagated to the client with the deleted status, even if in-flight updates
+// are skipped.
+func TestTrackPaymentsDeletedUpdate(t *testing.T) {
+	// Setup mocks and request.
+	request := &TrackPaymentsRequest{
+		NoInflightUpdates: true,
+	}
+	towerMock := makeControlTowerMock()
+
+	streamCtx, cancelStream := context.WithCancel(context.Background())
+	stream := makeStreamMock(streamCtx)
+	defer cancelStream()
+
+	server := &Server{
+		cfg: &Config{
+			RouterBackend: &RouterBackend{
+				Tower: towerMock,
+			},
+		},
+	}
+
+	// Listen to payment updates in a goroutine.
+	go func() {
+		err := server.TrackPayments(request, stream)
+		require.Equal(t, context.Canceled, err)
+	}()
+
+	// Enqueue a deletion event on the mock.
+	paymentHash := lntypes.Hash{1, 2, 3}
+	towerMock.queue.ChanIn() <- &routing.PaymentDeletedEvent{
+		PaymentIdentifier: paymentHash,
+	}
+
+	// The client should be notified about the deletion.
+	select {
+	case payment := <-stream.sentFromServer:
+		require.Equal(t, lnrpc.Payment_DELETED, payment.Status)
+		require.Equal(t, paymentHash.String(), payment.PaymentHash)
+
+	case <-time.After(time.Second):
+		require.FailNow(t, "deadline exceeded.")
+	}
+}
+
+// TestTrackPaymentV2AttemptEvents tests that the attempt transition causing a
+// payment update is only sent to the client if requested.
+func TestTrackPaymentV2AttemptEvents(t *testing.T) {
+	t.Parallel()
+
+	for _, include := range []bool{false, true} {
+		include := include
+
+		t.Run(fmt.Sprintf("include=%v", include), func(t *testing.T) {
+			t.Parallel()
+
+			testTrackPaymentV2AttemptEvents(t, include)
+		})
+	}
+}
+
+func testTrackPaymentV2AttemptEvents(t *testing.T, include bool) {
+	paymentHash := lntypes.Hash{1}
+	request := &TrackPaymentRequest{
+		PaymentHash:          paymentHash[:],
+		IncludeAttemptEvents: include,
+	}
+	towerMock := makeControlTowerMock()
+
+	streamCtx, cancelStream := context.WithCancel(context.Background())
+	stream := makeStreamMock(streamCtx)
+	defer cancelStream()
+
+	server := &Server{
+		cfg: &Config{
+			RouterBackend: &RouterBackend{
+				Tower: towerMock,
+			},
+		},
+	}
+
+	// Listen to payment updates in a goroutine.
+	go func() {
+		err := server.TrackPaymentV2(request, stream)
+		require.NoError(t, err)
+	}()
+
+	// Enqueue the current state of the payment, followed by an update
+	// caused by a settled attempt.
+	attemptID := uint64(3)
+	towerMock.queue.ChanIn() <- &routing.PaymentUpdateEvent{
+		Payment: &channeldb.MPPayment{
+			Info:   &channeldb.PaymentCreationInfo{},
+			Status: channeldb.StatusInFlight,
+		},
+	}
+	towerMock.queue.ChanIn() <- &routing.PaymentUpdateEvent{
+		Payment: &channeldb.MPPayment{
+			Info:   &channeldb.PaymentCreationInfo{},
+			Status: channeldb.StatusSucceeded,
+		},
+		AttemptID:    &attemptID,
+		AttemptEvent: channeldb.AttemptEventSettled,
+	}
+
+	receive := func() *lnrpc.Payment {
+		select {
+		case payment := <-stream.sentFromServer:
+			return payment
+
+		case <-time.After(time.Second):
+			require.FailNow(t, "deadline exceeded.")
+			return nil
+		}
+	}
+
+	// The current state isn't caused by an attempt.
+	payment := receive()
+	require.Equal(t, lnrpc.Payment_IN_FLIGHT, payment.Status)
+	require.Nil(t, payment.AttemptEvent)
+
+	payment = receive()
+	require.Equal(t, lnrpc.Payment_SUCCEEDED, payment.Status)
+	if !include {
+		require.Nil(t, payment.AttemptEvent)
+		return
+	}
+
+	require.Equal(t, &lnrpc.PaymentAttemptEvent{
+		AttemptId:  attemptID,
+		Transition: lnrpc.PaymentAttemptEvent_SETTLED,
+	}, payment.AttemptEvent)
+}
+
+// TestTrackPaymentBySeqNum tests that only updates of the payment record with
+// the requested sequence number are sent to the client.
+func TestTrackPaymentBySeqNum(t *testing.T) {
+	t.Parallel()
+
+	const seqNum = 2
+	request := &TrackPaymentBySeqNumRequest{
+		SequenceNumber: seqNum,
+	}
+	towerMock := makeControlTowerMock()
+
+	streamCtx, cancelStream := context.WithCancel(context.Background())
+	stream := makeStreamMock(streamCtx)
+	defer cancelStream()
+
+	makePayment := func(seq uint64,
+		status channeldb.PaymentStatus) *channeldb.MPPayment {
+
+		return &channeldb.MPPayment{
+			SequenceNum: seq,
+			Info:        &channeldb.PaymentCreationInfo{},
+			Status:      status,
+		}
+	}
+
+	fetchPayment := func(_ context.Context,
+		seq uint64) (*channeldb.MPPayment, error) {
+
+		if seq != seqNum {
+			return nil, channeldb.ErrPaymentNotInitiated
+		}
+
+		return makePayment(seq, channeldb.StatusInFlight), nil
+	}
+
+	server := &Server{
+		cfg: &Config{
+			RouterBackend: &RouterBackend{
+				Tower:                towerMock,
+				FetchPaymentBySeqNum: fetchPayment,
+			},
+		},
+	}
+
+	// An unknown sequence number is reported as not found.
+	err := server.TrackPaymentBySeqNum(
+		&TrackPaymentBySeqNumRequest{SequenceNumber: 1}, stream,
+	)
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// Listen to payment updates in a goroutine.
+	go func() {
+		err := server.TrackPaymentBySeqNum(request, stream)
+		require.NoError(t, err)
+	}()
+
+	// Enqueue updates of another payment record with the same hash in
+	// between the updates of the tracked one.
+	towerMock.queue.ChanIn() <- &routing.PaymentUpdateEvent{
+		Payment: makePayment(seqNum, channeldb.StatusInFlight),
+	}
+	towerMock.queue.ChanIn() <- &routing.PaymentUpdateEvent{
+		Payment: makePayment(seqNum+1, channeldb.StatusSucceeded),
+	}
+	towerMock.queue.ChanIn() <- &routing.PaymentUpdateEvent{
+		Payment: makePayment(seqNum, channeldb.StatusFailed),
+	}
+
+	receive := func() *lnrpc.Payment {
+		select {
+		case payment := <-stream.sentFromServer:
+			return payment
+
+		case <-time.After(time.Second):
+			require.FailNow(t, "deadline exceeded.")
+			return nil
+		}
+	}
+
+	payment := receive()
+	require.EqualValues(t, seqNum, payment.PaymentIndex)
+	require.Equal(t, lnrpc.Payment_IN_FLIGHT, payment.Status)
+
+	payment = receive()
+	require.EqualValues(t, seqNum, payment.PaymentIndex)
+	require.Equal(t, lnrpc.Payment_FAILED, payment.Status)
+}
+
+// TestLookupPaymentByReference tests that a payment can be looked up by its
+// client reference.
+func TestLookupPaymentByReference(t *testing.T) {
+	t.Parallel()
+
+	ref := []byte{1, 2, 3}
+
+	lookupPayment := func(_ context.Context,
+		r []byte) (*channeldb.MPPayment, error) {
+
+		if !bytes.Equal(r, ref) {
+			return nil, channeldb.ErrPaymentNotInitiated
+		}
+
+		return &channeldb.MPPayment{
+			SequenceNum: 1,
+			Info: &channeldb.PaymentCreationInfo{
+				ClientReference: ref,
+			},
+			Status: channeldb.StatusSucceeded,
+		}, nil
+	}
+
+	server := &Server{
+		cfg: &Config{
+			RouterBackend: &RouterBackend{
+				LookupPaymentByReference: lookupPayment,
+			},
+		},
+	}
+	ctx := context.Background()
+
+	// Empty and oversized references are rejected.
+	tooLong := make([]byte, channeldb.MaxClientReferenceLength+1)
+	for _, r := range [][]byte{nil, tooLong} {
+		_, err := server.LookupPaymentByReference(
+			ctx, &LookupPaymentByReferenceRequest{
+				ClientReference: r,
+			},
+		)
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+
+	// An unknown reference is reported as not found.
+	_, err := server.LookupPaymentByReference(
+		ctx, &LookupPaymentByReferenceRequest{
+			ClientReference: []byte{4},
+		},
+	)
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	payment, err := server.LookupPaymentByReference(
+		ctx, &LookupPaymentByReferenceRequest{ClientReference: ref},
+	)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, payment.PaymentIndex)
+	require.Equal(t, ref, payment.ClientReference)
+	require.Equal(t, lnrpc.Payment_SUCCEEDED, payment.Status)
+}
+
 // TestIsLsp tests the isLSP heuristic. Combinations of different route hints
 // with different fees and cltv deltas are tested to ensure that the heuristic
 // correctly identifies whether a route leads to an LSP or not.
@@ -0,0 +1,30 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/lightningnetwork/lnd/lnrpc/devrpc"
+	"github.com/lightningnetwork/lnd/lntest/wait"
+)
+
+// =====================
+// DevClient related RPCs.
+// =====================
+
+// PopulatePayments makes a PopulatePayments RPC call to the node's devrpc
+// client and asserts.
+func (h *HarnessRPC) PopulatePayments(
+	req *devrpc.PopulatePaymentsRequest) *devrpc.PopulatePaymentsResponse {
+
+	// Writing a large payment history can take a while, so we allow more
+	// time than for other calls.
+	ctxt, cancel := context.WithTimeout(
+		h.runCtx, wait.AsyncBenchmarkTimeout,
+	)
+	defer cancel()
+
+	resp, err := h.Dev.PopulatePayments(ctxt, req)
+	h.NoError(err, "PopulatePayments")
+
+	return resp
+}
@@ -91,6 +91,14 @@ type TrackPaymentClient routerrpc.Router_TrackPaymentV2Client
 func (h *HarnessRPC) TrackPaymentV2(payHash []byte) TrackPaymentClient {
 	req := &routerrpc.TrackPaymentRequest{PaymentHash: payHash}
 
+	return h.TrackPaymentV2WithRequest(req)
+}
+
+// TrackPaymentV2WithRequest creates a subscription client for the given track
+// payment request and asserts its creation.
+func (h *HarnessRPC) TrackPaymentV2WithRequest(
+	req *routerrpc.TrackPaymentRequest) TrackPaymentClient {
+
 	// TrackPaymentV2 needs to have the context alive for the entire test
 	// case as the returned client will be used for send and receive events
 	// stream. Thus we use runCtx here instead of a timeout context.
@@ -75,6 +75,19 @@ func (h *HarnessRPC) DeleteAllPayments() {
 	h.NoError(err, "DeleteAllPayments")
 }
 
+// DeletePayment makes a RPC call to the node's DeletePayment and asserts.
+func (h *HarnessRPC) DeletePayment(
+	req *lnrpc.DeletePaymentRequest) *lnrpc.DeletePaymentResponse {
+
+	ctxt, cancel := context.WithTimeout(h.runCtx, DefaultTimeout)
+	defer cancel()
+
+	resp, err := h.LN.DeletePayment(ctxt, req)
+	h.NoError(err, "DeletePayment")
+
+	return resp
+}
+
 // GetInfo calls the GetInfo RPC on a given node and asserts there's no error.
 func (h *HarnessRPC) GetInfo() *lnrpc.GetInfoResponse {
 	ctxt, cancel := context.WithTimeout(h.runCtx, DefaultTimeout)
@@ -189,6 +202,20 @@ func (h *HarnessRPC) ListPayments(
 	return resp
 }
 
+// CountPayments counts the node's payments per status using the request and
+// asserts.
+func (h *HarnessRPC) CountPayments(
+	req *lnrpc.CountPaymentsRequest) *lnrpc.CountPaymentsResponse {
+
+	ctxt, cancel := context.WithTimeout(h.runCtx, DefaultTimeout)
+	defer cancel()
+
+	resp, err := h.LN.CountPayments(ctxt, req)
+	h.NoError(err, "CountPayments")
+
+	return resp
+}
+
 // ListInvoices list the node's invoice using the request and asserts.
 func (h *HarnessRPC) ListInvoices(
 	req *lnrpc.ListInvoiceRequest) *lnrpc.ListInvoiceResponse {
@@ -204,3 +204,51 @@ func (h *HarnessRPC) TrackPayments(
 
 	return resp
 }
+
+// TrackPaymentBySeqNum creates a subscription client for the payment with the
+// given sequence number. The returned client can be consumed like the one of
+// TrackPaymentV2.
+func (h *HarnessRPC) TrackPaymentBySeqNum(
+	req *routerrpc.TrackPaymentBySeqNumRequest) TrackPaymentClient {
+
+	// The stream needs to have the context alive for the entire test case
+	// as the returned client will be used for send and receive events.
+	client, err := h.Router.TrackPaymentBySeqNum(h.runCtx, req)
+	h.NoError(err, "TrackPaymentBySeqNum")
+
+	return client
+}
+
+// GetPaymentLifecycleState makes a RPC call to the node's RouterClient and
+// asserts.
+func (h *HarnessRPC) GetPaymentLifecycleState(
+	payHash []byte) *routerrpc.GetPaymentLifecycleStateResponse {
+
+	ctxt, cancel := context.WithTimeout(h.runCtx, DefaultTimeout)
+	defer cancel()
+
+	req := &routerrpc.GetPaymentLifecycleStateRequest{
+		PaymentHash: payHash,
+	}
+	resp, err := h.Router.GetPaymentLifecycleState(ctxt, req)
+	h.NoError(err, "GetPaymentLifecycleState")
+
+	return resp
+}
+
+// ReconcileStaleAttempts makes a RPC call to the node's RouterClient and
+// asserts.
+func (h *HarnessRPC) ReconcileStaleAttempts(
+	failStale bool) *routerrpc.ReconcileStaleAttemptsResponse {
+
+	ctxt, cancel := context.WithTimeout(h.runCtx, DefaultTimeout)
+	defer cancel()
+
+	req := &routerrpc.ReconcileStaleAttemptsRequest{
+		FailStale: failStale,
+	}
+	resp, err := h.Router.ReconcileStaleAttempts(ctxt, req)
+	h.NoError(err, "ReconcileStaleAttempts")
+
+	return resp
+}
@@ -1,6 +1,7 @@
 package routing
 
 import (
+	"context"
 	"sync"
 
 	"github.com/lightningnetwork/lnd/channeldb"
@@ -9,6 +10,10 @@ import (
 	"github.com/lightningnetwork/lnd/queue"
 )
 
+// inFlightPaymentsPageSize is the number of in-flight payments that are read
+// per transaction when iterating over them.
+const inFlightPaymentsPageSize = 100
+
 // dbMPPayment is an interface derived from channeldb.MPPayment that is used by
 // the payment lifecycle.
 type dbMPPayment interface {
@@ -74,20 +79,63 @@ type ControlTower interface {
 	FailAttempt(lntypes.Hash, uint64, *channeldb.HTLCFailInfo) (
 		*channeldb.HTLCAttempt, error)
 
+	// AbandonAttempt marks the given payment attempt as abandoned, which
+	// counts as failed but records that the attempt's actual result is
+	// unknown.
+	AbandonAttempt(lntypes.Hash, uint64) (*channeldb.HTLCAttempt, error)
+
 	// FetchPayment fetches the payment corresponding to the given payment
 	// hash.
 	FetchPayment(paymentHash lntypes.Hash) (dbMPPayment, error)
 
+	// FetchPaymentState returns the state and status of the payment
+	// corresponding to the given payment hash without decoding its
+	// attempts.
+	FetchPaymentState(paymentHash lntypes.Hash) (*channeldb.MPPaymentState,
+		channeldb.PaymentStatus, error)
+
 	// FailPayment transitions a payment into the Failed state, and records
 	// the ultimate reason the payment failed. Note that this should only
 	// be called when all active attempts are already failed. After
 	// invoking this method, InitPayment should return nil on its next call
 	// for this payment hash, allowing the user to make a subsequent
-	// payment.
-	FailPayment(lntypes.Hash, channeldb.FailureReason) error
+	// payment. The optional failure detail is stored along with the
+	// reason.
+	FailPayment(lntypes.Hash, channeldb.FailureReason,
+		*channeldb.PaymentFailureDetail) error
+
+	// AbandonPayment forces a payment that can't make progress anymore
+	// into the Failed state by abandoning all of its in-flight attempts.
+	// A payment that has a settled attempt can't be abandoned.
+	AbandonPayment(lntypes.Hash) error
 
 	// FetchInFlightPayments returns all payments with status InFlight.
-	FetchInFlightPayments() ([]*channeldb.MPPayment, error)
+	FetchInFlightPayments(ctx context.Context) ([]*channeldb.MPPayment,
+		error)
+
+	// ForEachInFlightPayment calls the given callback for every payment
+	// with status InFlight. The payments are read page by page, so the
+	// callback is called before all of them have been read.
+	ForEachInFlightPayment(ctx context.Context,
+		cb func(*channeldb.MPPayment) error) error
+
+	// StoreTimings persists the timings of the payment's first attempt.
+	StoreTimings(lntypes.Hash, *channeldb.PaymentTimings) error
+
+	// DeletePayment deletes the payment with the given hash, or only its
+	// failed htlcs if failedHtlcsOnly is set. If the whole payment is
+	// deleted, its subscribers receive a final PaymentDeletedEvent. The
+	// status of the payment before the deletion and the number of removed
+	// htlc attempts are returned.
+	DeletePayment(paymentHash lntypes.Hash,
+		failedHtlcsOnly bool) (*channeldb.DeletedPayment, error)
+
+	// DeletePayments deletes all completed and failed payments, or only
+	// the failed ones if failedOnly is set. If failedHtlcsOnly is set,
+	// only the failed htlcs of the payments are deleted. The subscribers
+	// of every deleted payment receive a final PaymentDeletedEvent. The
+	// number of altered payments and removed htlc attempts are returned.
+	DeletePayments(failedOnly, failedHtlcsOnly bool) (int, int, error)
 
 	// SubscribePayment subscribes to updates for the payment with the given
 	// hash. A first update with the current state of the payment is always
@@ -101,10 +149,33 @@ type ControlTower interface {
 	SubscribeAllPayments() (ControlTowerSubscriber, error)
 }
 
+// PaymentUpdateEvent is sent to the subscribers of a payment with its state
+// after it was initiated or updated, or when subscribing to it.
+type PaymentUpdateEvent struct {
+	// Payment is the payment as of the update.
+	Payment *channeldb.MPPayment
+
+	// AttemptID is the ID of the htlc attempt whose transition caused the
+	// update. It is nil if the update wasn't caused by an attempt, as for
+	// the current state sent out when subscribing.
+	AttemptID *uint64
+
+	// AttemptEvent is the transition of the attempt with AttemptID.
+	AttemptEvent channeldb.AttemptEvent
+}
+
+// PaymentDeletedEvent is sent to the subscribers of a payment when the payment
+// is deleted from the database. It is always the last update for the payment.
+type PaymentDeletedEvent struct {
+	// PaymentIdentifier is the identifier of the deleted payment.
+	PaymentIdentifier lntypes.Hash
+}
+
 // ControlTowerSubscriber contains the state for a payment update subscriber.
 type ControlTowerSubscriber interface {
-	// Updates is the channel over which *channeldb.MPPayment updates can be
-	// received.
+	// Updates is the channel over which *PaymentUpdateEvent updates can be
+	// received. If a payment is deleted, a final *PaymentDeletedEvent is
+	// sent instead.
 	Updates() <-chan interface{}
 
 	// Close signals that the subscriber is no longer interested in updates.
@@ -142,7 +213,7 @@ func (s *controlTowerSubscriberImpl) Close() {
 	s.queue.Stop()
 }
 
-// Updates is the channel over which *channeldb.MPPayment updates can be
+// Updates is the channel over which *PaymentUpdateEvent updates can be
 // received.
 func (s *controlTowerSubscriberImpl) Updates() <-chan interface{} {
 	return s.updates
@@ -151,7 +222,7 @@ func (s *controlTowerSubscriberImpl) Updates() <-chan interface{} {
 // controlTower is persistent implementation of ControlTower to restrict
 // double payment sending.
 type controlTower struct {
-	db *channeldb.PaymentControl
+	db channeldb.PaymentStore
 
 	// subscriberIndex is used to provide a unique id for each subscriber
 	// to all payments. This is used to easily remove the subscriber when
@@ -167,9 +238,11 @@ type controlTower struct {
 	paymentsMtx *multimutex.Mutex[lntypes.Hash]
 }
 
-// NewControlTower creates a new instance of the controlTower.
-func NewControlTower(db *channeldb.PaymentControl) ControlTower {
-	return &controlTower{
+// NewControlTower creates a new instance of the controlTower. The control
+// tower registers itself as the notifier of the given payment store to learn
+// about committed payment updates.
+func NewControlTower(db channeldb.PaymentStore) ControlTower {
+	p := &controlTower{
 		db: db,
 		subscribersAllPayments: make(
 			map[uint64]*controlTowerSubscriberImpl,
@@ -177,6 +250,23 @@ func NewControlTower(db *channeldb.PaymentControl) ControlTower {
 		subscribers: make(map[lntypes.Hash][]*controlTowerSubscriberImpl),
 		paymentsMtx: multimutex.NewMutex[lntypes.Hash](),
 	}
+	db.SetNotifier(p.handlePaymentUpdate)
+
+	return p
+}
+
+// handlePaymentUpdate notifies the subscribers of a payment about an update
+// that was committed to the database.
+//
+// NOTE: This is called by the PaymentControl while the caller of the update
+// holds the payment's lock, so no update is missed or duplicated by a
+// concurrent subscription.
+func (p *controlTower) handlePaymentUpdate(update *channeldb.PaymentUpdate) {
+	p.notifySubscribers(update.PaymentIdentifier, &PaymentUpdateEvent{
+		Payment:      update.Payment,
+		AttemptID:    update.AttemptID,
+		AttemptEvent: update.AttemptEvent,
+	})
 }
 
 // InitPayment checks or records the given PaymentCreationInfo with the DB,
@@ -201,7 +291,9 @@ func (p *controlTower) InitPayment(paymentHash lntypes.Hash,
 		return err
 	}
 
-	p.notifySubscribers(paymentHash, payment)
+	p.notifySubscribers(paymentHash, &PaymentUpdateEvent{
+		Payment: payment,
+	})
 
 	return nil
 }
@@ -220,15 +312,11 @@ func (p *controlTower) RegisterAttempt(paymentHash lntypes.Hash,
 	p.paymentsMtx.Lock(paymentHash)
 	defer p.paymentsMtx.Unlock(paymentHash)
 
-	payment, err := p.db.RegisterAttempt(paymentHash, attempt)
-	if err != nil {
-		return err
-	}
-
-	// Notify subscribers of the attempt registration.
-	p.notifySubscribers(paymentHash, payment)
+	// Subscribers are notified of the attempt registration by the
+	// PaymentControl.
+	_, err := p.db.RegisterAttempt(paymentHash, attempt)
 
-	return nil
+	return err
 }
 
 // SettleAttempt marks the given attempt settled with the preimage. If
@@ -241,15 +329,16 @@ func (p *controlTower) SettleAttempt(paymentHash lntypes.Hash,
 	p.paymentsMtx.Lock(paymentHash)
 	defer p.paymentsMtx.Unlock(paymentHash)
 
-	payment, err := p.db.SettleAttempt(paymentHash, attemptID, settleInfo)
+	// Subscribers are notified of the success event by the
+	// PaymentControl.
+	_, attempt, err := p.db.SettleAttempt(
+		paymentHash, attemptID, settleInfo,
+	)
 	if err != nil {
 		return nil, err
 	}
 
-	// Notify subscribers of success event.
-	p.notifySubscribers(paymentHash, payment)
-
-	return payment.GetAttempt(attemptID)
+	return attempt, nil
 }
 
 // FailAttempt marks the given payment attempt failed.
@@ -260,15 +349,33 @@ func (p *controlTower) FailAttempt(paymentHash lntypes.Hash,
 	p.paymentsMtx.Lock(paymentHash)
 	defer p.paymentsMtx.Unlock(paymentHash)
 
-	payment, err := p.db.FailAttempt(paymentHash, attemptID, failInfo)
+	// Subscribers are notified of the failed attempt by the
+	// PaymentControl.
+	_, attempt, err := p.db.FailAttempt(
+		paymentHash, attemptID, failInfo,
+	)
 	if err != nil {
 		return nil, err
 	}
 
-	// Notify subscribers of failed attempt.
-	p.notifySubscribers(paymentHash, payment)
+	return attempt, nil
+}
+
+// AbandonAttempt marks the given payment attempt as abandoned.
+func (p *controlTower) AbandonAttempt(paymentHash lntypes.Hash,
+	attemptID uint64) (*channeldb.HTLCAttempt, error) {
+
+	p.paymentsMtx.Lock(paymentHash)
+	defer p.paymentsMtx.Unlock(paymentHash)
+
+	// Subscribers are notified of the abandoned attempt by the
+	// PaymentControl.
+	_, attempt, err := p.db.AbandonAttempt(paymentHash, attemptID)
+	if err != nil {
+		return nil, err
+	}
 
-	return payment.GetAttempt(attemptID)
+	return attempt, nil
 }
 
 // FetchPayment fetches the payment corresponding to the given payment hash.
@@ -278,30 +385,116 @@ func (p *controlTower) FetchPayment(paymentHash lntypes.Hash) (
 	return p.db.FetchPayment(paymentHash)
 }
 
+// FetchPaymentState returns the state and status of the payment corresponding
+// to the given payment hash without decoding its attempts.
+func (p *controlTower) FetchPaymentState(paymentHash lntypes.Hash) (
+	*channeldb.MPPaymentState, channeldb.PaymentStatus, error) {
+
+	return p.db.FetchPaymentState(paymentHash)
+}
+
 // FailPayment transitions a payment into the Failed state, and records the
-// reason the payment failed. After invoking this method, InitPayment should
-// return nil on its next call for this payment hash, allowing the switch to
-// make a subsequent payment.
+// reason the payment failed, along with the failure detail if not nil. After
+// invoking this method, InitPayment should return nil on its next call for
+// this payment hash, allowing the switch to make a subsequent payment.
 func (p *controlTower) FailPayment(paymentHash lntypes.Hash,
-	reason channeldb.FailureReason) error {
+	reason channeldb.FailureReason,
+	detail *channeldb.PaymentFailureDetail) error {
 
 	p.paymentsMtx.Lock(paymentHash)
 	defer p.paymentsMtx.Unlock(paymentHash)
 
-	payment, err := p.db.Fail(paymentHash, reason)
+	// Subscribers are notified of the fail event by the PaymentControl.
+	_, err := p.db.FailWithDetail(paymentHash, reason, detail)
+
+	return err
+}
+
+// AbandonPayment forces a payment that can't make progress anymore into the
+// Failed state by abandoning all of its in-flight attempts, which must be
+// truly gone. A payment that already failed keeps its failure reason.
+func (p *controlTower) AbandonPayment(paymentHash lntypes.Hash) error {
+	p.paymentsMtx.Lock(paymentHash)
+	defer p.paymentsMtx.Unlock(paymentHash)
+
+	// Subscribers are notified of the abandoned attempts by the
+	// PaymentControl.
+	return p.db.AbandonPayment(paymentHash)
+}
+
+// FetchInFlightPayments returns all payments with status InFlight.
+func (p *controlTower) FetchInFlightPayments(
+	ctx context.Context) ([]*channeldb.MPPayment, error) {
+
+	return p.db.FetchInFlightPayments(ctx)
+}
+
+// ForEachInFlightPayment calls the given callback for every payment with
+// status InFlight, reading inFlightPaymentsPageSize payments at a time.
+func (p *controlTower) ForEachInFlightPayment(ctx context.Context,
+	cb func(*channeldb.MPPayment) error) error {
+
+	return p.db.ForEachInFlightPayment(ctx, inFlightPaymentsPageSize, cb)
+}
+
+// StoreTimings persists the timings of the payment's first attempt.
+func (p *controlTower) StoreTimings(paymentHash lntypes.Hash,
+	timings *channeldb.PaymentTimings) error {
+
+	return p.db.StoreTimings(paymentHash, timings)
+}
+
+// DeletePayment deletes the payment with the given hash, or only its failed
+// htlcs if failedHtlcsOnly is set. If the whole payment is deleted, all of its
+// subscribers are notified with a final PaymentDeletedEvent.
+func (p *controlTower) DeletePayment(paymentHash lntypes.Hash,
+	failedHtlcsOnly bool) (*channeldb.DeletedPayment, error) {
+
+	p.paymentsMtx.Lock(paymentHash)
+	defer p.paymentsMtx.Unlock(paymentHash)
+
+	deleted, err := p.db.DeletePayment(paymentHash, failedHtlcsOnly)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// The payment still exists if only its failed htlcs were deleted.
+	if failedHtlcsOnly {
+		return deleted, nil
 	}
 
-	// Notify subscribers of fail event.
-	p.notifySubscribers(paymentHash, payment)
+	// Notify subscribers of the deletion.
+	p.notifySubscribers(paymentHash, &PaymentDeletedEvent{
+		PaymentIdentifier: paymentHash,
+	})
 
-	return nil
+	return deleted, nil
 }
 
-// FetchInFlightPayments returns all payments with status InFlight.
-func (p *controlTower) FetchInFlightPayments() ([]*channeldb.MPPayment, error) {
-	return p.db.FetchInFlightPayments()
+// DeletePayments deletes all completed and failed payments, or only the failed
+// ones if failedOnly is set. If failedHtlcsOnly is set, only the failed htlcs of
+// the payments are deleted. The subscribers of every deleted payment are
+// notified with a final PaymentDeletedEvent, also if the deletion stopped
+// halfway because of an error.
+func (p *controlTower) DeletePayments(failedOnly,
+	failedHtlcsOnly bool) (int, int, error) {
+
+	hashes, numAttempts, err := p.db.DeletePayments(
+		failedOnly, failedHtlcsOnly,
+	)
+
+	// The payments still exist if only their failed htlcs were deleted.
+	if !failedHtlcsOnly {
+		for _, hash := range hashes {
+			p.paymentsMtx.Lock(hash)
+			p.notifySubscribers(hash, &PaymentDeletedEvent{
+				PaymentIdentifier: hash,
+			})
+			p.paymentsMtx.Unlock(hash)
+		}
+	}
+
+	return len(hashes), numAttempts, err
 }
 
 // SubscribePayment subscribes to updates for the payment with the given hash. A
@@ -323,7 +516,7 @@ func (p *controlTower) SubscribePayment(paymentHash lntypes.Hash) (
 	subscriber := newControlTowerSubscriber()
 
 	// Always write current payment state to the channel.
-	subscriber.queue.ChanIn() <- payment
+	subscriber.queue.ChanIn() <- &PaymentUpdateEvent{Payment: payment}
 
 	// Payment is currently in flight. Register this subscriber for further
 	// updates. Otherwise this update is the final update and the incoming
@@ -362,14 +555,16 @@ func (p *controlTower) SubscribeAllPayments() (ControlTowerSubscriber, error) {
 	p.subscriberIndex++
 	p.subscribersMtx.Unlock()
 
-	inflightPayments, err := p.db.FetchInFlightPayments()
+	inflightPayments, err := p.db.FetchInFlightPayments(context.TODO())
 	if err != nil {
 		return nil, err
 	}
 
 	for index := range inflightPayments {
 		// Always write current payment state to the channel.
-		subscriber.queue.ChanIn() <- inflightPayments[index]
+		subscriber.queue.ChanIn() <- &PaymentUpdateEvent{
+			Payment: inflightPayments[index],
+		}
 	}
 
 	return subscriber, nil
@@ -380,7 +575,7 @@ func (p *controlTower) SubscribeAllPayments() (ControlTowerSubscriber, error) {
 // be executed atomically (by means of a lock) with the database update to
 // guarantee consistency of the notifications.
 func (p *controlTower) notifySubscribers(paymentHash lntypes.Hash,
-	event *channeldb.MPPayment) {
+	event interface{}) {
 
 	// Get all subscribers for this payment.
 	p.subscribersMtx.Lock()
@@ -391,9 +586,12 @@ func (p *controlTower) notifySubscribers(paymentHash lntypes.Hash,
 		return
 	}
 
-	// If the payment reached a terminal state, the subscriber list can be
-	// cleared. There won't be any more updates.
-	terminal := event.Terminated()
+	// If the payment reached a terminal state or was deleted, the
+	// subscriber list can be cleared. There won't be any more updates.
+	terminal := true
+	if update, ok := event.(*PaymentUpdateEvent); ok {
+		terminal = update.Payment.Terminated()
+	}
 	if terminal {
 		delete(p.subscribers, paymentHash)
 	}
@@ -124,7 +124,7 @@ func TestControlTowerSubscribeSuccess(t *testing.T) {
 		for result == nil || !result.Terminated() {
 			select {
 			case item := <-s.Updates():
-				result = item.(*channeldb.MPPayment)
+				result = item.(*PaymentUpdateEvent).Payment
 			case <-time.After(testTimeout):
 				t.Fatal("timeout waiting for payment result")
 			}
@@ -160,6 +160,125 @@ func TestControlTowerSubscribeSuccess(t *testing.T) {
 	}
 }
 
+// TestControlTowerSubscribeAttemptEvents tests that every update caused by an
+// htlc attempt carries the attempt's ID and transition.
+func TestControlTowerSubscribeAttemptEvents(t *testing.T) {
+	t.Parallel()
+
+	db, err := initDB(t, true)
+	require.NoError(t, err, "unable to init db")
+
+	pControl := NewControlTower(channeldb.NewPaymentControl(db))
+
+	info, attempt, preimg, err := genInfo()
+	require.NoError(t, err)
+
+	hash := info.PaymentIdentifier
+	require.NoError(t, pControl.InitPayment(hash, info))
+
+	subscriber, err := pControl.SubscribePayment(hash)
+	require.NoError(t, err)
+
+	// Fail a first attempt and settle a second one.
+	require.NoError(t, pControl.RegisterAttempt(hash, attempt))
+
+	_, err = pControl.FailAttempt(
+		hash, attempt.AttemptID, &channeldb.HTLCFailInfo{
+			Reason: channeldb.HTLCFailUnreadable,
+		},
+	)
+	require.NoError(t, err)
+
+	retry := *attempt
+	retry.AttemptID = attempt.AttemptID + 1
+	require.NoError(t, pControl.RegisterAttempt(hash, &retry))
+
+	_, err = pControl.SettleAttempt(
+		hash, retry.AttemptID,
+		&channeldb.HTLCSettleInfo{Preimage: preimg},
+	)
+	require.NoError(t, err)
+
+	type attemptEvent struct {
+		id    *uint64
+		event channeldb.AttemptEvent
+	}
+
+	first, second := attempt.AttemptID, retry.AttemptID
+	expected := []attemptEvent{
+		// The current state sent out when subscribing isn't caused by
+		// an attempt.
+		{nil, channeldb.AttemptEventNone},
+		{&first, channeldb.AttemptEventRegistered},
+		{&first, channeldb.AttemptEventFailed},
+		{&second, channeldb.AttemptEventRegistered},
+		{&second, channeldb.AttemptEventSettled},
+	}
+
+	var events []attemptEvent
+	for item := range subscriber.Updates() {
+		update := item.(*PaymentUpdateEvent)
+		events = append(events, attemptEvent{
+			id:    update.AttemptID,
+			event: update.AttemptEvent,
+		})
+	}
+	require.Equal(t, expected, events)
+}
+
+// TestControlTowerFailPaymentIdempotent checks that the control tower and its
+// mock agree on failing an already failed payment, and that the router keeps
+// the first failure reason of a payment.
+func TestControlTowerFailPaymentIdempotent(t *testing.T) {
+	t.Parallel()
+
+	db, err := initDB(t, false)
+	require.NoError(t, err)
+
+	towers := map[string]ControlTower{
+		"db":   NewControlTower(channeldb.NewPaymentControl(db)),
+		"mock": makeMockControlTower(),
+	}
+
+	for name, tower := range towers {
+		tower := tower
+
+		t.Run(name, func(t *testing.T) {
+			info, _, _, err := genInfo()
+			require.NoError(t, err)
+
+			hash := info.PaymentIdentifier
+			require.NoError(t, tower.InitPayment(hash, info))
+
+			timeout := channeldb.FailureReasonTimeout
+			err = tower.FailPayment(hash, timeout, nil)
+			require.NoError(t, err)
+
+			// Failing the payment again with the same reason is a
+			// no-op, while another reason is refused.
+			err = tower.FailPayment(hash, timeout, nil)
+			require.NoError(t, err)
+
+			noRoute := channeldb.FailureReasonNoRoute
+			err = tower.FailPayment(hash, noRoute, nil)
+			require.ErrorIs(
+				t, err, channeldb.ErrPaymentAlreadyFailed,
+			)
+
+			// The router doesn't treat the refused reason as an
+			// error.
+			router := &ChannelRouter{cfg: &Config{Control: tower}}
+			err = router.failPayment(hash, noRoute, nil)
+			require.NoError(t, err)
+
+			payment, err := tower.FetchPayment(hash)
+			require.NoError(t, err)
+			_, reason := payment.TerminalInfo()
+			require.Equal(t, timeout, *reason)
+		})
+	}
+}
+
 // TestPaymentControlSubscribeFail tests that payment updates for a
 // failed payment are properly sent to subscribers.
 func TestPaymentControlSubscribeFail(t *testing.T) {
@@ -250,8 +369,8 @@ func TestPaymentControlSubscribeAllSuccess(t *testing.T) {
 	for i := 0; i < 6; i++ {
 		select {
 		case item := <-subscription.Updates():
-			id := item.(*channeldb.MPPayment).Info.PaymentIdentifier
-			results[id] = item.(*channeldb.MPPayment)
+			payment := item.(*PaymentUpdateEvent).Payment
+			results[payment.Info.PaymentIdentifier] = payment
 		case <-time.After(testTimeout):
 			require.Fail(t, "timeout waiting for payment result")
 		}
@@ -321,9 +440,10 @@ func TestPaymentControlSubscribeAllImmediate(t *testing.T) {
 	select {
 	case update := <-subscription.Updates():
 		require.NotNil(t, update)
+		payment := update.(*PaymentUpdateEvent).Payment
 		require.Equal(
 			t, info.PaymentIdentifier,
-			update.(*channeldb.MPPayment).Info.PaymentIdentifier,
+			payment.Info.PaymentIdentifier,
 		)
 		require.Len(t, subscription.Updates(), 0)
 	case <-time.After(testTimeout):
@@ -331,6 +451,214 @@ func TestPaymentControlSubscribeAllImmediate(t *testing.T) {
 	}
 }
 
+// TestPaymentControlDeletePayment tests that subscribers are notified when a
+// payment is deleted, and that the payment can't be subscribed to afterwards.
+func TestPaymentControlDeletePayment(t *testing.T) {
+	t.Parallel()
+
+	db, err := initDB(t, true)
+	require.NoError(t, err, "unable to init db")
+
+	pControl := NewControlTower(channeldb.NewPaymentControl(db))
+
+	// Initiate a payment and register an attempt.
+	info, attempt, _, err := genInfo()
+	require.NoError(t, err)
+
+	err = pControl.InitPayment(info.PaymentIdentifier, info)
+	require.NoError(t, err)
+
+	err = pControl.RegisterAttempt(info.PaymentIdentifier, attempt)
+	require.NoError(t, err)
+
+	subscription, err := pControl.SubscribeAllPayments()
+	require.NoError(t, err)
+
+	// An in-flight payment can't be deleted, so no deletion event is
+	// sent.
+	_, err = pControl.DeletePayment(info.PaymentIdentifier, false)
+	require.ErrorIs(t, err, channeldb.ErrPaymentInFlight)
+
+	// Fail the attempt and the payment.
+	failInfo := channeldb.HTLCFailInfo{
+		Reason: channeldb.HTLCFailInternal,
+	}
+	_, err = pControl.FailAttempt(
+		info.PaymentIdentifier, attempt.AttemptID, &failInfo,
+	)
+	require.NoError(t, err)
+
+	err = pControl.FailPayment(
+		info.PaymentIdentifier, channeldb.FailureReasonNoRoute, nil,
+	)
+	require.NoError(t, err)
+
+	// Deleting only the failed htlcs keeps the payment around, so no
+	// deletion event is sent either.
+	deleted, err := pControl.DeletePayment(info.PaymentIdentifier, true)
+	require.NoError(t, err)
+	require.Equal(t, channeldb.StatusFailed, deleted.Status)
+	require.Equal(t, 1, deleted.NumAttempts)
+
+	// Now delete the whole payment, which has no attempts left.
+	deleted, err = pControl.DeletePayment(info.PaymentIdentifier, false)
+	require.NoError(t, err)
+	require.Equal(t, channeldb.StatusFailed, deleted.Status)
+	require.Zero(t, deleted.NumAttempts)
+
+	// The subscriber should receive the in-flight update, the two failure
+	// updates and finally the deletion event.
+	for i := 0; i < 3; i++ {
+		select {
+		case update := <-subscription.Updates():
+			require.IsType(t, &PaymentUpdateEvent{}, update)
+
+		case <-time.After(testTimeout):
+			require.Fail(t, "timeout waiting for payment update")
+		}
+	}
+
+	select {
+	case update := <-subscription.Updates():
+		require.Equal(t, &PaymentDeletedEvent{
+			PaymentIdentifier: info.PaymentIdentifier,
+		}, update)
+
+	case <-time.After(testTimeout):
+		require.Fail(t, "timeout waiting for deletion event")
+	}
+
+	// Subscribing to the deleted payment should fail.
+	_, err = pControl.SubscribePayment(info.PaymentIdentifier)
+	require.ErrorIs(t, err, channeldb.ErrPaymentNotInitiated)
+}
+
+// TestPaymentControlAbandonPayment tests that subscribers are notified when a
+// payment is abandoned.
+func TestPaymentControlAbandonPayment(t *testing.T) {
+	t.Parallel()
+
+	db, err := initDB(t, true)
+	require.NoError(t, err, "unable to init db")
+
+	pControl := NewControlTower(channeldb.NewPaymentControl(db))
+
+	info, attempt, _, err := genInfo()
+	require.NoError(t, err)
+
+	err = pControl.InitPayment(info.PaymentIdentifier, info)
+	require.NoError(t, err)
+
+	err = pControl.RegisterAttempt(info.PaymentIdentifier, attempt)
+	require.NoError(t, err)
+
+	subscription, err := pControl.SubscribePayment(info.PaymentIdentifier)
+	require.NoError(t, err)
+
+	err = pControl.AbandonPayment(info.PaymentIdentifier)
+	require.NoError(t, err)
+
+	// The subscriber receives the in-flight state and then the failed
+	// payment with its abandoned attempt.
+	var payment *channeldb.MPPayment
+	for i := 0; i < 2; i++ {
+		select {
+		case update := <-subscription.Updates():
+			require.IsType(t, &PaymentUpdateEvent{}, update)
+			payment = update.(*PaymentUpdateEvent).Payment
+
+		case <-time.After(testTimeout):
+			require.Fail(t, "timeout waiting for payment update")
+		}
+	}
+
+	require.Equal(t, channeldb.StatusFailed, payment.Status)
+	require.Equal(
+		t, channeldb.FailureReasonCanceled, *payment.FailureReason,
+	)
+	require.Equal(
+		t, channeldb.HTLCFailAbandoned, payment.HTLCs[0].Failure.Reason,
+	)
+}
+
+// TestPaymentControlDeletePayments tests that subscribers are notified of every
+// payment removed by a bulk deletion, and that deleting only the failed
+// htlcs doesn't send a deletion event.
+func TestPaymentControlDeletePayments(t *testing.T) {
+	t.Parallel()
+
+	db, err := initDB(t, true)
+	require.NoError(t, err, "unable to init db")
+
+	pControl := NewControlTower(channeldb.NewPaymentControl(db))
+
+	// Create two failed payments with one failed attempt each.
+	var hashes []lntypes.Hash
+	for i := 0; i < 2; i++ {
+		info, attempt, _, err := genInfo()
+		require.NoError(t, err)
+
+		err = pControl.InitPayment(info.PaymentIdentifier, info)
+		require.NoError(t, err)
+
+		err = pControl.RegisterAttempt(info.PaymentIdentifier, attempt)
+		require.NoError(t, err)
+
+		_, err = pControl.FailAttempt(
+			info.PaymentIdentifier, attempt.AttemptID,
+			&channeldb.HTLCFailInfo{
+				Reason: channeldb.HTLCFailInternal,
+			},
+		)
+		require.NoError(t, err)
+
+		err = pControl.FailPayment(
+			info.PaymentIdentifier, channeldb.FailureReasonNoRoute,
+			nil,
+		)
+		require.NoError(t, err)
+
+		hashes = append(hashes, info.PaymentIdentifier)
+	}
+
+	// Subscribe to all payments. The payments aren't in flight anymore,
+	// so no initial updates are sent.
+	subscription, err := pControl.SubscribeAllPayments()
+	require.NoError(t, err)
+
+	// Deleting only the failed htlcs keeps the payments around, so no
+	// deletion event is sent.
+	numAltered, numAttempts, err := pControl.DeletePayments(true, true)
+	require.NoError(t, err)
+	require.Equal(t, 2, numAltered)
+	require.Equal(t, 2, numAttempts)
+
+	require.Len(t, subscription.Updates(), 0)
+
+	// Deleting the failed payments notifies every subscriber.
+	numAltered, numAttempts, err = pControl.DeletePayments(true, false)
+	require.NoError(t, err)
+	require.Equal(t, 2, numAltered)
+	require.Zero(t, numAttempts)
+
+	var deletedHashes []lntypes.Hash
+	for range hashes {
+		select {
+		case update := <-subscription.Updates():
+			require.IsType(t, &PaymentDeletedEvent{}, update)
+
+			deleted := update.(*PaymentDeletedEvent)
+			deletedHashes = append(
+				deletedHashes, deleted.PaymentIdentifier,
+			)
+
+		case <-time.After(testTimeout):
+			require.Fail(t, "timeout waiting for deletion event")
+		}
+	}
+	require.ElementsMatch(t, hashes, deletedHashes)
+}
+
 // TestPaymentControlUnsubscribeSuccess tests that when unsubscribed, there are
 // no more notifications to that specific subscription.
 func TestPaymentControlUnsubscribeSuccess(t *testing.T) {
@@ -453,7 +781,7 @@ func testPaymentControlSubscribeFail(t *testing.T, registerAttempt,
 
 	// Mark the payment as failed.
 	err = pControl.FailPayment(
-		info.PaymentIdentifier, channeldb.FailureReasonTimeout,
+		info.PaymentIdentifier, channeldb.FailureReasonTimeout, nil,
 	)
 	if err != nil {
 		t.Fatal(err)
@@ -474,7 +802,7 @@ func testPaymentControlSubscribeFail(t *testing.T, registerAttempt,
 		for result == nil || !result.Terminated() {
 			select {
 			case item := <-s.Updates():
-				result = item.(*channeldb.MPPayment)
+				result = item.(*PaymentUpdateEvent).Payment
 			case <-time.After(testTimeout):
 				t.Fatal("timeout waiting for payment result")
 			}
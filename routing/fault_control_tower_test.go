@@ -0,0 +1,468 @@
+package routing
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/htlcswitch"
+	"github.com/lightningnetwork/lnd/lntest/wait"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/lightningnetwork/lnd/record"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// controlTowerFault describes the fault that is injected into the calls of a
+// single ControlTower method.
+type controlTowerFault struct {
+	// failOn is the number of the call, starting at one, that fails with
+	// err without reaching the wrapped control tower. If zero, no call
+	// fails.
+	failOn int
+
+	// err is the error returned by the failing call.
+	err error
+
+	// delay is the time every call is held back before it is executed.
+	delay time.Duration
+
+	// duplicate makes every successful call be delivered a second time.
+	// The result of the duplicate delivery is returned to the caller.
+	duplicate bool
+}
+
+// faultInjectingControlTower is a ControlTower decorator that injects the
+// configured faults into the calls of the wrapped control tower. Without any
+// faults, it behaves exactly like the wrapped control tower.
+type faultInjectingControlTower struct {
+	ControlTower
+
+	mu     sync.Mutex
+	faults map[string]*controlTowerFault
+	calls  map[string]int
+}
+
+// A compile-time check to ensure faultInjectingControlTower implements the
+// ControlTower interface.
+var _ ControlTower = (*faultInjectingControlTower)(nil)
+
+// newFaultInjectingControlTower wraps the given control tower.
+func newFaultInjectingControlTower(
+	ct ControlTower) *faultInjectingControlTower {
+
+	return &faultInjectingControlTower{
+		ControlTower: ct,
+		faults:       make(map[string]*controlTowerFault),
+		calls:        make(map[string]int),
+	}
+}
+
+// setFault sets the fault injected into the calls of the given method. The
+// call counter of the method is reset.
+func (f *faultInjectingControlTower) setFault(method string,
+	fault *controlTowerFault) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.faults[method] = fault
+	f.calls[method] = 0
+}
+
+// numCalls returns the number of times the given method was called.
+func (f *faultInjectingControlTower) numCalls(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.calls[method]
+}
+
+// inject executes the given call of the method, injecting the method's fault.
+func (f *faultInjectingControlTower) inject(method string,
+	call func() error) error {
+
+	f.mu.Lock()
+	f.calls[method]++
+	n := f.calls[method]
+	fault := f.faults[method]
+	f.mu.Unlock()
+
+	if fault == nil {
+		return call()
+	}
+
+	if fault.delay > 0 {
+		time.Sleep(fault.delay)
+	}
+
+	if fault.failOn == n {
+		return fault.err
+	}
+
+	if err := call(); err != nil {
+		return err
+	}
+
+	if fault.duplicate {
+		return call()
+	}
+
+	return nil
+}
+
+// InitPayment calls InitPayment of the wrapped control tower.
+func (f *faultInjectingControlTower) InitPayment(hash lntypes.Hash,
+	info *channeldb.PaymentCreationInfo) error {
+
+	return f.inject("InitPayment", func() error {
+		return f.ControlTower.InitPayment(hash, info)
+	})
+}
+
+// DeleteFailedAttempts calls DeleteFailedAttempts of the wrapped control
+// tower.
+func (f *faultInjectingControlTower) DeleteFailedAttempts(
+	hash lntypes.Hash) error {
+
+	return f.inject("DeleteFailedAttempts", func() error {
+		return f.ControlTower.DeleteFailedAttempts(hash)
+	})
+}
+
+// RegisterAttempt calls RegisterAttempt of the wrapped control tower.
+func (f *faultInjectingControlTower) RegisterAttempt(hash lntypes.Hash,
+	a *channeldb.HTLCAttemptInfo) error {
+
+	return f.inject("RegisterAttempt", func() error {
+		return f.ControlTower.RegisterAttempt(hash, a)
+	})
+}
+
+// SettleAttempt calls SettleAttempt of the wrapped control tower.
+func (f *faultInjectingControlTower) SettleAttempt(hash lntypes.Hash,
+	attemptID uint64, settleInfo *channeldb.HTLCSettleInfo) (
+	*channeldb.HTLCAttempt, error) {
+
+	var attempt *channeldb.HTLCAttempt
+	err := f.inject("SettleAttempt", func() error {
+		var err error
+		attempt, err = f.ControlTower.SettleAttempt(
+			hash, attemptID, settleInfo,
+		)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return attempt, nil
+}
+
+// FailAttempt calls FailAttempt of the wrapped control tower.
+func (f *faultInjectingControlTower) FailAttempt(hash lntypes.Hash,
+	attemptID uint64, failInfo *channeldb.HTLCFailInfo) (
+	*channeldb.HTLCAttempt, error) {
+
+	var attempt *channeldb.HTLCAttempt
+	err := f.inject("FailAttempt", func() error {
+		var err error
+		attempt, err = f.ControlTower.FailAttempt(
+			hash, attemptID, failInfo,
+		)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return attempt, nil
+}
+
+// FetchPayment calls FetchPayment of the wrapped control tower.
+func (f *faultInjectingControlTower) FetchPayment(
+	hash lntypes.Hash) (dbMPPayment, error) {
+
+	var payment dbMPPayment
+	err := f.inject("FetchPayment", func() error {
+		var err error
+		payment, err = f.ControlTower.FetchPayment(hash)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return payment, nil
+}
+
+// FailPayment calls FailPayment of the wrapped control tower.
+func (f *faultInjectingControlTower) FailPayment(hash lntypes.Hash,
+	reason channeldb.FailureReason,
+	detail *channeldb.PaymentFailureDetail) error {
+
+	return f.inject("FailPayment", func() error {
+		return f.ControlTower.FailPayment(hash, reason, detail)
+	})
+}
+
+// genMPPInfo generates the creation info of a payment that is sent in two
+// MPP shards, and the attempt infos of the two shards.
+func genMPPInfo(t *testing.T) (*channeldb.PaymentCreationInfo,
+	[]*channeldb.HTLCAttemptInfo, lntypes.Preimage) {
+
+	info, attemptInfo, preimage, err := genInfo()
+	require.NoError(t, err)
+
+	shardAmt := attemptInfo.Route.ReceiverAmt()
+	info.Value = 2 * shardAmt
+
+	var attempts []*channeldb.HTLCAttemptInfo
+	for i := uint64(1); i <= 2; i++ {
+		attempt := *attemptInfo
+		attempt.AttemptID = i
+
+		// Copy the hops, so the MPP record isn't added to the shared
+		// test hop. The record is only stored for TLV payloads.
+		attempt.Route.Hops = nil
+		for _, hop := range attemptInfo.Route.Hops {
+			hop := *hop
+			hop.LegacyPayload = false
+			attempt.Route.Hops = append(attempt.Route.Hops, &hop)
+		}
+		attempt.Route.FinalHop().MPP = record.NewMPP(
+			info.Value, [32]byte{1},
+		)
+
+		attempts = append(attempts, &attempt)
+	}
+
+	return info, attempts, preimage
+}
+
+// newFaultTestPaymentLifecycle creates a payment lifecycle whose control
+// tower is a fault injecting wrapper around a control tower backed by a real
+// database. A payment with two in-flight attempts is created, so that it
+// stays in flight when one of them is resolved. The first attempt is
+// returned.
+func newFaultTestPaymentLifecycle(t *testing.T) (*paymentLifecycle,
+	*mockers, *faultInjectingControlTower, *channeldb.HTLCAttempt) {
+
+	p, m := newTestPaymentLifecycle(t)
+
+	db, err := initDB(t, true)
+	require.NoError(t, err)
+
+	control := newFaultInjectingControlTower(
+		NewControlTower(channeldb.NewPaymentControl(db)),
+	)
+	p.router.cfg.Control = control
+
+	info, attempts, _ := genMPPInfo(t)
+
+	info.PaymentIdentifier = p.identifier
+	require.NoError(t, control.InitPayment(p.identifier, info))
+	for _, a := range attempts {
+		require.NoError(t, control.RegisterAttempt(p.identifier, a))
+	}
+
+	return p, m, control, &channeldb.HTLCAttempt{
+		HTLCAttemptInfo: *attempts[0],
+	}
+}
+
+// mockAttemptSettled mocks the calls made by collectResult when the switch
+// returns the given preimage for the attempt.
+func mockAttemptSettled(p *paymentLifecycle, m *mockers,
+	attempt *channeldb.HTLCAttempt, preimage lntypes.Preimage) {
+
+	m.shardTracker.On("GetHash",
+		attempt.AttemptID,
+	).Return(p.identifier, nil).Once()
+
+	resultChan := make(chan *htlcswitch.PaymentResult, 1)
+	m.payer.On("GetAttemptResult",
+		attempt.AttemptID, p.identifier, mock.Anything,
+	).Return(resultChan, nil).Once().Run(func(args mock.Arguments) {
+		resultChan <- &htlcswitch.PaymentResult{
+			Preimage: preimage,
+		}
+	})
+
+	m.missionControl.On("ReportPaymentSuccess",
+		attempt.AttemptID, &attempt.Route,
+	).Return(nil).Once()
+
+	m.clock.On("Now").Return(time.Unix(1000, 0))
+}
+
+// TestFaultInjectingControlTowerNoFaults checks that the fault injecting
+// control tower behaves like the wrapped control tower if no faults are
+// configured.
+func TestFaultInjectingControlTowerNoFaults(t *testing.T) {
+	t.Parallel()
+
+	db, err := initDB(t, false)
+	require.NoError(t, err)
+
+	control := newFaultInjectingControlTower(
+		NewControlTower(channeldb.NewPaymentControl(db)),
+	)
+
+	// Send the payment in two shards.
+	info, attempts, preimg := genMPPInfo(t)
+	require.NoError(t, control.InitPayment(info.PaymentIdentifier, info))
+
+	subscriber, err := control.SubscribePayment(info.PaymentIdentifier)
+	require.NoError(t, err)
+
+	for _, a := range attempts {
+		err = control.RegisterAttempt(info.PaymentIdentifier, a)
+		require.NoError(t, err)
+	}
+
+	settleInfo := &channeldb.HTLCSettleInfo{Preimage: preimg}
+	htlc, err := control.SettleAttempt(
+		info.PaymentIdentifier, attempts[0].AttemptID, settleInfo,
+	)
+	require.NoError(t, err)
+	require.Equal(t, preimg, htlc.Settle.Preimage)
+
+	// Settling the first shard again is rejected by the wrapped control
+	// tower.
+	_, err = control.SettleAttempt(
+		info.PaymentIdentifier, attempts[0].AttemptID, settleInfo,
+	)
+	require.ErrorIs(t, err, channeldb.ErrAttemptAlreadySettled)
+
+	_, err = control.SettleAttempt(
+		info.PaymentIdentifier, attempts[1].AttemptID, settleInfo,
+	)
+	require.NoError(t, err)
+
+	// The subscriber receives the updates up to the final state.
+	var result *channeldb.MPPayment
+	for result == nil || !result.Terminated() {
+		select {
+		case item := <-subscriber.Updates():
+			result = item.(*PaymentUpdateEvent).Payment
+
+		case <-time.After(testTimeout):
+			t.Fatal("timeout waiting for payment result")
+		}
+	}
+	require.Equal(t, channeldb.StatusSucceeded, result.GetStatus())
+
+	payment, err := control.FetchPayment(info.PaymentIdentifier)
+	require.NoError(t, err)
+	require.Equal(t, result.GetHTLCs(), payment.GetHTLCs())
+	require.Equal(t, 3, control.numCalls("SettleAttempt"))
+}
+
+// TestCollectResultAttemptAlreadySettled checks that a duplicate delivery of
+// an attempt's settlement returns ErrAttemptAlreadySettled without failing
+// the attempt, keeping the settlement.
+func TestCollectResultAttemptAlreadySettled(t *testing.T) {
+	t.Parallel()
+
+	p, m, control, attempt := newFaultTestPaymentLifecycle(t)
+
+	preimage := lntypes.Preimage{1}
+	mockAttemptSettled(p, m, attempt, preimage)
+
+	// Deliver the settlement twice.
+	control.setFault("SettleAttempt", &controlTowerFault{
+		duplicate: true,
+	})
+
+	result, err := p.collectResult(attempt)
+	require.ErrorIs(t, err, channeldb.ErrAttemptAlreadySettled)
+	require.Nil(t, result)
+
+	// The attempt must not be failed, since we have the preimage.
+	require.Zero(t, control.numCalls("FailAttempt"))
+
+	payment, err := control.FetchPayment(p.identifier)
+	require.NoError(t, err)
+	require.Equal(t, channeldb.StatusInFlight, payment.GetStatus())
+
+	htlcs := payment.GetHTLCs()
+	require.NotNil(t, htlcs[0].Settle)
+	require.Equal(t, preimage, htlcs[0].Settle.Preimage)
+	require.Nil(t, htlcs[0].Failure)
+}
+
+// TestFailAttemptTransientWriteErr checks that a transient error while
+// failing an attempt leaves the attempt in flight, and that it can be failed
+// once the control tower recovers.
+func TestFailAttemptTransientWriteErr(t *testing.T) {
+	t.Parallel()
+
+	p, m, control, attempt := newFaultTestPaymentLifecycle(t)
+
+	m.shardTracker.On("CancelShard", attempt.AttemptID).Return(nil).Twice()
+	m.clock.On("Now").Return(time.Unix(1000, 0))
+
+	// The first write fails.
+	control.setFault("FailAttempt", &controlTowerFault{
+		failOn: 1,
+		err:    errDummy,
+	})
+
+	result, err := p.failAttempt(
+		attempt.AttemptID, htlcswitch.ErrPaymentIDNotFound,
+	)
+	require.ErrorIs(t, err, errDummy)
+	require.Nil(t, result)
+
+	payment, err := control.FetchPayment(p.identifier)
+	require.NoError(t, err)
+	require.Len(t, payment.InFlightHTLCs(), 2)
+
+	// Retrying succeeds and the attempt is failed.
+	result, err = p.failAttempt(
+		attempt.AttemptID, htlcswitch.ErrPaymentIDNotFound,
+	)
+	require.NoError(t, err)
+	require.Equal(t, htlcswitch.ErrPaymentIDNotFound, result.err)
+	require.Equal(
+		t, channeldb.HTLCFailInternal, result.attempt.Failure.Reason,
+	)
+
+	payment, err = control.FetchPayment(p.identifier)
+	require.NoError(t, err)
+	require.Len(t, payment.InFlightHTLCs(), 1)
+}
+
+// TestCollectResultAsyncSlowStore checks that the result of an attempt is
+// still collected if the control tower is slow to settle it.
+func TestCollectResultAsyncSlowStore(t *testing.T) {
+	t.Parallel()
+
+	p, m, control, attempt := newFaultTestPaymentLifecycle(t)
+
+	preimage := lntypes.Preimage{1}
+	mockAttemptSettled(p, m, attempt, preimage)
+
+	control.setFault("SettleAttempt", &controlTowerFault{
+		delay: 100 * time.Millisecond,
+	})
+
+	p.collectResultAsync(attempt)
+
+	var err error
+	waitErr := wait.NoError(func() error {
+		err = <-p.resultCollected
+		return nil
+	}, testTimeout)
+	require.NoError(t, waitErr, "timeout waiting for result")
+	require.NoError(t, err)
+
+	payment, err := control.FetchPayment(p.identifier)
+	require.NoError(t, err)
+	require.Equal(t, preimage, payment.GetHTLCs()[0].Settle.Preimage)
+}
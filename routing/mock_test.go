@@ -1,6 +1,7 @@
 package routing
 
 import (
+	"context"
 	"fmt"
 	"sync"
 
@@ -313,6 +314,19 @@ func (m *mockControlTowerOld) InitPayment(phash lntypes.Hash,
 	return nil
 }
 
+func (m *mockControlTowerOld) StoreTimings(phash lntypes.Hash,
+	timings *channeldb.PaymentTimings) error {
+
+	m.Lock()
+	defer m.Unlock()
+
+	if _, ok := m.payments[phash]; !ok {
+		return channeldb.ErrPaymentNotInitiated
+	}
+
+	return nil
+}
+
 func (m *mockControlTowerOld) DeleteFailedAttempts(phash lntypes.Hash) error {
 	p, ok := m.payments[phash]
 	if !ok {
@@ -473,8 +487,17 @@ func (m *mockControlTowerOld) FailAttempt(phash lntypes.Hash, pid uint64,
 	return nil, fmt.Errorf("pid not found")
 }
 
+func (m *mockControlTowerOld) AbandonAttempt(phash lntypes.Hash,
+	pid uint64) (*channeldb.HTLCAttempt, error) {
+
+	return m.FailAttempt(phash, pid, &channeldb.HTLCFailInfo{
+		Reason: channeldb.HTLCFailAbandoned,
+	})
+}
+
 func (m *mockControlTowerOld) FailPayment(phash lntypes.Hash,
-	reason channeldb.FailureReason) error {
+	reason channeldb.FailureReason,
+	_ *channeldb.PaymentFailureDetail) error {
 
 	m.Lock()
 	defer m.Unlock()
@@ -488,11 +511,20 @@ func (m *mockControlTowerOld) FailPayment(phash lntypes.Hash,
 		return channeldb.ErrPaymentNotInitiated
 	}
 
+	// A failed payment keeps its first failure reason.
+	if failed, ok := m.failed[phash]; ok && failed != reason {
+		return channeldb.ErrPaymentAlreadyFailed
+	}
+
 	m.failed[phash] = reason
 
 	return nil
 }
 
+func (m *mockControlTowerOld) AbandonPayment(phash lntypes.Hash) error {
+	return errors.New("not implemented")
+}
+
 func (m *mockControlTowerOld) FetchPayment(phash lntypes.Hash) (
 	dbMPPayment, error) {
 
@@ -502,6 +534,20 @@ func (m *mockControlTowerOld) FetchPayment(phash lntypes.Hash) (
 	return m.fetchPayment(phash)
 }
 
+func (m *mockControlTowerOld) FetchPaymentState(phash lntypes.Hash) (
+	*channeldb.MPPaymentState, channeldb.PaymentStatus, error) {
+
+	m.Lock()
+	defer m.Unlock()
+
+	payment, err := m.fetchPayment(phash)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return payment.State, payment.Status, nil
+}
+
 func (m *mockControlTowerOld) fetchPayment(phash lntypes.Hash) (
 	*channeldb.MPPayment, error) {
 
@@ -529,7 +575,7 @@ func (m *mockControlTowerOld) fetchPayment(phash lntypes.Hash) (
 	return mp, nil
 }
 
-func (m *mockControlTowerOld) FetchInFlightPayments() (
+func (m *mockControlTowerOld) FetchInFlightPayments(_ context.Context) (
 	[]*channeldb.MPPayment, error) {
 
 	if m.fetchInFlight != nil {
@@ -560,6 +606,35 @@ func (m *mockControlTowerOld) FetchInFlightPayments() (
 	return fl, nil
 }
 
+func (m *mockControlTowerOld) ForEachInFlightPayment(ctx context.Context,
+	cb func(*channeldb.MPPayment) error) error {
+
+	payments, err := m.FetchInFlightPayments(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, payment := range payments {
+		if err := cb(payment); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *mockControlTowerOld) DeletePayment(phash lntypes.Hash,
+	failedHtlcsOnly bool) (*channeldb.DeletedPayment, error) {
+
+	return nil, errors.New("not implemented")
+}
+
+func (m *mockControlTowerOld) DeletePayments(failedOnly,
+	failedHtlcsOnly bool) (int, int, error) {
+
+	return 0, 0, errors.New("not implemented")
+}
+
 func (m *mockControlTowerOld) SubscribePayment(paymentHash lntypes.Hash) (
 	ControlTowerSubscriber, error) {
 
@@ -717,6 +792,13 @@ func (m *mockControlTower) DeleteFailedAttempts(phash lntypes.Hash) error {
 	return args.Error(0)
 }
 
+func (m *mockControlTower) StoreTimings(phash lntypes.Hash,
+	timings *channeldb.PaymentTimings) error {
+
+	args := m.Called(phash, timings)
+	return args.Error(0)
+}
+
 func (m *mockControlTower) RegisterAttempt(phash lntypes.Hash,
 	a *channeldb.HTLCAttemptInfo) error {
 
@@ -751,10 +833,29 @@ func (m *mockControlTower) FailAttempt(phash lntypes.Hash, pid uint64,
 	return args.Get(0).(*channeldb.HTLCAttempt), args.Error(1)
 }
 
+func (m *mockControlTower) AbandonAttempt(phash lntypes.Hash,
+	pid uint64) (*channeldb.HTLCAttempt, error) {
+
+	args := m.Called(phash, pid)
+
+	attempt := args.Get(0)
+	if attempt == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*channeldb.HTLCAttempt), args.Error(1)
+}
+
 func (m *mockControlTower) FailPayment(phash lntypes.Hash,
-	reason channeldb.FailureReason) error {
+	reason channeldb.FailureReason,
+	detail *channeldb.PaymentFailureDetail) error {
 
-	args := m.Called(phash, reason)
+	args := m.Called(phash, reason, detail)
+	return args.Error(0)
+}
+
+func (m *mockControlTower) AbandonPayment(phash lntypes.Hash) error {
+	args := m.Called(phash)
 	return args.Error(0)
 }
 
@@ -772,13 +873,61 @@ func (m *mockControlTower) FetchPayment(phash lntypes.Hash) (
 	return payment, args.Error(1)
 }
 
-func (m *mockControlTower) FetchInFlightPayments() (
+func (m *mockControlTower) FetchPaymentState(phash lntypes.Hash) (
+	*channeldb.MPPaymentState, channeldb.PaymentStatus, error) {
+
+	args := m.Called(phash)
+
+	// Type assertion on nil will fail, so we check and return here.
+	if args.Get(0) == nil {
+		return nil, 0, args.Error(2)
+	}
+
+	state := args.Get(0).(*channeldb.MPPaymentState)
+	return state, args.Get(1).(channeldb.PaymentStatus), args.Error(2)
+}
+
+func (m *mockControlTower) FetchInFlightPayments(_ context.Context) (
 	[]*channeldb.MPPayment, error) {
 
 	args := m.Called()
 	return args.Get(0).([]*channeldb.MPPayment), args.Error(1)
 }
 
+func (m *mockControlTower) ForEachInFlightPayment(_ context.Context,
+	cb func(*channeldb.MPPayment) error) error {
+
+	args := m.Called()
+
+	for _, payment := range args.Get(0).([]*channeldb.MPPayment) {
+		if err := cb(payment); err != nil {
+			return err
+		}
+	}
+
+	return args.Error(1)
+}
+
+func (m *mockControlTower) DeletePayment(phash lntypes.Hash,
+	failedHtlcsOnly bool) (*channeldb.DeletedPayment, error) {
+
+	args := m.Called(phash, failedHtlcsOnly)
+
+	deleted := args.Get(0)
+	if deleted == nil {
+		return nil, args.Error(1)
+	}
+
+	return deleted.(*channeldb.DeletedPayment), args.Error(1)
+}
+
+func (m *mockControlTower) DeletePayments(failedOnly,
+	failedHtlcsOnly bool) (int, int, error) {
+
+	args := m.Called(failedOnly, failedHtlcsOnly)
+	return args.Int(0), args.Int(1), args.Error(2)
+}
+
 func (m *mockControlTower) SubscribePayment(paymentHash lntypes.Hash) (
 	ControlTowerSubscriber, error) {
 
@@ -946,3 +1095,21 @@ func (m *mockShard) AMP() *record.AMP {
 
 	return r.(*record.AMP)
 }
+
+type mockAttemptLookup struct {
+	mock.Mock
+}
+
+var _ AttemptLookup = (*mockAttemptLookup)(nil)
+
+func (m *mockAttemptLookup) HasAttemptCircuit(attemptID uint64) bool {
+	args := m.Called(attemptID)
+	return args.Bool(0)
+}
+
+func (m *mockAttemptLookup) HasAttemptResult(attemptID uint64) (bool,
+	error) {
+
+	args := m.Called(attemptID)
+	return args.Bool(0), args.Error(1)
+}
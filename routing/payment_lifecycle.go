@@ -47,6 +47,24 @@ type paymentLifecycle struct {
 	// except in unit test, where we use a much simpler resultCollector to
 	// decouple the test flow for the payment lifecycle.
 	resultCollector func(attempt *channeldb.HTLCAttempt)
+
+	// tracker records the current step and the in-flight attempts of the
+	// lifecycle so it can be inspected while the payment is being sent.
+	tracker *lifecycleTracker
+
+	// numAttempts is the number of htlc attempts registered by the
+	// lifecycle, including the in-flight attempts it resumed. It's
+	// recorded in the failure detail of the payment.
+	numAttempts uint32
+
+	// exited is closed once the lifecycle has finished, after which the
+	// result fields below hold its outcome. Callers that attach to an
+	// already active lifecycle use it to wait for the payment's result.
+	exited chan struct{}
+
+	preimage [32]byte
+	route    *route.Route
+	err      error
 }
 
 // newPaymentLifecycle initiates a new payment lifecycle and returns it.
@@ -64,6 +82,8 @@ func newPaymentLifecycle(r *ChannelRouter, feeLimit lnwire.MilliSatoshi,
 		currentHeight:   currentHeight,
 		quit:            make(chan struct{}),
 		resultCollected: make(chan error, 1),
+		tracker:         newLifecycleTracker(),
+		exited:          make(chan struct{}),
 	}
 
 	// Mount the result collector.
@@ -140,6 +160,8 @@ func (p *paymentLifecycle) decideNextStep(
 		log.Tracef("Waiting for attempt results for payment %v",
 			p.identifier)
 
+		p.tracker.setStep(LifecycleStepWaitingForResults)
+
 		// Otherwise we wait for one HTLC attempt then continue
 		// the lifecycle.
 		//
@@ -181,7 +203,25 @@ func (p *paymentLifecycle) resumePayment() ([32]byte, *route.Route, error) {
 		return [32]byte{}, nil, err
 	}
 
-	for _, a := range payment.InFlightHTLCs() {
+	// A quarantined attempt lacks the data needed to collect its result,
+	// so we'd wait for it forever. As its amount is unknown, we also can't
+	// tell how much is left to be sent. The payment is therefore kept in
+	// flight without making any more attempts until it's repaired
+	// manually.
+	inFlight := payment.InFlightHTLCs()
+	p.numAttempts = uint32(len(inFlight))
+	for _, a := range inFlight {
+		if a.Corrupt == nil {
+			continue
+		}
+
+		return [32]byte{}, nil, fmt.Errorf("%w: unable to resume "+
+			"shard %v of payment %v: %v",
+			channeldb.ErrCorruptHTLCAttempt, a.AttemptID,
+			p.identifier, a.Corrupt.Err)
+	}
+
+	for _, a := range inFlight {
 		a := a
 
 		log.Infof("Resuming payment shard %v for payment %v",
@@ -192,6 +232,8 @@ func (p *paymentLifecycle) resumePayment() ([32]byte, *route.Route, error) {
 
 	// exitWithErr is a helper closure that logs and returns an error.
 	exitWithErr := func(err error) ([32]byte, *route.Route, error) {
+		p.tracker.setStep(LifecycleStepExiting)
+
 		log.Errorf("Payment %v with status=%v failed: %v",
 			p.identifier, payment.GetStatus(), err)
 		return [32]byte{}, nil, err
@@ -206,6 +248,7 @@ lifecycle:
 		// collectResultAsync), it is NOT guaranteed that we always
 		// have the latest state here. This is fine as long as the
 		// state is consistent as a whole.
+		p.tracker.setStep(LifecycleStepFetchingPayment)
 		payment, err = p.router.cfg.Control.FetchPayment(p.identifier)
 		if err != nil {
 			return exitWithErr(err)
@@ -261,6 +304,7 @@ lifecycle:
 		}
 
 		// Now request a route to be used to create our HTLC attempt.
+		p.tracker.setStep(LifecycleStepPathfinding)
 		rt, err := p.requestRoute(ps)
 		if err != nil {
 			return exitWithErr(err)
@@ -278,14 +322,18 @@ lifecycle:
 		}
 
 		log.Tracef("Found route: %s", spew.Sdump(rt.Hops))
+		p.tracker.routeFound()
 
 		// We found a route to try, create a new HTLC attempt to try.
+		p.tracker.setStep(LifecycleStepRegisteringAttempt)
 		attempt, err := p.registerAttempt(rt, ps.RemainingAmt)
 		if err != nil {
 			return exitWithErr(err)
 		}
+		p.tracker.attemptRegistered()
 
 		// Once the attempt is created, send it to the htlcswitch.
+		p.tracker.setStep(LifecycleStepSendingAttempt)
 		result, err := p.sendAttempt(attempt)
 		if err != nil {
 			return exitWithErr(err)
@@ -294,6 +342,7 @@ lifecycle:
 		// Now that the shard was successfully sent, launch a go
 		// routine that will handle its result when its back.
 		if result.err == nil {
+			p.tracker.attemptDispatched()
 			p.resultCollector(attempt)
 		}
 	}
@@ -301,9 +350,16 @@ lifecycle:
 	// Once we are out the lifecycle loop, it means we've reached a
 	// terminal condition. We either return the settled preimage or the
 	// payment's failure reason.
-	//
+	p.tracker.setStep(LifecycleStepExiting)
+
+	// Persist the timings of the payment's first attempt now that the
+	// payment is terminal.
+	p.storeTimings()
+
 	// Optionally delete the failed attempts from the database.
+	done := p.tracker.dbWrite()
 	err = p.router.cfg.Control.DeleteFailedAttempts(p.identifier)
+	done()
 	if err != nil {
 		log.Errorf("Error deleting failed htlc attempts for payment "+
 			"%v: %v", p.identifier, err)
@@ -318,6 +374,42 @@ lifecycle:
 	return [32]byte{}, nil, *failure
 }
 
+// storeTimings logs and persists the timings of the payment's first attempt.
+// Payments for which no milestone was observed, such as payments resumed
+// after a restart that make no new attempts, are skipped.
+func (p *paymentLifecycle) storeTimings() {
+	timings := p.tracker.snapshot().Timings
+	if timings.IsEmpty() {
+		return
+	}
+
+	log.Infof("Payment %v finished: init_to_route=%v, "+
+		"route_to_register=%v, register_to_dispatch=%v", p.identifier,
+		timingDelta(timings.InitCommitted, timings.FirstRouteFound),
+		timingDelta(timings.FirstRouteFound,
+			timings.FirstAttemptRegistered),
+		timingDelta(timings.FirstAttemptRegistered,
+			timings.FirstAttemptDispatched))
+
+	done := p.tracker.dbWrite()
+	err := p.router.cfg.Control.StoreTimings(p.identifier, &timings)
+	done()
+	if err != nil {
+		log.Errorf("Error storing timings for payment %v: %v",
+			p.identifier, err)
+	}
+}
+
+// timingDelta returns the duration between two milestones as a string, or
+// "unknown" if either of them wasn't observed.
+func timingDelta(from, to time.Time) string {
+	if from.IsZero() || to.IsZero() {
+		return "unknown"
+	}
+
+	return to.Sub(from).String()
+}
+
 // checkTimeout checks whether the payment has reached its timeout.
 func (p *paymentLifecycle) checkTimeout() error {
 	select {
@@ -328,8 +420,8 @@ func (p *paymentLifecycle) checkTimeout() error {
 		// inflight HTLCs or not, its status will now either be
 		// `StatusInflight` or `StatusFailed`. In either case, no more
 		// HTLCs will be attempted.
-		err := p.router.cfg.Control.FailPayment(
-			p.identifier, channeldb.FailureReasonTimeout,
+		err := p.router.failPayment(
+			p.identifier, channeldb.FailureReasonTimeout, nil,
 		)
 		if err != nil {
 			return fmt.Errorf("FailPayment got %w", err)
@@ -347,7 +439,8 @@ func (p *paymentLifecycle) checkTimeout() error {
 }
 
 // requestRoute is responsible for finding a route to be used to create an HTLC
-// attempt.
+// attempt. If no route can be found, the payment is failed, recording the
+// pathfinding error and the number of attempts made for the given payment.
 func (p *paymentLifecycle) requestRoute(
 	ps *channeldb.MPPaymentState) (*route.Route, error) {
 
@@ -384,7 +477,20 @@ func (p *paymentLifecycle) requestRoute(
 	log.Warnf("Marking payment %v permanently failed with no route: %v",
 		p.identifier, failureCode)
 
-	err = p.router.cfg.Control.FailPayment(p.identifier, failureCode)
+	// Keep the pathfinding error, so it's still known after a restart. It
+	// is truncated so the detail doesn't exceed the size limit.
+	errMsg := err.Error()
+	if len(errMsg) > channeldb.MaxPaymentFailureDetailErrorLen {
+		errMsg = errMsg[:channeldb.MaxPaymentFailureDetailErrorLen]
+	}
+	detail := &channeldb.PaymentFailureDetail{
+		Error:       errMsg,
+		NumAttempts: p.numAttempts,
+	}
+
+	done := p.tracker.dbWrite()
+	err = p.router.failPayment(p.identifier, failureCode, detail)
+	done()
 	if err != nil {
 		return nil, fmt.Errorf("FailPayment got: %w", err)
 	}
@@ -421,9 +527,13 @@ func (p *paymentLifecycle) collectResultAsync(attempt *channeldb.HTLCAttempt) {
 	log.Debugf("Collecting result for attempt %v in payment %v",
 		attempt.AttemptID, p.identifier)
 
+	// Keep track of the attempt until its result is collected.
+	p.tracker.addAttempt(attempt.AttemptID)
+
 	go func() {
 		// Block until the result is available.
 		_, err := p.collectResult(attempt)
+		p.tracker.removeAttempt(attempt.AttemptID)
 		if err != nil {
 			log.Errorf("Error collecting result for attempt %v "+
 				"in payment %v: %v", attempt.AttemptID,
@@ -491,6 +601,11 @@ func (p *paymentLifecycle) collectResult(attempt *channeldb.HTLCAttempt) (
 		OnionErrorDecrypter: sphinx.NewOnionErrorDecrypter(circuit),
 	}
 
+	// Subscribe to the attempt being abandoned as stale before its
+	// result is requested, since a stale attempt would never get one.
+	abandoned := p.router.subscribeAbandoned(attempt.AttemptID)
+	defer p.router.unsubscribeAbandoned(attempt.AttemptID)
+
 	// Now ask the switch to return the result of the payment when
 	// available.
 	//
@@ -523,6 +638,17 @@ func (p *paymentLifecycle) collectResult(attempt *channeldb.HTLCAttempt) (
 			return nil, htlcswitch.ErrSwitchExiting
 		}
 
+	// The attempt was abandoned and already marked as such with the
+	// control tower, so there's nothing left to record.
+	case htlcAttempt := <-abandoned:
+		log.Infof("Attempt %v of payment %v was abandoned",
+			attempt.AttemptID, p.identifier)
+
+		return &attemptResult{
+			err:     ErrAttemptAbandoned,
+			attempt: htlcAttempt,
+		}, nil
+
 	case <-p.quit:
 		return nil, ErrPaymentLifecycleExiting
 
@@ -589,16 +715,30 @@ func (p *paymentLifecycle) registerAttempt(rt *route.Route,
 		return nil, err
 	}
 
+	// Mark the attempt as dispatching before it becomes visible in the
+	// DB, so it isn't mistaken for a stale attempt until it's been handed
+	// to the switch by sendAttempt.
+	p.router.dispatchingAttempts.Store(attempt.AttemptID, struct{}{})
+
 	// Before sending this HTLC to the switch, we checkpoint the fresh
 	// paymentID and route to the DB. This lets us know on startup the ID
 	// of the payment that we attempted to send, such that we can query the
 	// Switch for its whereabouts. The route is needed to handle the result
 	// when it eventually comes back.
+	done := p.tracker.dbWrite()
 	err = p.router.cfg.Control.RegisterAttempt(
 		p.identifier, &attempt.HTLCAttemptInfo,
 	)
+	done()
+	if err != nil {
+		p.router.dispatchingAttempts.Delete(attempt.AttemptID)
+
+		return attempt, err
+	}
+
+	p.numAttempts++
 
-	return attempt, err
+	return attempt, nil
 }
 
 // createNewPaymentAttempt creates a new payment attempt from the given route.
@@ -647,6 +787,9 @@ func (p *paymentLifecycle) createNewPaymentAttempt(rt *route.Route,
 		attemptID, sessionKey, *rt, p.router.cfg.Clock.Now(), &hash,
 	)
 
+	// Snapshot the on-chain fee conditions at the time of the attempt.
+	attempt.ChainFeeRate = p.router.chainFeeRate()
+
 	return attempt, nil
 }
 
@@ -659,6 +802,10 @@ func (p *paymentLifecycle) sendAttempt(
 	log.Debugf("Attempting to send payment %v (pid=%v)", p.identifier,
 		attempt.AttemptID)
 
+	// Once we return, the switch has either committed the circuit of the
+	// attempt or the attempt has been failed.
+	defer p.router.dispatchingAttempts.Delete(attempt.AttemptID)
+
 	rt := attempt.Route
 
 	// Construct the first hop.
@@ -723,7 +870,7 @@ func (p *paymentLifecycle) failPaymentAndAttempt(
 	// NOTE: we must fail the payment first before failing the attempt.
 	// Otherwise, once the attempt is marked as failed, another goroutine
 	// might make another attempt while we are failing the payment.
-	err := p.router.cfg.Control.FailPayment(p.identifier, *reason)
+	err := p.router.failPayment(p.identifier, *reason, nil)
 	if err != nil {
 		log.Errorf("Unable to fail payment: %v", err)
 		return nil, err
@@ -0,0 +1,275 @@
+package routing
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lightningnetwork/lnd/channeldb"
+)
+
+// ErrPaymentLifecycleNotFound is returned when the state of a payment
+// lifecycle is requested for a payment that is not actively being sent.
+var ErrPaymentLifecycleNotFound = errors.New("no active payment lifecycle " +
+	"found")
+
+// maxLifecycleTransitions is the maximum number of recent step transitions
+// we keep track of for a single payment lifecycle.
+const maxLifecycleTransitions = 20
+
+// LifecycleStep describes what a payment lifecycle is currently doing.
+type LifecycleStep uint8
+
+const (
+	// LifecycleStepInitializing is the step of a lifecycle that has been
+	// created but hasn't entered its main loop yet.
+	LifecycleStepInitializing LifecycleStep = iota
+
+	// LifecycleStepFetchingPayment is the step in which the lifecycle
+	// reads the latest state of the payment from the database.
+	LifecycleStepFetchingPayment
+
+	// LifecycleStepPathfinding is the step in which the lifecycle requests
+	// a route for a new HTLC attempt from its payment session.
+	LifecycleStepPathfinding
+
+	// LifecycleStepRegisteringAttempt is the step in which the lifecycle
+	// persists a new HTLC attempt to the database.
+	LifecycleStepRegisteringAttempt
+
+	// LifecycleStepSendingAttempt is the step in which the lifecycle hands
+	// a new HTLC attempt to the switch.
+	LifecycleStepSendingAttempt
+
+	// LifecycleStepWaitingForResults is the step in which the lifecycle
+	// can't make any new attempts and is waiting for the result of one of
+	// its in-flight HTLC attempts.
+	LifecycleStepWaitingForResults
+
+	// LifecycleStepExiting is the step of a lifecycle that has left its
+	// main loop and is finishing up.
+	LifecycleStepExiting
+
+	// LifecycleStepBlockedOnDBWrite is the step in which the lifecycle
+	// waits for a write to the control tower to return.
+	LifecycleStepBlockedOnDBWrite
+
+	// LifecycleStepPausedByBackpressure is the step of a payment that is
+	// resumed at startup, but waits for a free resumption slot before its
+	// lifecycle is started.
+	LifecycleStepPausedByBackpressure
+)
+
+// String returns a human-readable name of the lifecycle step.
+func (s LifecycleStep) String() string {
+	switch s {
+	case LifecycleStepInitializing:
+		return "Initializing"
+
+	case LifecycleStepFetchingPayment:
+		return "FetchingPayment"
+
+	case LifecycleStepPathfinding:
+		return "Pathfinding"
+
+	case LifecycleStepRegisteringAttempt:
+		return "RegisteringAttempt"
+
+	case LifecycleStepSendingAttempt:
+		return "SendingAttempt"
+
+	case LifecycleStepWaitingForResults:
+		return "WaitingForResults"
+
+	case LifecycleStepExiting:
+		return "Exiting"
+
+	case LifecycleStepBlockedOnDBWrite:
+		return "BlockedOnDBWrite"
+
+	case LifecycleStepPausedByBackpressure:
+		return "PausedByBackpressure"
+
+	default:
+		return "Unknown"
+	}
+}
+
+// LifecycleTransition records the time at which a payment lifecycle entered
+// a step.
+type LifecycleTransition struct {
+	// Step is the step that was entered.
+	Step LifecycleStep
+
+	// Time is the time at which the step was entered.
+	Time time.Time
+}
+
+// PaymentLifecycleState is a snapshot of the internal state of a payment
+// lifecycle, used for debugging payments that don't make progress.
+type PaymentLifecycleState struct {
+	// Step is the step the lifecycle is currently in.
+	Step LifecycleStep
+
+	// InFlightAttempts are the IDs of the HTLC attempts the lifecycle is
+	// currently waiting on, in ascending order.
+	InFlightAttempts []uint64
+
+	// Transitions are the most recent step transitions of the lifecycle,
+	// oldest first.
+	Transitions []LifecycleTransition
+
+	// Timings holds the milestones of the payment's first attempt that
+	// were reached so far.
+	Timings channeldb.PaymentTimings
+}
+
+// lifecycleTracker keeps track of the current step and in-flight attempts of
+// a payment lifecycle. It is safe for concurrent use, so the state can be
+// inspected while the lifecycle is running.
+type lifecycleTracker struct {
+	mtx sync.Mutex
+
+	// step is the current step of the lifecycle.
+	step LifecycleStep
+
+	// transitions holds the most recent step transitions.
+	transitions []LifecycleTransition
+
+	// inFlight is the set of attempt IDs whose results are being
+	// collected.
+	inFlight map[uint64]struct{}
+
+	// timings holds the milestones of the payment's first attempt. Only
+	// the first time a milestone is reached is recorded.
+	timings channeldb.PaymentTimings
+}
+
+// newLifecycleTracker creates a new tracker in the initializing step.
+func newLifecycleTracker() *lifecycleTracker {
+	t := &lifecycleTracker{
+		inFlight: make(map[uint64]struct{}),
+	}
+	t.setStep(LifecycleStepInitializing)
+
+	return t
+}
+
+// setStep records that the lifecycle entered the given step. Entering the
+// step the lifecycle is already in is a no-op.
+func (t *lifecycleTracker) setStep(step LifecycleStep) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if len(t.transitions) > 0 && t.step == step {
+		return
+	}
+
+	t.step = step
+	t.transitions = append(t.transitions, LifecycleTransition{
+		Step: step,
+		Time: time.Now(),
+	})
+
+	// Only keep the most recent transitions around.
+	if len(t.transitions) > maxLifecycleTransitions {
+		t.transitions = t.transitions[1:]
+	}
+}
+
+// dbWrite records that the lifecycle is blocked on a write to the control
+// tower. The returned function must be called once the write returned, which
+// moves the lifecycle back to the step it was in before.
+func (t *lifecycleTracker) dbWrite() func() {
+	t.mtx.Lock()
+	prev := t.step
+	t.mtx.Unlock()
+
+	t.setStep(LifecycleStepBlockedOnDBWrite)
+
+	return func() {
+		t.setStep(prev)
+	}
+}
+
+// addAttempt records that the result of the given attempt is being waited on.
+func (t *lifecycleTracker) addAttempt(attemptID uint64) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	t.inFlight[attemptID] = struct{}{}
+}
+
+// removeAttempt records that the result of the given attempt was collected.
+func (t *lifecycleTracker) removeAttempt(attemptID uint64) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	delete(t.inFlight, attemptID)
+}
+
+// setInitCommitted records the time the payment's initialization was
+// committed to the database.
+func (t *lifecycleTracker) setInitCommitted(initCommitted time.Time) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	t.timings.InitCommitted = initCommitted
+}
+
+// recordFirst sets the given milestone to the current time, unless it was
+// already reached before. The caller must hold the mutex.
+func recordFirst(milestone *time.Time) {
+	if milestone.IsZero() {
+		*milestone = time.Now()
+	}
+}
+
+// routeFound records that a route was returned by pathfinding.
+func (t *lifecycleTracker) routeFound() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	recordFirst(&t.timings.FirstRouteFound)
+}
+
+// attemptRegistered records that an HTLC attempt was persisted.
+func (t *lifecycleTracker) attemptRegistered() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	recordFirst(&t.timings.FirstAttemptRegistered)
+}
+
+// attemptDispatched records that an HTLC attempt was handed to the switch.
+func (t *lifecycleTracker) attemptDispatched() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	recordFirst(&t.timings.FirstAttemptDispatched)
+}
+
+// snapshot returns a copy of the current state of the tracker.
+func (t *lifecycleTracker) snapshot() *PaymentLifecycleState {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	attempts := make([]uint64, 0, len(t.inFlight))
+	for id := range t.inFlight {
+		attempts = append(attempts, id)
+	}
+	sort.Slice(attempts, func(i, j int) bool {
+		return attempts[i] < attempts[j]
+	})
+
+	transitions := make([]LifecycleTransition, len(t.transitions))
+	copy(transitions, t.transitions)
+
+	return &PaymentLifecycleState{
+		Step:             t.step,
+		InFlightAttempts: attempts,
+		Transitions:      transitions,
+		Timings:          t.timings,
+	}
+}
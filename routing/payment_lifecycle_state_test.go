@@ -0,0 +1,126 @@
+package routing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLifecycleTracker checks that the lifecycle tracker records step
+// transitions and in-flight attempts as expected.
+func TestLifecycleTracker(t *testing.T) {
+	t.Parallel()
+
+	tracker := newLifecycleTracker()
+
+	// A new tracker starts in the initializing step.
+	state := tracker.snapshot()
+	require.Equal(t, LifecycleStepInitializing, state.Step)
+	require.Empty(t, state.InFlightAttempts)
+	require.Len(t, state.Transitions, 1)
+
+	// Entering a new step records a transition, entering the same step
+	// again doesn't.
+	tracker.setStep(LifecycleStepPathfinding)
+	tracker.setStep(LifecycleStepPathfinding)
+	tracker.setStep(LifecycleStepWaitingForResults)
+
+	state = tracker.snapshot()
+	require.Equal(t, LifecycleStepWaitingForResults, state.Step)
+	require.Len(t, state.Transitions, 3)
+	require.Equal(t, LifecycleStepPathfinding, state.Transitions[1].Step)
+	require.False(t, state.Transitions[2].Time.Before(
+		state.Transitions[1].Time,
+	))
+
+	// In-flight attempts are returned in ascending order.
+	tracker.addAttempt(3)
+	tracker.addAttempt(1)
+	tracker.addAttempt(2)
+	tracker.removeAttempt(2)
+
+	state = tracker.snapshot()
+	require.Equal(t, []uint64{1, 3}, state.InFlightAttempts)
+
+	// A database write moves the lifecycle to the blocked step until it
+	// returned.
+	done := tracker.dbWrite()
+	require.Equal(
+		t, LifecycleStepBlockedOnDBWrite, tracker.snapshot().Step,
+	)
+
+	done()
+	state = tracker.snapshot()
+	require.Equal(t, LifecycleStepWaitingForResults, state.Step)
+	require.Len(t, state.Transitions, 5)
+	require.Equal(
+		t, LifecycleStepBlockedOnDBWrite, state.Transitions[3].Step,
+	)
+
+	// Only the most recent transitions are kept.
+	for i := 0; i < maxLifecycleTransitions; i++ {
+		tracker.setStep(LifecycleStepPathfinding)
+		tracker.setStep(LifecycleStepSendingAttempt)
+	}
+
+	state = tracker.snapshot()
+	require.Len(t, state.Transitions, maxLifecycleTransitions)
+	require.Equal(t, LifecycleStepSendingAttempt, state.Step)
+	require.Equal(
+		t, LifecycleStepSendingAttempt,
+		state.Transitions[maxLifecycleTransitions-1].Step,
+	)
+}
+
+// TestGetPaymentLifecycleState checks that the state of a payment lifecycle
+// can only be fetched while the payment is actively being sent.
+func TestGetPaymentLifecycleState(t *testing.T) {
+	t.Parallel()
+
+	p := createTestPaymentLifecycle()
+	r := p.router
+
+	// No lifecycle is registered yet.
+	_, err := r.GetPaymentLifecycleState(p.identifier)
+	require.ErrorIs(t, err, ErrPaymentLifecycleNotFound)
+
+	// Register the lifecycle and move it to a new step.
+	r.activeLifecycles.Store(p.identifier, p)
+	p.tracker.setStep(LifecycleStepWaitingForResults)
+	p.tracker.addAttempt(7)
+
+	state, err := r.GetPaymentLifecycleState(p.identifier)
+	require.NoError(t, err)
+	require.Equal(t, LifecycleStepWaitingForResults, state.Step)
+	require.Equal(t, []uint64{7}, state.InFlightAttempts)
+
+	// A different payment is still not found.
+	_, err = r.GetPaymentLifecycleState(lntypes.Hash{9})
+	require.ErrorIs(t, err, ErrPaymentLifecycleNotFound)
+
+	// Once the lifecycle is removed, it can't be found anymore.
+	r.activeLifecycles.Delete(p.identifier)
+	_, err = r.GetPaymentLifecycleState(p.identifier)
+	require.ErrorIs(t, err, ErrPaymentLifecycleNotFound)
+
+	// A payment that waits for a resumption slot is reported as paused.
+	since := time.Now()
+	r.pausedResumptions.Store(p.identifier, since)
+
+	state, err = r.GetPaymentLifecycleState(p.identifier)
+	require.NoError(t, err)
+	require.Equal(t, LifecycleStepPausedByBackpressure, state.Step)
+	require.Empty(t, state.InFlightAttempts)
+	require.Len(t, state.Transitions, 1)
+	require.Equal(t, since, state.Transitions[0].Time)
+
+	// Once the lifecycle is started, its own state is returned.
+	r.pausedResumptions.Delete(p.identifier)
+	r.activeLifecycles.Store(p.identifier, p)
+
+	state, err = r.GetPaymentLifecycleState(p.identifier)
+	require.NoError(t, err)
+	require.Equal(t, LifecycleStepWaitingForResults, state.Step)
+}
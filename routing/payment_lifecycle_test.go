@@ -1,6 +1,8 @@
 package routing
 
 import (
+	"fmt"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -12,6 +14,7 @@ import (
 	"github.com/lightningnetwork/lnd/lnmock"
 	"github.com/lightningnetwork/lnd/lntest/wait"
 	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
 	"github.com/lightningnetwork/lnd/lnwire"
 	"github.com/lightningnetwork/lnd/record"
 	"github.com/lightningnetwork/lnd/routing/route"
@@ -34,6 +37,7 @@ func createTestPaymentLifecycle() *paymentLifecycle {
 	return &paymentLifecycle{
 		router:     rt,
 		identifier: paymentHash,
+		tracker:    newLifecycleTracker(),
 	}
 }
 
@@ -283,7 +287,8 @@ func TestCheckTimeoutTimedOut(t *testing.T) {
 	// Mock the control tower's `FailPayment` method.
 	ct := &mockControlTower{}
 	ct.On("FailPayment",
-		p.identifier, channeldb.FailureReasonTimeout).Return(nil)
+		p.identifier, channeldb.FailureReasonTimeout, mock.Anything,
+	).Return(nil)
 
 	// Mount the mocked control tower.
 	p.router.cfg.Control = ct
@@ -307,7 +312,8 @@ func TestCheckTimeoutTimedOut(t *testing.T) {
 	// Mock `FailPayment` to return a dummy error.
 	ct = &mockControlTower{}
 	ct.On("FailPayment",
-		p.identifier, channeldb.FailureReasonTimeout).Return(errDummy)
+		p.identifier, channeldb.FailureReasonTimeout, mock.Anything,
+	).Return(errDummy)
 
 	// Mount the mocked control tower.
 	p.router.cfg.Control = ct
@@ -437,9 +443,17 @@ func TestRequestRouteHandleNoRouteErr(t *testing.T) {
 		mock.Anything, mock.Anything, mock.Anything, mock.Anything,
 	).Return(nil, errNoTlvPayload)
 
-	// The payment should be failed with reason no route.
+	// Mock the lifecycle to have made two attempts so far.
+	p.numAttempts = 2
+
+	// The payment should be failed with reason no route, recording the
+	// pathfinding error and the number of attempts made.
+	detail := &channeldb.PaymentFailureDetail{
+		Error:       errNoTlvPayload.Error(),
+		NumAttempts: 2,
+	}
 	m.control.On("FailPayment",
-		p.identifier, channeldb.FailureReasonNoRoute,
+		p.identifier, channeldb.FailureReasonNoRoute, detail,
 	).Return(nil).Once()
 
 	result, err := p.requestRoute(ps)
@@ -449,6 +463,40 @@ func TestRequestRouteHandleNoRouteErr(t *testing.T) {
 	require.Nil(t, result, "expected no route returned")
 }
 
+// TestRequestRouteTruncateNoRouteErr checks that a pathfinding error that is
+// too long to be stored is truncated in the failure detail.
+func TestRequestRouteTruncateNoRouteErr(t *testing.T) {
+	t.Parallel()
+
+	// Create a paymentLifecycle with mockers.
+	p, m := newTestPaymentLifecycle(t)
+
+	ps := &channeldb.MPPaymentState{
+		RemainingAmt: 1,
+		FeesPaid:     100,
+	}
+	p.feeLimit = ps.FeesPaid + 1
+
+	// Return a no route error whose message exceeds the limit.
+	longErr := fmt.Errorf("%w: %s", errNoPathFound,
+		strings.Repeat("x", channeldb.MaxPaymentFailureDetailSize))
+	m.paySession.On("RequestRoute",
+		mock.Anything, mock.Anything, mock.Anything, mock.Anything,
+	).Return(nil, longErr)
+
+	errMsg := longErr.Error()
+	detail := &channeldb.PaymentFailureDetail{
+		Error: errMsg[:channeldb.MaxPaymentFailureDetailErrorLen],
+	}
+	m.control.On("FailPayment",
+		p.identifier, channeldb.FailureReasonNoRoute, detail,
+	).Return(nil).Once()
+
+	result, err := p.requestRoute(ps)
+	require.NoError(t, err)
+	require.Nil(t, result)
+}
+
 // TestRequestRouteFailPaymentError checks that `requestRoute` returns the
 // error from calling `FailPayment`.
 func TestRequestRouteFailPaymentError(t *testing.T) {
@@ -462,7 +510,7 @@ func TestRequestRouteFailPaymentError(t *testing.T) {
 	// Mock the control tower's `FailPayment` method.
 	ct := &mockControlTower{}
 	ct.On("FailPayment",
-		p.identifier, errNoTlvPayload.FailureReason(),
+		p.identifier, errNoTlvPayload.FailureReason(), mock.Anything,
 	).Return(errDummy)
 
 	// Mount the mocked control tower and payment session.
@@ -633,6 +681,33 @@ func TestResumePaymentFailOnFetchPayment(t *testing.T) {
 	require.Zero(t, m.collectResultsCount)
 }
 
+// TestResumePaymentQuarantinedAttempt checks that a payment with a quarantined
+// in-flight attempt isn't resumed, as the result of the attempt can't be
+// collected.
+//
+// NOTE: No parallel test because it overwrites global variables.
+//
+//nolint:paralleltest
+func TestResumePaymentQuarantinedAttempt(t *testing.T) {
+	// Create a test paymentLifecycle.
+	p, m := newTestPaymentLifecycle(t)
+
+	m.control.On("FetchPayment", p.identifier).Return(
+		m.payment, nil,
+	).Once()
+
+	htlcs := []channeldb.HTLCAttempt{{
+		Corrupt: &channeldb.HTLCCorruption{Err: errDummy},
+	}}
+	m.payment.On("InFlightHTLCs").Return(htlcs).Once()
+
+	// Send the payment and assert it failed.
+	sendPaymentAndAssertFailed(t, p, channeldb.ErrCorruptHTLCAttempt)
+
+	// Expected collectResultAsync to not be called.
+	require.Zero(t, m.collectResultsCount)
+}
+
 // TestResumePaymentFailOnTimeout checks that when timeout is reached, the
 // payment is failed.
 //
@@ -668,7 +743,7 @@ func TestResumePaymentFailOnTimeout(t *testing.T) {
 
 	// 4. the payment should be failed with reason timeout.
 	m.control.On("FailPayment",
-		p.identifier, channeldb.FailureReasonTimeout,
+		p.identifier, channeldb.FailureReasonTimeout, mock.Anything,
 	).Return(nil).Once()
 
 	// 5. decideNextStep now returns stepExit.
@@ -944,7 +1019,9 @@ func TestResumePaymentFailOnSendAttemptErr(t *testing.T) {
 	//
 	// `FailPayment` should be called with an internal reason.
 	reason := channeldb.FailureReasonError
-	m.control.On("FailPayment", p.identifier, reason).Return(nil).Once()
+	m.control.On("FailPayment",
+		p.identifier, reason, mock.Anything,
+	).Return(nil).Once()
 
 	// `CancelShard` should be called with the attemptID.
 	m.shardTracker.On("CancelShard", attemptID).Return(nil).Once()
@@ -1046,7 +1123,10 @@ func TestResumePaymentSuccess(t *testing.T) {
 	m.payment.On("AllowMoreAttempts").Return(false, nil).Once().
 		On("NeedWaitAttempts").Return(false, nil).Once()
 
-	// We should perform an optional deletion over failed attempts.
+	// We should persist the timings of the first attempt and perform an
+	// optional deletion over failed attempts.
+	m.control.On("StoreTimings", p.identifier, mock.Anything).
+		Return(nil).Once()
 	m.control.On("DeleteFailedAttempts", p.identifier).Return(nil).Once()
 
 	// Finally, mock the `TerminalInfo` to return the settled attempt.
@@ -1062,6 +1142,109 @@ func TestResumePaymentSuccess(t *testing.T) {
 	require.Equal(t, 1, m.collectResultsCount)
 }
 
+// TestResumePaymentTimings checks that the time spent in a slow pathfinder
+// is attributed to the time between the payment's initialization and its
+// first route, and that the timings are persisted once the payment is
+// terminal.
+//
+// NOTE: No parallel test because it overwrites global variables.
+//
+//nolint:paralleltest
+func TestResumePaymentTimings(t *testing.T) {
+	// Create a test paymentLifecycle with the initial two calls mocked.
+	p, m := setupTestPaymentLifecycle(t)
+
+	// The payment was initialized right before the lifecycle started.
+	initCommitted := time.Now()
+	p.tracker.setInitCommitted(initCommitted)
+
+	// Create a dummy route that will be returned by `RequestRoute`.
+	paymentAmt := lnwire.MilliSatoshi(10000)
+	rt := createDummyRoute(t, paymentAmt)
+
+	// We now enter the payment lifecycle loop.
+	m.control.On("FetchPayment", p.identifier).Return(m.payment, nil).Once()
+	ps := &channeldb.MPPaymentState{
+		RemainingAmt: paymentAmt,
+	}
+	m.payment.On("GetState").Return(ps).Once()
+	m.payment.On("GetStatus").Return(channeldb.StatusInFlight)
+	m.payment.On("AllowMoreAttempts").Return(true, nil).Once()
+
+	// Mock a slow pathfinder that takes a while to return the route.
+	const pathfindingDelay = 100 * time.Millisecond
+	m.paySession.On("RequestRoute",
+		paymentAmt, p.feeLimit, uint32(ps.NumAttemptsInFlight),
+		uint32(p.currentHeight),
+	).Return(rt, nil).Run(func(args mock.Arguments) {
+		time.Sleep(pathfindingDelay)
+	}).Once()
+
+	// Register the attempt and send it successfully.
+	attemptID := uint64(1)
+	p.router.cfg.NextPaymentID = func() (uint64, error) {
+		return attemptID, nil
+	}
+	m.shardTracker.On("NewShard",
+		attemptID, true,
+	).Return(m.shard, nil).Once()
+	m.shard.On("MPP").Return(&record.MPP{}).Twice().
+		On("AMP").Return(nil).Once().
+		On("Hash").Return(p.identifier).Once()
+	m.clock.On("Now").Return(time.Now())
+	m.control.On("RegisterAttempt",
+		p.identifier, mock.Anything,
+	).Return(nil).Once()
+	m.payer.On("SendHTLC",
+		mock.Anything, attemptID, mock.Anything,
+	).Return(nil).Once()
+
+	// In the second iteration the payment is done and the loop exits.
+	m.control.On("FetchPayment", p.identifier).Return(m.payment, nil).Once()
+	m.payment.On("GetState").Return(ps).Run(func(args mock.Arguments) {
+		ps.RemainingAmt = 0
+	}).Once()
+	m.payment.On("AllowMoreAttempts").Return(false, nil).Once().
+		On("NeedWaitAttempts").Return(false, nil).Once()
+
+	// Capture the timings that are persisted.
+	var stored *channeldb.PaymentTimings
+	m.control.On("StoreTimings", p.identifier, mock.Anything).Run(
+		func(args mock.Arguments) {
+			stored = args.Get(1).(*channeldb.PaymentTimings)
+		},
+	).Return(nil).Once()
+	m.control.On("DeleteFailedAttempts", p.identifier).Return(nil).Once()
+
+	testPreimage := lntypes.Preimage{1, 2, 3}
+	settledAttempt := makeSettledAttempt(t, int(paymentAmt), testPreimage)
+	m.payment.On("TerminalInfo").Return(settledAttempt, nil).Once()
+
+	sendPaymentAndAssertSucceeded(t, p, testPreimage)
+
+	// All milestones must have been recorded in order, with the time
+	// spent in the pathfinder attributed to finding the first route.
+	require.NotNil(t, stored)
+	require.Equal(t, initCommitted, stored.InitCommitted)
+	require.GreaterOrEqual(t,
+		stored.FirstRouteFound.Sub(stored.InitCommitted),
+		pathfindingDelay,
+	)
+	require.False(t,
+		stored.FirstAttemptRegistered.Before(stored.FirstRouteFound),
+	)
+	require.False(t, stored.FirstAttemptDispatched.Before(
+		stored.FirstAttemptRegistered,
+	))
+	require.Less(t,
+		stored.FirstAttemptDispatched.Sub(stored.FirstRouteFound),
+		pathfindingDelay,
+	)
+
+	// The same timings are exposed by the lifecycle state.
+	require.Equal(t, *stored, p.tracker.snapshot().Timings)
+}
+
 // TestResumePaymentSuccessWithTwoAttempts checks a successful payment flow
 // with two HTLC attempts.
 //
@@ -1198,7 +1381,10 @@ func TestResumePaymentSuccessWithTwoAttempts(t *testing.T) {
 	m.payment.On("AllowMoreAttempts").Return(false, nil).Once().
 		On("NeedWaitAttempts").Return(false, nil).Once()
 
-	// We should perform an optional deletion over failed attempts.
+	// We should persist the timings of the first attempt and perform an
+	// optional deletion over failed attempts.
+	m.control.On("StoreTimings", p.identifier, mock.Anything).
+		Return(nil).Once()
 	m.control.On("DeleteFailedAttempts", p.identifier).Return(nil).Once()
 
 	// Finally, mock the `TerminalInfo` to return the settled attempt.
@@ -1240,7 +1426,9 @@ func TestCollectResultExitOnErr(t *testing.T) {
 	//
 	// `FailPayment` should be called with an internal reason.
 	reason := channeldb.FailureReasonError
-	m.control.On("FailPayment", p.identifier, reason).Return(nil).Once()
+	m.control.On("FailPayment",
+		p.identifier, reason, mock.Anything,
+	).Return(nil).Once()
 
 	// `CancelShard` should be called with the attemptID.
 	m.shardTracker.On("CancelShard", attempt.AttemptID).Return(nil).Once()
@@ -1291,7 +1479,9 @@ func TestCollectResultExitOnResultErr(t *testing.T) {
 	//
 	// `FailPayment` should be called with an internal reason.
 	reason := channeldb.FailureReasonError
-	m.control.On("FailPayment", p.identifier, reason).Return(nil).Once()
+	m.control.On("FailPayment",
+		p.identifier, reason, mock.Anything,
+	).Return(nil).Once()
 
 	// `CancelShard` should be called with the attemptID.
 	m.shardTracker.On("CancelShard", attempt.AttemptID).Return(nil).Once()
@@ -1556,3 +1746,85 @@ func TestCollectResultAsyncSuccess(t *testing.T) {
 	// Assert that a nil error is received.
 	require.NoError(t, err, "expected no error")
 }
+
+// TestCreateNewPaymentAttemptChainFeeRate checks that a new attempt records
+// the node's on-chain fee estimate, and that no fee rate is recorded if no
+// estimate is available.
+func TestCreateNewPaymentAttemptChainFeeRate(t *testing.T) {
+	t.Parallel()
+
+	const feeRate = chainfee.SatPerKWeight(2500)
+
+	testCases := []struct {
+		name            string
+		setupEstimator  func() *chainfee.MockEstimator
+		expectedFeeRate chainfee.SatPerKWeight
+	}{
+		{
+			name: "estimate available",
+			setupEstimator: func() *chainfee.MockEstimator {
+				estimator := &chainfee.MockEstimator{}
+				estimator.On(
+					"EstimateFeePerKW", uint32(6),
+				).Return(feeRate, nil).Once()
+
+				return estimator
+			},
+			expectedFeeRate: feeRate,
+		},
+		{
+			name: "estimator error",
+			setupEstimator: func() *chainfee.MockEstimator {
+				estimator := &chainfee.MockEstimator{}
+				estimator.On(
+					"EstimateFeePerKW", uint32(6),
+				).Return(nil, errDummy).Once()
+
+				return estimator
+			},
+		},
+		{
+			name: "no estimator",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			p, m := newTestPaymentLifecycle(t)
+
+			if tc.setupEstimator != nil {
+				estimator := tc.setupEstimator()
+				p.router.cfg.FeeEstimator = estimator
+				t.Cleanup(func() {
+					estimator.AssertExpectations(t)
+				})
+			}
+
+			attemptID := uint64(1)
+			p.router.cfg.NextPaymentID = func() (uint64, error) {
+				return attemptID, nil
+			}
+
+			m.shardTracker.On("NewShard",
+				attemptID, true,
+			).Return(m.shard, nil).Once()
+
+			m.shard.On("MPP").Return(&record.MPP{}).Twice().
+				On("AMP").Return(nil).Once().
+				On("Hash").Return(p.identifier).Once()
+
+			m.clock.On("Now").Return(time.Now()).Once()
+
+			rt := createDummyRoute(t, 10000)
+			attempt, err := p.createNewPaymentAttempt(rt, true)
+			require.NoError(t, err)
+			require.Equal(
+				t, tc.expectedFeeRate, attempt.ChainFeeRate,
+			)
+		})
+	}
+}
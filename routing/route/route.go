@@ -563,6 +563,17 @@ func (r *Route) HopFee(hopIndex int) lnwire.MilliSatoshi {
 	}
 }
 
+// PerHopFees returns the fee charged by each hop of the route, as computed by
+// HopFee, in the order of the hops. The fees always add up to TotalFees.
+func (r *Route) PerHopFees() []lnwire.MilliSatoshi {
+	fees := make([]lnwire.MilliSatoshi, len(r.Hops))
+	for i := range r.Hops {
+		fees[i] = r.HopFee(i)
+	}
+
+	return fees
+}
+
 // TotalFees is the sum of the fees paid at each hop within the final route. In
 // the case of a one-hop payment, this value will be zero as we don't need to
 // pay a fee to ourself.
@@ -84,6 +84,32 @@ func TestRouteTotalFees(t *testing.T) {
 	}
 }
 
+// TestRoutePerHopFees checks that the fees of the hops of a route add up to
+// its total fee.
+func TestRoutePerHopFees(t *testing.T) {
+	t.Parallel()
+
+	require.Empty(t, (&Route{}).PerHopFees())
+
+	r := &Route{
+		TotalAmount: 1030,
+		Hops: []*Hop{
+			{ChannelID: 1, AmtToForward: 1020},
+			{ChannelID: 2, AmtToForward: 1000},
+			{ChannelID: 3, AmtToForward: 1000},
+		},
+	}
+
+	fees := r.PerHopFees()
+	require.Equal(t, []lnwire.MilliSatoshi{10, 20, 0}, fees)
+
+	var total lnwire.MilliSatoshi
+	for _, fee := range fees {
+		total += fee
+	}
+	require.Equal(t, r.TotalFees(), total)
+}
+
 var (
 	testAmt  = lnwire.MilliSatoshi(1000)
 	testAddr = [32]byte{0x01, 0x02}
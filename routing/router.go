@@ -2,6 +2,7 @@ package routing
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"math"
 	"runtime"
@@ -29,6 +30,7 @@ import (
 	"github.com/lightningnetwork/lnd/lnutils"
 	"github.com/lightningnetwork/lnd/lnwallet"
 	"github.com/lightningnetwork/lnd/lnwallet/btcwallet"
+	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
 	"github.com/lightningnetwork/lnd/lnwallet/chanvalidate"
 	"github.com/lightningnetwork/lnd/lnwire"
 	"github.com/lightningnetwork/lnd/multimutex"
@@ -56,6 +58,14 @@ const (
 	// getting blocked by this job.
 	DefaultFirstTimePruneDelay = 30 * time.Second
 
+	// DefaultMaxConcurrentResumptions is the default maximum number of
+	// in-flight payments that are resumed concurrently on startup.
+	DefaultMaxConcurrentResumptions = 100
+
+	// attemptFeeRateConfTarget is the confirmation target of the on-chain
+	// fee estimate that is recorded with each htlc attempt.
+	attemptFeeRateConfTarget = 6
+
 	// defaultStatInterval governs how often the router will log non-empty
 	// stats related to processing new channels, updates, or node
 	// announcements.
@@ -409,6 +419,76 @@ type Config struct {
 	// IsAlias returns whether a passed ShortChannelID is an alias. This is
 	// only used for our local channels.
 	IsAlias func(scid lnwire.ShortChannelID) bool
+
+	// AttemptLookup is used to check whether the switch still knows about
+	// the in-flight htlc attempts of our payments.
+	AttemptLookup AttemptLookup
+
+	// CheckStaleAttempts, if true, makes the router report in-flight htlc
+	// attempts that are unknown to the switch on startup.
+	CheckStaleAttempts bool
+
+	// MaxConcurrentResumptions is the maximum number of in-flight
+	// payments that are resumed concurrently on startup. The resumption of
+	// further payments waits until one of them completes. If zero,
+	// DefaultMaxConcurrentResumptions is used.
+	MaxConcurrentResumptions int
+
+	// FeeEstimator, if set, is used to record the node's on-chain fee
+	// estimate with each htlc attempt.
+	FeeEstimator chainfee.Estimator
+}
+
+// chainFeeRate returns the node's current on-chain fee estimate to record
+// with a new htlc attempt. Zero is returned if no estimate is available. The
+// estimate is cached until the next block arrives. While it's refreshed, the
+// estimate of the previous block is returned, so that attempts don't wait for
+// a slow estimator.
+func (r *ChannelRouter) chainFeeRate() chainfee.SatPerKWeight {
+	if r.cfg.FeeEstimator == nil {
+		return 0
+	}
+
+	height := atomic.LoadUint32(&r.bestHeight)
+
+	r.feeRateMtx.Lock()
+	if (r.feeRateCached && r.feeRateHeight == height) ||
+		r.feeRateRefreshing {
+
+		feeRate := r.feeRate
+		r.feeRateMtx.Unlock()
+
+		return feeRate
+	}
+	r.feeRateRefreshing = true
+	r.feeRateMtx.Unlock()
+
+	// The estimator may be slow, so it's queried without holding the
+	// lock. A failed estimate is cached as well, so an unavailable
+	// estimator isn't queried again until the next block.
+	feeRate, err := r.cfg.FeeEstimator.EstimateFeePerKW(
+		attemptFeeRateConfTarget,
+	)
+	if err != nil {
+		log.Debugf("Unable to estimate chain fee rate for htlc "+
+			"attempts: %v", err)
+
+		feeRate = 0
+	}
+
+	r.feeRateMtx.Lock()
+	defer r.feeRateMtx.Unlock()
+
+	r.feeRateRefreshing = false
+
+	// Don't replace an estimate of a later block.
+	if !r.feeRateCached || r.feeRateHeight < height {
+		r.feeRate = feeRate
+		r.feeRateHeight = height
+		r.feeRateCached = true
+	}
+
+	return feeRate
 }
 
 // EdgeLocator is a struct used to identify a specific edge.
@@ -493,6 +573,38 @@ type ChannelRouter struct {
 	// announcements over a window of defaultStatInterval.
 	stats *routerStats
 
+	// activeLifecycles maps the identifiers of the payments that are
+	// currently being sent to their payment lifecycle.
+	activeLifecycles lnutils.SyncMap[lntypes.Hash, *paymentLifecycle]
+
+	// pausedResumptions maps the identifiers of the payments that wait
+	// for a free slot to be resumed at startup to the time they started
+	// waiting.
+	pausedResumptions lnutils.SyncMap[lntypes.Hash, time.Time]
+
+	// dispatchingAttempts holds the IDs of the htlc attempts that are
+	// registered with the control tower, but haven't been handed to the
+	// switch yet. Such attempts are never considered stale.
+	dispatchingAttempts lnutils.SyncMap[uint64, struct{}]
+
+	// abandonedAttempts maps the IDs of the htlc attempts whose results
+	// are being waited for to the channel an abandoned attempt is
+	// delivered on.
+	abandonedAttempts lnutils.SyncMap[uint64,
+		chan *channeldb.HTLCAttempt]
+
+	// feeRate is the on-chain fee estimate recorded with new htlc
+	// attempts. It was estimated at feeRateHeight, if feeRateCached is
+	// set, and is only refreshed once a new block arrives, so the
+	// estimator isn't queried for every attempt. feeRateRefreshing is set
+	// while the estimator is queried. The fields are guarded by
+	// feeRateMtx.
+	feeRate           chainfee.SatPerKWeight
+	feeRateHeight     uint32
+	feeRateCached     bool
+	feeRateRefreshing bool
+	feeRateMtx        sync.Mutex
+
 	quit chan struct{}
 	wg   sync.WaitGroup
 }
@@ -643,93 +755,164 @@ func (r *ChannelRouter) Start() error {
 	}
 
 	// If any payments are still in flight, we resume, to make sure their
-	// results are properly handled.
-	payments, err := r.cfg.Control.FetchInFlightPayments()
-	if err != nil {
-		return err
-	}
+	// results are properly handled. Fetching them requires a scan of all
+	// payments, which is aborted if the router is stopped in the meantime,
+	// so a large payments database doesn't hold up the shutdown.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	// Before we restart existing payments and start accepting more
-	// payments to be made, we clean the network result store of the
-	// Switch. We do this here at startup to ensure no more payments can be
-	// made concurrently, so we know the toKeep map will be up-to-date
-	// until the cleaning has finished.
-	toKeep := make(map[uint64]struct{})
-	for _, p := range payments {
-		for _, a := range p.HTLCs {
-			toKeep[a.AttemptID] = struct{}{}
+	go func() {
+		select {
+		case <-r.quit:
+			cancel()
+		case <-ctx.Done():
 		}
-	}
+	}()
 
-	log.Debugf("Cleaning network result store.")
-	if err := r.cfg.Payer.CleanStore(toKeep); err != nil {
+	if err := r.resumePayments(ctx); err != nil {
 		return err
 	}
 
-	for _, payment := range payments {
-		log.Infof("Resuming payment %v", payment.Info.PaymentIdentifier)
-		r.wg.Add(1)
-		go func(payment *channeldb.MPPayment) {
-			defer r.wg.Done()
+	r.wg.Add(1)
+	go r.networkHandler()
 
-			// Get the hashes used for the outstanding HTLCs.
-			htlcs := make(map[uint64]lntypes.Hash)
-			for _, a := range payment.HTLCs {
-				a := a
-
-				// We check whether the individual attempts
-				// have their HTLC hash set, if not we'll fall
-				// back to the overall payment hash.
-				hash := payment.Info.PaymentIdentifier
-				if a.Hash != nil {
-					hash = *a.Hash
+	return nil
+}
+
+// resumePayments resumes the payments that are still in flight, to make sure
+// their results are properly handled. The payments are resumed as they are
+// read from the control tower page by page, so resumption doesn't wait until
+// all of them have been read. At most MaxConcurrentResumptions payments are
+// resumed concurrently. Once all payments have been read, the network result
+// store of the switch is cleaned.
+func (r *ChannelRouter) resumePayments(ctx context.Context) error {
+	maxResumptions := r.cfg.MaxConcurrentResumptions
+	if maxResumptions <= 0 {
+		maxResumptions = DefaultMaxConcurrentResumptions
+	}
+	resumptions := make(chan struct{}, maxResumptions)
+
+	// Only the attempt IDs of the payments are kept around, to clean the
+	// switch's result store once all payments have been read.
+	toKeep := make(map[uint64]struct{})
+	err := r.cfg.Control.ForEachInFlightPayment(ctx,
+		func(payment *channeldb.MPPayment) error {
+			// Report any in-flight attempts the switch doesn't
+			// know about. No new attempts are dispatched before
+			// we're started.
+			if r.cfg.CheckStaleAttempts {
+				stale, err := r.findStaleAttempts(payment)
+				if err != nil {
+					return err
 				}
 
-				htlcs[a.AttemptID] = hash
+				for _, a := range stale {
+					log.Warnf("In-flight attempt %v of "+
+						"payment %v has no circuit or "+
+						"result in the switch",
+						a.AttemptID,
+						a.PaymentIdentifier)
+				}
 			}
 
-			// Since we are not supporting creating more shards
-			// after a restart (only receiving the result of the
-			// shards already outstanding), we create a simple
-			// shard tracker that will map the attempt IDs to
-			// hashes used for the HTLCs. This will be enough also
-			// for AMP payments, since we only need the hashes for
-			// the individual HTLCs to regenerate the circuits, and
-			// we don't currently persist the root share necessary
-			// to re-derive them.
-			shardTracker := shards.NewSimpleShardTracker(
-				payment.Info.PaymentIdentifier, htlcs,
-			)
-
-			// We create a dummy, empty payment session such that
-			// we won't make another payment attempt when the
-			// result for the in-flight attempt is received.
-			paySession := r.cfg.SessionSource.NewPaymentSessionEmpty()
-
-			// We pass in a zero timeout value, to indicate we
-			// don't need it to timeout. It will stop immediately
-			// after the existing attempt has finished anyway. We
-			// also set a zero fee limit, as no more routes should
-			// be tried.
-			_, _, err := r.sendPayment(
-				0, payment.Info.PaymentIdentifier, 0,
-				paySession, shardTracker,
-			)
-			if err != nil {
-				log.Errorf("Resuming payment %v failed: %v.",
-					payment.Info.PaymentIdentifier, err)
-				return
+			for _, a := range payment.HTLCs {
+				toKeep[a.AttemptID] = struct{}{}
 			}
 
-			log.Infof("Resumed payment %v completed.",
+			log.Infof("Resuming payment %v",
 				payment.Info.PaymentIdentifier)
-		}(payment)
+
+			// Until a resumption slot is free, the payment is
+			// reported as paused.
+			paymentID := payment.Info.PaymentIdentifier
+			r.pausedResumptions.Store(paymentID, time.Now())
+
+			r.wg.Add(1)
+			go func() {
+				defer r.wg.Done()
+
+				select {
+				case resumptions <- struct{}{}:
+					r.pausedResumptions.Delete(paymentID)
+
+				case <-r.quit:
+					r.pausedResumptions.Delete(paymentID)
+					return
+				}
+				defer func() { <-resumptions }()
+
+				r.resumePayment(payment)
+			}()
+
+			return nil
+		},
+	)
+	if err != nil {
+		return err
 	}
 
-	r.wg.Add(1)
-	go r.networkHandler()
+	// Before we start accepting more payments to be made, we clean the
+	// network result store of the Switch. We do this here at startup to
+	// ensure no more payments can be made concurrently, so we know the
+	// toKeep map will be up-to-date until the cleaning has finished. The
+	// resumed payments don't make new attempts, and the results of their
+	// attempts are kept.
+	log.Debugf("Cleaning network result store.")
 
-	return nil
+	return r.cfg.Payer.CleanStore(toKeep)
+}
+
+// resumePayment resumes the given in-flight payment, waiting for the results
+// of its outstanding attempts without making new ones.
+func (r *ChannelRouter) resumePayment(payment *channeldb.MPPayment) {
+	// Get the hashes used for the outstanding HTLCs.
+	htlcs := make(map[uint64]lntypes.Hash)
+	for _, a := range payment.HTLCs {
+		a := a
+
+		// We check whether the individual attempts have their HTLC
+		// hash set, if not we'll fall back to the overall payment
+		// hash.
+		hash := payment.Info.PaymentIdentifier
+		if a.Hash != nil {
+			hash = *a.Hash
+		}
+
+		htlcs[a.AttemptID] = hash
+	}
+
+	// Since we are not supporting creating more shards after a restart
+	// (only receiving the result of the shards already outstanding), we
+	// create a simple shard tracker that will map the attempt IDs to
+	// hashes used for the HTLCs. This will be enough also for AMP
+	// payments, since we only need the hashes for the individual HTLCs to
+	// regenerate the circuits, and we don't currently persist the root
+	// share necessary to re-derive them.
+	shardTracker := shards.NewSimpleShardTracker(
+		payment.Info.PaymentIdentifier, htlcs,
+	)
+
+	// We create a dummy, empty payment session such that we won't make
+	// another payment attempt when the result for the in-flight attempt is
+	// received.
+	paySession := r.cfg.SessionSource.NewPaymentSessionEmpty()
+
+	// We pass in a zero timeout value, to indicate we don't need it to
+	// timeout. It will stop immediately after the existing attempt has
+	// finished anyway. We also set a zero fee limit, as no more routes
+	// should be tried.
+	_, _, err := r.sendPayment(
+		0, payment.Info.PaymentIdentifier, 0, paySession, shardTracker,
+		time.Time{},
+	)
+	if err != nil {
+		log.Errorf("Resuming payment %v failed: %v.",
+			payment.Info.PaymentIdentifier, err)
+		return
+	}
+
+	log.Infof("Resumed payment %v completed.",
+		payment.Info.PaymentIdentifier)
 }
 
 // Stop signals the ChannelRouter to gracefully halt all routines. This method
@@ -2252,6 +2435,10 @@ type LightningPayment struct {
 	// payment.
 	amp *AMPOptions
 
+	// initCommitted is the time the payment's initialization was
+	// committed to the database by PreparePayment.
+	initCommitted time.Time
+
 	// FinalCLTVDelta is the CTLV expiry delta to use for the _final_ hop
 	// in the route. This means that the final hop will have a CLTV delta
 	// of at least: currentHeight + FinalCLTVDelta.
@@ -2326,6 +2513,20 @@ type LightningPayment struct {
 	// Metadata is additional data that is sent along with the payment to
 	// the payee.
 	Metadata []byte
+
+	// Label is an optional user-supplied label that is stored with the
+	// payment.
+	Label string
+
+	// MaxRecordedAttempts is the maximum number of htlc attempts that are
+	// recorded for the payment, after which the oldest failed attempts
+	// are dropped. Zero means that the number isn't limited.
+	MaxRecordedAttempts uint32
+
+	// ClientReference is an optional reference supplied by the client that
+	// is stored with the payment. No two payments can carry the same
+	// reference.
+	ClientReference []byte
 }
 
 // AMPOptions houses information that must be known in order to send an AMP
@@ -2391,6 +2592,7 @@ func (r *ChannelRouter) SendPayment(payment *LightningPayment) ([32]byte,
 	return r.sendPayment(
 		payment.FeeLimit, payment.Identifier(),
 		payment.PayAttemptTimeout, paySession, shardTracker,
+		payment.initCommitted,
 	)
 }
 
@@ -2411,6 +2613,7 @@ func (r *ChannelRouter) SendPaymentAsync(payment *LightningPayment,
 		_, _, err := r.sendPayment(
 			payment.FeeLimit, payment.Identifier(),
 			payment.PayAttemptTimeout, ps, st,
+			payment.initCommitted,
 		)
 		if err != nil {
 			log.Errorf("Payment %x failed: %v",
@@ -2436,6 +2639,11 @@ func spewPayment(payment *LightningPayment) logClosure {
 		}
 		p := *payment
 		p.RouteHints = routeHints
+
+		// The payment address is only known to us and the receiver,
+		// so we keep it out of the logs.
+		p.PaymentAddr = nil
+
 		return spew.Sdump(p)
 	})
 }
@@ -2458,10 +2666,15 @@ func (r *ChannelRouter) PreparePayment(payment *LightningPayment) (
 	//
 	// TODO(roasbeef): store records as part of creation info?
 	info := &channeldb.PaymentCreationInfo{
-		PaymentIdentifier: payment.Identifier(),
-		Value:             payment.Amount,
-		CreationTime:      r.cfg.Clock.Now(),
-		PaymentRequest:    payment.PaymentRequest,
+		PaymentIdentifier:   payment.Identifier(),
+		Value:               payment.Amount,
+		CreationTime:        r.cfg.Clock.Now(),
+		PaymentRequest:      payment.PaymentRequest,
+		FeeLimit:            payment.FeeLimit,
+		Label:               payment.Label,
+		PaymentAddr:         payment.PaymentAddr,
+		MaxRecordedAttempts: payment.MaxRecordedAttempts,
+		ClientReference:     payment.ClientReference,
 	}
 
 	// Create a new ShardTracker that we'll use during the life cycle of
@@ -2488,6 +2701,10 @@ func (r *ChannelRouter) PreparePayment(payment *LightningPayment) (
 		return nil, nil, err
 	}
 
+	// Note the time the payment was initialized, using the same clock the
+	// payment lifecycle uses for its timings.
+	payment.initCommitted = time.Now()
+
 	return paySession, shardTracker, nil
 }
 
@@ -2552,6 +2769,7 @@ func (r *ChannelRouter) sendToRoute(htlcHash lntypes.Hash, rt *route.Route,
 		Value:             amt,
 		CreationTime:      r.cfg.Clock.Now(),
 		PaymentRequest:    nil,
+		FeeLimit:          channeldb.NoFeeLimit,
 	}
 
 	err := r.cfg.Control.InitPayment(paymentIdentifier, info)
@@ -2607,8 +2825,9 @@ func (r *ChannelRouter) sendToRoute(htlcHash lntypes.Hash, rt *route.Route,
 		return nil, err
 	}
 
-	// We now lookup the payment to see if it's already failed.
-	payment, err := p.router.cfg.Control.FetchPayment(p.identifier)
+	// We now lookup the payment to see if it's already failed. Only the
+	// state is needed, so the attempts of the payment aren't decoded.
+	state, _, err := p.router.cfg.Control.FetchPaymentState(p.identifier)
 	if err != nil {
 		return result.attempt, err
 	}
@@ -2616,8 +2835,7 @@ func (r *ChannelRouter) sendToRoute(htlcHash lntypes.Hash, rt *route.Route,
 	// Exit if the above error has caused the payment to be failed, we also
 	// return the error from sending attempt to mimic the old behavior of
 	// this method.
-	_, failedReason := payment.TerminalInfo()
-	if failedReason != nil {
+	if state.PaymentFailed {
 		return result.attempt, result.err
 	}
 
@@ -2635,7 +2853,7 @@ func (r *ChannelRouter) sendToRoute(htlcHash lntypes.Hash, rt *route.Route,
 		}
 
 		// Otherwise we need to fail the payment.
-		err := r.cfg.Control.FailPayment(paymentIdentifier, reason)
+		err := r.failPayment(paymentIdentifier, reason, nil)
 		if err != nil {
 			return nil, err
 		}
@@ -2658,7 +2876,7 @@ func (r *ChannelRouter) sendToRoute(htlcHash lntypes.Hash, rt *route.Route,
 	// An error returned from collecting the result, we'll mark the payment
 	// as failed if we don't skip temp error.
 	if !skipTempErr {
-		err := r.cfg.Control.FailPayment(paymentIdentifier, reason)
+		err := r.failPayment(paymentIdentifier, reason, nil)
 		if err != nil {
 			return nil, err
 		}
@@ -2667,6 +2885,25 @@ func (r *ChannelRouter) sendToRoute(htlcHash lntypes.Hash, rt *route.Route,
 	return result.attempt, result.err
 }
 
+// failPayment fails the payment with the given reason and optional failure
+// detail. A payment that already failed with another reason, for example
+// because it timed out while its last attempts were in flight, keeps its first
+// reason, which isn't an error for the caller.
+func (r *ChannelRouter) failPayment(identifier lntypes.Hash,
+	reason channeldb.FailureReason,
+	detail *channeldb.PaymentFailureDetail) error {
+
+	err := r.cfg.Control.FailPayment(identifier, reason, detail)
+	if errors.Is(err, channeldb.ErrPaymentAlreadyFailed) {
+		log.Debugf("Payment %v already failed, not failing it with "+
+			"reason %v", identifier, reason)
+
+		return nil
+	}
+
+	return err
+}
+
 // sendPayment attempts to send a payment to the passed payment hash. This
 // function is blocking and will return either: when the payment is successful,
 // or all candidates routes have been attempted and resulted in a failed
@@ -2678,11 +2915,13 @@ func (r *ChannelRouter) sendToRoute(htlcHash lntypes.Hash, rt *route.Route,
 // This method relies on the ControlTower's internal payment state machine to
 // carry out its execution. After restarts it is safe, and assumed, that the
 // router will call this method for every payment still in-flight according to
-// the ControlTower.
+// the ControlTower. The initCommitted time is zero for resumed payments. If the
+// payment is already being sent, the outcome of the active lifecycle is
+// returned instead of starting a second one.
 func (r *ChannelRouter) sendPayment(feeLimit lnwire.MilliSatoshi,
 	identifier lntypes.Hash, timeout time.Duration,
-	paySession PaymentSession,
-	shardTracker shards.ShardTracker) ([32]byte, *route.Route, error) {
+	paySession PaymentSession, shardTracker shards.ShardTracker,
+	initCommitted time.Time) ([32]byte, *route.Route, error) {
 
 	// We'll also fetch the current block height so we can properly
 	// calculate the required HTLC time locks within the route.
@@ -2697,8 +2936,64 @@ func (r *ChannelRouter) sendPayment(feeLimit lnwire.MilliSatoshi,
 		r, feeLimit, identifier, paySession,
 		shardTracker, timeout, currentHeight,
 	)
+	p.tracker.setInitCommitted(initCommitted)
+
+	// Register the lifecycle, which also makes it available for inspection
+	// while it is running. At most one lifecycle may be active for a
+	// payment, as two lifecycles racing to register attempts would break
+	// the MPP invariants. This can happen if a new send of a payment races
+	// with its resumption at startup. In that case we attach to the
+	// lifecycle that is already active and return its outcome.
+	active, loaded := r.activeLifecycles.LoadOrStore(identifier, p)
+	if loaded {
+		log.Infof("Payment %v is already being sent, waiting for its "+
+			"result", identifier)
 
-	return p.resumePayment()
+		select {
+		case <-active.exited:
+			return active.preimage, active.route, active.err
+
+		case <-r.quit:
+			return [32]byte{}, nil, ErrRouterShuttingDown
+		}
+	}
+
+	// The outcome of the lifecycle is recorded before it's unregistered,
+	// so callers that attached to it can always read it.
+	defer func() {
+		close(p.exited)
+		r.activeLifecycles.Delete(identifier)
+	}()
+
+	p.preimage, p.route, p.err = p.resumePayment()
+
+	return p.preimage, p.route, p.err
+}
+
+// GetPaymentLifecycleState returns a snapshot of the internal state of the
+// payment lifecycle that is currently sending the payment with the given
+// identifier. A payment that waits to be resumed is reported as paused by
+// backpressure. ErrPaymentLifecycleNotFound is returned if the payment isn't
+// actively being sent.
+func (r *ChannelRouter) GetPaymentLifecycleState(
+	identifier lntypes.Hash) (*PaymentLifecycleState, error) {
+
+	if p, ok := r.activeLifecycles.Load(identifier); ok {
+		return p.tracker.snapshot(), nil
+	}
+
+	since, ok := r.pausedResumptions.Load(identifier)
+	if !ok {
+		return nil, ErrPaymentLifecycleNotFound
+	}
+
+	return &PaymentLifecycleState{
+		Step: LifecycleStepPausedByBackpressure,
+		Transitions: []LifecycleTransition{{
+			Step: LifecycleStepPausedByBackpressure,
+			Time: since,
+		}},
+	}, nil
 }
 
 // extractChannelUpdate examines the error and extracts the channel update.
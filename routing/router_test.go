@@ -2,6 +2,8 @@ package routing
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"image/color"
 	"math"
@@ -25,9 +27,11 @@ import (
 	lnmock "github.com/lightningnetwork/lnd/lntest/mock"
 	"github.com/lightningnetwork/lnd/lntest/wait"
 	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/lightningnetwork/lnd/lnwallet/chainfee"
 	"github.com/lightningnetwork/lnd/lnwire"
 	"github.com/lightningnetwork/lnd/record"
 	"github.com/lightningnetwork/lnd/routing/route"
+	"github.com/lightningnetwork/lnd/routing/shards"
 	"github.com/lightningnetwork/lnd/zpay32"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -365,6 +369,116 @@ func TestSendPaymentRouteFailureFallback(t *testing.T) {
 	)
 }
 
+// TestSendPaymentSingleLifecycle checks that at most one payment lifecycle is
+// active per payment. A send that races with the lifecycle already sending the
+// payment, for instance one resumed at startup, attaches to that lifecycle
+// instead of registering attempts of its own.
+func TestSendPaymentSingleLifecycle(t *testing.T) {
+	t.Parallel()
+
+	const startingBlockHeight = 101
+	ctx := createTestCtxFromFile(t, startingBlockHeight, basicGraphFilePath)
+
+	paymentAmt := lnwire.NewMSatFromSatoshis(1000)
+	payment := createDummyLightningPayment(
+		t, ctx.aliases["sophon"], paymentAmt,
+	)
+
+	var preImage [32]byte
+	copy(preImage[:], bytes.Repeat([]byte{9}, 32))
+
+	// Hold back every attempt in the switch until we release it, so the
+	// second send races with an active lifecycle.
+	dispatched := make(chan struct{}, 2)
+	release := make(chan struct{})
+	ctx.router.cfg.Payer.(*mockPaymentAttemptDispatcherOld).setPaymentResult(
+		func(firstHop lnwire.ShortChannelID) ([32]byte, error) {
+			dispatched <- struct{}{}
+			<-release
+
+			return preImage, nil
+		})
+
+	type sendResult struct {
+		preimage [32]byte
+		route    *route.Route
+		err      error
+	}
+
+	send := func(ps PaymentSession,
+		st shards.ShardTracker) <-chan *sendResult {
+
+		resultChan := make(chan *sendResult, 1)
+		go func() {
+			preimage, rt, err := ctx.router.sendPayment(
+				payment.FeeLimit, payment.Identifier(), 0, ps,
+				st, time.Time{},
+			)
+			resultChan <- &sendResult{preimage, rt, err}
+		}()
+
+		return resultChan
+	}
+
+	// Start the first lifecycle, as is done when a payment is resumed,
+	// and wait for its attempt to reach the switch.
+	paySession, shardTracker, err := ctx.router.PreparePayment(payment)
+	require.NoError(t, err)
+
+	first := send(paySession, shardTracker)
+
+	select {
+	case <-dispatched:
+	case <-time.After(testTimeout):
+		t.Fatal("attempt not dispatched")
+	}
+
+	// Send the same payment again with a fresh payment session, which
+	// would register new attempts if it got a lifecycle of its own.
+	paySession, err = ctx.router.cfg.SessionSource.NewPaymentSession(
+		payment,
+	)
+	require.NoError(t, err)
+
+	second := send(
+		paySession,
+		shards.NewSimpleShardTracker(payment.Identifier(), nil),
+	)
+
+	// The second send must not dispatch an attempt.
+	select {
+	case <-dispatched:
+		t.Fatal("second lifecycle dispatched an attempt")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Once the attempt is released, both sends return the outcome of the
+	// first lifecycle.
+	close(release)
+
+	for _, resultChan := range []<-chan *sendResult{first, second} {
+		select {
+		case result := <-resultChan:
+			require.NoError(t, result.err)
+			require.Equal(t, preImage, result.preimage)
+			require.NotNil(t, result.route)
+
+		case <-time.After(testTimeout):
+			t.Fatal("send didn't return")
+		}
+	}
+
+	// Only the attempt of the first lifecycle was registered.
+	dbPayment, err := ctx.router.cfg.Control.FetchPayment(
+		payment.Identifier(),
+	)
+	require.NoError(t, err)
+	require.Len(t, dbPayment.GetHTLCs(), 1)
+
+	_, err = ctx.router.GetPaymentLifecycleState(payment.Identifier())
+	require.ErrorIs(t, err, ErrPaymentLifecycleNotFound)
+}
+
 // TestSendPaymentRouteInfiniteLoopWithBadHopHint tests that when sending
 // a payment with a malformed hop hint in the first hop, the hint is ignored
 // and the payment succeeds without an infinite loop of retries.
@@ -3521,12 +3635,11 @@ func TestSendToRouteSkipTempErrSuccess(t *testing.T) {
 		mock.Anything, rt,
 	).Return(nil)
 
-	// Mock the control tower to return the mocked payment.
-	payment := &mockMPPayment{}
-	controlTower.On("FetchPayment", payHash).Return(payment, nil).Once()
-
-	// Mock the payment to return nil failure reason.
-	payment.On("TerminalInfo").Return(nil, nil)
+	// Mock the control tower to return the state of the payment.
+	state := &channeldb.MPPaymentState{}
+	controlTower.On("FetchPaymentState", payHash).Return(
+		state, channeldb.StatusInFlight, nil,
+	).Once()
 
 	// Expect a successful send to route.
 	attempt, err := router.SendToRouteSkipTempErr(payHash, rt)
@@ -3537,7 +3650,6 @@ func TestSendToRouteSkipTempErrSuccess(t *testing.T) {
 	controlTower.AssertExpectations(t)
 	payer.AssertExpectations(t)
 	missionControl.AssertExpectations(t)
-	payment.AssertExpectations(t)
 }
 
 // TestSendToRouteSkipTempErrNonMPP checks that an error is return when
@@ -3650,9 +3762,11 @@ func TestSendToRouteSkipTempErrTempFailure(t *testing.T) {
 		mock.Anything, mock.Anything, mock.Anything,
 	).Return(tempErr)
 
-	// Mock the control tower to return the mocked payment.
-	payment := &mockMPPayment{}
-	controlTower.On("FetchPayment", payHash).Return(payment, nil).Once()
+	// Mock the control tower to return the state of the payment.
+	state := &channeldb.MPPaymentState{}
+	controlTower.On("FetchPaymentState", payHash).Return(
+		state, channeldb.StatusInFlight, nil,
+	).Once()
 
 	// Mock the mission control to return a nil reason from reporting the
 	// attempt failure.
@@ -3660,9 +3774,6 @@ func TestSendToRouteSkipTempErrTempFailure(t *testing.T) {
 		mock.Anything, rt, mock.Anything, mock.Anything,
 	).Return(nil, nil)
 
-	// Mock the payment to return nil failure reason.
-	payment.On("TerminalInfo").Return(nil, nil)
-
 	// Expect a failed send to route.
 	attempt, err := router.SendToRouteSkipTempErr(payHash, rt)
 	require.Equal(t, tempErr, err)
@@ -3672,7 +3783,6 @@ func TestSendToRouteSkipTempErrTempFailure(t *testing.T) {
 	controlTower.AssertExpectations(t)
 	payer.AssertExpectations(t)
 	missionControl.AssertExpectations(t)
-	payment.AssertExpectations(t)
 }
 
 // TestSendToRouteSkipTempErrPermanentFailure validates a permanent failure
@@ -3730,7 +3840,9 @@ func TestSendToRouteSkipTempErrPermanentFailure(t *testing.T) {
 	).Return(testAttempt, nil)
 
 	// Expect the payment to be failed.
-	controlTower.On("FailPayment", payHash, mock.Anything).Return(nil)
+	controlTower.On("FailPayment",
+		payHash, mock.Anything, mock.Anything,
+	).Return(nil)
 
 	// Mock an error to be returned from sending the htlc.
 	payer.On("SendHTLC",
@@ -3742,12 +3854,14 @@ func TestSendToRouteSkipTempErrPermanentFailure(t *testing.T) {
 		mock.Anything, rt, mock.Anything, mock.Anything,
 	).Return(&failureReason, nil)
 
-	// Mock the control tower to return the mocked payment.
-	payment := &mockMPPayment{}
-	controlTower.On("FetchPayment", payHash).Return(payment, nil).Once()
+	// Mock the control tower to return the state of the payment.
+	state := &channeldb.MPPaymentState{}
+	controlTower.On("FetchPaymentState", payHash).Return(
+		state, channeldb.StatusInFlight, nil,
+	).Once()
 
-	// Mock the payment to return a failure reason.
-	payment.On("TerminalInfo").Return(nil, &failureReason)
+	// Mock the payment to be failed.
+	state.PaymentFailed = true
 
 	// Expect a failed send to route.
 	attempt, err := router.SendToRouteSkipTempErr(payHash, rt)
@@ -3758,7 +3872,6 @@ func TestSendToRouteSkipTempErrPermanentFailure(t *testing.T) {
 	controlTower.AssertExpectations(t)
 	payer.AssertExpectations(t)
 	missionControl.AssertExpectations(t)
-	payment.AssertExpectations(t)
 }
 
 // TestSendToRouteTempFailure validates a temporary failure will cause the
@@ -3815,18 +3928,19 @@ func TestSendToRouteTempFailure(t *testing.T) {
 	).Return(testAttempt, nil)
 
 	// Expect the payment to be failed.
-	controlTower.On("FailPayment", payHash, mock.Anything).Return(nil)
+	controlTower.On("FailPayment",
+		payHash, mock.Anything, mock.Anything,
+	).Return(nil)
 
 	payer.On("SendHTLC",
 		mock.Anything, mock.Anything, mock.Anything,
 	).Return(tempErr)
 
-	// Mock the control tower to return the mocked payment.
-	payment := &mockMPPayment{}
-	controlTower.On("FetchPayment", payHash).Return(payment, nil).Once()
-
-	// Mock the payment to return nil failure reason.
-	payment.On("TerminalInfo").Return(nil, nil)
+	// Mock the control tower to return the state of the payment.
+	state := &channeldb.MPPaymentState{}
+	controlTower.On("FetchPaymentState", payHash).Return(
+		state, channeldb.StatusInFlight, nil,
+	).Once()
 
 	// Return a nil reason to mock a temporary failure.
 	missionControl.On("ReportPaymentFail",
@@ -3842,7 +3956,6 @@ func TestSendToRouteTempFailure(t *testing.T) {
 	controlTower.AssertExpectations(t)
 	payer.AssertExpectations(t)
 	missionControl.AssertExpectations(t)
-	payment.AssertExpectations(t)
 }
 
 // TestNewRouteRequest tests creation of route requests for blinded and
@@ -3996,3 +4109,210 @@ func TestNewRouteRequest(t *testing.T) {
 		})
 	}
 }
+
+// pagedControlTower is a control tower that serves its in-flight payments in
+// pages, only serving the last page once released.
+type pagedControlTower struct {
+	ControlTower
+
+	pages   [][]*channeldb.MPPayment
+	release chan struct{}
+
+	// resumed receives the hash of every payment whose lifecycle fetched
+	// it from the control tower.
+	resumed chan lntypes.Hash
+}
+
+func (c *pagedControlTower) ForEachInFlightPayment(_ context.Context,
+	cb func(*channeldb.MPPayment) error) error {
+
+	for i, page := range c.pages {
+		if i == len(c.pages)-1 {
+			<-c.release
+		}
+
+		for _, payment := range page {
+			if err := cb(payment); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// FetchPayment records the resumption of the payment and fails, which ends
+// its lifecycle right away.
+func (c *pagedControlTower) FetchPayment(hash lntypes.Hash) (dbMPPayment,
+	error) {
+
+	c.resumed <- hash
+
+	return nil, errors.New("payment unavailable")
+}
+
+// cleanStorePayer is a payer that records the attempt IDs it's asked to keep
+// when cleaning its store.
+type cleanStorePayer struct {
+	PaymentAttemptDispatcher
+
+	toKeep map[uint64]struct{}
+}
+
+func (c *cleanStorePayer) CleanStore(toKeep map[uint64]struct{}) error {
+	c.toKeep = toKeep
+	return nil
+}
+
+// TestResumePaymentsStreamed tests that the in-flight payments are resumed
+// while they are read page by page, and that each of them is resumed exactly
+// once.
+func TestResumePaymentsStreamed(t *testing.T) {
+	t.Parallel()
+
+	const (
+		numPages    = 3
+		perPage     = 2
+		numPayments = numPages * perPage
+	)
+
+	pages := make([][]*channeldb.MPPayment, numPages)
+	for i := 0; i < numPayments; i++ {
+		payment := &channeldb.MPPayment{
+			Info: &channeldb.PaymentCreationInfo{
+				PaymentIdentifier: lntypes.Hash{byte(i + 1)},
+			},
+			HTLCs: []channeldb.HTLCAttempt{{
+				HTLCAttemptInfo: channeldb.HTLCAttemptInfo{
+					AttemptID: uint64(i),
+				},
+			}},
+		}
+
+		page := i / perPage
+		pages[page] = append(pages[page], payment)
+	}
+
+	control := &pagedControlTower{
+		pages:   pages,
+		release: make(chan struct{}),
+		resumed: make(chan lntypes.Hash, numPayments),
+	}
+	payer := &cleanStorePayer{}
+	sessionSource := &mockPaymentSessionSourceOld{}
+
+	router := &ChannelRouter{
+		cfg: &Config{
+			Control:                  control,
+			Payer:                    payer,
+			Chain:                    newMockChain(0),
+			SessionSource:            sessionSource,
+			MaxConcurrentResumptions: 2,
+		},
+		quit: make(chan struct{}),
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- router.resumePayments(context.Background())
+	}()
+
+	// The payments of the first pages are resumed before the last page is
+	// served.
+	resumed := make(map[lntypes.Hash]int)
+	select {
+	case hash := <-control.resumed:
+		resumed[hash]++
+
+	case <-time.After(testTimeout):
+		t.Fatal("no payment resumed before the last page was served")
+	}
+
+	close(control.release)
+	select {
+	case err := <-errChan:
+		require.NoError(t, err)
+
+	case <-time.After(testTimeout):
+		t.Fatal("payments not read")
+	}
+
+	router.wg.Wait()
+	close(control.resumed)
+	for hash := range control.resumed {
+		resumed[hash]++
+	}
+
+	require.Len(t, resumed, numPayments)
+	for _, page := range pages {
+		for _, payment := range page {
+			hash := payment.Info.PaymentIdentifier
+			require.Equal(t, 1, resumed[hash], hash)
+
+			attemptID := payment.HTLCs[0].AttemptID
+			require.Contains(t, payer.toKeep, attemptID)
+		}
+	}
+}
+
+// TestChainFeeRateCache checks that the on-chain fee estimate recorded with
+// htlc attempts is only refreshed once a new block arrives.
+func TestChainFeeRateCache(t *testing.T) {
+	t.Parallel()
+
+	estimator := &chainfee.MockEstimator{}
+	r := &ChannelRouter{
+		cfg: &Config{
+			FeeEstimator: estimator,
+		},
+	}
+
+	// The first estimate is reused within the same block.
+	estimator.On("EstimateFeePerKW", uint32(6)).Return(
+		chainfee.SatPerKWeight(1000), nil,
+	).Once()
+	require.EqualValues(t, 1000, r.chainFeeRate())
+	require.EqualValues(t, 1000, r.chainFeeRate())
+
+	// A new block refreshes the estimate. A failed estimate is cached as
+	// well.
+	atomic.StoreUint32(&r.bestHeight, 1)
+	estimator.On("EstimateFeePerKW", uint32(6)).Return(
+		nil, errDummy,
+	).Once()
+	require.Zero(t, r.chainFeeRate())
+	require.Zero(t, r.chainFeeRate())
+
+	atomic.StoreUint32(&r.bestHeight, 2)
+	estimator.On("EstimateFeePerKW", uint32(6)).Return(
+		chainfee.SatPerKWeight(2000), nil,
+	).Once()
+	require.EqualValues(t, 2000, r.chainFeeRate())
+
+	// While a slow estimate is refreshed, other attempts don't wait for
+	// it but use the estimate of the previous block.
+	atomic.StoreUint32(&r.bestHeight, 3)
+	release := make(chan time.Time)
+	estimator.On("EstimateFeePerKW", uint32(6)).Return(
+		chainfee.SatPerKWeight(3000), nil,
+	).WaitUntil(release).Once()
+
+	refreshed := make(chan chainfee.SatPerKWeight, 1)
+	go func() {
+		refreshed <- r.chainFeeRate()
+	}()
+
+	require.Eventually(t, func() bool {
+		r.feeRateMtx.Lock()
+		defer r.feeRateMtx.Unlock()
+
+		return r.feeRateRefreshing
+	}, time.Second, 10*time.Millisecond)
+	require.EqualValues(t, 2000, r.chainFeeRate())
+
+	close(release)
+	require.EqualValues(t, 3000, <-refreshed)
+	require.EqualValues(t, 3000, r.chainFeeRate())
+
+	estimator.AssertExpectations(t)
+}
@@ -0,0 +1,264 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/lntypes"
+)
+
+var (
+	// ErrNoAttemptLookup is returned when stale attempts are requested but
+	// the router wasn't configured with a way to look up attempts in the
+	// switch.
+	ErrNoAttemptLookup = errors.New("no attempt lookup configured")
+
+	// ErrAttemptAbandoned is the result of an htlc attempt that was
+	// abandoned while its payment lifecycle was waiting for its result.
+	ErrAttemptAbandoned = errors.New("htlc attempt abandoned")
+)
+
+// AttemptLookup allows the router to query the switch's knowledge about the
+// htlc attempts it dispatched.
+type AttemptLookup interface {
+	// HasAttemptCircuit returns true if the switch has a circuit for the
+	// attempt with the given ID, meaning the htlc was dispatched and its
+	// result hasn't been stored yet.
+	HasAttemptCircuit(attemptID uint64) bool
+
+	// HasAttemptResult returns true if the result of the attempt with the
+	// given ID is available in the switch.
+	HasAttemptResult(attemptID uint64) (bool, error)
+}
+
+// StaleAttempt is an htlc attempt that is in flight according to the control
+// tower, but is unknown to the switch. Such an attempt can't be resolved
+// anymore, for example because its circuit was lost during a crash.
+type StaleAttempt struct {
+	// PaymentIdentifier is the identifier of the payment the attempt
+	// belongs to.
+	PaymentIdentifier lntypes.Hash
+
+	// AttemptID is the ID of the stale attempt.
+	AttemptID uint64
+
+	// AttemptTime is the time the attempt was created.
+	AttemptTime time.Time
+
+	// Failed is true if the attempt was marked as abandoned, which counts
+	// as failed.
+	Failed bool
+}
+
+// ReconcileStaleAttempts compares the in-flight htlc attempts recorded by the
+// control tower against the circuits and results of the switch and returns
+// the attempts the switch doesn't know about. If failStale is true, the stale
+// attempts are marked as abandoned, allowing their payments to make progress
+// again.
+func (r *ChannelRouter) ReconcileStaleAttempts(ctx context.Context,
+	failStale bool) ([]*StaleAttempt, error) {
+
+	var stale []*StaleAttempt
+	err := r.cfg.Control.ForEachInFlightPayment(ctx,
+		func(payment *channeldb.MPPayment) error {
+			s, err := r.findStaleAttempts(payment)
+			if err != nil {
+				return err
+			}
+
+			stale = append(stale, s...)
+
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Attempts may have been resolved since their payment was read, for
+	// example when they failed locally right before their dispatch marker
+	// was removed. Only report the ones that are still in flight.
+	if !failStale {
+		return r.filterInFlight(stale)
+	}
+
+	// The attempts are abandoned once all payments have been read, so
+	// the control tower isn't written to while it's being iterated.
+	abandoned := make([]*StaleAttempt, 0, len(stale))
+	for _, a := range stale {
+		log.Infof("Abandoning stale attempt %v of payment %v",
+			a.AttemptID, a.PaymentIdentifier)
+
+		attempt, err := r.cfg.Control.AbandonAttempt(
+			a.PaymentIdentifier, a.AttemptID,
+		)
+
+		// The attempt may have been resolved by the switch since it
+		// was read, in which case it wasn't stale after all.
+		switch {
+		case errors.Is(err, channeldb.ErrAttemptAlreadyFailed),
+			errors.Is(err, channeldb.ErrAttemptAlreadySettled):
+
+			log.Debugf("Attempt %v of payment %v was resolved "+
+				"while reconciling", a.AttemptID,
+				a.PaymentIdentifier)
+
+			continue
+
+		case err != nil:
+			return nil, err
+		}
+
+		// Hand the abandoned attempt to the lifecycle that may still
+		// be waiting for its result.
+		r.deliverAbandoned(attempt)
+
+		a.Failed = true
+		abandoned = append(abandoned, a)
+	}
+
+	return abandoned, nil
+}
+
+// filterInFlight returns the given attempts that are still in flight according
+// to the control tower.
+func (r *ChannelRouter) filterInFlight(
+	attempts []*StaleAttempt) ([]*StaleAttempt, error) {
+
+	// inFlight holds the IDs of the in-flight attempts of each payment,
+	// so every payment is only fetched once.
+	inFlight := make(map[lntypes.Hash]map[uint64]struct{})
+
+	filtered := make([]*StaleAttempt, 0, len(attempts))
+	for _, a := range attempts {
+		ids, ok := inFlight[a.PaymentIdentifier]
+		if !ok {
+			var err error
+			ids, err = r.fetchInFlightAttempts(a.PaymentIdentifier)
+			if err != nil {
+				return nil, err
+			}
+
+			inFlight[a.PaymentIdentifier] = ids
+		}
+
+		if _, ok := ids[a.AttemptID]; !ok {
+			log.Debugf("Attempt %v of payment %v was resolved "+
+				"while reconciling", a.AttemptID,
+				a.PaymentIdentifier)
+
+			continue
+		}
+
+		filtered = append(filtered, a)
+	}
+
+	return filtered, nil
+}
+
+// fetchInFlightAttempts returns the IDs of the attempts of the given payment
+// that are currently in flight. A payment that was deleted has none.
+func (r *ChannelRouter) fetchInFlightAttempts(
+	paymentID lntypes.Hash) (map[uint64]struct{}, error) {
+
+	ids := make(map[uint64]struct{})
+
+	payment, err := r.cfg.Control.FetchPayment(paymentID)
+	switch {
+	case errors.Is(err, channeldb.ErrPaymentNotInitiated):
+		return ids, nil
+
+	case err != nil:
+		return nil, err
+	}
+
+	for _, a := range payment.InFlightHTLCs() {
+		ids[a.AttemptID] = struct{}{}
+	}
+
+	return ids, nil
+}
+
+// findStaleAttempts returns the in-flight attempts of the given payment that
+// have neither a circuit nor a result in the switch.
+//
+// NOTE: The payment must be read before this method is called. An attempt is
+// marked as dispatching before it's registered with the control tower, and
+// the marker is only removed once the switch committed its circuit or the
+// attempt was failed. So an attempt that is read from the control tower
+// either still has its marker, or is known to the switch unless it's stale.
+func (r *ChannelRouter) findStaleAttempts(
+	payment *channeldb.MPPayment) ([]*StaleAttempt, error) {
+
+	lookup := r.cfg.AttemptLookup
+	if lookup == nil {
+		return nil, ErrNoAttemptLookup
+	}
+
+	paymentID := payment.Info.PaymentIdentifier
+
+	var stale []*StaleAttempt
+	for _, a := range payment.InFlightHTLCs() {
+		// Skip attempts that haven't been handed to the switch yet.
+		if _, ok := r.dispatchingAttempts.Load(a.AttemptID); ok {
+			continue
+		}
+
+		// The switch stores the result of an attempt before it removes
+		// its circuit, so we check for the circuit first to not miss
+		// an attempt that resolves in between the two lookups.
+		if lookup.HasAttemptCircuit(a.AttemptID) {
+			continue
+		}
+
+		hasResult, err := lookup.HasAttemptResult(a.AttemptID)
+		if err != nil {
+			return nil, err
+		}
+
+		// If a result is available, the payment lifecycle will pick it
+		// up.
+		if hasResult {
+			continue
+		}
+
+		stale = append(stale, &StaleAttempt{
+			PaymentIdentifier: paymentID,
+			AttemptID:         a.AttemptID,
+			AttemptTime:       a.AttemptTime,
+		})
+	}
+
+	return stale, nil
+}
+
+// subscribeAbandoned returns a channel that receives the given attempt if it
+// is abandoned as stale. It must be called before the result of the attempt
+// is requested from the switch, so an attempt abandoned after its circuit
+// was looked up can't be missed.
+func (r *ChannelRouter) subscribeAbandoned(
+	attemptID uint64) <-chan *channeldb.HTLCAttempt {
+
+	abandoned := make(chan *channeldb.HTLCAttempt, 1)
+	r.abandonedAttempts.Store(attemptID, abandoned)
+
+	return abandoned
+}
+
+// unsubscribeAbandoned removes the subscription for the given attempt.
+func (r *ChannelRouter) unsubscribeAbandoned(attemptID uint64) {
+	r.abandonedAttempts.Delete(attemptID)
+}
+
+// deliverAbandoned sends the abandoned attempt to the lifecycle waiting for
+// its result, if any.
+func (r *ChannelRouter) deliverAbandoned(attempt *channeldb.HTLCAttempt) {
+	abandoned, ok := r.abandonedAttempts.LoadAndDelete(attempt.AttemptID)
+	if !ok {
+		return
+	}
+
+	abandoned <- attempt
+}
@@ -0,0 +1,359 @@
+package routing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/lntypes"
+	"github.com/stretchr/testify/require"
+)
+
+// TestReconcileStaleAttempts checks that only dispatched in-flight attempts
+// that have neither a circuit nor a result in the switch are reported as
+// stale, and that they're failed if requested.
+func TestReconcileStaleAttempts(t *testing.T) {
+	t.Parallel()
+
+	var (
+		attemptTime = time.Unix(1000, 0)
+		paymentHash = lntypes.Hash{1, 2, 3}
+	)
+
+	// Create a payment with four in-flight attempts: one with a circuit,
+	// one with a stored result, one the switch doesn't know about and one
+	// that is still being dispatched. A failed attempt isn't in flight
+	// anymore.
+	newAttempt := func(id uint64) channeldb.HTLCAttempt {
+		return channeldb.HTLCAttempt{
+			HTLCAttemptInfo: channeldb.HTLCAttemptInfo{
+				AttemptID:   id,
+				AttemptTime: attemptTime,
+			},
+		}
+	}
+	failed := newAttempt(5)
+	failed.Failure = &channeldb.HTLCFailInfo{}
+
+	payment := &channeldb.MPPayment{
+		Info: &channeldb.PaymentCreationInfo{
+			PaymentIdentifier: paymentHash,
+		},
+		HTLCs: []channeldb.HTLCAttempt{
+			newAttempt(1),
+			newAttempt(2),
+			newAttempt(3),
+			newAttempt(4),
+			failed,
+		},
+	}
+
+	testCases := []struct {
+		name      string
+		failStale bool
+	}{
+		{
+			name:      "report only",
+			failStale: false,
+		},
+		{
+			name:      "fail stale",
+			failStale: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			control := &mockControlTower{}
+			lookup := &mockAttemptLookup{}
+
+			r := &ChannelRouter{
+				cfg: &Config{
+					Control:       control,
+					AttemptLookup: lookup,
+				},
+			}
+
+			control.On("ForEachInFlightPayment").Return(
+				[]*channeldb.MPPayment{payment}, nil,
+			)
+
+			// Attempt 4 was registered, but hasn't been handed to
+			// the switch yet.
+			r.dispatchingAttempts.Store(4, struct{}{})
+
+			// Attempt 1 still has a circuit, so the switch is
+			// waiting for its result.
+			lookup.On("HasAttemptCircuit", uint64(1)).Return(true)
+
+			// Attempt 2 has no circuit anymore, but its result is
+			// stored.
+			lookup.On("HasAttemptCircuit", uint64(2)).Return(false)
+			lookup.On("HasAttemptResult", uint64(2)).Return(
+				true, nil,
+			)
+
+			// Attempt 3 is unknown to the switch.
+			lookup.On("HasAttemptCircuit", uint64(3)).Return(false)
+			lookup.On("HasAttemptResult", uint64(3)).Return(
+				false, nil,
+			)
+
+			if tc.failStale {
+				control.On(
+					"AbandonAttempt", paymentHash,
+					uint64(3),
+				).Return(&channeldb.HTLCAttempt{}, nil)
+			} else {
+				// The reported attempts are checked to still
+				// be in flight.
+				current := &mockMPPayment{}
+				current.On("InFlightHTLCs").Return(
+					payment.InFlightHTLCs(),
+				)
+				control.On("FetchPayment", paymentHash).Return(
+					current, nil,
+				)
+			}
+
+			stale, err := r.ReconcileStaleAttempts(
+				context.Background(), tc.failStale,
+			)
+			require.NoError(t, err)
+
+			require.Equal(t, []*StaleAttempt{{
+				PaymentIdentifier: paymentHash,
+				AttemptID:         3,
+				AttemptTime:       attemptTime,
+				Failed:            tc.failStale,
+			}}, stale)
+
+			// The dispatching attempt must not have been looked
+			// up.
+			lookup.AssertNotCalled(
+				t, "HasAttemptCircuit", uint64(4),
+			)
+
+			control.AssertExpectations(t)
+			lookup.AssertExpectations(t)
+		})
+	}
+}
+
+// TestReconcileStaleAttemptsNoLookup checks that an error is returned when no
+// attempt lookup is configured.
+func TestReconcileStaleAttemptsNoLookup(t *testing.T) {
+	t.Parallel()
+
+	control := &mockControlTower{}
+	control.On("ForEachInFlightPayment").Return(
+		[]*channeldb.MPPayment{{
+			Info: &channeldb.PaymentCreationInfo{},
+			HTLCs: []channeldb.HTLCAttempt{{
+				HTLCAttemptInfo: channeldb.HTLCAttemptInfo{
+					AttemptID: 1,
+				},
+			}},
+		}}, nil,
+	)
+
+	r := &ChannelRouter{
+		cfg: &Config{
+			Control: control,
+		},
+	}
+
+	_, err := r.ReconcileStaleAttempts(context.Background(), false)
+	require.ErrorIs(t, err, ErrNoAttemptLookup)
+}
+
+// TestReconcileStaleAttemptsResolved checks that an attempt that is resolved
+// by the switch while it's being reconciled isn't reported as stale.
+func TestReconcileStaleAttemptsResolved(t *testing.T) {
+	t.Parallel()
+
+	paymentHash := lntypes.Hash{1, 2, 3}
+
+	control := &mockControlTower{}
+	lookup := &mockAttemptLookup{}
+
+	r := &ChannelRouter{
+		cfg: &Config{
+			Control:       control,
+			AttemptLookup: lookup,
+		},
+	}
+
+	control.On("ForEachInFlightPayment").Return(
+		[]*channeldb.MPPayment{{
+			Info: &channeldb.PaymentCreationInfo{
+				PaymentIdentifier: paymentHash,
+			},
+			HTLCs: []channeldb.HTLCAttempt{{
+				HTLCAttemptInfo: channeldb.HTLCAttemptInfo{
+					AttemptID: 1,
+				},
+			}},
+		}}, nil,
+	)
+	lookup.On("HasAttemptCircuit", uint64(1)).Return(false)
+	lookup.On("HasAttemptResult", uint64(1)).Return(false, nil)
+
+	// The attempt was failed after the payment was read.
+	control.On("AbandonAttempt", paymentHash, uint64(1)).Return(
+		nil, channeldb.ErrAttemptAlreadyFailed,
+	)
+
+	stale, err := r.ReconcileStaleAttempts(context.Background(), true)
+	require.NoError(t, err)
+	require.Empty(t, stale)
+
+	control.AssertExpectations(t)
+	lookup.AssertExpectations(t)
+}
+
+// TestReconcileStaleAttemptsReportResolved checks that attempts that are
+// resolved after their payment was read aren't reported as stale, even if they
+// aren't failed.
+func TestReconcileStaleAttemptsReportResolved(t *testing.T) {
+	t.Parallel()
+
+	var (
+		paymentHash = lntypes.Hash{1, 2, 3}
+		deletedHash = lntypes.Hash{4, 5, 6}
+	)
+
+	newAttempt := func(id uint64) channeldb.HTLCAttempt {
+		return channeldb.HTLCAttempt{
+			HTLCAttemptInfo: channeldb.HTLCAttemptInfo{
+				AttemptID: id,
+			},
+		}
+	}
+
+	control := &mockControlTower{}
+	lookup := &mockAttemptLookup{}
+
+	r := &ChannelRouter{
+		cfg: &Config{
+			Control:       control,
+			AttemptLookup: lookup,
+		},
+	}
+
+	// Both attempts of the first payment and the attempt of the second
+	// payment are in flight when the payments are read. None of them is
+	// known to the switch.
+	control.On("ForEachInFlightPayment").Return(
+		[]*channeldb.MPPayment{{
+			Info: &channeldb.PaymentCreationInfo{
+				PaymentIdentifier: paymentHash,
+			},
+			HTLCs: []channeldb.HTLCAttempt{
+				newAttempt(1), newAttempt(2),
+			},
+		}, {
+			Info: &channeldb.PaymentCreationInfo{
+				PaymentIdentifier: deletedHash,
+			},
+			HTLCs: []channeldb.HTLCAttempt{newAttempt(3)},
+		}}, nil,
+	)
+	for id := uint64(1); id <= 3; id++ {
+		lookup.On("HasAttemptCircuit", id).Return(false)
+		lookup.On("HasAttemptResult", id).Return(false, nil)
+	}
+
+	// Attempt 1 failed locally after the payment was read and before its
+	// dispatch marker was looked up, so only attempt 2 is still in
+	// flight.
+	current := &mockMPPayment{}
+	current.On("InFlightHTLCs").Return(
+		[]channeldb.HTLCAttempt{newAttempt(2)},
+	)
+	control.On("FetchPayment", paymentHash).Return(current, nil).Once()
+
+	// The second payment was deleted in the meantime.
+	control.On("FetchPayment", deletedHash).Return(
+		nil, channeldb.ErrPaymentNotInitiated,
+	).Once()
+
+	stale, err := r.ReconcileStaleAttempts(context.Background(), false)
+	require.NoError(t, err)
+	require.Equal(t, []*StaleAttempt{{
+		PaymentIdentifier: paymentHash,
+		AttemptID:         2,
+	}}, stale)
+
+	control.AssertExpectations(t)
+	lookup.AssertExpectations(t)
+	current.AssertExpectations(t)
+}
+
+// TestReconcileStaleAttemptsDeliver checks that an abandoned attempt is
+// delivered to the lifecycle waiting for its result.
+func TestReconcileStaleAttemptsDeliver(t *testing.T) {
+	t.Parallel()
+
+	paymentHash := lntypes.Hash{1, 2, 3}
+
+	control := &mockControlTower{}
+	lookup := &mockAttemptLookup{}
+
+	r := &ChannelRouter{
+		cfg: &Config{
+			Control:       control,
+			AttemptLookup: lookup,
+		},
+	}
+
+	control.On("ForEachInFlightPayment").Return(
+		[]*channeldb.MPPayment{{
+			Info: &channeldb.PaymentCreationInfo{
+				PaymentIdentifier: paymentHash,
+			},
+			HTLCs: []channeldb.HTLCAttempt{{
+				HTLCAttemptInfo: channeldb.HTLCAttemptInfo{
+					AttemptID: 1,
+				},
+			}},
+		}}, nil,
+	)
+	lookup.On("HasAttemptCircuit", uint64(1)).Return(false)
+	lookup.On("HasAttemptResult", uint64(1)).Return(false, nil)
+
+	abandonedAttempt := &channeldb.HTLCAttempt{
+		HTLCAttemptInfo: channeldb.HTLCAttemptInfo{
+			AttemptID: 1,
+		},
+		Failure: &channeldb.HTLCFailInfo{
+			Reason: channeldb.HTLCFailAbandoned,
+		},
+	}
+	control.On("AbandonAttempt", paymentHash, uint64(1)).Return(
+		abandonedAttempt, nil,
+	)
+
+	// Subscribe like a lifecycle collecting the attempt's result does.
+	abandoned := r.subscribeAbandoned(1)
+
+	stale, err := r.ReconcileStaleAttempts(context.Background(), true)
+	require.NoError(t, err)
+	require.Len(t, stale, 1)
+
+	select {
+	case attempt := <-abandoned:
+		require.Equal(t, abandonedAttempt, attempt)
+
+	default:
+		require.Fail(t, "abandoned attempt not delivered")
+	}
+
+	control.AssertExpectations(t)
+	lookup.AssertExpectations(t)
+}
@@ -465,6 +465,10 @@ func MainRPCServerPermissions() map[string][]bakery.Op {
 			Entity: "offchain",
 			Action: "read",
 		}},
+		"/lnrpc.Lightning/CountPayments": {{
+			Entity: "offchain",
+			Action: "read",
+		}},
 		"/lnrpc.Lightning/DeletePayment": {{
 			Entity: "offchain",
 			Action: "write",
@@ -740,6 +744,14 @@ func (r *rpcServer) addDeps(s *server, macService *macaroons.Service,
 		},
 		SetChannelAuto:     s.chanStatusMgr.RequestAuto,
 		UseStatusInitiated: subServerCgs.RouterRPC.UseStatusInitiated,
+		FetchPaymentLifecycleState: func(hash lntypes.Hash) (
+			*routing.PaymentLifecycleState, error) {
+
+			return s.chanRouter.GetPaymentLifecycleState(hash)
+		},
+		FetchPaymentBySeqNum:     s.miscDB.FetchPaymentBySeqNum,
+		LookupPaymentByReference: s.miscDB.LookupPaymentByReference,
+		ReconcileStaleAttempts:   s.chanRouter.ReconcileStaleAttempts,
 	}
 
 	genInvoiceFeatures := func() *lnwire.FeatureVector {
@@ -3158,8 +3170,8 @@ func (r *rpcServer) GetInfo(_ context.Context,
 }
 
 // GetDebugInfo returns debug information concerning the state of the daemon
-// and its subsystems. This includes the full configuration and the latest log
-// entries from the log file.
+// and its subsystems. This includes the full configuration, the options of the
+// payments database and the latest log entries from the log file.
 func (r *rpcServer) GetDebugInfo(_ context.Context,
 	_ *lnrpc.GetDebugInfoRequest) (*lnrpc.GetDebugInfoResponse, error) {
 
@@ -3176,9 +3188,16 @@ func (r *rpcServer) GetDebugInfo(_ context.Context,
 			logFileName, err)
 	}
 
+	// Report the resolved options of the payments store, together with
+	// the backend it runs on.
+	paymentsCfg := r.server.miscDB.PaymentsStoreConfig()
+	paymentsDB := paymentsCfg.ToMap()
+	paymentsDB["backend"] = r.cfg.DB.Backend
+
 	return &lnrpc.GetDebugInfoResponse{
-		Config: flatConfig,
-		Log:    strings.Split(string(logContent), "\n"),
+		Config:     flatConfig,
+		Log:        strings.Split(string(logContent), "\n"),
+		PaymentsDb: paymentsDB,
 	}, nil
 }
 
@@ -6758,16 +6777,35 @@ func (r *rpcServer) ListPayments(ctx context.Context,
 		CountTotal:        req.CountTotalPayments,
 		CreationDateStart: int64(req.CreationDateStart),
 		CreationDateEnd:   int64(req.CreationDateEnd),
+		SkipHops:          req.SkipHops || req.SkipHtlcs,
+	}
+
+	// Restrict the query to a payment address, if requested.
+	if len(req.PaymentAddr) > 0 {
+		if len(req.PaymentAddr) != 32 {
+			return nil, fmt.Errorf("payment address must be 32 "+
+				"bytes, got %d", len(req.PaymentAddr))
+		}
+
+		query.PaymentAddr = &[32]byte{}
+		copy(query.PaymentAddr[:], req.PaymentAddr)
 	}
 
 	// If the maximum number of payments wasn't specified, then we'll
-	// default to return the maximal number of payments representable.
+	// return as many payments as the database allows per query. If more
+	// payments match, HasMore is set in the response.
 	if req.MaxPayments == 0 {
 		query.MaxPayments = math.MaxUint64
 	}
 
-	paymentsQuerySlice, err := r.server.miscDB.QueryPayments(query)
-	if err != nil {
+	paymentsQuerySlice, err := r.server.miscDB.QueryPayments(ctx, query)
+	switch {
+	// If the client went away while the payments were being scanned, we
+	// let it know that the call was canceled.
+	case errors.Is(err, context.Canceled):
+		return nil, status.Error(codes.Canceled, err.Error())
+
+	case err != nil:
 		return nil, err
 	}
 
@@ -6775,6 +6813,7 @@ func (r *rpcServer) ListPayments(ctx context.Context,
 		LastIndexOffset:  paymentsQuerySlice.LastIndexOffset,
 		FirstIndexOffset: paymentsQuerySlice.FirstIndexOffset,
 		TotalNumPayments: paymentsQuerySlice.TotalCount,
+		HasMore:          paymentsQuerySlice.HasMore,
 	}
 
 	for _, payment := range paymentsQuerySlice.Payments {
@@ -6785,6 +6824,23 @@ func (r *rpcServer) ListPayments(ctx context.Context,
 			return nil, err
 		}
 
+		// The fee and preimage were derived from the htlcs, which
+		// can now be dropped if the client doesn't need them.
+		if req.SkipHtlcs {
+			rpcPayment.Htlcs = nil
+		}
+
+		// Without the intermediate hops, the failure source index of
+		// an htlc can't be resolved to the node that generated the
+		// failure, so we don't report a possibly wrong one.
+		if req.SkipHops {
+			for _, htlc := range rpcPayment.Htlcs {
+				if htlc.Failure != nil {
+					htlc.Failure.FailureSourcePubKey = nil
+				}
+			}
+		}
+
 		paymentsResp.Payments = append(
 			paymentsResp.Payments, rpcPayment,
 		)
@@ -6793,9 +6849,47 @@ func (r *rpcServer) ListPayments(ctx context.Context,
 	return paymentsResp, nil
 }
 
+// CountPayments returns the number of outgoing payments per status, optionally
+// restricted to the payments created within a date range.
+func (r *rpcServer) CountPayments(ctx context.Context,
+	req *lnrpc.CountPaymentsRequest) (*lnrpc.CountPaymentsResponse, error) {
+
+	// If both dates are set, we check that the start date is less than the
+	// end date, otherwise we'll get an empty result.
+	if req.CreationDateStart != 0 && req.CreationDateEnd != 0 {
+		if req.CreationDateStart >= req.CreationDateEnd {
+			return nil, fmt.Errorf("start date(%v) must be before "+
+				"end date(%v)", req.CreationDateStart,
+				req.CreationDateEnd)
+		}
+	}
+
+	stats, err := r.server.miscDB.FetchPaymentStats(
+		ctx, int64(req.CreationDateStart), int64(req.CreationDateEnd),
+	)
+	switch {
+	// If the client went away while the payments were being counted, we
+	// let it know that the call was canceled.
+	case errors.Is(err, context.Canceled):
+		return nil, status.Error(codes.Canceled, err.Error())
+
+	case err != nil:
+		return nil, err
+	}
+
+	return &lnrpc.CountPaymentsResponse{
+		NumInitiated:     stats.Initiated,
+		NumInFlight:      stats.InFlight,
+		NumSucceeded:     stats.Succeeded,
+		NumFailed:        stats.Failed,
+		TotalNumPayments: stats.Total(),
+	}, nil
+}
+
 // DeletePayment deletes a payment from the DB given its payment hash. If
 // failedHtlcsOnly is set, only failed HTLC attempts of the payment will be
-// deleted.
+// deleted. Clients tracking a deleted payment receive a final update with the
+// deleted status.
 func (r *rpcServer) DeletePayment(ctx context.Context,
 	req *lnrpc.DeletePaymentRequest) (
 	*lnrpc.DeletePaymentResponse, error) {
@@ -6808,12 +6902,29 @@ func (r *rpcServer) DeletePayment(ctx context.Context,
 	rpcsLog.Infof("[DeletePayment] payment_identifier=%v, "+
 		"failed_htlcs_only=%v", hash, req.FailedHtlcsOnly)
 
-	err = r.server.miscDB.DeletePayment(hash, req.FailedHtlcsOnly)
+	deleted, err := r.server.controlTower.DeletePayment(
+		hash, req.FailedHtlcsOnly,
+	)
 	if err != nil {
 		return nil, err
 	}
 
-	return &lnrpc.DeletePaymentResponse{}, nil
+	priorStatus, err := routerrpc.MarshallPaymentStatus(deleted.Status)
+	if err != nil {
+		return nil, err
+	}
+
+	// The payment itself is kept if only its failed htlcs were deleted.
+	var numPaymentsDeleted uint64
+	if !req.FailedHtlcsOnly {
+		numPaymentsDeleted = 1
+	}
+
+	return &lnrpc.DeletePaymentResponse{
+		NumPaymentsDeleted: numPaymentsDeleted,
+		NumHtlcsDeleted:    uint64(deleted.NumAttempts),
+		PriorStatus:        priorStatus,
+	}, nil
 }
 
 // DeleteAllPayments deletes all outgoing payments from DB.
@@ -6846,14 +6957,27 @@ func (r *rpcServer) DeleteAllPayments(ctx context.Context,
 		"failed_htlcs_only=%v", req.FailedPaymentsOnly,
 		req.FailedHtlcsOnly)
 
-	err := r.server.miscDB.DeletePayments(
+	numAltered, numAttempts, err := r.server.controlTower.DeletePayments(
 		req.FailedPaymentsOnly, req.FailedHtlcsOnly,
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	return &lnrpc.DeleteAllPaymentsResponse{}, nil
+	rpcsLog.Infof("[DeleteAllPayments] altered %d payments, removed %d "+
+		"htlc attempts", numAltered, numAttempts)
+
+	// The payments themselves are kept if only their failed htlcs were
+	// deleted.
+	numPaymentsDeleted := uint64(numAltered)
+	if req.FailedHtlcsOnly {
+		numPaymentsDeleted = 0
+	}
+
+	return &lnrpc.DeleteAllPaymentsResponse{
+		NumPaymentsDeleted: numPaymentsDeleted,
+		NumHtlcsDeleted:    uint64(numAttempts),
+	}, nil
 }
 
 // DebugLevel allows a caller to programmatically set the logging verbosity of
@@ -274,6 +274,12 @@ type server struct {
 
 	controlTower routing.ControlTower
 
+	paymentControl *channeldb.PaymentControl
+
+	// shadowPayments replicates the payment writes to the shadow payments
+	// database. It's nil if no shadow database is used.
+	shadowPayments *channeldb.ShadowPaymentControl
+
 	authGossiper *discovery.AuthenticatedGossiper
 
 	localChanMgr *localchans.Manager
@@ -681,6 +687,13 @@ func newServer(cfg *Config, listenAddrs []net.Addr,
 	if err != nil {
 		return nil, err
 	}
+	holdPolicy, err := routerrpc.GetInterceptorHoldPolicy(
+		cfg.SubRPCServers.RouterRPC,
+	)
+	if err != nil {
+		return nil, err
+	}
+
 	s.interceptableSwitch, err = htlcswitch.NewInterceptableSwitch(
 		&htlcswitch.InterceptableSwitchConfig{
 			Switch:             s.htlcSwitch,
@@ -688,6 +701,7 @@ func newServer(cfg *Config, listenAddrs []net.Addr,
 			CltvInterceptDelta: lncfg.DefaultCltvInterceptDelta,
 			RequireInterceptor: s.cfg.RequireInterceptor,
 			Notifier:           s.cc.ChainNotifier,
+			HoldPolicy:         holdPolicy,
 		},
 	)
 	if err != nil {
@@ -696,7 +710,7 @@ func newServer(cfg *Config, listenAddrs []net.Addr,
 
 	s.witnessBeacon = newPreimageBeacon(
 		dbs.ChanStateDB.NewWitnessCache(),
-		s.interceptableSwitch.ForwardPacket,
+		s.interceptableSwitch.ForwardPacket, s.aliasMgr.GetAliases,
 	)
 
 	chanStatusMgrCfg := &netann.ChanStatusConfig{
@@ -866,13 +880,6 @@ func newServer(cfg *Config, listenAddrs []net.Addr,
 	}
 	s.currentNodeAnn = nodeAnn
 
-	// The router will get access to the payment ID sequencer, such that it
-	// can generate unique payment IDs.
-	sequencer, err := htlcswitch.NewPersistentSequencer(dbs.ChanStateDB)
-	if err != nil {
-		return nil, err
-	}
-
 	// Instantiate mission control with config from the sub server.
 	//
 	// TODO(joostjager): When we are further in the process of moving to sub
@@ -964,8 +971,21 @@ func newServer(cfg *Config, listenAddrs []net.Addr,
 	}
 
 	paymentControl := channeldb.NewPaymentControl(dbs.ChanStateDB)
+	s.paymentControl = paymentControl
+
+	// If a shadow payments database is used, all payment writes are
+	// replicated to it while reads are still served by the channel DB.
+	var paymentStore channeldb.PaymentStore = paymentControl
+	if dbs.ShadowPaymentsDB != nil {
+		s.shadowPayments = channeldb.NewShadowPaymentControl(
+			paymentControl,
+			channeldb.NewPaymentControl(dbs.ShadowPaymentsDB),
+			channeldb.DefaultShadowQueueSize,
+		)
+		paymentStore = s.shadowPayments
+	}
 
-	s.controlTower = routing.NewControlTower(paymentControl)
+	s.controlTower = routing.NewControlTower(paymentStore)
 
 	strictPruning := (cfg.Bitcoin.Node == "neutrino" ||
 		cfg.Routing.StrictZombiePruning)
@@ -983,11 +1003,16 @@ func newServer(cfg *Config, listenAddrs []net.Addr,
 		FirstTimePruneDelay: routing.DefaultFirstTimePruneDelay,
 		GetLink:             s.htlcSwitch.GetLinkByShortID,
 		AssumeChannelValid:  cfg.Routing.AssumeChannelValid,
-		NextPaymentID:       sequencer.NextID,
+		NextPaymentID: func() (uint64, error) {
+			return paymentControl.NextAttemptID(context.TODO())
+		},
 		PathFindingConfig:   pathFindingConfig,
 		Clock:               clock.NewDefaultClock(),
 		StrictZombiePruning: strictPruning,
 		IsAlias:             aliasmgr.IsAlias,
+		AttemptLookup:       s.htlcSwitch,
+		CheckStaleAttempts:  cfg.Routing.CheckStaleAttempts,
+		FeeEstimator:        cc.FeeEstimator,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("can't create router: %w", err)
@@ -2013,6 +2038,16 @@ func (s *server) Start() error {
 		}
 		cleanup = cleanup.add(s.authGossiper.Stop)
 
+		// The shadow payments store must replicate the writes of
+		// payments resumed by the router.
+		if s.shadowPayments != nil {
+			if err := s.shadowPayments.Start(); err != nil {
+				startErr = err
+				return
+			}
+			cleanup = cleanup.add(s.shadowPayments.Stop)
+		}
+
 		if err := s.chanRouter.Start(); err != nil {
 			startErr = err
 			return
@@ -2276,6 +2311,16 @@ func (s *server) Stop() error {
 		if err := s.chanRouter.Stop(); err != nil {
 			srvrLog.Warnf("failed to stop chanRouter: %v", err)
 		}
+		if s.shadowPayments != nil {
+			if err := s.shadowPayments.Stop(); err != nil {
+				srvrLog.Warnf("failed to stop shadow payments: "+
+					"%v", err)
+			}
+		}
+		if err := s.paymentControl.Close(); err != nil {
+			srvrLog.Warnf("failed to close paymentControl: %v",
+				err)
+		}
 		if err := s.chainArb.Stop(); err != nil {
 			srvrLog.Warnf("failed to stop chainArb: %v", err)
 		}
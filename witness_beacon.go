@@ -2,6 +2,7 @@ package lnd
 
 import (
 	"errors"
+	"fmt"
 	"sync"
 
 	"github.com/lightningnetwork/lnd/channeldb"
@@ -18,6 +19,20 @@ import (
 type preimageSubscriber struct {
 	updateChan chan lntypes.Preimage
 
+	// hash is the payment hash of the htlc that the subscriber is
+	// resolving.
+	hash lntypes.Hash
+
+	// circuitKey identifies the incoming htlc that the subscriber is
+	// resolving.
+	circuitKey models.CircuitKey
+
+	// abandonChan is closed when the htlc is requested to be abandoned.
+	abandonChan chan struct{}
+
+	// abandoned indicates whether abandonChan has already been closed.
+	abandoned bool
+
 	quit chan struct{}
 }
 
@@ -44,14 +59,21 @@ type preimageBeacon struct {
 	subscribers   map[uint64]*preimageSubscriber
 
 	interceptor func(htlcswitch.InterceptedForward) error
+
+	// getAliases returns the aliases of the channel with the given short
+	// channel ID, if any.
+	getAliases func(lnwire.ShortChannelID) []lnwire.ShortChannelID
 }
 
 func newPreimageBeacon(wCache witnessCache,
-	interceptor func(htlcswitch.InterceptedForward) error) *preimageBeacon {
+	interceptor func(htlcswitch.InterceptedForward) error,
+	getAliases func(lnwire.ShortChannelID) []lnwire.ShortChannelID,
+) *preimageBeacon {
 
 	return &preimageBeacon{
 		wCache:      wCache,
 		interceptor: interceptor,
+		getAliases:  getAliases,
 		subscribers: make(map[uint64]*preimageSubscriber),
 	}
 }
@@ -59,17 +81,25 @@ func newPreimageBeacon(wCache witnessCache,
 // SubscribeUpdates returns a channel that will be sent upon *each* time a new
 // preimage is discovered.
 func (p *preimageBeacon) SubscribeUpdates(
-	chanID lnwire.ShortChannelID, htlc *channeldb.HTLC,
+	chanInfo contractcourt.IncomingChanInfo, htlc *channeldb.HTLC,
 	payload *hop.Payload,
 	nextHopOnionBlob []byte) (*contractcourt.WitnessSubscription, error) {
 
 	p.Lock()
 	defer p.Unlock()
 
+	circuitKey := models.CircuitKey{
+		ChanID: chanInfo.ShortChanID,
+		HtlcID: htlc.HtlcIndex,
+	}
+
 	clientID := p.clientCounter
 	client := &preimageSubscriber{
-		updateChan: make(chan lntypes.Preimage, 10),
-		quit:       make(chan struct{}),
+		updateChan:  make(chan lntypes.Preimage, 10),
+		hash:        htlc.RHash,
+		circuitKey:  circuitKey,
+		abandonChan: make(chan struct{}),
+		quit:        make(chan struct{}),
 	}
 
 	p.subscribers[p.clientCounter] = client
@@ -80,7 +110,8 @@ func (p *preimageBeacon) SubscribeUpdates(
 		p.clientCounter)
 
 	sub := &contractcourt.WitnessSubscription{
-		WitnessUpdates: client.updateChan,
+		WitnessUpdates:  client.updateChan,
+		AbandonRequests: client.abandonChan,
 		CancelSubscription: func() {
 			p.Lock()
 			defer p.Unlock()
@@ -92,22 +123,31 @@ func (p *preimageBeacon) SubscribeUpdates(
 	}
 
 	// Notify the htlc interceptor. There may be a client connected
-	// and willing to supply a preimage.
+	// and willing to supply a preimage. The htlc is auto-failed at its
+	// expiry height, which is also the height at which the resolver stops
+	// waiting for the preimage.
 	packet := &htlcswitch.InterceptedPacket{
-		Hash:           htlc.RHash,
-		IncomingExpiry: htlc.RefundTimeout,
-		IncomingAmount: htlc.Amt,
-		IncomingCircuit: models.CircuitKey{
-			ChanID: chanID,
-			HtlcID: htlc.HtlcIndex,
-		},
-		OutgoingChanID: payload.FwdInfo.NextHop,
-		OutgoingExpiry: payload.FwdInfo.OutgoingCTLV,
-		OutgoingAmount: payload.FwdInfo.AmountToForward,
-		CustomRecords:  payload.CustomRecords(),
+		Hash:            htlc.RHash,
+		IncomingExpiry:  htlc.RefundTimeout,
+		IncomingAmount:  htlc.Amt,
+		IncomingCircuit: circuitKey,
+		OutgoingChanID:  payload.FwdInfo.NextHop,
+		OutgoingExpiry:  payload.FwdInfo.OutgoingCTLV,
+		OutgoingAmount:  payload.FwdInfo.AmountToForward,
+		CustomRecords:   payload.CustomRecords(),
+		AutoFailHeight:  int32(htlc.RefundTimeout),
+
+		IncomingPeer:          chanInfo.Peer,
+		IncomingConfirmedScid: chanInfo.ConfirmedScid,
 	}
 	copy(packet.OnionBlob[:], nextHopOnionBlob)
 
+	// The channel arbitrator doesn't know about the aliases of the
+	// channel, so we look them up ourselves.
+	if aliases := p.getAliases(chanInfo.ShortChanID); len(aliases) > 0 {
+		packet.IncomingAlias = aliases[0]
+	}
+
 	fwd := newInterceptedForward(packet, p)
 
 	err := p.interceptor(fwd)
@@ -126,6 +166,14 @@ func (p *preimageBeacon) LookupPreimage(
 	p.RLock()
 	defer p.RUnlock()
 
+	return p.lookupPreimage(payHash)
+}
+
+// lookupPreimage attempts to lookup a preimage in the global cache. The caller
+// must hold the beacon's lock.
+func (p *preimageBeacon) lookupPreimage(
+	payHash lntypes.Hash) (lntypes.Preimage, bool) {
+
 	// Otherwise, we'll perform a final check using the witness cache.
 	preimage, err := p.wCache.LookupSha256Witness(payHash)
 	if errors.Is(err, channeldb.ErrNoWitnesses) {
@@ -188,4 +236,49 @@ func (p *preimageBeacon) AddPreimages(preimages ...lntypes.Preimage) error {
 	return nil
 }
 
+// AbandonHtlc requests the resolver of the given htlc to stop waiting for its
+// preimage, because it's known that the htlc will never be settled.
+// ErrCannotFail is returned if the preimage is already known or if there is no
+// resolver waiting for the preimage of the htlc anymore.
+func (p *preimageBeacon) AbandonHtlc(hash lntypes.Hash,
+	circuitKey models.CircuitKey) error {
+
+	// Hold the lock for the whole operation, so that subscribers can't be
+	// cancelled in between the lookup and the abandon request. A preimage
+	// that is added concurrently is still picked up by the resolver, as it
+	// checks for the preimage once more before abandoning the htlc.
+	p.Lock()
+	defer p.Unlock()
+
+	if _, ok := p.lookupPreimage(hash); ok {
+		return fmt.Errorf("%w: preimage for %v already known",
+			ErrCannotFail, hash)
+	}
+
+	var found bool
+	for _, client := range p.subscribers {
+		if client.hash != hash || client.circuitKey != circuitKey {
+			continue
+		}
+
+		found = true
+		if client.abandoned {
+			continue
+		}
+
+		srvrLog.Infof("Abandoning htlc %v with hash %v", circuitKey,
+			hash)
+
+		close(client.abandonChan)
+		client.abandoned = true
+	}
+
+	if !found {
+		return fmt.Errorf("%w: htlc %v is no longer awaiting a "+
+			"preimage", ErrCannotFail, circuitKey)
+	}
+
+	return nil
+}
+
 var _ contractcourt.WitnessBeacon = (*preimageBeacon)(nil)
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/lightningnetwork/lnd/channeldb"
+	"github.com/lightningnetwork/lnd/contractcourt"
 	"github.com/lightningnetwork/lnd/htlcswitch"
 	"github.com/lightningnetwork/lnd/htlcswitch/hop"
 	"github.com/lightningnetwork/lnd/lntypes"
@@ -21,15 +22,29 @@ func TestWitnessBeaconIntercept(t *testing.T) {
 		return nil
 	}
 
+	chanInfo := contractcourt.IncomingChanInfo{
+		ShortChanID:   lnwire.NewShortChanIDFromInt(1),
+		Peer:          [33]byte{2},
+		ConfirmedScid: lnwire.NewShortChanIDFromInt(3),
+	}
+	alias := lnwire.NewShortChanIDFromInt(4)
+	getAliases := func(
+		scid lnwire.ShortChannelID) []lnwire.ShortChannelID {
+
+		require.Equal(t, chanInfo.ShortChanID, scid)
+
+		return []lnwire.ShortChannelID{alias}
+	}
+
 	p := newPreimageBeacon(
-		&mockWitnessCache{}, interceptor,
+		newMockWitnessCache(), interceptor, getAliases,
 	)
 
 	preimage := lntypes.Preimage{1, 2, 3}
 	hash := preimage.Hash()
 
 	subscription, err := p.SubscribeUpdates(
-		lnwire.NewShortChanIDFromInt(1),
+		chanInfo,
 		&channeldb.HTLC{
 			RHash: hash,
 		},
@@ -39,18 +54,120 @@ func TestWitnessBeaconIntercept(t *testing.T) {
 	require.NoError(t, err)
 	t.Cleanup(subscription.CancelSubscription)
 
+	// The interceptor learns about the peer and the channel that the htlc
+	// came in from.
+	packet := interceptedFwd.Packet()
+	require.Equal(t, chanInfo.ShortChanID, packet.IncomingCircuit.ChanID)
+	require.Equal(t, chanInfo.Peer, packet.IncomingPeer)
+	require.Equal(t, alias, packet.IncomingAlias)
+	require.Equal(t, chanInfo.ConfirmedScid, packet.IncomingConfirmedScid)
+
 	require.NoError(t, interceptedFwd.Settle(preimage))
 
 	update := <-subscription.WitnessUpdates
 	require.Equal(t, preimage, update)
 }
 
+// TestWitnessBeaconInterceptFail tests that failing an intercepted forward
+// abandons the htlc only if its preimage isn't known.
+func TestWitnessBeaconInterceptFail(t *testing.T) {
+	var interceptedFwd htlcswitch.InterceptedForward
+	interceptor := func(fwd htlcswitch.InterceptedForward) error {
+		interceptedFwd = fwd
+
+		return nil
+	}
+
+	cache := newMockWitnessCache()
+	noAliases := func(lnwire.ShortChannelID) []lnwire.ShortChannelID {
+		return nil
+	}
+	p := newPreimageBeacon(cache, interceptor, noAliases)
+
+	preimage := lntypes.Preimage{1, 2, 3}
+	hash := preimage.Hash()
+
+	subscribe := func() *contractcourt.WitnessSubscription {
+		subscription, err := p.SubscribeUpdates(
+			contractcourt.IncomingChanInfo{
+				ShortChanID: lnwire.NewShortChanIDFromInt(1),
+			},
+			&channeldb.HTLC{
+				RHash:         hash,
+				RefundTimeout: 100,
+			},
+			&hop.Payload{},
+			[]byte{2},
+		)
+		require.NoError(t, err)
+
+		return subscription
+	}
+
+	// The on-chain forward is auto-failed at the htlc's expiry.
+	subscription := subscribe()
+	require.EqualValues(t, 100, interceptedFwd.Packet().AutoFailHeight)
+
+	// Failing the forward while the preimage is unknown abandons the
+	// htlc.
+	require.NoError(t, interceptedFwd.FailWithCode(
+		lnwire.CodeTemporaryChannelFailure,
+	))
+
+	select {
+	case <-subscription.AbandonRequests:
+	default:
+		t.Fatal("expected abandon request")
+	}
+
+	// Once the resolver is done, the htlc can't be failed anymore.
+	subscription.CancelSubscription()
+	require.ErrorIs(t, interceptedFwd.Fail(nil), ErrCannotFail)
+
+	// Failing the forward isn't possible either if the preimage is
+	// already known.
+	subscription = subscribe()
+	t.Cleanup(subscription.CancelSubscription)
+
+	require.NoError(t, p.AddPreimages(preimage))
+	require.ErrorIs(t, interceptedFwd.Fail(nil), ErrCannotFail)
+
+	select {
+	case <-subscription.AbandonRequests:
+		t.Fatal("unexpected abandon request")
+	default:
+	}
+}
+
 type mockWitnessCache struct {
 	witnessCache
+
+	preimages map[lntypes.Hash]lntypes.Preimage
+}
+
+func newMockWitnessCache() *mockWitnessCache {
+	return &mockWitnessCache{
+		preimages: make(map[lntypes.Hash]lntypes.Preimage),
+	}
+}
+
+func (w *mockWitnessCache) LookupSha256Witness(
+	hash lntypes.Hash) (lntypes.Preimage, error) {
+
+	preimage, ok := w.preimages[hash]
+	if !ok {
+		return lntypes.Preimage{}, channeldb.ErrNoWitnesses
+	}
+
+	return preimage, nil
 }
 
 func (w *mockWitnessCache) AddSha256Witnesses(
 	preimages ...lntypes.Preimage) error {
 
+	for _, preimage := range preimages {
+		w.preimages[preimage.Hash()] = preimage
+	}
+
 	return nil
 }